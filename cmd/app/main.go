@@ -1,112 +1,231 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/ebipenman/go-otp-auth-service/config"
-	"github.com/ebipenman/go-otp-auth-service/internal/api"
 	"github.com/ebipenman/go-otp-auth-service/internal/database"
-	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
-	"github.com/ebipenman/go-otp-auth-service/pkg/auth"
-	"github.com/ebipenman/go-otp-auth-service/pkg/otp"
-	"github.com/ebipenman/go-otp-auth-service/pkg/user"
+	"github.com/ebipenman/go-otp-auth-service/internal/fieldcrypt"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/secrets"
+	"github.com/ebipenman/go-otp-auth-service/internal/seed"
+	"github.com/ebipenman/go-otp-auth-service/internal/tracing"
+	"github.com/ebipenman/go-otp-auth-service/pkg/server"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-
-	// Swagger docs (generated)
-	_ "github.com/ebipenman/go-otp-auth-service/docs"
-	swaggerFiles "github.com/swaggo/files"
-	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
-// @title OTP Auth GoLang API
-// @version 1.0
-// @description This is a sample server for OTP based authentication and user management.
-// @termsOfService http://swagger.io/terms/
-
-// @contact.name API Support
-// @contact.url http://www.swagger.io/support
-// @contact.email support@swagger.io
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		runSeed(os.Args[2:])
+		return
+	}
 
-// @license.name Apache 2.0
-// @license.url http://www.apache.org/licenses/LICENSE-2.0.html
+	cfg := config.LoadConfig(parseFlags())
 
-// @host localhost:8080
-// @BasePath /
-// @securityDefinitions.apikey BearerAuth
-// @in header
-// @name Authorization
-func main() {
-	cfg := config.LoadConfig()
+	// Structured logs; request handlers log through the request-scoped logger set up by RequestLogger.
+	logger, err := logging.New(os.Stdout, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		log.Fatalf("FATAL: invalid logging configuration: %v", err)
+	}
+	slog.SetDefault(logger)
+	logger.Info("Starting", "version", version, "env", cfg.Env)
 
-	// Declare variables for our stores using their INTERFACE types.
-	var userStore user.UserStore
-	var otpStore otp.OTPStore
+	// Gin's debug output (route listing, warnings) is only useful in development.
+	if cfg.Env != config.EnvDev {
+		gin.SetMode(gin.ReleaseMode)
+	}
 
-	// Decide which concrete implementation to create based on the config.
-	if cfg.StorageType == "postgres" {
-		log.Println("Initializing PostgreSQL database store...")
-		postgresStore, err := database.NewPostgresStore(cfg.DatabaseURL)
+	// Continue incoming W3C trace context, and export spans via OTLP when enabled.
+	tracing.InitPropagation()
+	if cfg.TracingEnabled {
+		logger.Info("Initializing OpenTelemetry tracing...")
+		shutdownTracing, err := tracing.Init(context.Background(), cfg.ServiceName)
 		if err != nil {
-			log.Fatalf("FATAL: could not connect to postgres database: %v", err)
+			fatal(logger, "could not initialize tracing", err)
 		}
-		// The single PostgresStore object implements BOTH interfaces.
-		userStore = postgresStore
-		otpStore = postgresStore
-	} else {
-		log.Println("Initializing in-memory database store...")
-		// For in-memory, we have separate store objects.
-		userStore = database.NewInMemoryUserStore()
-		otpStore = database.NewInMemoryOTPStore()
+		defer shutdownTracing(context.Background())
+	}
+
+	srv, err := server.New(cfg, server.WithLogger(logger))
+	if err != nil {
+		fatal(logger, "could not initialize the service", err)
 	}
+	if err := srv.Start(); err != nil {
+		fatal(logger, "could not start the service", err)
+	}
+
+	// Wait for SIGINT (Ctrl+C) or SIGTERM (docker stop, Kubernetes) before draining.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 
-	// NOTE: We now use the middleware's rate limiter, not the one from the database package
-	// as it contains the cleanup logic.
-	otpRateLimiter := middleware.NewInMemoryRateLimiter(3, 2*time.Minute)
+	// Rotated secrets only take effect on startup, so drain and exit for the orchestrator to
+	// restart the service with the new values.
+	if cfg.SecretsProvider != nil && cfg.SecretsRefreshInterval > 0 {
+		go secrets.Watch(ctx, cfg.SecretsProvider, cfg.SecretsRefreshInterval, cfg.Secrets, func(changed []string) {
+			logger.Warn("Secrets rotated, restarting to apply them", "provider", cfg.SecretsProvider.Name(), "secrets", changed)
+			stop()
+		})
+	}
 
-	// Initialize OTP components
-	otpGenerator := otp.NewSimpleOTPGenerator()
+	// SIGHUP re-reads the config file and applies the settings that are safe to change at
+	// runtime; everything else, including the in-memory OTPs, stays as it is.
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			rt, err := config.Reload()
+			if err != nil {
+				logger.Error("Config reload failed, keeping the running configuration", "error", err)
+				continue
+			}
+			srv.ApplyRuntime(rt)
+			logger.Info("Configuration reloaded", "log_level", rt.LogLevel, "sms_provider", rt.SMSProvider)
+		}
+	}()
+
+	// SIGUSR1 switches to debug logs for debugLogDuration, or back to the configured level if
+	// they are on already, e.g. kill -USR1 while investigating an incident.
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for range usr1 {
+			if status := logging.Level(); status.Overridden {
+				logging.ResetLevel()
+				logger.Warn("Log level reset by SIGUSR1", "level", status.Configured)
+				continue
+			}
+			status, _ := logging.OverrideLevel("debug", debugLogDuration)
+			logger.Warn("Debug logs turned on by SIGUSR1", "until", status.Until)
+		}
+	}()
 
-	// Initialize Repositories
-	userRepo := user.NewRepository(userStore)
-	otpRepo := otp.NewRepository(otpStore)
-	authRepo := auth.NewRepository(userRepo, otpRepo, otpRateLimiter)
+	<-ctx.Done()
+	stop()
+	signal.Stop(hangup)
+	signal.Stop(usr1)
 
-	// The auth service now correctly receives all its dependencies via the authRepo.
-	authService := auth.NewService(authRepo, otpGenerator, cfg.JWTSecret)
-	userService := user.NewService(userRepo)
+	logger.Info("Shutting down, draining in-flight requests...", "timeout", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Server did not shut down cleanly", "error", err)
+	}
+
+	logger.Info("Server stopped")
+}
 
-	// Initialize Handlers
-	authHandler := auth.NewHandler(authService)
-	userHandler := user.NewHandler(userService)
+// debugLogDuration is how long the debug logs turned on by SIGUSR1 last.
+const debugLogDuration = 30 * time.Minute
+
+// version is set at build time: go build -ldflags "-X main.version=1.2.3".
+var version = "dev"
+
+// parseFlags parses the command line and returns the settings it overrides, keyed by
+// their environment variable. --version and --help print and exit.
+func parseFlags() map[string]string {
+	port := flag.String("port", "", "port to listen on (overrides PORT)")
+	storage := flag.String("storage", "", `storage backend, "inmemory" or "postgres" (overrides STORAGE_TYPE)`)
+	configFile := flag.String("config", "", "YAML or TOML config file (overrides CONFIG_FILE)")
+	showVersion := flag.Bool("version", false, "print the version and exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s seed [flags]   fill the database with fake users\n\n", filepath.Base(os.Args[0]))
+		fmt.Fprintln(flag.CommandLine.Output(), "Settings are read from flags, the secrets manager, the environment, .env and the config file,")
+		fmt.Fprintln(flag.CommandLine.Output(), "in that order of precedence. See .env.example for every setting.")
+		fmt.Fprintln(flag.CommandLine.Output())
+		flag.PrintDefaults()
+	}
+	flag.Parse()
 
-	// Setup Gin router
-	router := gin.Default()
+	if *showVersion {
+		fmt.Println("go-otp-auth-service", version)
+		os.Exit(0)
+	}
+	if flag.NArg() > 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "unexpected argument %q\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
 
-	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}))
+	overrides := make(map[string]string)
+	for name, value := range map[string]string{"PORT": *port, "STORAGE_TYPE": *storage, "CONFIG_FILE": *configFile} {
+		if value != "" {
+			overrides[name] = value
+		}
+	}
+	return overrides
+}
 
-	// Global Middleware
-	router.Use(gin.Logger())
-	router.Use(gin.Recovery())
+// runSeed implements the seed subcommand, creating fake users (and pending OTPs) in the
+// configured database for load tests and demos.
+func runSeed(args []string) {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	users := flags.Int("users", 100, "number of fake users")
+	withOTPs := flags.Bool("otps", false, "also give every user a pending OTP")
+	prefix := flags.String("prefix", seed.DefaultPrefix, "start of the fake E.164 phone numbers, followed by a 7 digit counter")
+	configFile := flags.String("config", "", "YAML or TOML config file (overrides CONFIG_FILE)")
+	flags.Parse(args)
+
+	overrides := make(map[string]string)
+	if *configFile != "" {
+		overrides["CONFIG_FILE"] = *configFile
+	}
+	cfg := config.LoadConfig(overrides)
 
-	// The router setup function needs this to apply the rate limiting middleware
-	api.SetupRoutes(router, authHandler, userHandler, cfg.JWTSecret, otpRateLimiter)
+	logger, err := logging.New(os.Stdout, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		log.Fatalf("FATAL: invalid logging configuration: %v", err)
+	}
+	// In-memory data would be gone as soon as the command exits.
+	if cfg.StorageType != "postgres" {
+		fatal(logger, "seeding needs STORAGE_TYPE=postgres", fmt.Errorf("STORAGE_TYPE is '%s'", cfg.StorageType))
+	}
 
-	// Swagger documentation route
-	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	var phoneCipher *fieldcrypt.Cipher
+	if cfg.PhoneEncryptionKey != "" {
+		phoneCipher, err = fieldcrypt.New(cfg.PhoneEncryptionKey)
+		if err != nil {
+			fatal(logger, "invalid PHONE_ENCRYPTION_KEY", err)
+		}
+	}
+	retry := database.ConnectRetry{MaxWait: cfg.DBConnectMaxWait, BaseDelay: cfg.DBConnectRetryBaseDelay, MaxDelay: cfg.DBConnectRetryMaxDelay}
+	if err := database.WaitForPostgres(context.Background(), cfg.DatabaseURL, retry); err != nil {
+		fatal(logger, "could not connect to postgres database", err)
+	}
+	if cfg.DBAutoProvision {
+		if err := database.ProvisionPostgres(cfg.DatabaseURL); err != nil {
+			fatal(logger, "could not provision postgres database", err)
+		}
+	}
+	store, err := database.NewPostgresStore(cfg.DatabaseURL, phoneCipher)
+	if err != nil {
+		fatal(logger, "could not connect to postgres database", err)
+	}
+	defer store.Close()
 
-	log.Printf("Server starting on port %s", cfg.Port)
-	if err := router.Run(":" + cfg.Port); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	opts := seed.Options{Users: *users, Prefix: *prefix}
+	if *withOTPs {
+		opts.OTPTTL = time.Duration(cfg.OTPExpirationMinutes) * time.Minute
 	}
+	result, err := seed.Run(context.Background(), store, store, opts)
+	if err != nil {
+		fatal(logger, "seeding failed", err)
+	}
+	logger.Info("Seeded fake users", "created", result.Created, "skipped_existing", result.Skipped, "otps", result.OTPs)
+}
 
+// fatal logs the error and exits, the structured counterpart of log.Fatalf.
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error("FATAL: "+msg, "error", err)
+	os.Exit(1)
 }
@@ -1,50 +1,924 @@
 package config
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/phone"
+	"github.com/ebipenman/go-otp-auth-service/internal/secrets"
 
 	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
+)
+
+// Environments selected by APP_ENV. They switch defaults and how strictly the rest of
+// the configuration is checked.
+const (
+	EnvDev     = "dev"
+	EnvStaging = "staging"
+	EnvProd    = "prod"
 )
 
 type Config struct {
-	Port                 string
-	JWTSecret            string
+	// Env is the APP_ENV profile: EnvDev, EnvStaging or EnvProd.
+	Env  string
+	Port string
+	// BasePath (e.g. "/auth") is the path prefix every route, the Swagger UI and the links
+	// in responses are mounted under, for gateways routing by path; empty mounts them at the root.
+	BasePath             string
+	JWTSecret            string // signs new tokens
 	OTPExpirationMinutes int
+	// JWTSecrets lists every HS256 secret tokens are accepted with, starting with JWTSecret.
+	// Keeping the previous secret here rotates JWTSecret without logging every user out.
+	JWTSecrets []string
+	// JWTClockSkew is how far the exp, nbf and iat claims of tokens may be off.
+	JWTClockSkew time.Duration
+	// JWTRequiredClaims lists the registered claims ("exp", "iat", "nbf") tokens must carry.
+	JWTRequiredClaims []string
+	// JWTMaxTokenAge rejects tokens issued longer ago, even if they haven't expired; zero disables it.
+	JWTMaxTokenAge time.Duration
 	// ADD THESE TWO LINES
 	StorageType string // "inmemory" or "postgres"
 	DatabaseURL string
+	// DBAutoProvision creates the database and the pgcrypto extension on startup if missing.
+	DBAutoProvision bool
+	// DBConnectMaxWait is how long startup waits for the database to accept connections, e.g.
+	// while it's still starting, retrying from DBConnectRetryBaseDelay, doubling up to
+	// DBConnectRetryMaxDelay. Zero gives up after the first attempt.
+	DBConnectMaxWait        time.Duration
+	DBConnectRetryBaseDelay time.Duration
+	DBConnectRetryMaxDelay  time.Duration
+	// MemorySnapshotFile, if set, keeps the in-memory users and OTPs across restarts by saving
+	// them there every MemorySnapshotInterval and on shutdown.
+	MemorySnapshotFile     string
+	MemorySnapshotInterval time.Duration
+	// OTPStoreMaxEntries caps the in-memory OTP store; the least recently used OTPs are
+	// evicted beyond it, so unauthenticated sends can't exhaust memory. 0 disables the cap.
+	OTPStoreMaxEntries int
+	// PhoneEncryptionKey (base64, 32 bytes) encrypts the phone numbers in the users table.
+	PhoneEncryptionKey string
+	// PhoneDefaultRegion (e.g. "DE") is the region phone numbers without a country code
+	// belong to. When empty, numbers must be given in international format.
+	PhoneDefaultRegion string
+	// OTPAllowedCountryCodes, if not empty, are the only calling codes (e.g. 98, 1) OTPs are
+	// sent to; OTPBlockedCountryCodes are never sent OTPs. Both keep SMS costs and abuse in check.
+	OTPAllowedCountryCodes []int
+	OTPBlockedCountryCodes []int
+
+	// Optional carrier SIM swap check before OTPs are sent; no URL disables it. OTPs to numbers
+	// whose SIM changed within SIMSwapMaxAge are challenged or denied, as SIMSwapAction says.
+	SIMSwapAPIURL   string
+	SIMSwapAPIToken string
+	SIMSwapMaxAge   time.Duration
+	SIMSwapAction   string // "challenge" or "deny"
+
+	// SecretsProvider is the secrets manager the secrets were loaded from (nil when none is
+	// configured), Secrets the values it returned. The process restarts itself when they are
+	// rotated; SecretsRefreshInterval sets how often they are checked (zero disables checking).
+	SecretsProvider        secrets.Provider
+	Secrets                map[string]string
+	SecretsRefreshInterval time.Duration
+
+	// TrustedProxies lists the proxy IPs/CIDRs allowed to set X-Forwarded-For.
+	// When empty, the client IP is always taken from the connection itself.
+	TrustedProxies []string
+
+	// CORS is disabled unless origins are listed; "*" allows any origin but cannot be
+	// combined with credentials.
+	CORSAllowedOrigins   []string
+	CORSAllowCredentials bool
+
+	// ContentSecurityPolicy is sent on every response except the Swagger UI; empty disables it.
+	ContentSecurityPolicy string
+	// HSTSMaxAge is announced on HTTPS responses; zero disables HSTS.
+	HSTSMaxAge time.Duration
+
+	RateLimitBackend string // "inmemory" or "redis"
+	RedisURL         string
+	// RateLimitCleanupInterval is how often the in-memory limiters drop stale entries.
+	RateLimitCleanupInterval time.Duration
+
+	OTPSendRateLimit         RateLimit
+	OTPChannelSendRateLimits map[string]RateLimit // by OTP channel, see Runtime
+	OTPVerifyRateLimit       RateLimit
+	IPRateLimit              RateLimit
+
+	// Brute-force detection across phone numbers and IPs: an IP failing verification for
+	// AnomalyMaxPhonesPerIP numbers, or a number failed for from AnomalyMaxIPsPerPhone IPs,
+	// within AnomalyWindow is blocked for AnomalyBlock, doubling on repeats up to AnomalyMaxBlock.
+	// Anyone controlling AnomalyMaxIPsPerPhone IPs can use this to lock a victim's number out of
+	// signing in; the lower it's set, the cheaper that gets.
+	AnomalyDetectionEnabled bool
+	AnomalyWindow           time.Duration
+	AnomalyMaxPhonesPerIP   int
+	AnomalyMaxIPsPerPhone   int
+	AnomalyBlock            time.Duration
+	AnomalyMaxBlock         time.Duration
+
+	// Server-wide load shedding; zero disables the respective limit.
+	MaxConcurrentRequests int
+	MaxRequestsPerSecond  int
+	// RequestTimeout bounds how long requests may take before they're answered with 504;
+	// RequestTimeouts override it for the routes below path prefixes, e.g. "/admin". Zero
+	// disables the timeout.
+	RequestTimeout  time.Duration
+	RequestTimeouts map[string]time.Duration
+	// MaxRequestBodySize caps request bodies in bytes, answering 413 beyond it;
+	// AuthMaxRequestBodySize is the stricter cap of the sign-in endpoints. Zero disables a cap.
+	MaxRequestBodySize     int64
+	AuthMaxRequestBodySize int64
+	// MaintenanceMode pauses sign-ins with 503 for planned maintenance, showing
+	// MaintenanceMessage; health checks and the admin API keep working.
+	MaintenanceMode    bool
+	MaintenanceMessage string
+
+	// AdminAPIKey is a bootstrap key with the admin scope, used to create the first API keys.
+	AdminAPIKey string
+
+	// Optional dedicated listener for the admin endpoints, requiring client certificates
+	// signed by AdminClientCAFile. Allowed SANs further restrict which certificates are accepted.
+	AdminPort              string
+	AdminTLSCertFile       string
+	AdminTLSKeyFile        string
+	AdminClientCAFile      string
+	AdminClientAllowedSANs []string
+
+	// Trusted backends that may sign their requests with HMAC instead of calling as end users,
+	// keyed by client ID.
+	ServiceClientSecrets map[string]string
+	ServiceClientMaxSkew time.Duration
+
+	// Outgoing webhooks for auth events; no URLs disables them.
+	WebhookURLs        []string
+	WebhookSecret      string
+	WebhookMaxAttempts int
+
+	// EventBus streams the auth events to a message broker: "nats", "kafka" or "" for none.
+	EventBus string
+	// NATSURL is the NATS server; events go to the subjects "<NATSSubjectPrefix>.<event type>".
+	NATSURL           string
+	NATSSubjectPrefix string
+	// KafkaRESTURL is the Kafka REST Proxy that produces the events to KafkaTopic.
+	KafkaRESTURL string
+	KafkaTopic   string
+	// OutboxPollInterval is how often the events that Postgres stored in its outbox are relayed.
+	OutboxPollInterval time.Duration
+
+	// OpenID Connect provider mode, enabled by an issuer URL. Relying parties are keyed by client
+	// ID with their secret; OIDCRedirectURIs are, per client ID, the only URIs its users are
+	// sent back to. ID tokens are signed with the RSA key in OIDCSigningKeyFile, or a key
+	// generated on startup.
+	OIDCIssuer         string
+	OIDCClients        map[string]string
+	OIDCRedirectURIs   map[string][]string
+	OIDCSigningKeyFile string
+
+	// Social login as a fallback for unreliable SMS delivery, enabled per provider by the client
+	// IDs (audiences) ID tokens must be issued for.
+	GoogleClientIDs []string
+	AppleClientIDs  []string
+
+	// Single sign-on through an upstream OpenID Connect identity provider, e.g. a company's, enabled
+	// by its issuer URL. FederationRedirectURL is this service's /sso/callback as registered at
+	// the IdP; after signing in, users are sent to one of FederationReturnURLs with the JWT.
+	FederationIssuer       string
+	FederationClientID     string
+	FederationClientSecret string
+	FederationRedirectURL  string
+	FederationReturnURLs   []string
+
+	// OpenTelemetry tracing; the OTLP exporter reads the standard OTEL_EXPORTER_OTLP_* variables.
+	TracingEnabled bool
+	ServiceName    string
+
+	LogLevel  string // "debug", "info", "warn" or "error"
+	LogFormat string // "json" or "text"
+	// LogBodies logs PII-redacted request and response bodies; requires LOG_LEVEL=debug.
+	LogBodies bool
+
+	// ProfilingEnabled serves the Go runtime profiles (net/http/pprof) to admins below
+	// /admin/debug/pprof, on the admin listener if there is one.
+	ProfilingEnabled bool
+
+	// I18nDir optionally holds <language>.json message catalogs that add languages
+	// or override the built-in messages.
+	I18nDir string
+
+	// NewDeviceNotifications tells users about sign-ins from devices they haven't used before.
+	NewDeviceNotifications bool
+	// TrustedDeviceTTL is how long a device the user chose to remember signs in without an
+	// OTP. Zero disables trusted devices.
+	TrustedDeviceTTL time.Duration
+	// GuestTokenTTL is how long guest sessions last before the guest must sign in. Zero
+	// disables guest sessions.
+	GuestTokenTTL time.Duration
+	// PhoneReverifyDays is after how many days users must verify their phone number again
+	// with an OTP before using the protected endpoints. Zero never asks them to.
+	PhoneReverifyDays int
+	// DeletionGracePeriod is how long deleted accounts can be restored by signing in with an
+	// OTP before their data is purged. Zero never offers to restore them.
+	DeletionGracePeriod time.Duration
+
+	// DataExportTTL is how long users can download the exports of their data.
+	DataExportTTL time.Duration
+
+	// SMSProvider delivers the OTPs; "console" only prints them to the log.
+	SMSProvider string
+	// OTPChannels are the channels clients can request OTPs through: "sms" (the default and
+	// always enabled), "whatsapp", "email" and "voice".
+	OTPChannels []string
+	// OTPCosts are the costs of a message by channel, in any unit (e.g. cents); channels
+	// without one cost 1. The OTP quotas are budgets in the same unit: OTPQuotaDaily and
+	// OTPQuotaMonthly of the whole service, OTPTenantQuotaDaily and OTPTenantQuotaMonthly of
+	// every backend sending through the API (by service client or API key). Zero doesn't
+	// limit. With OTPQuotaFailover, an OTP over budget goes through a cheaper channel if one fits.
+	OTPCosts              map[string]int64
+	OTPQuotaDaily         int64
+	OTPQuotaMonthly       int64
+	OTPTenantQuotaDaily   int64
+	OTPTenantQuotaMonthly int64
+	OTPQuotaFailover      bool
+	// SMSRetryMaxAttempts bounds the sends of an SMS whose provider fails temporarily (timeouts,
+	// 5xx), including the first; they back off exponentially with jitter from SMSRetryBaseDelay
+	// up to SMSRetryMaxDelay. Rejected numbers aren't retried.
+	SMSRetryMaxAttempts int
+	SMSRetryBaseDelay   time.Duration
+	SMSRetryMaxDelay    time.Duration
+	// OTPDispatchWorkers, when set, send OTPs in the background: /otp/send returns once the OTP
+	// is stored and clients poll its delivery status. Up to OTPDispatchQueueSize sends wait for
+	// a worker; beyond that they're sent within the request. Zero sends every OTP within it.
+	OTPDispatchWorkers   int
+	OTPDispatchQueueSize int
+	// OTPAutoResendAfter, when set, sends an OTP again through OTPAutoResendChannel, one of
+	// OTPChannels, if it wasn't verified within that time and its delivery wasn't confirmed.
+	OTPAutoResendAfter   time.Duration
+	OTPAutoResendChannel string
+	// SMSSenderID is the originator OTP messages are sent from, for providers that support
+	// one: up to 11 letters and digits, or a number of up to 16 digits. SMSMessagePrefix is
+	// put before the message text, e.g. "[Acme]". SMSTenantSenderIDs and
+	// SMSTenantMessagePrefixes override them for the tenants (see middleware.Tenant) named,
+	// e.g. "client:billing" or "api-key:<id>". Empty leaves it to the provider.
+	SMSSenderID              string
+	SMSMessagePrefix         string
+	SMSTenantSenderIDs       map[string]string
+	SMSTenantMessagePrefixes map[string]string
+
+	// FeatureFlags turn features on for some of the traffic, see FeatureFlag; the flags of
+	// FeatureFlagsURL, a JSON document polled every FeatureFlagsRefreshInterval, override them.
+	FeatureFlags                map[string]FeatureFlag
+	FeatureFlagsURL             string
+	FeatureFlagsRefreshInterval time.Duration
+
+	// ShutdownTimeout bounds how long in-flight requests and webhook deliveries
+	// are given to finish after SIGINT/SIGTERM.
+	ShutdownTimeout time.Duration
+
+	// Native HTTPS, either from certificate files or from Let's Encrypt (autocert).
+	// Without either the server speaks plain HTTP and is expected to sit behind a TLS proxy.
+	TLSCertFile         string
+	TLSKeyFile          string
+	TLSAutocertDomains  []string
+	TLSAutocertEmail    string
+	TLSAutocertCacheDir string
+	// TLSHTTPPort, when set, serves ACME HTTP-01 challenges and redirects everything else to HTTPS.
+	TLSHTTPPort string
+}
+
+// RateLimit describes the policy of a single rate limiter.
+type RateLimit struct {
+	Algorithm string // "sliding_window" or "token_bucket"
+	Max       int    // requests per window (sliding window) or tokens refilled per window (token bucket)
+	Window    time.Duration
+	Burst     int // token bucket capacity; ignored by the sliding window
+	// MaxPenalty caps the progressive backoff applied each time the limit is exhausted
+	// (the block doubles from 2*Window on every repeat). Zero disables penalties.
+	MaxPenalty time.Duration
+}
+
+// FeatureFlag turns a feature on for everyone if Enabled, otherwise for the Tenants listed and
+// Percentage of the subjects, e.g. phone numbers. FEATURE_FLAGS sets them as a comma-separated
+// list of name=rules, the rules joined with "|": "on", "off", a percentage like "10%" or
+// "tenant:<tenant>", e.g. "otp_channel_whatsapp=10%|tenant:client:billing".
+type FeatureFlag struct {
+	Enabled    bool
+	Percentage int
+	Tenants    []string
 }
 
-func LoadConfig() *Config {
+// otpChannels are the channels OTPs can be sent through, see OTP_CHANNELS.
+var otpChannels = []string{"sms", "whatsapp", "email", "voice"}
+
+// LoadConfig reads the configuration. overrides (e.g. from command-line flags) map
+// environment variable names to values and take precedence over every other source.
+func LoadConfig(overrides map[string]string) *Config {
+	exportOverrides(overrides)
+
 	err := godotenv.Load()
 	if err != nil && !os.IsNotExist(err) {
 		log.Printf("Error loading .env file (might be okay if running in container): %v", err)
 	}
 
+	// The config file has the lowest precedence, so it only fills in what the environment
+	// and .env leave unset.
+	fileValues := loadConfigFile()
+
+	// Secrets from a secrets manager override the environment, so they are exported first.
+	secretsProvider := newSecretsProvider()
+	var secretValues map[string]string
+	if secretsProvider != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		secretValues, err = secrets.Export(ctx, secretsProvider)
+		cancel()
+		if err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		log.Printf("Loaded %d secrets from %s", len(secretValues), secretsProvider.Name())
+		exportOverrides(overrides)
+	}
+
+	// The settings Reload can change are read the same way on startup.
+	rt := runtimeFromEnv()
+
+	env := strings.ToLower(getEnv("APP_ENV", EnvDev))
+	// Browsers may only call the API from the front-end dev server by default, and only in dev.
+	var defaultCORSOrigins []string
+	if env == EnvDev {
+		defaultCORSOrigins = []string{"http://localhost:5173"}
+	}
+
 	cfg := &Config{
+		Env:                  env,
 		Port:                 getEnv("PORT", "8080"),
+		BasePath:             strings.TrimSuffix(getEnv("BASE_PATH", ""), "/"),
 		JWTSecret:            getEnv("JWT_SECRET", "default-jwt-secret"),
 		OTPExpirationMinutes: getEnvAsInt("OTP_EXPIRATION_MINUTES", 2),
 		// ADD THESE TWO LINES
-		StorageType: strings.ToLower(getEnv("STORAGE_TYPE", "inmemory")),
-		DatabaseURL: getEnv("DATABASE_URL", ""),
+		StorageType:     strings.ToLower(getEnv("STORAGE_TYPE", "inmemory")),
+		DatabaseURL:     getEnv("DATABASE_URL", ""),
+		DBAutoProvision: getEnvAsBool("DB_AUTO_PROVISION", false),
+
+		DBConnectMaxWait:        getEnvAsDuration("DB_CONNECT_MAX_WAIT", time.Minute),
+		DBConnectRetryBaseDelay: getEnvAsDuration("DB_CONNECT_RETRY_BASE_DELAY", 500*time.Millisecond),
+		DBConnectRetryMaxDelay:  getEnvAsDuration("DB_CONNECT_RETRY_MAX_DELAY", 10*time.Second),
+
+		MemorySnapshotFile:     getEnv("MEMORY_SNAPSHOT_FILE", ""),
+		MemorySnapshotInterval: getEnvAsDuration("MEMORY_SNAPSHOT_INTERVAL", time.Minute),
+		OTPStoreMaxEntries:     getEnvAsInt("OTP_STORE_MAX_ENTRIES", 100000),
+
+		SecretsProvider:        secretsProvider,
+		Secrets:                secretValues,
+		SecretsRefreshInterval: getEnvAsDuration("SECRETS_REFRESH_INTERVAL", 5*time.Minute),
+
+		PhoneEncryptionKey: getEnv("PHONE_ENCRYPTION_KEY", ""),
+		PhoneDefaultRegion: strings.ToUpper(getEnv("PHONE_DEFAULT_REGION", "")),
+
+		OTPAllowedCountryCodes: rt.OTPAllowedCountryCodes,
+		OTPBlockedCountryCodes: rt.OTPBlockedCountryCodes,
+
+		SIMSwapAPIURL:   getEnv("SIM_SWAP_API_URL", ""),
+		SIMSwapAPIToken: getEnv("SIM_SWAP_API_TOKEN", ""),
+		SIMSwapMaxAge:   getEnvAsDuration("SIM_SWAP_MAX_AGE", 72*time.Hour),
+		SIMSwapAction:   strings.ToLower(getEnv("SIM_SWAP_ACTION", "challenge")),
+
+		TrustedProxies: getEnvAsSlice("TRUSTED_PROXIES", nil),
+
+		CORSAllowedOrigins:   getEnvAsSlice("CORS_ALLOWED_ORIGINS", defaultCORSOrigins),
+		CORSAllowCredentials: getEnvAsBool("CORS_ALLOW_CREDENTIALS", false),
+
+		ContentSecurityPolicy: getEnv("CONTENT_SECURITY_POLICY", "default-src 'none'; frame-ancestors 'none'"),
+		HSTSMaxAge:            getEnvAsDuration("HSTS_MAX_AGE", 365*24*time.Hour),
+
+		RateLimitBackend:         strings.ToLower(getEnv("RATE_LIMIT_BACKEND", "inmemory")),
+		RedisURL:                 getEnv("REDIS_URL", ""),
+		RateLimitCleanupInterval: getEnvAsDuration("RATE_LIMIT_CLEANUP_INTERVAL", 10*time.Minute),
+
+		OTPSendRateLimit:         rt.OTPSendRateLimit,
+		OTPChannelSendRateLimits: rt.OTPChannelSendRateLimits,
+		OTPVerifyRateLimit:       rt.OTPVerifyRateLimit,
+		IPRateLimit:              rt.IPRateLimit,
+
+		AnomalyDetectionEnabled: getEnvAsBool("ANOMALY_DETECTION_ENABLED", true),
+		AnomalyWindow:           getEnvAsDuration("ANOMALY_WINDOW", 15*time.Minute),
+		AnomalyMaxPhonesPerIP:   getEnvAsInt("ANOMALY_MAX_PHONES_PER_IP", 10),
+		AnomalyMaxIPsPerPhone:   getEnvAsInt("ANOMALY_MAX_IPS_PER_PHONE", 10),
+		AnomalyBlock:            getEnvAsDuration("ANOMALY_BLOCK", time.Hour),
+		AnomalyMaxBlock:         getEnvAsDuration("ANOMALY_MAX_BLOCK", 24*time.Hour),
+
+		MaxConcurrentRequests: getEnvAsInt("MAX_CONCURRENT_REQUESTS", 1000),
+		MaxRequestsPerSecond:  getEnvAsInt("MAX_REQUESTS_PER_SECOND", 0),
+		RequestTimeout:        getEnvAsDuration("REQUEST_TIMEOUT", 15*time.Second),
+		RequestTimeouts:       getEnvAsDurations("REQUEST_TIMEOUTS"),
+
+		MaxRequestBodySize:     int64(getEnvAsInt("MAX_REQUEST_BODY_SIZE", 1<<20)),
+		AuthMaxRequestBodySize: int64(getEnvAsInt("AUTH_MAX_REQUEST_BODY_SIZE", 8<<10)),
+
+		MaintenanceMode:    rt.MaintenanceMode,
+		MaintenanceMessage: rt.MaintenanceMessage,
+
+		AdminAPIKey: getEnv("ADMIN_API_KEY", ""),
+
+		AdminPort:              getEnv("ADMIN_PORT", ""),
+		AdminTLSCertFile:       getEnv("ADMIN_TLS_CERT_FILE", ""),
+		AdminTLSKeyFile:        getEnv("ADMIN_TLS_KEY_FILE", ""),
+		AdminClientCAFile:      getEnv("ADMIN_CLIENT_CA_FILE", ""),
+		AdminClientAllowedSANs: getEnvAsSlice("ADMIN_CLIENT_ALLOWED_SANS", nil),
+
+		ServiceClientSecrets: getEnvAsMap("SERVICE_CLIENTS", nil),
+		ServiceClientMaxSkew: getEnvAsDuration("SERVICE_CLIENT_MAX_SKEW", 5*time.Minute),
+
+		WebhookURLs:        getEnvAsSlice("WEBHOOK_URLS", nil),
+		WebhookSecret:      getEnv("WEBHOOK_SECRET", ""),
+		WebhookMaxAttempts: getEnvAsInt("WEBHOOK_MAX_ATTEMPTS", 5),
+
+		EventBus:          strings.ToLower(getEnv("EVENT_BUS", "")),
+		NATSURL:           getEnv("NATS_URL", ""),
+		NATSSubjectPrefix: getEnv("NATS_SUBJECT_PREFIX", "auth"),
+		KafkaRESTURL:      getEnv("KAFKA_REST_URL", ""),
+		KafkaTopic:        getEnv("KAFKA_TOPIC", "auth-events"),
+
+		OutboxPollInterval: getEnvAsDuration("OUTBOX_POLL_INTERVAL", time.Second),
+
+		OIDCIssuer:         strings.TrimSuffix(getEnv("OIDC_ISSUER", ""), "/"),
+		OIDCClients:        getEnvAsMap("OIDC_CLIENTS", nil),
+		OIDCRedirectURIs:   getEnvAsLists("OIDC_REDIRECT_URIS"),
+		OIDCSigningKeyFile: getEnv("OIDC_SIGNING_KEY_FILE", ""),
+
+		GoogleClientIDs: getEnvAsSlice("GOOGLE_CLIENT_IDS", nil),
+		AppleClientIDs:  getEnvAsSlice("APPLE_CLIENT_IDS", nil),
+
+		FederationIssuer:       strings.TrimSuffix(getEnv("FEDERATION_ISSUER", ""), "/"),
+		FederationClientID:     getEnv("FEDERATION_CLIENT_ID", ""),
+		FederationClientSecret: getEnv("FEDERATION_CLIENT_SECRET", ""),
+		FederationRedirectURL:  getEnv("FEDERATION_REDIRECT_URL", ""),
+		FederationReturnURLs:   getEnvAsSlice("FEDERATION_RETURN_URLS", nil),
+
+		TracingEnabled: getEnvAsBool("TRACING_ENABLED", false),
+		ServiceName:    getEnv("OTEL_SERVICE_NAME", "go-otp-auth-service"),
+
+		LogLevel:  rt.LogLevel,
+		LogFormat: strings.ToLower(getEnv("LOG_FORMAT", "json")),
+		LogBodies: getEnvAsBool("LOG_BODIES", false),
+
+		ProfilingEnabled: getEnvAsBool("PPROF_ENABLED", false),
+
+		I18nDir: getEnv("I18N_DIR", ""),
+
+		SMSProvider: rt.SMSProvider,
+		OTPChannels: getEnvAsSlice("OTP_CHANNELS", []string{"sms"}),
+
+		OTPQuotaDaily:         int64(getEnvAsInt("OTP_QUOTA_DAILY", 0)),
+		OTPQuotaMonthly:       int64(getEnvAsInt("OTP_QUOTA_MONTHLY", 0)),
+		OTPTenantQuotaDaily:   int64(getEnvAsInt("OTP_TENANT_QUOTA_DAILY", 0)),
+		OTPTenantQuotaMonthly: int64(getEnvAsInt("OTP_TENANT_QUOTA_MONTHLY", 0)),
+		OTPQuotaFailover:      getEnvAsBool("OTP_QUOTA_FAILOVER", false),
+
+		SMSRetryMaxAttempts: getEnvAsInt("SMS_RETRY_MAX_ATTEMPTS", 3),
+		SMSRetryBaseDelay:   getEnvAsDuration("SMS_RETRY_BASE_DELAY", 200*time.Millisecond),
+		SMSRetryMaxDelay:    getEnvAsDuration("SMS_RETRY_MAX_DELAY", 2*time.Second),
+
+		OTPDispatchWorkers:   getEnvAsInt("OTP_DISPATCH_WORKERS", 0),
+		OTPDispatchQueueSize: getEnvAsInt("OTP_DISPATCH_QUEUE_SIZE", 1000),
+
+		OTPAutoResendAfter:   getEnvAsDuration("OTP_AUTO_RESEND_AFTER", 0),
+		OTPAutoResendChannel: getEnv("OTP_AUTO_RESEND_CHANNEL", ""),
+
+		SMSSenderID:              getEnv("SMS_SENDER_ID", ""),
+		SMSMessagePrefix:         getEnv("SMS_MESSAGE_PREFIX", ""),
+		SMSTenantSenderIDs:       getEnvAsPairs("SMS_TENANT_SENDER_IDS", "=", nil),
+		SMSTenantMessagePrefixes: getEnvAsPairs("SMS_TENANT_MESSAGE_PREFIXES", "=", nil),
+
+		FeatureFlags:                rt.FeatureFlags,
+		FeatureFlagsURL:             getEnv("FEATURE_FLAGS_URL", ""),
+		FeatureFlagsRefreshInterval: getEnvAsDuration("FEATURE_FLAGS_REFRESH_INTERVAL", time.Minute),
+
+		NewDeviceNotifications: getEnvAsBool("NEW_DEVICE_NOTIFICATIONS", false),
+		TrustedDeviceTTL:       getEnvAsDuration("TRUSTED_DEVICE_TTL", 30*24*time.Hour),
+		GuestTokenTTL:          getEnvAsDuration("GUEST_TOKEN_TTL", 0),
+		PhoneReverifyDays:      getEnvAsInt("PHONE_REVERIFY_DAYS", 0),
+		DeletionGracePeriod:    getEnvAsDuration("DELETION_GRACE_PERIOD", 0),
+
+		DataExportTTL: getEnvAsDuration("DATA_EXPORT_TTL", 24*time.Hour),
+
+		ShutdownTimeout: getEnvAsDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+
+		TLSCertFile:         getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:          getEnv("TLS_KEY_FILE", ""),
+		TLSAutocertDomains:  getEnvAsSlice("TLS_AUTOCERT_DOMAINS", nil),
+		TLSAutocertEmail:    getEnv("TLS_AUTOCERT_EMAIL", ""),
+		TLSAutocertCacheDir: getEnv("TLS_AUTOCERT_CACHE_DIR", "certs"),
+		TLSHTTPPort:         getEnv("TLS_HTTP_PORT", ""),
 	}
 
-	if cfg.StorageType == "postgres" && cfg.DatabaseURL == "" {
-		log.Fatal("FATAL: STORAGE_TYPE is 'postgres' but DATABASE_URL is not set.")
+	// Every problem is collected and reported at once, so one restart is enough to fix them all.
+	switch cfg.Env {
+	case EnvDev, EnvStaging, EnvProd:
+	default:
+		addProblem("APP_ENV must be '%s', '%s' or '%s', got '%s'", EnvDev, EnvStaging, EnvProd, cfg.Env)
+	}
+
+	switch cfg.StorageType {
+	case "inmemory":
+	case "postgres":
+		if cfg.DatabaseURL == "" {
+			addProblem("STORAGE_TYPE is 'postgres' but DATABASE_URL is not set")
+		} else if err := validateDSN(cfg.DatabaseURL); err != nil {
+			addProblem("DATABASE_URL is invalid: %v", err)
+		}
+	default:
+		addProblem("STORAGE_TYPE must be 'inmemory' or 'postgres', got '%s'", cfg.StorageType)
+	}
+	if cfg.MemorySnapshotFile != "" {
+		if cfg.StorageType != "inmemory" {
+			addProblem("MEMORY_SNAPSHOT_FILE only applies to STORAGE_TYPE 'inmemory'")
+		}
+		if cfg.MemorySnapshotInterval <= 0 {
+			addProblem("MEMORY_SNAPSHOT_INTERVAL must be positive")
+		}
+	}
+	if cfg.OTPStoreMaxEntries < 0 {
+		addProblem("OTP_STORE_MAX_ENTRIES must not be negative")
+	}
+	if cfg.OutboxPollInterval <= 0 {
+		addProblem("OUTBOX_POLL_INTERVAL must be positive")
+	}
+	if cfg.DataExportTTL <= 0 {
+		addProblem("DATA_EXPORT_TTL must be positive")
+	}
+	if cfg.TrustedDeviceTTL < 0 {
+		addProblem("TRUSTED_DEVICE_TTL must not be negative")
+	}
+	if cfg.GuestTokenTTL < 0 {
+		addProblem("GUEST_TOKEN_TTL must not be negative")
+	}
+	if cfg.PhoneReverifyDays < 0 {
+		addProblem("PHONE_REVERIFY_DAYS must not be negative")
+	}
+	if cfg.DeletionGracePeriod < 0 {
+		addProblem("DELETION_GRACE_PERIOD must not be negative")
+	}
+	for _, channel := range cfg.OTPChannels {
+		if !slices.Contains(otpChannels, channel) {
+			addProblem("OTP_CHANNELS must only list 'sms', 'whatsapp', 'email' or 'voice', got '%s'", channel)
+		}
+	}
+	if !slices.Contains(cfg.OTPChannels, "sms") {
+		addProblem("OTP_CHANNELS must include 'sms', the default channel")
+	}
+	cfg.OTPCosts = make(map[string]int64)
+	for channel, cost := range getEnvAsMap("OTP_COSTS", nil) {
+		value, err := strconv.ParseInt(cost, 10, 64)
+		switch {
+		case !slices.Contains(otpChannels, channel):
+			addProblem("OTP_COSTS must only name 'sms', 'whatsapp', 'email' or 'voice', got '%s'", channel)
+		case err != nil || value < 0:
+			addProblem("OTP_COSTS must give every channel a non-negative integer cost, got '%s' for %s", cost, channel)
+		default:
+			cfg.OTPCosts[channel] = value
+		}
+	}
+	if cfg.OTPQuotaDaily < 0 || cfg.OTPQuotaMonthly < 0 || cfg.OTPTenantQuotaDaily < 0 || cfg.OTPTenantQuotaMonthly < 0 {
+		addProblem("OTP_QUOTA_DAILY, OTP_QUOTA_MONTHLY, OTP_TENANT_QUOTA_DAILY and OTP_TENANT_QUOTA_MONTHLY must not be negative")
+	}
+	if cfg.SMSRetryMaxAttempts < 1 {
+		addProblem("SMS_RETRY_MAX_ATTEMPTS must be at least 1")
+	}
+	if cfg.SMSRetryBaseDelay < 0 || cfg.SMSRetryMaxDelay < cfg.SMSRetryBaseDelay {
+		addProblem("SMS_RETRY_BASE_DELAY must not be negative nor above SMS_RETRY_MAX_DELAY")
+	}
+	if cfg.BasePath != "" && (!strings.HasPrefix(cfg.BasePath, "/") || path.Clean(cfg.BasePath) != cfg.BasePath || strings.ContainsAny(cfg.BasePath, ":*?#% ")) {
+		addProblem("BASE_PATH must be a plain path like /auth, got '%s'", cfg.BasePath)
+	}
+	if cfg.DBConnectMaxWait < 0 || cfg.DBConnectRetryBaseDelay <= 0 || cfg.DBConnectRetryMaxDelay < cfg.DBConnectRetryBaseDelay {
+		addProblem("DB_CONNECT_MAX_WAIT must not be negative, DB_CONNECT_RETRY_BASE_DELAY must be positive and not above DB_CONNECT_RETRY_MAX_DELAY")
+	}
+	if cfg.RequestTimeout < 0 {
+		addProblem("REQUEST_TIMEOUT must not be negative")
+	}
+	if cfg.MaxRequestBodySize < 0 || cfg.AuthMaxRequestBodySize < 0 {
+		addProblem("MAX_REQUEST_BODY_SIZE and AUTH_MAX_REQUEST_BODY_SIZE must not be negative")
+	}
+	for prefix, timeout := range cfg.RequestTimeouts {
+		if !strings.HasPrefix(prefix, "/") || timeout < 0 {
+			addProblem("REQUEST_TIMEOUTS must map path prefixes like /admin to durations that aren't negative, got '%s=%s'", prefix, timeout)
+		}
+	}
+	if cfg.OTPDispatchWorkers < 0 {
+		addProblem("OTP_DISPATCH_WORKERS must not be negative")
+	}
+	if cfg.OTPDispatchWorkers > 0 && cfg.OTPDispatchQueueSize <= 0 {
+		addProblem("OTP_DISPATCH_QUEUE_SIZE must be positive")
+	}
+	if cfg.OTPAutoResendAfter < 0 {
+		addProblem("OTP_AUTO_RESEND_AFTER must not be negative")
+	}
+	if cfg.OTPAutoResendAfter > 0 && !slices.Contains(cfg.OTPChannels, cfg.OTPAutoResendChannel) {
+		addProblem("OTP_AUTO_RESEND_CHANNEL must be one of OTP_CHANNELS when OTP_AUTO_RESEND_AFTER is set, got '%s'", cfg.OTPAutoResendChannel)
+	}
+	if !validSenderID(cfg.SMSSenderID) {
+		addProblem("SMS_SENDER_ID must be up to 11 letters and digits or a number of up to 16 digits, got '%s'", cfg.SMSSenderID)
+	}
+	if cfg.FeatureFlagsURL != "" {
+		if u, err := url.Parse(cfg.FeatureFlagsURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			addProblem("FEATURE_FLAGS_URL must be an http(s) URL, got '%s'", cfg.FeatureFlagsURL)
+		}
+		if cfg.FeatureFlagsRefreshInterval <= 0 {
+			addProblem("FEATURE_FLAGS_REFRESH_INTERVAL must be positive")
+		}
+	}
+	for tenant, senderID := range cfg.SMSTenantSenderIDs {
+		if !validSenderID(senderID) {
+			addProblem("SMS_TENANT_SENDER_IDS must give %s up to 11 letters and digits or a number of up to 16 digits, got '%s'", tenant, senderID)
+		}
+	}
+
+	validatePort("PORT", cfg.Port)
+	validatePort("ADMIN_PORT", cfg.AdminPort)
+	validatePort("TLS_HTTP_PORT", cfg.TLSHTTPPort)
+	if cfg.ShutdownTimeout <= 0 {
+		addProblem("SHUTDOWN_TIMEOUT must be positive")
+	}
+	if cfg.OTPExpirationMinutes <= 0 {
+		addProblem("OTP_EXPIRATION_MINUTES must be positive")
+	}
+
+	if cfg.PhoneDefaultRegion != "" && !phone.ValidRegion(cfg.PhoneDefaultRegion) {
+		addProblem("PHONE_DEFAULT_REGION must be a supported region code such as 'DE', got '%s'", cfg.PhoneDefaultRegion)
+	}
+	switch cfg.RateLimitBackend {
+	case "inmemory":
+	case "redis":
+		if cfg.RedisURL == "" {
+			addProblem("RATE_LIMIT_BACKEND is 'redis' but REDIS_URL is not set")
+		} else if _, err := redis.ParseURL(cfg.RedisURL); err != nil {
+			addProblem("REDIS_URL is invalid: %v", err)
+		}
+	default:
+		addProblem("RATE_LIMIT_BACKEND must be 'inmemory' or 'redis', got '%s'", cfg.RateLimitBackend)
+	}
+	if cfg.RateLimitCleanupInterval <= 0 {
+		addProblem("RATE_LIMIT_CLEANUP_INTERVAL must be positive")
+	}
+
+	if cfg.CORSAllowCredentials && slices.Contains(cfg.CORSAllowedOrigins, "*") {
+		addProblem("CORS_ALLOW_CREDENTIALS cannot be combined with CORS_ALLOWED_ORIGINS=*")
+	}
+
+	// Production refuses what is only fine for trying the service out.
+	allowInMemoryStorage := getEnvAsBool("ALLOW_INMEMORY_STORAGE", false)
+	if cfg.Env == EnvProd {
+		if cfg.StorageType == "inmemory" && !allowInMemoryStorage {
+			addProblem("STORAGE_TYPE 'inmemory' loses every user on restart and is refused with APP_ENV=prod; set ALLOW_INMEMORY_STORAGE=true to run it anyway")
+		}
+		for _, origin := range cfg.CORSAllowedOrigins {
+			if !strings.HasPrefix(origin, "https://") {
+				addProblem("CORS_ALLOWED_ORIGINS must only list https:// origins with APP_ENV=prod, got '%s'", origin)
+			}
+		}
+	}
+
+	if cfg.AnomalyDetectionEnabled && (cfg.AnomalyWindow <= 0 || cfg.AnomalyMaxPhonesPerIP <= 0 || cfg.AnomalyMaxIPsPerPhone <= 0 ||
+		cfg.AnomalyBlock <= 0 || cfg.AnomalyMaxBlock < cfg.AnomalyBlock) {
+		addProblem("ANOMALY_* settings must be positive and ANOMALY_MAX_BLOCK at least ANOMALY_BLOCK")
+	}
+
+	if cfg.SIMSwapAction != "challenge" && cfg.SIMSwapAction != "deny" {
+		addProblem("SIM_SWAP_ACTION must be 'challenge' or 'deny', got '%s'", cfg.SIMSwapAction)
+	}
+	if cfg.SIMSwapMaxAge < time.Hour {
+		addProblem("SIM_SWAP_MAX_AGE must be at least 1h")
+	}
+
+	if len(cfg.WebhookURLs) > 0 && cfg.WebhookSecret == "" {
+		addProblem("WEBHOOK_URLS is set but WEBHOOK_SECRET is not set")
+	}
+
+	switch cfg.EventBus {
+	case "":
+	case "nats":
+		if cfg.NATSURL == "" {
+			addProblem("EVENT_BUS is 'nats' but NATS_URL is not set")
+		}
+		if cfg.NATSSubjectPrefix == "" || strings.ContainsAny(cfg.NATSSubjectPrefix, " \t*>") {
+			addProblem("NATS_SUBJECT_PREFIX must be a subject without spaces or wildcards, got '%s'", cfg.NATSSubjectPrefix)
+		}
+	case "kafka":
+		if u, err := url.Parse(cfg.KafkaRESTURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			addProblem("EVENT_BUS is 'kafka' but KAFKA_REST_URL is not an http(s) URL, got '%s'", cfg.KafkaRESTURL)
+		}
+		if cfg.KafkaTopic == "" {
+			addProblem("EVENT_BUS is 'kafka' but KAFKA_TOPIC is empty")
+		}
+	default:
+		addProblem("EVENT_BUS must be 'nats', 'kafka' or empty, got '%s'", cfg.EventBus)
+	}
+
+	if cfg.OIDCIssuer != "" {
+		if u, err := url.Parse(cfg.OIDCIssuer); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.RawQuery != "" {
+			addProblem("OIDC_ISSUER must be an http(s) URL without query, got '%s'", cfg.OIDCIssuer)
+		} else if u.Scheme != "https" && cfg.Env == EnvProd {
+			addProblem("OIDC_ISSUER must be an https:// URL with APP_ENV=prod")
+		}
+		if len(cfg.OIDCClients) == 0 || len(cfg.OIDCRedirectURIs) == 0 {
+			addProblem("OIDC_ISSUER requires OIDC_CLIENTS and OIDC_REDIRECT_URIS")
+		}
+		for clientID := range cfg.OIDCClients {
+			if len(cfg.OIDCRedirectURIs[clientID]) == 0 {
+				addProblem("OIDC_REDIRECT_URIS has no redirect URIs of the client '%s'", clientID)
+			}
+		}
+		for clientID, uris := range cfg.OIDCRedirectURIs {
+			if _, ok := cfg.OIDCClients[clientID]; !ok {
+				addProblem("OIDC_REDIRECT_URIS names the client '%s', which isn't in OIDC_CLIENTS", clientID)
+			}
+			for _, uri := range uris {
+				if u, err := url.Parse(uri); err != nil || !u.IsAbs() || u.Fragment != "" {
+					addProblem("OIDC_REDIRECT_URIS must be absolute URLs without fragment, got '%s'", uri)
+				}
+			}
+		}
+	}
+
+	if cfg.FederationIssuer != "" {
+		if u, err := url.Parse(cfg.FederationIssuer); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			addProblem("FEDERATION_ISSUER must be an http(s) URL, got '%s'", cfg.FederationIssuer)
+		} else if u.Scheme != "https" && cfg.Env == EnvProd {
+			addProblem("FEDERATION_ISSUER must be an https:// URL with APP_ENV=prod")
+		}
+		if cfg.FederationClientID == "" || cfg.FederationClientSecret == "" || cfg.FederationRedirectURL == "" {
+			addProblem("FEDERATION_ISSUER requires FEDERATION_CLIENT_ID, FEDERATION_CLIENT_SECRET and FEDERATION_REDIRECT_URL")
+		}
+		for _, uri := range append([]string{cfg.FederationRedirectURL}, cfg.FederationReturnURLs...) {
+			if u, err := url.Parse(uri); uri != "" && (err != nil || !u.IsAbs() || u.Fragment != "") {
+				addProblem("FEDERATION_REDIRECT_URL and FEDERATION_RETURN_URLS must be absolute URLs without fragment, got '%s'", uri)
+			}
+		}
+	}
+
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		addProblem("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	if cfg.TLSCertFile != "" && len(cfg.TLSAutocertDomains) > 0 {
+		addProblem("TLS_CERT_FILE and TLS_AUTOCERT_DOMAINS are mutually exclusive")
+	}
+
+	if cfg.TLSHTTPPort != "" && !cfg.TLSEnabled() {
+		addProblem("TLS_HTTP_PORT is set but neither TLS_CERT_FILE nor TLS_AUTOCERT_DOMAINS is set")
+	}
+
+	if cfg.AdminPort != "" && (cfg.AdminTLSCertFile == "" || cfg.AdminTLSKeyFile == "" || cfg.AdminClientCAFile == "") {
+		addProblem("ADMIN_PORT requires ADMIN_TLS_CERT_FILE, ADMIN_TLS_KEY_FILE and ADMIN_CLIENT_CA_FILE")
+	}
+
+	// JWT_SECRETS takes precedence over JWT_SECRET; its first entry signs new tokens.
+	cfg.JWTSecrets = getEnvAsSlice("JWT_SECRETS", []string{cfg.JWTSecret})
+	cfg.JWTSecret = cfg.JWTSecrets[0]
+	cfg.JWTClockSkew = getEnvAsDuration("JWT_CLOCK_SKEW", 0)
+	cfg.JWTRequiredClaims = getEnvAsSlice("JWT_REQUIRED_CLAIMS", []string{"exp", "iat"})
+	cfg.JWTMaxTokenAge = getEnvAsDuration("JWT_MAX_TOKEN_AGE", 0)
+
+	if cfg.JWTClockSkew < 0 {
+		addProblem("JWT_CLOCK_SKEW must not be negative")
+	}
+	for _, claim := range cfg.JWTRequiredClaims {
+		if !slices.Contains([]string{"exp", "iat", "nbf"}, claim) {
+			addProblem("JWT_REQUIRED_CLAIMS may only list exp, iat and nbf, got '%s'", claim)
+		}
+	}
+	if cfg.JWTMaxTokenAge < 0 {
+		addProblem("JWT_MAX_TOKEN_AGE must not be negative")
 	}
 
 	if cfg.JWTSecret == "default-jwt-secret" {
-		log.Println("WARNING: Using default JWT_SECRET. Please set a strong secret in .env or environment variables.")
+		if cfg.Env == EnvProd {
+			addProblem("JWT_SECRET must be set with APP_ENV=prod")
+		} else {
+			log.Println("WARNING: Using default JWT_SECRET. Please set a strong secret in .env or environment variables.")
+		}
+	}
+	for i, secret := range cfg.JWTSecrets {
+		if secret != "default-jwt-secret" && len(secret) < minJWTSecretLength {
+			addProblem("JWT secrets must be at least %d bytes long, secret %d of JWT_SECRETS/JWT_SECRET has %d", minJWTSecretLength, i+1, len(secret))
+		}
+	}
+
+	if err := takeProblems(); err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+
+	for _, name := range unusedKeys(fileValues, readKeys) {
+		log.Printf("WARNING: Config file setting %s is not used by this configuration.", name)
 	}
 
 	return cfg
 }
 
+// exportOverrides sets the overrides in the environment, where no other source replaces them.
+// Their <name>_FILE variants are dropped, which would take precedence otherwise.
+func exportOverrides(overrides map[string]string) {
+	for name, value := range overrides {
+		os.Setenv(name, value)
+		os.Unsetenv(name + "_FILE")
+	}
+}
+
+// loadConfigFile exports the settings of the config file and returns them.
+func loadConfigFile() map[string]string {
+	values, err := readConfigFile()
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+	applyConfigFile(values)
+	return values
+}
+
+// readConfigFile reads CONFIG_FILE, or config.yaml if it exists. A missing config.yaml
+// is not an error.
+func readConfigFile() (map[string]string, error) {
+	path := getEnv("CONFIG_FILE", "")
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigFile
+	}
+
+	values, err := loadFile(path)
+	if err != nil {
+		if !explicit && os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not read config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// applyConfigFile exports the settings of the config file the environment doesn't override.
+func applyConfigFile(values map[string]string) {
+	if values == nil {
+		return
+	}
+	applied := applyFile(values)
+	log.Printf("Loaded %d settings from the config file (%d overridden by the environment)", len(applied), len(values)-len(applied))
+}
+
+// TLSEnabled reports whether the server terminates TLS itself.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" || len(c.TLSAutocertDomains) > 0
+}
+
+// newSecretsProvider creates the secrets manager client selected by SECRETS_PROVIDER,
+// or returns nil when secrets come from the environment only.
+func newSecretsProvider() secrets.Provider {
+	switch provider := strings.ToLower(getEnv("SECRETS_PROVIDER", "")); provider {
+	case "":
+		return nil
+	case "vault":
+		addr, token, path := getEnv("VAULT_ADDR", ""), getEnv("VAULT_TOKEN", ""), getEnv("VAULT_SECRET_PATH", "")
+		if addr == "" || token == "" || path == "" {
+			log.Fatal("FATAL: SECRETS_PROVIDER is 'vault' but VAULT_ADDR, VAULT_TOKEN or VAULT_SECRET_PATH is not set.")
+		}
+		return secrets.NewVaultProvider(addr, token, path)
+	case "aws":
+		region, secretID := getEnv("AWS_REGION", ""), getEnv("AWS_SECRET_ID", "")
+		creds := secrets.AWSCredentials{
+			AccessKeyID:     getEnv("AWS_ACCESS_KEY_ID", ""),
+			SecretAccessKey: getEnv("AWS_SECRET_ACCESS_KEY", ""),
+			SessionToken:    getEnv("AWS_SESSION_TOKEN", ""),
+		}
+		if region == "" || secretID == "" || creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+			log.Fatal("FATAL: SECRETS_PROVIDER is 'aws' but AWS_REGION, AWS_SECRET_ID, AWS_ACCESS_KEY_ID or AWS_SECRET_ACCESS_KEY is not set.")
+		}
+		return secrets.NewAWSProvider(region, secretID, getEnv("AWS_ENDPOINT_URL_SECRETS_MANAGER", ""), creds)
+	default:
+		log.Fatalf("FATAL: SECRETS_PROVIDER must be 'vault' or 'aws', got '%s'.", provider)
+		return nil
+	}
+}
+
+// readKeys records every setting the configuration looked up, to spot unused config file settings.
+var readKeys = make(map[string]bool)
+
+// getEnv returns the setting key. <key>_FILE, e.g. JWT_SECRET_FILE, names a file holding it
+// instead, like the secrets Docker and Kubernetes mount, and takes precedence over key.
 func getEnv(key, defaultValue string) string {
+	readKeys[key] = true
+	readKeys[key+"_FILE"] = true
+	if path, exists := os.LookupEnv(key + "_FILE"); exists && path != "" {
+		value, err := os.ReadFile(path)
+		if err != nil {
+			addProblem("%s_FILE could not be read: %v", key, err)
+			return defaultValue
+		}
+		// Secret files usually end with a newline that isn't part of the value.
+		return strings.TrimRight(string(value), "\r\n")
+	}
 	if value, exists := os.LookupEnv(key); exists {
 		return value
 	}
@@ -53,8 +927,194 @@ func getEnv(key, defaultValue string) string {
 
 func getEnvAsInt(key string, defaultValue int) int {
 	valueStr := getEnv(key, strconv.Itoa(defaultValue))
-	if value, err := strconv.Atoi(valueStr); err == nil {
-		return value
+	value, err := strconv.Atoi(valueStr)
+	if err != nil {
+		addProblem("%s must be an integer, got '%s'", key, valueStr)
+		return defaultValue
 	}
-	return defaultValue
+	return value
+}
+
+func getEnvAsBool(key string, defaultValue bool) bool {
+	valueStr := getEnv(key, strconv.FormatBool(defaultValue))
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		addProblem("%s must be true or false, got '%s'", key, valueStr)
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	valueStr := getEnv(key, "")
+	if valueStr == "" {
+		return defaultValue
+	}
+	value, err := time.ParseDuration(valueStr)
+	if err != nil {
+		addProblem("%s must be a duration such as '90s' or '2h', got '%s'", key, valueStr)
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvAsRateLimit reads the <prefix>_ALGORITHM, <prefix>_MAX, <prefix>_WINDOW, <prefix>_BURST and
+// <prefix>_MAX_PENALTY variables describing one rate limiter. The burst defaults to the maximum.
+func getEnvAsRateLimit(prefix string, defaultMax int, defaultWindow, defaultMaxPenalty time.Duration) RateLimit {
+	maxReq := getEnvAsInt(prefix+"_MAX", defaultMax)
+	return RateLimit{
+		Algorithm:  strings.ToLower(getEnv(prefix+"_ALGORITHM", "sliding_window")),
+		Max:        maxReq,
+		Window:     getEnvAsDuration(prefix+"_WINDOW", defaultWindow),
+		Burst:      getEnvAsInt(prefix+"_BURST", maxReq),
+		MaxPenalty: getEnvAsDuration(prefix+"_MAX_PENALTY", defaultMaxPenalty),
+	}
+}
+
+// getEnvAsRateLimitFrom reads the <prefix>_* settings of a limiter, defaulting to base. The
+// burst follows a changed max, as with getEnvAsRateLimit.
+func getEnvAsRateLimitFrom(prefix string, base RateLimit) RateLimit {
+	maxReq := getEnvAsInt(prefix+"_MAX", base.Max)
+	burst := base.Burst
+	if maxReq != base.Max {
+		burst = maxReq
+	}
+	return RateLimit{
+		Algorithm:  strings.ToLower(getEnv(prefix+"_ALGORITHM", base.Algorithm)),
+		Max:        maxReq,
+		Window:     getEnvAsDuration(prefix+"_WINDOW", base.Window),
+		Burst:      getEnvAsInt(prefix+"_BURST", burst),
+		MaxPenalty: getEnvAsDuration(prefix+"_MAX_PENALTY", base.MaxPenalty),
+	}
+}
+
+// getEnvAsMap reads a comma-separated list of key:value pairs. Items without a colon are a
+// problem, since they are almost certainly a typo in a secret.
+func getEnvAsMap(key string, defaultValue map[string]string) map[string]string {
+	return getEnvAsPairs(key, ":", defaultValue)
+}
+
+// getEnvAsPairs reads a comma-separated list of pairs split at the first sep, for keys
+// that contain colons themselves.
+func getEnvAsPairs(key, sep string, defaultValue map[string]string) map[string]string {
+	items := getEnvAsSlice(key, nil)
+	if items == nil {
+		return defaultValue
+	}
+	values := make(map[string]string, len(items))
+	for _, item := range items {
+		k, v, ok := strings.Cut(item, sep)
+		if k, v = strings.TrimSpace(k), strings.TrimSpace(v); !ok || k == "" || v == "" {
+			addProblem("%s must be a comma-separated list of key%svalue pairs", key, sep)
+			continue
+		}
+		values[k] = v
+	}
+	return values
+}
+
+// getEnvAsLists reads a comma-separated list of key=value pairs whose values are lists
+// separated by |.
+func getEnvAsLists(key string) map[string][]string {
+	pairs := getEnvAsPairs(key, "=", nil)
+	lists := make(map[string][]string, len(pairs))
+	for k, v := range pairs {
+		for _, item := range strings.Split(v, "|") {
+			if item = strings.TrimSpace(item); item != "" {
+				lists[k] = append(lists[k], item)
+			}
+		}
+	}
+	return lists
+}
+
+// getEnvAsDurations reads a comma-separated list of key=duration pairs.
+func getEnvAsDurations(key string) map[string]time.Duration {
+	pairs := getEnvAsPairs(key, "=", nil)
+	durations := make(map[string]time.Duration, len(pairs))
+	for k, v := range pairs {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			addProblem("%s must give %s a duration like 30s, got '%s'", key, k, v)
+			continue
+		}
+		durations[k] = d
+	}
+	return durations
+}
+
+// getEnvAsFeatureFlags reads feature flags in the format described at FeatureFlag.
+func getEnvAsFeatureFlags(key string) map[string]FeatureFlag {
+	specs := getEnvAsPairs(key, "=", nil)
+	flags := make(map[string]FeatureFlag, len(specs))
+	for name, spec := range specs {
+		var flag FeatureFlag
+		for _, rule := range strings.Split(spec, "|") {
+			rule = strings.TrimSpace(rule)
+			switch {
+			case rule == "on":
+				flag.Enabled = true
+			case rule == "off":
+			case strings.HasPrefix(rule, "tenant:") && len(rule) > len("tenant:"):
+				flag.Tenants = append(flag.Tenants, strings.TrimPrefix(rule, "tenant:"))
+			case strings.HasSuffix(rule, "%"):
+				percentage, err := strconv.Atoi(strings.TrimSuffix(rule, "%"))
+				if err != nil || percentage < 0 || percentage > 100 {
+					addProblem("%s must give %s a percentage between 0%% and 100%%, got '%s'", key, name, rule)
+				}
+				flag.Percentage = percentage
+			default:
+				addProblem("%s must give %s rules of on, off, a percentage like 10%% or tenant:<tenant>, got '%s'", key, name, rule)
+			}
+		}
+		flags[name] = flag
+	}
+	return flags
+}
+
+// validSenderID reports whether id can be an SMS originator: alphanumeric of up to 11
+// characters, or numeric of up to 16 digits. Empty leaves it to the provider.
+func validSenderID(id string) bool {
+	digits := strings.TrimPrefix(id, "+")
+	if digits != "" && len(digits) <= 16 && strings.Trim(digits, "0123456789") == "" {
+		return true
+	}
+	if len(id) > 11 {
+		return false
+	}
+	for _, r := range id {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// getEnvAsCountryCodes reads a comma-separated list of calling codes, with or without "+".
+func getEnvAsCountryCodes(key string) []int {
+	var codes []int
+	for _, v := range getEnvAsSlice(key, nil) {
+		code, err := strconv.Atoi(strings.TrimPrefix(v, "+"))
+		if err != nil || !phone.ValidCountryCode(code) {
+			addProblem("%s must be a comma-separated list of country calling codes such as '98,1', got '%s'", key, v)
+			continue
+		}
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+// getEnvAsSlice reads a comma-separated list, trimming whitespace and dropping empty items.
+func getEnvAsSlice(key string, defaultValue []string) []string {
+	valueStr := getEnv(key, "")
+	if valueStr == "" {
+		return defaultValue
+	}
+	var values []string
+	for _, v := range strings.Split(valueStr, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
 }
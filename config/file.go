@@ -0,0 +1,109 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFile is read when CONFIG_FILE isn't set, if it exists.
+const defaultConfigFile = "config.yaml"
+
+// loadFile reads a YAML (.yaml, .yml) or TOML (.toml) config file and returns its settings
+// keyed by the environment variables they stand for. The top-level sections (server, storage,
+// jwt, ...) only group the settings; below them, nested keys are joined with "_" and
+// upper-cased, so rate_limits.otp_send.max is OTP_SEND_MAX. Lists become comma-separated values.
+func loadFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var sections map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &sections)
+	case ".toml":
+		err = toml.Unmarshal(data, &sections)
+	default:
+		return nil, fmt.Errorf("unsupported config file extension %q, use .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for section, settings := range sections {
+		settingsMap, ok := settings.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("top-level key %q must be a section of settings", section)
+		}
+		if err := flatten("", settingsMap, values); err != nil {
+			return nil, fmt.Errorf("section %q: %w", section, err)
+		}
+	}
+	return values, nil
+}
+
+// flatten adds the leaves of settings to values, keyed by their upper-cased path.
+func flatten(prefix string, settings map[string]interface{}, values map[string]string) error {
+	for key, value := range settings {
+		name := strings.ToUpper(prefix + key)
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if err := flatten(name+"_", v, values); err != nil {
+				return err
+			}
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[name] = strings.Join(items, ",")
+		case nil:
+			values[name] = ""
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
+
+// applyFile exports the settings of the config file that aren't set in the environment,
+// so environment variables (and .env) take precedence over the file.
+// It returns the names of the settings taken from the file.
+func applyFile(values map[string]string) []string {
+	var applied []string
+	for name, value := range values {
+		if _, exists := os.LookupEnv(name); exists {
+			continue
+		}
+		os.Setenv(name, value)
+		applied = append(applied, name)
+	}
+	slices.Sort(applied)
+	fileApplied = applied
+	return applied
+}
+
+// fileApplied are the settings the config file exported last, which Reload takes back
+// before reading the file again.
+var fileApplied []string
+
+// unusedKeys returns the settings of the config file no part of the configuration read,
+// most likely typos.
+func unusedKeys(values map[string]string, read map[string]bool) []string {
+	var unused []string
+	for name := range values {
+		if !read[name] {
+			unused = append(unused, name)
+		}
+	}
+	slices.Sort(unused)
+	return unused
+}
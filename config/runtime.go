@@ -0,0 +1,123 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// Runtime holds the settings that can change without a restart: edit the config file
+// and send the process SIGHUP to apply them.
+type Runtime struct {
+	LogLevel         string
+	OTPSendRateLimit RateLimit
+	// OTPChannelSendRateLimits limit the sends per channel (OTP_SEND_SMS_*, OTP_SEND_VOICE_*,
+	// ...) below OTPSendRateLimit, the ceiling across all channels. They default to it.
+	OTPChannelSendRateLimits map[string]RateLimit
+	OTPVerifyRateLimit       RateLimit
+	IPRateLimit              RateLimit
+	OTPAllowedCountryCodes   []int
+	OTPBlockedCountryCodes   []int
+	SMSProvider              string
+	// MaintenanceMode pauses sign-ins with 503, showing MaintenanceMessage to clients.
+	MaintenanceMode    bool
+	MaintenanceMessage string
+	FeatureFlags       map[string]FeatureFlag
+}
+
+// Runtime returns the runtime-tunable part of the configuration.
+func (c *Config) Runtime() Runtime {
+	return Runtime{
+		LogLevel:                 c.LogLevel,
+		OTPSendRateLimit:         c.OTPSendRateLimit,
+		OTPChannelSendRateLimits: c.OTPChannelSendRateLimits,
+		OTPVerifyRateLimit:       c.OTPVerifyRateLimit,
+		IPRateLimit:              c.IPRateLimit,
+		OTPAllowedCountryCodes:   c.OTPAllowedCountryCodes,
+		OTPBlockedCountryCodes:   c.OTPBlockedCountryCodes,
+		SMSProvider:              c.SMSProvider,
+		MaintenanceMode:          c.MaintenanceMode,
+		MaintenanceMessage:       c.MaintenanceMessage,
+		FeatureFlags:             c.FeatureFlags,
+	}
+}
+
+// Reload reads the config file again and returns the runtime settings, with the same
+// precedence as LoadConfig. Unlike LoadConfig it reports invalid settings as an error,
+// so a bad edit leaves the running configuration in place instead of stopping the service.
+func Reload() (Runtime, error) {
+	values, err := readConfigFile()
+	if err != nil {
+		return Runtime{}, err
+	}
+
+	// Settings removed from the file must fall back to their defaults, so the ones the file
+	// exported last time are taken back first; the environment set by the operator stays.
+	for _, name := range fileApplied {
+		os.Unsetenv(name)
+	}
+	fileApplied = nil
+	applyConfigFile(values)
+
+	rt := runtimeFromEnv()
+	return rt, takeProblems()
+}
+
+// runtimeFromEnv reads the runtime settings, recording the invalid ones as problems.
+func runtimeFromEnv() Runtime {
+	rt := Runtime{
+		LogLevel:               strings.ToLower(getEnv("LOG_LEVEL", "info")),
+		OTPSendRateLimit:       getEnvAsRateLimit("OTP_SEND", 3, 2*time.Minute, time.Hour),
+		OTPVerifyRateLimit:     getEnvAsRateLimit("OTP_VERIFY", 5, 10*time.Minute, 0),
+		IPRateLimit:            getEnvAsRateLimit("IP_RATE_LIMIT", 20, 10*time.Minute, 0),
+		OTPAllowedCountryCodes: getEnvAsCountryCodes("OTP_ALLOWED_COUNTRY_CODES"),
+		OTPBlockedCountryCodes: getEnvAsCountryCodes("OTP_BLOCKED_COUNTRY_CODES"),
+		SMSProvider:            strings.ToLower(getEnv("SMS_PROVIDER", "console")),
+		MaintenanceMode:        getEnvAsBool("MAINTENANCE_MODE", false),
+		MaintenanceMessage:     getEnv("MAINTENANCE_MESSAGE", ""),
+		FeatureFlags:           getEnvAsFeatureFlags("FEATURE_FLAGS"),
+	}
+	rt.OTPChannelSendRateLimits = make(map[string]RateLimit, len(otpChannels))
+	for _, channel := range otpChannels {
+		rt.OTPChannelSendRateLimits[channel] = getEnvAsRateLimitFrom(otpChannelPrefix(channel), rt.OTPSendRateLimit)
+	}
+	rt.validate()
+	return rt
+}
+
+// otpChannelPrefix is the prefix of the variables configuring the send limit of an OTP channel.
+func otpChannelPrefix(channel string) string {
+	return "OTP_SEND_" + strings.ToUpper(channel)
+}
+
+func (r Runtime) validate() {
+	validateRateLimit("OTP_SEND", r.OTPSendRateLimit)
+	for _, channel := range otpChannels {
+		validateRateLimit(otpChannelPrefix(channel), r.OTPChannelSendRateLimits[channel])
+	}
+	validateRateLimit("OTP_VERIFY", r.OTPVerifyRateLimit)
+	validateRateLimit("IP_RATE_LIMIT", r.IPRateLimit)
+
+	if r.SMSProvider != "console" {
+		addProblem("SMS_PROVIDER must be 'console', got '%s'", r.SMSProvider)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(r.LogLevel)); err != nil {
+		addProblem("LOG_LEVEL must be 'debug', 'info', 'warn' or 'error', got '%s'", r.LogLevel)
+	}
+}
+
+// validateRateLimit records the problems of the rate limiter configured by the <name>_* variables.
+func validateRateLimit(name string, rl RateLimit) {
+	if rl.Algorithm != "sliding_window" && rl.Algorithm != "token_bucket" {
+		addProblem("%s_ALGORITHM must be 'sliding_window' or 'token_bucket', got '%s'", name, rl.Algorithm)
+	}
+	if rl.Max <= 0 || rl.Window <= 0 || rl.Burst <= 0 {
+		addProblem("%s_MAX, %s_WINDOW and %s_BURST must be positive", name, name, name)
+	}
+	if rl.MaxPenalty < 0 || (rl.MaxPenalty > 0 && rl.MaxPenalty < rl.Window) {
+		addProblem("%s_MAX_PENALTY must be 0 (disabled) or at least %s_WINDOW", name, name)
+	}
+}
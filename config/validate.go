@@ -0,0 +1,56 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// minJWTSecretLength is the shortest HS256 secret accepted: 256 bits, the size of the hash.
+const minJWTSecretLength = 32
+
+// problems collects the invalid settings found while reading the configuration,
+// so they can be reported all at once.
+var problems []string
+
+func addProblem(format string, args ...interface{}) {
+	problems = append(problems, fmt.Sprintf(format, args...))
+}
+
+// takeProblems returns the problems found so far as one error, or nil, and forgets them.
+func takeProblems() error {
+	if len(problems) == 0 {
+		return nil
+	}
+	err := fmt.Errorf("invalid configuration (%d problems):\n  - %s", len(problems), strings.Join(problems, "\n  - "))
+	problems = nil
+	return err
+}
+
+// validatePort records a problem unless port is empty or a valid TCP port number.
+func validatePort(name, port string) {
+	if port == "" {
+		return
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		addProblem("%s must be a port number, got '%s'", name, port)
+	}
+}
+
+// validateDSN checks that a Postgres connection string is either a postgres:// URL
+// or a list of key=value pairs, the two forms lib/pq accepts.
+func validateDSN(dsn string) error {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		_, err := pq.ParseURL(dsn)
+		return err
+	}
+	for _, field := range strings.Fields(dsn) {
+		if key, _, ok := strings.Cut(field, "="); !ok || key == "" {
+			return errors.New("must be a postgres:// URL or key=value pairs")
+		}
+	}
+	return nil
+}
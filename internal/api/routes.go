@@ -1,56 +1,310 @@
 package api
 
 import (
+	"net/http/pprof"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/graph"
+	"github.com/ebipenman/go-otp-auth-service/internal/health"
+	"github.com/ebipenman/go-otp-auth-service/internal/metrics"
 	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/pkg/apikey"
 	"github.com/ebipenman/go-otp-auth-service/pkg/auth"
+	"github.com/ebipenman/go-otp-auth-service/pkg/deadletter"
+	"github.com/ebipenman/go-otp-auth-service/pkg/device"
+	"github.com/ebipenman/go-otp-auth-service/pkg/email"
+	"github.com/ebipenman/go-otp-auth-service/pkg/export"
+	"github.com/ebipenman/go-otp-auth-service/pkg/featureflag"
+	"github.com/ebipenman/go-otp-auth-service/pkg/federation"
+	"github.com/ebipenman/go-otp-auth-service/pkg/loginhistory"
+	"github.com/ebipenman/go-otp-auth-service/pkg/loglevel"
+	"github.com/ebipenman/go-otp-auth-service/pkg/maintenance"
+	"github.com/ebipenman/go-otp-auth-service/pkg/merge"
+	"github.com/ebipenman/go-otp-auth-service/pkg/oidc"
+	"github.com/ebipenman/go-otp-auth-service/pkg/privacy"
+	"github.com/ebipenman/go-otp-auth-service/pkg/quota"
+	"github.com/ebipenman/go-otp-auth-service/pkg/social"
+	"github.com/ebipenman/go-otp-auth-service/pkg/stats"
 	"github.com/ebipenman/go-otp-auth-service/pkg/user"
 
 	"github.com/gin-gonic/gin"
 )
 
 func SetupRoutes(
-	router *gin.Engine,
+	router gin.IRouter,
 	authHandler *auth.Handler,
 	userHandler *user.Handler,
-	jwtSecret string,
-	otpRateLimiter middleware.RateLimiterStore,
+	deviceHandler *device.Handler,
+	loginHandler *loginhistory.Handler,
+	exportHandler *export.Handler,
+	graphHandler *graph.Handler,
+	healthHandler *health.Handler,
+	apiKeys middleware.APIKeyAuthenticator,
+	tokens middleware.TokenValidator,
+	revocations middleware.TokenRevocations,
+	ipRateLimiter middleware.RateLimiterStore,
+	signedRequestAuth gin.HandlerFunc,
+	maintenanceGate gin.HandlerFunc,
+	reverifyAfter time.Duration,
 ) {
 	// Public routes (no authentication required)
 	public := router.Group("/")
 	{
-		// Health check endpoint
-		public.GET("/health", func(c *gin.Context) {
-			c.JSON(200, gin.H{"status": "UP"})
-		})
+		// Kubernetes probes: /livez only checks the process, /readyz its dependencies.
+		public.GET("/livez", healthHandler.Livez)
+		public.GET("/readyz", healthHandler.Readyz)
+		// Deprecated: kept for existing health checks, same as /livez.
+		public.GET("/health", healthHandler.Livez)
 	}
 
 	// Authentication routes
 	authRoutes := router.Group("/otp")
+	// Trusted backends may sign their requests or use an API key; they skip the per-IP limit.
+	authRoutes.Use(
+		signedRequestAuth,
+		middleware.APIKeyAuth(apiKeys, apikey.ScopeOTP, false),
+		middleware.IPRateLimiter(ipRateLimiter),
+	)
+	{
+		// Per phone number rate limiting is enforced by the auth service itself. Sign-ins
+		// pause in maintenance mode.
+		authRoutes.POST("/send", maintenanceGate, authHandler.SendOTP)
+		authRoutes.POST("/send-with-password", maintenanceGate, authHandler.SendPasswordOTP)
+		// A guest token is optional; with one, the guest is upgraded to the verified user.
+		authRoutes.POST("/verify", maintenanceGate, middleware.GuestAuthMiddleware(tokens, revocations), authHandler.VerifyOTP)
+		authRoutes.POST("/device-login", maintenanceGate, authHandler.DeviceLogin)
+	}
+
+	// Clients poll the delivery of an OTP until it arrives, so the polls stay out of the per-IP
+	// limit that /otp/send and /otp/verify share; the random delivery IDs can't be guessed. The
+	// deliveries of OTPs sent before can still be watched in maintenance mode.
+	deliveryRoutes := router.Group("/otp/deliveries")
+	deliveryRoutes.Use(
+		signedRequestAuth,
+		middleware.APIKeyAuth(apiKeys, apikey.ScopeOTP, false),
+	)
+	{
+		deliveryRoutes.GET("/:id", authHandler.GetDelivery)
+		deliveryRoutes.GET("/:id/events", authHandler.WatchDelivery)
+	}
+
+	// GraphQL endpoint. The token is optional here: the OTP mutations are public,
+	// while the user queries check for the authenticated user themselves.
+	router.POST("/graphql", signedRequestAuth, maintenanceGate, middleware.OptionalAuthMiddleware(tokens, revocations), graphHandler.Serve)
+
+	// User management endpoints, for users (JWT) and machine clients (API key with users:read)
+	userRoutes := router.Group("/users")
+	userRoutes.Use(
+		middleware.APIKeyAuth(apiKeys, apikey.ScopeUsersRead, false),
+		middleware.AuthMiddleware(tokens, revocations),
+		middleware.RequireRecentVerification(reverifyAfter),
+	)
 	{
-		authRoutes.POST("/send", middleware.OTPRateLimiter(otpRateLimiter), authHandler.SendOTP)
-		authRoutes.POST("/verify", authHandler.VerifyOTP)
+		userRoutes.GET("", userHandler.ListUsers)
+		userRoutes.GET("/:id", userHandler.GetUserByID)
+		userRoutes.POST("/batch-get", userHandler.GetUsersByIDs)
+		// Add other user management routes here (e.g., PUT, DELETE) if needed
 	}
 
-	// Protected routes (JWT authentication required)
+	// Users whose phone verification went stale re-verify here to use the protected routes again.
+	router.POST("/me/reverify", maintenanceGate, middleware.AuthMiddleware(tokens, revocations), authHandler.ReverifyMe)
+
+	// Protected routes (JWT authentication and a recent phone verification required)
 	protected := router.Group("/")
-	protected.Use(middleware.AuthMiddleware(jwtSecret))
-	{
-		// User management endpoints
-		userRoutes := protected.Group("/users")
-		{
-			userRoutes.GET("", userHandler.ListUsers)
-			userRoutes.GET("/:id", userHandler.GetUserByID)
-			// Add other user management routes here (e.g., PUT, DELETE) if needed
-		}
-
-		// Example of a protected endpoint that uses the user from context
-		protected.GET("/me", func(c *gin.Context) {
-			user, exists := c.Get(middleware.ContextKeyUser)
-			if !exists {
-				c.JSON(401, gin.H{"error": "User not found in context"})
-				return
-			}
-			c.JSON(200, user)
+	protected.Use(
+		middleware.AuthMiddleware(tokens, revocations),
+		middleware.RequireRecentVerification(reverifyAfter),
+	)
+	{
+		protected.GET("/me", userHandler.GetMe)
+		protected.PATCH("/me", userHandler.UpdateMe)
+		protected.DELETE("/me", authHandler.DeleteMe)
+		protected.PUT("/me/password", authHandler.SetMyPassword)
+		protected.GET("/me/phones", userHandler.ListMyPhones)
+		// Adding a phone number sends it an OTP, so it pauses in maintenance mode too.
+		protected.POST("/me/phones", maintenanceGate, authHandler.AddMyPhone)
+		protected.POST("/me/phones/verify", authHandler.VerifyMyPhone)
+		protected.POST("/me/phones/:id/primary", userHandler.SetPrimaryPhone)
+		protected.DELETE("/me/phones/:id", userHandler.RemoveMyPhone)
+		protected.GET("/me/devices", deviceHandler.ListMyDevices)
+		protected.DELETE("/me/devices/:id/trust", deviceHandler.RevokeDeviceTrust)
+		protected.GET("/me/logins", loginHandler.ListMyLogins)
+		protected.POST("/me/exports", exportHandler.RequestExport)
+		protected.GET("/me/exports/:id", exportHandler.GetExport)
+		protected.GET("/me/exports/:id/download", exportHandler.DownloadExport)
+	}
+}
+
+// SetupAdminRoutes registers the admin endpoints. They are served either by the main router
+// or, when an admin listener is configured, only by the admin router behind mutual TLS.
+func SetupAdminRoutes(
+	router gin.IRouter,
+	apiKeyHandler *apikey.Handler,
+	loginHandler *loginhistory.Handler,
+	privacyHandler *privacy.Handler,
+	mergeHandler *merge.Handler,
+	statsHandler *stats.Handler,
+	quotaHandler *quota.Handler,
+	deadLetterHandler *deadletter.Handler,
+	authHandler *auth.Handler,
+	maintenanceHandler *maintenance.Handler,
+	featureFlagHandler *featureflag.Handler,
+	logLevelHandler *loglevel.Handler,
+	apiKeys middleware.APIKeyAuthenticator,
+) {
+	// Admin routes (API key with the admin scope required)
+	adminRoutes := router.Group("/admin")
+	adminRoutes.Use(middleware.APIKeyAuth(apiKeys, apikey.ScopeAdmin, true))
+	{
+		adminRoutes.POST("/api-keys", apiKeyHandler.CreateAPIKey)
+		adminRoutes.GET("/api-keys", apiKeyHandler.ListAPIKeys)
+		adminRoutes.DELETE("/api-keys/:id", apiKeyHandler.RevokeAPIKey)
+		adminRoutes.GET("/users/:id/logins", loginHandler.ListUserLogins)
+		adminRoutes.POST("/users/:id/anonymize", privacyHandler.AnonymizeUser)
+		adminRoutes.POST("/users/:id/merge", mergeHandler.MergeUsers)
+		adminRoutes.GET("/users/:id/merges", mergeHandler.ListMerges)
+		adminRoutes.POST("/users/:id/logout", authHandler.ForceLogout)
+		adminRoutes.POST("/users/bulk", authHandler.BulkUpdateUsers)
+		adminRoutes.GET("/stats", statsHandler.GetStats)
+		adminRoutes.GET("/analytics/users", statsHandler.GetUserGrowth)
+		adminRoutes.GET("/otp-quota", quotaHandler.GetUsage)
+		adminRoutes.DELETE("/otps/:phone", authHandler.InvalidateOTP)
+		adminRoutes.GET("/otp-dead-letters", deadLetterHandler.ListDeadLetters)
+		adminRoutes.POST("/otp-dead-letters/:id/retry", deadLetterHandler.RetryDeadLetter)
+		adminRoutes.GET("/maintenance", maintenanceHandler.GetStatus)
+		adminRoutes.PUT("/maintenance", maintenanceHandler.SetStatus)
+		adminRoutes.GET("/feature-flags", featureFlagHandler.ListFlags)
+		adminRoutes.GET("/log-level", logLevelHandler.GetLevel)
+		adminRoutes.PUT("/log-level", logLevelHandler.SetLevel)
+		adminRoutes.DELETE("/log-level", logLevelHandler.ResetLevel)
+		adminRoutes.GET("/metrics", metrics.Handler())
+	}
+}
+
+// SetupOAuthRoutes registers the OAuth2 token endpoint of machine clients. It shares the
+// per-IP limit of the OTP endpoints, which is enough to stop guessing at client secrets.
+func SetupOAuthRoutes(
+	router gin.IRouter,
+	apiKeyHandler *apikey.Handler,
+	ipRateLimiter middleware.RateLimiterStore,
+) {
+	router.POST("/oauth/token", middleware.IPRateLimiter(ipRateLimiter), apiKeyHandler.Token)
+}
+
+// SetupSocialRoutes registers the social login endpoints. A JWT is optional; with one, the
+// provider account is linked to its user. Nonces fill the store until they expire, so they
+// and the sign-ins share the per-IP limit of the OTP endpoints. Sign-ins pause in
+// maintenance mode.
+func SetupSocialRoutes(
+	router gin.IRouter,
+	socialHandler *social.Handler,
+	tokens middleware.TokenValidator,
+	revocations middleware.TokenRevocations,
+	ipRateLimiter middleware.RateLimiterStore,
+	maintenanceGate gin.HandlerFunc,
+) {
+	router.POST("/auth/social/:provider/nonce", middleware.IPRateLimiter(ipRateLimiter), socialHandler.Nonce)
+	router.POST("/auth/social/:provider",
+		maintenanceGate,
+		middleware.IPRateLimiter(ipRateLimiter),
+		middleware.OptionalAuthMiddleware(tokens, revocations),
+		socialHandler.SignIn,
+	)
+}
+
+// SetupEmailRoutes registers the email verification endpoints of users, behind the same
+// checks as the other /me routes, and the account recovery endpoints. Recovery sign-ins and
+// codes share the per-IP limit of the OTP endpoints. Sending codes and recovery sign-ins
+// pause in maintenance mode.
+func SetupEmailRoutes(
+	router gin.IRouter,
+	emailHandler *email.Handler,
+	tokens middleware.TokenValidator,
+	revocations middleware.TokenRevocations,
+	ipRateLimiter middleware.RateLimiterStore,
+	maintenanceGate gin.HandlerFunc,
+	reverifyAfter time.Duration,
+) {
+	me := router.Group("/me/email")
+	me.Use(
+		middleware.AuthMiddleware(tokens, revocations),
+		middleware.RequireRecentVerification(reverifyAfter),
+	)
+	{
+		me.POST("/verification", maintenanceGate, emailHandler.SendVerification)
+		me.POST("/verify", emailHandler.Verify)
+	}
+
+	recovery := router.Group("/auth/email-recovery")
+	recovery.Use(maintenanceGate, middleware.IPRateLimiter(ipRateLimiter))
+	{
+		recovery.POST("/send", emailHandler.SendRecoveryCode)
+		recovery.POST("/verify", emailHandler.Recover)
+	}
+}
+
+// SetupGuestRoutes registers the endpoint starting guest sessions. Guests are free to create,
+// so it shares the per-IP limit of the OTP endpoints. It pauses in maintenance mode, like
+// the sign-ins.
+func SetupGuestRoutes(
+	router gin.IRouter,
+	authHandler *auth.Handler,
+	ipRateLimiter middleware.RateLimiterStore,
+	maintenanceGate gin.HandlerFunc,
+) {
+	router.POST("/auth/guest", maintenanceGate, middleware.IPRateLimiter(ipRateLimiter), authHandler.CreateGuest)
+}
+
+// SetupFederationRoutes registers the single sign-on endpoints of the upstream identity
+// provider. Starting logins fills memory until they expire, so it shares the per-IP limit.
+// Both pause in maintenance mode.
+func SetupFederationRoutes(
+	router gin.IRouter,
+	federationHandler *federation.Handler,
+	ipRateLimiter middleware.RateLimiterStore,
+	maintenanceGate gin.HandlerFunc,
+) {
+	router.GET("/sso/login", maintenanceGate, middleware.IPRateLimiter(ipRateLimiter), federationHandler.Login)
+	router.GET("/sso/callback", maintenanceGate, federationHandler.Callback)
+}
+
+// SetupOIDCRoutes registers the OpenID Connect provider endpoints. Posting the sign-in page
+// sends OTPs and /token checks client secrets and authorization codes, so both share the
+// per-IP limit of the OTP endpoints. /userinfo only accepts the access tokens of /token
+// (accessTokens), which relying parties can't use elsewhere. Posting the sign-in page and
+// issuing tokens pause in maintenance mode.
+func SetupOIDCRoutes(
+	router gin.IRouter,
+	oidcHandler *oidc.Handler,
+	accessTokens middleware.TokenValidator,
+	revocations middleware.TokenRevocations,
+	ipRateLimiter middleware.RateLimiterStore,
+	maintenanceGate gin.HandlerFunc,
+) {
+	router.GET("/.well-known/openid-configuration", oidcHandler.Discovery)
+	router.GET("/.well-known/jwks.json", oidcHandler.JWKS)
+	router.GET("/authorize", oidcHandler.Authorize)
+	router.POST("/authorize", maintenanceGate, middleware.IPRateLimiter(ipRateLimiter), oidcHandler.Authorize)
+	router.POST("/token", maintenanceGate, middleware.IPRateLimiter(ipRateLimiter), oidcHandler.Token)
+	router.GET("/userinfo", middleware.AuthMiddleware(accessTokens, revocations), oidcHandler.UserInfo)
+	router.POST("/userinfo", middleware.AuthMiddleware(accessTokens, revocations), oidcHandler.UserInfo)
+}
+
+// SetupProfilingRoutes registers the net/http/pprof profiles below /admin/debug/pprof, behind
+// the same API key as the admin endpoints, e.g. for go tool pprof against a production instance.
+func SetupProfilingRoutes(router gin.IRouter, apiKeys middleware.APIKeyAuthenticator) {
+	profiles := router.Group("/admin/debug/pprof")
+	profiles.Use(middleware.APIKeyAuth(apiKeys, apikey.ScopeAdmin, true))
+	{
+		profiles.GET("/", gin.WrapF(pprof.Index))
+		profiles.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+		profiles.GET("/profile", gin.WrapF(pprof.Profile))
+		profiles.GET("/symbol", gin.WrapF(pprof.Symbol))
+		profiles.POST("/symbol", gin.WrapF(pprof.Symbol))
+		profiles.GET("/trace", gin.WrapF(pprof.Trace))
+		// The other profiles by name: heap, goroutine, allocs, block, mutex and threadcreate.
+		profiles.GET("/:name", func(c *gin.Context) {
+			pprof.Handler(c.Param("name")).ServeHTTP(c.Writer, c.Request)
 		})
 	}
 }
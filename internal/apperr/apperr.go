@@ -0,0 +1,89 @@
+// Package apperr holds the domain errors of the services. Each carries the HTTP status and the
+// stable i18n code clients get for it, so handlers pass errors on with c.Error and
+// middleware.ErrorHandler answers them, instead of every handler matching errors itself.
+package apperr
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+)
+
+// Error is a domain error. Two errors are the same for errors.Is when their codes are, so
+// the sentinels below match the errors derived from them with Wrap and WithParams.
+type Error struct {
+	Status int
+	Code   string
+	Params i18n.Params
+	// Fields are added to the error body next to the code, e.g. retry_after.
+	Fields map[string]any
+	// Err is the cause, for the logs; clients only get the code and its message.
+	Err error
+}
+
+// New creates a domain error answered with status and code.
+func New(status int, code string) *Error {
+	return &Error{Status: status, Code: code}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Code + ": " + e.Err.Error()
+	}
+	return e.Code
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Wrap returns a copy of e caused by err.
+func (e *Error) Wrap(err error) *Error {
+	wrapped := *e
+	wrapped.Err = err
+	return &wrapped
+}
+
+// WithParams returns a copy of e with the parameters of its message.
+func (e *Error) WithParams(params i18n.Params) *Error {
+	withParams := *e
+	withParams.Params = params
+	return &withParams
+}
+
+// WithFields returns a copy of e with fields added to its body.
+func (e *Error) WithFields(fields map[string]any) *Error {
+	withFields := *e
+	withFields.Fields = make(map[string]any, len(e.Fields)+len(fields))
+	maps.Copy(withFields.Fields, e.Fields)
+	maps.Copy(withFields.Fields, fields)
+	return &withFields
+}
+
+// As returns the domain error in err's chain and whether there is one.
+func As(err error) (*Error, bool) {
+	var e *Error
+	ok := errors.As(err, &e)
+	return e, ok
+}
+
+var (
+	ErrInvalidRequest    = New(http.StatusBadRequest, i18n.CodeInvalidRequest)
+	ErrInvalidUserID     = New(http.StatusBadRequest, i18n.CodeInvalidUserID)
+	ErrInvalidPhoneID    = New(http.StatusBadRequest, i18n.CodeInvalidPhoneID)
+	ErrInvalidPagination = New(http.StatusBadRequest, i18n.CodeInvalidPagination)
+	ErrInvalidFields     = New(http.StatusBadRequest, i18n.CodeInvalidFields)
+	ErrInvalidDeliveryID = New(http.StatusBadRequest, i18n.CodeInvalidDeliveryID)
+	ErrAuthRequired      = New(http.StatusUnauthorized, i18n.CodeAuthRequired)
+	ErrUserNotFound      = New(http.StatusNotFound, i18n.CodeUserNotFound)
+	ErrPhoneNotFound     = New(http.StatusNotFound, i18n.CodePhoneNotFound)
+	// ErrInternal is what every error without a domain error in its chain is answered with.
+	ErrInternal = New(http.StatusInternalServerError, i18n.CodeInternal)
+)
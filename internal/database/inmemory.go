@@ -1,11 +1,19 @@
 package database
 
 import (
+	"bytes"
+	"cmp"
+	"container/list"
+	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/ebipenman/go-otp-auth-service/internal/metrics"
 	"github.com/ebipenman/go-otp-auth-service/internal/model"
 
 	"github.com/google/uuid"
@@ -19,7 +27,12 @@ var (
 // In-memory User Store
 type InMemoryUserStore struct {
 	users      map[uuid.UUID]model.User
-	phoneIndex map[string]uuid.UUID // For fast lookup by phone number
+	phoneIndex map[string]uuid.UUID       // For fast lookup by phone number
+	deleted    map[uuid.UUID]model.User   // Kept until they're anonymized
+	phones     map[string]model.UserPhone // Secondary phone numbers, keyed by number
+	emailOTPs  map[uuid.UUID]model.EmailOTP
+	passwords  map[uuid.UUID]string // Encoded password hashes
+	merges     []model.UserMerge
 	mu         sync.RWMutex
 }
 
@@ -27,10 +40,14 @@ func NewInMemoryUserStore() *InMemoryUserStore {
 	return &InMemoryUserStore{
 		users:      make(map[uuid.UUID]model.User),
 		phoneIndex: make(map[string]uuid.UUID),
+		deleted:    make(map[uuid.UUID]model.User),
+		phones:     make(map[string]model.UserPhone),
+		emailOTPs:  make(map[uuid.UUID]model.EmailOTP),
+		passwords:  make(map[uuid.UUID]string),
 	}
 }
 
-func (s *InMemoryUserStore) CreateUser(user model.User) (model.User, error) {
+func (s *InMemoryUserStore) CreateUser(ctx context.Context, user model.User) (model.User, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -38,7 +55,12 @@ func (s *InMemoryUserStore) CreateUser(user model.User) (model.User, error) {
 		return model.User{}, fmt.Errorf("%w: user with phone number %s", ErrAlreadyExists, user.PhoneNumber)
 	}
 
-	user.ID = uuid.New()
+	// A preset ID is kept, e.g. that of an upgraded guest.
+	if user.ID == uuid.Nil {
+		user.ID = uuid.New()
+	} else if _, exists := s.users[user.ID]; exists {
+		return model.User{}, fmt.Errorf("%w: user with ID %s", ErrAlreadyExists, user.ID)
+	}
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = time.Now()
 	s.users[user.ID] = user
@@ -46,7 +68,7 @@ func (s *InMemoryUserStore) CreateUser(user model.User) (model.User, error) {
 	return user, nil
 }
 
-func (s *InMemoryUserStore) GetUserByID(id uuid.UUID) (model.User, error) {
+func (s *InMemoryUserStore) GetUserByID(ctx context.Context, id uuid.UUID) (model.User, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	user, ok := s.users[id]
@@ -56,7 +78,7 @@ func (s *InMemoryUserStore) GetUserByID(id uuid.UUID) (model.User, error) {
 	return user, nil
 }
 
-func (s *InMemoryUserStore) GetUserByPhoneNumber(phoneNumber string) (model.User, error) {
+func (s *InMemoryUserStore) GetUserByPhoneNumber(ctx context.Context, phoneNumber string) (model.User, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	id, ok := s.phoneIndex[phoneNumber]
@@ -70,7 +92,20 @@ func (s *InMemoryUserStore) GetUserByPhoneNumber(phoneNumber string) (model.User
 	return user, nil
 }
 
-func (s *InMemoryUserStore) ListUsers(limit, offset int, search string) ([]model.User, int, error) {
+func (s *InMemoryUserStore) GetUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]model.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := []model.User{}
+	for _, id := range ids {
+		if user, ok := s.users[id]; ok {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (s *InMemoryUserStore) ListUsers(ctx context.Context, limit, offset int, search string) ([]model.User, int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -94,41 +129,777 @@ func (s *InMemoryUserStore) ListUsers(limit, offset int, search string) ([]model
 	return filteredUsers[offset:end], total, nil
 }
 
-// In-memory OTP Store
+func (s *InMemoryUserStore) UpdateUser(ctx context.Context, user model.User) (model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.users[user.ID]
+	if !ok {
+		return model.User{}, fmt.Errorf("%w: user with ID %s", ErrNotFound, user.ID)
+	}
+	stored.Name = user.Name
+	if stored.Email != user.Email {
+		stored.EmailVerifiedAt = nil
+	}
+	stored.Email = user.Email
+	stored.UpdatedAt = time.Now()
+	s.users[user.ID] = stored
+	return stored, nil
+}
+
+func (s *InMemoryUserStore) MarkPhoneVerified(ctx context.Context, id uuid.UUID, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return fmt.Errorf("%w: user with ID %s", ErrNotFound, id)
+	}
+	user.PhoneVerifiedAt = &at
+	user.UpdatedAt = time.Now()
+	s.users[id] = user
+	return nil
+}
+
+func (s *InMemoryUserStore) SetPasswordHash(ctx context.Context, id uuid.UUID, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[id]; !ok {
+		return fmt.Errorf("%w: user with ID %s", ErrNotFound, id)
+	}
+	s.passwords[id] = hash
+	return nil
+}
+
+func (s *InMemoryUserStore) GetPasswordHash(ctx context.Context, id uuid.UUID) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.users[id]; !ok {
+		return "", fmt.Errorf("%w: user with ID %s", ErrNotFound, id)
+	}
+	return s.passwords[id], nil
+}
+
+func (s *InMemoryUserStore) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.deleteUser(id) {
+		return fmt.Errorf("%w: user with ID %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+func (s *InMemoryUserStore) DeleteUsers(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted := []uuid.UUID{}
+	for _, id := range ids {
+		if s.deleteUser(id) {
+			deleted = append(deleted, id)
+		}
+	}
+	return deleted, nil
+}
+
+// deleteUser deletes the user, if they exist. The caller must hold the lock.
+func (s *InMemoryUserStore) deleteUser(id uuid.UUID) bool {
+	user, ok := s.users[id]
+	if !ok {
+		return false
+	}
+	delete(s.users, id)
+	delete(s.phoneIndex, user.PhoneNumber)
+	s.deletePhoneNumbers(id)
+	delete(s.emailOTPs, id)
+	// The password is kept with the user, in case they're restored.
+	now := time.Now()
+	user.DeletedAt = &now
+	user.UpdatedAt = now
+	s.deleted[id] = user
+	return true
+}
+
+func (s *InMemoryUserStore) SetUsersBanned(ctx context.Context, ids []uuid.UUID, bannedAt *time.Time) ([]uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	updated := []uuid.UUID{}
+	for _, id := range ids {
+		user, ok := s.users[id]
+		if !ok {
+			continue
+		}
+		// A user banned already keeps the time of their ban.
+		if bannedAt == nil || user.BannedAt == nil {
+			user.BannedAt = bannedAt
+		}
+		user.UpdatedAt = time.Now()
+		s.users[id] = user
+		updated = append(updated, id)
+	}
+	return updated, nil
+}
+
+func (s *InMemoryUserStore) GetDeletedUserByPhoneNumber(ctx context.Context, phoneNumber string, deletedAfter time.Time) (model.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var found *model.User
+	for _, user := range s.deleted {
+		if user.PhoneNumber != phoneNumber || !s.restorable(user) || !user.DeletedAt.After(deletedAfter) {
+			continue
+		}
+		if found == nil || user.DeletedAt.After(*found.DeletedAt) {
+			found = &user
+		}
+	}
+	if found == nil {
+		return model.User{}, fmt.Errorf("%w: deleted user with phone number %s", ErrNotFound, phoneNumber)
+	}
+	return *found, nil
+}
+
+func (s *InMemoryUserStore) ListDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) ([]model.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	users := []model.User{}
+	for _, user := range s.deleted {
+		if s.restorable(user) && user.DeletedAt.Before(deletedBefore) {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].DeletedAt.Before(*users[j].DeletedAt) })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (s *InMemoryUserStore) RestoreUser(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.deleted[id]
+	if !ok || !s.restorable(user) {
+		return fmt.Errorf("%w: deleted user with ID %s", ErrNotFound, id)
+	}
+	if _, exists := s.phoneIndex[user.PhoneNumber]; exists {
+		return fmt.Errorf("%w: user with phone number %s", ErrAlreadyExists, user.PhoneNumber)
+	}
+	if _, exists := s.phones[user.PhoneNumber]; exists {
+		return fmt.Errorf("%w: phone number %s", ErrAlreadyExists, user.PhoneNumber)
+	}
+	// Another user may have verified the email address in the meantime.
+	if user.EmailVerifiedAt != nil {
+		if _, taken := s.findVerifiedEmail(user.Email); taken {
+			user.EmailVerifiedAt = nil
+		}
+	}
+	delete(s.deleted, id)
+	user.DeletedAt = nil
+	user.UpdatedAt = time.Now()
+	s.users[id] = user
+	s.phoneIndex[user.PhoneNumber] = id
+	return nil
+}
+
+// restorable reports whether the deleted user can be restored: neither anonymized, which
+// leaves no phone number, nor merged into another user. The caller must hold the lock.
+func (s *InMemoryUserStore) restorable(user model.User) bool {
+	if user.PhoneNumber == "" || user.DeletedAt == nil {
+		return false
+	}
+	for _, merge := range s.merges {
+		if merge.SourceUserID == user.ID {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *InMemoryUserStore) AnonymizeUser(ctx context.Context, id uuid.UUID) (model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if ok {
+		delete(s.users, id)
+		delete(s.phoneIndex, user.PhoneNumber)
+		s.deletePhoneNumbers(id)
+		delete(s.emailOTPs, id)
+	} else if user, ok = s.deleted[id]; !ok {
+		return model.User{}, fmt.Errorf("%w: user with ID %s", ErrNotFound, id)
+	}
+	delete(s.passwords, id)
+	for i, merge := range s.merges {
+		if merge.TargetUserID == id || merge.SourceUserID == id {
+			s.merges[i].SourcePhoneNumber = ""
+		}
+	}
+	// Nothing is left to scrub, so the user is just remembered as deleted.
+	s.deleted[id] = model.User{ID: id, CreatedAt: user.CreatedAt, UpdatedAt: time.Now()}
+	return user, nil
+}
+
+func (s *InMemoryUserStore) ListPhoneNumbers(ctx context.Context, userID uuid.UUID) ([]model.UserPhone, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	phones := []model.UserPhone{}
+	for _, phone := range s.phones {
+		if phone.UserID == userID {
+			phones = append(phones, phone)
+		}
+	}
+	sort.Slice(phones, func(i, j int) bool { return phones[i].VerifiedAt.Before(*phones[j].VerifiedAt) })
+	return phones, nil
+}
+
+func (s *InMemoryUserStore) GetPhoneNumber(ctx context.Context, phoneNumber string) (model.UserPhone, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	phone, ok := s.phones[phoneNumber]
+	if !ok {
+		return model.UserPhone{}, fmt.Errorf("%w: phone number %s", ErrNotFound, phoneNumber)
+	}
+	return phone, nil
+}
+
+func (s *InMemoryUserStore) AddPhoneNumber(ctx context.Context, phone model.UserPhone) (model.UserPhone, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[phone.UserID]; !ok {
+		return model.UserPhone{}, fmt.Errorf("%w: user with ID %s", ErrNotFound, phone.UserID)
+	}
+	if _, exists := s.phones[phone.PhoneNumber]; exists {
+		return model.UserPhone{}, fmt.Errorf("%w: phone number %s", ErrAlreadyExists, phone.PhoneNumber)
+	}
+	phone.ID = uuid.New()
+	phone.Primary = false
+	s.phones[phone.PhoneNumber] = phone
+	return phone, nil
+}
+
+func (s *InMemoryUserStore) DeletePhoneNumber(ctx context.Context, userID, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	phone, ok := s.findPhoneNumber(userID, id)
+	if !ok {
+		return fmt.Errorf("%w: phone number with ID %s", ErrNotFound, id)
+	}
+	delete(s.phones, phone.PhoneNumber)
+	return nil
+}
+
+func (s *InMemoryUserStore) SwapPrimaryPhoneNumber(ctx context.Context, userID, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("%w: user with ID %s", ErrNotFound, userID)
+	}
+	phone, ok := s.findPhoneNumber(userID, id)
+	if !ok {
+		return fmt.Errorf("%w: phone number with ID %s", ErrNotFound, id)
+	}
+
+	delete(s.phones, phone.PhoneNumber)
+	delete(s.phoneIndex, user.PhoneNumber)
+	user.PhoneNumber, phone.PhoneNumber = phone.PhoneNumber, user.PhoneNumber
+	// The old primary number was last verified when the account was.
+	phone.VerifiedAt = user.PhoneVerifiedAt
+	if phone.VerifiedAt == nil {
+		phone.VerifiedAt = &user.CreatedAt
+	}
+	user.UpdatedAt = time.Now()
+	s.users[userID] = user
+	s.phoneIndex[user.PhoneNumber] = userID
+	s.phones[phone.PhoneNumber] = phone
+	return nil
+}
+
+func (s *InMemoryUserStore) MovePhoneNumbers(ctx context.Context, fromUserID, toUserID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for number, phone := range s.phones {
+		if phone.UserID == fromUserID {
+			phone.UserID = toUserID
+			s.phones[number] = phone
+		}
+	}
+	return nil
+}
+
+func (s *InMemoryUserStore) CreateUserMerge(ctx context.Context, merge model.UserMerge) (model.UserMerge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	merge.ID = uuid.New()
+	merge.CreatedAt = time.Now()
+	s.merges = append(s.merges, merge)
+	return merge, nil
+}
+
+func (s *InMemoryUserStore) ListUserMerges(ctx context.Context, userID uuid.UUID) ([]model.UserMerge, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	merges := []model.UserMerge{}
+	for i := len(s.merges) - 1; i >= 0; i-- {
+		if s.merges[i].TargetUserID == userID || s.merges[i].SourceUserID == userID {
+			merges = append(merges, s.merges[i])
+		}
+	}
+	return merges, nil
+}
+
+// findPhoneNumber returns the secondary phone number of the user with the ID. The caller must
+// hold the lock.
+func (s *InMemoryUserStore) findPhoneNumber(userID, id uuid.UUID) (model.UserPhone, bool) {
+	for _, phone := range s.phones {
+		if phone.ID == id && phone.UserID == userID {
+			return phone, true
+		}
+	}
+	return model.UserPhone{}, false
+}
+
+// deletePhoneNumbers drops the secondary phone numbers of the user. The caller must hold the lock.
+func (s *InMemoryUserStore) deletePhoneNumbers(userID uuid.UUID) {
+	for number, phone := range s.phones {
+		if phone.UserID == userID {
+			delete(s.phones, number)
+		}
+	}
+}
+
+func (s *InMemoryUserStore) StoreEmailOTP(ctx context.Context, otp model.EmailOTP) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	otp.Attempts = 0
+	s.emailOTPs[otp.UserID] = otp
+	return nil
+}
+
+func (s *InMemoryUserStore) GetEmailOTP(ctx context.Context, userID uuid.UUID) (model.EmailOTP, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	otp, ok := s.emailOTPs[userID]
+	if !ok {
+		return model.EmailOTP{}, fmt.Errorf("%w: email OTP of user %s", ErrNotFound, userID)
+	}
+	return otp, nil
+}
+
+func (s *InMemoryUserStore) IncrementEmailOTPAttempts(ctx context.Context, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if otp, ok := s.emailOTPs[userID]; ok {
+		otp.Attempts++
+		s.emailOTPs[userID] = otp
+	}
+	return nil
+}
+
+func (s *InMemoryUserStore) DeleteEmailOTP(ctx context.Context, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.emailOTPs, userID)
+	return nil
+}
+
+func (s *InMemoryUserStore) MarkEmailVerified(ctx context.Context, userID uuid.UUID, email string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[userID]
+	if !ok || user.Email != email {
+		return fmt.Errorf("%w: user %s with email %s", ErrNotFound, userID, email)
+	}
+	if owner, ok := s.findVerifiedEmail(email); ok && owner.ID != userID {
+		return fmt.Errorf("%w: verified email %s", ErrAlreadyExists, email)
+	}
+	user.EmailVerifiedAt = &at
+	user.UpdatedAt = time.Now()
+	s.users[userID] = user
+	return nil
+}
+
+func (s *InMemoryUserStore) GetUserByVerifiedEmail(ctx context.Context, email string) (model.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.findVerifiedEmail(email)
+	if !ok {
+		return model.User{}, fmt.Errorf("%w: user with verified email %s", ErrNotFound, email)
+	}
+	return user, nil
+}
+
+// findVerifiedEmail returns the user who verified the email address. The caller must hold the lock.
+func (s *InMemoryUserStore) findVerifiedEmail(email string) (model.User, bool) {
+	for _, user := range s.users {
+		if user.EmailVerifiedAt != nil && user.Email == email {
+			return user, true
+		}
+	}
+	return model.User{}, false
+}
+
+// In-memory OTP Store. Every send for a new phone number adds an entry, so the store can be
+// bounded: at maxEntries the least recently used OTP is evicted, which under a spray attack is
+// the oldest one and usually already expired.
 type InMemoryOTPStore struct {
-	otps map[string]model.OTP // Keyed by phone number
-	mu   sync.RWMutex
+	otps       map[string]*list.Element // Keyed by phone number, values hold a model.OTP
+	lru        *list.List               // Most recently used at the front
+	maxEntries int
+	mu         sync.Mutex
 }
 
-func NewInMemoryOTPStore() *InMemoryOTPStore {
+// NewInMemoryOTPStore creates an OTP store holding at most maxEntries OTPs; 0 means unbounded.
+func NewInMemoryOTPStore(maxEntries int) *InMemoryOTPStore {
 	return &InMemoryOTPStore{
-		otps: make(map[string]model.OTP),
+		otps:       make(map[string]*list.Element),
+		lru:        list.New(),
+		maxEntries: maxEntries,
 	}
 }
 
-func (s *InMemoryOTPStore) StoreOTP(otp model.OTP) error {
+func (s *InMemoryOTPStore) StoreOTP(ctx context.Context, otp model.OTP) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	otp.ID = uuid.New() // Assign an ID, though not used as key
 	otp.CreatedAt = time.Now()
-	s.otps[otp.PhoneNumber] = otp
+	s.put(otp)
 	return nil
 }
 
-func (s *InMemoryOTPStore) GetOTP(phoneNumber string) (model.OTP, error) {
+// put adds or replaces the OTP of its phone number, evicting OTPs beyond maxEntries.
+// s.mu must be held.
+func (s *InMemoryOTPStore) put(otp model.OTP) {
+	if elem, ok := s.otps[otp.PhoneNumber]; ok {
+		elem.Value = otp
+		s.lru.MoveToFront(elem)
+		return
+	}
+	s.otps[otp.PhoneNumber] = s.lru.PushFront(otp)
+	if s.maxEntries <= 0 {
+		return
+	}
+
+	now := time.Now()
+	for s.lru.Len() > s.maxEntries {
+		oldest := s.lru.Back()
+		reason := "capacity"
+		if now.After(oldest.Value.(model.OTP).ExpiresAt) {
+			reason = "expired"
+		}
+		s.remove(oldest)
+		// Counted rather than logged: a spray attack would flood the logs.
+		metrics.OTPStoreEvictions.Add(reason, 1)
+	}
+}
+
+// remove drops the element from the store. s.mu must be held.
+func (s *InMemoryOTPStore) remove(elem *list.Element) {
+	s.lru.Remove(elem)
+	delete(s.otps, elem.Value.(model.OTP).PhoneNumber)
+}
+
+func (s *InMemoryOTPStore) GetOTP(ctx context.Context, phoneNumber string) (model.OTP, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.otps[phoneNumber]
+	if !ok {
+		return model.OTP{}, fmt.Errorf("%w: OTP for phone number %s", ErrNotFound, phoneNumber)
+	}
+	s.lru.MoveToFront(elem)
+	return elem.Value.(model.OTP), nil
+}
+
+func (s *InMemoryOTPStore) DeleteOTP(ctx context.Context, phoneNumber string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.otps[phoneNumber]; ok {
+		s.remove(elem)
+	}
+	return nil
+}
+
+// In-memory API Key Store
+type InMemoryAPIKeyStore struct {
+	keys      map[uuid.UUID]model.APIKey
+	hashIndex map[string]uuid.UUID // For fast lookup by key hash
+	mu        sync.RWMutex
+}
+
+func NewInMemoryAPIKeyStore() *InMemoryAPIKeyStore {
+	return &InMemoryAPIKeyStore{
+		keys:      make(map[uuid.UUID]model.APIKey),
+		hashIndex: make(map[string]uuid.UUID),
+	}
+}
+
+func (s *InMemoryAPIKeyStore) CreateAPIKey(ctx context.Context, key model.APIKey) (model.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.hashIndex[key.KeyHash]; exists {
+		return model.APIKey{}, fmt.Errorf("%w: API key %s", ErrAlreadyExists, key.Prefix)
+	}
+
+	key.ID = uuid.New()
+	key.CreatedAt = time.Now()
+	s.keys[key.ID] = key
+	s.hashIndex[key.KeyHash] = key.ID
+	return key, nil
+}
+
+func (s *InMemoryAPIKeyStore) GetAPIKeyByHash(ctx context.Context, keyHash string) (model.APIKey, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	otp, ok := s.otps[phoneNumber]
+	id, ok := s.hashIndex[keyHash]
 	if !ok {
-		return model.OTP{}, fmt.Errorf("%w: OTP for phone number %s", ErrNotFound, phoneNumber)
+		return model.APIKey{}, fmt.Errorf("%w: API key", ErrNotFound)
 	}
-	return otp, nil
+	return s.keys[id], nil
 }
 
-func (s *InMemoryOTPStore) DeleteOTP(phoneNumber string) error {
+func (s *InMemoryAPIKeyStore) GetAPIKeyByID(ctx context.Context, id uuid.UUID) (model.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return model.APIKey{}, fmt.Errorf("%w: API key with ID %s", ErrNotFound, id)
+	}
+	return key, nil
+}
+
+func (s *InMemoryAPIKeyStore) ListAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]model.APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (s *InMemoryAPIKeyStore) RevokeAPIKey(ctx context.Context, id uuid.UUID, revokedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return fmt.Errorf("%w: API key with ID %s", ErrNotFound, id)
+	}
+	if key.RevokedAt == nil {
+		key.RevokedAt = &revokedAt
+		s.keys[id] = key
+	}
+	return nil
+}
+
+// InMemoryDeviceStore keeps the devices users signed in from.
+type InMemoryDeviceStore struct {
+	devices map[uuid.UUID]model.Device
+	mu      sync.RWMutex
+}
+
+func NewInMemoryDeviceStore() *InMemoryDeviceStore {
+	return &InMemoryDeviceStore{
+		devices: make(map[uuid.UUID]model.Device),
+	}
+}
+
+func (s *InMemoryDeviceStore) CreateDevice(ctx context.Context, device model.Device) (model.Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.devices {
+		if d.UserID == device.UserID && d.Fingerprint == device.Fingerprint {
+			return model.Device{}, fmt.Errorf("%w: device of user %s", ErrAlreadyExists, device.UserID)
+		}
+	}
+
+	device.ID = uuid.New()
+	s.devices[device.ID] = device
+	return device, nil
+}
+
+func (s *InMemoryDeviceStore) ListDevices(ctx context.Context, userID uuid.UUID) ([]model.Device, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	devices := []model.Device{}
+	for _, d := range s.devices {
+		if d.UserID == userID {
+			devices = append(devices, d)
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].LastSeenAt.After(devices[j].LastSeenAt) })
+	return devices, nil
+}
+
+func (s *InMemoryDeviceStore) AnonymizeDevices(ctx context.Context, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, d := range s.devices {
+		if d.UserID == userID {
+			d.Fingerprint, d.UserAgent, d.LastIP = id.String(), "", ""
+			d.TrustTokenHash, d.TrustedUntil = "", nil
+			s.devices[id] = d
+		}
+	}
+	return nil
+}
+
+func (s *InMemoryDeviceStore) MoveDevices(ctx context.Context, fromUserID, toUserID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	known := make(map[string]bool)
+	for _, d := range s.devices {
+		if d.UserID == toUserID {
+			known[d.Fingerprint] = true
+		}
+	}
+	for id, d := range s.devices {
+		if d.UserID != fromUserID {
+			continue
+		}
+		if known[d.Fingerprint] {
+			delete(s.devices, id)
+			continue
+		}
+		d.UserID = toUserID
+		s.devices[id] = d
+	}
+	return nil
+}
+
+func (s *InMemoryDeviceStore) TouchDevice(ctx context.Context, id uuid.UUID, ip, userAgent string, seenAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device, ok := s.devices[id]
+	if !ok {
+		return fmt.Errorf("%w: device with ID %s", ErrNotFound, id)
+	}
+	device.LastIP, device.UserAgent, device.LastSeenAt = ip, userAgent, seenAt
+	s.devices[id] = device
+	return nil
+}
+
+func (s *InMemoryDeviceStore) TrustDevice(ctx context.Context, id uuid.UUID, tokenHash string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device, ok := s.devices[id]
+	if !ok {
+		return fmt.Errorf("%w: device with ID %s", ErrNotFound, id)
+	}
+	device.TrustTokenHash, device.TrustedUntil = tokenHash, &until
+	s.devices[id] = device
+	return nil
+}
+
+func (s *InMemoryDeviceStore) UntrustDevice(ctx context.Context, userID, id uuid.UUID) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.otps, phoneNumber)
+	device, ok := s.devices[id]
+	if !ok || device.UserID != userID {
+		return fmt.Errorf("%w: device with ID %s", ErrNotFound, id)
+	}
+	device.TrustTokenHash, device.TrustedUntil = "", nil
+	s.devices[id] = device
+	return nil
+}
+
+func (s *InMemoryDeviceStore) UntrustDevices(ctx context.Context, userID uuid.UUID) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, device := range s.devices {
+		if device.UserID != userID || device.TrustTokenHash == "" {
+			continue
+		}
+		device.TrustTokenHash, device.TrustedUntil = "", nil
+		s.devices[id] = device
+		n++
+	}
+	return n, nil
+}
+
+// InMemoryIdentityStore keeps the social login accounts linked to users.
+type InMemoryIdentityStore struct {
+	identities map[string]model.Identity // provider + "|" + subject -> identity
+	mu         sync.RWMutex
+}
+
+func NewInMemoryIdentityStore() *InMemoryIdentityStore {
+	return &InMemoryIdentityStore{
+		identities: make(map[string]model.Identity),
+	}
+}
+
+func (s *InMemoryIdentityStore) CreateIdentity(ctx context.Context, identity model.Identity) (model.Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := identity.Provider + "|" + identity.Subject
+	if _, exists := s.identities[key]; exists {
+		return model.Identity{}, fmt.Errorf("%w: %s identity %s", ErrAlreadyExists, identity.Provider, identity.Subject)
+	}
+	identity.CreatedAt = time.Now()
+	s.identities[key] = identity
+	return identity, nil
+}
+
+func (s *InMemoryIdentityStore) GetIdentity(ctx context.Context, provider, subject string) (model.Identity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	identity, ok := s.identities[provider+"|"+subject]
+	if !ok {
+		return model.Identity{}, fmt.Errorf("%w: %s identity %s", ErrNotFound, provider, subject)
+	}
+	return identity, nil
+}
+
+func (s *InMemoryIdentityStore) GetIdentityByEmail(ctx context.Context, email string) (model.Identity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var found *model.Identity
+	for _, identity := range s.identities {
+		if identity.Email == email && (found == nil || identity.CreatedAt.Before(found.CreatedAt)) {
+			found = &identity
+		}
+	}
+	if found == nil {
+		return model.Identity{}, fmt.Errorf("%w: identity with email %s", ErrNotFound, email)
+	}
+	return *found, nil
+}
+
+func (s *InMemoryIdentityStore) ListIdentities(ctx context.Context, userID uuid.UUID) ([]model.Identity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	identities := []model.Identity{}
+	for _, identity := range s.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool { return identities[i].CreatedAt.Before(identities[j].CreatedAt) })
+	return identities, nil
+}
+
+func (s *InMemoryIdentityStore) MoveIdentities(ctx context.Context, fromUserID, toUserID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, identity := range s.identities {
+		if identity.UserID == fromUserID {
+			identity.UserID = toUserID
+			s.identities[key] = identity
+		}
+	}
+	return nil
+}
+
+func (s *InMemoryIdentityStore) DeleteIdentity(ctx context.Context, provider, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.identities, provider+"|"+subject)
 	return nil
 }
 
@@ -166,3 +937,338 @@ func (r *InMemoryRateLimiter) Allow(phoneNumber string) bool {
 	r.requests[phoneNumber] = recentRequests
 	return true
 }
+
+// inMemoryLoginsPerUser is how many logins InMemoryLoginStore keeps per user.
+const inMemoryLoginsPerUser = 100
+
+// InMemoryLoginStore keeps the latest logins of every user.
+type InMemoryLoginStore struct {
+	logins map[uuid.UUID][]model.Login // Keyed by user ID, oldest first
+	mu     sync.RWMutex
+}
+
+func NewInMemoryLoginStore() *InMemoryLoginStore {
+	return &InMemoryLoginStore{
+		logins: make(map[uuid.UUID][]model.Login),
+	}
+}
+
+func (s *InMemoryLoginStore) CreateLogin(ctx context.Context, login model.Login) (model.Login, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	login.ID = uuid.New()
+	logins := append(s.logins[login.UserID], login)
+	if len(logins) > inMemoryLoginsPerUser {
+		logins = logins[len(logins)-inMemoryLoginsPerUser:]
+	}
+	s.logins[login.UserID] = logins
+	return login, nil
+}
+
+func (s *InMemoryLoginStore) AnonymizeLogins(ctx context.Context, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.logins[userID] {
+		s.logins[userID][i].IP, s.logins[userID][i].UserAgent = "", ""
+	}
+	return nil
+}
+
+func (s *InMemoryLoginStore) MoveLogins(ctx context.Context, fromUserID, toUserID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	moved := s.logins[fromUserID]
+	if len(moved) == 0 {
+		return nil
+	}
+	logins := append(s.logins[toUserID], moved...)
+	for i := range logins {
+		logins[i].UserID = toUserID
+	}
+	sort.SliceStable(logins, func(i, j int) bool { return logins[i].CreatedAt.Before(logins[j].CreatedAt) })
+	if len(logins) > inMemoryLoginsPerUser {
+		logins = logins[len(logins)-inMemoryLoginsPerUser:]
+	}
+	s.logins[toUserID] = logins
+	delete(s.logins, fromUserID)
+	return nil
+}
+
+func (s *InMemoryLoginStore) ListLogins(ctx context.Context, userID uuid.UUID, limit int, before *model.LoginCursor) ([]model.Login, error) {
+	s.mu.RLock()
+	stored := slices.Clone(s.logins[userID])
+	s.mu.RUnlock()
+
+	// Newest first, like the Postgres store: by time, then by ID.
+	slices.SortFunc(stored, func(a, b model.Login) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), bytes.Compare(b.ID[:], a.ID[:]))
+	})
+	logins := make([]model.Login, 0, min(limit, len(stored)))
+	for _, login := range stored {
+		if len(logins) == limit {
+			break
+		}
+		if before != nil && cmp.Or(login.CreatedAt.Compare(before.CreatedAt), bytes.Compare(login.ID[:], before.ID[:])) >= 0 {
+			continue
+		}
+		logins = append(logins, login)
+	}
+	return logins, nil
+}
+
+// InMemoryExportStore keeps the users' data exports until they expire.
+type InMemoryExportStore struct {
+	exports map[uuid.UUID]model.Export
+	mu      sync.RWMutex
+}
+
+func NewInMemoryExportStore() *InMemoryExportStore {
+	return &InMemoryExportStore{exports: make(map[uuid.UUID]model.Export)}
+}
+
+func (s *InMemoryExportStore) CreateExport(ctx context.Context, export model.Export) (model.Export, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Exports are requested rarely, so pruning the expired ones here is cheap enough.
+	now := time.Now()
+	for id, e := range s.exports {
+		if !now.Before(e.ExpiresAt) {
+			delete(s.exports, id)
+		}
+	}
+	s.exports[export.ID] = export
+	return export, nil
+}
+
+func (s *InMemoryExportStore) GetExport(ctx context.Context, id uuid.UUID) (model.Export, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	export, ok := s.exports[id]
+	if !ok || !time.Now().Before(export.ExpiresAt) {
+		return model.Export{}, fmt.Errorf("%w: export with ID %s", ErrNotFound, id)
+	}
+	return export, nil
+}
+
+func (s *InMemoryExportStore) DeleteExports(ctx context.Context, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, e := range s.exports {
+		if e.UserID == userID {
+			delete(s.exports, id)
+		}
+	}
+	return nil
+}
+
+func (s *InMemoryExportStore) UpdateExport(ctx context.Context, export model.Export) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.exports[export.ID]
+	if !ok {
+		return fmt.Errorf("%w: export with ID %s", ErrNotFound, export.ID)
+	}
+	stored.Status = export.Status
+	stored.CompletedAt = export.CompletedAt
+	stored.Data = export.Data
+	s.exports[export.ID] = stored
+	return nil
+}
+
+// InMemoryDeadLetterStore keeps the OTPs whose delivery failed for good, oldest first.
+type InMemoryDeadLetterStore struct {
+	letters []model.DeadLetter
+	mu      sync.RWMutex
+}
+
+func NewInMemoryDeadLetterStore() *InMemoryDeadLetterStore {
+	return &InMemoryDeadLetterStore{}
+}
+
+func (s *InMemoryDeadLetterStore) CreateDeadLetter(ctx context.Context, letter model.DeadLetter) (model.DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	letter.ID = uuid.New()
+	letter.CreatedAt = time.Now()
+	s.letters = append(s.letters, letter)
+	return letter, nil
+}
+
+func (s *InMemoryDeadLetterStore) GetDeadLetter(ctx context.Context, id uuid.UUID) (model.DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, letter := range s.letters {
+		if letter.ID == id {
+			return letter, nil
+		}
+	}
+	return model.DeadLetter{}, fmt.Errorf("%w: dead letter with ID %s", ErrNotFound, id)
+}
+
+func (s *InMemoryDeadLetterStore) ListDeadLetters(ctx context.Context, limit int) ([]model.DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	letters := []model.DeadLetter{}
+	for i := len(s.letters) - 1; i >= 0 && len(letters) < limit; i-- {
+		letters = append(letters, s.letters[i])
+	}
+	return letters, nil
+}
+
+func (s *InMemoryDeadLetterStore) DeleteDeadLetter(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, letter := range s.letters {
+		if letter.ID == id {
+			s.letters = append(s.letters[:i], s.letters[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: dead letter with ID %s", ErrNotFound, id)
+}
+
+func (s *InMemoryDeadLetterStore) DeleteDeadLetters(ctx context.Context, phoneNumber string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.letters[:0]
+	for _, letter := range s.letters {
+		if letter.PhoneNumber != phoneNumber {
+			kept = append(kept, letter)
+		}
+	}
+	s.letters = kept
+	return nil
+}
+
+// In-memory Stats Store
+
+// InMemoryStatsStore keeps the daily counters and aggregates the in-memory users and OTPs.
+type InMemoryStatsStore struct {
+	users    *InMemoryUserStore
+	otps     *InMemoryOTPStore
+	counters map[string]int64 // day + "|" + name -> count
+	mu       sync.Mutex
+}
+
+func NewInMemoryStatsStore(users *InMemoryUserStore, otps *InMemoryOTPStore) *InMemoryStatsStore {
+	return &InMemoryStatsStore{users: users, otps: otps, counters: make(map[string]int64)}
+}
+
+func (s *InMemoryStatsStore) IncrementCounter(ctx context.Context, name string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters[at.UTC().Format(time.DateOnly)+"|"+name]++
+	return nil
+}
+
+func (s *InMemoryStatsStore) SumCounters(ctx context.Context, since time.Time) (map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	first := since.UTC().Format(time.DateOnly)
+	sums := make(map[string]int64)
+	for key, count := range s.counters {
+		day, name, _ := strings.Cut(key, "|")
+		if day >= first {
+			sums[name] += count
+		}
+	}
+	return sums, nil
+}
+
+func (s *InMemoryStatsStore) CountUsers(ctx context.Context) (int64, error) {
+	s.users.mu.RLock()
+	defer s.users.mu.RUnlock()
+	return int64(len(s.users.users)), nil
+}
+
+func (s *InMemoryStatsStore) CountNewUsersPerDay(ctx context.Context, since time.Time) ([]model.DayCount, error) {
+	s.users.mu.RLock()
+	perDay := make(map[string]int64)
+	for _, users := range []map[uuid.UUID]model.User{s.users.users, s.users.deleted} {
+		for _, user := range users {
+			if !user.CreatedAt.Before(since) {
+				perDay[user.CreatedAt.UTC().Format(time.DateOnly)]++
+			}
+		}
+	}
+	s.users.mu.RUnlock()
+
+	counts := make([]model.DayCount, 0, len(perDay))
+	for day, count := range perDay {
+		counts = append(counts, model.DayCount{Date: day, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Date < counts[j].Date })
+	return counts, nil
+}
+
+func (s *InMemoryStatsStore) CountUserGrowth(ctx context.Context, from, to time.Time) ([]model.UserGrowthDay, error) {
+	start := from.UTC().Truncate(24 * time.Hour)
+	end := to.UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	// Like in the Postgres store, everything before from counts for the day before it.
+	before := start.AddDate(0, 0, -1)
+	dayOf := func(t time.Time) string {
+		day := t.UTC().Truncate(24 * time.Hour)
+		if day.Before(before) {
+			day = before
+		}
+		return day.Format(time.DateOnly)
+	}
+
+	signups := make(map[string]int64)
+	deletions := make(map[string]int64)
+	s.users.mu.RLock()
+	for _, user := range s.users.users {
+		if user.CreatedAt.Before(end) {
+			signups[dayOf(user.CreatedAt)]++
+		}
+	}
+	for _, user := range s.users.deleted {
+		if user.CreatedAt.Before(end) {
+			signups[dayOf(user.CreatedAt)]++
+		}
+		// Anonymized users are only remembered with the time of their anonymization.
+		deletedAt := user.UpdatedAt
+		if user.DeletedAt != nil {
+			deletedAt = *user.DeletedAt
+		}
+		if deletedAt.Before(end) {
+			deletions[dayOf(deletedAt)]++
+		}
+	}
+	s.users.mu.RUnlock()
+
+	dates := make([]string, 0, len(signups)+len(deletions))
+	for date := range signups {
+		dates = append(dates, date)
+	}
+	for date := range deletions {
+		if _, ok := signups[date]; !ok {
+			dates = append(dates, date)
+		}
+	}
+	sort.Strings(dates)
+	days := make([]model.UserGrowthDay, len(dates))
+	var total int64
+	for i, date := range dates {
+		total += signups[date] - deletions[date]
+		days[i] = model.UserGrowthDay{Date: date, Signups: signups[date], Total: total}
+	}
+	return days, nil
+}
+
+func (s *InMemoryStatsStore) CountActiveOTPs(ctx context.Context) (int64, error) {
+	s.otps.mu.Lock()
+	defer s.otps.mu.Unlock()
+	now := time.Now()
+	var active int64
+	for _, elem := range s.otps.otps {
+		if elem.Value.(model.OTP).ExpiresAt.After(now) {
+			active++
+		}
+	}
+	return active, nil
+}
@@ -1,25 +1,35 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"time"
 
+	"github.com/ebipenman/go-otp-auth-service/internal/fieldcrypt"
 	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/tracing"
 
 	"github.com/google/uuid"
 	"github.com/lib/pq" // PostgreSQL driver
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // PostgresStore holds the database connection pool.
 type PostgresStore struct {
 	db *sql.DB
+	// phones encrypts the users' phone numbers at rest; nil stores them in plain text.
+	phones *fieldcrypt.Cipher
 }
 
 // NewPostgresStore creates a new PostgreSQL store, connects to the database,
-// and runs initial migrations.
-func NewPostgresStore(dataSourceName string) (*PostgresStore, error) {
+// and runs initial migrations. With a phone cipher, phone numbers that are still stored
+// in plain text are encrypted on startup.
+func NewPostgresStore(dataSourceName string, phones *fieldcrypt.Cipher) (*PostgresStore, error) {
 	db, err := sql.Open("postgres", dataSourceName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database connection: %w", err)
@@ -28,74 +38,568 @@ func NewPostgresStore(dataSourceName string) (*PostgresStore, error) {
 	// Ping the database to verify the connection is alive.
 	if err := db.Ping(); err != nil {
 		db.Close() // Close the connection if ping fails
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+		return nil, fmt.Errorf("failed to ping database: %w", explainConnectError(err))
 	}
 
-	log.Println("Successfully connected to PostgreSQL database.")
+	slog.Info("Successfully connected to PostgreSQL database")
 
-	store := &PostgresStore{db: db}
+	store := &PostgresStore{db: db, phones: phones}
 
 	// Run migrations to ensure tables are created.
 	if err := store.runMigrations(); err != nil {
 		db.Close()
-		return nil, fmt.Errorf("failed to run migrations: %w", err)
+		return nil, fmt.Errorf("failed to run migrations: %w", explainConnectError(err))
+	}
+
+	if err := store.checkPhoneEncryption(); err != nil {
+		db.Close()
+		return nil, err
 	}
 
 	return store, nil
 }
 
-// runMigrations executes the SQL statements to create the necessary tables if they don't exist.
-func (s *PostgresStore) runMigrations() error {
-	createUsersTable := `
+// Close closes the connection pool, waiting for in-flight queries to finish.
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
+
+// migration is one versioned schema change. Migrations are applied in order and recorded in
+// the schema_migrations table, so each runs exactly once per database. Never edit a migration
+// that has been released; append a new one instead.
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create_users",
+		sql: `
 	CREATE TABLE IF NOT EXISTS users (
 		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
 		phone_number VARCHAR(20) UNIQUE NOT NULL,
 		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
 		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-	);`
-
-	// --- THIS IS THE CHANGE ---
-	createOTPsTable := `
+	);`,
+	},
+	{
+		version: 2,
+		name:    "create_otps",
+		sql: `
 	CREATE TABLE IF NOT EXISTS otps (
 		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-		-- Add the UNIQUE constraint to this column
 		phone_number VARCHAR(20) UNIQUE NOT NULL,
 		otp_code VARCHAR(6) NOT NULL,
 		expires_at TIMESTAMPTZ NOT NULL,
 		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 	);
-	-- This index is now automatically created by the UNIQUE constraint, but leaving it is fine.
-	CREATE INDEX IF NOT EXISTS idx_otps_phone_number ON otps (phone_number);
-	`
+	CREATE INDEX IF NOT EXISTS idx_otps_phone_number ON otps (phone_number);`,
+	},
+	{
+		version: 3,
+		name:    "create_api_keys",
+		sql: `
+	CREATE TABLE api_keys (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		name VARCHAR(100) NOT NULL,
+		prefix VARCHAR(16) NOT NULL,
+		key_hash CHAR(64) UNIQUE NOT NULL,
+		scopes TEXT[] NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		revoked_at TIMESTAMPTZ
+	);`,
+	},
+	{
+		version: 4,
+		name:    "create_devices",
+		sql: `
+	CREATE TABLE devices (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		fingerprint CHAR(64) NOT NULL,
+		user_agent TEXT NOT NULL,
+		last_ip VARCHAR(45) NOT NULL,
+		first_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (user_id, fingerprint)
+	);`,
+	},
+	{
+		version: 5,
+		name:    "add_users_phone_number_hash",
+		sql: `
+	ALTER TABLE users
+		ALTER COLUMN phone_number TYPE TEXT,
+		ADD COLUMN phone_number_hash CHAR(64) UNIQUE;`,
+	},
+	{
+		version: 6,
+		name:    "create_identities",
+		sql: `
+	CREATE TABLE identities (
+		provider VARCHAR(20) NOT NULL,
+		subject TEXT NOT NULL,
+		user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		email TEXT,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (provider, subject)
+	);
+	CREATE INDEX idx_identities_email ON identities (email);`,
+	},
+	{
+		version: 7,
+		name:    "add_otps_channel",
+		sql: `
+	ALTER TABLE otps ADD COLUMN channel VARCHAR(20) NOT NULL DEFAULT 'sms';`,
+	},
+	{
+		version: 8,
+		name:    "create_logins",
+		sql: `
+	CREATE TABLE logins (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		succeeded BOOLEAN NOT NULL,
+		method VARCHAR(20) NOT NULL,
+		reason VARCHAR(40) NOT NULL DEFAULT '',
+		ip VARCHAR(45) NOT NULL,
+		user_agent TEXT NOT NULL,
+		device_id UUID REFERENCES devices (id) ON DELETE SET NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+	CREATE INDEX idx_logins_user_id_created_at ON logins (user_id, created_at DESC);`,
+	},
+	{
+		version: 9,
+		name:    "add_users_profile",
+		sql: `
+	ALTER TABLE users ADD COLUMN name TEXT NOT NULL DEFAULT '', ADD COLUMN email TEXT NOT NULL DEFAULT '';`,
+	},
+	{
+		// Deleted users keep their row, so the phone number may sign up again as a new user.
+		version: 10,
+		name:    "add_users_deleted_at",
+		sql: `
+	ALTER TABLE users
+		ADD COLUMN deleted_at TIMESTAMPTZ,
+		DROP CONSTRAINT users_phone_number_key,
+		DROP CONSTRAINT users_phone_number_hash_key;
+	CREATE UNIQUE INDEX users_phone_number_key ON users (phone_number) WHERE deleted_at IS NULL;
+	CREATE UNIQUE INDEX users_phone_number_hash_key ON users (phone_number_hash) WHERE deleted_at IS NULL;`,
+	},
+	{
+		version: 11,
+		name:    "create_exports",
+		sql: `
+	CREATE TABLE exports (
+		id UUID PRIMARY KEY,
+		user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		status VARCHAR(20) NOT NULL,
+		data BYTEA,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		completed_at TIMESTAMPTZ,
+		expires_at TIMESTAMPTZ NOT NULL
+	);
+	CREATE INDEX idx_exports_expires_at ON exports (expires_at);`,
+	},
+	{
+		// Anonymized users have no phone number left, nor a hash of it.
+		version: 12,
+		name:    "add_users_anonymized_at",
+		sql: `
+	ALTER TABLE users ADD COLUMN anonymized_at TIMESTAMPTZ;`,
+	},
+	{
+		// Events written with the state change they describe, until the relay publishes them.
+		version: 13,
+		name:    "create_outbox",
+		sql: `
+	CREATE TABLE outbox (
+		seq BIGSERIAL PRIMARY KEY,
+		payload TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`,
+	},
+	{
+		// Counters of the admin statistics, and the index for counting new users per day.
+		version: 14,
+		name:    "create_daily_counters",
+		sql: `
+	CREATE TABLE daily_counters (
+		day DATE NOT NULL,
+		name VARCHAR(50) NOT NULL,
+		count BIGINT NOT NULL,
+		PRIMARY KEY (day, name)
+	);
+	CREATE INDEX idx_users_created_at ON users (created_at);`,
+	},
+	{
+		// Devices the user chose to trust sign in with a device token instead of an OTP.
+		version: 15,
+		name:    "add_devices_trust",
+		sql: `
+	ALTER TABLE devices ADD COLUMN trust_token_hash TEXT, ADD COLUMN trusted_until TIMESTAMPTZ;`,
+	},
+	{
+		// Existing users last verified their number with their latest OTP login, or else when
+		// they registered.
+		version: 16,
+		name:    "add_users_phone_verified_at",
+		sql: `
+	ALTER TABLE users ADD COLUMN phone_verified_at TIMESTAMPTZ;
+	UPDATE users u SET phone_verified_at = COALESCE(
+		(SELECT MAX(l.created_at) FROM logins l WHERE l.user_id = u.id AND l.succeeded AND l.method = 'otp'),
+		u.created_at
+	);`,
+	},
+	{
+		// Secondary phone numbers; the primary one stays in users. Like there, phone_number
+		// holds the ciphertext and phone_number_hash the lookup hash once numbers are encrypted.
+		version: 17,
+		name:    "create_user_phones",
+		sql: `
+	CREATE TABLE user_phones (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		phone_number TEXT NOT NULL UNIQUE,
+		phone_number_hash CHAR(64) UNIQUE,
+		verified_at TIMESTAMPTZ NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+	CREATE INDEX idx_user_phones_user_id ON user_phones (user_id);`,
+	},
+	{
+		// Verified email addresses recover accounts, so each belongs to one user at most.
+		version: 18,
+		name:    "add_users_email_verified_at",
+		sql: `
+	ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+	CREATE UNIQUE INDEX users_verified_email_key ON users (email) WHERE email_verified_at IS NOT NULL AND deleted_at IS NULL;
+	CREATE TABLE email_otps (
+		user_id UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+		email TEXT NOT NULL,
+		purpose VARCHAR(16) NOT NULL,
+		code_hash CHAR(64) NOT NULL,
+		attempts INT NOT NULL DEFAULT 0,
+		expires_at TIMESTAMPTZ NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`,
+	},
+	{
+		version: 19,
+		name:    "add_users_password_hash",
+		sql: `
+	ALTER TABLE users ADD COLUMN password_hash TEXT;
+	ALTER TABLE otps ADD COLUMN password_verified BOOLEAN NOT NULL DEFAULT FALSE;`,
+	},
+	{
+		version: 20,
+		name:    "create_user_merges",
+		sql: `
+	CREATE TABLE user_merges (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		target_user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		source_user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		-- The primary phone number of the source user, stored like the phone numbers of users.
+		phone_number TEXT NOT NULL,
+		phone_number_hash CHAR(64),
+		merged_by TEXT NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+	CREATE INDEX idx_user_merges_target_user_id ON user_merges (target_user_id);
+	CREATE INDEX idx_user_merges_source_user_id ON user_merges (source_user_id);`,
+	},
+	{
+		version: 21,
+		name:    "create_otp_dead_letters",
+		sql: `
+	CREATE TABLE otp_dead_letters (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		-- Stored like the phone numbers of users.
+		phone_number TEXT NOT NULL,
+		phone_number_hash CHAR(64),
+		channel VARCHAR(16) NOT NULL,
+		tenant TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);
+	CREATE INDEX idx_otp_dead_letters_created_at ON otp_dead_letters (created_at);
+	CREATE INDEX idx_otp_dead_letters_phone_number_hash ON otp_dead_letters (phone_number_hash);`,
+	},
+	{
+		version: 22,
+		name:    "add_users_banned_at",
+		sql: `
+	ALTER TABLE users ADD COLUMN banned_at TIMESTAMPTZ;`,
+	},
+}
 
-	_, err := s.db.Exec(createUsersTable)
+// migrationLockID is the key of the advisory lock that keeps replicas starting at the same
+// time from applying the same migration twice.
+const migrationLockID = 7_164_583_201
+
+// runMigrations applies the migrations that haven't been recorded in schema_migrations yet.
+// The first two migrations use IF NOT EXISTS, so databases created before versioning was
+// introduced are adopted as they are.
+func (s *PostgresStore) runMigrations() error {
+	_, err := s.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`)
 	if err != nil {
-		return fmt.Errorf("failed to create users table: %w", err)
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
 	}
 
-	_, err = s.db.Exec(createOTPsTable)
+	for _, m := range migrations {
+		if err := s.applyMigration(m); err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %w", m.version, m.name, err)
+		}
+	}
+
+	slog.Info("Database migrations completed successfully", "version", migrations[len(migrations)-1].version)
+	return nil
+}
+
+// applyMigration runs a single migration in its own transaction, unless it was already applied.
+func (s *PostgresStore) applyMigration(m migration) error {
+	tx, err := s.db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to create otps table: %w", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLockID); err != nil {
+		return err
+	}
+
+	var applied bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", m.version).Scan(&applied); err != nil {
+		return err
+	}
+	if applied {
+		return nil
+	}
+
+	if _, err := tx.Exec(m.sql); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.version, m.name); err != nil {
+		return err
+	}
+
+	slog.Info("Applied database migration", "version", m.version, "name", m.name)
+	return tx.Commit()
+}
+
+// Ping checks that the database is reachable.
+func (s *PostgresStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// CheckMigrations returns an error unless every known migration has been applied, e.g. when
+// another replica is still migrating or the database was restored from an old backup.
+func (s *PostgresStore) CheckMigrations(ctx context.Context) error {
+	var version sql.NullInt64
+	if err := s.db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if latest := migrations[len(migrations)-1].version; int(version.Int64) < latest {
+		return fmt.Errorf("schema is at version %d, expected %d", version.Int64, latest)
+	}
+	return nil
+}
+
+// checkPhoneEncryption encrypts the phone numbers still stored in plain text when a phone
+// cipher is configured, and refuses to start without one once numbers have been encrypted.
+func (s *PostgresStore) checkPhoneEncryption() error {
+	if s.phones == nil {
+		var encrypted bool
+		if err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE phone_number_hash IS NOT NULL)").Scan(&encrypted); err != nil {
+			return fmt.Errorf("failed to check phone number encryption: %w", err)
+		}
+		if encrypted {
+			return errors.New("users table holds encrypted phone numbers but no phone encryption key is configured")
+		}
+		return nil
 	}
 
-	log.Println("Database migrations completed successfully.")
+	users, err := s.encryptPlainPhoneNumbers("users", "anonymized_at IS NULL")
+	if err != nil {
+		return err
+	}
+	phones, err := s.encryptPlainPhoneNumbers("user_phones", "TRUE")
+	if err != nil {
+		return err
+	}
+	merges, err := s.encryptPlainPhoneNumbers("user_merges", "phone_number <> ''")
+	if err != nil {
+		return err
+	}
+	deadLetters, err := s.encryptPlainPhoneNumbers("otp_dead_letters", "TRUE")
+	if err != nil {
+		return err
+	}
+	if users > 0 || phones > 0 || merges > 0 || deadLetters > 0 {
+		slog.Info("Encrypted plain text phone numbers", "users", users, "secondary_numbers", phones, "merges", merges, "dead_letters", deadLetters)
+	}
 	return nil
 }
 
+// encryptPlainPhoneNumbers encrypts the phone numbers of the table's rows still stored in
+// plain text that match the condition, and returns how many it encrypted.
+func (s *PostgresStore) encryptPlainPhoneNumbers(table, condition string) (int, error) {
+	rows, err := s.db.Query("SELECT id, phone_number FROM " + table + " WHERE phone_number_hash IS NULL AND " + condition)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read plain text phone numbers: %w", err)
+	}
+	plain := map[uuid.UUID]string{}
+	for rows.Next() {
+		var id uuid.UUID
+		var phoneNumber string
+		if err := rows.Scan(&id, &phoneNumber); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan %s row: %w", table, err)
+		}
+		plain[id] = phoneNumber
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read plain text phone numbers: %w", err)
+	}
+
+	for id, phoneNumber := range plain {
+		encrypted, hash, err := s.encodePhoneNumber(phoneNumber)
+		if err != nil {
+			return 0, err
+		}
+		// Another replica may be encrypting the same rows; only touch rows still in plain text.
+		if _, err := s.db.Exec("UPDATE "+table+" SET phone_number = $2, phone_number_hash = $3 WHERE id = $1 AND phone_number_hash IS NULL AND "+condition,
+			id, encrypted, hash); err != nil {
+			return 0, fmt.Errorf("failed to encrypt phone number %s of %s: %w", id, table, err)
+		}
+	}
+	return len(plain), nil
+}
+
+// encodePhoneNumber returns the values stored in the phone_number and phone_number_hash columns.
+func (s *PostgresStore) encodePhoneNumber(phoneNumber string) (string, sql.NullString, error) {
+	if s.phones == nil {
+		return phoneNumber, sql.NullString{}, nil
+	}
+	encrypted, err := s.phones.Encrypt(phoneNumber)
+	if err != nil {
+		return "", sql.NullString{}, fmt.Errorf("failed to encrypt phone number: %w", err)
+	}
+	return encrypted, sql.NullString{String: s.phones.Index(phoneNumber), Valid: true}, nil
+}
+
+// decodePhoneNumber reverses encodePhoneNumber for a value read from the phone_number column.
+func (s *PostgresStore) decodePhoneNumber(stored string) (string, error) {
+	if s.phones == nil {
+		return stored, nil
+	}
+	return s.phones.Decrypt(stored)
+}
+
+// phoneNumberFilter returns the condition and argument matching a full phone number.
+func (s *PostgresStore) phoneNumberFilter(phoneNumber string, argID int) (string, string) {
+	if s.phones == nil {
+		return fmt.Sprintf("phone_number = $%d", argID), phoneNumber
+	}
+	return fmt.Sprintf("phone_number_hash = $%d", argID), s.phones.Index(phoneNumber)
+}
+
+// startSpan starts a client span for a single SQL statement, so slow requests can be
+// traced down to the exact query.
+func (s *PostgresStore) startSpan(ctx context.Context, operation, query string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "PostgresStore."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation", operation),
+			attribute.String("db.statement", query),
+		),
+	)
+}
+
+// recordQueryError marks the span as failed, except for "no rows" which is an expected outcome.
+func recordQueryError(span trace.Span, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	tracing.RecordError(span, err)
+}
+
 // --- UserStore Implementation ---
 
-func (s *PostgresStore) CreateUser(user model.User) (model.User, error) {
+func (s *PostgresStore) CreateUser(ctx context.Context, user model.User) (model.User, error) {
+	return s.createUser(ctx, s.db, user)
+}
+
+// queryer is what *sql.DB and *sql.Tx have in common, so a query can run in a transaction or not.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// CreateUserWithEvent creates the user and writes the event built for it to the outbox in one
+// transaction, so the event is stored if and only if the user is.
+func (s *PostgresStore) CreateUserWithEvent(ctx context.Context, user model.User, event func(model.User) model.Event) (model.User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.User{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	user, err = s.createUser(ctx, tx, user)
+	if err != nil {
+		return model.User{}, err
+	}
+	if err := s.addOutboxEvent(ctx, tx, event(user)); err != nil {
+		return model.User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return model.User{}, fmt.Errorf("failed to create user: %w", err)
+	}
+	return user, nil
+}
+
+func (s *PostgresStore) createUser(ctx context.Context, q queryer, user model.User) (model.User, error) {
 	query := `
-		INSERT INTO users (phone_number)
-		VALUES ($1)
+		INSERT INTO users (id, phone_number, phone_number_hash, phone_verified_at)
+		VALUES (COALESCE($3, gen_random_uuid()), $1, $2, $4)
 		RETURNING id, created_at, updated_at;
 	`
-	row := s.db.QueryRow(query, user.PhoneNumber)
-	err := row.Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	ctx, span := s.startSpan(ctx, "CreateUser", query)
+	defer span.End()
+
+	phoneNumber, phoneHash, err := s.encodePhoneNumber(user.PhoneNumber)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return model.User{}, err
+	}
+
+	// A preset ID is kept, e.g. that of an upgraded guest.
+	var id *uuid.UUID
+	if user.ID != uuid.Nil {
+		id = &user.ID
+	}
+	row := q.QueryRowContext(ctx, query, phoneNumber, phoneHash, id, user.PhoneVerifiedAt)
+	err = row.Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	tracing.RecordError(span, err)
 
 	if err != nil {
 		// Check for unique constraint violation
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			if pqErr.Constraint == "users_pkey" {
+				return model.User{}, fmt.Errorf("%w: user with ID %s", ErrAlreadyExists, user.ID)
+			}
 			return model.User{}, fmt.Errorf("%w: user with phone number %s", ErrAlreadyExists, user.PhoneNumber)
 		}
 		return model.User{}, fmt.Errorf("failed to create user: %w", err)
@@ -103,11 +607,15 @@ func (s *PostgresStore) CreateUser(user model.User) (model.User, error) {
 	return user, nil
 }
 
-func (s *PostgresStore) GetUserByID(id uuid.UUID) (model.User, error) {
+func (s *PostgresStore) GetUserByID(ctx context.Context, id uuid.UUID) (model.User, error) {
 	var user model.User
-	query := `SELECT id, phone_number, created_at, updated_at FROM users WHERE id = $1;`
-	row := s.db.QueryRow(query, id)
-	err := row.Scan(&user.ID, &user.PhoneNumber, &user.CreatedAt, &user.UpdatedAt)
+	query := `SELECT id, phone_number, name, email, email_verified_at, phone_verified_at, created_at, updated_at, banned_at FROM users WHERE id = $1 AND deleted_at IS NULL;`
+	ctx, span := s.startSpan(ctx, "GetUserByID", query)
+	defer span.End()
+
+	row := s.db.QueryRowContext(ctx, query, id)
+	err := row.Scan(&user.ID, &user.PhoneNumber, &user.Name, &user.Email, &user.EmailVerifiedAt, &user.PhoneVerifiedAt, &user.CreatedAt, &user.UpdatedAt, &user.BannedAt)
+	recordQueryError(span, err)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -115,14 +623,23 @@ func (s *PostgresStore) GetUserByID(id uuid.UUID) (model.User, error) {
 		}
 		return model.User{}, fmt.Errorf("failed to get user by ID: %w", err)
 	}
+	if user.PhoneNumber, err = s.decodePhoneNumber(user.PhoneNumber); err != nil {
+		tracing.RecordError(span, err)
+		return model.User{}, fmt.Errorf("failed to get user by ID: %w", err)
+	}
 	return user, nil
 }
 
-func (s *PostgresStore) GetUserByPhoneNumber(phoneNumber string) (model.User, error) {
+func (s *PostgresStore) GetUserByPhoneNumber(ctx context.Context, phoneNumber string) (model.User, error) {
 	var user model.User
-	query := `SELECT id, phone_number, created_at, updated_at FROM users WHERE phone_number = $1;`
-	row := s.db.QueryRow(query, phoneNumber)
-	err := row.Scan(&user.ID, &user.PhoneNumber, &user.CreatedAt, &user.UpdatedAt)
+	filter, arg := s.phoneNumberFilter(phoneNumber, 1)
+	query := `SELECT id, phone_number, name, email, email_verified_at, phone_verified_at, created_at, updated_at, banned_at FROM users WHERE ` + filter + ` AND deleted_at IS NULL;`
+	ctx, span := s.startSpan(ctx, "GetUserByPhoneNumber", query)
+	defer span.End()
+
+	row := s.db.QueryRowContext(ctx, query, arg)
+	err := row.Scan(&user.ID, &user.PhoneNumber, &user.Name, &user.Email, &user.EmailVerifiedAt, &user.PhoneVerifiedAt, &user.CreatedAt, &user.UpdatedAt, &user.BannedAt)
+	recordQueryError(span, err)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -130,95 +647,1643 @@ func (s *PostgresStore) GetUserByPhoneNumber(phoneNumber string) (model.User, er
 		}
 		return model.User{}, fmt.Errorf("failed to get user by phone number: %w", err)
 	}
+	// The row was found by this number, so there is no need to decrypt the stored copy.
+	user.PhoneNumber = phoneNumber
 	return user, nil
 }
 
-func (s *PostgresStore) ListUsers(limit, offset int, search string) ([]model.User, int, error) {
-	var users []model.User
+func (s *PostgresStore) GetUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]model.User, error) {
+	query := `SELECT id, phone_number, name, email, email_verified_at, phone_verified_at, created_at, updated_at, banned_at FROM users WHERE id = ANY($1) AND deleted_at IS NULL;`
+	ctx, span := s.startSpan(ctx, "GetUsersByIDs", query)
+	defer span.End()
+
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(uuidStrings(ids)))
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to get users by IDs: %w", err)
+	}
+	defer rows.Close()
+
+	users := []model.User{}
+	for rows.Next() {
+		var user model.User
+		if err := rows.Scan(&user.ID, &user.PhoneNumber, &user.Name, &user.Email, &user.EmailVerifiedAt, &user.PhoneVerifiedAt, &user.CreatedAt, &user.UpdatedAt, &user.BannedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan user row: %w", err)
+		}
+		if user.PhoneNumber, err = s.decodePhoneNumber(user.PhoneNumber); err != nil {
+			return nil, fmt.Errorf("failed to decrypt phone number of user %s: %w", user.ID, err)
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (s *PostgresStore) ListUsers(ctx context.Context, limit, offset int, search string) ([]model.User, int, error) {
+	users := []model.User{}
 	var total int
 
 	// Base query for listing users
-	baseQuery := `FROM users`
+	baseQuery := `FROM users WHERE deleted_at IS NULL`
 	var args []interface{}
-	argID := 1
 
-	// Add search filter if provided
-	if search != "" {
-		baseQuery += fmt.Sprintf(" WHERE phone_number LIKE $%d", argID)
+	// Add search filter if provided. Encrypted phone numbers can only be matched exactly.
+	if search != "" && s.phones != nil {
+		filter, arg := s.phoneNumberFilter(search, len(args)+1)
+		baseQuery += " AND " + filter
+		args = append(args, arg)
+	} else if search != "" {
+		baseQuery += fmt.Sprintf(" AND phone_number LIKE $%d", len(args)+1)
 		args = append(args, "%"+search+"%")
-		argID++
 	}
 
-	// Query to get the total count of users matching the filter
-	countQuery := `SELECT COUNT(*) ` + baseQuery
-	err := s.db.QueryRow(countQuery, args...).Scan(&total)
-	if err != nil {
-		return nil, 0, fmt.Errorf("failed to count users: %w", err)
-	}
+	// The window function counts the matching rows before LIMIT applies, so one query returns
+	// both the page and the total.
+	listQuery := `SELECT id, phone_number, name, email, email_verified_at, phone_verified_at, created_at, updated_at, banned_at, COUNT(*) OVER() ` + baseQuery +
+		fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	listArgs := append(args, limit, offset)
 
-	// Query to get the paginated list of users
-	listQuery := `SELECT id, phone_number, created_at, updated_at ` + baseQuery +
-		fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d OFFSET $%d", argID, argID+1)
-	args = append(args, limit, offset)
+	ctx, span := s.startSpan(ctx, "ListUsers", listQuery)
+	defer span.End()
 
-	rows, err := s.db.Query(listQuery, args...)
+	rows, err := s.db.QueryContext(ctx, listQuery, listArgs...)
 	if err != nil {
+		tracing.RecordError(span, err)
 		return nil, 0, fmt.Errorf("failed to list users: %w", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var user model.User
-		if err := rows.Scan(&user.ID, &user.PhoneNumber, &user.CreatedAt, &user.UpdatedAt); err != nil {
+		if err := rows.Scan(&user.ID, &user.PhoneNumber, &user.Name, &user.Email, &user.EmailVerifiedAt, &user.PhoneVerifiedAt, &user.CreatedAt, &user.UpdatedAt, &user.BannedAt, &total); err != nil {
 			return nil, 0, fmt.Errorf("failed to scan user row: %w", err)
 		}
+		if user.PhoneNumber, err = s.decodePhoneNumber(user.PhoneNumber); err != nil {
+			return nil, 0, fmt.Errorf("failed to decrypt phone number of user %s: %w", user.ID, err)
+		}
 		users = append(users, user)
 	}
+	if err := rows.Err(); err != nil {
+		tracing.RecordError(span, err)
+		return nil, 0, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	// A page past the end has no rows to carry the count; only then is it queried separately.
+	if len(users) == 0 && offset > 0 {
+		countQuery := `SELECT COUNT(*) ` + baseQuery
+		countCtx, countSpan := s.startSpan(ctx, "CountUsers", countQuery)
+		err := s.db.QueryRowContext(countCtx, countQuery, args...).Scan(&total)
+		tracing.RecordError(countSpan, err)
+		countSpan.End()
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to count users: %w", err)
+		}
+	}
 
 	return users, total, nil
 }
 
-// --- OTPStore Implementation ---
-
-// StoreOTP uses an "UPSERT" operation to either insert a new OTP or update an existing one for a given phone number.
-func (s *PostgresStore) StoreOTP(otp model.OTP) error {
+func (s *PostgresStore) UpdateUser(ctx context.Context, user model.User) (model.User, error) {
 	query := `
-		INSERT INTO otps (phone_number, otp_code, expires_at)
-		VALUES ($1, $2, $3)
-		ON CONFLICT (phone_number) DO UPDATE
-		SET otp_code = EXCLUDED.otp_code, expires_at = EXCLUDED.expires_at, created_at = NOW();
+		UPDATE users SET name = $2, email = $3, updated_at = NOW(),
+			email_verified_at = CASE WHEN email = $3 THEN email_verified_at END
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING phone_number, email_verified_at, phone_verified_at, created_at, updated_at;
 	`
-	_, err := s.db.Exec(query, otp.PhoneNumber, otp.OTPCode, otp.ExpiresAt)
+	ctx, span := s.startSpan(ctx, "UpdateUser", query)
+	defer span.End()
+
+	row := s.db.QueryRowContext(ctx, query, user.ID, user.Name, user.Email)
+	err := row.Scan(&user.PhoneNumber, &user.EmailVerifiedAt, &user.PhoneVerifiedAt, &user.CreatedAt, &user.UpdatedAt)
+	recordQueryError(span, err)
+
 	if err != nil {
-		return fmt.Errorf("failed to store OTP: %w", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.User{}, fmt.Errorf("%w: user with ID %s", ErrNotFound, user.ID)
+		}
+		return model.User{}, fmt.Errorf("failed to update user: %w", err)
+	}
+	if user.PhoneNumber, err = s.decodePhoneNumber(user.PhoneNumber); err != nil {
+		tracing.RecordError(span, err)
+		return model.User{}, fmt.Errorf("failed to update user: %w", err)
+	}
+	return user, nil
+}
+
+// MarkPhoneVerified records that the user verified their phone number at the time.
+func (s *PostgresStore) MarkPhoneVerified(ctx context.Context, id uuid.UUID, at time.Time) error {
+	query := `UPDATE users SET phone_verified_at = $2, updated_at = NOW() WHERE id = $1 AND deleted_at IS NULL;`
+	ctx, span := s.startSpan(ctx, "MarkPhoneVerified", query)
+	defer span.End()
+
+	result, err := s.db.ExecContext(ctx, query, id, at)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to mark phone number verified: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("%w: user with ID %s", ErrNotFound, id)
 	}
 	return nil
 }
 
-func (s *PostgresStore) GetOTP(phoneNumber string) (model.OTP, error) {
-	var otp model.OTP
-	query := `SELECT id, phone_number, otp_code, created_at, expires_at FROM otps WHERE phone_number = $1;`
-	row := s.db.QueryRow(query, phoneNumber)
-	err := row.Scan(&otp.ID, &otp.PhoneNumber, &otp.OTPCode, &otp.CreatedAt, &otp.ExpiresAt)
+func (s *PostgresStore) SetPasswordHash(ctx context.Context, id uuid.UUID, hash string) error {
+	query := `UPDATE users SET password_hash = $2, updated_at = NOW() WHERE id = $1 AND deleted_at IS NULL;`
+	ctx, span := s.startSpan(ctx, "SetPasswordHash", query)
+	defer span.End()
+
+	result, err := s.db.ExecContext(ctx, query, id, hash)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to set password: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("%w: user with ID %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetPasswordHash(ctx context.Context, id uuid.UUID) (string, error) {
+	query := `SELECT COALESCE(password_hash, '') FROM users WHERE id = $1 AND deleted_at IS NULL;`
+	ctx, span := s.startSpan(ctx, "GetPasswordHash", query)
+	defer span.End()
+
+	var hash string
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&hash)
+	recordQueryError(span, err)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return model.OTP{}, fmt.Errorf("%w: OTP for phone number %s", ErrNotFound, phoneNumber)
+			return "", fmt.Errorf("%w: user with ID %s", ErrNotFound, id)
 		}
-		return model.OTP{}, fmt.Errorf("failed to get OTP: %w", err)
+		return "", fmt.Errorf("failed to get password: %w", err)
 	}
-	return otp, nil
+	return hash, nil
 }
 
-func (s *PostgresStore) DeleteOTP(phoneNumber string) error {
-	query := `DELETE FROM otps WHERE phone_number = $1;`
-	_, err := s.db.Exec(query, phoneNumber)
+// DeleteUser marks the user deleted. The row is kept, but no longer found by any query.
+func (s *PostgresStore) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	// The secondary phone numbers are dropped, so they can sign up again too.
+	query := `
+		WITH deleted AS (
+			UPDATE users SET deleted_at = NOW(), updated_at = NOW() WHERE id = $1 AND deleted_at IS NULL RETURNING id
+		), phones AS (
+			DELETE FROM user_phones WHERE user_id IN (SELECT id FROM deleted)
+		)
+		SELECT COUNT(*) FROM deleted;
+	`
+	ctx, span := s.startSpan(ctx, "DeleteUser", query)
+	defer span.End()
+
+	var rows int
+	if err := s.db.QueryRowContext(ctx, query, id).Scan(&rows); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w: user with ID %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+// DeleteUsers deletes the users like DeleteUser in one statement, so either all of them are
+// deleted or none.
+func (s *PostgresStore) DeleteUsers(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error) {
+	query := `
+		WITH deleted AS (
+			UPDATE users SET deleted_at = NOW(), updated_at = NOW() WHERE id = ANY($1) AND deleted_at IS NULL RETURNING id
+		), phones AS (
+			DELETE FROM user_phones WHERE user_id IN (SELECT id FROM deleted)
+		)
+		SELECT id FROM deleted;
+	`
+	ctx, span := s.startSpan(ctx, "DeleteUsers", query)
+	defer span.End()
+
+	deleted, err := s.queryIDs(ctx, query, pq.Array(uuidStrings(ids)))
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to delete users: %w", err)
+	}
+	return deleted, nil
+}
+
+// SetUsersBanned bans or unbans the users in one statement, so either all of them are
+// updated or none.
+func (s *PostgresStore) SetUsersBanned(ctx context.Context, ids []uuid.UUID, bannedAt *time.Time) ([]uuid.UUID, error) {
+	query := `
+		UPDATE users SET banned_at = CASE WHEN $2::timestamptz IS NULL THEN NULL ELSE COALESCE(banned_at, $2) END, updated_at = NOW()
+		WHERE id = ANY($1) AND deleted_at IS NULL
+		RETURNING id;
+	`
+	ctx, span := s.startSpan(ctx, "SetUsersBanned", query)
+	defer span.End()
+
+	updated, err := s.queryIDs(ctx, query, pq.Array(uuidStrings(ids)), bannedAt)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to update bans: %w", err)
+	}
+	return updated, nil
+}
+
+// queryIDs runs a query returning a column of IDs.
+func (s *PostgresStore) queryIDs(ctx context.Context, query string, args ...interface{}) ([]uuid.UUID, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// uuidStrings converts IDs for pq.Array, which can't encode uuid.UUID itself.
+func uuidStrings(ids []uuid.UUID) []string {
+	strs := make([]string, len(ids))
+	for i, id := range ids {
+		strs[i] = id.String()
+	}
+	return strs
+}
+
+// restorableUsers matches the deleted users that can be restored: neither anonymized nor merged
+// into another user.
+const restorableUsers = `deleted_at IS NOT NULL AND anonymized_at IS NULL
+	AND NOT EXISTS (SELECT 1 FROM user_merges m WHERE m.source_user_id = users.id)`
+
+func (s *PostgresStore) GetDeletedUserByPhoneNumber(ctx context.Context, phoneNumber string, deletedAfter time.Time) (model.User, error) {
+	var user model.User
+	filter, arg := s.phoneNumberFilter(phoneNumber, 1)
+	query := `
+		SELECT id, phone_number, name, email, email_verified_at, phone_verified_at, created_at, updated_at, deleted_at
+		FROM users WHERE ` + filter + ` AND deleted_at > $2 AND ` + restorableUsers + `
+		ORDER BY deleted_at DESC LIMIT 1;
+	`
+	ctx, span := s.startSpan(ctx, "GetDeletedUserByPhoneNumber", query)
+	defer span.End()
+
+	row := s.db.QueryRowContext(ctx, query, arg, deletedAfter)
+	err := row.Scan(&user.ID, &user.PhoneNumber, &user.Name, &user.Email, &user.EmailVerifiedAt, &user.PhoneVerifiedAt, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt)
+	recordQueryError(span, err)
+
 	if err != nil {
-		// It's safe to ignore "not found" errors on delete
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil
+			return model.User{}, fmt.Errorf("%w: deleted user with phone number %s", ErrNotFound, phoneNumber)
 		}
-		return fmt.Errorf("failed to delete OTP: %w", err)
+		return model.User{}, fmt.Errorf("failed to get deleted user by phone number: %w", err)
+	}
+	user.PhoneNumber = phoneNumber
+	return user, nil
+}
+
+func (s *PostgresStore) ListDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) ([]model.User, error) {
+	query := `
+		SELECT id, phone_number, name, email, email_verified_at, phone_verified_at, created_at, updated_at, deleted_at
+		FROM users WHERE deleted_at < $1 AND ` + restorableUsers + `
+		ORDER BY deleted_at LIMIT $2;
+	`
+	ctx, span := s.startSpan(ctx, "ListDeletedUsers", query)
+	defer span.End()
+
+	rows, err := s.db.QueryContext(ctx, query, deletedBefore, limit)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to list deleted users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []model.User{}
+	for rows.Next() {
+		var user model.User
+		if err := rows.Scan(&user.ID, &user.PhoneNumber, &user.Name, &user.Email, &user.EmailVerifiedAt, &user.PhoneVerifiedAt, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan user row: %w", err)
+		}
+		if user.PhoneNumber, err = s.decodePhoneNumber(user.PhoneNumber); err != nil {
+			return nil, fmt.Errorf("failed to decrypt phone number of user %s: %w", user.ID, err)
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// RestoreUser undeletes the user. A verified email address that another user verified in the
+// meantime becomes unverified.
+func (s *PostgresStore) RestoreUser(ctx context.Context, id uuid.UUID) error {
+	query := `
+		UPDATE users SET deleted_at = NULL, updated_at = NOW(),
+			email_verified_at = CASE WHEN EXISTS (
+				SELECT 1 FROM users o WHERE o.email = users.email AND o.email_verified_at IS NOT NULL AND o.deleted_at IS NULL
+			) THEN NULL ELSE email_verified_at END
+		WHERE id = $1 AND ` + restorableUsers + `;
+	`
+	ctx, span := s.startSpan(ctx, "RestoreUser", query)
+	defer span.End()
+
+	result, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		tracing.RecordError(span, err)
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return fmt.Errorf("%w: phone number of user %s", ErrAlreadyExists, id)
+		}
+		return fmt.Errorf("failed to restore user: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to restore user: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w: deleted user with ID %s", ErrNotFound, id)
 	}
 	return nil
 }
+
+// AnonymizeUser scrubs the phone number, name and email of the user, deleted or not, drops
+// their secondary phone numbers, scrubs the phone number of their merges and marks them
+// deleted. It returns the user as they were before.
+func (s *PostgresStore) AnonymizeUser(ctx context.Context, id uuid.UUID) (model.User, error) {
+	query := `
+		WITH phones AS (DELETE FROM user_phones WHERE user_id = $1), email_otps AS (DELETE FROM email_otps WHERE user_id = $1),
+			merges AS (UPDATE user_merges SET phone_number = '', phone_number_hash = NULL WHERE target_user_id = $1 OR source_user_id = $1)
+		UPDATE users u SET phone_number = '', phone_number_hash = NULL, name = '', email = '', email_verified_at = NULL, phone_verified_at = NULL, password_hash = NULL,
+			deleted_at = COALESCE(u.deleted_at, NOW()), anonymized_at = COALESCE(u.anonymized_at, NOW()), updated_at = NOW()
+		FROM (SELECT id, phone_number, name, email, email_verified_at, phone_verified_at, created_at, updated_at FROM users WHERE id = $1 FOR UPDATE) old
+		WHERE u.id = old.id
+		RETURNING old.id, old.phone_number, old.name, old.email, old.email_verified_at, old.phone_verified_at, old.created_at, old.updated_at;
+	`
+	ctx, span := s.startSpan(ctx, "AnonymizeUser", query)
+	defer span.End()
+
+	var user model.User
+	row := s.db.QueryRowContext(ctx, query, id)
+	err := row.Scan(&user.ID, &user.PhoneNumber, &user.Name, &user.Email, &user.EmailVerifiedAt, &user.PhoneVerifiedAt, &user.CreatedAt, &user.UpdatedAt)
+	recordQueryError(span, err)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.User{}, fmt.Errorf("%w: user with ID %s", ErrNotFound, id)
+		}
+		return model.User{}, fmt.Errorf("failed to anonymize user: %w", err)
+	}
+	// Users anonymized before have no phone number left to decrypt.
+	if user.PhoneNumber != "" {
+		if user.PhoneNumber, err = s.decodePhoneNumber(user.PhoneNumber); err != nil {
+			tracing.RecordError(span, err)
+			return model.User{}, fmt.Errorf("failed to anonymize user: %w", err)
+		}
+	}
+	return user, nil
+}
+
+// --- Secondary phone numbers ---
+
+func (s *PostgresStore) MovePhoneNumbers(ctx context.Context, fromUserID, toUserID uuid.UUID) error {
+	query := `UPDATE user_phones SET user_id = $2 WHERE user_id = $1;`
+	ctx, span := s.startSpan(ctx, "MovePhoneNumbers", query)
+	defer span.End()
+
+	if _, err := s.db.ExecContext(ctx, query, fromUserID, toUserID); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to move phone numbers: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) ListPhoneNumbers(ctx context.Context, userID uuid.UUID) ([]model.UserPhone, error) {
+	query := `SELECT id, user_id, phone_number, verified_at FROM user_phones WHERE user_id = $1 ORDER BY created_at;`
+	ctx, span := s.startSpan(ctx, "ListPhoneNumbers", query)
+	defer span.End()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to list phone numbers: %w", err)
+	}
+	defer rows.Close()
+
+	phones := []model.UserPhone{}
+	for rows.Next() {
+		var phone model.UserPhone
+		if err := rows.Scan(&phone.ID, &phone.UserID, &phone.PhoneNumber, &phone.VerifiedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan phone number row: %w", err)
+		}
+		if phone.PhoneNumber, err = s.decodePhoneNumber(phone.PhoneNumber); err != nil {
+			return nil, fmt.Errorf("failed to decrypt phone number %s: %w", phone.ID, err)
+		}
+		phones = append(phones, phone)
+	}
+	return phones, rows.Err()
+}
+
+func (s *PostgresStore) GetPhoneNumber(ctx context.Context, phoneNumber string) (model.UserPhone, error) {
+	filter, arg := s.phoneNumberFilter(phoneNumber, 1)
+	query := `SELECT id, user_id, verified_at FROM user_phones WHERE ` + filter + `;`
+	ctx, span := s.startSpan(ctx, "GetPhoneNumber", query)
+	defer span.End()
+
+	phone := model.UserPhone{PhoneNumber: phoneNumber}
+	err := s.db.QueryRowContext(ctx, query, arg).Scan(&phone.ID, &phone.UserID, &phone.VerifiedAt)
+	recordQueryError(span, err)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.UserPhone{}, fmt.Errorf("%w: phone number %s", ErrNotFound, phoneNumber)
+		}
+		return model.UserPhone{}, fmt.Errorf("failed to get phone number: %w", err)
+	}
+	return phone, nil
+}
+
+func (s *PostgresStore) AddPhoneNumber(ctx context.Context, phone model.UserPhone) (model.UserPhone, error) {
+	query := `
+		INSERT INTO user_phones (user_id, phone_number, phone_number_hash, verified_at)
+		SELECT id, $2, $3, $4 FROM users WHERE id = $1 AND deleted_at IS NULL
+		RETURNING id;
+	`
+	ctx, span := s.startSpan(ctx, "AddPhoneNumber", query)
+	defer span.End()
+
+	phoneNumber, phoneHash, err := s.encodePhoneNumber(phone.PhoneNumber)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return model.UserPhone{}, err
+	}
+	err = s.db.QueryRowContext(ctx, query, phone.UserID, phoneNumber, phoneHash, phone.VerifiedAt).Scan(&phone.ID)
+	recordQueryError(span, err)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.UserPhone{}, fmt.Errorf("%w: user with ID %s", ErrNotFound, phone.UserID)
+		}
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return model.UserPhone{}, fmt.Errorf("%w: phone number %s", ErrAlreadyExists, phone.PhoneNumber)
+		}
+		return model.UserPhone{}, fmt.Errorf("failed to add phone number: %w", err)
+	}
+	phone.Primary = false
+	return phone, nil
+}
+
+func (s *PostgresStore) DeletePhoneNumber(ctx context.Context, userID, id uuid.UUID) error {
+	query := `DELETE FROM user_phones WHERE id = $1 AND user_id = $2;`
+	ctx, span := s.startSpan(ctx, "DeletePhoneNumber", query)
+	defer span.End()
+
+	result, err := s.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to delete phone number: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: phone number with ID %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+// SwapPrimaryPhoneNumber exchanges the stored values of the two numbers, so neither needs to
+// be decrypted. The old primary number was last verified when the account was.
+func (s *PostgresStore) SwapPrimaryPhoneNumber(ctx context.Context, userID, id uuid.UUID) error {
+	query := `
+		WITH swap AS (
+			SELECT p.id, p.user_id, p.phone_number AS secondary, p.phone_number_hash AS secondary_hash,
+				u.phone_number AS primary_number, u.phone_number_hash AS primary_hash,
+				COALESCE(u.phone_verified_at, u.created_at) AS primary_verified_at
+			FROM user_phones p JOIN users u ON u.id = p.user_id
+			WHERE p.id = $1 AND p.user_id = $2 AND u.deleted_at IS NULL
+			FOR UPDATE
+		), promoted AS (
+			UPDATE users u SET phone_number = swap.secondary, phone_number_hash = swap.secondary_hash, updated_at = NOW()
+			FROM swap WHERE u.id = swap.user_id
+		)
+		UPDATE user_phones p SET phone_number = swap.primary_number, phone_number_hash = swap.primary_hash,
+			verified_at = swap.primary_verified_at
+		FROM swap WHERE p.id = swap.id;
+	`
+	ctx, span := s.startSpan(ctx, "SwapPrimaryPhoneNumber", query)
+	defer span.End()
+
+	result, err := s.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to swap primary phone number: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: phone number with ID %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+// --- EmailStore Implementation ---
+
+// StoreEmailOTP replaces the pending email code of the user.
+func (s *PostgresStore) StoreEmailOTP(ctx context.Context, otp model.EmailOTP) error {
+	query := `
+		INSERT INTO email_otps (user_id, email, purpose, code_hash, attempts, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, 0, $5, $6)
+		ON CONFLICT (user_id) DO UPDATE
+		SET email = EXCLUDED.email, purpose = EXCLUDED.purpose, code_hash = EXCLUDED.code_hash,
+			attempts = 0, expires_at = EXCLUDED.expires_at, created_at = EXCLUDED.created_at;
+	`
+	ctx, span := s.startSpan(ctx, "StoreEmailOTP", query)
+	defer span.End()
+
+	if _, err := s.db.ExecContext(ctx, query, otp.UserID, otp.Email, otp.Purpose, otp.CodeHash, otp.ExpiresAt, otp.CreatedAt); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to store email OTP: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetEmailOTP(ctx context.Context, userID uuid.UUID) (model.EmailOTP, error) {
+	query := `SELECT user_id, email, purpose, code_hash, attempts, expires_at, created_at FROM email_otps WHERE user_id = $1;`
+	ctx, span := s.startSpan(ctx, "GetEmailOTP", query)
+	defer span.End()
+
+	var otp model.EmailOTP
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&otp.UserID, &otp.Email, &otp.Purpose, &otp.CodeHash, &otp.Attempts, &otp.ExpiresAt, &otp.CreatedAt)
+	recordQueryError(span, err)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.EmailOTP{}, fmt.Errorf("%w: email OTP of user %s", ErrNotFound, userID)
+		}
+		return model.EmailOTP{}, fmt.Errorf("failed to get email OTP: %w", err)
+	}
+	return otp, nil
+}
+
+func (s *PostgresStore) IncrementEmailOTPAttempts(ctx context.Context, userID uuid.UUID) error {
+	query := `UPDATE email_otps SET attempts = attempts + 1 WHERE user_id = $1;`
+	ctx, span := s.startSpan(ctx, "IncrementEmailOTPAttempts", query)
+	defer span.End()
+
+	if _, err := s.db.ExecContext(ctx, query, userID); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to count email OTP attempt: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) DeleteEmailOTP(ctx context.Context, userID uuid.UUID) error {
+	query := `DELETE FROM email_otps WHERE user_id = $1;`
+	ctx, span := s.startSpan(ctx, "DeleteEmailOTP", query)
+	defer span.End()
+
+	if _, err := s.db.ExecContext(ctx, query, userID); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to delete email OTP: %w", err)
+	}
+	return nil
+}
+
+// MarkEmailVerified only verifies the address the user still has; another one set in the
+// meantime stays unverified.
+func (s *PostgresStore) MarkEmailVerified(ctx context.Context, userID uuid.UUID, email string, at time.Time) error {
+	query := `UPDATE users SET email_verified_at = $3, updated_at = NOW() WHERE id = $1 AND email = $2 AND deleted_at IS NULL;`
+	ctx, span := s.startSpan(ctx, "MarkEmailVerified", query)
+	defer span.End()
+
+	result, err := s.db.ExecContext(ctx, query, userID, email, at)
+	if err != nil {
+		tracing.RecordError(span, err)
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return fmt.Errorf("%w: verified email %s", ErrAlreadyExists, email)
+		}
+		return fmt.Errorf("failed to mark email verified: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: user %s with email %s", ErrNotFound, userID, email)
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetUserByVerifiedEmail(ctx context.Context, email string) (model.User, error) {
+	query := `SELECT id, phone_number, name, email, email_verified_at, phone_verified_at, created_at, updated_at
+		FROM users WHERE email = $1 AND email_verified_at IS NOT NULL AND deleted_at IS NULL;`
+	ctx, span := s.startSpan(ctx, "GetUserByVerifiedEmail", query)
+	defer span.End()
+
+	var user model.User
+	err := s.db.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.PhoneNumber, &user.Name, &user.Email, &user.EmailVerifiedAt, &user.PhoneVerifiedAt, &user.CreatedAt, &user.UpdatedAt)
+	recordQueryError(span, err)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.User{}, fmt.Errorf("%w: user with verified email %s", ErrNotFound, email)
+		}
+		return model.User{}, fmt.Errorf("failed to get user by email: %w", err)
+	}
+	if user.PhoneNumber, err = s.decodePhoneNumber(user.PhoneNumber); err != nil {
+		tracing.RecordError(span, err)
+		return model.User{}, fmt.Errorf("failed to get user by email: %w", err)
+	}
+	return user, nil
+}
+
+// --- MergeStore Implementation ---
+
+func (s *PostgresStore) CreateUserMerge(ctx context.Context, merge model.UserMerge) (model.UserMerge, error) {
+	query := `
+		INSERT INTO user_merges (target_user_id, source_user_id, phone_number, phone_number_hash, merged_by, reason)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at;
+	`
+	ctx, span := s.startSpan(ctx, "CreateUserMerge", query)
+	defer span.End()
+
+	phoneNumber, phoneHash, err := s.encodePhoneNumber(merge.SourcePhoneNumber)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return model.UserMerge{}, err
+	}
+	row := s.db.QueryRowContext(ctx, query, merge.TargetUserID, merge.SourceUserID, phoneNumber, phoneHash, merge.MergedBy, merge.Reason)
+	err = row.Scan(&merge.ID, &merge.CreatedAt)
+	tracing.RecordError(span, err)
+	if err != nil {
+		return model.UserMerge{}, fmt.Errorf("failed to create user merge: %w", err)
+	}
+	return merge, nil
+}
+
+func (s *PostgresStore) ListUserMerges(ctx context.Context, userID uuid.UUID) ([]model.UserMerge, error) {
+	query := `
+		SELECT id, target_user_id, source_user_id, phone_number, merged_by, reason, created_at
+		FROM user_merges WHERE target_user_id = $1 OR source_user_id = $1 ORDER BY created_at DESC;
+	`
+	ctx, span := s.startSpan(ctx, "ListUserMerges", query)
+	defer span.End()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to list user merges: %w", err)
+	}
+	defer rows.Close()
+
+	merges := []model.UserMerge{}
+	for rows.Next() {
+		var merge model.UserMerge
+		if err := rows.Scan(&merge.ID, &merge.TargetUserID, &merge.SourceUserID, &merge.SourcePhoneNumber,
+			&merge.MergedBy, &merge.Reason, &merge.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan user merge row: %w", err)
+		}
+		// Anonymizing either user scrubs the phone number.
+		if merge.SourcePhoneNumber != "" {
+			if merge.SourcePhoneNumber, err = s.decodePhoneNumber(merge.SourcePhoneNumber); err != nil {
+				return nil, fmt.Errorf("failed to decrypt phone number of user merge %s: %w", merge.ID, err)
+			}
+		}
+		merges = append(merges, merge)
+	}
+	return merges, rows.Err()
+}
+
+// --- DeadLetterStore Implementation ---
+
+func (s *PostgresStore) CreateDeadLetter(ctx context.Context, letter model.DeadLetter) (model.DeadLetter, error) {
+	query := `
+		INSERT INTO otp_dead_letters (phone_number, phone_number_hash, channel, tenant, error)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at;
+	`
+	ctx, span := s.startSpan(ctx, "CreateDeadLetter", query)
+	defer span.End()
+
+	phoneNumber, phoneHash, err := s.encodePhoneNumber(letter.PhoneNumber)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return model.DeadLetter{}, err
+	}
+	row := s.db.QueryRowContext(ctx, query, phoneNumber, phoneHash, letter.Channel, letter.Tenant, letter.Error)
+	err = row.Scan(&letter.ID, &letter.CreatedAt)
+	tracing.RecordError(span, err)
+	if err != nil {
+		return model.DeadLetter{}, fmt.Errorf("failed to create dead letter: %w", err)
+	}
+	return letter, nil
+}
+
+func (s *PostgresStore) GetDeadLetter(ctx context.Context, id uuid.UUID) (model.DeadLetter, error) {
+	query := `SELECT id, phone_number, channel, tenant, error, created_at FROM otp_dead_letters WHERE id = $1;`
+	ctx, span := s.startSpan(ctx, "GetDeadLetter", query)
+	defer span.End()
+
+	var letter model.DeadLetter
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&letter.ID, &letter.PhoneNumber, &letter.Channel, &letter.Tenant, &letter.Error, &letter.CreatedAt)
+	recordQueryError(span, err)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.DeadLetter{}, fmt.Errorf("%w: dead letter with ID %s", ErrNotFound, id)
+		}
+		return model.DeadLetter{}, fmt.Errorf("failed to get dead letter: %w", err)
+	}
+	if letter.PhoneNumber, err = s.decodePhoneNumber(letter.PhoneNumber); err != nil {
+		return model.DeadLetter{}, fmt.Errorf("failed to decrypt phone number of dead letter %s: %w", id, err)
+	}
+	return letter, nil
+}
+
+func (s *PostgresStore) ListDeadLetters(ctx context.Context, limit int) ([]model.DeadLetter, error) {
+	query := `
+		SELECT id, phone_number, channel, tenant, error, created_at
+		FROM otp_dead_letters ORDER BY created_at DESC LIMIT $1;
+	`
+	ctx, span := s.startSpan(ctx, "ListDeadLetters", query)
+	defer span.End()
+
+	rows, err := s.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	letters := []model.DeadLetter{}
+	for rows.Next() {
+		var letter model.DeadLetter
+		if err := rows.Scan(&letter.ID, &letter.PhoneNumber, &letter.Channel, &letter.Tenant, &letter.Error, &letter.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan dead letter row: %w", err)
+		}
+		if letter.PhoneNumber, err = s.decodePhoneNumber(letter.PhoneNumber); err != nil {
+			return nil, fmt.Errorf("failed to decrypt phone number of dead letter %s: %w", letter.ID, err)
+		}
+		letters = append(letters, letter)
+	}
+	return letters, rows.Err()
+}
+
+func (s *PostgresStore) DeleteDeadLetter(ctx context.Context, id uuid.UUID) error {
+	query := `DELETE FROM otp_dead_letters WHERE id = $1;`
+	ctx, span := s.startSpan(ctx, "DeleteDeadLetter", query)
+	defer span.End()
+
+	result, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to delete dead letter: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete dead letter: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w: dead letter with ID %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+// DeleteDeadLetters deletes the dead letters of the phone number.
+func (s *PostgresStore) DeleteDeadLetters(ctx context.Context, phoneNumber string) error {
+	filter, arg := s.phoneNumberFilter(phoneNumber, 1)
+	query := `DELETE FROM otp_dead_letters WHERE ` + filter + `;`
+	ctx, span := s.startSpan(ctx, "DeleteDeadLetters", query)
+	defer span.End()
+
+	if _, err := s.db.ExecContext(ctx, query, arg); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to delete dead letters: %w", err)
+	}
+	return nil
+}
+
+// --- OTPStore Implementation ---
+
+// StoreOTP uses an "UPSERT" operation to either insert a new OTP or update an existing one for a given phone number.
+func (s *PostgresStore) StoreOTP(ctx context.Context, otp model.OTP) error {
+	query := `
+		INSERT INTO otps (phone_number, otp_code, expires_at, channel, password_verified)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (phone_number) DO UPDATE
+		SET otp_code = EXCLUDED.otp_code, expires_at = EXCLUDED.expires_at, channel = EXCLUDED.channel,
+			password_verified = EXCLUDED.password_verified, created_at = NOW();
+	`
+	ctx, span := s.startSpan(ctx, "StoreOTP", query)
+	defer span.End()
+
+	_, err := s.db.ExecContext(ctx, query, otp.PhoneNumber, otp.OTPCode, otp.ExpiresAt, otp.Channel, otp.PasswordVerified)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to store OTP: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetOTP(ctx context.Context, phoneNumber string) (model.OTP, error) {
+	var otp model.OTP
+	query := `SELECT id, phone_number, otp_code, created_at, expires_at, channel, password_verified FROM otps WHERE phone_number = $1;`
+	ctx, span := s.startSpan(ctx, "GetOTP", query)
+	defer span.End()
+
+	row := s.db.QueryRowContext(ctx, query, phoneNumber)
+	err := row.Scan(&otp.ID, &otp.PhoneNumber, &otp.OTPCode, &otp.CreatedAt, &otp.ExpiresAt, &otp.Channel, &otp.PasswordVerified)
+	recordQueryError(span, err)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.OTP{}, fmt.Errorf("%w: OTP for phone number %s", ErrNotFound, phoneNumber)
+		}
+		return model.OTP{}, fmt.Errorf("failed to get OTP: %w", err)
+	}
+	return otp, nil
+}
+
+func (s *PostgresStore) DeleteOTP(ctx context.Context, phoneNumber string) error {
+	query := `DELETE FROM otps WHERE phone_number = $1;`
+	ctx, span := s.startSpan(ctx, "DeleteOTP", query)
+	defer span.End()
+
+	_, err := s.db.ExecContext(ctx, query, phoneNumber)
+	if err != nil {
+		tracing.RecordError(span, err)
+		// It's safe to ignore "not found" errors on delete
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete OTP: %w", err)
+	}
+	return nil
+}
+
+// --- APIKeyStore Implementation ---
+
+func (s *PostgresStore) CreateAPIKey(ctx context.Context, key model.APIKey) (model.APIKey, error) {
+	query := `
+		INSERT INTO api_keys (name, prefix, key_hash, scopes)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at;
+	`
+	ctx, span := s.startSpan(ctx, "CreateAPIKey", query)
+	defer span.End()
+
+	row := s.db.QueryRowContext(ctx, query, key.Name, key.Prefix, key.KeyHash, pq.Array(key.Scopes))
+	err := row.Scan(&key.ID, &key.CreatedAt)
+	tracing.RecordError(span, err)
+
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return model.APIKey{}, fmt.Errorf("%w: API key %s", ErrAlreadyExists, key.Prefix)
+		}
+		return model.APIKey{}, fmt.Errorf("failed to create API key: %w", err)
+	}
+	return key, nil
+}
+
+func (s *PostgresStore) GetAPIKeyByHash(ctx context.Context, keyHash string) (model.APIKey, error) {
+	var key model.APIKey
+	query := `SELECT id, name, prefix, key_hash, scopes, created_at, revoked_at FROM api_keys WHERE key_hash = $1;`
+	ctx, span := s.startSpan(ctx, "GetAPIKeyByHash", query)
+	defer span.End()
+
+	row := s.db.QueryRowContext(ctx, query, keyHash)
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.KeyHash, pq.Array(&key.Scopes), &key.CreatedAt, &key.RevokedAt)
+	recordQueryError(span, err)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.APIKey{}, fmt.Errorf("%w: API key", ErrNotFound)
+		}
+		return model.APIKey{}, fmt.Errorf("failed to get API key: %w", err)
+	}
+	return key, nil
+}
+
+func (s *PostgresStore) GetAPIKeyByID(ctx context.Context, id uuid.UUID) (model.APIKey, error) {
+	var key model.APIKey
+	query := `SELECT id, name, prefix, key_hash, scopes, created_at, revoked_at FROM api_keys WHERE id = $1;`
+	ctx, span := s.startSpan(ctx, "GetAPIKeyByID", query)
+	defer span.End()
+
+	row := s.db.QueryRowContext(ctx, query, id)
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.KeyHash, pq.Array(&key.Scopes), &key.CreatedAt, &key.RevokedAt)
+	recordQueryError(span, err)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.APIKey{}, fmt.Errorf("%w: API key with ID %s", ErrNotFound, id)
+		}
+		return model.APIKey{}, fmt.Errorf("failed to get API key: %w", err)
+	}
+	return key, nil
+}
+
+func (s *PostgresStore) ListAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	query := `SELECT id, name, prefix, key_hash, scopes, created_at, revoked_at FROM api_keys ORDER BY created_at DESC;`
+	ctx, span := s.startSpan(ctx, "ListAPIKeys", query)
+	defer span.End()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []model.APIKey{}
+	for rows.Next() {
+		var key model.APIKey
+		if err := rows.Scan(&key.ID, &key.Name, &key.Prefix, &key.KeyHash, pq.Array(&key.Scopes), &key.CreatedAt, &key.RevokedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan API key row: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *PostgresStore) RevokeAPIKey(ctx context.Context, id uuid.UUID, revokedAt time.Time) error {
+	query := `UPDATE api_keys SET revoked_at = COALESCE(revoked_at, $2) WHERE id = $1;`
+	ctx, span := s.startSpan(ctx, "RevokeAPIKey", query)
+	defer span.End()
+
+	result, err := s.db.ExecContext(ctx, query, id, revokedAt)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: API key with ID %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+// --- DeviceStore Implementation ---
+
+func (s *PostgresStore) CreateDevice(ctx context.Context, device model.Device) (model.Device, error) {
+	query := `
+		INSERT INTO devices (user_id, fingerprint, user_agent, last_ip, first_seen_at, last_seen_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id;
+	`
+	ctx, span := s.startSpan(ctx, "CreateDevice", query)
+	defer span.End()
+
+	row := s.db.QueryRowContext(ctx, query, device.UserID, device.Fingerprint, device.UserAgent, device.LastIP, device.FirstSeenAt, device.LastSeenAt)
+	err := row.Scan(&device.ID)
+	tracing.RecordError(span, err)
+
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return model.Device{}, fmt.Errorf("%w: device of user %s", ErrAlreadyExists, device.UserID)
+		}
+		return model.Device{}, fmt.Errorf("failed to create device: %w", err)
+	}
+	return device, nil
+}
+
+// AnonymizeDevices scrubs the fingerprint, user agent and IP of the user's devices and ends
+// their trust; the fingerprint becomes the device ID, which keeps it unique.
+func (s *PostgresStore) AnonymizeDevices(ctx context.Context, userID uuid.UUID) error {
+	query := `
+		UPDATE devices SET fingerprint = id::text, user_agent = '', last_ip = '', trust_token_hash = NULL, trusted_until = NULL
+		WHERE user_id = $1;
+	`
+	ctx, span := s.startSpan(ctx, "AnonymizeDevices", query)
+	defer span.End()
+
+	if _, err := s.db.ExecContext(ctx, query, userID); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to anonymize devices: %w", err)
+	}
+	return nil
+}
+
+// MoveDevices drops the devices the target user knows already, after pointing their logins to
+// the target user's device.
+func (s *PostgresStore) MoveDevices(ctx context.Context, fromUserID, toUserID uuid.UUID) error {
+	query := `
+		WITH known AS (
+			SELECT f.id AS from_id, t.id AS to_id FROM devices f
+			JOIN devices t ON t.user_id = $2 AND t.fingerprint = f.fingerprint
+			WHERE f.user_id = $1
+		), relinked AS (
+			UPDATE logins l SET device_id = known.to_id FROM known WHERE l.device_id = known.from_id
+		), dropped AS (
+			DELETE FROM devices WHERE id IN (SELECT from_id FROM known)
+		)
+		UPDATE devices SET user_id = $2 WHERE user_id = $1 AND id NOT IN (SELECT from_id FROM known);
+	`
+	ctx, span := s.startSpan(ctx, "MoveDevices", query)
+	defer span.End()
+
+	if _, err := s.db.ExecContext(ctx, query, fromUserID, toUserID); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to move devices: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) ListDevices(ctx context.Context, userID uuid.UUID) ([]model.Device, error) {
+	query := `
+		SELECT id, user_id, fingerprint, user_agent, last_ip, first_seen_at, last_seen_at,
+			COALESCE(trust_token_hash, ''), trusted_until
+		FROM devices WHERE user_id = $1 ORDER BY last_seen_at DESC;
+	`
+	ctx, span := s.startSpan(ctx, "ListDevices", query)
+	defer span.End()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	defer rows.Close()
+
+	devices := []model.Device{}
+	for rows.Next() {
+		var d model.Device
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Fingerprint, &d.UserAgent, &d.LastIP, &d.FirstSeenAt, &d.LastSeenAt,
+			&d.TrustTokenHash, &d.TrustedUntil); err != nil {
+			return nil, fmt.Errorf("failed to scan device row: %w", err)
+		}
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
+func (s *PostgresStore) TouchDevice(ctx context.Context, id uuid.UUID, ip, userAgent string, seenAt time.Time) error {
+	query := `UPDATE devices SET last_ip = $2, user_agent = $3, last_seen_at = $4 WHERE id = $1;`
+	ctx, span := s.startSpan(ctx, "TouchDevice", query)
+	defer span.End()
+
+	result, err := s.db.ExecContext(ctx, query, id, ip, userAgent, seenAt)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to update device: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: device with ID %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+func (s *PostgresStore) TrustDevice(ctx context.Context, id uuid.UUID, tokenHash string, until time.Time) error {
+	query := `UPDATE devices SET trust_token_hash = $2, trusted_until = $3 WHERE id = $1;`
+	ctx, span := s.startSpan(ctx, "TrustDevice", query)
+	defer span.End()
+
+	result, err := s.db.ExecContext(ctx, query, id, tokenHash, until)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to trust device: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: device with ID %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+func (s *PostgresStore) UntrustDevice(ctx context.Context, userID, id uuid.UUID) error {
+	query := `UPDATE devices SET trust_token_hash = NULL, trusted_until = NULL WHERE id = $1 AND user_id = $2;`
+	ctx, span := s.startSpan(ctx, "UntrustDevice", query)
+	defer span.End()
+
+	result, err := s.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to untrust device: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: device with ID %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+func (s *PostgresStore) UntrustDevices(ctx context.Context, userID uuid.UUID) (int, error) {
+	query := `UPDATE devices SET trust_token_hash = NULL, trusted_until = NULL WHERE user_id = $1 AND trust_token_hash IS NOT NULL;`
+	ctx, span := s.startSpan(ctx, "UntrustDevices", query)
+	defer span.End()
+
+	result, err := s.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return 0, fmt.Errorf("failed to untrust devices: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// --- LoginStore Implementation ---
+
+func (s *PostgresStore) CreateLogin(ctx context.Context, login model.Login) (model.Login, error) {
+	query := `
+		INSERT INTO logins (user_id, succeeded, method, reason, ip, user_agent, device_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id;
+	`
+	ctx, span := s.startSpan(ctx, "CreateLogin", query)
+	defer span.End()
+
+	row := s.db.QueryRowContext(ctx, query, login.UserID, login.Succeeded, login.Method, login.Reason,
+		login.IP, login.UserAgent, login.DeviceID, login.CreatedAt)
+	err := row.Scan(&login.ID)
+	tracing.RecordError(span, err)
+	if err != nil {
+		return model.Login{}, fmt.Errorf("failed to create login: %w", err)
+	}
+	return login, nil
+}
+
+func (s *PostgresStore) ListLogins(ctx context.Context, userID uuid.UUID, limit int, before *model.LoginCursor) ([]model.Login, error) {
+	query := `
+		SELECT id, user_id, succeeded, method, reason, ip, user_agent, device_id, created_at
+		FROM logins WHERE user_id = $1 AND ($3::timestamptz IS NULL OR (created_at, id) < ($3, $4))
+		ORDER BY created_at DESC, id DESC LIMIT $2;
+	`
+	ctx, span := s.startSpan(ctx, "ListLogins", query)
+	defer span.End()
+
+	var beforeTime *time.Time
+	var beforeID *uuid.UUID
+	if before != nil {
+		beforeTime, beforeID = &before.CreatedAt, &before.ID
+	}
+	rows, err := s.db.QueryContext(ctx, query, userID, limit, beforeTime, beforeID)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to list logins: %w", err)
+	}
+	defer rows.Close()
+
+	logins := []model.Login{}
+	for rows.Next() {
+		var l model.Login
+		if err := rows.Scan(&l.ID, &l.UserID, &l.Succeeded, &l.Method, &l.Reason, &l.IP, &l.UserAgent, &l.DeviceID, &l.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan login row: %w", err)
+		}
+		logins = append(logins, l)
+	}
+	return logins, rows.Err()
+}
+
+// AnonymizeLogins scrubs the IP and user agent of the user's logins, keeping when and how
+// they signed in.
+func (s *PostgresStore) AnonymizeLogins(ctx context.Context, userID uuid.UUID) error {
+	query := `UPDATE logins SET ip = '', user_agent = '' WHERE user_id = $1;`
+	ctx, span := s.startSpan(ctx, "AnonymizeLogins", query)
+	defer span.End()
+
+	if _, err := s.db.ExecContext(ctx, query, userID); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to anonymize logins: %w", err)
+	}
+	return nil
+}
+
+// --- IdentityStore Implementation ---
+
+func (s *PostgresStore) MoveLogins(ctx context.Context, fromUserID, toUserID uuid.UUID) error {
+	query := `UPDATE logins SET user_id = $2 WHERE user_id = $1;`
+	ctx, span := s.startSpan(ctx, "MoveLogins", query)
+	defer span.End()
+
+	if _, err := s.db.ExecContext(ctx, query, fromUserID, toUserID); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to move logins: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) CreateIdentity(ctx context.Context, identity model.Identity) (model.Identity, error) {
+	query := `
+		INSERT INTO identities (provider, subject, user_id, email)
+		VALUES ($1, $2, $3, NULLIF($4, ''))
+		RETURNING created_at;
+	`
+	ctx, span := s.startSpan(ctx, "CreateIdentity", query)
+	defer span.End()
+
+	row := s.db.QueryRowContext(ctx, query, identity.Provider, identity.Subject, identity.UserID, identity.Email)
+	err := row.Scan(&identity.CreatedAt)
+	tracing.RecordError(span, err)
+
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return model.Identity{}, fmt.Errorf("%w: %s identity %s", ErrAlreadyExists, identity.Provider, identity.Subject)
+		}
+		return model.Identity{}, fmt.Errorf("failed to create identity: %w", err)
+	}
+	return identity, nil
+}
+
+func (s *PostgresStore) GetIdentity(ctx context.Context, provider, subject string) (model.Identity, error) {
+	query := `
+		SELECT provider, subject, user_id, COALESCE(email, ''), created_at
+		FROM identities WHERE provider = $1 AND subject = $2;
+	`
+	ctx, span := s.startSpan(ctx, "GetIdentity", query)
+	defer span.End()
+
+	var identity model.Identity
+	row := s.db.QueryRowContext(ctx, query, provider, subject)
+	err := row.Scan(&identity.Provider, &identity.Subject, &identity.UserID, &identity.Email, &identity.CreatedAt)
+	recordQueryError(span, err)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.Identity{}, fmt.Errorf("%w: %s identity %s", ErrNotFound, provider, subject)
+		}
+		return model.Identity{}, fmt.Errorf("failed to get identity: %w", err)
+	}
+	return identity, nil
+}
+
+func (s *PostgresStore) GetIdentityByEmail(ctx context.Context, email string) (model.Identity, error) {
+	query := `
+		SELECT provider, subject, user_id, COALESCE(email, ''), created_at
+		FROM identities WHERE email = $1 ORDER BY created_at LIMIT 1;
+	`
+	ctx, span := s.startSpan(ctx, "GetIdentityByEmail", query)
+	defer span.End()
+
+	var identity model.Identity
+	row := s.db.QueryRowContext(ctx, query, email)
+	err := row.Scan(&identity.Provider, &identity.Subject, &identity.UserID, &identity.Email, &identity.CreatedAt)
+	recordQueryError(span, err)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.Identity{}, fmt.Errorf("%w: identity with email %s", ErrNotFound, email)
+		}
+		return model.Identity{}, fmt.Errorf("failed to get identity by email: %w", err)
+	}
+	return identity, nil
+}
+
+func (s *PostgresStore) ListIdentities(ctx context.Context, userID uuid.UUID) ([]model.Identity, error) {
+	query := `
+		SELECT provider, subject, user_id, COALESCE(email, ''), created_at
+		FROM identities WHERE user_id = $1 ORDER BY created_at;
+	`
+	ctx, span := s.startSpan(ctx, "ListIdentities", query)
+	defer span.End()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+	defer rows.Close()
+
+	identities := []model.Identity{}
+	for rows.Next() {
+		var identity model.Identity
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.UserID, &identity.Email, &identity.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan identity row: %w", err)
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+func (s *PostgresStore) DeleteIdentity(ctx context.Context, provider, subject string) error {
+	query := `DELETE FROM identities WHERE provider = $1 AND subject = $2;`
+	ctx, span := s.startSpan(ctx, "DeleteIdentity", query)
+	defer span.End()
+
+	if _, err := s.db.ExecContext(ctx, query, provider, subject); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to delete identity: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) MoveIdentities(ctx context.Context, fromUserID, toUserID uuid.UUID) error {
+	query := `UPDATE identities SET user_id = $2 WHERE user_id = $1;`
+	ctx, span := s.startSpan(ctx, "MoveIdentities", query)
+	defer span.End()
+
+	if _, err := s.db.ExecContext(ctx, query, fromUserID, toUserID); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to move identities: %w", err)
+	}
+	return nil
+}
+
+// --- ExportStore Implementation ---
+
+// CreateExport also deletes the expired exports, which hold personal data no one can fetch anymore.
+func (s *PostgresStore) CreateExport(ctx context.Context, export model.Export) (model.Export, error) {
+	query := `
+		WITH expired AS (DELETE FROM exports WHERE expires_at <= NOW())
+		INSERT INTO exports (id, user_id, status, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5);
+	`
+	ctx, span := s.startSpan(ctx, "CreateExport", query)
+	defer span.End()
+
+	if _, err := s.db.ExecContext(ctx, query, export.ID, export.UserID, export.Status, export.CreatedAt, export.ExpiresAt); err != nil {
+		tracing.RecordError(span, err)
+		return model.Export{}, fmt.Errorf("failed to create export: %w", err)
+	}
+	return export, nil
+}
+
+func (s *PostgresStore) GetExport(ctx context.Context, id uuid.UUID) (model.Export, error) {
+	query := `
+		SELECT id, user_id, status, data, created_at, completed_at, expires_at
+		FROM exports WHERE id = $1 AND expires_at > NOW();
+	`
+	ctx, span := s.startSpan(ctx, "GetExport", query)
+	defer span.End()
+
+	var export model.Export
+	row := s.db.QueryRowContext(ctx, query, id)
+	err := row.Scan(&export.ID, &export.UserID, &export.Status, &export.Data, &export.CreatedAt, &export.CompletedAt, &export.ExpiresAt)
+	recordQueryError(span, err)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.Export{}, fmt.Errorf("%w: export with ID %s", ErrNotFound, id)
+		}
+		return model.Export{}, fmt.Errorf("failed to get export: %w", err)
+	}
+	return export, nil
+}
+
+func (s *PostgresStore) DeleteExports(ctx context.Context, userID uuid.UUID) error {
+	query := `DELETE FROM exports WHERE user_id = $1;`
+	ctx, span := s.startSpan(ctx, "DeleteExports", query)
+	defer span.End()
+
+	if _, err := s.db.ExecContext(ctx, query, userID); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to delete exports: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) UpdateExport(ctx context.Context, export model.Export) error {
+	query := `UPDATE exports SET status = $2, completed_at = $3, data = $4 WHERE id = $1;`
+	ctx, span := s.startSpan(ctx, "UpdateExport", query)
+	defer span.End()
+
+	result, err := s.db.ExecContext(ctx, query, export.ID, export.Status, export.CompletedAt, export.Data)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to update export: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("%w: export with ID %s", ErrNotFound, export.ID)
+	}
+	return nil
+}
+
+// --- Outbox Implementation ---
+
+// addOutboxEvent writes the event to the outbox within q's transaction. Events carry phone
+// numbers, so they are encrypted like the phone_number column.
+func (s *PostgresStore) addOutboxEvent(ctx context.Context, q queryer, event model.Event) error {
+	query := `INSERT INTO outbox (payload) VALUES ($1);`
+	ctx, span := s.startSpan(ctx, "AddOutboxEvent", query)
+	defer span.End()
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	stored := string(payload)
+	if s.phones != nil {
+		if stored, err = s.phones.Encrypt(stored); err != nil {
+			return fmt.Errorf("failed to encrypt event: %w", err)
+		}
+	}
+	if _, err := q.ExecContext(ctx, query, stored); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to write event to outbox: %w", err)
+	}
+	return nil
+}
+
+// RelayEvents passes up to limit of the oldest outbox events to publish, in order, and deletes
+// them. Rows being relayed by another replica are skipped. If the deletion fails, the events
+// are published again later, so delivery is at least once.
+func (s *PostgresStore) RelayEvents(ctx context.Context, limit int, publish func(model.Event)) (int, error) {
+	query := `SELECT seq, payload FROM outbox ORDER BY seq LIMIT $1 FOR UPDATE SKIP LOCKED;`
+	ctx, span := s.startSpan(ctx, "RelayEvents", query)
+	defer span.End()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return 0, fmt.Errorf("failed to read outbox: %w", err)
+	}
+	var seqs []int64
+	var events []model.Event
+	for rows.Next() {
+		var seq int64
+		var stored string
+		if err := rows.Scan(&seq, &stored); err != nil {
+			rows.Close()
+			tracing.RecordError(span, err)
+			return 0, fmt.Errorf("failed to scan outbox event: %w", err)
+		}
+		seqs = append(seqs, seq)
+		event, err := s.decodeOutboxEvent(stored)
+		if err != nil {
+			// It would never decode; deleting it keeps it from holding up the events behind it.
+			slog.Error("Dropping undecodable outbox event", "seq", seq, "error", err)
+			continue
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		tracing.RecordError(span, err)
+		return 0, fmt.Errorf("failed to read outbox: %w", err)
+	}
+	if len(seqs) == 0 {
+		return 0, nil
+	}
+
+	for _, event := range events {
+		publish(event)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM outbox WHERE seq = ANY($1);`, pq.Array(seqs)); err != nil {
+		tracing.RecordError(span, err)
+		return 0, fmt.Errorf("failed to delete relayed events: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		tracing.RecordError(span, err)
+		return 0, fmt.Errorf("failed to delete relayed events: %w", err)
+	}
+	return len(seqs), nil
+}
+
+// decodeOutboxEvent reverses the encoding of addOutboxEvent.
+func (s *PostgresStore) decodeOutboxEvent(stored string) (model.Event, error) {
+	if s.phones != nil {
+		decrypted, err := s.phones.Decrypt(stored)
+		if err != nil {
+			return model.Event{}, fmt.Errorf("failed to decrypt event: %w", err)
+		}
+		stored = decrypted
+	}
+	var event model.Event
+	if err := json.Unmarshal([]byte(stored), &event); err != nil {
+		return model.Event{}, fmt.Errorf("failed to decode event: %w", err)
+	}
+	return event, nil
+}
+
+// --- StatsStore Implementation ---
+
+func (s *PostgresStore) IncrementCounter(ctx context.Context, name string, at time.Time) error {
+	query := `
+		INSERT INTO daily_counters (day, name, count) VALUES ($1, $2, 1)
+		ON CONFLICT (day, name) DO UPDATE SET count = daily_counters.count + 1;
+	`
+	ctx, span := s.startSpan(ctx, "IncrementCounter", query)
+	defer span.End()
+
+	if _, err := s.db.ExecContext(ctx, query, at.UTC().Format(time.DateOnly), name); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to increment counter: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) SumCounters(ctx context.Context, since time.Time) (map[string]int64, error) {
+	query := `SELECT name, SUM(count) FROM daily_counters WHERE day >= $1 GROUP BY name;`
+	ctx, span := s.startSpan(ctx, "SumCounters", query)
+	defer span.End()
+
+	rows, err := s.db.QueryContext(ctx, query, since.UTC().Format(time.DateOnly))
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to sum counters: %w", err)
+	}
+	defer rows.Close()
+
+	sums := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var sum int64
+		if err := rows.Scan(&name, &sum); err != nil {
+			tracing.RecordError(span, err)
+			return nil, fmt.Errorf("failed to scan counter: %w", err)
+		}
+		sums[name] = sum
+	}
+	if err := rows.Err(); err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to sum counters: %w", err)
+	}
+	return sums, nil
+}
+
+func (s *PostgresStore) CountUsers(ctx context.Context) (int64, error) {
+	query := `SELECT COUNT(*) FROM users WHERE deleted_at IS NULL;`
+	ctx, span := s.startSpan(ctx, "CountUsers", query)
+	defer span.End()
+
+	var count int64
+	if err := s.db.QueryRowContext(ctx, query).Scan(&count); err != nil {
+		tracing.RecordError(span, err)
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return count, nil
+}
+
+// CountNewUsersPerDay counts the users created since since per day (UTC), including the ones
+// deleted later. Days without new users are left out.
+func (s *PostgresStore) CountNewUsersPerDay(ctx context.Context, since time.Time) ([]model.DayCount, error) {
+	query := `
+		SELECT to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day, COUNT(*)
+		FROM users WHERE created_at >= $1
+		GROUP BY day ORDER BY day;
+	`
+	ctx, span := s.startSpan(ctx, "CountNewUsersPerDay", query)
+	defer span.End()
+
+	rows, err := s.db.QueryContext(ctx, query, since)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to count new users: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []model.DayCount
+	for rows.Next() {
+		var count model.DayCount
+		if err := rows.Scan(&count.Date, &count.Count); err != nil {
+			tracing.RecordError(span, err)
+			return nil, fmt.Errorf("failed to scan new users: %w", err)
+		}
+		counts = append(counts, count)
+	}
+	if err := rows.Err(); err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to count new users: %w", err)
+	}
+	return counts, nil
+}
+
+// CountUserGrowth groups the registrations and deletions by day in one query; the running sum
+// of their difference is the number of users.
+func (s *PostgresStore) CountUserGrowth(ctx context.Context, from, to time.Time) ([]model.UserGrowthDay, error) {
+	query := `
+		SELECT to_char(day, 'YYYY-MM-DD'), signups, SUM(signups - deletions) OVER (ORDER BY day)::bigint
+		FROM (
+			SELECT GREATEST(day, $1::date - 1) AS day, COUNT(*) FILTER (WHERE signup) AS signups, COUNT(*) FILTER (WHERE NOT signup) AS deletions
+			FROM (
+				SELECT (created_at AT TIME ZONE 'UTC')::date AS day, TRUE AS signup FROM users WHERE created_at < $2
+				UNION ALL
+				SELECT (deleted_at AT TIME ZONE 'UTC')::date, FALSE FROM users WHERE deleted_at < $2
+			) events
+			GROUP BY 1
+		) per_day
+		ORDER BY day;
+	`
+	ctx, span := s.startSpan(ctx, "CountUserGrowth", query)
+	defer span.End()
+
+	fromDay := from.UTC().Format(time.DateOnly)
+	end := to.UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	rows, err := s.db.QueryContext(ctx, query, fromDay, end)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to count user growth: %w", err)
+	}
+	defer rows.Close()
+
+	var days []model.UserGrowthDay
+	for rows.Next() {
+		var day model.UserGrowthDay
+		if err := rows.Scan(&day.Date, &day.Signups, &day.Total); err != nil {
+			tracing.RecordError(span, err)
+			return nil, fmt.Errorf("failed to scan user growth: %w", err)
+		}
+		days = append(days, day)
+	}
+	if err := rows.Err(); err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to count user growth: %w", err)
+	}
+	return days, nil
+}
+
+func (s *PostgresStore) CountActiveOTPs(ctx context.Context) (int64, error) {
+	query := `SELECT COUNT(*) FROM otps WHERE expires_at > NOW();`
+	ctx, span := s.startSpan(ctx, "CountActiveOTPs", query)
+	defer span.End()
+
+	var count int64
+	if err := s.db.QueryRowContext(ctx, query).Scan(&count); err != nil {
+		tracing.RecordError(span, err)
+		return 0, fmt.Errorf("failed to count active OTPs: %w", err)
+	}
+	return count, nil
+}
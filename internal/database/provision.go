@@ -0,0 +1,112 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// maintenanceDatabase is the database every PostgreSQL cluster has, used to create the
+// service's one.
+const maintenanceDatabase = "postgres"
+
+// ProvisionPostgres prepares a fresh cluster for NewPostgresStore: it creates the database
+// named in the DSN if it doesn't exist yet, connecting to the maintenance database of the
+// same server to do so, and enables the pgcrypto extension, which provides gen_random_uuid
+// before PostgreSQL 13. The DSN's user needs the CREATEDB privilege for the first part.
+func ProvisionPostgres(dataSourceName string) error {
+	dbName, maintenanceDSN, err := maintenanceDataSource(dataSourceName)
+	if err != nil {
+		return err
+	}
+
+	if dbName != "" && dbName != maintenanceDatabase {
+		if err := createDatabase(maintenanceDSN, dbName); err != nil {
+			return err
+		}
+	}
+
+	db, err := sql.Open("postgres", dataSourceName)
+	if err != nil {
+		return fmt.Errorf("failed to open database connection: %w", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE EXTENSION IF NOT EXISTS pgcrypto"); err != nil {
+		return fmt.Errorf("failed to enable the pgcrypto extension: %w", err)
+	}
+	return nil
+}
+
+// createDatabase creates the database unless it exists already.
+func createDatabase(maintenanceDSN, dbName string) error {
+	db, err := sql.Open("postgres", maintenanceDSN)
+	if err != nil {
+		return fmt.Errorf("failed to open maintenance database connection: %w", err)
+	}
+	defer db.Close()
+
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", dbName).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check whether database %q exists: %w", dbName, err)
+	}
+	if exists {
+		return nil
+	}
+
+	// Another replica may create it at the same time.
+	_, err = db.Exec("CREATE DATABASE " + pq.QuoteIdentifier(dbName))
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "42P04" { // duplicate_database
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create database %q: %w", dbName, err)
+	}
+	slog.Info("Created database", "name", dbName)
+	return nil
+}
+
+// maintenanceDataSource returns the database named in the DSN (empty when it names none)
+// and the same DSN pointing at the maintenance database instead.
+func maintenanceDataSource(dataSourceName string) (string, string, error) {
+	if strings.HasPrefix(dataSourceName, "postgres://") || strings.HasPrefix(dataSourceName, "postgresql://") {
+		u, err := url.Parse(dataSourceName)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid DATABASE_URL: %w", err)
+		}
+		dbName := strings.TrimPrefix(u.Path, "/")
+		u.Path = "/" + maintenanceDatabase
+		return dbName, u.String(), nil
+	}
+
+	// key=value pairs
+	var dbName string
+	fields := strings.Fields(dataSourceName)
+	for i, field := range fields {
+		if value, ok := strings.CutPrefix(field, "dbname="); ok {
+			dbName = value
+			fields[i] = "dbname=" + maintenanceDatabase
+		}
+	}
+	return dbName, strings.Join(fields, " "), nil
+}
+
+// explainConnectError adds what to do about the errors a fresh cluster typically causes.
+func explainConnectError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	switch pqErr.Code {
+	case "3D000": // invalid_catalog_name
+		return fmt.Errorf("%w (create the database or set DB_AUTO_PROVISION=true)", err)
+	case "42883": // undefined_function, i.e. gen_random_uuid before PostgreSQL 13
+		return fmt.Errorf("%w (enable the pgcrypto extension or set DB_AUTO_PROVISION=true)", err)
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// snapshot is the file format of Snapshotter.
+type snapshot struct {
+	SavedAt   time.Time            `json:"saved_at"`
+	Users     []model.User         `json:"users"`
+	Deleted   []model.User         `json:"deleted,omitempty"`
+	Phones    []model.UserPhone    `json:"phones,omitempty"`
+	Passwords map[uuid.UUID]string `json:"passwords,omitempty"`
+	Merges    []model.UserMerge    `json:"merges,omitempty"`
+	OTPs      []model.OTP          `json:"otps"`
+}
+
+// Snapshotter periodically saves the in-memory user and OTP stores to a JSON file, so small
+// deployments and demos keep their users across restarts. It is no substitute for a database:
+// whatever changed since the last snapshot is lost when the process is killed.
+type Snapshotter struct {
+	path  string
+	users *InMemoryUserStore
+	otps  *InMemoryOTPStore
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewSnapshotter restores the stores from the file at path, if it exists, and saves them
+// there every interval until Stop is called.
+func NewSnapshotter(path string, interval time.Duration, users *InMemoryUserStore, otps *InMemoryOTPStore) (*Snapshotter, error) {
+	s := &Snapshotter{
+		path:  path,
+		users: users,
+		otps:  otps,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if err := s.restore(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Save(); err != nil {
+					slog.Error("Failed to save in-memory snapshot", "path", s.path, "error", err)
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s, nil
+}
+
+// Stop ends the periodic snapshots and saves a final one.
+func (s *Snapshotter) Stop() error {
+	var err error
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+		err = s.Save()
+	})
+	return err
+}
+
+// Save writes the current state of the stores. The file is replaced atomically, so a crash
+// while saving leaves the previous snapshot intact.
+func (s *Snapshotter) Save() error {
+	snap := snapshot{SavedAt: time.Now()}
+
+	s.users.mu.RLock()
+	snap.Users = make([]model.User, 0, len(s.users.users))
+	for _, user := range s.users.users {
+		snap.Users = append(snap.Users, user)
+	}
+	for _, user := range s.users.deleted {
+		snap.Deleted = append(snap.Deleted, user)
+	}
+	snap.Phones = make([]model.UserPhone, 0, len(s.users.phones))
+	for _, phone := range s.users.phones {
+		snap.Phones = append(snap.Phones, phone)
+	}
+	snap.Passwords = maps.Clone(s.users.passwords)
+	snap.Merges = slices.Clone(s.users.merges)
+	s.users.mu.RUnlock()
+
+	s.otps.mu.Lock()
+	snap.OTPs = make([]model.OTP, 0, s.otps.lru.Len())
+	// Least recently used first, so restoring them in order rebuilds the LRU order.
+	for elem := s.otps.lru.Back(); elem != nil; elem = elem.Prev() {
+		snap.OTPs = append(snap.OTPs, elem.Value.(model.OTP))
+	}
+	s.otps.mu.Unlock()
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	// CreateTemp makes the file readable by the owner only, which suits phone numbers and OTPs.
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// restore loads the snapshot into the stores. A missing file is a first start, not an error.
+func (s *Snapshotter) restore() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("failed to decode snapshot %s: %w", s.path, err)
+	}
+
+	s.users.mu.Lock()
+	for _, user := range snap.Users {
+		s.users.users[user.ID] = user
+		s.users.phoneIndex[user.PhoneNumber] = user.ID
+	}
+	for _, user := range snap.Deleted {
+		s.users.deleted[user.ID] = user
+	}
+	for _, phone := range snap.Phones {
+		s.users.phones[phone.PhoneNumber] = phone
+	}
+	for id, hash := range snap.Passwords {
+		s.users.passwords[id] = hash
+	}
+	s.users.merges = append(s.users.merges, snap.Merges...)
+	s.users.mu.Unlock()
+
+	now := time.Now()
+	s.otps.mu.Lock()
+	for _, otp := range snap.OTPs {
+		if otp.ExpiresAt.After(now) {
+			s.otps.put(otp)
+		}
+	}
+	s.otps.mu.Unlock()
+
+	slog.Info("Restored in-memory snapshot", "path", s.path, "users", len(snap.Users), "saved_at", snap.SavedAt)
+	return nil
+}
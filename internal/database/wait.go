@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// connectAttemptTimeout bounds a single attempt to reach the server, e.g. while its address
+// doesn't answer at all.
+const connectAttemptTimeout = 5 * time.Second
+
+// ConnectRetry is how long and how often WaitForPostgres tries to reach the server. The delay
+// between attempts doubles from BaseDelay up to MaxDelay; a MaxWait of zero tries only once.
+type ConnectRetry struct {
+	MaxWait   time.Duration
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// WaitForPostgres waits until the server of the DSN accepts connections, e.g. while it's still
+// starting next to the service in docker compose or Kubernetes. A server answering with an error
+// of its own, like a missing database, counts as up: ProvisionPostgres and NewPostgresStore deal
+// with those.
+func WaitForPostgres(ctx context.Context, dataSourceName string, retry ConnectRetry) error {
+	db, err := sql.Open("postgres", dataSourceName)
+	if err != nil {
+		return fmt.Errorf("failed to open database connection: %w", err)
+	}
+	defer db.Close()
+
+	deadline := time.Now().Add(retry.MaxWait)
+	delay := retry.BaseDelay
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, connectAttemptTimeout)
+		err := db.PingContext(attemptCtx)
+		cancel()
+		if err == nil || !unreachable(err) {
+			return nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 && attempt == 1 {
+			return fmt.Errorf("database not reachable: %w", err)
+		}
+		if remaining <= 0 {
+			return fmt.Errorf("database not reachable after %d attempts: %w", attempt, err)
+		}
+		wait := min(delay, remaining)
+		slog.Warn("Database not reachable yet, retrying", "attempt", attempt, "delay", wait, "error", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("database not reachable: %w", err)
+		case <-time.After(wait):
+		}
+		delay = min(delay*2, retry.MaxDelay)
+	}
+}
+
+// unreachable reports whether err means the server can't be reached or doesn't accept
+// connections yet, as opposed to rejecting them, e.g. for a wrong password.
+func unreachable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// cannot_connect_now (starting up or shutting down) and too_many_connections.
+		return pqErr.Code == "57P03" || pqErr.Code == "53300"
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, driver.ErrBadConn)
+}
@@ -0,0 +1,88 @@
+// Package fieldcrypt encrypts individual database fields, such as phone numbers, so a leaked
+// database doesn't reveal them. Encrypted values can't be searched, so each value also gets a
+// deterministic keyed hash to look it up by.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Cipher encrypts values with AES-256-GCM and computes their HMAC-SHA256 lookup index.
+// The encryption and index keys are both derived from the configured key.
+type Cipher struct {
+	aead     cipher.AEAD
+	indexKey []byte
+}
+
+// New creates a Cipher from a base64 encoded 32-byte key.
+func New(encodedKey string) (*Cipher, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("key is not valid base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key must be 32 bytes, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(deriveKey(key, "encryption"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cipher{aead: aead, indexKey: deriveKey(key, "index")}, nil
+}
+
+// Encrypt returns base64(nonce || ciphertext). Every call uses a fresh random nonce, so equal
+// values encrypt differently; use Index to find them.
+func (c *Cipher) Encrypt(plaintext string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt reverses Encrypt. It fails if the value was encrypted with another key or tampered with.
+func (c *Cipher) Decrypt(encrypted string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", fmt.Errorf("encrypted value is not valid base64: %w", err)
+	}
+	if len(sealed) < c.aead.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// Index returns the hex encoded HMAC-SHA256 of the value. It is deterministic, so it can be
+// stored in a unique column and used for exact-match lookups.
+func (c *Cipher) Index(plaintext string) string {
+	mac := hmac.New(sha256.New, c.indexKey)
+	mac.Write([]byte(plaintext))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deriveKey derives a purpose-specific subkey, so the encryption and index keys differ.
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("fieldcrypt:" + purpose))
+	return mac.Sum(nil)
+}
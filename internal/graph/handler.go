@@ -0,0 +1,80 @@
+package graph
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graph-gophers/graphql-go"
+)
+
+// Context keys used to hand request data from Gin to the resolvers.
+type (
+	userKey          struct{}
+	clientKey        struct{}
+	serviceClientKey struct{}
+)
+
+type Handler struct {
+	schema *graphql.Schema
+}
+
+func NewHandler(resolver *Resolver) *Handler {
+	return &Handler{
+		schema: graphql.MustParseSchema(schema, resolver, graphql.UseFieldResolvers()),
+	}
+}
+
+type graphqlRequest struct {
+	Query         string                 `json:"query" binding:"required"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+var _ = openapi.Describe((*Handler).Serve, openapi.Operation{
+	Summary: "GraphQL endpoint",
+	Description: "Executes a GraphQL query or mutation. User queries require a Bearer token, " +
+		"the sendOTP and verifyOTP mutations are public.",
+	Tags: []string{"GraphQL"},
+	Body: &openapi.Body{Description: "GraphQL request", Required: true, Type: graphqlRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:           {Description: "data and/or errors", Type: map[string]any{}},
+		http.StatusBadRequest:   {Description: "Invalid request format"},
+		http.StatusUnauthorized: {Description: "Invalid token"},
+	},
+})
+
+func (h *Handler) Serve(c *gin.Context) {
+	var req graphqlRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithDetails(c, apperr.ErrInvalidRequest.Wrap(err), err.Error())
+		return
+	}
+
+	// Hand the authenticated user (if any), the client and whether a trusted backend
+	// signed the request over to the resolvers.
+	ctx := context.WithValue(c.Request.Context(), clientKey{}, model.ClientInfo{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		DeviceID:  c.GetHeader("X-Device-ID"),
+		Tenant:    middleware.Tenant(c),
+	})
+	ctx = context.WithValue(ctx, serviceClientKey{}, middleware.IsServiceClient(c))
+	if val, exists := c.Get(middleware.ContextKeyUser); exists {
+		if user, ok := val.(model.User); ok {
+			ctx = context.WithValue(ctx, userKey{}, user)
+		}
+	}
+
+	resp := h.schema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+	if len(resp.Errors) > 0 {
+		// Let clients quote the request ID when reporting a failed operation.
+		resp.Extensions = map[string]interface{}{"request_id": middleware.GetRequestID(c)}
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,285 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/pkg/auth"
+	"github.com/ebipenman/go-otp-auth-service/pkg/user"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"github.com/graph-gophers/graphql-go"
+)
+
+// resolverError carries a machine-readable code (and optional details) in the GraphQL error extensions.
+type resolverError struct {
+	message    string
+	code       string
+	retryAfter int
+}
+
+func (e *resolverError) Error() string {
+	return e.message
+}
+
+// newResolverError builds an error whose message is in the client's language.
+func newResolverError(ctx context.Context, code, messageCode string, params i18n.Params) *resolverError {
+	return &resolverError{message: i18n.FromContext(ctx).Message(messageCode, params), code: code}
+}
+
+func unauthenticated(ctx context.Context) error {
+	return newResolverError(ctx, "UNAUTHENTICATED", i18n.CodeAuthRequired, nil)
+}
+
+func (e *resolverError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.code}
+	if e.retryAfter > 0 {
+		ext["retry_after"] = e.retryAfter
+	}
+	return ext
+}
+
+// Resolver is the root resolver for both queries and mutations.
+type Resolver struct {
+	authService   auth.Service
+	userService   user.Service
+	ipRateLimiter middleware.RateLimiterStore
+}
+
+func NewResolver(authService auth.Service, userService user.Service, ipRateLimiter middleware.RateLimiterStore) *Resolver {
+	return &Resolver{
+		authService:   authService,
+		userService:   userService,
+		ipRateLimiter: ipRateLimiter,
+	}
+}
+
+// --- Queries ---
+
+func (r *Resolver) Me(ctx context.Context) (*userResolver, error) {
+	current, ok := ctx.Value(userKey{}).(model.User)
+	if !ok {
+		return nil, unauthenticated(ctx)
+	}
+	u, err := r.userService.GetUserByID(ctx, current.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &userResolver{u: u}, nil
+}
+
+func (r *Resolver) User(ctx context.Context, args struct{ ID graphql.ID }) (*userResolver, error) {
+	if _, ok := ctx.Value(userKey{}).(model.User); !ok {
+		return nil, unauthenticated(ctx)
+	}
+	id, err := uuid.Parse(string(args.ID))
+	if err != nil {
+		return nil, newResolverError(ctx, "BAD_USER_INPUT", i18n.CodeInvalidUserID, nil)
+	}
+	u, err := r.userService.GetUserByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, apperr.ErrUserNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &userResolver{u: u}, nil
+}
+
+// maxUsersLimit caps the page size of the users query.
+const maxUsersLimit = 100
+
+func (r *Resolver) Users(ctx context.Context, args struct {
+	Page   int32
+	Limit  int32
+	Search *string
+}) (*userListResolver, error) {
+	if _, ok := ctx.Value(userKey{}).(model.User); !ok {
+		return nil, unauthenticated(ctx)
+	}
+	if args.Page <= 0 || args.Limit <= 0 {
+		return nil, newResolverError(ctx, "BAD_USER_INPUT", i18n.CodeInvalidPagination, nil)
+	}
+
+	search := ""
+	if args.Search != nil {
+		search = *args.Search
+	}
+	args.Limit = min(args.Limit, maxUsersLimit)
+	offset := int(args.Page-1) * int(args.Limit)
+	users, total, err := r.userService.ListUsers(ctx, int(args.Limit), offset, search)
+	if err != nil {
+		return nil, err
+	}
+	return &userListResolver{users: users, total: total, page: args.Page, limit: args.Limit}, nil
+}
+
+// --- Mutations ---
+
+func (r *Resolver) SendOTP(ctx context.Context, args struct{ PhoneNumber string }) (*sendOTPPayload, error) {
+	if err := r.checkIPRate(ctx); err != nil {
+		return nil, err
+	}
+	if err := binding.Validator.ValidateStruct(model.SendOTPRequest{PhoneNumber: args.PhoneNumber}); err != nil {
+		return nil, newResolverError(ctx, "BAD_USER_INPUT", i18n.CodeInvalidRequest, nil)
+	}
+	client, _ := ctx.Value(clientKey{}).(model.ClientInfo)
+
+	_, rateLimit, err := r.authService.SendOTP(ctx, args.PhoneNumber, client)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidPhoneNumber) {
+			return nil, newResolverError(ctx, "BAD_USER_INPUT", i18n.CodeInvalidPhoneNumber, nil)
+		}
+		if errors.Is(err, auth.ErrCountryNotAllowed) {
+			return nil, newResolverError(ctx, "FORBIDDEN", i18n.CodeCountryNotAllowed, nil)
+		}
+		if errors.Is(err, auth.ErrQuotaExceeded) {
+			return nil, newResolverError(ctx, "QUOTA_EXCEEDED", i18n.CodeOTPQuotaExceeded, nil)
+		}
+		if errors.Is(err, auth.ErrRateLimitExceeded) {
+			return nil, rateLimitedError(ctx, i18n.CodeOTPRateLimited, rateLimit)
+		}
+		if riskErr := riskRefusalError(ctx, err); riskErr != nil {
+			return nil, riskErr
+		}
+		return nil, err
+	}
+	return &sendOTPPayload{Message: "OTP sent successfully (check console)"}, nil
+}
+
+// verifyOTPInput holds the arguments of verifyOTP with the rules of the body of /otp/verify.
+type verifyOTPInput struct {
+	PhoneNumber string `binding:"required,max=32"`
+	OTP         string `binding:"required,len=6,numeric"`
+}
+
+func (r *Resolver) VerifyOTP(ctx context.Context, args struct {
+	PhoneNumber string
+	OTP         string
+}) (*authPayload, error) {
+	if err := r.checkIPRate(ctx); err != nil {
+		return nil, err
+	}
+	if err := binding.Validator.ValidateStruct(verifyOTPInput{PhoneNumber: args.PhoneNumber, OTP: args.OTP}); err != nil {
+		return nil, newResolverError(ctx, "BAD_USER_INPUT", i18n.CodeInvalidRequest, nil)
+	}
+	client, _ := ctx.Value(clientKey{}).(model.ClientInfo)
+
+	token, rateLimit, err := r.authService.VerifyOTPAndAuthenticate(ctx, args.PhoneNumber, args.OTP, client)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidPhoneNumber) {
+			return nil, newResolverError(ctx, "BAD_USER_INPUT", i18n.CodeInvalidPhoneNumber, nil)
+		}
+		if errors.Is(err, auth.ErrRateLimitExceeded) {
+			return nil, rateLimitedError(ctx, i18n.CodeVerifyRateLimited, rateLimit)
+		}
+		if errors.Is(err, auth.ErrInvalidOTP) {
+			return nil, newResolverError(ctx, "INVALID_OTP", i18n.CodeInvalidOTP, nil)
+		}
+		if errors.Is(err, auth.ErrPasswordRequired) {
+			return nil, newResolverError(ctx, "PASSWORD_REQUIRED", i18n.CodePasswordRequired, nil)
+		}
+		if errors.Is(err, auth.ErrUserBanned) {
+			return nil, newResolverError(ctx, "ACCOUNT_BANNED", i18n.CodeAccountBanned, nil)
+		}
+		var restorable *auth.RestorableError
+		if errors.As(err, &restorable) {
+			return nil, newResolverError(ctx, "ACCOUNT_RESTORABLE", i18n.CodeAccountRestorable, nil)
+		}
+		if riskErr := riskRefusalError(ctx, err); riskErr != nil {
+			return nil, riskErr
+		}
+		return nil, err
+	}
+	return &authPayload{Token: token}, nil
+}
+
+// riskRefusalError translates a refusal of the risk evaluator, and returns nil for other errors.
+func riskRefusalError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, auth.ErrRiskChallenge):
+		return newResolverError(ctx, "CHALLENGE_REQUIRED", i18n.CodeChallengeRequired, nil)
+	case errors.Is(err, auth.ErrRiskDenied):
+		return newResolverError(ctx, "FORBIDDEN", i18n.CodeRequestDenied, nil)
+	}
+	return nil
+}
+
+// checkIPRate applies the same per-IP limit as the REST OTP endpoints, including the
+// exemption of signed requests from trusted backends.
+func (r *Resolver) checkIPRate(ctx context.Context) error {
+	if trusted, _ := ctx.Value(serviceClientKey{}).(bool); trusted {
+		return nil
+	}
+	client, _ := ctx.Value(clientKey{}).(model.ClientInfo)
+	if result := r.ipRateLimiter.Allow(client.IP); !result.Allowed {
+		return rateLimitedError(ctx, i18n.CodeIPRateLimited, result)
+	}
+	return nil
+}
+
+func rateLimitedError(ctx context.Context, messageCode string, result model.RateLimitResult) error {
+	retryAfter := middleware.RetryAfterSeconds(result)
+	err := newResolverError(ctx, "RATE_LIMITED", messageCode, i18n.Params{"seconds": retryAfter})
+	err.retryAfter = retryAfter
+	return err
+}
+
+// --- Object resolvers ---
+
+type userResolver struct {
+	u model.UserResponse
+}
+
+func (r *userResolver) ID() graphql.ID {
+	return graphql.ID(r.u.ID.String())
+}
+
+func (r *userResolver) PhoneNumber() string {
+	return r.u.PhoneNumber
+}
+
+func (r *userResolver) CreatedAt() string {
+	return r.u.CreatedAt.Format(time.RFC3339)
+}
+
+type userListResolver struct {
+	users []model.UserResponse
+	total int
+	page  int32
+	limit int32
+}
+
+func (r *userListResolver) Data() []*userResolver {
+	resolvers := make([]*userResolver, 0, len(r.users))
+	for _, u := range r.users {
+		resolvers = append(resolvers, &userResolver{u: u})
+	}
+	return resolvers
+}
+
+func (r *userListResolver) Total() int32 {
+	return int32(r.total)
+}
+
+func (r *userListResolver) Page() int32 {
+	return r.page
+}
+
+func (r *userListResolver) Limit() int32 {
+	return r.limit
+}
+
+type sendOTPPayload struct {
+	Message string
+}
+
+type authPayload struct {
+	Token string
+}
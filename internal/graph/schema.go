@@ -0,0 +1,47 @@
+package graph
+
+// schema is the GraphQL schema served at /graphql. It mirrors the REST API:
+// user queries require a valid JWT, the OTP mutations are public.
+const schema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+type Query {
+	# The authenticated user.
+	me: User!
+	# A single user by ID.
+	user(id: ID!): User
+	# A paginated list of users, optionally filtered by phone number. At most 100 per page.
+	users(page: Int = 1, limit: Int = 10, search: String): UserList!
+}
+
+type Mutation {
+	# Sends an OTP to the phone number for login or registration.
+	sendOTP(phoneNumber: String!): SendOTPPayload!
+	# Verifies the OTP and returns a JWT, registering the user if needed.
+	verifyOTP(phoneNumber: String!, otp: String!): AuthPayload!
+}
+
+type User {
+	id: ID!
+	phoneNumber: String!
+	createdAt: String!
+}
+
+type UserList {
+	data: [User!]!
+	total: Int!
+	page: Int!
+	limit: Int!
+}
+
+type SendOTPPayload {
+	message: String!
+}
+
+type AuthPayload {
+	token: String!
+}
+`
@@ -0,0 +1,94 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkTimeout bounds each dependency check, so a hanging dependency can't hang the probe.
+const checkTimeout = 2 * time.Second
+
+// Check reports whether a single dependency is usable.
+type Check func(ctx context.Context) error
+
+// Handler serves the Kubernetes style liveness and readiness probes.
+type Handler struct {
+	names  []string
+	checks map[string]Check
+}
+
+func NewHandler() *Handler {
+	return &Handler{checks: make(map[string]Check)}
+}
+
+// Register adds a dependency that must be healthy for the instance to be ready.
+// Checks must be registered before the server starts.
+func (h *Handler) Register(name string, check Check) {
+	h.names = append(h.names, name)
+	h.checks[name] = check
+}
+
+var _ = openapi.Describe((*Handler).Livez, openapi.Operation{
+	Summary: "Liveness probe",
+	Description: "Reports that the process is up and serving HTTP. It does not check any dependency, " +
+		"so a failing database doesn't get every replica restarted.",
+	Tags: []string{"Health"},
+	Responses: map[int]openapi.Response{
+		http.StatusOK: {Description: "status: UP", Type: map[string]string{}},
+	},
+})
+
+func (h *Handler) Livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "UP"})
+}
+
+var _ = openapi.Describe((*Handler).Readyz, openapi.Operation{
+	Summary: "Readiness probe",
+	Description: "Reports whether the instance can serve traffic: the database is reachable, its migrations " +
+		"are applied and the SMS provider is configured. Returns 503 with the status of every " +
+		"dependency when any of them fails.",
+	Tags: []string{"Health"},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                 {Description: "status: UP, checks: per dependency status", Type: map[string]any{}},
+		http.StatusServiceUnavailable: {Description: "status: DOWN, checks: per dependency status and error"},
+	},
+})
+
+func (h *Handler) Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), checkTimeout)
+	defer cancel()
+
+	results := make(map[string]gin.H, len(h.names))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	ready := true
+	for _, name := range h.names {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			err := check(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				ready = false
+				results[name] = gin.H{"status": "DOWN", "error": err.Error()}
+				return
+			}
+			results[name] = gin.H{"status": "UP"}
+		}(name, h.checks[name])
+	}
+	wg.Wait()
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "DOWN", "checks": results})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "UP", "checks": results})
+}
@@ -0,0 +1,205 @@
+package i18n
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Message codes identify client-facing errors. They are returned next to the localized text
+// and never change between releases or languages, so clients should branch on them.
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeInvalidPhoneNumber = "invalid_phone_number"
+	CodeCountryNotAllowed  = "country_not_allowed"
+	CodeInvalidOTP         = "invalid_otp"
+	CodeOTPRateLimited     = "otp_rate_limited"
+	CodeVerifyRateLimited  = "verify_rate_limited"
+	CodeIPRateLimited      = "ip_rate_limited"
+	CodeChallengeRequired  = "challenge_required"
+	CodeRequestDenied      = "request_denied"
+	CodeServerOverloaded   = "server_overloaded"
+	CodeUnderMaintenance   = "under_maintenance"
+	CodeRequestTimeout     = "request_timeout"
+	CodeBodyTooLarge       = "request_body_too_large"
+	CodeAuthRequired       = "authorization_required"
+	CodeInvalidToken       = "invalid_token"
+	CodeInvalidSignature   = "invalid_signature"
+	CodeAPIKeyRequired     = "api_key_required"
+	CodeInvalidAPIKey      = "invalid_api_key"
+	CodeInsufficientScope  = "insufficient_scope"
+	CodeAPIKeyNotFound     = "api_key_not_found"
+	CodeInvalidUserID      = "invalid_user_id"
+	CodeUserNotFound       = "user_not_found"
+	CodeInvalidPagination  = "invalid_pagination"
+	CodeInvalidFields      = "invalid_fields"
+	CodeUnknownClient      = "unknown_client"
+	CodeUnknownProvider    = "unknown_provider"
+	CodeInvalidIDToken     = "invalid_id_token"
+	CodeAccountNotLinked   = "account_not_linked"
+	CodeSSOFailed          = "sso_failed"
+	CodeSSONoPhoneNumber   = "sso_no_phone_number"
+	CodeInvalidExportID    = "invalid_export_id"
+	CodeExportNotFound     = "export_not_found"
+	CodeExportInProgress   = "export_in_progress"
+	CodeExportNotReady     = "export_not_ready"
+	CodeInvalidDeliveryID  = "invalid_delivery_id"
+	CodeDeliveryNotFound   = "delivery_not_found"
+	CodeInvalidStatsWindow = "invalid_stats_window"
+	CodeInvalidDateRange   = "invalid_date_range"
+	CodeInvalidDeviceToken = "invalid_device_token"
+	CodeInvalidDeviceID    = "invalid_device_id"
+	CodeDeviceNotFound     = "device_not_found"
+	CodeReverifyPhone      = "phone_reverification_required"
+	CodeInvalidPhoneID     = "invalid_phone_id"
+	CodePhoneNotFound      = "phone_not_found"
+	CodePhoneNumberTaken   = "phone_number_taken"
+	CodeEmailMissing       = "email_missing"
+	CodeInvalidEmailCode   = "invalid_email_code"
+	CodeEmailCodeCooldown  = "email_code_cooldown"
+	CodeEmailTaken         = "email_taken"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodePasswordRequired   = "password_required"
+	CodeMergeSameUser      = "merge_same_user"
+	CodeAccountRestorable  = "account_restorable"
+	CodeAccountBanned      = "account_banned"
+	CodeChannelNotEnabled  = "channel_not_enabled"
+	CodeChannelUnavailable = "channel_unavailable"
+	CodeOTPQuotaExceeded   = "otp_quota_exceeded"
+	CodeDeadLetterNotFound = "dead_letter_not_found"
+	CodeOTPDeliveryFailed  = "otp_delivery_failed"
+	CodeOTPNotFound        = "otp_not_found"
+	CodeInternal           = "internal_error"
+)
+
+// Params fill the {name} placeholders of a message.
+type Params map[string]interface{}
+
+// Catalog holds the messages of every supported language. English is the fallback
+// for unsupported languages and for codes missing from a translation.
+// Messages must be added before the catalog is used to serve requests.
+type Catalog struct {
+	messages map[language.Tag]map[string]string
+	tags     []language.Tag // tags[0] is the fallback
+	matcher  language.Matcher
+}
+
+// NewCatalog creates a catalog with the built-in English and Persian messages.
+func NewCatalog() *Catalog {
+	c := &Catalog{messages: make(map[language.Tag]map[string]string)}
+	for _, lang := range []string{"en", "fa"} {
+		// The built-in tags are valid, so this can't fail.
+		_ = c.Add(lang, builtinMessages[lang])
+	}
+	return c
+}
+
+// Add registers messages for a language, overriding existing messages with the same code.
+// Integrators use it to add languages or to reword the built-in messages.
+func (c *Catalog) Add(lang string, messages map[string]string) error {
+	tag, err := language.Parse(lang)
+	if err != nil {
+		return fmt.Errorf("invalid language %q: %w", lang, err)
+	}
+
+	existing, ok := c.messages[tag]
+	if !ok {
+		existing = make(map[string]string, len(messages))
+		c.messages[tag] = existing
+		c.tags = append(c.tags, tag)
+		c.matcher = language.NewMatcher(c.tags)
+	}
+	for code, message := range messages {
+		existing[code] = message
+	}
+	return nil
+}
+
+// LoadDir adds every <language>.json file in dir (e.g. de.json, fa.json), each holding
+// a flat object of message code to message.
+func (c *Catalog) LoadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		if err := c.Add(strings.TrimSuffix(filepath.Base(file), ".json"), messages); err != nil {
+			return fmt.Errorf("failed to load %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// Localizer picks the best supported language for an Accept-Language header value.
+func (c *Catalog) Localizer(acceptLanguage string) *Localizer {
+	tag := c.tags[0]
+	if prefs, _, err := language.ParseAcceptLanguage(acceptLanguage); err == nil && len(prefs) > 0 {
+		if _, index, confidence := c.matcher.Match(prefs...); confidence != language.No {
+			tag = c.tags[index]
+		}
+	}
+	return &Localizer{catalog: c, tag: tag}
+}
+
+// Localizer renders messages in one language.
+type Localizer struct {
+	catalog *Catalog
+	tag     language.Tag
+}
+
+// Language returns the selected language, e.g. for the Content-Language header.
+func (l *Localizer) Language() string {
+	return l.tag.String()
+}
+
+// Message returns the message for code with its placeholders filled in, falling back
+// to English and finally to the code itself.
+func (l *Localizer) Message(code string, params Params) string {
+	message, ok := l.catalog.messages[l.tag][code]
+	if !ok {
+		message, ok = l.catalog.messages[l.catalog.tags[0]][code]
+	}
+	if !ok {
+		return code
+	}
+
+	if len(params) > 0 {
+		oldnew := make([]string, 0, 2*len(params))
+		for name, value := range params {
+			oldnew = append(oldnew, "{"+name+"}", fmt.Sprint(value))
+		}
+		message = strings.NewReplacer(oldnew...).Replace(message)
+	}
+	return message
+}
+
+type ctxKey struct{}
+
+// defaultLocalizer serves code running outside of a localized request.
+var defaultLocalizer = NewCatalog().Localizer("")
+
+// NewContext returns a copy of ctx carrying the localizer.
+func NewContext(ctx context.Context, localizer *Localizer) context.Context {
+	return context.WithValue(ctx, ctxKey{}, localizer)
+}
+
+// FromContext returns the localizer stored in ctx, falling back to English.
+func FromContext(ctx context.Context) *Localizer {
+	if localizer, ok := ctx.Value(ctxKey{}).(*Localizer); ok {
+		return localizer
+	}
+	return defaultLocalizer
+}
@@ -0,0 +1,132 @@
+package i18n
+
+// builtinMessages are the messages shipped with the service.
+// Placeholders in braces are replaced by the Params passed to Localizer.Message.
+var builtinMessages = map[string]map[string]string{
+	"en": {
+		CodeInvalidRequest:     "Invalid request.",
+		CodeInvalidPhoneNumber: "Invalid phone number. Use the international E.164 format, e.g. +14155550123.",
+		CodeCountryNotAllowed:  "Verification codes can't be sent to phone numbers in this country.",
+		CodeInvalidOTP:         "Invalid or expired OTP.",
+		CodeOTPRateLimited:     "Too many OTP requests for this phone number. Please try again in {seconds} seconds.",
+		CodeVerifyRateLimited:  "Too many verification attempts. Please try again in {seconds} seconds.",
+		CodeIPRateLimited:      "Too many requests from this IP address. Please try again in {seconds} seconds.",
+		CodeChallengeRequired:  "Additional verification is required for this request.",
+		CodeRequestDenied:      "This request was denied for security reasons.",
+		CodeServerOverloaded:   "The server is overloaded. Please try again shortly.",
+		CodeUnderMaintenance:   "Sign-in is paused for planned maintenance. Please try again later.",
+		CodeRequestTimeout:     "The request took too long. Please try again.",
+		CodeBodyTooLarge:       "The request body must not be larger than {bytes} bytes.",
+		CodeAuthRequired:       "Authorization header is required.",
+		CodeInvalidToken:       "Invalid or expired token.",
+		CodeInvalidSignature:   "Invalid request signature.",
+		CodeAPIKeyRequired:     "X-API-Key header is required.",
+		CodeInvalidAPIKey:      "Invalid or revoked API key.",
+		CodeInsufficientScope:  "The API key lacks the '{scope}' scope.",
+		CodeAPIKeyNotFound:     "API key not found.",
+		CodeInvalidUserID:      "Invalid user ID.",
+		CodeUserNotFound:       "User not found.",
+		CodeInvalidPagination:  "Page and per_page must be positive numbers, and the cursor one of a previous page.",
+		CodeInvalidFields:      "Unknown field {field}; fields lists the fields to return, separated by commas.",
+		CodeUnknownClient:      "Unknown application or redirect URI.",
+		CodeUnknownProvider:    "Sign-in with this provider is not available.",
+		CodeInvalidIDToken:     "The sign-in could not be verified. Please try again.",
+		CodeAccountNotLinked:   "This account is not linked yet. Sign in with your phone number once to link it.",
+		CodeSSOFailed:          "Single sign-on failed. Please try again.",
+		CodeSSONoPhoneNumber:   "Your organization's account has no verified phone number. Ask your administrator to add one.",
+		CodeInvalidExportID:    "Invalid export ID.",
+		CodeExportNotFound:     "Export not found or expired.",
+		CodeExportInProgress:   "An export of your data is already being prepared. Please wait until it is ready.",
+		CodeExportNotReady:     "The export is not ready for download.",
+		CodeInvalidDeliveryID:  "Invalid delivery ID.",
+		CodeDeliveryNotFound:   "Delivery not found or expired.",
+		CodeInvalidStatsWindow: "The window must be 1 to {max} days.",
+		CodeInvalidDateRange:   "Invalid date range. Dates are YYYY-MM-DD, from no later than to, to not in the future, at most {max} days apart.",
+		CodeInvalidDeviceToken: "This device is not trusted anymore. Please sign in with a code.",
+		CodeInvalidDeviceID:    "Invalid device ID.",
+		CodeDeviceNotFound:     "Device not found.",
+		CodeReverifyPhone:      "Please verify your phone number again.",
+		CodeInvalidPhoneID:     "Invalid phone number ID.",
+		CodePhoneNotFound:      "Phone number not found.",
+		CodePhoneNumberTaken:   "This phone number is already in use.",
+		CodeEmailMissing:       "Add an email address to your profile first.",
+		CodeInvalidEmailCode:   "Invalid or expired email code.",
+		CodeEmailCodeCooldown:  "A code was just sent. Please try again in {seconds} seconds.",
+		CodeEmailTaken:         "This email address is already verified by another account.",
+		CodeInvalidCredentials: "Invalid phone number or password.",
+		CodePasswordRequired:   "This account has a password. Sign in with your password first.",
+		CodeMergeSameUser:      "A user cannot be merged into themselves.",
+		CodeAccountRestorable:  "The account of this phone number was deleted and can still be restored. Choose whether to restore it or create a new account.",
+		CodeAccountBanned:      "This account has been banned. Please contact support.",
+		CodeChannelNotEnabled:  "OTPs can't be sent through this channel.",
+		CodeChannelUnavailable: "The OTP can't be sent to this phone number through this channel. Try another channel.",
+		CodeOTPQuotaExceeded:   "The verification code budget is used up for now. Try again later.",
+		CodeDeadLetterNotFound: "Failed delivery not found.",
+		CodeOTPDeliveryFailed:  "The verification code could not be delivered.",
+		CodeOTPNotFound:        "No verification code is pending for this phone number.",
+		CodeInternal:           "Internal server error.",
+	},
+	"fa": {
+		CodeInvalidRequest:     "درخواست نامعتبر است.",
+		CodeInvalidPhoneNumber: "شماره تلفن نامعتبر است. از قالب بین‌المللی E.164 استفاده کنید، مانند +989121234567.",
+		CodeCountryNotAllowed:  "ارسال کد تأیید به شماره‌های این کشور امکان‌پذیر نیست.",
+		CodeInvalidOTP:         "کد یکبار مصرف نامعتبر است یا منقضی شده است.",
+		CodeOTPRateLimited:     "تعداد درخواست‌های کد برای این شماره بیش از حد مجاز است. لطفاً {seconds} ثانیه دیگر دوباره تلاش کنید.",
+		CodeVerifyRateLimited:  "تعداد تلاش‌های تأیید بیش از حد مجاز است. لطفاً {seconds} ثانیه دیگر دوباره تلاش کنید.",
+		CodeIPRateLimited:      "تعداد درخواست‌های این آدرس IP بیش از حد مجاز است. لطفاً {seconds} ثانیه دیگر دوباره تلاش کنید.",
+		CodeChallengeRequired:  "این درخواست به تأیید هویت بیشتری نیاز دارد.",
+		CodeRequestDenied:      "این درخواست به دلایل امنیتی رد شد.",
+		CodeServerOverloaded:   "سرور در حال حاضر شلوغ است. لطفاً کمی بعد دوباره تلاش کنید.",
+		CodeUnderMaintenance:   "ورود به دلیل تعمیرات برنامه‌ریزی‌شده موقتاً متوقف است. لطفاً بعداً دوباره تلاش کنید.",
+		CodeRequestTimeout:     "پردازش درخواست بیش از حد طول کشید. لطفاً دوباره تلاش کنید.",
+		CodeBodyTooLarge:       "حجم بدنه درخواست نباید بیشتر از {bytes} بایت باشد.",
+		CodeAuthRequired:       "هدر Authorization الزامی است.",
+		CodeInvalidToken:       "توکن نامعتبر است یا منقضی شده است.",
+		CodeInvalidSignature:   "امضای درخواست نامعتبر است.",
+		CodeAPIKeyRequired:     "هدر X-API-Key الزامی است.",
+		CodeInvalidAPIKey:      "کلید API نامعتبر است یا باطل شده است.",
+		CodeInsufficientScope:  "کلید API دسترسی '{scope}' را ندارد.",
+		CodeAPIKeyNotFound:     "کلید API یافت نشد.",
+		CodeInvalidUserID:      "شناسه کاربر نامعتبر است.",
+		CodeUserNotFound:       "کاربر یافت نشد.",
+		CodeInvalidPagination:  "شماره صفحه و تعداد در هر صفحه باید اعداد مثبت باشند و cursor باید از صفحهٔ قبلی باشد.",
+		CodeInvalidFields:      "فیلد {field} وجود ندارد؛ fields فهرست فیلدهای موردنیاز است که با کاما جدا شده‌اند.",
+		CodeUnknownClient:      "برنامه یا آدرس بازگشت ناشناخته است.",
+		CodeUnknownProvider:    "ورود با این سرویس امکان‌پذیر نیست.",
+		CodeInvalidIDToken:     "ورود تأیید نشد. لطفاً دوباره تلاش کنید.",
+		CodeAccountNotLinked:   "این حساب هنوز متصل نشده است. یک بار با شماره تلفن خود وارد شوید تا متصل شود.",
+		CodeSSOFailed:          "ورود یکپارچه ناموفق بود. لطفاً دوباره تلاش کنید.",
+		CodeSSONoPhoneNumber:   "حساب سازمانی شما شماره تلفن تأییدشده ندارد. از مدیر سیستم بخواهید آن را اضافه کند.",
+		CodeInvalidExportID:    "شناسه خروجی نامعتبر است.",
+		CodeExportNotFound:     "خروجی یافت نشد یا منقضی شده است.",
+		CodeExportInProgress:   "خروجی اطلاعات شما در حال آماده‌سازی است. لطفاً تا آماده شدن آن صبر کنید.",
+		CodeExportNotReady:     "خروجی هنوز برای دانلود آماده نیست.",
+		CodeInvalidDeliveryID:  "شناسه ارسال نامعتبر است.",
+		CodeDeliveryNotFound:   "ارسال یافت نشد یا منقضی شده است.",
+		CodeInvalidStatsWindow: "بازه باید بین ۱ تا {max} روز باشد.",
+		CodeInvalidDateRange:   "بازه تاریخ نامعتبر است. تاریخ‌ها به شکل YYYY-MM-DD، تاریخ شروع پیش از پایان، پایان نه در آینده و حداکثر {max} روز باشند.",
+		CodeInvalidDeviceToken: "این دستگاه دیگر مورد اعتماد نیست. لطفاً با کد تأیید وارد شوید.",
+		CodeInvalidDeviceID:    "شناسه دستگاه نامعتبر است.",
+		CodeDeviceNotFound:     "دستگاه یافت نشد.",
+		CodeReverifyPhone:      "لطفاً شماره تلفن خود را دوباره تأیید کنید.",
+		CodeInvalidPhoneID:     "شناسه شماره تلفن نامعتبر است.",
+		CodePhoneNotFound:      "شماره تلفن یافت نشد.",
+		CodePhoneNumberTaken:   "این شماره تلفن قبلاً استفاده شده است.",
+		CodeEmailMissing:       "ابتدا یک آدرس ایمیل به نمایه خود اضافه کنید.",
+		CodeInvalidEmailCode:   "کد ایمیل نامعتبر است یا منقضی شده است.",
+		CodeEmailCodeCooldown:  "کدی همین الان ارسال شد. لطفاً {seconds} ثانیه دیگر دوباره تلاش کنید.",
+		CodeEmailTaken:         "این آدرس ایمیل قبلاً توسط حساب دیگری تأیید شده است.",
+		CodeInvalidCredentials: "شماره تلفن یا رمز عبور نامعتبر است.",
+		CodePasswordRequired:   "این حساب رمز عبور دارد. ابتدا با رمز عبور خود وارد شوید.",
+		CodeMergeSameUser:      "یک کاربر را نمی‌توان با خودش ادغام کرد.",
+		CodeAccountRestorable:  "حساب این شماره تلفن حذف شده است و هنوز قابل بازیابی است. انتخاب کنید که آن را بازیابی کنید یا حساب جدیدی بسازید.",
+		CodeAccountBanned:      "این حساب مسدود شده است. لطفاً با پشتیبانی تماس بگیرید.",
+		CodeChannelNotEnabled:  "ارسال کد یکبار مصرف از این روش امکان‌پذیر نیست.",
+		CodeChannelUnavailable: "کد یکبار مصرف را نمی‌توان از این روش برای این شماره تلفن فرستاد. روش دیگری را امتحان کنید.",
+		CodeOTPQuotaExceeded:   "سهمیه ارسال کد تأیید فعلاً تمام شده است. بعداً دوباره تلاش کنید.",
+		CodeDeadLetterNotFound: "ارسال ناموفق یافت نشد.",
+		CodeOTPDeliveryFailed:  "کد تأیید تحویل داده نشد.",
+		CodeOTPNotFound:        "کد تأیید در انتظاری برای این شماره تلفن وجود ندارد.",
+		CodeInternal:           "خطای داخلی سرور.",
+	},
+}
@@ -0,0 +1,163 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ctxKey struct{}
+
+// levelVar is the level of the loggers created by New, changed at runtime by SetLevel and
+// OverrideLevel.
+var levelVar = new(slog.LevelVar)
+
+// override is the level set by OverrideLevel instead of the configured one, if any.
+var override struct {
+	mu         sync.Mutex
+	configured slog.Level
+	active     bool
+	until      time.Time
+	timer      *time.Timer
+}
+
+// LevelStatus is the level of the loggers created by New: the configured one or, while it's
+// overridden, the level set instead until Until, if that lasts for a while only.
+type LevelStatus struct {
+	Level      string     `json:"level"`
+	Configured string     `json:"configured"`
+	Overridden bool       `json:"overridden"`
+	Until      *time.Time `json:"until,omitempty"`
+}
+
+// New creates a structured logger writing to w.
+// level: "debug", "info", "warn" or "error".
+// format: "json" for machine-readable output or "text" for local development.
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	if err := SetLevel(level); err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: levelVar}
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, must be 'json' or 'text'", format)
+	}
+}
+
+// SetLevel changes the configured level of the loggers created by New, e.g. when the
+// configuration is reloaded. It ends an override by OverrideLevel.
+func SetLevel(level string) error {
+	lvl, err := parseLevel(level)
+	if err != nil {
+		return err
+	}
+	override.mu.Lock()
+	defer override.mu.Unlock()
+	endOverride()
+	override.configured = lvl
+	levelVar.Set(lvl)
+	return nil
+}
+
+// OverrideLevel changes the level of the loggers created by New at runtime, e.g. to debug while
+// investigating an incident, until the configured level is set again. With a duration, the
+// configured level comes back by itself after it.
+func OverrideLevel(level string, d time.Duration) (LevelStatus, error) {
+	lvl, err := parseLevel(level)
+	if err != nil {
+		return LevelStatus{}, err
+	}
+	override.mu.Lock()
+	defer override.mu.Unlock()
+	endOverride()
+	override.active = true
+	levelVar.Set(lvl)
+	if d > 0 {
+		override.until = time.Now().Add(d)
+		var timer *time.Timer
+		timer = time.AfterFunc(d, func() {
+			override.mu.Lock()
+			defer override.mu.Unlock()
+			// A later override or SetLevel replaced this one.
+			if override.timer == timer {
+				endOverride()
+			}
+		})
+		override.timer = timer
+	}
+	return levelStatus(), nil
+}
+
+// ResetLevel ends an override by OverrideLevel, going back to the configured level.
+func ResetLevel() LevelStatus {
+	override.mu.Lock()
+	defer override.mu.Unlock()
+	endOverride()
+	return levelStatus()
+}
+
+// Level returns the level of the loggers created by New.
+func Level() LevelStatus {
+	override.mu.Lock()
+	defer override.mu.Unlock()
+	return levelStatus()
+}
+
+// endOverride goes back to the configured level. override.mu must be held.
+func endOverride() {
+	if override.timer != nil {
+		override.timer.Stop()
+	}
+	override.active, override.until, override.timer = false, time.Time{}, nil
+	levelVar.Set(override.configured)
+}
+
+// levelStatus describes the level. override.mu must be held.
+func levelStatus() LevelStatus {
+	status := LevelStatus{
+		Level:      levelName(levelVar.Level()),
+		Configured: levelName(override.configured),
+		Overridden: override.active,
+	}
+	if !override.until.IsZero() {
+		until := override.until
+		status.Until = &until
+	}
+	return status
+}
+
+func parseLevel(level string) (slog.Level, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+	return lvl, nil
+}
+
+func levelName(lvl slog.Level) string {
+	return strings.ToLower(lvl.String())
+}
+
+// NewContext returns a copy of ctx carrying the logger, typically one already
+// enriched with request-scoped attributes such as the request ID.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx, falling back to the default logger
+// for code running outside of a request (startup, background jobs).
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
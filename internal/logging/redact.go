@@ -0,0 +1,154 @@
+package logging
+
+import (
+	"encoding/json"
+	"maps"
+	"mime"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// sensitiveKeys are JSON fields whose values are masked no matter what they contain.
+var sensitiveKeys = map[string]bool{
+	"otp":           true,
+	"otp_code":      true,
+	"otpcode":       true,
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"password":      true,
+	"secret":        true,
+
+	// OAuth2 client credentials, sent form-encoded to the token endpoints.
+	"client_secret": true,
+
+	// API keys, returned in plaintext once when they are created.
+	"key":     true,
+	"api_key": true,
+
+	// Trusted device tokens sign in without an OTP.
+	"device_token": true,
+
+	// The passwords of the password login.
+	"current_password": true,
+	"new_password":     true,
+}
+
+// phoneKeys are JSON fields holding phone numbers, which keep their last two digits so
+// log lines about the same user can still be told apart.
+var phoneKeys = map[string]bool{
+	"phone_number": true,
+	"phonenumber":  true,
+	"phone":        true,
+}
+
+var (
+	// phonePattern finds E.164 phone numbers in free text, e.g. inside a GraphQL query.
+	phonePattern = regexp.MustCompile(`\+\d{7,15}`)
+	// codePattern finds standalone 6 digit numbers in free text, which may be OTPs.
+	codePattern = regexp.MustCompile(`\b\d{6}\b`)
+)
+
+const redacted = "[REDACTED]"
+
+// RedactBody returns a copy of a request or response body of the content type that is safe to
+// log: phone numbers are masked, OTPs and tokens are replaced. JSON and form-encoded bodies are
+// masked field by field, anything else as free text.
+func RedactBody(body []byte, contentType string) string {
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/x-www-form-urlencoded" {
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			return redactForm(values)
+		}
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return redactText(string(body))
+	}
+	redacted, err := json.Marshal(redactValue("", value))
+	if err != nil {
+		return redactText(string(body))
+	}
+	return string(redacted)
+}
+
+// MaskPhoneNumber keeps the leading + and the last two digits, e.g. +98*********67.
+func MaskPhoneNumber(phone string) string {
+	if len(phone) <= 4 {
+		return strings.Repeat("*", len(phone))
+	}
+	prefix := ""
+	if strings.HasPrefix(phone, "+") {
+		prefix, phone = "+", phone[1:]
+	}
+	return prefix + strings.Repeat("*", len(phone)-2) + phone[len(phone)-2:]
+}
+
+func redactValue(key string, value interface{}) interface{} {
+	normalized := strings.ToLower(key)
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = redactValue(k, item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(key, item)
+		}
+		return v
+	case string:
+		switch {
+		case sensitiveKeys[normalized]:
+			return redacted
+		case normalized == "code" && codePattern.MatchString(v):
+			// "code" also names the error codes of responses, so only numeric codes are masked.
+			return redacted
+		case phoneKeys[normalized]:
+			return MaskPhoneNumber(v)
+		default:
+			// Timestamps would otherwise lose their fractional seconds to codePattern.
+			if _, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return v
+			}
+			return redactText(v)
+		}
+	case float64:
+		if sensitiveKeys[normalized] || normalized == "code" || phoneKeys[normalized] {
+			return redacted
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// redactForm masks the values of a form like the fields of a JSON object. The code of a form is
+// an OAuth2 authorization code, so unlike in JSON, where it also names error codes, it's always
+// replaced.
+func redactForm(values url.Values) string {
+	var b strings.Builder
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		for _, value := range values[key] {
+			if b.Len() > 0 {
+				b.WriteByte('&')
+			}
+			b.WriteString(url.QueryEscape(key) + "=")
+			if strings.EqualFold(key, "code") {
+				b.WriteString(redacted)
+			} else if masked := redactValue(key, value).(string); masked == redacted {
+				b.WriteString(masked)
+			} else {
+				b.WriteString(url.QueryEscape(masked))
+			}
+		}
+	}
+	return b.String()
+}
+
+func redactText(text string) string {
+	text = phonePattern.ReplaceAllStringFunc(text, MaskPhoneNumber)
+	return codePattern.ReplaceAllString(text, redacted)
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"sync"
+)
+
+// Stages of the OTP funnel, in order.
+const (
+	// StageSent counts the OTPs generated and handed to a sender.
+	StageSent = "sent"
+	// StageDelivered counts the OTPs the sender accepted. Until providers report delivery
+	// receipts, a carrier dropping the SMS after that only shows as a low verification rate.
+	StageDelivered = "delivered"
+	// StageVerified counts the OTPs users entered correctly.
+	StageVerified = "verified"
+)
+
+// OTPFunnel counts the OTPs at every stage per delivery channel and country calling code, so
+// operators can spot a carrier silently dropping the SMS of a country.
+var OTPFunnel = newFunnel("otp_funnel")
+
+// Funnel is an expvar.Var counting events per channel, country and stage. It is rendered as
+// {"<channel>": {"<country>": {"sent": n, "delivered": n, "verified": n,
+// "delivery_rate": r, "verification_rate": r}}}, the rates relative to the sent count.
+type Funnel struct {
+	mu     sync.Mutex
+	counts map[string]map[string]*funnelCounts
+}
+
+type funnelCounts struct {
+	Sent             int64   `json:"sent"`
+	Delivered        int64   `json:"delivered"`
+	Verified         int64   `json:"verified"`
+	DeliveryRate     float64 `json:"delivery_rate"`
+	VerificationRate float64 `json:"verification_rate"`
+}
+
+func newFunnel(name string) *Funnel {
+	f := &Funnel{counts: make(map[string]map[string]*funnelCounts)}
+	expvar.Publish(name, f)
+	return f
+}
+
+// Add counts one OTP of the channel and country reaching stage.
+func (f *Funnel) Add(channel, country, stage string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	countries, ok := f.counts[channel]
+	if !ok {
+		countries = make(map[string]*funnelCounts)
+		f.counts[channel] = countries
+	}
+	c, ok := countries[country]
+	if !ok {
+		c = &funnelCounts{}
+		countries[country] = c
+	}
+	switch stage {
+	case StageSent:
+		c.Sent++
+	case StageDelivered:
+		c.Delivered++
+	case StageVerified:
+		c.Verified++
+	}
+	if c.Sent > 0 {
+		c.DeliveryRate = float64(c.Delivered) / float64(c.Sent)
+		c.VerificationRate = float64(c.Verified) / float64(c.Sent)
+	}
+}
+
+// String renders the funnel as JSON, as expvar.Var requires.
+func (f *Funnel) String() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, err := json.Marshal(f.counts)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
@@ -0,0 +1,20 @@
+// Package metrics keeps the counters of the service. They are published with the standard
+// expvar package, so Handler serves them as JSON next to the Go runtime's memstats, ready for
+// scrapers such as the Prometheus json_exporter or Telegraf.
+package metrics
+
+import (
+	"expvar"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OTPStoreEvictions counts the OTPs the bounded in-memory store dropped to make room, keyed by
+// reason: "expired" for OTPs nobody could have verified anymore, "capacity" for live ones. A
+// rising capacity count means the store is too small or under a spray attack.
+var OTPStoreEvictions = expvar.NewMap("otp_store_evictions")
+
+// Handler serves all published variables as one JSON object.
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(expvar.Handler())
+}
@@ -0,0 +1,224 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// FailureStore records failed OTP verifications by client IP and phone number.
+// This allows for easy swapping between in-memory, Redis, etc.
+type FailureStore interface {
+	// RecordFailure records a failed verification of phoneNumber from ip and returns how many
+	// distinct phone numbers failed from ip, and how many distinct IPs failed for phoneNumber,
+	// within the window.
+	RecordFailure(ip, phoneNumber string, window time.Duration) (phonesPerIP, ipsPerPhone int)
+	// Stop releases background resources (e.g. cleanup goroutines) on shutdown.
+	Stop()
+}
+
+// AnomalyDetector spots brute-force attacks spread over many phone numbers or many IPs,
+// which the per phone number and IP limits don't catch on their own. An IP failing for too
+// many numbers, or a number failed for from too many IPs, is blocked; repeated detections
+// double the block, up to maxBlock.
+//
+// Blocking a number locks its owner out too: whoever controls maxIPsPerPhone IPs can keep a
+// victim from signing in by failing verifications for their number.
+type AnomalyDetector struct {
+	failures       FailureStore
+	box            PenaltyBox
+	window         time.Duration
+	maxPhonesPerIP int
+	maxIPsPerPhone int
+	block          time.Duration
+	maxBlock       time.Duration
+}
+
+// NewAnomalyDetector creates and returns a new AnomalyDetector.
+// window: How long failed verifications are remembered.
+// maxPhonesPerIP, maxIPsPerPhone: The distinct counts within the window that trigger a block.
+// block, maxBlock: The first and the longest block imposed.
+func NewAnomalyDetector(failures FailureStore, box PenaltyBox, window time.Duration, maxPhonesPerIP, maxIPsPerPhone int, block, maxBlock time.Duration) *AnomalyDetector {
+	return &AnomalyDetector{
+		failures:       failures,
+		box:            box,
+		window:         window,
+		maxPhonesPerIP: maxPhonesPerIP,
+		maxIPsPerPhone: maxIPsPerPhone,
+		block:          block,
+		maxBlock:       maxBlock,
+	}
+}
+
+// Blocked returns until when verifications from ip or for phoneNumber are blocked.
+// A zero time means neither is blocked.
+func (d *AnomalyDetector) Blocked(ip, phoneNumber string) time.Time {
+	now := time.Now()
+	var until time.Time
+	for _, key := range []string{"ip:" + ip, "phone:" + phoneNumber} {
+		if blockedUntil, _ := d.box.Blocked(key); blockedUntil.After(now) && blockedUntil.After(until) {
+			until = blockedUntil
+		}
+	}
+	return until
+}
+
+// RecordFailure records a failed verification and returns the patterns it completed.
+func (d *AnomalyDetector) RecordFailure(ip, phoneNumber string) []model.Anomaly {
+	phonesPerIP, ipsPerPhone := d.failures.RecordFailure(ip, phoneNumber, d.window)
+
+	var anomalies []model.Anomaly
+	if phonesPerIP >= d.maxPhonesPerIP {
+		// The block starts at d.block, since the penalty box doubles its window once.
+		blockedUntil, _ := d.box.Penalize("ip:"+ip, d.block/2, d.maxBlock)
+		anomalies = append(anomalies, model.Anomaly{
+			Pattern: model.AnomalyPhonesPerIP, IP: ip, Count: phonesPerIP, BlockedUntil: blockedUntil,
+		})
+	}
+	if ipsPerPhone >= d.maxIPsPerPhone {
+		blockedUntil, _ := d.box.Penalize("phone:"+phoneNumber, d.block/2, d.maxBlock)
+		anomalies = append(anomalies, model.Anomaly{
+			Pattern: model.AnomalyIPsPerPhone, PhoneNumber: phoneNumber, Count: ipsPerPhone, BlockedUntil: blockedUntil,
+		})
+	}
+	return anomalies
+}
+
+// Stop releases the resources of the underlying stores.
+func (d *AnomalyDetector) Stop() {
+	d.failures.Stop()
+	d.box.Stop()
+}
+
+// InMemoryFailureStore implements FailureStore using in-memory maps of the last failure
+// per IP and phone number pair, indexed both ways.
+type InMemoryFailureStore struct {
+	byIP    map[string]map[string]time.Time // ip -> phone number -> last failure
+	byPhone map[string]map[string]time.Time // phone number -> ip -> last failure
+	window  time.Duration                   // longest window seen, for the cleanup
+	mu      sync.Mutex
+	janitor *janitor
+}
+
+// NewInMemoryFailureStore creates and returns a new InMemoryFailureStore, which forgets old
+// failures every cleanupInterval (see DefaultCleanupInterval).
+func NewInMemoryFailureStore(cleanupInterval time.Duration) *InMemoryFailureStore {
+	store := &InMemoryFailureStore{
+		byIP:    make(map[string]map[string]time.Time),
+		byPhone: make(map[string]map[string]time.Time),
+	}
+
+	// Start a background goroutine to periodically forget old failures
+	store.janitor = startJanitor(cleanupInterval, store.cleanup)
+
+	return store
+}
+
+// RecordFailure records the failure and counts the distinct counterparts within the window.
+func (s *InMemoryFailureStore) RecordFailure(ip, phoneNumber string, window time.Duration) (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.window = max(s.window, window)
+	return recordPair(s.byIP, ip, phoneNumber, now, window), recordPair(s.byPhone, phoneNumber, ip, now, window)
+}
+
+// recordPair stores the failure under key and returns the number of counterparts of key
+// that failed within the window, dropping older ones.
+func recordPair(index map[string]map[string]time.Time, key, counterpart string, now time.Time, window time.Duration) int {
+	seen, ok := index[key]
+	if !ok {
+		seen = make(map[string]time.Time)
+		index[key] = seen
+	}
+	seen[counterpart] = now
+	for c, last := range seen {
+		if now.Sub(last) > window {
+			delete(seen, c)
+		}
+	}
+	return len(seen)
+}
+
+// Stop ends the cleanup goroutine and waits for it to exit.
+func (s *InMemoryFailureStore) Stop() {
+	s.janitor.Stop()
+}
+
+// cleanup removes IPs and phone numbers without recent failures.
+func (s *InMemoryFailureStore) cleanup() {
+	s.mu.Lock()
+	now := time.Now()
+	for _, index := range []map[string]map[string]time.Time{s.byIP, s.byPhone} {
+		for key, seen := range index {
+			for c, last := range seen {
+				if now.Sub(last) > s.window {
+					delete(seen, c)
+				}
+			}
+			if len(seen) == 0 {
+				delete(index, key)
+			}
+		}
+	}
+	s.mu.Unlock()
+	slog.Debug("Failure store cleanup finished")
+}
+
+// recordFailureScript adds the counterpart to a sorted set scored by the failure time,
+// drops the entries that left the window and returns the size of the set.
+//
+// KEYS[1] - the sorted set of the IP or phone number
+// ARGV[1] - current time in milliseconds
+// ARGV[2] - window size in milliseconds
+// ARGV[3] - the counterpart (phone number or IP)
+var recordFailureScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+
+redis.call('ZADD', key, now, ARGV[3])
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+redis.call('PEXPIRE', key, window)
+return redis.call('ZCARD', key)
+`)
+
+// RedisFailureStore implements FailureStore on top of Redis, so attacks spread over
+// several replicas are still recognized.
+type RedisFailureStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisFailureStore creates and returns a new RedisFailureStore.
+func NewRedisFailureStore(client *redis.Client, prefix string) *RedisFailureStore {
+	return &RedisFailureStore{client: client, prefix: prefix}
+}
+
+// RecordFailure records the failure and counts the distinct counterparts within the window.
+// Redis errors are logged and count as no failures, like the Redis rate limiter does.
+func (s *RedisFailureStore) RecordFailure(ip, phoneNumber string, window time.Duration) (int, int) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	phonesPerIP, err := recordFailureScript.Run(ctx, s.client, []string{s.prefix + "ip:" + ip}, now, window.Milliseconds(), phoneNumber).Int()
+	if err != nil {
+		slog.Error("Redis failure store failed", "ip", ip, "error", err)
+	}
+	ipsPerPhone, err := recordFailureScript.Run(ctx, s.client, []string{s.prefix + "phone:" + phoneNumber}, now, window.Milliseconds(), ip).Int()
+	if err != nil {
+		slog.Error("Redis failure store failed", "phone_number", phoneNumber, "error", err)
+	}
+	return phonesPerIP, ipsPerPhone
+}
+
+// Stop is a no-op; the Redis client is closed by its owner.
+func (s *RedisFailureStore) Stop() {}
@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+)
+
+func newTestAnomalyDetector(t *testing.T, block, maxBlock time.Duration) *AnomalyDetector {
+	t.Helper()
+	detector := NewAnomalyDetector(NewInMemoryFailureStore(time.Hour), NewInMemoryPenaltyBox(time.Hour), 15*time.Minute, 3, 3, block, maxBlock)
+	t.Cleanup(detector.Stop)
+	return detector
+}
+
+func TestAnomalyDetectorPhonesPerIP(t *testing.T) {
+	const block = time.Hour
+	detector := newTestAnomalyDetector(t, block, 24*time.Hour)
+
+	for i := range 2 {
+		// Failing again for the same number doesn't count.
+		for range 2 {
+			if anomalies := detector.RecordFailure("203.0.113.7", "+1415555267"+strconv.Itoa(i)); len(anomalies) != 0 {
+				t.Fatalf("failure %d: anomalies = %+v, want none below the threshold", i+1, anomalies)
+			}
+		}
+	}
+	if until := detector.Blocked("203.0.113.7", "+14155552670"); !until.IsZero() {
+		t.Fatalf("Blocked = %v below the threshold, want zero", until)
+	}
+
+	before := time.Now()
+	anomalies := detector.RecordFailure("203.0.113.7", "+14155552672")
+	if len(anomalies) != 1 || anomalies[0].Pattern != model.AnomalyPhonesPerIP || anomalies[0].IP != "203.0.113.7" || anomalies[0].Count != 3 {
+		t.Fatalf("anomalies = %+v, want phones_per_ip of 203.0.113.7 with count 3", anomalies)
+	}
+	// The first block is block itself, not twice it.
+	if got := anomalies[0].BlockedUntil.Sub(before); got < block || got > block+time.Second {
+		t.Errorf("first block = %v, want %v", got, block)
+	}
+
+	// The IP is blocked whichever number it tries next; other IPs aren't.
+	if until := detector.Blocked("203.0.113.7", "+14155552679"); !until.Equal(anomalies[0].BlockedUntil) {
+		t.Errorf("Blocked = %v, want %v", until, anomalies[0].BlockedUntil)
+	}
+	if until := detector.Blocked("198.51.100.1", "+14155552670"); !until.IsZero() {
+		t.Errorf("Blocked from another IP = %v, want zero", until)
+	}
+
+	// Another detection doubles the block.
+	before = time.Now()
+	anomalies = detector.RecordFailure("203.0.113.7", "+14155552673")
+	if len(anomalies) != 1 || anomalies[0].Count != 4 {
+		t.Fatalf("anomalies = %+v, want phones_per_ip with count 4", anomalies)
+	}
+	if got := anomalies[0].BlockedUntil.Sub(before); got < 2*block || got > 2*block+time.Second {
+		t.Errorf("second block = %v, want %v", got, 2*block)
+	}
+}
+
+func TestAnomalyDetectorIPsPerPhone(t *testing.T) {
+	const block = time.Hour
+	detector := newTestAnomalyDetector(t, block, 24*time.Hour)
+
+	detector.RecordFailure("203.0.113.1", "+14155552671")
+	detector.RecordFailure("203.0.113.2", "+14155552671")
+	before := time.Now()
+	anomalies := detector.RecordFailure("203.0.113.3", "+14155552671")
+	if len(anomalies) != 1 || anomalies[0].Pattern != model.AnomalyIPsPerPhone || anomalies[0].PhoneNumber != "+14155552671" || anomalies[0].Count != 3 {
+		t.Fatalf("anomalies = %+v, want ips_per_phone of +14155552671 with count 3", anomalies)
+	}
+	if got := anomalies[0].BlockedUntil.Sub(before); got < block || got > block+time.Second {
+		t.Errorf("first block = %v, want %v", got, block)
+	}
+
+	// The number is blocked from any IP; other numbers aren't.
+	if until := detector.Blocked("198.51.100.1", "+14155552671"); !until.Equal(anomalies[0].BlockedUntil) {
+		t.Errorf("Blocked = %v, want %v", until, anomalies[0].BlockedUntil)
+	}
+	if until := detector.Blocked("203.0.113.1", "+14155552672"); !until.IsZero() {
+		t.Errorf("Blocked for another number = %v, want zero", until)
+	}
+}
+
+func TestAnomalyDetectorBlockedLongest(t *testing.T) {
+	detector := newTestAnomalyDetector(t, time.Hour, 24*time.Hour)
+
+	detector.box.Penalize("ip:203.0.113.7", time.Hour, 24*time.Hour)
+	phoneUntil, _ := detector.box.Penalize("phone:+14155552671", 2*time.Hour, 24*time.Hour)
+	if until := detector.Blocked("203.0.113.7", "+14155552671"); !until.Equal(phoneUntil) {
+		t.Errorf("Blocked = %v, want the longer block %v", until, phoneUntil)
+	}
+}
+
+func TestInMemoryFailureStoreWindow(t *testing.T) {
+	const window = time.Minute
+	store := NewInMemoryFailureStore(time.Hour)
+	defer store.Stop()
+
+	store.RecordFailure("203.0.113.7", "+14155552671", window)
+	store.RecordFailure("203.0.113.7", "+14155552672", window)
+	if phonesPerIP, ipsPerPhone := store.RecordFailure("203.0.113.8", "+14155552671", window); phonesPerIP != 1 || ipsPerPhone != 2 {
+		t.Fatalf("RecordFailure = %d, %d, want 1, 2", phonesPerIP, ipsPerPhone)
+	}
+
+	// Age the failures of 203.0.113.7 out of the window.
+	old := time.Now().Add(-window - time.Second)
+	for phoneNumber := range store.byIP["203.0.113.7"] {
+		store.byIP["203.0.113.7"][phoneNumber] = old
+		store.byPhone[phoneNumber]["203.0.113.7"] = old
+	}
+	if phonesPerIP, ipsPerPhone := store.RecordFailure("203.0.113.7", "+14155552673", window); phonesPerIP != 1 || ipsPerPhone != 1 {
+		t.Errorf("RecordFailure after the window = %d, %d, want 1, 1", phonesPerIP, ipsPerPhone)
+	}
+	if phonesPerIP, ipsPerPhone := store.RecordFailure("203.0.113.9", "+14155552672", window); phonesPerIP != 1 || ipsPerPhone != 1 {
+		t.Errorf("RecordFailure after the window = %d, %d, want 1, 1", phonesPerIP, ipsPerPhone)
+	}
+
+	// The cleanup forgets numbers without failures in the window.
+	store.cleanup()
+	if _, ok := store.byPhone["+14155552671"]["203.0.113.7"]; ok {
+		t.Error("cleanup kept a failure older than the window")
+	}
+	if _, ok := store.byPhone["+14155552671"]["203.0.113.8"]; !ok {
+		t.Error("cleanup dropped a failure within the window")
+	}
+}
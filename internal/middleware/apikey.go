@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// ContextKeyAPIKey is the key used to store the authenticated API key in the Gin context.
+	ContextKeyAPIKey = "api_key"
+
+	HeaderAPIKey = "X-API-Key"
+)
+
+// APIKeyAuthenticator resolves a plaintext API key, or an access token issued for one by the
+// client credentials grant, to the stored key.
+type APIKeyAuthenticator interface {
+	Authenticate(ctx context.Context, key string) (model.APIKey, error)
+	AuthenticateToken(ctx context.Context, token string) (model.APIKey, error)
+}
+
+// APIKeyAuth creates a Gin middleware authenticating machine clients by their X-API-Key header
+// or a client credentials access token in the Authorization header. The key must grant scope.
+// When required is false, requests without either pass through, so the route can fall back to
+// another authentication method; user tokens are left to it as well.
+func APIKeyAuth(authenticator APIKeyAuthenticator, scope string, required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var apiKey model.APIKey
+		var err error
+		if key := c.GetHeader(HeaderAPIKey); key != "" {
+			apiKey, err = authenticator.Authenticate(c.Request.Context(), key)
+		} else if token := clientToken(c.GetHeader("Authorization")); token != "" {
+			apiKey, err = authenticator.AuthenticateToken(c.Request.Context(), token)
+		} else {
+			if required {
+				abortWithProblem(c, http.StatusUnauthorized, i18n.CodeAPIKeyRequired, nil)
+				return
+			}
+			c.Next()
+			return
+		}
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Info("Rejected API key", "error", err)
+			abortWithProblem(c, http.StatusUnauthorized, i18n.CodeInvalidAPIKey, nil)
+			return
+		}
+		if !apiKey.HasScope(scope) {
+			abortWithProblem(c, http.StatusForbidden, i18n.CodeInsufficientScope, i18n.Params{"scope": scope})
+			return
+		}
+
+		c.Set(ContextKeyAPIKey, apiKey)
+		c.Next()
+	}
+}
+
+// HasAPIKey reports whether an API key authenticated the request.
+func HasAPIKey(c *gin.Context) bool {
+	_, exists := c.Get(ContextKeyAPIKey)
+	return exists
+}
+
+// clientToken returns the bearer token of the header if it was issued to a machine client,
+// which is told apart from user tokens by its client_id claim. The token isn't verified yet.
+func clientToken(authHeader string) string {
+	token, ok := strings.CutPrefix(authHeader, "Bearer ")
+	if !ok {
+		return ""
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return ""
+	}
+	if _, ok := claims["client_id"]; !ok {
+		return ""
+	}
+	return token
+}
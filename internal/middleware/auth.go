@@ -1,73 +1,170 @@
 package middleware
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
 	"github.com/ebipenman/go-otp-auth-service/internal/model"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
 const (
 	// ContextKeyUser is the key used to store the user object in the Gin context.
 	ContextKeyUser = "user"
+	// ContextKeyGuest is the key used to store the ID of an authenticated guest in the Gin context.
+	ContextKeyGuest = "guest"
 )
 
-// AuthMiddleware creates a Gin middleware for JWT authentication.
-func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
+// AuthMiddleware creates a Gin middleware for JWT authentication. Tokens the validator accepts
+// are let in, unless revocations (which may be nil) revoked them. Guest tokens are not.
+// Requests already authenticated by an API key (see APIKeyAuth) are let through without a token.
+func AuthMiddleware(tokens TokenValidator, revocations TokenRevocations) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if HasAPIKey(c) {
+			c.Next()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
+			abortWithProblem(c, http.StatusUnauthorized, i18n.CodeAuthRequired, nil)
 			return
 		}
 
-		// Check if the header is in the "Bearer <token>" format
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header format must be Bearer {token}"})
+		user, err := authenticate(c.Request.Context(), authHeader, tokens, revocations)
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Info("Rejected token", "error", err)
+			abortWithProblem(c, http.StatusUnauthorized, i18n.CodeInvalidToken, nil)
 			return
 		}
 
-		tokenString := parts[1]
+		// Store user details in the context for downstream handlers
+		c.Set(ContextKeyUser, user)
+		c.Next()
+	}
+}
 
-		// Parse and validate the token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Check the signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, errors.New("unexpected signing method")
-			}
-			return []byte(jwtSecret), nil
-		})
+// OptionalAuthMiddleware works like AuthMiddleware but lets anonymous requests through.
+// A token that is present must still be valid; handlers decide per operation whether
+// they need the user from the context.
+func OptionalAuthMiddleware(tokens TokenValidator, revocations TokenRevocations) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			c.Next()
+			return
+		}
 
+		user, err := authenticate(c.Request.Context(), authHeader, tokens, revocations)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token: " + err.Error()})
+			logging.FromContext(c.Request.Context()).Info("Rejected token", "error", err)
+			abortWithProblem(c, http.StatusUnauthorized, i18n.CodeInvalidToken, nil)
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			// Extract user information from claims
-			userID, err := uuid.Parse(claims["sub"].(string))
-			if err != nil {
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID in token"})
-				return
-			}
-			phoneNumber := claims["phone"].(string)
-
-			// Store user details in the context for downstream handlers
-			user := model.User{
-				ID:          userID,
-				PhoneNumber: phoneNumber,
-			}
-			c.Set(ContextKeyUser, user)
+		c.Set(ContextKeyUser, user)
+		c.Next()
+	}
+}
 
+// GuestAuthMiddleware works like OptionalAuthMiddleware but also accepts guest tokens. For
+// them, the guest's ID is stored in the context instead of the user, see GuestID.
+func GuestAuthMiddleware(tokens TokenValidator, revocations TokenRevocations) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
 			c.Next()
+			return
+		}
+
+		claims, err := validateBearer(c.Request.Context(), authHeader, tokens, revocations)
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Info("Rejected token", "error", err)
+			abortWithProblem(c, http.StatusUnauthorized, i18n.CodeInvalidToken, nil)
+			return
+		}
+
+		id, _ := claims.UserID()
+		if claims.Guest {
+			c.Set(ContextKeyGuest, id)
 		} else {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.Set(ContextKeyUser, model.User{ID: id, PhoneNumber: claims.Phone})
 		}
+		c.Next()
 	}
 }
+
+// GuestID returns the ID of the guest GuestAuthMiddleware authenticated, if any.
+func GuestID(c *gin.Context) (uuid.UUID, bool) {
+	val, _ := c.Get(ContextKeyGuest)
+	id, ok := val.(uuid.UUID)
+	return id, ok
+}
+
+// authenticate validates a "Bearer <token>" Authorization header and returns the user it
+// identifies. Guest tokens are rejected.
+func authenticate(ctx context.Context, authHeader string, tokens TokenValidator, revocations TokenRevocations) (model.User, error) {
+	claims, err := validateBearer(ctx, authHeader, tokens, revocations)
+	if err != nil {
+		return model.User{}, err
+	}
+	if claims.Guest {
+		return model.User{}, errors.New("Guest token")
+	}
+
+	// Validate already checked the subject.
+	userID, _ := claims.UserID()
+	user := model.User{
+		ID:          userID,
+		PhoneNumber: claims.Phone,
+	}
+	if claims.PhoneVerifiedAt != nil {
+		user.PhoneVerifiedAt = &claims.PhoneVerifiedAt.Time
+	}
+	return user, nil
+}
+
+// validateBearer validates a "Bearer <token>" Authorization header and returns the claims of
+// the token, unless it was revoked.
+func validateBearer(ctx context.Context, authHeader string, tokens TokenValidator, revocations TokenRevocations) (*Claims, error) {
+	// Check if the header is in the "Bearer <token>" format
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, errors.New("Authorization header format must be Bearer {token}")
+	}
+
+	tokenString := parts[1]
+
+	// Parse and validate the token
+	claims, err := tokens.ValidateToken(ctx, tokenString)
+	if err != nil {
+		return nil, errors.New("Invalid token: " + err.Error())
+	}
+
+	// Validate already checked the subject.
+	userID, _ := claims.UserID()
+
+	if revocations != nil {
+		revokedBefore, err := revocations.TokensRevokedBefore(ctx, userID)
+		if err != nil {
+			// Like the rate limiters, fail open: an outage of the store mustn't log everyone out.
+			logging.FromContext(ctx).Error("Failed to check token revocation", "user_id", userID, "error", err)
+		} else if !revokedBefore.IsZero() {
+			// Tokens without iat can't prove they were issued after the revocation. iat has whole
+			// seconds, so tokens of the second of the revocation, e.g. of a sign-in right after a
+			// forced logout, stay valid.
+			if claims.IssuedAt == nil || claims.IssuedAt.Before(revokedBefore.Truncate(time.Second)) {
+				return nil, errors.New("Token revoked")
+			}
+		}
+	}
+
+	return claims, nil
+}
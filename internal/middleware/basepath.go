@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// ContextKeyBasePath is the key used to store the base path of the routes in the Gin context.
+	ContextKeyBasePath = "base_path"
+)
+
+// BasePath records the path prefix the routes are mounted under, e.g. "/auth" behind a gateway
+// routing by path, so handlers can link to the other routes with Path.
+func BasePath(prefix string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(ContextKeyBasePath, prefix)
+		c.Next()
+	}
+}
+
+// Path returns the path of a route, e.g. "/otp/send", below the base path of the request.
+func Path(c *gin.Context, path string) string {
+	return c.GetString(ContextKeyBasePath) + path
+}
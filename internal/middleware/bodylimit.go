@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BodyLimit creates a Gin middleware that rejects request bodies larger than maxBytes with 413
+// before a handler binds them, e.g. to keep abusive payloads away from the JSON binder. Routes
+// below the prefixes of byPrefix, e.g. "/otp", get the limit of the longest one instead. Bodies
+// within the limit are read up front, so chunked ones are caught too. A limit of zero disables it.
+func BodyLimit(maxBytes int64, byPrefix map[string]int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, longest := maxBytes, -1
+		path := c.FullPath()
+		for prefix, n := range byPrefix {
+			if len(prefix) > longest && matchesPrefix(path, prefix) {
+				limit, longest = n, len(prefix)
+			}
+		}
+		if limit <= 0 || c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+
+		if c.Request.ContentLength > limit {
+			tooLarge(c, limit)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, limit+1))
+		c.Request.Body.Close()
+		if err != nil {
+			abortWithProblem(c, http.StatusBadRequest, i18n.CodeInvalidRequest, nil)
+			return
+		}
+		if int64(len(body)) > limit {
+			tooLarge(c, limit)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Request.ContentLength = int64(len(body))
+		c.Next()
+	}
+}
+
+// tooLarge rejects the request because its body exceeds the limit.
+func tooLarge(c *gin.Context, limit int64) {
+	logging.FromContext(c.Request.Context()).Warn("Request body too large", "method", c.Request.Method, "path", c.Request.URL.Path, "limit", limit)
+	// The rest of the body isn't read, so the connection can't be reused.
+	c.Header("Connection", "close")
+	abortWithProblem(c, http.StatusRequestEntityTooLarge, i18n.CodeBodyTooLarge, i18n.Params{"bytes": limit})
+}
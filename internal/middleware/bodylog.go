@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bodyCaptureWriter tees the response body into a bounded buffer.
+type bodyCaptureWriter struct {
+	gin.ResponseWriter
+	body     *bytes.Buffer
+	maxBytes int
+}
+
+func (w *bodyCaptureWriter) Write(b []byte) (int, error) {
+	if room := w.maxBytes - w.body.Len(); room > 0 {
+		w.body.Write(b[:min(len(b), room)])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyCaptureWriter) WriteString(s string) (int, error) {
+	if room := w.maxBytes - w.body.Len(); room > 0 {
+		w.body.WriteString(s[:min(len(s), room)])
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+// BodyLogger creates a Gin middleware that logs request and response bodies at debug level,
+// for troubleshooting integrations. Phone numbers are masked and OTPs and tokens replaced
+// before anything is logged (see logging.RedactBody). At most maxBytes of each body are kept.
+// It does nothing unless the request logger has debug logging enabled.
+func BodyLogger(maxBytes int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logger := logging.FromContext(c.Request.Context())
+		if !logger.Enabled(c.Request.Context(), slog.LevelDebug) {
+			c.Next()
+			return
+		}
+
+		var requestBody []byte
+		if c.Request.Body != nil {
+			requestBody, _ = io.ReadAll(io.LimitReader(c.Request.Body, int64(maxBytes)))
+			// Hand the handler the full body again: the part we read plus whatever is left.
+			c.Request.Body = readCloser{io.MultiReader(bytes.NewReader(requestBody), c.Request.Body), c.Request.Body}
+		}
+
+		writer := &bodyCaptureWriter{ResponseWriter: c.Writer, body: &bytes.Buffer{}, maxBytes: maxBytes}
+		c.Writer = writer
+
+		c.Next()
+
+		logger.Debug("request body",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"request_body", logging.RedactBody(requestBody, c.ContentType()),
+			"response_body", logging.RedactBody(writer.body.Bytes(), c.Writer.Header().Get("Content-Type")),
+		)
+	}
+}
+
+// readCloser reads from one reader and closes another.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
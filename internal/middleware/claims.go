@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Claims are the claims of the access tokens issued to users. The same struct is used to
+// issue and to parse them, so claims of the wrong type fail parsing instead of panicking.
+type Claims struct {
+	jwt.RegisteredClaims
+	// Phone is the phone number the user signed in with. Guests have none.
+	Phone string `json:"phone,omitempty"`
+	// Guest marks the limited tokens of guests, who haven't verified a phone number yet.
+	Guest bool `json:"guest,omitempty"`
+	// PhoneVerifiedAt is when the user last verified their phone number, as of issuing the
+	// token. RequireRecentVerification checks it.
+	PhoneVerifiedAt *jwt.NumericDate `json:"phone_verified_at,omitempty"`
+}
+
+// NewClaims returns the claims of a token for the user, issued at now and valid for ttl.
+func NewClaims(userID uuid.UUID, phoneNumber string, now time.Time, ttl time.Duration) *Claims {
+	return &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		Phone: phoneNumber,
+	}
+}
+
+// NewGuestClaims returns the claims of a guest token, issued at now and valid for ttl.
+func NewGuestClaims(guestID uuid.UUID, now time.Time, ttl time.Duration) *Claims {
+	return &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   guestID.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		Guest: true,
+	}
+}
+
+// UserID returns the user the token was issued to.
+func (c *Claims) UserID() (uuid.UUID, error) {
+	id, err := uuid.Parse(c.Subject)
+	if err != nil {
+		return uuid.Nil, errors.New("subject is not a user ID")
+	}
+	return id, nil
+}
+
+// Validate checks the custom claims. The parser calls it after validating the registered ones.
+func (c *Claims) Validate() error {
+	if _, err := c.UserID(); err != nil {
+		return err
+	}
+	if c.Phone == "" && !c.Guest {
+		return errors.New("phone is missing")
+	}
+	return nil
+}
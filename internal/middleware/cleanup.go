@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultCleanupInterval is how often the in-memory limiters and stores drop stale entries
+// unless told otherwise.
+const DefaultCleanupInterval = 10 * time.Minute
+
+// janitor runs the cleanup of an in-memory store in the background until it is stopped.
+type janitor struct {
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// startJanitor calls clean every interval. An interval of zero or less runs no cleanup at all,
+// for stores that never grow, e.g. with a single key.
+func startJanitor(interval time.Duration, clean func()) *janitor {
+	j := &janitor{stop: make(chan struct{}), done: make(chan struct{})}
+	if interval <= 0 {
+		close(j.done)
+		return j
+	}
+
+	go func() {
+		defer close(j.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				clean()
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+	return j
+}
+
+// Stop ends the cleanup and waits until a running one finished. Stopping again is harmless.
+func (j *janitor) Stop() {
+	j.stopOnce.Do(func() {
+		close(j.stop)
+		<-j.done
+	})
+}
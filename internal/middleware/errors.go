@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"maps"
+	"net/http"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorHandler creates a Gin middleware that answers the errors handlers attach with c.Error,
+// unless they responded already: domain errors (see apperr) with their status and code, any
+// other error with 500. Server errors are logged with their cause, which clients never see.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		last := c.Errors.Last()
+		if last == nil || c.Writer.Written() {
+			return
+		}
+		e, ok := apperr.As(last.Err)
+		if !ok {
+			e = apperr.ErrInternal.Wrap(last.Err)
+		}
+		if e.Status >= http.StatusInternalServerError {
+			logging.FromContext(c.Request.Context()).Error("Request failed",
+				"method", c.Request.Method, "route", c.FullPath(), "code", e.Code, "error", last.Err)
+		}
+		body := ErrorBody(c, e.Status, e.Code, e.Params)
+		maps.Copy(body, e.Fields)
+		if details, ok := last.Meta.(string); ok && details != "" {
+			body["details"] = details
+		}
+		c.JSON(e.Status, body)
+	}
+}
+
+// Abort records err for ErrorHandler to answer and stops the handlers after the current one.
+func Abort(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// AbortWithDetails is Abort with details for the client, e.g. why binding the body failed.
+func AbortWithDetails(c *gin.Context, err error, details string) {
+	_ = c.Error(err).SetMeta(details)
+	c.Abort()
+}
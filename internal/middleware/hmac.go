@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// ContextKeyServiceClient is the key used to store the ID of an authenticated backend caller.
+	ContextKeyServiceClient = "service_client"
+
+	HeaderClientID  = "X-Client-ID"
+	HeaderTimestamp = "X-Timestamp"
+	HeaderSignature = "X-Signature"
+
+	// maxSignedBodySize bounds how much of a request is buffered to verify its signature.
+	maxSignedBodySize = 1 << 20
+)
+
+// SignedRequestAuth creates a Gin middleware for server-to-server calls. A trusted backend sends
+// X-Client-ID, X-Timestamp (Unix seconds) and X-Signature: "sha256=" followed by the hex
+// HMAC-SHA256 of "<timestamp>.<method>.<path>.<raw body>" keyed with its shared secret, e.g.
+// "1700000000.POST./otp/send.{...}". The path is the one requested, without the query. Signing
+// the method and path keeps a request captured within maxSkew from being replayed to another
+// route with the same body. Requests without these headers pass through untouched; requests with
+// an unknown client, a timestamp off by more than maxSkew or a wrong signature are rejected.
+// Verified callers are recorded under ContextKeyServiceClient, which exempts them from the
+// per-IP rate limit since they send on behalf of many users.
+func SignedRequestAuth(secrets map[string]string, maxSkew time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientID := c.GetHeader(HeaderClientID)
+		if clientID == "" && c.GetHeader(HeaderSignature) == "" {
+			c.Next()
+			return
+		}
+
+		if err := verifySignedRequest(c, secrets, clientID, maxSkew); err != nil {
+			logging.FromContext(c.Request.Context()).Warn("Rejected signed request", "client_id", clientID, "error", err)
+			abortWithProblem(c, http.StatusUnauthorized, i18n.CodeInvalidSignature, nil)
+			return
+		}
+
+		c.Set(ContextKeyServiceClient, clientID)
+		c.Next()
+	}
+}
+
+func verifySignedRequest(c *gin.Context, secrets map[string]string, clientID string, maxSkew time.Duration) error {
+	secret, ok := secrets[clientID]
+	if !ok {
+		return errors.New("unknown client")
+	}
+
+	timestamp := c.GetHeader(HeaderTimestamp)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > maxSkew || skew < -maxSkew {
+		return errors.New("timestamp outside the allowed window")
+	}
+
+	signature, ok := strings.CutPrefix(c.GetHeader(HeaderSignature), "sha256=")
+	if !ok {
+		return errors.New("missing sha256 signature")
+	}
+	received, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.New("malformed signature")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSignedBodySize+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxSignedBodySize {
+		return errors.New("body too large")
+	}
+	// Let the handler read the body again.
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + c.Request.Method + "." + c.Request.URL.Path + "."))
+	mac.Write(body)
+	if !hmac.Equal(received, mac.Sum(nil)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// IsServiceClient reports whether the request comes from a trusted backend, i.e. it was
+// signed or authenticated with an API key.
+func IsServiceClient(c *gin.Context) bool {
+	return c.GetString(ContextKeyServiceClient) != "" || HasAPIKey(c)
+}
+
+// Tenant names the trusted backend sending the request: "client:<id>" for signed requests,
+// "api-key:<id>" for API keys. It's empty for requests of end users.
+func Tenant(c *gin.Context) string {
+	if clientID := c.GetString(ContextKeyServiceClient); clientID != "" {
+		return "client:" + clientID
+	}
+	if val, ok := c.Get(ContextKeyAPIKey); ok {
+		if key, ok := val.(model.APIKey); ok {
+			return "api-key:" + key.ID.String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testClientID = "billing"
+	testSecret   = "billing-secret"
+)
+
+func sign(secret, timestamp, method, path, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + method + "." + path + "." + body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestSignedRequestAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const maxSkew = 5 * time.Minute
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	past := strconv.FormatInt(time.Now().Add(-maxSkew-time.Minute).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(maxSkew+time.Minute).Unix(), 10)
+	body := `{"phone_number":"+14155552671"}`
+	large := strings.Repeat("x", maxSignedBodySize+1)
+
+	tests := []struct {
+		name                string
+		method, path, body  string
+		clientID, timestamp string
+		signature           string
+		wantStatus          int
+		wantServiceClient   bool
+	}{
+		{
+			name:   "unsigned",
+			method: http.MethodPost, path: "/otp/send", body: body,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "valid",
+			method: http.MethodPost, path: "/otp/send", body: body,
+			clientID: testClientID, timestamp: now,
+			signature:  sign(testSecret, now, http.MethodPost, "/otp/send", body),
+			wantStatus: http.StatusOK, wantServiceClient: true,
+		},
+		{
+			name:   "unknown client",
+			method: http.MethodPost, path: "/otp/send", body: body,
+			clientID: "unknown", timestamp: now,
+			signature:  sign(testSecret, now, http.MethodPost, "/otp/send", body),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "timestamp in the past",
+			method: http.MethodPost, path: "/otp/send", body: body,
+			clientID: testClientID, timestamp: past,
+			signature:  sign(testSecret, past, http.MethodPost, "/otp/send", body),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "timestamp in the future",
+			method: http.MethodPost, path: "/otp/send", body: body,
+			clientID: testClientID, timestamp: future,
+			signature:  sign(testSecret, future, http.MethodPost, "/otp/send", body),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "invalid timestamp",
+			method: http.MethodPost, path: "/otp/send", body: body,
+			clientID: testClientID, timestamp: "yesterday",
+			signature:  sign(testSecret, "yesterday", http.MethodPost, "/otp/send", body),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "missing sha256 prefix",
+			method: http.MethodPost, path: "/otp/send", body: body,
+			clientID: testClientID, timestamp: now,
+			signature:  strings.TrimPrefix(sign(testSecret, now, http.MethodPost, "/otp/send", body), "sha256="),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "bad hex",
+			method: http.MethodPost, path: "/otp/send", body: body,
+			clientID: testClientID, timestamp: now,
+			signature:  "sha256=not-hex",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "wrong secret",
+			method: http.MethodPost, path: "/otp/send", body: body,
+			clientID: testClientID, timestamp: now,
+			signature:  sign("other-secret", now, http.MethodPost, "/otp/send", body),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "body too large",
+			method: http.MethodPost, path: "/otp/send", body: large,
+			clientID: testClientID, timestamp: now,
+			signature:  sign(testSecret, now, http.MethodPost, "/otp/send", large),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "replayed to another path",
+			method: http.MethodPost, path: "/otp/verify", body: body,
+			clientID: testClientID, timestamp: now,
+			signature:  sign(testSecret, now, http.MethodPost, "/otp/send", body),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "replayed with another method",
+			method: http.MethodPut, path: "/otp/send", body: body,
+			clientID: testClientID, timestamp: now,
+			signature:  sign(testSecret, now, http.MethodPost, "/otp/send", body),
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotBody string
+			var serviceClient bool
+			router := gin.New()
+			router.Use(SignedRequestAuth(map[string]string{testClientID: testSecret}, maxSkew))
+			router.Any("/otp/:action", func(c *gin.Context) {
+				data, err := io.ReadAll(c.Request.Body)
+				if err != nil {
+					t.Errorf("reading the body: %v", err)
+				}
+				gotBody, serviceClient = string(data), IsServiceClient(c)
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.clientID != "" {
+				req.Header.Set(HeaderClientID, tt.clientID)
+			}
+			if tt.timestamp != "" {
+				req.Header.Set(HeaderTimestamp, tt.timestamp)
+			}
+			if tt.signature != "" {
+				req.Header.Set(HeaderSignature, tt.signature)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			// The handler still reads the whole body after the middleware has.
+			if gotBody != tt.body {
+				t.Errorf("handler read body %q, want %q", gotBody, tt.body)
+			}
+			if serviceClient != tt.wantServiceClient {
+				t.Errorf("IsServiceClient = %v, want %v", serviceClient, tt.wantServiceClient)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Localization creates a Gin middleware that selects the language of client-facing messages
+// from the Accept-Language header and stores the localizer in the request context.
+func Localization(catalog *i18n.Catalog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		localizer := catalog.Localizer(c.GetHeader("Accept-Language"))
+		c.Request = c.Request.WithContext(i18n.NewContext(c.Request.Context(), localizer))
+		c.Header("Content-Language", localizer.Language())
+		c.Next()
+	}
+}
+
+// ProblemContentType is the media type of error responses, RFC 9457 problem details.
+const ProblemContentType = "application/problem+json"
+
+// ErrorBody builds the body of an error response with status as RFC 9457 problem details: the
+// status and its title, the message in the client's language as detail and, as extensions, the
+// stable machine-readable code, the message again as error (the field clients read before) and
+// the request ID to quote when reporting problems. It sets the Content-Type of the response to
+// ProblemContentType, which c.JSON keeps.
+func ErrorBody(c *gin.Context, status int, code string, params i18n.Params) gin.H {
+	message := i18n.FromContext(c.Request.Context()).Message(code, params)
+	c.Header("Content-Type", ProblemContentType)
+	return gin.H{
+		"title":      http.StatusText(status),
+		"status":     status,
+		"detail":     message,
+		"code":       code,
+		"error":      message,
+		"request_id": GetRequestID(c),
+	}
+}
+
+// abortWithProblem answers with the problem details of status and code and stops the handlers.
+func abortWithProblem(c *gin.Context, status int, code string, params i18n.Params) {
+	c.AbortWithStatusJSON(status, ErrorBody(c, status, code, params))
+}
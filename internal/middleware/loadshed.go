@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LoadShedder creates a Gin middleware that protects the instance (and the database behind it)
+// during traffic spikes. Requests beyond maxConcurrent in-flight requests or maxQPS requests per
+// second are rejected immediately with 503 and a Retry-After header instead of queueing up.
+// A limit of zero disables that check. Paths listed in exempt (e.g. health checks) are never shed.
+func LoadShedder(maxConcurrent, maxQPS int, exempt ...string) gin.HandlerFunc {
+	var slots chan struct{}
+	if maxConcurrent > 0 {
+		slots = make(chan struct{}, maxConcurrent)
+	}
+
+	var qps *TokenBucketRateLimiter
+	if maxQPS > 0 {
+		// A single key needs no cleanup, and so no goroutine that would outlive the middleware.
+		qps = NewTokenBucketRateLimiter(maxQPS, maxQPS, time.Second, 0)
+	}
+
+	exemptPaths := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		exemptPaths[path] = true
+	}
+
+	return func(c *gin.Context) {
+		if exemptPaths[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		if qps != nil {
+			if result := qps.Allow("global"); !result.Allowed {
+				shed(c, RetryAfterSeconds(result))
+				return
+			}
+		}
+
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			default:
+				shed(c, 1)
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// shed rejects the request because the instance is saturated.
+func shed(c *gin.Context, retryAfter int) {
+	logging.FromContext(c.Request.Context()).Warn("Shedding load", "method", c.Request.Method, "path", c.Request.URL.Path)
+	c.Header("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	body := ErrorBody(c, http.StatusServiceUnavailable, i18n.CodeServerOverloaded, nil)
+	body["retry_after"] = max(retryAfter, 1)
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, body)
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestLogger creates a Gin middleware that stores a logger carrying the request ID
+// (and the trace ID, when the request is traced) in the request context,
+// so every log line written while handling the request can be correlated.
+// It must run after RequestIDMiddleware.
+// Once the request is done it writes one structured access log line.
+func RequestLogger(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		reqLogger := logger.With("request_id", GetRequestID(c))
+		if spanCtx := trace.SpanContextFromContext(c.Request.Context()); spanCtx.HasTraceID() {
+			reqLogger = reqLogger.With("trace_id", spanCtx.TraceID().String())
+		}
+
+		c.Request = c.Request.WithContext(logging.NewContext(c.Request.Context(), reqLogger))
+
+		c.Next()
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelError
+		}
+		reqLogger.Log(c.Request.Context(), level, "request completed",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"latency_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+		)
+	}
+}
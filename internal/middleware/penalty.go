@@ -0,0 +1,258 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// PenaltyBox stores the progressive backoff state of rate limited keys.
+// This allows for easy swapping between in-memory, Redis, etc.
+type PenaltyBox interface {
+	// Blocked returns until when the key is blocked and its current penalty level.
+	// A zero time means the key is not blocked.
+	Blocked(key string) (time.Time, int)
+	// Penalize raises the penalty level of the key and blocks it for window * 2^level,
+	// capped at maxPenalty. It returns the end of the block and the new level.
+	Penalize(key string, window, maxPenalty time.Duration) (time.Time, int)
+	// Stop releases background resources (e.g. cleanup goroutines) on shutdown.
+	Stop()
+}
+
+// PenaltyRateLimiter wraps a RateLimiterStore with escalating penalties: each time a key
+// exhausts its limit it is blocked for twice as long as the previous time (2m, 4m, 8m, ...),
+// up to maxPenalty. Penalties are forgotten once a key stays clean for 2*maxPenalty.
+type PenaltyRateLimiter struct {
+	inner      RateLimiterStore
+	box        PenaltyBox
+	limit      int
+	window     time.Duration
+	maxPenalty time.Duration
+}
+
+// NewPenaltyRateLimiter creates and returns a new PenaltyRateLimiter.
+// limit: The limit of the inner limiter, reported while a key is blocked.
+// window: The time window of the inner limiter, the base for the backoff.
+// maxPenalty: The maximum time a key can be blocked for.
+func NewPenaltyRateLimiter(inner RateLimiterStore, box PenaltyBox, limit int, window, maxPenalty time.Duration) *PenaltyRateLimiter {
+	return &PenaltyRateLimiter{
+		inner:      inner,
+		box:        box,
+		limit:      limit,
+		window:     window,
+		maxPenalty: maxPenalty,
+	}
+}
+
+// Allow checks if a request for a given key is permitted.
+func (r *PenaltyRateLimiter) Allow(key string) model.RateLimitResult {
+	now := time.Now()
+
+	// While a key serves its penalty the inner limiter isn't consulted at all,
+	// so blocked requests can't use up the quota of the next window.
+	if blockedUntil, penalty := r.box.Blocked(key); blockedUntil.After(now) {
+		return model.RateLimitResult{
+			Allowed:    false,
+			Limit:      r.limit,
+			Remaining:  0,
+			ResetAt:    blockedUntil,
+			RetryAfter: blockedUntil.Sub(now),
+			Penalty:    penalty,
+		}
+	}
+
+	result := r.inner.Allow(key)
+	if result.Allowed {
+		return result
+	}
+
+	blockedUntil, penalty := r.box.Penalize(key, r.window, r.maxPenalty)
+	slog.Warn("Rate limit exhausted, applying penalty", "key", key, "penalty_level", penalty, "blocked_until", blockedUntil)
+	result.Penalty = penalty
+	if blockedUntil.After(result.ResetAt) {
+		result.ResetAt = blockedUntil
+	}
+	if wait := blockedUntil.Sub(now); wait > result.RetryAfter {
+		result.RetryAfter = wait
+	}
+	return result
+}
+
+// Stop stops the inner limiter and the penalty box.
+func (r *PenaltyRateLimiter) Stop() {
+	r.inner.Stop()
+	r.box.Stop()
+}
+
+// penaltyDuration returns window * 2^level, capped at maxPenalty.
+func penaltyDuration(level int, window, maxPenalty time.Duration) time.Duration {
+	penalty := window
+	for i := 0; i < level && penalty < maxPenalty; i++ {
+		penalty *= 2
+	}
+	return min(penalty, maxPenalty)
+}
+
+type penaltyState struct {
+	blockedUntil time.Time
+	level        int
+	lastPenalty  time.Time
+	forgetAfter  time.Duration
+}
+
+// InMemoryPenaltyBox implements PenaltyBox using a simple in-memory map.
+type InMemoryPenaltyBox struct {
+	penalties map[string]*penaltyState
+	mu        sync.Mutex
+	janitor   *janitor
+}
+
+// NewInMemoryPenaltyBox creates and returns a new InMemoryPenaltyBox, which forgets expired
+// penalties every cleanupInterval (see DefaultCleanupInterval).
+func NewInMemoryPenaltyBox(cleanupInterval time.Duration) *InMemoryPenaltyBox {
+	box := &InMemoryPenaltyBox{
+		penalties: make(map[string]*penaltyState),
+	}
+
+	// Start a background goroutine to periodically forget expired penalties
+	box.janitor = startJanitor(cleanupInterval, box.cleanup)
+
+	return box
+}
+
+// Blocked returns until when the key is blocked and its current penalty level.
+func (b *InMemoryPenaltyBox) Blocked(key string) (time.Time, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, ok := b.penalties[key]
+	if !ok {
+		return time.Time{}, 0
+	}
+	return state.blockedUntil, state.level
+}
+
+// Penalize raises the penalty level of the key and blocks it.
+func (b *InMemoryPenaltyBox) Penalize(key string, window, maxPenalty time.Duration) (time.Time, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	state, ok := b.penalties[key]
+	if !ok || now.Sub(state.lastPenalty) > state.forgetAfter {
+		state = &penaltyState{}
+		b.penalties[key] = state
+	}
+
+	state.level++
+	state.lastPenalty = now
+	state.forgetAfter = 2 * maxPenalty
+	state.blockedUntil = now.Add(penaltyDuration(state.level, window, maxPenalty))
+	return state.blockedUntil, state.level
+}
+
+// Stop ends the cleanup goroutine and waits for it to exit.
+func (b *InMemoryPenaltyBox) Stop() {
+	b.janitor.Stop()
+}
+
+// cleanup removes penalties that have been forgotten.
+func (b *InMemoryPenaltyBox) cleanup() {
+	b.mu.Lock()
+	now := time.Now()
+	for key, state := range b.penalties {
+		if now.Sub(state.lastPenalty) > state.forgetAfter {
+			delete(b.penalties, key)
+		}
+	}
+	b.mu.Unlock()
+	slog.Debug("Penalty box cleanup finished")
+}
+
+// penalizeScript raises the penalty level stored in a hash and blocks the key.
+// The hash expires after 2*maxPenalty, which forgets the penalty of a key that stays clean.
+//
+// KEYS[1] - the penalty key
+// ARGV[1] - current time in milliseconds
+// ARGV[2] - window size in milliseconds
+// ARGV[3] - maximum penalty in milliseconds
+//
+// Returns {blocked until in milliseconds, penalty level}.
+var penalizeScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local maxPenalty = tonumber(ARGV[3])
+
+local level = redis.call('HINCRBY', key, 'level', 1)
+local penalty = math.min(window * math.pow(2, level), maxPenalty)
+local blockedUntil = now + penalty
+
+redis.call('HSET', key, 'until', blockedUntil)
+redis.call('PEXPIRE', key, 2 * maxPenalty)
+return {blockedUntil, level}
+`)
+
+// RedisPenaltyBox implements PenaltyBox on top of Redis, sharing penalties across replicas.
+type RedisPenaltyBox struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisPenaltyBox creates and returns a new RedisPenaltyBox.
+func NewRedisPenaltyBox(client *redis.Client, prefix string) *RedisPenaltyBox {
+	return &RedisPenaltyBox{client: client, prefix: prefix}
+}
+
+// Blocked returns until when the key is blocked and its current penalty level.
+// Redis errors are treated as "not blocked", like the Redis rate limiter does.
+func (b *RedisPenaltyBox) Blocked(key string) (time.Time, int) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	state, err := b.client.HMGet(ctx, b.prefix+key, "until", "level").Result()
+	if err != nil {
+		slog.Error("Redis penalty box failed", "key", key, "error", err)
+		return time.Time{}, 0
+	}
+
+	var blockedUntil, level int64
+	if v, ok := state[0].(string); ok {
+		blockedUntil, _ = strconv.ParseInt(v, 10, 64)
+	}
+	if v, ok := state[1].(string); ok {
+		level, _ = strconv.ParseInt(v, 10, 64)
+	}
+	if blockedUntil == 0 {
+		return time.Time{}, int(level)
+	}
+	return time.UnixMilli(blockedUntil), int(level)
+}
+
+// Stop is a no-op; the Redis client is closed by its owner.
+func (b *RedisPenaltyBox) Stop() {}
+
+// Penalize raises the penalty level of the key and blocks it.
+func (b *RedisPenaltyBox) Penalize(key string, window, maxPenalty time.Duration) (time.Time, int) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	now := time.Now()
+	reply, err := penalizeScript.Run(ctx, b.client,
+		[]string{b.prefix + key},
+		now.UnixMilli(),
+		window.Milliseconds(),
+		maxPenalty.Milliseconds(),
+	).Int64Slice()
+	if err != nil || len(reply) != 2 {
+		slog.Error("Redis penalty box failed", "key", key, "error", err)
+		return now, 0
+	}
+	return time.UnixMilli(reply[0]), int(reply[1])
+}
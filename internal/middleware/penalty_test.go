@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+)
+
+// countingLimiter allows requests while allowed is set and counts the requests it sees.
+type countingLimiter struct {
+	allowed bool
+	calls   int
+}
+
+func (l *countingLimiter) Allow(string) model.RateLimitResult {
+	l.calls++
+	return model.RateLimitResult{Allowed: l.allowed, Limit: 5, ResetAt: time.Now().Add(time.Minute)}
+}
+
+func (l *countingLimiter) Stop() {}
+
+// scriptPenalty is the backoff of penalizeScript, math.min(window * math.pow(2, level), maxPenalty).
+func scriptPenalty(level int, window, maxPenalty time.Duration) time.Duration {
+	return time.Duration(math.Min(float64(window)*math.Pow(2, float64(level)), float64(maxPenalty)))
+}
+
+func TestPenaltyDuration(t *testing.T) {
+	const window, maxPenalty = time.Minute, 10 * time.Minute
+	tests := []struct {
+		level int
+		want  time.Duration
+	}{
+		{1, 2 * time.Minute},
+		{2, 4 * time.Minute},
+		{3, 8 * time.Minute},
+		{4, maxPenalty},
+		{50, maxPenalty},
+	}
+	for _, tt := range tests {
+		if got := penaltyDuration(tt.level, window, maxPenalty); got != tt.want {
+			t.Errorf("penaltyDuration(%d) = %v, want %v", tt.level, got, tt.want)
+		}
+	}
+	// The in-memory and Redis penalty boxes must back off alike.
+	for level := 1; level <= 64; level++ {
+		if got, want := penaltyDuration(level, window, maxPenalty), scriptPenalty(level, window, maxPenalty); got != want {
+			t.Errorf("level %d: penaltyDuration = %v, penalizeScript = %v", level, got, want)
+		}
+	}
+}
+
+func TestInMemoryPenaltyBoxEscalates(t *testing.T) {
+	const window, maxPenalty = time.Minute, 10 * time.Minute
+	box := NewInMemoryPenaltyBox(time.Hour)
+	defer box.Stop()
+
+	for i, want := range []time.Duration{2 * time.Minute, 4 * time.Minute, 8 * time.Minute, maxPenalty, maxPenalty} {
+		before := time.Now()
+		blockedUntil, level := box.Penalize("+14155552671", window, maxPenalty)
+		if level != i+1 {
+			t.Errorf("penalty %d: level = %d, want %d", i+1, level, i+1)
+		}
+		if got := blockedUntil.Sub(before); got < want || got > want+time.Second {
+			t.Errorf("penalty %d: blocked for %v, want %v", i+1, got, want)
+		}
+		if until, blockedLevel := box.Blocked("+14155552671"); !until.Equal(blockedUntil) || blockedLevel != level {
+			t.Errorf("penalty %d: Blocked = %v, %d, want %v, %d", i+1, until, blockedLevel, blockedUntil, level)
+		}
+	}
+}
+
+func TestInMemoryPenaltyBoxForgets(t *testing.T) {
+	const window, maxPenalty = time.Minute, 10 * time.Minute
+	box := NewInMemoryPenaltyBox(time.Hour)
+	defer box.Stop()
+
+	box.Penalize("+14155552671", window, maxPenalty)
+	box.Penalize("+14155552671", window, maxPenalty)
+
+	// Still remembered just before 2*maxPenalty without a penalty...
+	box.penalties["+14155552671"].lastPenalty = time.Now().Add(-2*maxPenalty + time.Minute)
+	if _, level := box.Penalize("+14155552671", window, maxPenalty); level != 3 {
+		t.Errorf("level = %d within 2*maxPenalty, want 3", level)
+	}
+
+	// ...and forgotten after it, starting over at the first penalty.
+	box.penalties["+14155552671"].lastPenalty = time.Now().Add(-2*maxPenalty - time.Minute)
+	if _, level := box.Penalize("+14155552671", window, maxPenalty); level != 1 {
+		t.Errorf("level = %d after 2*maxPenalty, want 1", level)
+	}
+
+	box.penalties["+14155552671"].lastPenalty = time.Now().Add(-2*maxPenalty - time.Minute)
+	box.cleanup()
+	if until, level := box.Blocked("+14155552671"); !until.IsZero() || level != 0 {
+		t.Errorf("Blocked after cleanup = %v, %d, want the key forgotten", until, level)
+	}
+}
+
+func TestPenaltyRateLimiter(t *testing.T) {
+	const window, maxPenalty = time.Minute, 10 * time.Minute
+	inner := &countingLimiter{allowed: true}
+	box := NewInMemoryPenaltyBox(time.Hour)
+	limiter := NewPenaltyRateLimiter(inner, box, 5, window, maxPenalty)
+	defer limiter.Stop()
+
+	if result := limiter.Allow("+14155552671"); !result.Allowed || result.Penalty != 0 {
+		t.Fatalf("Allow under the limit = %+v, want allowed without penalty", result)
+	}
+
+	// Exhausting the limit blocks the key for twice the window.
+	inner.allowed = false
+	result := limiter.Allow("+14155552671")
+	if result.Allowed || result.Penalty != 1 {
+		t.Fatalf("Allow over the limit = %+v, want denied with penalty 1", result)
+	}
+	if result.RetryAfter < 2*window-time.Second || result.RetryAfter > 2*window+time.Second {
+		t.Errorf("RetryAfter = %v, want about %v", result.RetryAfter, 2*window)
+	}
+
+	// While blocked, requests don't reach the inner limiter, even once it would allow them.
+	inner.allowed = true
+	calls := inner.calls
+	for range 3 {
+		if result := limiter.Allow("+14155552671"); result.Allowed || result.Penalty != 1 || result.Remaining != 0 || result.Limit != 5 {
+			t.Errorf("Allow while blocked = %+v, want denied with penalty 1", result)
+		}
+	}
+	if inner.calls != calls {
+		t.Errorf("inner limiter saw %d requests while the key was blocked, want none", inner.calls-calls)
+	}
+
+	// Once the block ends, the next exhaustion doubles it.
+	box.penalties["+14155552671"].blockedUntil = time.Now().Add(-time.Second)
+	inner.allowed = false
+	if result := limiter.Allow("+14155552671"); result.Penalty != 2 || result.RetryAfter < 4*window-time.Second {
+		t.Errorf("Allow over the limit again = %+v, want penalty 2 for about %v", result, 4*window)
+	}
+}
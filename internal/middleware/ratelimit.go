@@ -1,11 +1,14 @@
 package middleware
 
 import (
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
 	"github.com/ebipenman/go-otp-auth-service/internal/model"
 
 	"github.com/gin-gonic/gin"
@@ -14,112 +17,194 @@ import (
 // RateLimiterStore defines the interface for a rate limiter's underlying storage.
 // This allows for easy swapping between in-memory, Redis, etc.
 type RateLimiterStore interface {
-	Allow(key string) bool
+	Allow(key string) model.RateLimitResult
+	// Stop releases background resources (e.g. cleanup goroutines) on shutdown.
+	Stop()
 }
 
 // InMemoryRateLimiter implements RateLimiterStore using a simple in-memory map.
-// It tracks request timestamps for each key (e.g., phone number).
+// It uses the sliding window counter algorithm: for each key (e.g., phone number) it only keeps
+// the request counts of the current and the previous fixed window, and estimates the number of
+// requests in the sliding window by weighting the previous count by how much of it still overlaps.
+// Memory use is therefore constant per key, no matter how many requests an attacker sends.
 type InMemoryRateLimiter struct {
-	requests   map[string][]time.Time
+	counters   map[string]*windowCounter
 	mu         sync.RWMutex
 	maxReq     int
 	timeWindow time.Duration
+	janitor    *janitor
+}
+
+// windowCounter holds the request counts of the two most recent fixed windows for one key.
+type windowCounter struct {
+	windowStart time.Time
+	current     int
+	previous    int
 }
 
 // NewInMemoryRateLimiter creates and returns a new InMemoryRateLimiter.
 // maxReq: Maximum number of requests allowed.
 // timeWindow: The duration of the time window.
-func NewInMemoryRateLimiter(maxReq int, timeWindow time.Duration) *InMemoryRateLimiter {
+// cleanupInterval: How often keys without recent requests are dropped, see DefaultCleanupInterval.
+func NewInMemoryRateLimiter(maxReq int, timeWindow, cleanupInterval time.Duration) *InMemoryRateLimiter {
 	limiter := &InMemoryRateLimiter{
-		requests:   make(map[string][]time.Time),
+		counters:   make(map[string]*windowCounter),
 		maxReq:     maxReq,
 		timeWindow: timeWindow,
 	}
 
 	// Start a background goroutine to periodically clean up old entries
-	go limiter.cleanup()
+	limiter.janitor = startJanitor(cleanupInterval, limiter.cleanup)
 
 	return limiter
 }
 
 // Allow checks if a request for a given key is permitted.
-func (r *InMemoryRateLimiter) Allow(key string) bool {
+func (r *InMemoryRateLimiter) Allow(key string) model.RateLimitResult {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	currentTime := time.Now()
-	// Filter out requests that are older than the time window
-	var recentRequests []time.Time
-	for _, t := range r.requests[key] {
-		if currentTime.Sub(t) <= r.timeWindow {
-			recentRequests = append(recentRequests, t)
-		}
+	counter, ok := r.counters[key]
+	if !ok {
+		counter = &windowCounter{}
+		r.counters[key] = counter
+	}
+	r.advance(counter, currentTime)
+
+	// Check if the estimated number of requests in the sliding window has reached the maximum
+	allowed := r.estimate(counter, currentTime) < float64(r.maxReq)
+	if allowed {
+		// Count the current request
+		counter.current++
+	} else {
+		slog.Warn("Rate limit exceeded", "key", key)
 	}
 
-	// Check if the number of recent requests has reached the maximum
-	if len(recentRequests) >= r.maxReq {
-		log.Printf("Rate limit exceeded for key: %s", key)
-		r.requests[key] = recentRequests // Update with the filtered list
-		return false                     // Rate limit exceeded
+	remaining := int(math.Floor(float64(r.maxReq) - r.estimate(counter, currentTime)))
+	result := model.RateLimitResult{
+		Allowed:   allowed,
+		Limit:     r.maxReq,
+		Remaining: max(remaining, 0),
+		ResetAt:   r.resetAt(counter, currentTime),
 	}
+	if !allowed {
+		result.RetryAfter = r.retryAt(counter).Sub(currentTime)
+	}
+	return result
+}
 
-	// Add the current request timestamp and allow the request
-	recentRequests = append(recentRequests, currentTime)
-	r.requests[key] = recentRequests
-	return true
+// advance rolls the counter forward so that its current window contains currentTime.
+func (r *InMemoryRateLimiter) advance(counter *windowCounter, currentTime time.Time) {
+	windowStart := currentTime.Truncate(r.timeWindow)
+	switch {
+	case windowStart.Equal(counter.windowStart):
+		return
+	case windowStart.Equal(counter.windowStart.Add(r.timeWindow)):
+		counter.previous = counter.current
+	default:
+		// More than one whole window has passed, nothing from before still counts.
+		counter.previous = 0
+	}
+	counter.current = 0
+	counter.windowStart = windowStart
 }
 
-// cleanup periodically iterates through the map and removes keys with no recent requests.
+// estimate approximates the number of requests made within the last timeWindow.
+func (r *InMemoryRateLimiter) estimate(counter *windowCounter, currentTime time.Time) float64 {
+	elapsed := currentTime.Sub(counter.windowStart)
+	overlap := float64(r.timeWindow-elapsed) / float64(r.timeWindow)
+	return float64(counter.previous)*overlap + float64(counter.current)
+}
+
+// resetAt returns when none of the counted requests affect the estimate anymore.
+func (r *InMemoryRateLimiter) resetAt(counter *windowCounter, currentTime time.Time) time.Time {
+	switch {
+	case counter.current > 0:
+		return counter.windowStart.Add(2 * r.timeWindow)
+	case counter.previous > 0:
+		return counter.windowStart.Add(r.timeWindow)
+	default:
+		return currentTime
+	}
+}
+
+// retryAt returns the earliest time at which enough of the counted requests have slid out
+// of the window for the estimate to drop below the maximum again.
+func (r *InMemoryRateLimiter) retryAt(counter *windowCounter) time.Time {
+	window := float64(r.timeWindow)
+	maxReq := float64(r.maxReq)
+
+	if current := float64(counter.current); current >= maxReq {
+		// Only once the current window has become the previous one and partially slid out.
+		wait := window * (1 - maxReq/current)
+		return counter.windowStart.Add(r.timeWindow + time.Duration(wait))
+	}
+
+	// The current window still has room, so wait until enough of the previous one slid out.
+	wait := window * (1 - (maxReq-float64(counter.current))/float64(counter.previous))
+	return counter.windowStart.Add(time.Duration(wait))
+}
+
+// Stop ends the cleanup goroutine and waits for it to exit.
+func (r *InMemoryRateLimiter) Stop() {
+	r.janitor.Stop()
+}
+
+// cleanup iterates through the map and removes keys with no recent requests.
 func (r *InMemoryRateLimiter) cleanup() {
-	// Run cleanup every 10 minutes (the same as our time window)
-	for range time.Tick(10 * time.Minute) {
-		r.mu.Lock()
-		currentTime := time.Now()
-		for key, timestamps := range r.requests {
-			var recentTimestamps []time.Time
-			for _, t := range timestamps {
-				if currentTime.Sub(t) <= r.timeWindow {
-					recentTimestamps = append(recentTimestamps, t)
-				}
-			}
-			if len(recentTimestamps) == 0 {
-				delete(r.requests, key)
-			} else {
-				r.requests[key] = recentTimestamps
-			}
+	r.mu.Lock()
+	currentTime := time.Now()
+	for key, counter := range r.counters {
+		// Once two whole windows have passed, neither bucket affects the estimate anymore.
+		if currentTime.Sub(counter.windowStart) >= 2*r.timeWindow {
+			delete(r.counters, key)
 		}
-		r.mu.Unlock()
-		log.Println("Rate limiter cleanup finished.")
 	}
+	r.mu.Unlock()
+	slog.Debug("Rate limiter cleanup finished")
 }
 
-// OTPRateLimiter creates a Gin middleware to rate limit OTP requests based on phone number.
-func OTPRateLimiter(store RateLimiterStore) gin.HandlerFunc {
-
+// IPRateLimiter creates a Gin middleware to rate limit requests based on the client IP.
+// The IP is resolved by Gin, which only honors X-Forwarded-For when the request comes
+// from one of the engine's trusted proxies (see gin.Engine.SetTrustedProxies).
+func IPRateLimiter(store RateLimiterStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var req model.SendOTPRequest
-
-		// Step 1: Bind the JSON.
-		// If binding fails, it's a malformed request. We should stop here.
-		if err := c.ShouldBindJSON(&req); err != nil {
-			// Abort the request with a 400 Bad Request.
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		// Trusted backends send on behalf of many users from a few IPs; the per phone number
+		// limits still apply to them.
+		if IsServiceClient(c) {
+			c.Next()
 			return
 		}
 
-		// Step 2: Use the phone number from the successfully bound request for rate limiting.
-		if !store.Allow(req.PhoneNumber) {
-			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
-				"error": "You have made too many requests. Please try again after rate limit time.",
-			})
+		result := store.Allow(c.ClientIP())
+		SetRateLimitHeaders(c, result)
+		if !result.Allowed {
+			retryAfter := RetryAfterSeconds(result)
+			body := ErrorBody(c, http.StatusTooManyRequests, i18n.CodeIPRateLimited, i18n.Params{"seconds": retryAfter})
+			body["retry_after"] = retryAfter
+			body["penalty_level"] = result.Penalty
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, body)
 			return
 		}
 
-		// Step 3: IMPORTANT - Store the bound request object in the context
-		// for the final handler to use.
-		c.Set("otp_request", req)
-
-		// Step 4: Proceed to the next handler.
 		c.Next()
 	}
 }
+
+// SetRateLimitHeaders writes the standard X-RateLimit-* headers for a rate limit result,
+// so clients can back off before hitting the limit. The reset is a Unix timestamp in seconds.
+// Rejected requests additionally get a Retry-After header in seconds.
+func SetRateLimitHeaders(c *gin.Context, result model.RateLimitResult) {
+	c.Header("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(result.ResetAt.Unix(), 10))
+	if !result.Allowed {
+		c.Header("Retry-After", strconv.Itoa(RetryAfterSeconds(result)))
+	}
+}
+
+// RetryAfterSeconds rounds the retry delay of a rejected request up to whole seconds.
+func RetryAfterSeconds(result model.RateLimitResult) int {
+	return int(math.Ceil(result.RetryAfter.Seconds()))
+}
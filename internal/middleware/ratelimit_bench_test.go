@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"io"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// BenchmarkRateLimiterMemory spreads requests over many keys and compares the sliding window
+// counters of InMemoryRateLimiter with the log of request timestamps they replaced. Besides the
+// allocations per request it reports the heap retained per key once the keys are full.
+func BenchmarkRateLimiterMemory(b *testing.B) {
+	const (
+		keys   = 10000
+		maxReq = 100
+		window = time.Hour
+	)
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = "+1999" + strconv.Itoa(1000000+i)
+	}
+	// Keys over the limit are logged on every request.
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	b.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	b.Run("timestamp_log", func(b *testing.B) {
+		limiter := &timestampLogLimiter{requests: make(map[string][]time.Time), maxReq: maxReq, timeWindow: window}
+		benchmarkLimiterMemory(b, names, limiter.Allow)
+		runtime.KeepAlive(limiter)
+	})
+	b.Run("window_counter", func(b *testing.B) {
+		limiter := NewInMemoryRateLimiter(maxReq, window, window)
+		defer limiter.Stop()
+		benchmarkLimiterMemory(b, names, func(key string) bool { return limiter.Allow(key).Allowed })
+		runtime.KeepAlive(limiter)
+	})
+}
+
+func benchmarkLimiterMemory(b *testing.B, keys []string, allow func(key string) bool) {
+	b.ReportAllocs()
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	i := 0
+	for b.Loop() {
+		allow(keys[i%len(keys)])
+		i++
+	}
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(int64(after.HeapAlloc)-int64(before.HeapAlloc))/float64(min(i, len(keys))), "B/key")
+}
+
+// timestampLogLimiter is InMemoryRateLimiter as it was before the sliding window counters: it
+// keeps the time of every request within the window, up to maxReq per key.
+type timestampLogLimiter struct {
+	requests   map[string][]time.Time
+	mu         sync.Mutex
+	maxReq     int
+	timeWindow time.Duration
+}
+
+func (r *timestampLogLimiter) Allow(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	currentTime := time.Now()
+	var recentRequests []time.Time
+	for _, t := range r.requests[key] {
+		if currentTime.Sub(t) <= r.timeWindow {
+			recentRequests = append(recentRequests, t)
+		}
+	}
+	if len(recentRequests) >= r.maxReq {
+		r.requests[key] = recentRequests
+		return false
+	}
+	r.requests[key] = append(recentRequests, currentTime)
+	return true
+}
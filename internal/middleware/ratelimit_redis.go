@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// slidingWindowScript implements a sliding window counter limiter on a hash holding the
+// start of the current fixed window and the request counts of the current and previous
+// windows. It runs atomically on the Redis server, so every replica sharing the same Redis
+// instance sees and enforces the same limit, and it needs constant memory per key.
+//
+// KEYS[1] - the limiter key
+// ARGV[1] - current time in milliseconds
+// ARGV[2] - window size in milliseconds
+// ARGV[3] - maximum number of requests in the window
+//
+// Returns {allowed (0/1), remaining requests, reset time, retry time}, times in milliseconds.
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+local start = now - (now % window)
+local state = redis.call('HMGET', key, 'start', 'curr', 'prev')
+local prevStart = tonumber(state[1]) or start
+local curr = tonumber(state[2]) or 0
+local prev = tonumber(state[3]) or 0
+
+if start == prevStart + window then
+	prev = curr
+	curr = 0
+elseif start ~= prevStart then
+	prev = 0
+	curr = 0
+end
+
+local weight = (window - (now - start)) / window
+local allowed = 0
+if prev * weight + curr < limit then
+	curr = curr + 1
+	allowed = 1
+end
+
+local reset = now
+if curr > 0 then
+	reset = start + 2 * window
+elseif prev > 0 then
+	reset = start + window
+end
+
+local retry = now
+if allowed == 0 then
+	if curr >= limit then
+		retry = start + window + math.ceil(window * (1 - limit / curr))
+	else
+		retry = start + math.ceil(window * (1 - (limit - curr) / prev))
+	end
+end
+
+redis.call('HSET', key, 'start', start, 'curr', curr, 'prev', prev)
+redis.call('PEXPIRE', key, 2 * window)
+return {allowed, math.max(0, math.floor(limit - (prev * weight + curr))), reset, retry}
+`)
+
+// tokenBucketScript implements a token bucket limiter on a hash holding the
+// remaining tokens and the time of the last refill.
+//
+// KEYS[1] - the limiter key
+// ARGV[1] - current time in milliseconds
+// ARGV[2] - bucket capacity (burst)
+// ARGV[3] - refill rate in tokens per millisecond
+//
+// Returns {allowed (0/1), remaining tokens, reset time, retry time}, times in milliseconds.
+var tokenBucketScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local rate = tonumber(ARGV[3])
+
+local state = redis.call('HMGET', key, 'tokens', 'ts')
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+
+tokens = math.min(capacity, tokens + (now - ts) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+local retry = now
+if allowed == 0 then
+	retry = now + math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HSET', key, 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', key, math.ceil(capacity / rate))
+return {allowed, math.floor(tokens), now + math.ceil((capacity - tokens) / rate), retry}
+`)
+
+// RedisRateLimiter implements RateLimiterStore on top of Redis so limits are
+// enforced globally when the service runs with multiple instances.
+type RedisRateLimiter struct {
+	client     *redis.Client
+	prefix     string
+	algorithm  string
+	maxReq     int
+	burst      int
+	timeWindow time.Duration
+}
+
+// NewRedisRateLimiter creates and returns a new sliding window RedisRateLimiter.
+// prefix: Namespace for the limiter's keys, so several limiters can share one Redis.
+// maxReq: Maximum number of requests allowed.
+// timeWindow: The duration of the time window.
+func NewRedisRateLimiter(client *redis.Client, prefix string, maxReq int, timeWindow time.Duration) *RedisRateLimiter {
+	return &RedisRateLimiter{
+		client:     client,
+		prefix:     prefix,
+		algorithm:  AlgorithmSlidingWindow,
+		maxReq:     maxReq,
+		timeWindow: timeWindow,
+	}
+}
+
+// NewRedisTokenBucketRateLimiter creates and returns a token bucket RedisRateLimiter.
+// burst: Bucket capacity, i.e. how many requests may be made back to back.
+// maxReq: Number of tokens refilled per time window.
+// timeWindow: The duration over which maxReq tokens are refilled.
+func NewRedisTokenBucketRateLimiter(client *redis.Client, prefix string, burst, maxReq int, timeWindow time.Duration) *RedisRateLimiter {
+	return &RedisRateLimiter{
+		client:     client,
+		prefix:     prefix,
+		algorithm:  AlgorithmTokenBucket,
+		maxReq:     maxReq,
+		burst:      burst,
+		timeWindow: timeWindow,
+	}
+}
+
+// Stop is a no-op; the Redis client is closed by its owner.
+func (r *RedisRateLimiter) Stop() {}
+
+// Allow checks if a request for a given key is permitted.
+// If Redis is unreachable the request is allowed, so an outage of the limiter
+// backend doesn't take the whole login flow down with it.
+func (r *RedisRateLimiter) Allow(key string) model.RateLimitResult {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	now := time.Now()
+	limit := r.maxReq
+	var cmd *redis.Cmd
+	if r.algorithm == AlgorithmTokenBucket {
+		limit = r.burst
+		refillRate := float64(r.maxReq) / float64(r.timeWindow.Milliseconds())
+		cmd = tokenBucketScript.Run(ctx, r.client,
+			[]string{r.prefix + key},
+			now.UnixMilli(),
+			r.burst,
+			strconv.FormatFloat(refillRate, 'f', -1, 64),
+		)
+	} else {
+		cmd = slidingWindowScript.Run(ctx, r.client,
+			[]string{r.prefix + key},
+			now.UnixMilli(),
+			r.timeWindow.Milliseconds(),
+			r.maxReq,
+		)
+	}
+
+	reply, err := cmd.Int64Slice()
+	if err != nil || len(reply) != 4 {
+		slog.Error("Redis rate limiter failed, allowing request", "key", key, "error", err)
+		return model.RateLimitResult{Allowed: true, Limit: limit, Remaining: limit, ResetAt: now}
+	}
+
+	result := model.RateLimitResult{
+		Allowed:   reply[0] == 1,
+		Limit:     limit,
+		Remaining: int(reply[1]),
+		ResetAt:   time.UnixMilli(reply[2]),
+	}
+	if !result.Allowed {
+		slog.Warn("Rate limit exceeded", "key", key)
+		result.RetryAfter = time.UnixMilli(reply[3]).Sub(now)
+	}
+	return result
+}
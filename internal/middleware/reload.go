@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"sync"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+)
+
+// ReloadableRateLimiter forwards to a RateLimiterStore that can be replaced at runtime, so rate
+// limit settings can change without a restart. The state of an in-memory limiter doesn't carry
+// over to its replacement; Redis limiters with the same prefix share it.
+type ReloadableRateLimiter struct {
+	mu    sync.RWMutex
+	inner RateLimiterStore
+}
+
+// NewReloadableRateLimiter creates and returns a new ReloadableRateLimiter.
+func NewReloadableRateLimiter(inner RateLimiterStore) *ReloadableRateLimiter {
+	return &ReloadableRateLimiter{inner: inner}
+}
+
+// Allow checks if a request for a given key is permitted by the current limiter.
+func (r *ReloadableRateLimiter) Allow(key string) model.RateLimitResult {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.inner.Allow(key)
+}
+
+// Swap replaces the limiter and stops the previous one.
+func (r *ReloadableRateLimiter) Swap(inner RateLimiterStore) {
+	r.mu.Lock()
+	old := r.inner
+	r.inner = inner
+	r.mu.Unlock()
+	old.Stop()
+}
+
+// Stop stops the current limiter.
+func (r *ReloadableRateLimiter) Stop() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.inner.Stop()
+}
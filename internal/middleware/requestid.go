@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"github.com/ebipenman/go-otp-auth-service/internal/requestid"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ContextKeyRequestID is the key used to store the request ID in the Gin context.
+	ContextKeyRequestID = "request_id"
+)
+
+// RequestIDMiddleware adopts the X-Request-ID sent by the client (or a proxy in front of us),
+// generating one when it is missing or malformed. The ID is echoed in the response header,
+// stored in the Gin and request contexts and recorded on the current span, so client reports,
+// logs and traces can be matched up.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestid.Header)
+		if !requestid.Valid(id) {
+			id = uuid.NewString()
+		}
+
+		c.Set(ContextKeyRequestID, id)
+		c.Request = c.Request.WithContext(requestid.NewContext(c.Request.Context(), id))
+		c.Header(requestid.Header, id)
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("request.id", id))
+
+		c.Next()
+	}
+}
+
+// GetRequestID returns the ID of the current request, for inclusion in error bodies.
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(ContextKeyRequestID)
+}
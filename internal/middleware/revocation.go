@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// TokenRevocations remembers the users whose tokens issued up to some point in time must no
+// longer be accepted, e.g. because the account was deleted. Entries only need to outlive the
+// tokens they revoke, so implementations forget them after the token lifetime.
+type TokenRevocations interface {
+	// RevokeTokens revokes the user's tokens issued before the second of the given time.
+	RevokeTokens(ctx context.Context, userID uuid.UUID, before time.Time) error
+	// TokensRevokedBefore returns the time the user's tokens were last revoked up to, or the
+	// zero time if they weren't.
+	TokensRevokedBefore(ctx context.Context, userID uuid.UUID) (time.Time, error)
+}
+
+// InMemoryTokenRevocations implements TokenRevocations for a single instance.
+type InMemoryTokenRevocations struct {
+	tokenTTL time.Duration
+	revoked  map[uuid.UUID]time.Time
+	mu       sync.RWMutex
+}
+
+// NewInMemoryTokenRevocations creates revocations forgotten once tokens older than tokenTTL
+// have expired anyway.
+func NewInMemoryTokenRevocations(tokenTTL time.Duration) *InMemoryTokenRevocations {
+	return &InMemoryTokenRevocations{tokenTTL: tokenTTL, revoked: make(map[uuid.UUID]time.Time)}
+}
+
+func (r *InMemoryTokenRevocations) RevokeTokens(ctx context.Context, userID uuid.UUID, before time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Revocations are rare, so pruning the expired ones here is cheap enough.
+	expired := time.Now().Add(-r.tokenTTL)
+	for id, t := range r.revoked {
+		if t.Before(expired) {
+			delete(r.revoked, id)
+		}
+	}
+	if before.After(r.revoked[userID]) {
+		r.revoked[userID] = before
+	}
+	return nil
+}
+
+func (r *InMemoryTokenRevocations) TokensRevokedBefore(ctx context.Context, userID uuid.UUID) (time.Time, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.revoked[userID], nil
+}
+
+// RedisTokenRevocations implements TokenRevocations on top of Redis, so a revocation holds on
+// every replica.
+type RedisTokenRevocations struct {
+	client   *redis.Client
+	prefix   string
+	tokenTTL time.Duration
+}
+
+// NewRedisTokenRevocations creates revocations stored under prefix, expiring after tokenTTL.
+func NewRedisTokenRevocations(client *redis.Client, prefix string, tokenTTL time.Duration) *RedisTokenRevocations {
+	return &RedisTokenRevocations{client: client, prefix: prefix, tokenTTL: tokenTTL}
+}
+
+// revokeScript raises a revocation to a later time, never lowering it: an earlier one arriving
+// late, e.g. from a replica with a slower clock, must not bring revoked tokens back.
+//
+// KEYS[1] - the revocation key
+// ARGV[1] - time up to which tokens are revoked, in milliseconds
+// ARGV[2] - token lifetime in milliseconds
+//
+// Returns 1 if the revocation was raised, 0 if it was at or after the time already.
+var revokeScript = redis.NewScript(`
+local key = KEYS[1]
+local before = tonumber(ARGV[1])
+
+local current = tonumber(redis.call('GET', key))
+if current and current >= before then
+	return 0
+end
+redis.call('SET', key, ARGV[1], 'PX', ARGV[2])
+return 1
+`)
+
+func (r *RedisTokenRevocations) RevokeTokens(ctx context.Context, userID uuid.UUID, before time.Time) error {
+	keys := []string{r.prefix + userID.String()}
+	if err := revokeScript.Run(ctx, r.client, keys, before.UnixMilli(), r.tokenTTL.Milliseconds()).Err(); err != nil {
+		return fmt.Errorf("failed to revoke tokens: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisTokenRevocations) TokensRevokedBefore(ctx context.Context, userID uuid.UUID) (time.Time, error) {
+	value, err := r.client.Get(ctx, r.prefix+userID.String()).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid token revocation %q: %w", value, err)
+	}
+	return time.UnixMilli(millis), nil
+}
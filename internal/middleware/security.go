@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SwaggerUIContentSecurityPolicy relaxes the default policy just enough for the Swagger UI,
+// which relies on inline scripts and styles.
+const SwaggerUIContentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline'; " +
+	"style-src 'self' 'unsafe-inline'; img-src 'self' data:; frame-ancestors 'none'"
+
+// SecurityHeaders sets the usual hardening headers on every response. HSTS is only sent on
+// HTTPS requests (directly or via a proxy setting X-Forwarded-Proto) and is disabled when
+// hstsMaxAge is zero; an empty csp omits the Content-Security-Policy header.
+func SecurityHeaders(csp string, hstsMaxAge time.Duration) gin.HandlerFunc {
+	hsts := "max-age=" + strconv.FormatInt(int64(hstsMaxAge.Seconds()), 10) + "; includeSubDomains"
+
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "no-referrer")
+		if csp != "" {
+			h.Set("Content-Security-Policy", csp)
+		}
+		if hstsMaxAge > 0 && (c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https") {
+			h.Set("Strict-Transport-Security", hsts)
+		}
+		c.Next()
+	}
+}
+
+// ContentSecurityPolicy overrides the policy set by SecurityHeaders for a single route.
+func ContentSecurityPolicy(csp string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer.Header().Set("Content-Security-Policy", csp)
+		c.Next()
+	}
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Timeout creates a Gin middleware that bounds how long a request may take, so a hung database
+// or SMS provider can't hold handlers open. The deadline is set on the request context, which
+// handlers pass down to the stores and senders; once it passed, whatever the handler answers
+// is replaced by 504. Requests get the timeout of the longest route prefix in byPrefix they
+// match, e.g. "/admin", or defaultTimeout. A timeout of zero disables it, as do the exempt
+// paths (e.g. event streams).
+func Timeout(defaultTimeout time.Duration, byPrefix map[string]time.Duration, exempt ...string) gin.HandlerFunc {
+	exemptPaths := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		exemptPaths[path] = true
+	}
+
+	return func(c *gin.Context) {
+		path := c.FullPath()
+		if exemptPaths[path] {
+			c.Next()
+			return
+		}
+		timeout, longest := defaultTimeout, -1
+		for prefix, d := range byPrefix {
+			if len(prefix) > longest && matchesPrefix(path, prefix) {
+				timeout, longest = d, len(prefix)
+			}
+		}
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		w := &timeoutWriter{ResponseWriter: c.Writer, ctx: ctx}
+		c.Writer = w
+
+		c.Next()
+
+		c.Writer = w.ResponseWriter
+		if !w.timedOut {
+			return
+		}
+		logging.FromContext(ctx).Warn("Request timed out", "method", c.Request.Method, "path", c.Request.URL.Path, "timeout", timeout)
+		abortWithProblem(c, http.StatusGatewayTimeout, i18n.CodeRequestTimeout, nil)
+	}
+}
+
+// matchesPrefix reports whether the route path lies below prefix, at a segment boundary.
+func matchesPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// timeoutWriter drops what the handler writes once the deadline passed, unless the response
+// had started already.
+type timeoutWriter struct {
+	gin.ResponseWriter
+	ctx      context.Context
+	timedOut bool
+}
+
+// expired reports whether the response has to be replaced, deciding it on the first write.
+func (w *timeoutWriter) expired() bool {
+	if !w.timedOut && !w.ResponseWriter.Written() && errors.Is(w.ctx.Err(), context.DeadlineExceeded) {
+		w.timedOut = true
+	}
+	return w.timedOut
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	if !w.expired() {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	if !w.expired() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	if w.expired() {
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	if w.expired() {
+		return len(s), nil
+	}
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Registered claims a TokenPolicy can require. The subject and phone are always required.
+const (
+	ClaimExpiresAt = "exp"
+	ClaimIssuedAt  = "iat"
+	ClaimNotBefore = "nbf"
+)
+
+// TokenValidator verifies the bearer tokens of users. JWTValidator checks them locally;
+// other implementations may e.g. ask an introspection endpoint instead.
+type TokenValidator interface {
+	// ValidateToken returns the claims of the token, or an error if it must not be accepted.
+	ValidateToken(ctx context.Context, token string) (*Claims, error)
+}
+
+// TokenPolicy controls which tokens JWTValidator accepts.
+type TokenPolicy struct {
+	// ClockSkew is how far the clock of the issuer may be off when checking exp, nbf and iat.
+	ClockSkew time.Duration
+	// RequiredClaims lists the registered claims a token must carry, see ClaimExpiresAt.
+	RequiredClaims []string
+	// MaxAge rejects tokens issued longer ago than this, even if they haven't expired yet.
+	// Zero accepts tokens of any age.
+	MaxAge time.Duration
+}
+
+// JWTValidator validates HS256 tokens signed with any of the accepted secrets.
+type JWTValidator struct {
+	keys   jwt.VerificationKeySet
+	policy TokenPolicy
+	parser *jwt.Parser
+}
+
+// NewJWTValidator creates a JWTValidator accepting tokens signed with any of jwtSecrets, as
+// far as the policy allows.
+func NewJWTValidator(jwtSecrets []string, policy TokenPolicy) *JWTValidator {
+	keys := jwt.VerificationKeySet{}
+	for _, secret := range jwtSecrets {
+		keys.Keys = append(keys.Keys, []byte(secret))
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithLeeway(policy.ClockSkew),
+		// Reject tokens issued in the future too.
+		jwt.WithIssuedAt(),
+	}
+	for _, claim := range policy.RequiredClaims {
+		if claim == ClaimExpiresAt {
+			options = append(options, jwt.WithExpirationRequired())
+		}
+	}
+	return &JWTValidator{keys: keys, policy: policy, parser: jwt.NewParser(options...)}
+}
+
+// ValidateToken implements TokenValidator.
+func (v *JWTValidator) ValidateToken(ctx context.Context, token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return v.keys, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The parser only checks the claims that are present.
+	for _, claim := range v.policy.RequiredClaims {
+		switch {
+		case claim == ClaimIssuedAt && claims.IssuedAt == nil,
+			claim == ClaimNotBefore && claims.NotBefore == nil:
+			return nil, fmt.Errorf("%s claim is required", claim)
+		}
+	}
+	if v.policy.MaxAge > 0 {
+		if claims.IssuedAt == nil {
+			return nil, errors.New("iat claim is required to check the token age")
+		}
+		if time.Since(claims.IssuedAt.Time) > v.policy.MaxAge+v.policy.ClockSkew {
+			return nil, errors.New("token is too old")
+		}
+	}
+	return claims, nil
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+)
+
+// Supported rate limiting algorithms.
+const (
+	AlgorithmSlidingWindow = "sliding_window"
+	AlgorithmTokenBucket   = "token_bucket"
+)
+
+type tokenBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// TokenBucketRateLimiter implements RateLimiterStore using the token bucket algorithm.
+// Each key starts with a full bucket of `burst` tokens, every request consumes one token,
+// and tokens are refilled continuously at maxReq per timeWindow. Unlike the sliding window,
+// this tolerates short bursts (e.g. legitimate retries) while keeping the same average rate.
+type TokenBucketRateLimiter struct {
+	buckets    map[string]*tokenBucket
+	mu         sync.Mutex
+	capacity   float64
+	refillRate float64 // tokens per second
+	janitor    *janitor
+}
+
+// NewTokenBucketRateLimiter creates and returns a new TokenBucketRateLimiter.
+// burst: Bucket capacity, i.e. how many requests may be made back to back.
+// maxReq: Number of tokens refilled per time window.
+// timeWindow: The duration over which maxReq tokens are refilled.
+// cleanupInterval: How often full buckets are dropped, see DefaultCleanupInterval.
+func NewTokenBucketRateLimiter(burst, maxReq int, timeWindow, cleanupInterval time.Duration) *TokenBucketRateLimiter {
+	limiter := &TokenBucketRateLimiter{
+		buckets:    make(map[string]*tokenBucket),
+		capacity:   float64(burst),
+		refillRate: float64(maxReq) / timeWindow.Seconds(),
+	}
+
+	// Start a background goroutine to periodically drop buckets that are full again
+	limiter.janitor = startJanitor(cleanupInterval, limiter.cleanup)
+
+	return limiter
+}
+
+// Allow checks if a request for a given key is permitted.
+func (r *TokenBucketRateLimiter) Allow(key string) model.RateLimitResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := r.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: r.capacity, lastRefill: now}
+		r.buckets[key] = bucket
+	}
+	r.refill(bucket, now)
+
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	} else {
+		slog.Warn("Rate limit exceeded", "key", key)
+	}
+
+	// The bucket is full again once the missing tokens have been refilled.
+	missing := r.capacity - bucket.tokens
+	result := model.RateLimitResult{
+		Allowed:   allowed,
+		Limit:     int(r.capacity),
+		Remaining: int(math.Floor(bucket.tokens)),
+		ResetAt:   now.Add(r.refillTime(missing)),
+	}
+	if !allowed {
+		// The next request is allowed as soon as one whole token is available.
+		result.RetryAfter = r.refillTime(1 - bucket.tokens)
+	}
+	return result
+}
+
+// refillTime returns how long it takes to refill the given number of tokens.
+func (r *TokenBucketRateLimiter) refillTime(tokens float64) time.Duration {
+	return time.Duration(tokens / r.refillRate * float64(time.Second))
+}
+
+// refill adds the tokens earned since the last refill, up to the bucket capacity.
+func (r *TokenBucketRateLimiter) refill(bucket *tokenBucket, now time.Time) {
+	elapsed := now.Sub(bucket.lastRefill).Seconds()
+	bucket.tokens = math.Min(r.capacity, bucket.tokens+elapsed*r.refillRate)
+	bucket.lastRefill = now
+}
+
+// Stop ends the cleanup goroutine and waits for it to exit.
+func (r *TokenBucketRateLimiter) Stop() {
+	r.janitor.Stop()
+}
+
+// cleanup removes buckets that have refilled completely,
+// since a missing bucket behaves exactly like a full one.
+func (r *TokenBucketRateLimiter) cleanup() {
+	r.mu.Lock()
+	now := time.Now()
+	for key, bucket := range r.buckets {
+		r.refill(bucket, now)
+		if bucket.tokens >= r.capacity {
+			delete(r.buckets, key)
+		}
+	}
+	r.mu.Unlock()
+	slog.Debug("Token bucket rate limiter cleanup finished")
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRecentVerification rejects users who last verified their phone number more than
+// maxAge ago with 403 and the code phone_reverification_required, until they verify it again
+// (see POST /me/reverify). Tokens issued before verification times were recorded count as
+// stale. It must run after AuthMiddleware; requests authenticated by an API key are let
+// through. Zero maxAge lets everyone through.
+func RequireRecentVerification(maxAge time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if maxAge <= 0 || HasAPIKey(c) {
+			c.Next()
+			return
+		}
+
+		val, _ := c.Get(ContextKeyUser)
+		user, ok := val.(model.User)
+		if !ok {
+			c.Next()
+			return
+		}
+		if user.PhoneVerifiedAt == nil || time.Since(*user.PhoneVerifiedAt) > maxAge {
+			abortWithProblem(c, http.StatusForbidden, i18n.CodeReverifyPhone, nil)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// Brute-force patterns recognized in failed OTP verifications.
+const (
+	// AnomalyPhonesPerIP: one IP failing to verify many different phone numbers.
+	AnomalyPhonesPerIP = "phones_per_ip"
+	// AnomalyIPsPerPhone: many IPs failing to verify the same phone number.
+	AnomalyIPsPerPhone = "ips_per_phone"
+)
+
+// Anomaly is a detected brute-force pattern and the block imposed in response.
+type Anomaly struct {
+	Pattern      string
+	IP           string // set for AnomalyPhonesPerIP
+	PhoneNumber  string // set for AnomalyIPsPerPhone
+	Count        int    // distinct phone numbers or IPs seen within the window
+	BlockedUntil time.Time
+}
@@ -0,0 +1,54 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey authenticates a machine client. Only the SHA-256 hash of the key is stored;
+// the key itself is shown once, when it is created.
+type APIKey struct {
+	ID        uuid.UUID  `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"` // first characters of the key, to recognize it in listings
+	KeyHash   string     `json:"-"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// HasScope reports whether the key grants the scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRevoked checks if the key has been revoked.
+func (k *APIKey) IsRevoked() bool {
+	return k.RevokedAt != nil
+}
+
+// APIKeyCreateRequest is used for creating a new API key.
+type APIKeyCreateRequest struct {
+	Name   string   `json:"name" binding:"required,max=100"`
+	Scopes []string `json:"scopes" binding:"required,min=1,dive,oneof=admin otp users:read"`
+}
+
+// APIKeyCreateResponse returns the new key. It is the only time the plaintext key is available.
+type APIKeyCreateResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// ClientTokenResponse is the OAuth2 access token response of the client credentials grant.
+type ClientTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
@@ -0,0 +1,21 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeadLetter is an OTP whose delivery failed for good, after the sender's own retries. It
+// is kept until support looks into it and sends a new OTP.
+type DeadLetter struct {
+	ID          uuid.UUID `json:"id"`
+	PhoneNumber string    `json:"phone_number"`
+	// Channel is the channel the OTP was sent through, e.g. "sms".
+	Channel string `json:"channel"`
+	// Tenant is the backend that requested the OTP, if any (see ClientInfo).
+	Tenant string `json:"tenant,omitempty"`
+	// Error is what the sender failed with.
+	Error     string    `json:"error"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,56 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Device is a client a user has signed in from, recognized by its fingerprint.
+type Device struct {
+	ID          uuid.UUID `json:"id"`
+	UserID      uuid.UUID `json:"user_id"`
+	Fingerprint string    `json:"-"`
+	UserAgent   string    `json:"user_agent"`
+	LastIP      string    `json:"last_ip"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	// TrustedUntil is set while the device may sign in with its device token instead of an OTP.
+	TrustedUntil *time.Time `json:"trusted_until,omitempty"`
+	// TrustTokenHash is the SHA-256 hash of the device token; the token itself isn't stored.
+	TrustTokenHash string `json:"-"`
+}
+
+// Trusted reports whether the device may sign in with its device token at the given time.
+func (d Device) Trusted(at time.Time) bool {
+	return d.TrustedUntil != nil && at.Before(*d.TrustedUntil)
+}
+
+// DeviceToken is handed to a device the user chose to trust. With it, the device signs in
+// without an OTP until it expires.
+type DeviceToken struct {
+	Token     string    `json:"device_token"`
+	ExpiresAt time.Time `json:"device_token_expires_at"`
+}
+
+// ClientInfo describes the client sending a request, as far as the request tells.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+	DeviceID  string // optional identifier the app sends in X-Device-ID
+	// Tenant names the trusted backend sending on behalf of the user, see middleware.Tenant.
+	Tenant string
+}
+
+// Fingerprint identifies the device: the hash of the app-provided device ID when there is one,
+// of the user agent otherwise. Only the hash is stored.
+func (c ClientInfo) Fingerprint() string {
+	source := "ua:" + c.UserAgent
+	if c.DeviceID != "" {
+		source = "id:" + c.DeviceID
+	}
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
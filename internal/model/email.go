@@ -0,0 +1,26 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Purposes of email codes. A code only works for the purpose it was sent for.
+const (
+	EmailPurposeVerify   = "verify"
+	EmailPurposeRecovery = "recovery"
+)
+
+// EmailOTP is a code sent to the email address of a user, to verify the address or to sign in
+// with it when the phone number is lost. Users have at most one pending code.
+type EmailOTP struct {
+	UserID  uuid.UUID `json:"user_id"`
+	Email   string    `json:"email"`
+	Purpose string    `json:"purpose"`
+	// CodeHash is the SHA-256 hash of the code; the code itself isn't stored.
+	CodeHash  string    `json:"-"`
+	Attempts  int       `json:"attempts"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types emitted by the auth service.
+const (
+	EventUserCreated        = "user.created"
+	EventUserDeleted        = "user.deleted"
+	EventUserAnonymized     = "user.anonymized"
+	EventUserMerged         = "user.merged"
+	EventUserRestored       = "user.restored"
+	EventUserLoggedOut      = "user.logged_out"
+	EventUserBanned         = "user.banned"
+	EventUserUnbanned       = "user.unbanned"
+	EventOTPSent            = "otp.sent"
+	EventOTPRateLimited     = "otp.rate_limited"
+	EventOTPInvalidated     = "otp.invalidated"
+	EventLoginSucceeded     = "login.succeeded"
+	EventLoginFailed        = "login.failed"
+	EventNewDevice          = "login.new_device"
+	EventBruteForceDetected = "security.brute_force_detected"
+	EventSIMSwapChecked     = "security.sim_swap_checked"
+)
+
+// Event is a domain event delivered to downstream systems (e.g. via webhooks).
+type Event struct {
+	ID        uuid.UUID              `json:"id"`
+	Type      string                 `json:"type"`
+	CreatedAt time.Time              `json:"created_at"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// NewEvent creates an event of the given type with a fresh ID and timestamp.
+func NewEvent(eventType string, data map[string]interface{}) Event {
+	return Event{
+		ID:        uuid.New(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+}
@@ -0,0 +1,18 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Identity links an account at a social login provider to a user.
+type Identity struct {
+	Provider string    `json:"provider"` // "google" or "apple"
+	Subject  string    `json:"subject"`  // the provider's stable account ID, the sub claim
+	UserID   uuid.UUID `json:"user_id"`
+	// Email is the verified email address of the account, empty when the provider didn't
+	// vouch for one. Accounts of other providers with the same address link to the same user.
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Login is a sign-in attempt of a user, successful or not, kept in their login history.
+type Login struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Succeeded bool      `json:"succeeded"`
+	// Method is how the user signed in: "otp", a social login provider or "sso".
+	Method string `json:"method"`
+	// Reason tells why a failed attempt failed, e.g. "invalid_otp" or "rate_limited".
+	Reason    string `json:"reason,omitempty"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	// DeviceID is the device a successful sign-in came from, when devices are tracked.
+	DeviceID  *uuid.UUID `json:"device_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// LoginCursor marks the last login of a page of the login history; the next page continues
+// with the logins before it.
+type LoginCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserMerge is the audit record of merging one user into another, e.g. a user who registered
+// with both their old and their new phone number.
+type UserMerge struct {
+	ID uuid.UUID `json:"id"`
+	// TargetUserID is the user that was kept and received the data of the source user.
+	TargetUserID uuid.UUID `json:"target_user_id"`
+	// SourceUserID is the user that was merged and deleted.
+	SourceUserID uuid.UUID `json:"source_user_id"`
+	// SourcePhoneNumber was the primary number of the source user; it's now a secondary number
+	// of the target user. Anonymizing either user scrubs it.
+	SourcePhoneNumber string `json:"source_phone_number"`
+	// MergedBy names who merged the users, e.g. the API key.
+	MergedBy  string    `json:"merged_by"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
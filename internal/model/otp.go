@@ -13,6 +13,16 @@ type OTP struct {
 	OTPCode     string    `json:"otp_code"`
 	CreatedAt   time.Time `json:"created_at"`
 	ExpiresAt   time.Time `json:"expires_at"`
+	// Channel is the delivery channel the OTP was sent through, e.g. "sms".
+	Channel string `json:"channel"`
+	// PasswordVerified marks OTPs sent after the user entered their password. Users with a
+	// password can only sign in with those.
+	PasswordVerified bool `json:"password_verified,omitempty"`
+	// SenderID and MessagePrefix brand the message for the integrator who requested the OTP:
+	// the originator the recipient sees, where the provider supports one, and the text put
+	// before the message. They're only handed to the sender, not stored.
+	SenderID      string `json:"-"`
+	MessagePrefix string `json:"-"`
 }
 
 // IsExpired checks if the OTP has expired.
@@ -21,5 +31,8 @@ func (o *OTP) IsExpired() bool {
 }
 
 type SendOTPRequest struct {
-	PhoneNumber string `json:"phone_number" binding:"required,e164"`
+	// Any common formatting is accepted; the auth service normalizes the number to E.164.
+	PhoneNumber string `json:"phone_number" binding:"required,max=32"`
+	// Channel delivers the OTP; only the channels enabled in OTP_CHANNELS are accepted.
+	Channel string `json:"channel,omitempty" binding:"omitempty,oneof=sms whatsapp email voice"`
 }
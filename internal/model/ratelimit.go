@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// RateLimitResult describes the outcome of a rate limit check for a single key.
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int       // maximum number of requests in the window (or bucket capacity)
+	Remaining int       // requests still allowed right now
+	ResetAt   time.Time // when the full quota is available again
+	// RetryAfter is how long the client has to wait until its next request is allowed.
+	// It is zero when the request was allowed.
+	RetryAfter time.Duration
+	// Penalty counts how many times in a row the key exhausted its limit. Each penalty
+	// doubles the time the key stays blocked; zero means no penalty applies.
+	Penalty int
+}
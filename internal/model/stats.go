@@ -0,0 +1,23 @@
+package model
+
+// Daily counters kept for the admin statistics.
+const (
+	CounterVerificationSucceeded = "verification_succeeded"
+	CounterVerificationFailed    = "verification_failed"
+	CounterOTPSendRateLimited    = "otp_send_rate_limited"
+	CounterOTPVerifyRateLimited  = "otp_verify_rate_limited"
+)
+
+// DayCount is a count for one day (UTC), formatted as 2006-01-02.
+type DayCount struct {
+	Date  string `json:"date"`
+	Count int64  `json:"count"`
+}
+
+// UserGrowthDay counts the users registered on a day (UTC), formatted as 2006-01-02, and
+// the users there were at its end.
+type UserGrowthDay struct {
+	Date    string `json:"date"`
+	Signups int64  `json:"signups"`
+	Total   int64  `json:"total"`
+}
@@ -10,8 +10,32 @@ import (
 type User struct {
 	ID          uuid.UUID `json:"id"`
 	PhoneNumber string    `json:"phone_number"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	Name        string    `json:"name"`
+	Email       string    `json:"email"`
+	// EmailVerifiedAt is set once the user confirmed the email address with a code sent to it.
+	// Changing the address clears it.
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// PhoneVerifiedAt is when the user last proved they own the phone number, e.g. with an OTP.
+	PhoneVerifiedAt *time.Time `json:"phone_verified_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	// DeletedAt is only set on deleted users that can still be restored.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// BannedAt is set while an administrator bans the user from signing in.
+	BannedAt *time.Time `json:"banned_at,omitempty"`
+}
+
+// UserPhone is a phone number of a user. Besides their primary number, User.PhoneNumber, users
+// can add secondary numbers, each verified with its own OTP, and sign in with any of them.
+type UserPhone struct {
+	// ID identifies secondary numbers; the primary number has none.
+	ID          uuid.UUID `json:"id,omitzero"`
+	UserID      uuid.UUID `json:"user_id"`
+	PhoneNumber string    `json:"phone_number"`
+	Primary     bool      `json:"primary"`
+	// VerifiedAt is when the number was last verified. Users registered before this was
+	// recorded may have none for their primary number.
+	VerifiedAt *time.Time `json:"verified_at"`
 }
 
 // UserCreateRequest is used for creating a new user (implicitly during OTP login/reg).
@@ -19,18 +43,85 @@ type UserCreateRequest struct {
 	PhoneNumber string `json:"phone_number" binding:"required"`
 }
 
+// UserProfileUpdateRequest changes the profile of the authenticated user. Fields left out
+// keep their value; an empty string clears them.
+type UserProfileUpdateRequest struct {
+	Name  *string `json:"name" binding:"omitempty,max=100"`
+	Email *string `json:"email" binding:"omitempty,max=254,email|eq="`
+}
+
+// UserBatchGetRequest asks for several users at once, e.g. the subjects of many JWTs.
+type UserBatchGetRequest struct {
+	IDs []uuid.UUID `json:"ids" binding:"required,min=1,max=100"`
+}
+
+// Actions of UserBulkRequest.
+const (
+	BulkActionDelete = "delete"
+	BulkActionBan    = "ban"
+	BulkActionUnban  = "unban"
+)
+
+// UserBulkRequest applies an administrative action to many users at once.
+type UserBulkRequest struct {
+	Action  string      `json:"action" binding:"required,oneof=delete ban unban"`
+	UserIDs []uuid.UUID `json:"user_ids" binding:"required,min=1,max=1000"`
+	Reason  string      `json:"reason" binding:"max=500"`
+}
+
+// Statuses of UserBulkResult.
+const (
+	BulkStatusDone     = "done"
+	BulkStatusNotFound = "not_found"
+	BulkStatusFailed   = "failed"
+)
+
+// UserBulkResult is the outcome of a bulk action for one user.
+type UserBulkResult struct {
+	UserID uuid.UUID `json:"user_id"`
+	Status string    `json:"status"`
+}
+
+// UserBulkResponse reports the outcome of a bulk action, per user in the order of the request.
+// Failed counts the users the action wasn't done for, including those not found.
+type UserBulkResponse struct {
+	Action    string           `json:"action"`
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+	Results   []UserBulkResult `json:"results"`
+}
+
+// UserBatchGetResponse returns the users found, in the order of the request, and the IDs
+// of the others.
+type UserBatchGetResponse struct {
+	Data     []UserResponse `json:"data"`
+	NotFound []uuid.UUID    `json:"not_found"`
+}
+
 // UserResponse is a DTO for user details, possibly omitting sensitive fields.
 type UserResponse struct {
-	ID          uuid.UUID `json:"id"`
-	PhoneNumber string    `json:"phone_number"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID              uuid.UUID  `json:"id"`
+	PhoneNumber     string     `json:"phone_number"`
+	Name            string     `json:"name"`
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	PhoneVerifiedAt *time.Time `json:"phone_verified_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	BannedAt        *time.Time `json:"banned_at,omitempty"`
 }
 
 // ToUserResponse converts a User model to a UserResponse DTO.
 func (u *User) ToUserResponse() UserResponse {
 	return UserResponse{
-		ID:          u.ID,
-		PhoneNumber: u.PhoneNumber,
-		CreatedAt:   u.CreatedAt,
+		ID:              u.ID,
+		PhoneNumber:     u.PhoneNumber,
+		Name:            u.Name,
+		Email:           u.Email,
+		EmailVerifiedAt: u.EmailVerifiedAt,
+		PhoneVerifiedAt: u.PhoneVerifiedAt,
+		CreatedAt:       u.CreatedAt,
+		UpdatedAt:       u.UpdatedAt,
+		BannedAt:        u.BannedAt,
 	}
 }
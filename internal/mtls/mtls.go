@@ -0,0 +1,70 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// ServerConfig returns a TLS config that requires clients to present a certificate signed by
+// one of the CAs in caFile. When allowedSANs is not empty, the client certificate must also
+// carry one of them as a DNS name, URI (e.g. a SPIFFE ID), email address or IP address.
+func ServerConfig(certFile, keyFile, caFile string, allowedSANs []string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA bundle: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("client CA bundle contains no certificates")
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(allowedSANs) > 0 {
+		// Runs after the chain has been verified, so the handshake fails for disallowed clients.
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("no client certificate")
+			}
+			if !hasAllowedSAN(cs.PeerCertificates[0], allowedSANs) {
+				return errors.New("client certificate SAN is not allowed")
+			}
+			return nil
+		}
+	}
+	return cfg, nil
+}
+
+func hasAllowedSAN(cert *x509.Certificate, allowed []string) bool {
+	for _, name := range sans(cert) {
+		if slices.Contains(allowed, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func sans(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	names = append(names, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
@@ -0,0 +1,399 @@
+// Package openapi serves an OpenAPI 3 document of the service, generated at runtime from the
+// routes registered with Gin and the operations their handlers are described with (see
+// Describe). Schemas are derived from the Go types of the request and response bodies, so a
+// DTO change shows up in the document without further ado; a new endpoint only needs its
+// handler described. Routes without a description, e.g. the profiles, are listed with their
+// path parameters only. Every error response has the problem details schema of
+// middleware.ErrorBody.
+package openapi
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Names of the components every document has.
+const (
+	problemSchema = "Problem"
+	BearerAuth    = "BearerAuth"
+	APIKeyAuth    = "APIKeyAuth"
+)
+
+// Parameter locations.
+const (
+	InPath   = "path"
+	InQuery  = "query"
+	InHeader = "header"
+	// InForm parameters make up an application/x-www-form-urlencoded request body.
+	InForm = "formData"
+)
+
+// Operation describes what the handler of a route does and its parameters, body and responses.
+type Operation struct {
+	Summary     string
+	Description string
+	Tags        []string
+	// Security names the schemes authenticating the route, BearerAuth or APIKeyAuth.
+	Security []string
+	Params   []Param
+	Body     *Body
+	// Responses by status code. Error responses, 400 and up, have the problem details schema
+	// unless they have a Type.
+	Responses map[int]Response
+}
+
+// Param is a parameter of an operation. Path parameters are always required.
+type Param struct {
+	Name        string
+	In          string
+	Description string
+	Required    bool
+	// Type is the JSON type of the parameter, "string" if empty.
+	Type    string
+	Default any
+}
+
+// Body is the JSON request body of an operation.
+type Body struct {
+	Description string
+	Required    bool
+	// Type is a value of the body's type, e.g. loginRequest{}.
+	Type any
+}
+
+// Response is a response of an operation.
+type Response struct {
+	Description string
+	// Type is a value of the body's type, or nil for responses without a body. Interface fields,
+	// like the data of a page, are described by the type of their value.
+	Type any
+	// ContentType of the body, "application/json" if empty.
+	ContentType string
+	Headers     []Header
+}
+
+// Header is a header of a response.
+type Header struct {
+	Name        string
+	Description string
+	// Type is the JSON type of the header, "string" if empty.
+	Type string
+}
+
+var (
+	mu         sync.Mutex
+	operations = make(map[string]Operation)
+)
+
+// Describe describes the routes served by handler, a method expression like (*Handler).GetMe,
+// wherever they are registered. It's meant for a package-level declaration next to the handler:
+//
+//	var _ = openapi.Describe((*Handler).GetMe, openapi.Operation{...})
+func Describe(handler any, op Operation) Operation {
+	mu.Lock()
+	defer mu.Unlock()
+	operations[handlerName(handler)] = op
+	return op
+}
+
+// handlerName returns the name of handler. Gin reports the same for the routes registered
+// with the method value h.GetMe as for (*Handler).GetMe, plus "-fm".
+func handlerName(handler any) string {
+	return runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+}
+
+// Info describes the service at the top of the document.
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Document is an OpenAPI 3.0 document.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Servers    []server             `json:"servers,omitempty"`
+	Paths      map[string]*pathItem `json:"paths"`
+	Components components           `json:"components"`
+}
+
+type server struct {
+	URL string `json:"url"`
+}
+
+// pathItem holds the operations of a path by lowercase HTTP method.
+type pathItem map[string]*operationObject
+
+type operationObject struct {
+	Summary     string                    `json:"summary,omitempty"`
+	Description string                    `json:"description,omitempty"`
+	Tags        []string                  `json:"tags,omitempty"`
+	Parameters  []parameterObject         `json:"parameters,omitempty"`
+	RequestBody *requestBodyObject        `json:"requestBody,omitempty"`
+	Responses   map[string]responseObject `json:"responses"`
+	Security    []map[string][]string     `json:"security,omitempty"`
+}
+
+type parameterObject struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type requestBodyObject struct {
+	Description string                     `json:"description,omitempty"`
+	Required    bool                       `json:"required,omitempty"`
+	Content     map[string]mediaTypeObject `json:"content"`
+}
+
+type responseObject struct {
+	Description string                     `json:"description"`
+	Headers     map[string]headerObject    `json:"headers,omitempty"`
+	Content     map[string]mediaTypeObject `json:"content,omitempty"`
+}
+
+type headerObject struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type mediaTypeObject struct {
+	Schema *Schema `json:"schema"`
+}
+
+type components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]securityScheme `json:"securitySchemes"`
+}
+
+type securityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Name         string `json:"name,omitempty"`
+	In           string `json:"in,omitempty"`
+}
+
+// problem is the schema of the error bodies written by middleware.ErrorBody.
+var problem = &Schema{
+	Type:        "object",
+	Description: "RFC 9457 problem details, served as application/problem+json",
+	Required:    []string{"title", "status", "detail", "code", "error", "request_id"},
+	Properties: map[string]*Schema{
+		"title":       {Type: "string", Description: "Reason phrase of the status"},
+		"status":      {Type: "integer", Description: "HTTP status code"},
+		"detail":      {Type: "string", Description: "Message in the language of Accept-Language"},
+		"code":        {Type: "string", Description: "Stable machine-readable error code, e.g. invalid_otp"},
+		"error":       {Type: "string", Description: "The message again, as in the error bodies before problem details"},
+		"request_id":  {Type: "string", Description: "ID of the request, to quote when reporting problems"},
+		"details":     {Type: "string", Description: "Why the request was invalid, for some errors"},
+		"retry_after": {Type: "integer", Description: "Seconds until the request may be retried, for rate limits"},
+	},
+	AdditionalProperties: &Schema{},
+}
+
+// Build creates the document of the routes whose paths start with basePath.
+func Build(routes gin.RoutesInfo, basePath string, info Info) (doc *Document, err error) {
+	// Reflecting on a type that can't be encoded as JSON, like a channel, panics.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to build OpenAPI document: %v", r)
+		}
+	}()
+
+	mu.Lock()
+	described := maps.Clone(operations)
+	mu.Unlock()
+
+	schemas := newSchemas()
+	schemas.components[problemSchema] = problem
+	doc = &Document{
+		OpenAPI: "3.0.3",
+		Info:    info,
+		Paths:   make(map[string]*pathItem),
+		Components: components{
+			Schemas: schemas.components,
+			SecuritySchemes: map[string]securityScheme{
+				BearerAuth: {Type: "http", Scheme: "bearer", BearerFormat: "JWT", Description: "JWT issued by the service"},
+				APIKeyAuth: {Type: "apiKey", Name: "X-API-Key", In: "header", Description: "API key of a machine client"},
+			},
+		},
+	}
+	if basePath != "" {
+		doc.Servers = []server{{URL: basePath}}
+	}
+
+	for _, route := range routes {
+		path, ok := servicePath(route.Path, basePath)
+		if !ok {
+			continue
+		}
+		op := schemas.operation(path, described[strings.TrimSuffix(route.Handler, "-fm")])
+
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = &pathItem{}
+			doc.Paths[path] = item
+		}
+		(*item)[strings.ToLower(route.Method)] = op
+	}
+	return doc, nil
+}
+
+// Handler serves the document of the routes as JSON. They are read on the first request,
+// once every route is registered.
+func Handler(routes func() gin.RoutesInfo, basePath string, info Info) gin.HandlerFunc {
+	var (
+		once sync.Once
+		body []byte
+		err  error
+	)
+	return func(c *gin.Context) {
+		once.Do(func() {
+			var doc *Document
+			if doc, err = Build(routes(), basePath, info); err == nil {
+				body, err = json.Marshal(doc)
+			}
+		})
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+		c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+	}
+}
+
+// operation creates the operation object of a route at path described by in.
+func (s *schemas) operation(path string, in Operation) *operationObject {
+	op := &operationObject{
+		Summary:     in.Summary,
+		Description: in.Description,
+		Tags:        in.Tags,
+		Responses:   make(map[string]responseObject, len(in.Responses)+1),
+	}
+	for _, name := range in.Security {
+		op.Security = append(op.Security, map[string][]string{name: {}})
+	}
+
+	// The path parameters are those of the route, described if the operation describes them.
+	described := make(map[string]Param)
+	var form *Schema
+	for _, p := range in.Params {
+		switch p.In {
+		case InPath:
+			described[p.Name] = p
+		case InForm:
+			if form == nil {
+				form = &Schema{Type: "object", Properties: make(map[string]*Schema)}
+			}
+			form.Properties[p.Name] = p.schema()
+			if p.Required {
+				form.Required = append(form.Required, p.Name)
+			}
+		default:
+			op.Parameters = append(op.Parameters, parameterObject{
+				Name:        p.Name,
+				In:          p.In,
+				Description: p.Description,
+				Required:    p.Required,
+				Schema:      p.schema(),
+			})
+		}
+	}
+	var pathParameters []parameterObject
+	for _, name := range pathParams(path) {
+		p := described[name]
+		pathParameters = append(pathParameters, parameterObject{
+			Name:        name,
+			In:          InPath,
+			Description: p.Description,
+			Required:    true,
+			Schema:      p.schema(),
+		})
+	}
+	op.Parameters = append(pathParameters, op.Parameters...)
+
+	if in.Body != nil {
+		op.RequestBody = &requestBodyObject{
+			Description: in.Body.Description,
+			Required:    in.Body.Required,
+			Content:     map[string]mediaTypeObject{"application/json": {Schema: s.of(in.Body.Type)}},
+		}
+	} else if form != nil {
+		op.RequestBody = &requestBodyObject{
+			Required: len(form.Required) > 0,
+			Content:  map[string]mediaTypeObject{"application/x-www-form-urlencoded": {Schema: form}},
+		}
+	}
+
+	for status, r := range in.Responses {
+		resp := responseObject{Description: cmp.Or(r.Description, http.StatusText(status))}
+		switch {
+		case r.Type != nil:
+			resp.Content = map[string]mediaTypeObject{cmp.Or(r.ContentType, "application/json"): {Schema: s.of(r.Type)}}
+		case status >= http.StatusBadRequest:
+			resp.Content = problemContent()
+		}
+		for _, h := range r.Headers {
+			if resp.Headers == nil {
+				resp.Headers = make(map[string]headerObject)
+			}
+			resp.Headers[h.Name] = headerObject{Description: h.Description, Schema: &Schema{Type: cmp.Or(h.Type, "string")}}
+		}
+		op.Responses[strconv.Itoa(status)] = resp
+	}
+	op.Responses["default"] = responseObject{Description: "Error", Content: problemContent()}
+	return op
+}
+
+// schema returns the schema of a parameter that isn't in the body.
+func (p Param) schema() *Schema {
+	return &Schema{Type: cmp.Or(p.Type, "string"), Default: p.Default}
+}
+
+func problemContent() map[string]mediaTypeObject {
+	return map[string]mediaTypeObject{"application/problem+json": {Schema: &Schema{Ref: "#/components/schemas/" + problemSchema}}}
+}
+
+// servicePath returns the OpenAPI path of a Gin route below basePath, and false for other routes
+// and catch-alls like the Swagger UI.
+func servicePath(route, basePath string) (string, bool) {
+	path, ok := strings.CutPrefix(route, basePath)
+	if !ok || (path != "" && !strings.HasPrefix(path, "/")) || strings.Contains(path, "*") {
+		return "", false
+	}
+	return openAPIPath(cmp.Or(path, "/")), true
+}
+
+var ginParam = regexp.MustCompile(`[:*]([^/]+)`)
+
+// openAPIPath turns the parameters of a Gin path, like /users/:id, into /users/{id}.
+func openAPIPath(path string) string {
+	return ginParam.ReplaceAllString(path, "{$1}")
+}
+
+var pathParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+func pathParams(path string) []string {
+	var names []string
+	for _, m := range pathParam.FindAllStringSubmatch(path, -1) {
+		names = append(names, m[1])
+	}
+	return slices.Compact(names)
+}
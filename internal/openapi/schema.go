@@ -0,0 +1,318 @@
+package openapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Schema is an OpenAPI 3.0 schema object.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Default              any                `json:"default,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+var (
+	timeType          = reflect.TypeFor[time.Time]()
+	uuidType          = reflect.TypeFor[uuid.UUID]()
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// schemas derives schemas from Go types the way encoding/json encodes them. Named structs
+// become components, referenced by their package-qualified name like model.User.
+type schemas struct {
+	components map[string]*Schema
+	names      map[reflect.Type]string
+}
+
+func newSchemas() *schemas {
+	return &schemas{components: make(map[string]*Schema), names: make(map[reflect.Type]string)}
+}
+
+// of returns the schema of the value v, whose interface fields are described by their values.
+func (s *schemas) of(v any) *Schema {
+	if v == nil {
+		return &Schema{}
+	}
+	return s.value(reflect.ValueOf(v))
+}
+
+func (s *schemas) value(v reflect.Value) *Schema {
+	t := v.Type()
+	if !hasInterface(t, nil) {
+		return s.typ(t)
+	}
+	switch t.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return s.typ(t)
+		}
+		return s.value(v.Elem())
+	case reflect.Slice, reflect.Array:
+		if v.Len() == 0 {
+			return s.typ(t)
+		}
+		return &Schema{Type: "array", Items: s.value(v.Index(0))}
+	case reflect.Struct:
+		return s.object(t, v)
+	}
+	return s.typ(t)
+}
+
+func (s *schemas) typ(t reflect.Type) *Schema {
+	switch {
+	case t.Kind() == reflect.Pointer:
+		elem := s.typ(t.Elem())
+		if elem.Ref == "" {
+			elem.Nullable = true
+		}
+		return elem
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == uuidType:
+		return &Schema{Type: "string", Format: "uuid"}
+	case t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType):
+		return &Schema{}
+	case t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType):
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return &Schema{Type: "integer"}
+	case reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Minimum: ptr(0.0)}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: s.typ(t.Elem()), Nullable: true}
+	case reflect.Array:
+		return &Schema{Type: "array", Items: s.typ(t.Elem()), MinItems: ptr(t.Len()), MaxItems: ptr(t.Len())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.typ(t.Elem())}
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Struct:
+		if t.Name() == "" || hasInterface(t, nil) {
+			return s.object(t, reflect.Value{})
+		}
+		return &Schema{Ref: "#/components/schemas/" + s.component(t)}
+	}
+	panic("openapi: unsupported type " + t.String())
+}
+
+// component registers the named struct t and returns its component name.
+func (s *schemas) component(t reflect.Type) string {
+	if name, ok := s.names[t]; ok {
+		return name
+	}
+	name := t.String()
+	for i := 2; s.components[name] != nil; i++ {
+		name = t.String() + strconv.Itoa(i)
+	}
+	// Registered before its fields are, for types that refer to themselves.
+	schema := &Schema{}
+	s.names[t] = name
+	s.components[name] = schema
+	*schema = *s.object(t, reflect.Value{})
+	return name
+}
+
+// object returns the schema of the struct t, of the value v if it's valid.
+func (s *schemas) object(t reflect.Type, v reflect.Value) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		ft := f.Type
+		var fv reflect.Value
+		if v.IsValid() {
+			fv = v.Field(i)
+		}
+
+		// Fields of embedded structs are promoted, as encoding/json does.
+		if f.Anonymous && name == "" {
+			et := ft
+			if et.Kind() == reflect.Pointer {
+				et, fv = et.Elem(), reflect.Value{}
+			}
+			if et.Kind() == reflect.Struct {
+				embedded := s.object(et, fv)
+				for n, p := range embedded.Properties {
+					if _, ok := schema.Properties[n]; !ok {
+						schema.Properties[n] = p
+					}
+				}
+				schema.Required = append(schema.Required, embedded.Required...)
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		var field *Schema
+		switch {
+		case hasOption(opts, "string"):
+			field = &Schema{Type: "string"}
+		case fv.IsValid():
+			field = s.value(fv)
+		default:
+			field = s.typ(ft)
+		}
+		if constrain(field, f.Tag.Get("binding")) {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = field
+	}
+	return schema
+}
+
+// constrain adds the validations of a binding tag to schema and reports whether it's required.
+// Validations after dive apply to the items of an array.
+func constrain(schema *Schema, binding string) (required bool) {
+	target := schema
+	for rule := range strings.SplitSeq(binding, ",") {
+		if rule == "required" && target == schema {
+			required = true
+			continue
+		}
+		// A referenced schema is shared, so it isn't constrained.
+		if target == nil || target.Ref != "" {
+			continue
+		}
+		name, param, _ := strings.Cut(rule, "=")
+		n, numErr := strconv.Atoi(param)
+		switch name {
+		case "dive":
+			target = target.Items
+		case "oneof":
+			for value := range strings.FieldsSeq(param) {
+				if target.Type == "integer" {
+					if n, err := strconv.Atoi(value); err == nil {
+						target.Enum = append(target.Enum, n)
+					}
+					continue
+				}
+				target.Enum = append(target.Enum, value)
+			}
+		case "email":
+			target.Format = "email"
+		case "uuid":
+			target.Format = "uuid"
+		case "url", "uri":
+			target.Format = "uri"
+		case "numeric":
+			target.Pattern = "^[0-9]+$"
+		case "len", "min", "max", "gte", "lte":
+			if numErr != nil {
+				continue
+			}
+			lower, upper := name != "max" && name != "lte", name != "min" && name != "gte"
+			switch target.Type {
+			case "string":
+				if lower {
+					target.MinLength = ptr(n)
+				}
+				if upper {
+					target.MaxLength = ptr(n)
+				}
+			case "array":
+				if lower {
+					target.MinItems = ptr(n)
+				}
+				if upper {
+					target.MaxItems = ptr(n)
+				}
+			case "integer", "number":
+				if lower {
+					target.Minimum = ptr(float64(n))
+				}
+				if upper {
+					target.Maximum = ptr(float64(n))
+				}
+			}
+		}
+	}
+	return required
+}
+
+// hasInterface reports whether values of t can hold interfaces, whose schema depends on the
+// value they hold.
+func hasInterface(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		return !t.Implements(jsonMarshalerType)
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		return hasInterface(t.Elem(), seen)
+	case reflect.Struct:
+		if t == timeType || t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType) {
+			return false
+		}
+		if seen == nil {
+			seen = make(map[reflect.Type]bool)
+		}
+		seen[t] = true
+		for i := range t.NumField() {
+			if f := t.Field(i); (f.IsExported() || f.Anonymous) && f.Tag.Get("json") != "-" && hasInterface(f.Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasOption(opts, option string) bool {
+	for opt := range strings.SplitSeq(opts, ",") {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,55 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	swaggerFiles "github.com/swaggo/files"
+)
+
+// uiInitializer is the script starting Swagger UI, with the document at specURL.
+const uiInitializer = `window.onload = function() {
+  window.ui = SwaggerUIBundle({
+    url: %s,
+    dom_id: '#swagger-ui',
+    deepLinking: true,
+    presets: [
+      SwaggerUIBundle.presets.apis,
+      SwaggerUIStandalonePreset
+    ],
+    plugins: [
+      SwaggerUIBundle.plugins.DownloadUrl
+    ],
+    layout: "StandaloneLayout"
+  });
+};
+`
+
+// SwaggerUI serves Swagger UI showing the document at specURL. It's mounted on a catch-all
+// route whose parameter is named any, like /swagger/*any.
+func SwaggerUI(specURL string) gin.HandlerFunc {
+	initializer := fmt.Sprintf(uiInitializer, strconv.Quote(specURL))
+	files := http.FileServer(swaggerFiles.HTTP)
+	return func(c *gin.Context) {
+		switch path := c.Param("any"); path {
+		case "", "/":
+			c.Redirect(http.StatusMovedPermanently, "index.html")
+		case "/index.html":
+			// The file server would redirect it to the directory.
+			index, err := swaggerFiles.ReadFile(path)
+			if err != nil {
+				_ = c.Error(err)
+				return
+			}
+			c.Data(http.StatusOK, "text/html; charset=utf-8", index)
+		case "/swagger-initializer.js":
+			c.Data(http.StatusOK, "application/javascript; charset=utf-8", []byte(initializer))
+		default:
+			prefix := strings.TrimSuffix(c.Request.URL.Path, path)
+			http.StripPrefix(prefix, files).ServeHTTP(c.Writer, c.Request)
+		}
+	}
+}
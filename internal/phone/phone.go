@@ -0,0 +1,79 @@
+// Package phone parses, validates and normalizes phone numbers using libphonenumber's metadata,
+// so every formatting of a number maps to the same user and the same rate limit keys.
+package phone
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+// ErrInvalidNumber is returned for input that isn't a valid, dialable phone number.
+var ErrInvalidNumber = errors.New("invalid phone number")
+
+// Normalize parses the number and returns it in E.164 format (e.g. "+4917123456789").
+// Numbers without a leading "+" are read as national numbers of defaultRegion (an ISO 3166-1
+// alpha-2 code such as "DE"); with an empty defaultRegion they are rejected.
+func Normalize(raw, defaultRegion string) (string, error) {
+	if defaultRegion == "" {
+		// libphonenumber's code for "unknown region": only international numbers parse.
+		defaultRegion = "ZZ"
+	}
+
+	number, err := phonenumbers.Parse(raw, strings.ToUpper(defaultRegion))
+	if err != nil || !phonenumbers.IsValidNumber(number) {
+		return "", ErrInvalidNumber
+	}
+	return phonenumbers.Format(number, phonenumbers.E164), nil
+}
+
+// CountryCode returns the calling code of number, which must be in E.164 format, as a
+// string such as "98", or "unknown" if it can't be parsed.
+func CountryCode(number string) string {
+	parsed, err := phonenumbers.Parse(number, "ZZ")
+	if err != nil {
+		return "unknown"
+	}
+	return strconv.Itoa(int(parsed.GetCountryCode()))
+}
+
+// ValidRegion reports whether region is a region code Normalize can use as default.
+func ValidRegion(region string) bool {
+	return phonenumbers.GetSupportedRegions()[strings.ToUpper(region)]
+}
+
+// ValidCountryCode reports whether code is a calling code in use (e.g. 98 for Iran, 1 for NANP).
+func ValidCountryCode(code int) bool {
+	return phonenumbers.GetRegionCodeForCountryCode(code) != "ZZ"
+}
+
+// Policy decides how phone numbers are read and which countries OTPs may be sent to.
+type Policy struct {
+	// DefaultRegion is passed to Normalize.
+	DefaultRegion string
+	// AllowedCountryCodes, if not empty, are the only calling codes OTPs are sent to.
+	AllowedCountryCodes []int
+	// BlockedCountryCodes are calling codes OTPs are never sent to.
+	BlockedCountryCodes []int
+}
+
+// Normalize normalizes raw to E.164 using the policy's default region.
+func (p Policy) Normalize(raw string) (string, error) {
+	return Normalize(raw, p.DefaultRegion)
+}
+
+// AllowsCountry reports whether OTPs may be sent to number, which must be in E.164 format.
+func (p Policy) AllowsCountry(number string) bool {
+	parsed, err := phonenumbers.Parse(number, "ZZ")
+	if err != nil {
+		return false
+	}
+	code := int(parsed.GetCountryCode())
+	if len(p.AllowedCountryCodes) > 0 && !slices.Contains(p.AllowedCountryCodes, code) {
+		return false
+	}
+	return !slices.Contains(p.BlockedCountryCodes, code)
+}
@@ -0,0 +1,39 @@
+package requestid
+
+import (
+	"context"
+	"unicode"
+)
+
+// Header is the HTTP header carrying the request ID in both directions.
+const Header = "X-Request-ID"
+
+// maxLength bounds client-supplied IDs so they can't bloat logs and audit records.
+const maxLength = 128
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying the request ID.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the request ID stored in ctx, or an empty string outside of a request.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// Valid reports whether a client-supplied ID is safe to adopt: non-empty, at most
+// 128 characters and limited to printable ASCII without spaces.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, r := range id {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) || r == ' ' {
+			return false
+		}
+	}
+	return true
+}
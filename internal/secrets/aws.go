@@ -0,0 +1,156 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AWSCredentials are static credentials, as found in AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+// and AWS_SESSION_TOKEN.
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// AWSProvider reads a secret from AWS Secrets Manager. The secret string must be a JSON object.
+// Requests are signed with Signature Version 4.
+type AWSProvider struct {
+	region   string
+	secretID string
+	endpoint string
+	creds    AWSCredentials
+}
+
+// NewAWSProvider creates a provider for the secret in the region. endpoint overrides the
+// regional Secrets Manager endpoint (e.g. for a VPC endpoint); leave it empty otherwise.
+func NewAWSProvider(region, secretID, endpoint string, creds AWSCredentials) *AWSProvider {
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + region + ".amazonaws.com"
+	}
+	return &AWSProvider{
+		region:   region,
+		secretID: secretID,
+		endpoint: strings.TrimRight(endpoint, "/"),
+		creds:    creds,
+	}
+}
+
+func (p *AWSProvider) Name() string {
+	return "aws"
+}
+
+func (p *AWSProvider) Fetch(ctx context.Context) (map[string]string, error) {
+	payload, err := json.Marshal(map[string]string{"SecretId": p.secretID})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+"/", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	p.sign(req, payload, time.Now().UTC())
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var secret struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("failed to decode secret: %w", err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(secret.SecretString), &fields); err != nil {
+		return nil, fmt.Errorf("secret string is not a JSON object: %w", err)
+	}
+	return stringValues(fields)
+}
+
+// sign adds the Signature Version 4 headers for the secretsmanager service.
+func (p *AWSProvider) sign(req *http.Request, payload []byte, now time.Time) {
+	const service = "secretsmanager"
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	signed := []string{"content-type", "host", "x-amz-date", "x-amz-target"}
+	if p.creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", p.creds.SessionToken)
+		signed = []string{"content-type", "host", "x-amz-date", "x-amz-security-token", "x-amz-target"}
+	}
+
+	// Header names must be sorted; Go sends the host from the URL rather than the header map.
+	var canonicalHeaders strings.Builder
+	for _, name := range signed {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hashHex(payload),
+	}, "\n")
+
+	scope := date + "/" + p.region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hashHex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+p.creds.SecretAccessKey), date)
+	key = hmacSHA256(key, p.region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		p.creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalQuery encodes the query string as SigV4 expects: sorted by key, spaces as %20.
+func canonicalQuery(values url.Values) string {
+	return strings.ReplaceAll(values.Encode(), "+", "%20")
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
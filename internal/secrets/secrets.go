@@ -0,0 +1,80 @@
+// Package secrets loads configuration secrets (JWT_SECRET, DATABASE_URL, SMS provider keys, ...)
+// from an external secrets manager. A secret holds a flat object mapping environment variable
+// names to values; the values are exported into the environment before the configuration is read.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+)
+
+// Provider fetches the current secret values from a secrets manager.
+type Provider interface {
+	Name() string
+	Fetch(ctx context.Context) (map[string]string, error)
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Export fetches the secrets and sets them as environment variables, overriding values
+// that are already set. It returns the fetched values.
+func Export(ctx context.Context, provider Provider) (map[string]string, error) {
+	values, err := provider.Fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch secrets from %s: %w", provider.Name(), err)
+	}
+	for key, value := range values {
+		if err := os.Setenv(key, value); err != nil {
+			return nil, fmt.Errorf("failed to export secret %s: %w", key, err)
+		}
+	}
+	return values, nil
+}
+
+// Watch polls the provider every interval until ctx is done and calls onRotate with the names
+// of the secrets whose values differ from current. Failed polls are logged and retried on the
+// next tick; onRotate is called at most once.
+func Watch(ctx context.Context, provider Provider, interval time.Duration, current map[string]string, onRotate func(changed []string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		values, err := provider.Fetch(ctx)
+		if err != nil {
+			slog.Warn("Failed to check secrets for rotation", "provider", provider.Name(), "error", err)
+			continue
+		}
+		if changed := diff(current, values); len(changed) > 0 {
+			onRotate(changed)
+			return
+		}
+	}
+}
+
+// diff returns the sorted names of the secrets added, removed or changed between old and new.
+func diff(old, new map[string]string) []string {
+	var changed []string
+	for key, value := range new {
+		if prev, ok := old[key]; !ok || prev != value {
+			changed = append(changed, key)
+		}
+	}
+	for key := range old {
+		if _, ok := new[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
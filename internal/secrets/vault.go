@@ -0,0 +1,79 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// VaultProvider reads a secret from HashiCorp Vault's KV secrets engine (version 1 or 2),
+// authenticating with a token.
+type VaultProvider struct {
+	addr  string
+	token string
+	path  string // e.g. "secret/data/otp-auth" for KV v2
+}
+
+func NewVaultProvider(addr, token, path string) *VaultProvider {
+	return &VaultProvider{
+		addr:  strings.TrimRight(addr, "/"),
+		token: token,
+		path:  strings.Trim(path, "/"),
+	}
+}
+
+func (p *VaultProvider) Name() string {
+	return "vault"
+}
+
+func (p *VaultProvider) Fetch(ctx context.Context) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.addr+"/v1/"+p.path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("failed to decode secret: %w", err)
+	}
+
+	// KV v2 nests the values under data.data, next to data.metadata.
+	fields := secret.Data
+	if inner, ok := fields["data"]; ok && fields["metadata"] != nil {
+		fields = nil
+		if err := json.Unmarshal(inner, &fields); err != nil {
+			return nil, fmt.Errorf("failed to decode secret: %w", err)
+		}
+	}
+	return stringValues(fields)
+}
+
+// stringValues converts the fields of a secret, which must all be strings.
+func stringValues(fields map[string]json.RawMessage) (map[string]string, error) {
+	values := make(map[string]string, len(fields))
+	for key, raw := range fields {
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("secret field %s is not a string", key)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
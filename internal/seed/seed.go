@@ -0,0 +1,66 @@
+// Package seed fills a store with fake users, for load tests and demos of the admin
+// listing endpoints.
+package seed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/database"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/pkg/otp"
+	"github.com/ebipenman/go-otp-auth-service/pkg/user"
+)
+
+// DefaultPrefix starts the fake phone numbers. Area code 555 doesn't exist, so the numbers
+// can never reach a real phone.
+const DefaultPrefix = "+1555"
+
+// Options describes what Run creates.
+type Options struct {
+	Users int
+	// Prefix is followed by a seven digit counter to make up the phone numbers.
+	Prefix string
+	// OTPTTL, if positive, gives every seeded user a pending OTP valid for that long.
+	OTPTTL time.Duration
+}
+
+// Result counts what Run did. Users that already exist are skipped, so seeding twice
+// doesn't fail.
+type Result struct {
+	Created int
+	Skipped int
+	OTPs    int
+}
+
+// Run creates the fake users and, if requested, their pending OTPs.
+func Run(ctx context.Context, users user.UserStore, otps otp.OTPStore, opts Options) (Result, error) {
+	var result Result
+	generator := otp.NewSimpleOTPGenerator()
+	for i := 1; i <= opts.Users; i++ {
+		phoneNumber := fmt.Sprintf("%s%07d", opts.Prefix, i)
+		if _, err := users.CreateUser(ctx, model.User{PhoneNumber: phoneNumber}); err != nil {
+			if !errors.Is(err, database.ErrAlreadyExists) {
+				return result, fmt.Errorf("failed to create user %s: %w", phoneNumber, err)
+			}
+			result.Skipped++
+		} else {
+			result.Created++
+		}
+
+		if opts.OTPTTL > 0 {
+			err := otps.StoreOTP(ctx, model.OTP{
+				PhoneNumber: phoneNumber,
+				OTPCode:     generator.GenerateOTP(),
+				ExpiresAt:   time.Now().Add(opts.OTPTTL),
+			})
+			if err != nil {
+				return result, fmt.Errorf("failed to store the OTP of %s: %w", phoneNumber, err)
+			}
+			result.OTPs++
+		}
+	}
+	return result, nil
+}
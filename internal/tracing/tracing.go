@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/ebipenman/go-otp-auth-service"
+
+// Tracer returns the tracer used by all packages of the service.
+// Until Init is called it is a no-op tracer, so instrumented code costs next to nothing.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Init installs a global tracer provider exporting spans via OTLP over HTTP, and the
+// W3C traceparent/baggage propagators. The exporter is configured through the standard
+// OTEL_EXPORTER_OTLP_* environment variables (e.g. OTEL_EXPORTER_OTLP_ENDPOINT).
+// The returned function flushes and stops the provider.
+func Init(ctx context.Context, serviceName string) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// InitPropagation installs the W3C traceparent and baggage propagators, so incoming trace
+// context is continued even when this instance doesn't export spans itself.
+func InitPropagation() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+}
+
+// RecordError marks the span as failed with the given error. A nil error is ignored.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
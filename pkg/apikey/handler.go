@@ -0,0 +1,172 @@
+package apikey
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type Handler struct {
+	apiKeyService Service
+}
+
+func NewHandler(apiKeyService Service) *Handler {
+	return &Handler{apiKeyService: apiKeyService}
+}
+
+var _ = openapi.Describe((*Handler).CreateAPIKey, openapi.Operation{
+	Summary: "Create API key",
+	Description: "Creates an API key for a machine client. The key is only returned in this response; " +
+		"the service stores nothing but its hash. Scopes: admin, otp, users:read.",
+	Tags:     []string{"Admin"},
+	Security: []string{openapi.APIKeyAuth},
+	Body:     &openapi.Body{Description: "Name and scopes", Required: true, Type: model.APIKeyCreateRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusCreated:      {Type: model.APIKeyCreateResponse{}},
+		http.StatusBadRequest:   {Description: "Invalid request"},
+		http.StatusUnauthorized: {Description: "Invalid or revoked API key"},
+		http.StatusForbidden:    {Description: "The API key lacks the 'admin' scope"},
+	},
+})
+
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	var req model.APIKeyCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithDetails(c, apperr.ErrInvalidRequest.Wrap(err), err.Error())
+		return
+	}
+
+	apiKey, key, err := h.apiKeyService.CreateAPIKey(c.Request.Context(), req.Name, req.Scopes)
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to create API key: %w", err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, model.APIKeyCreateResponse{APIKey: apiKey, Key: key})
+}
+
+var _ = openapi.Describe((*Handler).ListAPIKeys, openapi.Operation{
+	Summary:     "List API keys",
+	Description: "Lists all API keys, including revoked ones. Keys are identified by their prefix.",
+	Tags:        []string{"Admin"},
+	Security:    []string{openapi.APIKeyAuth},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:           {Type: []model.APIKey{}},
+		http.StatusUnauthorized: {Description: "Invalid or revoked API key"},
+		http.StatusForbidden:    {Description: "The API key lacks the 'admin' scope"},
+	},
+})
+
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+	keys, err := h.apiKeyService.ListAPIKeys(c.Request.Context())
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to list API keys: %w", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+var _ = openapi.Describe((*Handler).RevokeAPIKey, openapi.Operation{
+	Summary:     "Revoke API key",
+	Description: "Revokes an API key; requests using it are rejected from then on.",
+	Tags:        []string{"Admin"},
+	Security:    []string{openapi.APIKeyAuth},
+	Params: []openapi.Param{
+		{Name: "id", In: openapi.InPath, Description: "API key ID"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusNoContent:    {},
+		http.StatusUnauthorized: {Description: "Invalid or revoked API key"},
+		http.StatusForbidden:    {Description: "The API key lacks the 'admin' scope"},
+		http.StatusNotFound:     {Description: "API key not found"},
+	},
+})
+
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.Abort(c, ErrAPIKeyNotFound.Wrap(err))
+		return
+	}
+
+	if err := h.apiKeyService.RevokeAPIKey(c.Request.Context(), id); err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to revoke API key %s: %w", id, err))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+var _ = openapi.Describe((*Handler).Token, openapi.Operation{
+	Summary: "OAuth2 token endpoint",
+	Description: "Client credentials grant for machine clients: the API key ID is the client ID and the " +
+		"key the client secret, sent with HTTP Basic authentication or in the form. The access " +
+		"token is accepted wherever the API key is, with the requested scopes (default: all " +
+		"scopes of the key). Errors follow RFC 6749.",
+	Tags: []string{"OAuth"},
+	Params: []openapi.Param{
+		{Name: "grant_type", In: openapi.InForm, Description: "client_credentials", Required: true},
+		{Name: "scope", In: openapi.InForm, Description: "Space separated scopes"},
+		{Name: "client_id", In: openapi.InForm, Description: "API key ID, unless sent with Basic authentication"},
+		{Name: "client_secret", In: openapi.InForm, Description: "API key, unless sent with Basic authentication"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:           {Type: model.ClientTokenResponse{}},
+		http.StatusBadRequest:   {Description: "unsupported_grant_type or invalid_scope", Type: map[string]string{}},
+		http.StatusUnauthorized: {Description: "invalid_client", Type: map[string]string{}},
+	},
+})
+
+func (h *Handler) Token(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	if c.PostForm("grant_type") != "client_credentials" {
+		tokenError(c, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+	clientID, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		clientID, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+
+	token, err := h.apiKeyService.IssueToken(c.Request.Context(), clientID, secret, strings.Fields(c.PostForm("scope")))
+	switch {
+	case errors.Is(err, ErrInvalidClient):
+		c.Header("WWW-Authenticate", `Basic realm="token"`)
+		tokenError(c, http.StatusUnauthorized, "invalid_client")
+		return
+	case errors.Is(err, ErrInvalidScope):
+		tokenError(c, http.StatusBadRequest, "invalid_scope")
+		return
+	case err != nil:
+		logging.FromContext(c.Request.Context()).Error("Failed to issue client token", "error", err)
+		tokenError(c, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, model.ClientTokenResponse{
+		AccessToken: token.AccessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(time.Until(token.ExpiresAt).Seconds()),
+		Scope:       strings.Join(token.Scopes, " "),
+	})
+}
+
+// tokenError writes an OAuth2 error response, which clients expect instead of the service's
+// error format.
+func tokenError(c *gin.Context, status int, code string) {
+	c.JSON(status, gin.H{"error": code})
+}
@@ -0,0 +1,56 @@
+package apikey
+
+import (
+	"context"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// Repository defines the interface for API key data operations.
+type Repository interface {
+	CreateAPIKey(ctx context.Context, key model.APIKey) (model.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (model.APIKey, error)
+	GetAPIKeyByID(ctx context.Context, id uuid.UUID) (model.APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id uuid.UUID, revokedAt time.Time) error
+}
+
+type apiKeyRepository struct {
+	store APIKeyStore // Using the internal database interface
+}
+
+func NewRepository(store APIKeyStore) Repository {
+	return &apiKeyRepository{store: store}
+}
+
+func (r *apiKeyRepository) CreateAPIKey(ctx context.Context, key model.APIKey) (model.APIKey, error) {
+	return r.store.CreateAPIKey(ctx, key)
+}
+
+func (r *apiKeyRepository) GetAPIKeyByHash(ctx context.Context, keyHash string) (model.APIKey, error) {
+	return r.store.GetAPIKeyByHash(ctx, keyHash)
+}
+
+func (r *apiKeyRepository) GetAPIKeyByID(ctx context.Context, id uuid.UUID) (model.APIKey, error) {
+	return r.store.GetAPIKeyByID(ctx, id)
+}
+
+func (r *apiKeyRepository) ListAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	return r.store.ListAPIKeys(ctx)
+}
+
+func (r *apiKeyRepository) RevokeAPIKey(ctx context.Context, id uuid.UUID, revokedAt time.Time) error {
+	return r.store.RevokeAPIKey(ctx, id, revokedAt)
+}
+
+// APIKeyStore is the interface that the database implementation must satisfy.
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, key model.APIKey) (model.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (model.APIKey, error)
+	GetAPIKeyByID(ctx context.Context, id uuid.UUID) (model.APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id uuid.UUID, revokedAt time.Time) error
+}
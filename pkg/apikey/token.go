@@ -0,0 +1,131 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/database"
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/tracing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// ClientTokenTTL is how long access tokens of the client credentials grant are valid.
+const ClientTokenTTL = time.Hour
+
+// clientSubjectPrefix keeps client tokens from passing for user tokens, whose subject is a
+// user ID.
+const clientSubjectPrefix = "client:"
+
+// ErrInvalidClient and ErrInvalidScope are answered by Token in the error format of RFC 6749,
+// which clients of the grant expect, so they aren't domain errors.
+var (
+	ErrInvalidClient = errors.New("invalid client credentials")
+	ErrInvalidScope  = errors.New("scope not granted to the client")
+	ErrInvalidToken  = apperr.New(http.StatusUnauthorized, i18n.CodeInvalidAPIKey)
+)
+
+// ClientToken is an access token issued by the client credentials grant.
+type ClientToken struct {
+	AccessToken string
+	Scopes      []string
+	ExpiresAt   time.Time
+}
+
+// IssueToken implements the OAuth2 client credentials grant with API keys as the clients:
+// the key ID is the client ID and the key is its secret. Without scopes the token gets all
+// scopes of the key, otherwise the key must grant each of them.
+func (s *apiKeyService) IssueToken(ctx context.Context, clientID, secret string, scopes []string) (ClientToken, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "apikey.IssueToken")
+	defer span.End()
+
+	apiKey, err := s.Authenticate(ctx, secret)
+	if errors.Is(err, ErrInvalidAPIKey) || (err == nil && apiKey.ID.String() != clientID) {
+		return ClientToken{}, ErrInvalidClient
+	}
+	if err != nil {
+		return ClientToken{}, err
+	}
+
+	if len(scopes) == 0 {
+		scopes = apiKey.Scopes
+	}
+	for _, scope := range scopes {
+		if !apiKey.HasScope(scope) {
+			return ClientToken{}, ErrInvalidScope
+		}
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ClientTokenTTL)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":       clientSubjectPrefix + apiKey.ID.String(),
+		"client_id": apiKey.ID.String(),
+		"scope":     strings.Join(scopes, " "),
+		"iat":       now.Unix(),
+		"exp":       expiresAt.Unix(),
+	})
+	signed, err := token.SignedString([]byte(s.jwtSecrets[0]))
+	if err != nil {
+		tracing.RecordError(span, err)
+		return ClientToken{}, err
+	}
+	return ClientToken{AccessToken: signed, Scopes: scopes, ExpiresAt: expiresAt}, nil
+}
+
+// AuthenticateToken verifies an access token issued by IssueToken and returns the key it
+// was issued to, limited to the scopes of the token the key still grants. Tokens of revoked
+// keys are rejected.
+func (s *apiKeyService) AuthenticateToken(ctx context.Context, token string) (model.APIKey, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "apikey.AuthenticateToken")
+	defer span.End()
+
+	keys := jwt.VerificationKeySet{}
+	for _, secret := range s.jwtSecrets {
+		keys.Keys = append(keys.Keys, []byte(secret))
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return keys, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return model.APIKey{}, ErrInvalidToken
+	}
+
+	clientID, _ := claims["client_id"].(string)
+	id, err := uuid.Parse(clientID)
+	if err != nil {
+		return model.APIKey{}, ErrInvalidToken
+	}
+	if sub, _ := claims.GetSubject(); sub != clientSubjectPrefix+clientID {
+		return model.APIKey{}, ErrInvalidToken
+	}
+
+	apiKey, err := s.repo.GetAPIKeyByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return model.APIKey{}, ErrInvalidToken
+		}
+		tracing.RecordError(span, err)
+		return model.APIKey{}, err
+	}
+	if apiKey.IsRevoked() {
+		return model.APIKey{}, ErrInvalidToken
+	}
+
+	// The key may have lost scopes since the token was issued.
+	granted := apiKey.Scopes
+	scope, _ := claims["scope"].(string)
+	apiKey.Scopes = slices.DeleteFunc(slices.Collect(strings.FieldsSeq(scope)), func(scope string) bool {
+		return !slices.Contains(granted, scope)
+	})
+	return apiKey, nil
+}
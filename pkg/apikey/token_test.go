@@ -0,0 +1,89 @@
+package apikey_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/database"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/pkg/apikey"
+
+	"github.com/google/uuid"
+)
+
+// narrowedRepository returns the keys with the scopes of scopes, as if an operator had
+// taken the others away.
+type narrowedRepository struct {
+	apikey.Repository
+	scopes []string
+}
+
+func (r *narrowedRepository) GetAPIKeyByID(ctx context.Context, id uuid.UUID) (model.APIKey, error) {
+	key, err := r.Repository.GetAPIKeyByID(ctx, id)
+	key.Scopes = r.scopes
+	return key, err
+}
+
+// issue creates a key with scopes and a token for it with all of them.
+func issue(t *testing.T, service apikey.Service, scopes ...string) (model.APIKey, string) {
+	t.Helper()
+	ctx := context.Background()
+	key, secret, err := service.CreateAPIKey(ctx, "billing", scopes)
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	token, err := service.IssueToken(ctx, key.ID.String(), secret, nil)
+	if err != nil {
+		t.Fatalf("IssueToken: %v", err)
+	}
+	return key, token.AccessToken
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	ctx := context.Background()
+	service := apikey.NewService(apikey.NewRepository(database.NewInMemoryAPIKeyStore()), []string{"token-test-secret"})
+	key, token := issue(t, service, apikey.ScopeOTP, apikey.ScopeUsersRead)
+
+	got, err := service.AuthenticateToken(ctx, token)
+	if err != nil {
+		t.Fatalf("AuthenticateToken: %v", err)
+	}
+	if got.ID != key.ID || !slices.Equal(got.Scopes, []string{apikey.ScopeOTP, apikey.ScopeUsersRead}) {
+		t.Errorf("AuthenticateToken = key %s with scopes %v, want %s with %v", got.ID, got.Scopes, key.ID, key.Scopes)
+	}
+
+	if _, err := service.AuthenticateToken(ctx, token+"x"); !errors.Is(err, apikey.ErrInvalidToken) {
+		t.Errorf("AuthenticateToken with a bad signature: %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestAuthenticateTokenRevokedKey(t *testing.T) {
+	ctx := context.Background()
+	service := apikey.NewService(apikey.NewRepository(database.NewInMemoryAPIKeyStore()), []string{"token-test-secret"})
+	key, token := issue(t, service, apikey.ScopeOTP)
+
+	if err := service.RevokeAPIKey(ctx, key.ID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	if _, err := service.AuthenticateToken(ctx, token); !errors.Is(err, apikey.ErrInvalidToken) {
+		t.Errorf("AuthenticateToken after revoking the key: %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestAuthenticateTokenNarrowedKey(t *testing.T) {
+	ctx := context.Background()
+	repo := &narrowedRepository{Repository: apikey.NewRepository(database.NewInMemoryAPIKeyStore())}
+	service := apikey.NewService(repo, []string{"token-test-secret"})
+	_, token := issue(t, service, apikey.ScopeOTP, apikey.ScopeUsersRead)
+
+	repo.scopes = []string{apikey.ScopeUsersRead}
+	got, err := service.AuthenticateToken(ctx, token)
+	if err != nil {
+		t.Fatalf("AuthenticateToken: %v", err)
+	}
+	if !slices.Equal(got.Scopes, []string{apikey.ScopeUsersRead}) {
+		t.Errorf("scopes = %v after the key lost %s, want [%s]", got.Scopes, apikey.ScopeOTP, apikey.ScopeUsersRead)
+	}
+}
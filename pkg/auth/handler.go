@@ -2,11 +2,19 @@ package auth
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"time"
 
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
 	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+	"github.com/ebipenman/go-otp-auth-service/pkg/otp"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 type Handler struct {
@@ -18,81 +26,760 @@ func NewHandler(authService Service) *Handler {
 }
 
 type verifyOTPRequest struct {
-	PhoneNumber string `json:"phone_number" binding:"required,e164"`
+	PhoneNumber string `json:"phone_number" binding:"required,max=32"`
 	OTP         string `json:"otp" binding:"required,len=6,numeric"`
+	// RememberDevice asks for a device token, to sign in from this device without an OTP.
+	RememberDevice bool `json:"remember_device"`
+	// Restore answers the account_restorable error: true restores the deleted account of the
+	// phone number, false registers a new one.
+	Restore *bool `json:"restore,omitempty"`
 }
 
-// @Summary Send OTP
-// @Description Sends an OTP to the provided phone number for login or registration.
-// @Description Rate limit: 3 requests per phone number within 10 minutes.
-// @Tags Authentication
-// @Accept json
-// @Produce json
-// @Param body body model.SendOTPRequest true "Phone Number"
-// @Success 200 {object} map[string]string "message: OTP sent successfully (check console)"
-// @Failure 400 {object} map[string]string "error: Invalid phone number format"
-// @Failure 429 {object} map[string]string "error: Rate limit exceeded"
-// @Failure 500 {object} map[string]string "error: Failed to process OTP request"
-// @Router /otp/send [post]
+type deviceLoginRequest struct {
+	PhoneNumber string `json:"phone_number" binding:"required,max=32"`
+	DeviceToken string `json:"device_token" binding:"required,max=64"`
+}
+
+type deleteAccountRequest struct {
+	OTP string `json:"otp" binding:"required,len=6,numeric"`
+}
+
+type reverifyPhoneRequest struct {
+	OTP string `json:"otp" binding:"required,len=6,numeric"`
+}
+
+type addPhoneRequest struct {
+	PhoneNumber string `json:"phone_number" binding:"required,max=32"`
+}
+
+type verifyPhoneRequest struct {
+	PhoneNumber string `json:"phone_number" binding:"required,max=32"`
+	OTP         string `json:"otp" binding:"required,len=6,numeric"`
+}
+
+type sendPasswordOTPRequest struct {
+	PhoneNumber string `json:"phone_number" binding:"required,max=32"`
+	Password    string `json:"password" binding:"required,max=128"`
+}
+
+type setPasswordRequest struct {
+	// CurrentPassword is required to change an existing password.
+	CurrentPassword string `json:"current_password" binding:"max=128"`
+	NewPassword     string `json:"new_password" binding:"required,min=8,max=128"`
+}
+
+var _ = openapi.Describe((*Handler).SendOTP, openapi.Operation{
+	Summary: "Send OTP",
+	Description: "Sends an OTP to the provided phone number for login or registration. " +
+		"The OTP goes by SMS unless another channel enabled in OTP_CHANNELS is requested. The email channel " +
+		"sends it to the verified email address of the phone number's user. " +
+		"Rate limit: OTP_SEND_MAX requests per phone number within OTP_SEND_WINDOW (default 3 per 2 minutes). " +
+		"Each time the limit is exhausted the number is blocked twice as long as before, up to OTP_SEND_MAX_PENALTY.",
+	Tags: []string{"Authentication"},
+	Body: &openapi.Body{Description: "Phone Number", Required: true, Type: model.SendOTPRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusOK: {Description: "message: OTP sent successfully (check console), delivery_id and status_url: the stream of the delivery statuses, see /otp/deliveries/{id}/events, and poll_url: the current status, see /otp/deliveries/{id}", Type: map[string]string{}, Headers: []openapi.Header{
+			{Name: "X-RateLimit-Limit", Description: "Maximum number of OTP requests in the window", Type: "integer"},
+			{Name: "X-RateLimit-Remaining", Description: "OTP requests left in the current window", Type: "integer"},
+			{Name: "X-RateLimit-Reset", Description: "Unix time (seconds) at which the full quota is restored", Type: "integer"},
+		}},
+		http.StatusBadRequest: {Description: "Invalid phone number format, or the channel is not enabled"},
+		http.StatusForbidden:  {Description: "OTPs are not sent to the phone number's country (OTP_ALLOWED_COUNTRY_CODES, OTP_BLOCKED_COUNTRY_CODES), or the request was refused by the risk evaluator (code challenge_required or request_denied)"},
+		http.StatusConflict:   {Description: "The phone number can't be reached on the channel, e.g. email without a verified address"},
+		http.StatusTooManyRequests: {Description: "Rate limit exceeded, retry_after: seconds until the next request is allowed, penalty_level: times in a row the limit was exhausted; or the daily or monthly send budget is used up (code otp_quota_exceeded, no retry_after)", Headers: []openapi.Header{
+			{Name: "X-RateLimit-Limit", Description: "Maximum number of OTP requests in the window", Type: "integer"},
+			{Name: "X-RateLimit-Remaining", Description: "OTP requests left in the current window", Type: "integer"},
+			{Name: "X-RateLimit-Reset", Description: "Unix time (seconds) at which the full quota is restored", Type: "integer"},
+			{Name: "Retry-After", Description: "Seconds until the next request is allowed", Type: "integer"},
+		}},
+		http.StatusInternalServerError: {Description: "Failed to process OTP request"},
+		http.StatusBadGateway:          {Description: "The OTP could not be delivered (code otp_delivery_failed)"},
+	},
+})
+
 func (h *Handler) SendOTP(c *gin.Context) {
-	// Step 1: Retrieve the pre-bound request object from the context.
-	val, exists := c.Get("otp_request")
-	if !exists {
-		// This should not happen if the middleware is applied correctly.
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not retrieve request from context"})
+	var req model.SendOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortInvalidRequest(c, err)
 		return
 	}
 
-	// Step 2: Perform a type assertion to get the correct struct type.
-	req, ok := val.(model.SendOTPRequest)
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid request type in context"})
+	deliveryID, rateLimit, err := h.authService.SendOTPVia(c.Request.Context(), req.PhoneNumber, req.Channel, clientInfo(c))
+	respondOTPSent(c, deliveryID, rateLimit, err)
+}
+
+var _ = openapi.Describe((*Handler).SendPasswordOTP, openapi.Operation{
+	Summary: "Send OTP after checking the password",
+	Description: "First step of the password login, required for users who set a password: checks the password and " +
+		"then sends an OTP like /otp/send, to be verified on /otp/verify as usual. OTPs from /otp/send don't " +
+		"sign in users with a password (401 password_required). Wrong passwords, unknown numbers and users " +
+		"without a password all answer 401 invalid_credentials; attempts are limited like /otp/verify.",
+	Tags: []string{"Authentication"},
+	Body: &openapi.Body{Description: "Phone number and password", Required: true, Type: sendPasswordOTPRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Description: "message: OTP sent successfully (check console), delivery_id and status_url: the stream of the delivery statuses, and poll_url: the current status", Type: map[string]string{}},
+		http.StatusBadRequest:          {Description: "Invalid phone number format"},
+		http.StatusUnauthorized:        {Description: "Invalid phone number or password"},
+		http.StatusForbidden:           {Description: "Country not allowed, or refused by the risk evaluator"},
+		http.StatusTooManyRequests:     {Description: "Too many password attempts (verify_rate_limited) or OTP requests (otp_rate_limited), retry_after: seconds until the next request is allowed"},
+		http.StatusInternalServerError: {Description: "Failed to process OTP request"},
+		http.StatusBadGateway:          {Description: "The OTP could not be delivered (code otp_delivery_failed)"},
+	},
+})
+
+func (h *Handler) SendPasswordOTP(c *gin.Context) {
+	var req sendPasswordOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortInvalidRequest(c, err)
 		return
 	}
 
-	// Step 3: The rest of the handler logic remains the same.
-	err := h.authService.SendOTP(req.PhoneNumber)
+	deliveryID, rateLimit, err := h.authService.SendPasswordOTP(c.Request.Context(), req.PhoneNumber, req.Password, clientInfo(c))
+	respondOTPSent(c, deliveryID, rateLimit, err)
+}
+
+// respondOTPSent answers a request that sent an OTP, or failed to.
+func respondOTPSent(c *gin.Context, deliveryID uuid.UUID, rateLimit model.RateLimitResult, err error) {
+	setRateLimitHeaders(c, rateLimit)
 	if err != nil {
-		if errors.Is(err, ErrRateLimitExceeded) {
-			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
-			return
+		middleware.Abort(c, err)
+		return
+	}
+
+	body := gin.H{"message": "OTP sent successfully (check console)"}
+	if deliveryID != uuid.Nil {
+		body["delivery_id"] = deliveryID
+		body["status_url"] = middleware.Path(c, "/otp/deliveries/"+deliveryID.String()+"/events")
+		body["poll_url"] = middleware.Path(c, "/otp/deliveries/"+deliveryID.String())
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// deliveryResponse is the current status of an OTP delivery and the statuses before it.
+type deliveryResponse struct {
+	ID uuid.UUID `json:"id"`
+	otp.DeliveryStatus
+	History []otp.DeliveryStatus `json:"history"`
+}
+
+var _ = openapi.Describe((*Handler).GetDelivery, openapi.Operation{
+	Summary: "Get the delivery status of an OTP",
+	Description: "Returns the current delivery status of an OTP (queued, sent, delivered or failed) with the statuses it " +
+		"went through, for clients that poll instead of watching /otp/deliveries/{id}/events. OTPs are queued " +
+		"until a worker sends them when OTP_DISPATCH_WORKERS is set; then a failed send only shows here. " +
+		"Deliveries can be looked up until the OTP expires, on the instance that sent it.",
+	Tags: []string{"Authentication"},
+	Params: []openapi.Param{
+		{Name: "id", In: openapi.InPath, Description: "Delivery ID, returned by /otp/send"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:         {Type: deliveryResponse{}},
+		http.StatusBadRequest: {Description: "Invalid delivery ID"},
+		http.StatusNotFound:   {Description: "Delivery not found or expired"},
+	},
+})
+
+func (h *Handler) GetDelivery(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.Abort(c, apperr.ErrInvalidDeliveryID)
+		return
+	}
+	history, _, stop, err := h.authService.WatchDelivery(id)
+	if err != nil {
+		middleware.Abort(c, err)
+		return
+	}
+	stop()
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, deliveryResponse{ID: id, DeliveryStatus: history[len(history)-1], History: history})
+}
+
+var _ = openapi.Describe((*Handler).InvalidateOTP, openapi.Operation{
+	Summary: "Invalidate the pending OTP of a phone number",
+	Description: "Deletes the pending OTP of a phone number, e.g. one that got stuck or was leaked, so it can no longer " +
+		"be verified; the user can request a new one. An otp.invalidated event names the API key and reason.",
+	Tags:     []string{"Admin"},
+	Security: []string{openapi.APIKeyAuth},
+	Params: []openapi.Param{
+		{Name: "phone", In: openapi.InPath, Description: "Phone number, e.g. +989121234567"},
+		{Name: "reason", In: openapi.InQuery, Description: "Why the OTP is invalidated"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusNoContent:           {Description: "OTP invalidated"},
+		http.StatusBadRequest:          {Description: "Invalid phone number"},
+		http.StatusUnauthorized:        {Description: "API key required"},
+		http.StatusNotFound:            {Description: "No OTP pending"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) InvalidateOTP(c *gin.Context) {
+	// The admin routes always authenticate an API key; it's named in the event.
+	var invalidatedBy string
+	if val, ok := c.Get(middleware.ContextKeyAPIKey); ok {
+		if key, ok := val.(model.APIKey); ok {
+			invalidatedBy = "api-key:" + key.ID.String()
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+
+	phoneNumber := c.Param("phone")
+	if err := h.authService.InvalidateOTP(c.Request.Context(), phoneNumber, invalidatedBy, c.Query("reason")); err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to invalidate OTP of %s: %w", phoneNumber, err))
 		return
 	}
+	c.Status(http.StatusNoContent)
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "OTP sent successfully (check console)"})
+type forceLogoutRequest struct {
+	Reason string `json:"reason" binding:"max=500"`
 }
 
-// @Summary Verify OTP and Login/Register
-// @Description Submits a phone number and OTP to get a JWT token.
-// @Description If the user doesn't exist, they will be registered.
-// @Tags Authentication
-// @Accept json
-// @Produce json
-// @Param body body verifyOTPRequest true "Phone Number and OTP"
-// @Success 200 {object} map[string]string "token: <jwt_token>"
-// @Failure 400 {object} map[string]string "error: Invalid request format"
-// @Failure 401 {object} map[string]string "error: Invalid or expired OTP"
-// @Failure 500 {object} map[string]string "error: Internal server error"
-// @Router /otp/verify [post]
-func (h *Handler) VerifyOTP(c *gin.Context) {
-	var req verifyOTPRequest
+var _ = openapi.Describe((*Handler).ForceLogout, openapi.Operation{
+	Summary: "Log a user out everywhere",
+	Description: "Revokes every token issued to the user so far and ends the trust of their devices, e.g. when the " +
+		"account is compromised; the user has to verify an OTP to sign in again. A user.logged_out event " +
+		"names the API key and reason. The body is optional.",
+	Tags:     []string{"Admin"},
+	Security: []string{openapi.APIKeyAuth},
+	Params: []openapi.Param{
+		{Name: "id", In: openapi.InPath, Description: "User ID"},
+	},
+	Body: &openapi.Body{Description: "Why the user is logged out", Type: forceLogoutRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusNoContent:           {Description: "User logged out"},
+		http.StatusBadRequest:          {Description: "Invalid user ID or request format"},
+		http.StatusUnauthorized:        {Description: "API key required"},
+		http.StatusNotFound:            {Description: "User not found"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) ForceLogout(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.Abort(c, apperr.ErrInvalidUserID)
+		return
+	}
+	var req forceLogoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		abortInvalidRequest(c, err)
+		return
+	}
+
+	// The admin routes always authenticate an API key; it's named in the event.
+	var loggedOutBy string
+	if val, ok := c.Get(middleware.ContextKeyAPIKey); ok {
+		if key, ok := val.(model.APIKey); ok {
+			loggedOutBy = "api-key:" + key.ID.String()
+		}
+	}
+
+	if err := h.authService.ForceLogout(c.Request.Context(), id, loggedOutBy, req.Reason); err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to log user %s out: %w", id, err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+var _ = openapi.Describe((*Handler).BulkUpdateUsers, openapi.Operation{
+	Summary: "Delete, ban or unban many users",
+	Description: "Applies the action to up to 1000 users, in batches of 100 updated in one transaction each, and reports " +
+		"the outcome per user in the order of the request: done, not_found (unknown or deleted users) or failed " +
+		"(the batch failed; repeating the request is safe). Banned users can't sign in (403 account_banned) and " +
+		"are logged out everywhere; deleted users can restore their account within the grace period, like after " +
+		"deleting it themselves. A user.deleted, user.banned or user.unbanned event names the API key and reason.",
+	Tags:     []string{"Admin"},
+	Security: []string{openapi.APIKeyAuth},
+	Body:     &openapi.Body{Description: "Action, user IDs and reason", Required: true, Type: model.UserBulkRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Type: model.UserBulkResponse{}},
+		http.StatusBadRequest:          {Description: "Invalid request format"},
+		http.StatusUnauthorized:        {Description: "API key required"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) BulkUpdateUsers(c *gin.Context) {
+	var req model.UserBulkRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		abortInvalidRequest(c, err)
 		return
 	}
 
-	token, err := h.authService.VerifyOTPAndAuthenticate(req.PhoneNumber, req.OTP)
+	// The admin routes always authenticate an API key; it's named in the events.
+	var actor string
+	if val, ok := c.Get(middleware.ContextKeyAPIKey); ok {
+		if key, ok := val.(model.APIKey); ok {
+			actor = "api-key:" + key.ID.String()
+		}
+	}
+
+	results, err := h.authService.BulkUpdateUsers(c.Request.Context(), req.Action, req.UserIDs, actor, req.Reason)
 	if err != nil {
-		if errors.Is(err, ErrInvalidOTP) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		middleware.Abort(c, fmt.Errorf("failed to apply bulk action %s: %w", req.Action, err))
+		return
+	}
+
+	resp := model.UserBulkResponse{Action: req.Action, Results: results}
+	for _, result := range results {
+		if result.Status == model.BulkStatusDone {
+			resp.Succeeded++
+		} else {
+			resp.Failed++
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// deliveryKeepAlive is how often an idle status stream gets a comment, so proxies keep it open.
+const deliveryKeepAlive = 15 * time.Second
+
+var _ = openapi.Describe((*Handler).WatchDelivery, openapi.Operation{
+	Summary: "Watch the delivery of an OTP",
+	Description: "Streams the delivery statuses of an OTP as server-sent events named \"status\", with a JSON object of status (queued, sent, delivered or failed), channel and at. " +
+		"The statuses reached before subscribing come first; the stream ends after delivered or failed, or after sent when the provider doesn't confirm deliveries. " +
+		"Deliveries can be watched until the OTP expires, on the instance that sent it.",
+	Tags: []string{"Authentication"},
+	Params: []openapi.Param{
+		{Name: "id", In: openapi.InPath, Description: "Delivery ID, returned by /otp/send"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:         {Description: "Stream of status events", Type: "", ContentType: "text/event-stream"},
+		http.StatusBadRequest: {Description: "Invalid delivery ID"},
+		http.StatusNotFound:   {Description: "Delivery not found or expired"},
+	},
+})
+
+func (h *Handler) WatchDelivery(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.Abort(c, apperr.ErrInvalidDeliveryID)
+		return
+	}
+	history, updates, stop, err := h.authService.WatchDelivery(id)
+	if err != nil {
+		middleware.Abort(c, err)
+		return
+	}
+	defer stop()
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	for _, status := range history {
+		c.SSEvent("status", status)
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(deliveryKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case status, ok := <-updates:
+			if !ok {
+				return
+			}
+			c.SSEvent("status", status)
+		case <-keepAlive.C:
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+		case <-c.Request.Context().Done():
 			return
 		}
-		// Other errors from the service layer are likely 500s
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Writer.Flush()
+	}
+}
+
+var _ = openapi.Describe((*Handler).VerifyOTP, openapi.Operation{
+	Summary: "Verify OTP and Login/Register",
+	Description: "Submits a phone number and OTP to get a JWT token. " +
+		"If the user doesn't exist, they will be registered. " +
+		"Rate limit: OTP_VERIFY_MAX attempts per phone number and client IP within OTP_VERIFY_WINDOW (default 5 per 10 minutes). " +
+		"With remember_device the response also carries a device_token, valid for TRUSTED_DEVICE_TTL, that signs in " +
+		"from this device through /otp/device-login without an OTP. It's left out when trusted devices are disabled. " +
+		"With a guest token (see /auth/guest) in the Authorization header, the guest is upgraded: a new user keeps the " +
+		"guest's ID. guest_upgraded is false if the phone number already had an account, which is signed in to instead. " +
+		"remember_device is ignored for guests. " +
+		"If the phone number's account was deleted less than DELETION_GRACE_PERIOD ago, the response is 409 account_restorable " +
+		"with purge_at, and the OTP stays valid: repeat the request with restore true to restore the account, or false to " +
+		"register a new one. remember_device and guest tokens are ignored when restore is set.",
+	Tags: []string{"Authentication"},
+	Params: []openapi.Param{
+		{Name: "X-Device-ID", In: openapi.InHeader, Description: "Stable identifier of the app installation, used to recognize the device"},
+		{Name: "Authorization", In: openapi.InHeader, Description: "Bearer <guest token> to upgrade the guest"},
+	},
+	Body: &openapi.Body{Description: "Phone Number and OTP", Required: true, Type: verifyOTPRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusOK: {Description: "token: <jwt_token>, with remember_device also device_token and device_token_expires_at, for guests guest_upgraded", Type: map[string]any{}, Headers: []openapi.Header{
+			{Name: "X-RateLimit-Limit", Description: "Maximum number of verification attempts in the window", Type: "integer"},
+			{Name: "X-RateLimit-Remaining", Description: "Verification attempts left in the current window", Type: "integer"},
+			{Name: "X-RateLimit-Reset", Description: "Unix time (seconds) at which the full quota is restored", Type: "integer"},
+		}},
+		http.StatusBadRequest: {Description: "Invalid request format"},
+		http.StatusUnauthorized: {Description: "Invalid or expired OTP, invalid guest token, or the user has a password and the OTP wasn't sent by /otp/send-with-password (password_required)", Headers: []openapi.Header{
+			{Name: "X-RateLimit-Limit", Description: "Maximum number of verification attempts in the window", Type: "integer"},
+			{Name: "X-RateLimit-Remaining", Description: "Verification attempts left in the current window", Type: "integer"},
+			{Name: "X-RateLimit-Reset", Description: "Unix time (seconds) at which the full quota is restored", Type: "integer"},
+		}},
+		http.StatusForbidden: {Description: "Refused by the risk evaluator (code challenge_required or request_denied)"},
+		http.StatusConflict: {Description: "The phone number's deleted account can be restored, purge_at: when it's purged", Headers: []openapi.Header{
+			{Name: "X-RateLimit-Limit", Description: "Maximum number of verification attempts in the window", Type: "integer"},
+			{Name: "X-RateLimit-Remaining", Description: "Verification attempts left in the current window", Type: "integer"},
+			{Name: "X-RateLimit-Reset", Description: "Unix time (seconds) at which the full quota is restored", Type: "integer"},
+		}},
+		http.StatusTooManyRequests: {Description: "Too many verification attempts, retry_after: seconds until the next attempt is allowed", Headers: []openapi.Header{
+			{Name: "X-RateLimit-Limit", Description: "Maximum number of verification attempts in the window", Type: "integer"},
+			{Name: "X-RateLimit-Remaining", Description: "Verification attempts left in the current window", Type: "integer"},
+			{Name: "X-RateLimit-Reset", Description: "Unix time (seconds) at which the full quota is restored", Type: "integer"},
+			{Name: "Retry-After", Description: "Seconds until the next attempt is allowed", Type: "integer"},
+		}},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) VerifyOTP(c *gin.Context) {
+	var req verifyOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortInvalidRequest(c, err)
+		return
+	}
+
+	var (
+		token       string
+		deviceToken model.DeviceToken
+		upgraded    bool
+		rateLimit   model.RateLimitResult
+		err         error
+	)
+	guestID, isGuest := middleware.GuestID(c)
+	if req.Restore != nil {
+		isGuest = false
+		token, rateLimit, err = h.authService.VerifyOTPAndRestore(c.Request.Context(), req.PhoneNumber, req.OTP, *req.Restore, clientInfo(c))
+	} else if isGuest {
+		token, upgraded, rateLimit, err = h.authService.UpgradeGuest(c.Request.Context(), guestID, req.PhoneNumber, req.OTP, clientInfo(c))
+	} else if req.RememberDevice {
+		token, deviceToken, rateLimit, err = h.authService.VerifyOTPAndTrustDevice(c.Request.Context(), req.PhoneNumber, req.OTP, clientInfo(c))
+	} else {
+		token, rateLimit, err = h.authService.VerifyOTPAndAuthenticate(c.Request.Context(), req.PhoneNumber, req.OTP, clientInfo(c))
+	}
+	setRateLimitHeaders(c, rateLimit)
+	if err != nil {
+		middleware.Abort(c, err)
+		return
+	}
+
+	resp := gin.H{"token": token}
+	if deviceToken.Token != "" {
+		resp["device_token"] = deviceToken.Token
+		resp["device_token_expires_at"] = deviceToken.ExpiresAt
+	}
+	if isGuest {
+		resp["guest_upgraded"] = upgraded
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+var _ = openapi.Describe((*Handler).CreateGuest, openapi.Operation{
+	Summary: "Start a guest session",
+	Description: "Issues a guest token for apps that allow browsing before sign-in. No phone number is verified, so " +
+		"the token is rejected by the endpoints for users. Send it along to /otp/verify to turn the guest into " +
+		"a user with the same ID. Only available with GUEST_TOKEN_TTL set; shares the per-IP limit of the OTP endpoints.",
+	Tags: []string{"Authentication"},
+	Responses: map[int]openapi.Response{
+		http.StatusCreated:             {Description: "token: <guest_jwt>, guest_id: the ID the user will have", Type: map[string]string{}},
+		http.StatusTooManyRequests:     {Description: "Too many requests from this IP"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) CreateGuest(c *gin.Context) {
+	token, guestID, err := h.authService.CreateGuest(c.Request.Context(), clientInfo(c))
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to start guest session: %w", err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"token": token, "guest_id": guestID})
+}
+
+var _ = openapi.Describe((*Handler).DeviceLogin, openapi.Operation{
+	Summary: "Login with a trusted device",
+	Description: "Signs in from a device trusted with remember_device on /otp/verify, with its device token instead " +
+		"of an OTP. The device must identify itself as it did then (same X-Device-ID, or user agent without one). " +
+		"Rate limited like /otp/verify. Users can revoke the trust through DELETE /me/devices/{id}/trust.",
+	Tags: []string{"Authentication"},
+	Params: []openapi.Param{
+		{Name: "X-Device-ID", In: openapi.InHeader, Description: "Stable identifier of the app installation, used to recognize the device"},
+	},
+	Body: &openapi.Body{Description: "Phone Number and device token", Required: true, Type: deviceLoginRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusOK: {Description: "token: <jwt_token>", Type: map[string]string{}, Headers: []openapi.Header{
+			{Name: "X-RateLimit-Limit", Description: "Maximum number of verification attempts in the window", Type: "integer"},
+			{Name: "X-RateLimit-Remaining", Description: "Verification attempts left in the current window", Type: "integer"},
+			{Name: "X-RateLimit-Reset", Description: "Unix time (seconds) at which the full quota is restored", Type: "integer"},
+		}},
+		http.StatusBadRequest: {Description: "Invalid request format"},
+		http.StatusUnauthorized: {Description: "Invalid, expired or revoked device token (code invalid_device_token)", Headers: []openapi.Header{
+			{Name: "X-RateLimit-Limit", Description: "Maximum number of verification attempts in the window", Type: "integer"},
+			{Name: "X-RateLimit-Remaining", Description: "Verification attempts left in the current window", Type: "integer"},
+			{Name: "X-RateLimit-Reset", Description: "Unix time (seconds) at which the full quota is restored", Type: "integer"},
+		}},
+		http.StatusTooManyRequests: {Description: "Too many verification attempts, retry_after: seconds until the next attempt is allowed", Headers: []openapi.Header{
+			{Name: "X-RateLimit-Limit", Description: "Maximum number of verification attempts in the window", Type: "integer"},
+			{Name: "X-RateLimit-Remaining", Description: "Verification attempts left in the current window", Type: "integer"},
+			{Name: "X-RateLimit-Reset", Description: "Unix time (seconds) at which the full quota is restored", Type: "integer"},
+			{Name: "Retry-After", Description: "Seconds until the next attempt is allowed", Type: "integer"},
+		}},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) DeviceLogin(c *gin.Context) {
+	var req deviceLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortInvalidRequest(c, err)
+		return
+	}
+
+	token, rateLimit, err := h.authService.SignInTrustedDevice(c.Request.Context(), req.PhoneNumber, req.DeviceToken, clientInfo(c))
+	setRateLimitHeaders(c, rateLimit)
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to sign in with trusted device: %w", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+var _ = openapi.Describe((*Handler).DeleteMe, openapi.Operation{
+	Summary: "Delete my account",
+	Description: "Deletes the account of the authenticated user. The deletion must be confirmed with an OTP, " +
+		"requested through /otp/send for the user's phone number; it's rate limited like /otp/verify. " +
+		"All tokens of the user are revoked and pending OTPs are purged. Signing in with the phone " +
+		"number again registers a new user.",
+	Tags:     []string{"Users"},
+	Security: []string{openapi.BearerAuth},
+	Body:     &openapi.Body{Description: "OTP sent to the user's phone number", Required: true, Type: deleteAccountRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusNoContent:           {Description: "Account deleted"},
+		http.StatusBadRequest:          {Description: "Invalid request format"},
+		http.StatusUnauthorized:        {Description: "Authorization header required, or invalid or expired OTP"},
+		http.StatusForbidden:           {Description: "Refused by the risk evaluator (code challenge_required or request_denied)"},
+		http.StatusNotFound:            {Description: "User not found"},
+		http.StatusTooManyRequests:     {Description: "Too many verification attempts, retry_after: seconds until the next attempt is allowed"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) DeleteMe(c *gin.Context) {
+	val, _ := c.Get(middleware.ContextKeyUser)
+	user, ok := val.(model.User)
+	if !ok {
+		middleware.Abort(c, apperr.ErrAuthRequired)
+		return
+	}
+
+	var req deleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortInvalidRequest(c, err)
+		return
+	}
+
+	rateLimit, err := h.authService.DeleteAccount(c.Request.Context(), user.ID, req.OTP, clientInfo(c))
+	setRateLimitHeaders(c, rateLimit)
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to delete account of user %s: %w", user.ID, err))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+var _ = openapi.Describe((*Handler).ReverifyMe, openapi.Operation{
+	Summary: "Re-verify my phone number",
+	Description: "Renews the phone verification of the authenticated user with an OTP, requested through /otp/send " +
+		"for the user's phone number; it's rate limited like /otp/verify. When PHONE_REVERIFY_DAYS is set, " +
+		"the other protected endpoints answer 403 phone_reverification_required once the last verification " +
+		"is older than that, until the user re-verifies and uses the new token returned here.",
+	Tags:     []string{"Users"},
+	Security: []string{openapi.BearerAuth},
+	Body:     &openapi.Body{Description: "OTP sent to the user's phone number", Required: true, Type: reverifyPhoneRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Description: "token: JWT carrying the new verification time", Type: map[string]string{}},
+		http.StatusBadRequest:          {Description: "Invalid request format"},
+		http.StatusUnauthorized:        {Description: "Authorization header required, or invalid or expired OTP"},
+		http.StatusForbidden:           {Description: "Refused by the risk evaluator (code challenge_required or request_denied)"},
+		http.StatusNotFound:            {Description: "User not found"},
+		http.StatusTooManyRequests:     {Description: "Too many verification attempts, retry_after: seconds until the next attempt is allowed"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) ReverifyMe(c *gin.Context) {
+	val, _ := c.Get(middleware.ContextKeyUser)
+	user, ok := val.(model.User)
+	if !ok {
+		middleware.Abort(c, apperr.ErrAuthRequired)
+		return
+	}
+
+	var req reverifyPhoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortInvalidRequest(c, err)
+		return
+	}
+
+	token, rateLimit, err := h.authService.ReverifyPhone(c.Request.Context(), user.ID, req.OTP, clientInfo(c))
+	setRateLimitHeaders(c, rateLimit)
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to re-verify phone number of user %s: %w", user.ID, err))
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"token": token})
 }
+
+var _ = openapi.Describe((*Handler).SetMyPassword, openapi.Operation{
+	Summary: "Set or change my password",
+	Description: "Sets a password (Argon2id hashed) for the authenticated user, turning on the password login: from then on, " +
+		"OTP logins need the OTP to be requested through /otp/send-with-password. Changing an existing password " +
+		"requires current_password; wrong ones are limited like /otp/verify.",
+	Tags:     []string{"Users"},
+	Security: []string{openapi.BearerAuth},
+	Body:     &openapi.Body{Description: "New password of 8 to 128 characters, and the current one to change it", Required: true, Type: setPasswordRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusNoContent:           {Description: "Password set"},
+		http.StatusBadRequest:          {Description: "Invalid request format"},
+		http.StatusUnauthorized:        {Description: "Authorization header required, or wrong current password"},
+		http.StatusNotFound:            {Description: "User not found"},
+		http.StatusTooManyRequests:     {Description: "Too many attempts, retry_after: seconds until the next attempt is allowed"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) SetMyPassword(c *gin.Context) {
+	val, _ := c.Get(middleware.ContextKeyUser)
+	user, ok := val.(model.User)
+	if !ok {
+		middleware.Abort(c, apperr.ErrAuthRequired)
+		return
+	}
+
+	var req setPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortInvalidRequest(c, err)
+		return
+	}
+
+	rateLimit, err := h.authService.SetPassword(c.Request.Context(), user.ID, req.CurrentPassword, req.NewPassword, clientInfo(c))
+	setRateLimitHeaders(c, rateLimit)
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to set password of user %s: %w", user.ID, err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+var _ = openapi.Describe((*Handler).AddMyPhone, openapi.Operation{
+	Summary: "Add a phone number",
+	Description: "Sends an OTP to a phone number the authenticated user wants to add as a secondary number, rate " +
+		"limited like /otp/send. The number is added once the OTP is confirmed on /me/phones/verify.",
+	Tags:     []string{"Users"},
+	Security: []string{openapi.BearerAuth},
+	Body:     &openapi.Body{Description: "Phone number to add", Required: true, Type: addPhoneRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Description: "message: OTP sent successfully (check console), delivery_id and status_url: the stream of the delivery statuses, and poll_url: the current status", Type: map[string]string{}},
+		http.StatusBadRequest:          {Description: "Invalid phone number format"},
+		http.StatusUnauthorized:        {Description: "Authorization header required"},
+		http.StatusForbidden:           {Description: "OTPs are not sent to the phone number's country, or the request was refused by the risk evaluator"},
+		http.StatusConflict:            {Description: "The phone number belongs to a user already"},
+		http.StatusTooManyRequests:     {Description: "Rate limit exceeded, retry_after: seconds until the next request is allowed"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+		http.StatusBadGateway:          {Description: "The OTP could not be delivered (code otp_delivery_failed)"},
+	},
+})
+
+func (h *Handler) AddMyPhone(c *gin.Context) {
+	val, _ := c.Get(middleware.ContextKeyUser)
+	user, ok := val.(model.User)
+	if !ok {
+		middleware.Abort(c, apperr.ErrAuthRequired)
+		return
+	}
+
+	var req addPhoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortInvalidRequest(c, err)
+		return
+	}
+
+	deliveryID, rateLimit, err := h.authService.AddPhoneNumber(c.Request.Context(), user.ID, req.PhoneNumber, clientInfo(c))
+	respondOTPSent(c, deliveryID, rateLimit, err)
+}
+
+var _ = openapi.Describe((*Handler).VerifyMyPhone, openapi.Operation{
+	Summary: "Verify an added phone number",
+	Description: "Adds a secondary phone number to the authenticated user with the OTP sent to it by POST /me/phones; " +
+		"it's rate limited like /otp/verify. The user can then sign in with the number.",
+	Tags:     []string{"Users"},
+	Security: []string{openapi.BearerAuth},
+	Body:     &openapi.Body{Description: "Phone number and the OTP sent to it", Required: true, Type: verifyPhoneRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusCreated:             {Type: model.UserPhone{}},
+		http.StatusBadRequest:          {Description: "Invalid request format or phone number"},
+		http.StatusUnauthorized:        {Description: "Authorization header required, or invalid or expired OTP"},
+		http.StatusForbidden:           {Description: "Refused by the risk evaluator (code challenge_required or request_denied)"},
+		http.StatusNotFound:            {Description: "User not found"},
+		http.StatusConflict:            {Description: "The phone number belongs to a user already"},
+		http.StatusTooManyRequests:     {Description: "Too many verification attempts, retry_after: seconds until the next attempt is allowed"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) VerifyMyPhone(c *gin.Context) {
+	val, _ := c.Get(middleware.ContextKeyUser)
+	user, ok := val.(model.User)
+	if !ok {
+		middleware.Abort(c, apperr.ErrAuthRequired)
+		return
+	}
+
+	var req verifyPhoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortInvalidRequest(c, err)
+		return
+	}
+
+	phone, rateLimit, err := h.authService.VerifyPhoneNumber(c.Request.Context(), user.ID, req.PhoneNumber, req.OTP, clientInfo(c))
+	setRateLimitHeaders(c, rateLimit)
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to verify phone number of user %s: %w", user.ID, err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, phone)
+}
+
+// clientInfo describes the client sending the request.
+func clientInfo(c *gin.Context) model.ClientInfo {
+	return model.ClientInfo{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		DeviceID:  c.GetHeader("X-Device-ID"),
+		Tenant:    middleware.Tenant(c),
+	}
+}
+
+// setRateLimitHeaders reports the rate limit the request counted against, unless it failed before.
+func setRateLimitHeaders(c *gin.Context, rateLimit model.RateLimitResult) {
+	if rateLimit.Limit > 0 {
+		middleware.SetRateLimitHeaders(c, rateLimit)
+	}
+}
+
+// abortInvalidRequest reports a request that failed validation. Invalid phone numbers are
+// reported by the service with their own code, since that's the mistake end users make.
+func abortInvalidRequest(c *gin.Context, err error) {
+	middleware.AbortWithDetails(c, apperr.ErrInvalidRequest.Wrap(err), err.Error())
+}
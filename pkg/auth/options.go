@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/phone"
+	"github.com/ebipenman/go-otp-auth-service/pkg/otp"
+)
+
+// defaultOTPTTL is how long an OTP stays valid unless WithOTPTTL says otherwise.
+const defaultOTPTTL = 2 * time.Minute
+
+// Option customizes the auth service built by NewService.
+type Option func(*authService)
+
+// WithOTPGenerator sets the generator of the OTP codes (six random digits by default).
+func WithOTPGenerator(generator otp.OTPGenerator) Option {
+	return func(s *authService) {
+		s.otpGenerator = generator
+	}
+}
+
+// WithSender sets how OTPs are delivered. By default they are logged without the code.
+func WithSender(sender otp.Sender) Option {
+	return func(s *authService) {
+		s.otpSender = sender
+	}
+}
+
+// WithChannelSender lets clients request OTPs through another channel than SMS, e.g.
+// otp.ChannelWhatsApp, delivered by sender. Channels without a sender aren't enabled.
+func WithChannelSender(channel string, sender otp.Sender) Option {
+	return func(s *authService) {
+		if s.channels == nil {
+			s.channels = make(map[string]otp.Sender)
+		}
+		s.channels[channel] = sender
+	}
+}
+
+// WithOTPTTL sets how long an OTP stays valid (2 minutes by default).
+func WithOTPTTL(ttl time.Duration) Option {
+	return func(s *authService) {
+		s.otpTTL = ttl
+	}
+}
+
+// WithClock sets the source of the current time, used for OTP and token expiry.
+func WithClock(now func() time.Time) Option {
+	return func(s *authService) {
+		s.now = now
+	}
+}
+
+// WithEventPublisher sets the receiver of the auth events, which are dropped otherwise.
+func WithEventPublisher(events EventPublisher) Option {
+	return func(s *authService) {
+		s.events = events
+	}
+}
+
+// WithDeviceTracker sets the tracker of the devices users sign in from. Without one,
+// devices aren't recorded.
+func WithDeviceTracker(devices DeviceTracker) Option {
+	return func(s *authService) {
+		s.devices = devices
+	}
+}
+
+// WithTrustedDevices lets users trust the device they verified an OTP on for ttl, so it signs
+// in with its device token instead. It requires a device tracker; without it, or with a ttl of
+// zero, devices aren't trusted.
+func WithTrustedDevices(ttl time.Duration) Option {
+	return func(s *authService) {
+		s.trustTTL = ttl
+	}
+}
+
+// WithGuestSessions enables guest sessions whose tokens are valid for ttl. Without it, or
+// with a ttl of zero, CreateGuest fails.
+func WithGuestSessions(ttl time.Duration) Option {
+	return func(s *authService) {
+		s.guestTTL = ttl
+	}
+}
+
+// WithDeletionGracePeriod keeps deleted accounts restorable for grace: signing in to their
+// phone number with an OTP offers to restore the account instead of registering a new one.
+// Without it, or with a grace of zero, deleted accounts can't be restored.
+func WithDeletionGracePeriod(grace time.Duration) Option {
+	return func(s *authService) {
+		s.deletionGrace = grace
+	}
+}
+
+// WithLoginRecorder sets the keeper of the users' login history. Without one, logins
+// aren't recorded.
+func WithLoginRecorder(logins LoginRecorder) Option {
+	return func(s *authService) {
+		s.logins = logins
+	}
+}
+
+// WithDispatcher makes SendOTP return once the OTP is stored, leaving the send to the
+// dispatcher, so slow providers don't hold up requests. Clients follow the delivery
+// through its status, so it takes effect only with a delivery tracker.
+func WithDispatcher(dispatcher Dispatcher) Option {
+	return func(s *authService) {
+		s.dispatcher = dispatcher
+	}
+}
+
+// WithAutoResend sends OTPs again through the fallback channel when they weren't verified
+// within after and their delivery wasn't confirmed; the scheduler runs the checks. The OTP
+// keeps its code, so the user can enter either message. The channel needs a sender, see
+// WithChannelSender. Zero doesn't re-send.
+func WithAutoResend(after time.Duration, channel string, scheduler Scheduler) Option {
+	return func(s *authService) {
+		s.resendAfter = after
+		s.resendChannel = channel
+		s.scheduler = scheduler
+	}
+}
+
+// WithDeadLetters sets where OTPs whose delivery failed for good are kept. Without it, they
+// are only logged.
+func WithDeadLetters(deadLetters DeadLetterRecorder) Option {
+	return func(s *authService) {
+		s.deadLetters = deadLetters
+	}
+}
+
+// WithFeatureFlags gates the OTP channels besides SMS with the otp_channel_<channel> flags,
+// e.g. to roll WhatsApp out to a share of the phone numbers or to some tenants. Sends through
+// a channel whose flag is off return ErrChannelNotEnabled; channels without a flag stay on.
+func WithFeatureFlags(flags FeatureFlags) Option {
+	return func(s *authService) {
+		s.flags = flags
+	}
+}
+
+// WithTokenRevoker sets where the tokens of deleted accounts are revoked. Without one, they
+// stay valid until they expire.
+func WithTokenRevoker(tokens TokenRevoker) Option {
+	return func(s *authService) {
+		s.tokens = tokens
+	}
+}
+
+// WithUserOutbox makes user.created go through a transactional outbox instead of the
+// EventPublisher, so no event is lost or published for a user that was never created.
+func WithUserOutbox(outbox UserOutbox) Option {
+	return func(s *authService) {
+		s.outbox = outbox
+	}
+}
+
+// WithDeliveryTracker sets where the delivery statuses of the OTPs are recorded for clients
+// to watch. Without one, deliveries can't be watched.
+func WithDeliveryTracker(deliveries DeliveryTracker) Option {
+	return func(s *authService) {
+		s.deliveries = deliveries
+	}
+}
+
+// WithStatsRecorder sets where verification outcomes and rate limit rejections are counted
+// for the usage statistics. Without one, they aren't counted.
+func WithStatsRecorder(stats StatsRecorder) Option {
+	return func(s *authService) {
+		s.stats = stats
+	}
+}
+
+// WithPhonePolicy sets how phone numbers are normalized and which countries OTPs are sent
+// to. By default only international numbers are accepted, from every country.
+func WithPhonePolicy(phones phone.Policy) Option {
+	return func(s *authService) {
+		s.phones.Store(&phones)
+	}
+}
+
+// WithQuota books every OTP send against the budgets the keeper enforces; sends exceeding them
+// return ErrQuotaExceeded, unless failover is set and a cheaper enabled channel still fits.
+// Without a keeper, sends aren't budgeted.
+func WithQuota(keeper QuotaKeeper, failover bool) Option {
+	return func(s *authService) {
+		s.quota = keeper
+		s.quotaFailover = failover
+	}
+}
+
+// WithBranding sets the sender ID and message prefix handed to the senders with every OTP,
+// and the ones of tenants (see model.ClientInfo) with their own branding. A tenant's empty
+// fields fall back to the defaults. Without branding, the senders use their own.
+func WithBranding(defaults otp.Branding, tenants map[string]otp.Branding) Option {
+	return func(s *authService) {
+		s.branding = defaults
+		s.tenantBrands = tenants
+	}
+}
+
+// WithRiskEvaluator sets the evaluator asked before OTPs are sent and verified. Without one,
+// every request is allowed.
+func WithRiskEvaluator(risk RiskEvaluator) Option {
+	return func(s *authService) {
+		s.risk = risk
+	}
+}
+
+// discardEvents is the EventPublisher used when none is configured.
+type discardEvents struct{}
+
+func (discardEvents) Publish(model.Event) {}
@@ -1,76 +1,245 @@
 package auth
 
 import (
+	"context"
 	"errors"
+	"net/http"
+	"time"
 
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
 	"github.com/ebipenman/go-otp-auth-service/internal/database"
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
 	"github.com/ebipenman/go-otp-auth-service/internal/model"
 	"github.com/ebipenman/go-otp-auth-service/pkg/otp"
 	"github.com/ebipenman/go-otp-auth-service/pkg/user"
+
+	"github.com/google/uuid"
 )
 
-var ErrUserNotFound = errors.New("user not found")
+var (
+	ErrUserNotFound = apperr.ErrUserNotFound
+	ErrOTPNotFound  = apperr.New(http.StatusNotFound, i18n.CodeOTPNotFound)
+)
 
 // CHANGE 1: Define a RateLimiter interface.
 // This decouples the auth repository from any specific rate limiter implementation.
-// Any struct that has an `Allow(key string) bool` method will satisfy this interface.
+// Any struct that has an `Allow(key string) model.RateLimitResult` method will satisfy this interface.
 type RateLimiter interface {
-	Allow(key string) bool
+	Allow(key string) model.RateLimitResult
+}
+
+// AttackDetector recognizes brute-force attacks spread over many phone numbers or IPs.
+type AttackDetector interface {
+	// Blocked returns until when verifications from ip or for phoneNumber are blocked.
+	Blocked(ip, phoneNumber string) time.Time
+	// RecordFailure records a failed verification and returns the attack patterns it completed.
+	RecordFailure(ip, phoneNumber string) []model.Anomaly
 }
 
 // Repository defines the interface for authentication-related data operations.
 type Repository interface {
-	GetUserByPhoneNumber(phoneNumber string) (model.User, error)
-	CreateUser(user model.User) (model.User, error)
-	StoreOTP(otp model.OTP) error
-	GetOTP(phoneNumber string) (model.OTP, error)
-	DeleteOTP(phoneNumber string) error
-	AllowOTPRate(phoneNumber string) bool
+	GetUserByID(ctx context.Context, id uuid.UUID) (model.User, error)
+	GetUserByPhoneNumber(ctx context.Context, phoneNumber string) (model.User, error)
+	AddPhoneNumber(ctx context.Context, phone model.UserPhone) (model.UserPhone, error)
+	CreateUser(ctx context.Context, user model.User) (model.User, error)
+	MarkPhoneVerified(ctx context.Context, id uuid.UUID, at time.Time) error
+	SetPasswordHash(ctx context.Context, id uuid.UUID, hash string) error
+	GetPasswordHash(ctx context.Context, id uuid.UUID) (string, error)
+	DeleteUser(ctx context.Context, id uuid.UUID) error
+	GetUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]model.User, error)
+	DeleteUsers(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error)
+	SetUsersBanned(ctx context.Context, ids []uuid.UUID, bannedAt *time.Time) ([]uuid.UUID, error)
+	GetDeletedUserByPhoneNumber(ctx context.Context, phoneNumber string, deletedAfter time.Time) (model.User, error)
+	RestoreUser(ctx context.Context, id uuid.UUID) error
+	StoreOTP(ctx context.Context, otp model.OTP) error
+	GetOTP(ctx context.Context, phoneNumber string) (model.OTP, error)
+	DeleteOTP(ctx context.Context, phoneNumber string) error
+	AllowOTPRate(channel, phoneNumber string) model.RateLimitResult
+	AllowOTPVerifyRate(key string) model.RateLimitResult
+	VerifyBlockedUntil(ip, phoneNumber string) time.Time
+	RecordVerifyFailure(ip, phoneNumber string) []model.Anomaly
 }
 
 type authRepository struct {
 	userRepo user.Repository
 	otpRepo  otp.Repository
 	// CHANGE 2: Depend on the interface, not the concrete type.
-	rateLimiter RateLimiter
+	rateLimiter         RateLimiter
+	channelRateLimiters map[string]RateLimiter
+	verifyRateLimiter   RateLimiter
+	attackDetector      AttackDetector // nil disables attack detection
 }
 
 // CHANGE 3: The function now accepts the interface.
 // This makes it more flexible and testable.
-func NewRepository(userRepo user.Repository, otpRepo otp.Repository, rateLimiter RateLimiter) Repository {
+// The verify rate limiter is separate so brute-forcing codes has its own, stricter budget.
+// channelRateLimiters limit the sends of each OTP channel below rateLimiter, the ceiling across
+// all of them; channels without one only count against the ceiling. attackDetector may be nil.
+func NewRepository(userRepo user.Repository, otpRepo otp.Repository, rateLimiter RateLimiter, channelRateLimiters map[string]RateLimiter, verifyRateLimiter RateLimiter, attackDetector AttackDetector) Repository {
 	return &authRepository{
-		userRepo:    userRepo,
-		otpRepo:     otpRepo,
-		rateLimiter: rateLimiter,
+		userRepo:            userRepo,
+		otpRepo:             otpRepo,
+		rateLimiter:         rateLimiter,
+		channelRateLimiters: channelRateLimiters,
+		verifyRateLimiter:   verifyRateLimiter,
+		attackDetector:      attackDetector,
+	}
+}
+
+func (r *authRepository) GetUserByID(ctx context.Context, id uuid.UUID) (model.User, error) {
+	u, err := r.userRepo.GetUserByID(ctx, id)
+	if errors.Is(err, database.ErrNotFound) {
+		return model.User{}, ErrUserNotFound
 	}
+	return u, err
 }
 
-func (r *authRepository) GetUserByPhoneNumber(phoneNumber string) (model.User, error) {
-	u, err := r.userRepo.GetUserByPhoneNumber(phoneNumber)
+// GetUserByPhoneNumber finds the user by their primary or any of their secondary phone numbers.
+func (r *authRepository) GetUserByPhoneNumber(ctx context.Context, phoneNumber string) (model.User, error) {
+	u, err := r.userRepo.GetUserByPhoneNumber(ctx, phoneNumber)
+	if errors.Is(err, database.ErrNotFound) {
+		var phone model.UserPhone
+		phone, err = r.userRepo.GetPhoneNumber(ctx, phoneNumber)
+		if err == nil {
+			u, err = r.userRepo.GetUserByID(ctx, phone.UserID)
+		}
+	}
 	if errors.Is(err, database.ErrNotFound) {
 		return model.User{}, ErrUserNotFound // Translate internal error to a domain-specific one
 	}
 	return u, err
 }
 
-func (r *authRepository) CreateUser(user model.User) (model.User, error) {
-	return r.userRepo.CreateUser(user)
+func (r *authRepository) AddPhoneNumber(ctx context.Context, phone model.UserPhone) (model.UserPhone, error) {
+	phone, err := r.userRepo.AddPhoneNumber(ctx, phone)
+	if errors.Is(err, database.ErrAlreadyExists) {
+		return model.UserPhone{}, ErrPhoneNumberTaken
+	}
+	if errors.Is(err, database.ErrNotFound) {
+		return model.UserPhone{}, ErrUserNotFound
+	}
+	return phone, err
 }
 
-func (r *authRepository) StoreOTP(otp model.OTP) error {
-	return r.otpRepo.StoreOTP(otp)
+func (r *authRepository) CreateUser(ctx context.Context, user model.User) (model.User, error) {
+	return r.userRepo.CreateUser(ctx, user)
 }
 
-func (r *authRepository) GetOTP(phoneNumber string) (model.OTP, error) {
-	return r.otpRepo.GetOTP(phoneNumber)
+func (r *authRepository) MarkPhoneVerified(ctx context.Context, id uuid.UUID, at time.Time) error {
+	err := r.userRepo.MarkPhoneVerified(ctx, id, at)
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrUserNotFound
+	}
+	return err
 }
 
-func (r *authRepository) DeleteOTP(phoneNumber string) error {
-	return r.otpRepo.DeleteOTP(phoneNumber)
+func (r *authRepository) SetPasswordHash(ctx context.Context, id uuid.UUID, hash string) error {
+	err := r.userRepo.SetPasswordHash(ctx, id, hash)
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+func (r *authRepository) GetPasswordHash(ctx context.Context, id uuid.UUID) (string, error) {
+	hash, err := r.userRepo.GetPasswordHash(ctx, id)
+	if errors.Is(err, database.ErrNotFound) {
+		return "", ErrUserNotFound
+	}
+	return hash, err
+}
+
+func (r *authRepository) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	err := r.userRepo.DeleteUser(ctx, id)
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+func (r *authRepository) GetUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]model.User, error) {
+	return r.userRepo.GetUsersByIDs(ctx, ids)
+}
+
+func (r *authRepository) DeleteUsers(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error) {
+	return r.userRepo.DeleteUsers(ctx, ids)
+}
+
+func (r *authRepository) SetUsersBanned(ctx context.Context, ids []uuid.UUID, bannedAt *time.Time) ([]uuid.UUID, error) {
+	return r.userRepo.SetUsersBanned(ctx, ids, bannedAt)
+}
+
+func (r *authRepository) GetDeletedUserByPhoneNumber(ctx context.Context, phoneNumber string, deletedAfter time.Time) (model.User, error) {
+	u, err := r.userRepo.GetDeletedUserByPhoneNumber(ctx, phoneNumber, deletedAfter)
+	if errors.Is(err, database.ErrNotFound) {
+		return model.User{}, ErrUserNotFound
+	}
+	return u, err
+}
+
+func (r *authRepository) RestoreUser(ctx context.Context, id uuid.UUID) error {
+	err := r.userRepo.RestoreUser(ctx, id)
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrUserNotFound
+	}
+	if errors.Is(err, database.ErrAlreadyExists) {
+		return ErrPhoneNumberTaken
+	}
+	return err
+}
+
+func (r *authRepository) StoreOTP(ctx context.Context, otp model.OTP) error {
+	return r.otpRepo.StoreOTP(ctx, otp)
+}
+
+func (r *authRepository) GetOTP(ctx context.Context, phoneNumber string) (model.OTP, error) {
+	o, err := r.otpRepo.GetOTP(ctx, phoneNumber)
+	if errors.Is(err, database.ErrNotFound) {
+		return model.OTP{}, ErrOTPNotFound
+	}
+	return o, err
+}
+
+func (r *authRepository) DeleteOTP(ctx context.Context, phoneNumber string) error {
+	return r.otpRepo.DeleteOTP(ctx, phoneNumber)
 }
 
 // This method works exactly as before because the interface guarantees
 // that a `.Allow()` method exists.
-func (r *authRepository) AllowOTPRate(phoneNumber string) bool {
-	return r.rateLimiter.Allow(phoneNumber)
+// AllowOTPRate counts a send against the limit of its channel and the ceiling across all
+// channels, so switching channels doesn't get around either. A send the channel refuses isn't
+// counted against the ceiling. The result is the one of the limit refusing, or else of the
+// one with fewer requests remaining.
+func (r *authRepository) AllowOTPRate(channel, phoneNumber string) model.RateLimitResult {
+	limiter, ok := r.channelRateLimiters[channel]
+	if !ok {
+		return r.rateLimiter.Allow(phoneNumber)
+	}
+	channelLimit := limiter.Allow(phoneNumber)
+	if !channelLimit.Allowed {
+		return channelLimit
+	}
+	ceiling := r.rateLimiter.Allow(phoneNumber)
+	if !ceiling.Allowed || ceiling.Remaining <= channelLimit.Remaining {
+		return ceiling
+	}
+	return channelLimit
+}
+
+func (r *authRepository) AllowOTPVerifyRate(key string) model.RateLimitResult {
+	return r.verifyRateLimiter.Allow(key)
+}
+
+func (r *authRepository) VerifyBlockedUntil(ip, phoneNumber string) time.Time {
+	if r.attackDetector == nil {
+		return time.Time{}
+	}
+	return r.attackDetector.Blocked(ip, phoneNumber)
+}
+
+func (r *authRepository) RecordVerifyFailure(ip, phoneNumber string) []model.Anomaly {
+	if r.attackDetector == nil {
+		return nil
+	}
+	return r.attackDetector.RecordFailure(ip, phoneNumber)
 }
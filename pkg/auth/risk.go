@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+)
+
+var (
+	ErrRiskChallenge = apperr.New(http.StatusForbidden, i18n.CodeChallengeRequired)
+	ErrRiskDenied    = apperr.New(http.StatusForbidden, i18n.CodeRequestDenied)
+)
+
+// RiskDecision is what a RiskEvaluator makes of a request.
+type RiskDecision int
+
+const (
+	RiskAllow     RiskDecision = iota // proceed as usual
+	RiskChallenge                     // ask the client for additional verification
+	RiskDeny                          // refuse the request
+)
+
+func (d RiskDecision) String() string {
+	switch d {
+	case RiskChallenge:
+		return "challenge"
+	case RiskDeny:
+		return "deny"
+	default:
+		return "allow"
+	}
+}
+
+// Actions a RiskEvaluator is consulted for.
+const (
+	RiskActionSendOTP   = "send_otp"
+	RiskActionVerifyOTP = "verify_otp"
+)
+
+// RiskAssessment is the data a RiskEvaluator scores.
+type RiskAssessment struct {
+	Action      string // RiskActionSendOTP or RiskActionVerifyOTP
+	PhoneNumber string // E.164
+	Client      model.ClientInfo
+	// Velocity is the rate limit status of the request: OTPs sent to the phone number for
+	// RiskActionSendOTP, verification attempts for the phone number and IP for RiskActionVerifyOTP.
+	Velocity model.RateLimitResult
+}
+
+// RiskEvaluator lets teams plug their own fraud engine into the auth service. It is consulted
+// before an OTP is sent and before one is checked, after the rate limits passed.
+// Errors are logged and the request is allowed, so an unavailable engine doesn't lock users out.
+type RiskEvaluator interface {
+	Evaluate(ctx context.Context, assessment RiskAssessment) (RiskDecision, error)
+}
+
+// evaluateRisk consults the risk evaluator, if any, and returns ErrRiskChallenge or
+// ErrRiskDenied for requests that must not proceed.
+func (s *authService) evaluateRisk(ctx context.Context, assessment RiskAssessment) error {
+	if s.risk == nil {
+		return nil
+	}
+
+	decision, err := s.risk.Evaluate(ctx, assessment)
+	if err != nil {
+		logging.FromContext(ctx).Error("Risk evaluation failed, allowing request",
+			"action", assessment.Action, "phone_number", assessment.PhoneNumber, "error", err)
+		return nil
+	}
+	switch decision {
+	case RiskChallenge:
+		return ErrRiskChallenge
+	case RiskDeny:
+		return ErrRiskDenied
+	default:
+		return nil
+	}
+}
@@ -1,12 +1,27 @@
 package auth
 
 import (
+	"cmp"
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/metrics"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
 	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/password"
+	"github.com/ebipenman/go-otp-auth-service/internal/phone"
+	"github.com/ebipenman/go-otp-auth-service/internal/tracing"
+	"github.com/ebipenman/go-otp-auth-service/pkg/featureflag"
 	"github.com/ebipenman/go-otp-auth-service/pkg/otp"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -14,113 +29,1598 @@ import (
 )
 
 var (
+	// ErrRateLimitExceeded is the cause of the rate limit errors, ErrSendRateLimited and
+	// ErrVerifyRateLimited, for callers that don't tell them apart.
 	ErrRateLimitExceeded = errors.New("rate limit exceeded")
-	ErrInvalidOTP        = errors.New("invalid or expired OTP")
+	// ErrSendRateLimited is returned when OTPs are requested too often for a phone number.
+	ErrSendRateLimited = apperr.New(http.StatusTooManyRequests, i18n.CodeOTPRateLimited).Wrap(ErrRateLimitExceeded)
+	// ErrVerifyRateLimited is returned when OTPs, device tokens or passwords are tried too often.
+	ErrVerifyRateLimited = apperr.New(http.StatusTooManyRequests, i18n.CodeVerifyRateLimited).Wrap(ErrRateLimitExceeded)
+	ErrInvalidOTP        = apperr.New(http.StatusUnauthorized, i18n.CodeInvalidOTP)
 	ErrUserRegistration  = errors.New("failed to register new user")
 	ErrJWTGeneration     = errors.New("failed to generate JWT token")
+	// ErrInvalidPhoneNumber wraps phone.ErrInvalidNumber for callers of the auth service.
+	ErrInvalidPhoneNumber = apperr.New(http.StatusBadRequest, i18n.CodeInvalidPhoneNumber).Wrap(phone.ErrInvalidNumber)
+	ErrCountryNotAllowed  = apperr.New(http.StatusForbidden, i18n.CodeCountryNotAllowed)
+	ErrChannelNotEnabled  = apperr.New(http.StatusBadRequest, i18n.CodeChannelNotEnabled)
+	// ErrChannelUnavailable means the phone number can't be reached on the requested channel,
+	// e.g. email when its user has no verified email address.
+	ErrChannelUnavailable = apperr.New(http.StatusConflict, i18n.CodeChannelUnavailable)
+	// ErrQuotaExceeded means the OTP would exceed the send budget of the service or of the
+	// tenant, see WithQuota.
+	ErrQuotaExceeded = apperr.New(http.StatusTooManyRequests, i18n.CodeOTPQuotaExceeded)
+	// ErrDeliveryFailed means the sender failed to deliver the OTP for good; the OTP is
+	// recorded as a dead letter (see WithDeadLetters).
+	ErrDeliveryFailed     = apperr.New(http.StatusBadGateway, i18n.CodeOTPDeliveryFailed)
+	ErrDeliveryNotFound   = apperr.New(http.StatusNotFound, i18n.CodeDeliveryNotFound)
+	ErrInvalidDeviceToken = apperr.New(http.StatusUnauthorized, i18n.CodeInvalidDeviceToken)
+	ErrGuestsDisabled     = errors.New("guest sessions are disabled")
+	ErrPhoneNumberTaken   = apperr.New(http.StatusConflict, i18n.CodePhoneNumberTaken)
+	// ErrPasswordRequired means the user has a password, so the OTP must have been requested
+	// with it through SendPasswordOTP.
+	ErrPasswordRequired   = apperr.New(http.StatusUnauthorized, i18n.CodePasswordRequired)
+	ErrInvalidCredentials = apperr.New(http.StatusUnauthorized, i18n.CodeInvalidCredentials)
+	// ErrUserBanned means an administrator banned the user from signing in, see BulkUpdateUsers.
+	ErrUserBanned = apperr.New(http.StatusForbidden, i18n.CodeAccountBanned)
+	// ErrInvalidBulkAction is returned by BulkUpdateUsers for an action it doesn't know.
+	ErrInvalidBulkAction = errors.New("invalid bulk action")
+	// ErrAccountRestorable is answered for a *RestorableError, whose PurgeAt it carries.
+	ErrAccountRestorable = apperr.New(http.StatusConflict, i18n.CodeAccountRestorable)
+)
+
+// RestorableError is returned by the OTP sign-ins, wrapped in ErrAccountRestorable, when the phone
+// number belonged to an account deleted less than the grace period ago (see WithDeletionGracePeriod). The OTP isn't used
+// up: VerifyOTPAndRestore restores the account with it, or registers a new one.
+type RestorableError struct {
+	// PurgeAt is when the deleted account is purged for good.
+	PurgeAt time.Time
+}
+
+func (e *RestorableError) Error() string {
+	return "phone number belongs to a deleted account that can be restored"
+}
+
+// restorable returns the ErrAccountRestorable of an account purged at purgeAt.
+func restorable(purgeAt time.Time) error {
+	return ErrAccountRestorable.Wrap(&RestorableError{PurgeAt: purgeAt}).WithFields(map[string]any{"purge_at": purgeAt})
+}
+
+// rateLimited returns err, ErrSendRateLimited or ErrVerifyRateLimited, with the seconds until
+// rateLimit allows the next request.
+func rateLimited(err *apperr.Error, rateLimit model.RateLimitResult) *apperr.Error {
+	retryAfter := middleware.RetryAfterSeconds(rateLimit)
+	return err.WithParams(i18n.Params{"seconds": retryAfter}).WithFields(map[string]any{"retry_after": retryAfter})
+}
+
+// TokenTTL is how long the JWTs issued by the auth service are valid.
+const TokenTTL = 24 * time.Hour
+
+// Sign-in methods of the auth service itself, as named in the login events and history.
+const (
+	methodOTP           = "otp"
+	methodTrustedDevice = "trusted_device"
+	methodPassword      = "password"
 )
 
 // Service defines the business logic for authentication.
 type Service interface {
-	SendOTP(phoneNumber string) error
-	VerifyOTPAndAuthenticate(phoneNumber, receivedOTP string) (string, error)
+	// Both methods normalize the phone number to E.164 first and return ErrInvalidPhoneNumber
+	// if it isn't valid. SendOTP returns ErrCountryNotAllowed for countries the phone policy excludes.
+	// Both return ErrRiskChallenge or ErrRiskDenied when the risk evaluator objects.
+	// SendOTP returns the ID of the delivery to watch with WatchDelivery (uuid.Nil when deliveries
+	// aren't tracked), and the rate limit status of the phone number alongside any error, so
+	// callers can tell clients when they may try again.
+	SendOTP(ctx context.Context, phoneNumber string, client model.ClientInfo) (uuid.UUID, model.RateLimitResult, error)
+	// SendOTPVia is SendOTP through the given channel, e.g. otp.ChannelWhatsApp; empty means SMS.
+	// It returns ErrChannelNotEnabled for channels without a sender (see WithChannelSender) or
+	// whose feature flag is off for the phone number (see WithFeatureFlags), and
+	// ErrChannelUnavailable if the sender can't reach the phone number. If the sender fails
+	// otherwise, it records a dead letter and returns ErrDeliveryFailed. With a dispatcher
+	// (see WithDispatcher) both only show in the delivery status, which ends as failed.
+	SendOTPVia(ctx context.Context, phoneNumber, channel string, client model.ClientInfo) (uuid.UUID, model.RateLimitResult, error)
+	// WatchDelivery returns the statuses an OTP delivery went through so far and a channel
+	// receiving the later ones, closed after the last. It returns ErrDeliveryNotFound for
+	// unknown and expired deliveries, and when deliveries aren't tracked. stop must be called
+	// once the caller stops reading.
+	WatchDelivery(id uuid.UUID) (history []otp.DeliveryStatus, updates <-chan otp.DeliveryStatus, stop func(), err error)
+	// VerifyOTPAndAuthenticate is rate limited per phone number and client IP, and like SendOTP
+	// returns the rate limit status alongside the token. The client's device is recorded. If the
+	// number's account was deleted recently, it returns a *RestorableError instead.
+	VerifyOTPAndAuthenticate(ctx context.Context, phoneNumber, receivedOTP string, client model.ClientInfo) (string, model.RateLimitResult, error)
+	// VerifyOTPAndTrustDevice works like VerifyOTPAndAuthenticate and also trusts the client's
+	// device, returning its device token for SignInTrustedDevice. The device token is empty
+	// when devices aren't trusted (see WithTrustedDevices).
+	VerifyOTPAndTrustDevice(ctx context.Context, phoneNumber, receivedOTP string, client model.ClientInfo) (string, model.DeviceToken, model.RateLimitResult, error)
+	// VerifyOTPAndRestore works like VerifyOTPAndAuthenticate, for phone numbers the other OTP
+	// sign-ins returned a *RestorableError for. With restore set, the deleted account is
+	// restored and signed in to, without the secondary phone numbers it had; otherwise a new
+	// account is registered and the deleted one is purged after the grace period.
+	VerifyOTPAndRestore(ctx context.Context, phoneNumber, receivedOTP string, restore bool, client model.ClientInfo) (string, model.RateLimitResult, error)
+	// CreateGuest starts a guest session for apps that allow browsing before sign-in. The
+	// guest token is limited: AuthMiddleware rejects it. It returns ErrGuestsDisabled unless
+	// guest sessions are enabled (see WithGuestSessions).
+	CreateGuest(ctx context.Context, client model.ClientInfo) (token string, guestID uuid.UUID, err error)
+	// UpgradeGuest works like VerifyOTPAndAuthenticate for a guest: the user registered for an
+	// unknown phone number keeps the guest's ID. If the number has an account already, the
+	// guest signs in to it instead; upgraded reports whether the guest's ID was kept.
+	UpgradeGuest(ctx context.Context, guestID uuid.UUID, phoneNumber, receivedOTP string, client model.ClientInfo) (token string, upgraded bool, rateLimit model.RateLimitResult, err error)
+	// SignInTrustedDevice signs in from a trusted device with its device token instead of an
+	// OTP. It's rate limited like VerifyOTPAndAuthenticate and returns ErrInvalidDeviceToken
+	// unless the token is valid and belongs to the client's device and the phone number's user.
+	SignInTrustedDevice(ctx context.Context, phoneNumber, deviceToken string, client model.ClientInfo) (string, model.RateLimitResult, error)
+	// SignInVerified signs in the owner of a phone number that was verified by other means
+	// than an OTP, e.g. a social login; method names them in the login.succeeded event.
+	// Like an OTP login it registers unknown numbers and records the device; it returns the
+	// JWT and the user.
+	SignInVerified(ctx context.Context, phoneNumber, method string, client model.ClientInfo) (string, model.User, error)
+	// DeleteAccount deletes the user's account once they confirmed it with an OTP sent to their
+	// phone number, which is checked like in VerifyOTPAndAuthenticate. Their tokens are revoked
+	// and pending OTPs purged. With a deletion grace period, the account can be restored until
+	// it ends. It returns ErrUserNotFound for unknown or deleted users.
+	DeleteAccount(ctx context.Context, userID uuid.UUID, receivedOTP string, client model.ClientInfo) (model.RateLimitResult, error)
+	// ReverifyPhone renews the phone verification of the user with an OTP sent to their phone
+	// number, which is checked like in VerifyOTPAndAuthenticate, and returns a new JWT carrying
+	// it. It returns ErrUserNotFound for unknown or deleted users.
+	ReverifyPhone(ctx context.Context, userID uuid.UUID, receivedOTP string, client model.ClientInfo) (string, model.RateLimitResult, error)
+	// AddPhoneNumber sends an OTP to a phone number the user wants to add as a secondary
+	// number, like SendOTP. It returns ErrPhoneNumberTaken if the number belongs to a user
+	// already, including this one.
+	AddPhoneNumber(ctx context.Context, userID uuid.UUID, phoneNumber string, client model.ClientInfo) (uuid.UUID, model.RateLimitResult, error)
+	// VerifyPhoneNumber adds the phone number as a secondary number of the user once they
+	// confirmed the OTP sent to it, which is checked like in VerifyOTPAndAuthenticate. The
+	// user can then sign in with it.
+	VerifyPhoneNumber(ctx context.Context, userID uuid.UUID, phoneNumber, receivedOTP string, client model.ClientInfo) (model.UserPhone, model.RateLimitResult, error)
+	// SetPassword sets the password of the user, who then has to enter it before every OTP
+	// login (see SendPasswordOTP). Changing an existing password requires the current one;
+	// wrong ones return ErrInvalidCredentials and count against the verification rate limit.
+	SetPassword(ctx context.Context, userID uuid.UUID, currentPassword, newPassword string, client model.ClientInfo) (model.RateLimitResult, error)
+	// SendPasswordOTP is the first step of the password login: it checks the password of the
+	// phone number's user and then sends an OTP like SendOTP. Unlike those of SendOTP, the OTP
+	// signs in users with a password. Wrong passwords, unknown numbers and users without a
+	// password all return ErrInvalidCredentials; attempts are limited like OTP verifications.
+	SendPasswordOTP(ctx context.Context, phoneNumber, password string, client model.ClientInfo) (uuid.UUID, model.RateLimitResult, error)
+	// InvalidateOTP deletes the pending OTP of the phone number, e.g. one that got stuck or
+	// leaked, and emits an otp.invalidated event naming invalidatedBy and the reason. It
+	// returns ErrInvalidPhoneNumber for invalid numbers and ErrOTPNotFound if none is pending.
+	InvalidateOTP(ctx context.Context, phoneNumber, invalidatedBy, reason string) error
+	// ForceLogout signs the user out everywhere, e.g. when their account is compromised: the
+	// tokens issued so far are revoked and their trusted devices have to verify an OTP again.
+	// It emits a user.logged_out event naming loggedOutBy and the reason, and returns
+	// ErrUserNotFound for unknown users.
+	ForceLogout(ctx context.Context, userID uuid.UUID, loggedOutBy, reason string) error
+	// BulkUpdateUsers applies the action, model.BulkActionDelete, BulkActionBan or
+	// BulkActionUnban, to the users in batches, each in one transaction, and reports the outcome
+	// per user in the order of ids. A batch that fails is reported failed, and the next one is
+	// tried. Deleted users can be restored like after DeleteAccount; banned users can't sign in
+	// (ErrUserBanned) and are logged out like by ForceLogout. It emits a user.deleted,
+	// user.banned or user.unbanned event per user, naming actor and the reason.
+	BulkUpdateUsers(ctx context.Context, action string, ids []uuid.UUID, actor, reason string) ([]model.UserBulkResult, error)
+	// SetPhonePolicy replaces the phone policy at runtime, e.g. when the configuration is reloaded.
+	SetPhonePolicy(phones phone.Policy)
+}
+
+// EventPublisher receives the domain events emitted by the auth service
+// (user.created, user.deleted, user.banned, user.unbanned, otp.sent, otp.rate_limited, otp.invalidated, user.logged_out,
+// login.succeeded, login.failed).
+// Publish must not block.
+type EventPublisher interface {
+	Publish(event model.Event)
+}
+
+// LoginRecorder keeps the login history of users.
+type LoginRecorder interface {
+	RecordLogin(ctx context.Context, login model.Login) error
+}
+
+// DeadLetterRecorder keeps the OTPs whose delivery failed for good, for support to look into
+// and retry.
+type DeadLetterRecorder interface {
+	RecordDeadLetter(ctx context.Context, letter model.DeadLetter) error
+}
+
+// TokenRevoker revokes the tokens issued to a user up to some point in time.
+type TokenRevoker interface {
+	RevokeTokens(ctx context.Context, userID uuid.UUID, before time.Time) error
+}
+
+// DeviceTracker records the devices users sign in from and recognizes unseen ones. It also
+// keeps which devices users chose to trust.
+type DeviceTracker interface {
+	Track(ctx context.Context, user model.User, client model.ClientInfo) (model.Device, bool, error)
+	Trust(ctx context.Context, userID uuid.UUID, client model.ClientInfo, until time.Time) (model.DeviceToken, error)
+	IsTrusted(ctx context.Context, userID uuid.UUID, client model.ClientInfo, token string) (bool, error)
+	RevokeAllTrust(ctx context.Context, userID uuid.UUID) (int, error)
+}
+
+// DeliveryTracker follows the delivery of the OTPs for the clients watching it.
+type DeliveryTracker interface {
+	Track(id uuid.UUID, channel string, expiresAt time.Time)
+	Update(id uuid.UUID, status string)
+	Finish(id uuid.UUID)
+	Subscribe(id uuid.UUID) ([]otp.DeliveryStatus, <-chan otp.DeliveryStatus, func(), bool)
+}
+
+// Dispatcher runs OTP sends in the background, e.g. an *otp.Dispatcher. Dispatch must not
+// block; when it returns an error, the send runs right away instead.
+type Dispatcher interface {
+	Dispatch(send func()) error
+}
+
+// FeatureFlags decide which features are on for a subject, e.g. a phone number, of a tenant,
+// e.g. *featureflag.Flags. Flags that aren't defined are on if def is set.
+type FeatureFlags interface {
+	Enabled(name, subject, tenant string, def bool) bool
+}
+
+// Scheduler runs jobs at a later time, e.g. an *otp.Scheduler.
+type Scheduler interface {
+	Schedule(at time.Time, job func())
+}
+
+// QuotaKeeper books the cost of OTP sends against the send budgets.
+type QuotaKeeper interface {
+	// Cost returns the cost of a message on the channel.
+	Cost(channel string) int64
+	// Spend books a message on the channel against the budgets of the service and of the
+	// tenant, if any. It reports false, booking nothing, if that would exceed one of them.
+	Spend(ctx context.Context, tenant, channel string) (bool, error)
+	// Refund takes back a message booked by Spend that wasn't sent after all.
+	Refund(ctx context.Context, tenant, channel string) error
+}
+
+// StatsRecorder counts the outcomes of OTP requests for the usage statistics.
+type StatsRecorder interface {
+	Count(ctx context.Context, counter string) error
+}
+
+// UserOutbox creates users together with their user.created event in one transaction, and
+// publishes the event once that committed.
+type UserOutbox interface {
+	CreateUserWithEvent(ctx context.Context, user model.User, event func(model.User) model.Event) (model.User, error)
 }
 
 type authService struct {
-	authRepo     Repository
-	otpGenerator otp.OTPGenerator
-	jwtSecret    string
+	authRepo      Repository
+	otpGenerator  otp.OTPGenerator
+	otpSender     otp.Sender
+	channels      map[string]otp.Sender // senders of the channels besides SMS
+	otpTTL        time.Duration
+	now           func() time.Time
+	jwtSecret     string
+	events        EventPublisher
+	devices       DeviceTracker   // nil doesn't record devices
+	trustTTL      time.Duration   // zero doesn't trust devices
+	guestTTL      time.Duration   // zero disables guest sessions
+	deletionGrace time.Duration   // zero doesn't restore deleted accounts
+	logins        LoginRecorder   // nil doesn't record logins
+	tokens        TokenRevoker    // nil doesn't revoke tokens
+	outbox        UserOutbox      // nil publishes user.created directly
+	deliveries    DeliveryTracker // nil doesn't track deliveries
+	stats         StatsRecorder   // nil doesn't count
+	phones        atomic.Pointer[phone.Policy]
+	risk          RiskEvaluator // nil allows every request
+	quota         QuotaKeeper   // nil doesn't budget sends
+	quotaFailover bool          // a cheaper channel stands in when the quota is exceeded
+	branding      otp.Branding  // sender ID and message prefix of every OTP
+	tenantBrands  map[string]otp.Branding
+	deadLetters   DeadLetterRecorder // nil doesn't keep failed deliveries
+	dispatcher    Dispatcher         // nil sends within the request
+	resendAfter   time.Duration      // zero doesn't re-send unconfirmed OTPs
+	resendChannel string
+	scheduler     Scheduler
+	flags         FeatureFlags // nil gates no channels
 }
 
-func NewService(authRepo Repository, otpGenerator otp.OTPGenerator, jwtSecret string) Service {
-	return &authService{
+// NewService creates the auth service. Everything besides the repository and the JWT
+// signing secret is optional, see the With* options for the defaults.
+func NewService(authRepo Repository, jwtSecret string, opts ...Option) Service {
+	s := &authService{
 		authRepo:     authRepo,
-		otpGenerator: otpGenerator,
+		otpGenerator: otp.NewSimpleOTPGenerator(),
+		otpSender:    otp.NewConsoleSender(false),
+		otpTTL:       defaultOTPTTL,
+		now:          time.Now,
 		jwtSecret:    jwtSecret,
+		events:       discardEvents{},
+	}
+	s.phones.Store(&phone.Policy{})
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
+}
+
+func (s *authService) SetPhonePolicy(phones phone.Policy) {
+	s.phones.Store(&phones)
+}
+
+func (s *authService) SendOTP(ctx context.Context, phoneNumber string, client model.ClientInfo) (deliveryID uuid.UUID, rateLimit model.RateLimitResult, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.SendOTP")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	return s.sendOTP(ctx, phoneNumber, otp.ChannelSMS, client, false)
 }
 
-func (s *authService) SendOTP(phoneNumber string) error {
+func (s *authService) SendOTPVia(ctx context.Context, phoneNumber, channel string, client model.ClientInfo) (deliveryID uuid.UUID, rateLimit model.RateLimitResult, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.SendOTPVia")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	return s.sendOTP(ctx, phoneNumber, channel, client, false)
+}
+
+// sendOTP sends an OTP to the phone number through the channel; passwordVerified marks it as
+// sent after the password of the number's user was checked.
+func (s *authService) sendOTP(ctx context.Context, phoneNumber, channel string, client model.ClientInfo, passwordVerified bool) (deliveryID uuid.UUID, rateLimit model.RateLimitResult, err error) {
+	logger := logging.FromContext(ctx)
+	if channel == "" {
+		channel = otp.ChannelSMS
+	}
+	sender, err := s.sender(channel)
+	if err != nil {
+		return uuid.Nil, rateLimit, err
+	}
+
+	// 0. Normalize the number, so every formatting of it shares one user and one rate limit
+	phones := s.phones.Load()
+	phoneNumber, err = phones.Normalize(phoneNumber)
+	if err != nil {
+		return uuid.Nil, rateLimit, ErrInvalidPhoneNumber
+	}
+	if !phones.AllowsCountry(phoneNumber) {
+		return uuid.Nil, rateLimit, ErrCountryNotAllowed
+	}
+	if !s.channelEnabled(channel, phoneNumber, client.Tenant) {
+		return uuid.Nil, rateLimit, ErrChannelNotEnabled
+	}
+
 	// 1. Check Rate Limit
-	if !s.authRepo.AllowOTPRate(phoneNumber) {
-		return ErrRateLimitExceeded
+	rateLimit = s.authRepo.AllowOTPRate(channel, phoneNumber)
+	if !rateLimit.Allowed {
+		s.count(ctx, model.CounterOTPSendRateLimited)
+		s.events.Publish(model.NewEvent(model.EventOTPRateLimited, map[string]interface{}{
+			"phone_number":  phoneNumber,
+			"channel":       channel,
+			"ip":            client.IP,
+			"retry_after":   middleware.RetryAfterSeconds(rateLimit),
+			"penalty_level": rateLimit.Penalty,
+		}))
+		return uuid.Nil, rateLimit, rateLimited(ErrSendRateLimited, rateLimit).WithFields(map[string]any{"penalty_level": rateLimit.Penalty})
+	}
+	if err := s.evaluateRisk(ctx, RiskAssessment{
+		Action: RiskActionSendOTP, PhoneNumber: phoneNumber, Client: client, Velocity: rateLimit,
+	}); err != nil {
+		logger.Warn("OTP request refused by risk evaluation", "phone_number", phoneNumber, "ip", client.IP, "error", err)
+		return uuid.Nil, rateLimit, err
+	}
+	if channel, sender, err = s.spendQuota(ctx, channel, sender, phoneNumber, client.Tenant); err != nil {
+		return uuid.Nil, rateLimit, err
 	}
 
 	// 2. Generate OTP
 	otpCode := s.otpGenerator.GenerateOTP()
-	expiresAt := time.Now().Add(2 * time.Minute) // As per requirement
+	expiresAt := s.now().Add(s.otpTTL)
 
 	// 3. Store OTP
 	otpModel := model.OTP{
-		PhoneNumber: phoneNumber,
-		OTPCode:     otpCode,
-		ExpiresAt:   expiresAt,
+		PhoneNumber:      phoneNumber,
+		OTPCode:          otpCode,
+		ExpiresAt:        expiresAt,
+		Channel:          otp.Channel(sender),
+		PasswordVerified: passwordVerified,
 	}
-	if err := s.authRepo.StoreOTP(otpModel); err != nil {
+	otpModel.SenderID, otpModel.MessagePrefix = s.brandingOf(client.Tenant)
+	if err := s.authRepo.StoreOTP(ctx, otpModel); err != nil {
 		// Log the internal error
-		log.Printf("ERROR: Failed to store OTP for %s: %v", phoneNumber, err)
-		return fmt.Errorf("failed to process OTP request")
+		logger.Error("Failed to store OTP", "phone_number", phoneNumber, "error", err)
+		return uuid.Nil, rateLimit, fmt.Errorf("failed to process OTP request")
 	}
 
-	// 4. Print to console (as per requirement, no SMS sending)
-	log.Printf("---- OTP for %s: %s (Expires in 2 minutes) ----", phoneNumber, otpCode)
+	// 4. Deliver the OTP (printed to the console unless an SMS provider is configured)
+	if s.deliveries != nil {
+		deliveryID = uuid.New()
+		s.deliveries.Track(deliveryID, otpModel.Channel, expiresAt)
+	}
+	// Deliveries in the background can only be followed through their status.
+	if s.dispatcher != nil && s.deliveries != nil {
+		sendCtx, cancel := context.WithDeadline(context.WithoutCancel(ctx), expiresAt)
+		err := s.dispatcher.Dispatch(func() {
+			defer cancel()
+			_ = s.deliver(sendCtx, sender, channel, client, deliveryID, otpModel)
+		})
+		if err == nil {
+			return deliveryID, rateLimit, nil
+		}
+		cancel()
+		logger.Warn("Failed to dispatch OTP, sending it right away", "phone_number", phoneNumber, "error", err)
+	}
+	if err := s.deliver(ctx, sender, channel, client, deliveryID, otpModel); err != nil {
+		return uuid.Nil, rateLimit, err
+	}
+	return deliveryID, rateLimit, nil
+}
+
+// deliver sends the stored OTP through sender and follows its delivery. A failed send
+// refunds the quota and, unless the channel can't reach the number, leaves a dead letter.
+func (s *authService) deliver(ctx context.Context, sender otp.Sender, channel string, client model.ClientInfo, deliveryID uuid.UUID, otpModel model.OTP) error {
+	logger := logging.FromContext(ctx)
+	country := phone.CountryCode(otpModel.PhoneNumber)
+	metrics.OTPFunnel.Add(otpModel.Channel, country, metrics.StageSent)
+	if err := sender.Send(ctx, otpModel); err != nil {
+		if s.deliveries != nil {
+			s.deliveries.Update(deliveryID, otp.StatusFailed)
+		}
+		s.refundQuota(ctx, channel, client.Tenant)
+		if errors.Is(err, otp.ErrNoRecipient) {
+			return ErrChannelUnavailable
+		}
+		logger.Error("Failed to send OTP", "phone_number", otpModel.PhoneNumber, "channel", channel, "error", err)
+		s.recordDeadLetter(ctx, model.DeadLetter{
+			PhoneNumber: otpModel.PhoneNumber,
+			Channel:     channel,
+			Tenant:      client.Tenant,
+			Error:       err.Error(),
+		})
+		return ErrDeliveryFailed
+	}
+	metrics.OTPFunnel.Add(otpModel.Channel, country, metrics.StageDelivered)
+	if s.deliveries != nil {
+		s.deliveries.Update(deliveryID, otp.StatusSent)
+		go s.awaitDelivery(context.WithoutCancel(ctx), sender, deliveryID, otpModel)
+	}
+	if s.resendAfter > 0 && channel != s.resendChannel {
+		resendCtx := context.WithoutCancel(ctx)
+		s.scheduler.Schedule(s.now().Add(s.resendAfter), func() {
+			go s.resend(resendCtx, client, deliveryID, otpModel)
+		})
+	}
 
+	s.events.Publish(model.NewEvent(model.EventOTPSent, map[string]interface{}{
+		"phone_number": otpModel.PhoneNumber,
+		"expires_at":   otpModel.ExpiresAt,
+	}))
 	return nil
 }
 
-func (s *authService) VerifyOTPAndAuthenticate(phoneNumber, receivedOTP string) (string, error) {
-	// 1. Retrieve and Validate OTP
-	storedOTP, err := s.authRepo.GetOTP(phoneNumber)
-	if err != nil || storedOTP.OTPCode != receivedOTP || storedOTP.IsExpired() {
-		return "", ErrInvalidOTP
+// spendQuota books the OTP against the send budgets. If it doesn't fit, and failover is
+// enabled, the cheaper channels are tried from the most to the least expensive; it returns
+// the channel and sender the OTP was booked on.
+func (s *authService) spendQuota(ctx context.Context, channel string, sender otp.Sender, phoneNumber, tenant string) (string, otp.Sender, error) {
+	if s.quota == nil {
+		return channel, sender, nil
+	}
+	ok, err := s.quota.Spend(ctx, tenant, channel)
+	if err != nil {
+		return "", nil, err
+	}
+	if ok {
+		return channel, sender, nil
+	}
+	if s.quotaFailover {
+		cost := s.quota.Cost(channel)
+		var cheaper []string
+		for _, c := range append(slices.Sorted(maps.Keys(s.channels)), otp.ChannelSMS) {
+			if s.quota.Cost(c) < cost && s.channelEnabled(c, phoneNumber, tenant) {
+				cheaper = append(cheaper, c)
+			}
+		}
+		slices.SortStableFunc(cheaper, func(a, b string) int {
+			return cmp.Compare(s.quota.Cost(b), s.quota.Cost(a))
+		})
+		for _, c := range cheaper {
+			ok, err := s.quota.Spend(ctx, tenant, c)
+			if err != nil {
+				return "", nil, err
+			}
+			if ok {
+				logging.FromContext(ctx).Info("OTP quota exceeded, sending through a cheaper channel", "channel", channel, "failover_channel", c, "tenant", tenant)
+				failover, _ := s.sender(c)
+				return c, failover, nil
+			}
+		}
+	}
+	logging.FromContext(ctx).Warn("OTP quota exceeded", "channel", channel, "tenant", tenant)
+	return "", nil, ErrQuotaExceeded
+}
+
+// resend sends the OTP again through the fallback channel (see WithAutoResend), unless it
+// was verified or replaced in the meantime, or its delivery was confirmed. The re-send is
+// booked against the quota like any other, but not rate limited.
+func (s *authService) resend(ctx context.Context, client model.ClientInfo, deliveryID uuid.UUID, sent model.OTP) {
+	ctx, cancel := context.WithDeadline(ctx, sent.ExpiresAt)
+	defer cancel()
+	logger := logging.FromContext(ctx)
+
+	stored, err := s.authRepo.GetOTP(ctx, sent.PhoneNumber)
+	if err != nil || stored.OTPCode != sent.OTPCode || s.now().After(sent.ExpiresAt) {
+		return
+	}
+	if s.deliveries != nil {
+		if history, _, stop, ok := s.deliveries.Subscribe(deliveryID); ok {
+			stop()
+			if history[len(history)-1].Status == otp.StatusDelivered {
+				return
+			}
+		}
+	}
+	sender, err := s.sender(s.resendChannel)
+	if err != nil || !s.channelEnabled(s.resendChannel, sent.PhoneNumber, client.Tenant) {
+		return
+	}
+	if s.quota != nil {
+		if ok, err := s.quota.Spend(ctx, client.Tenant, s.resendChannel); err != nil || !ok {
+			logger.Warn("OTP not re-sent, quota exceeded", "phone_number", sent.PhoneNumber, "channel", s.resendChannel, "error", err)
+			return
+		}
+	}
+
+	logger.Info("Re-sending unconfirmed OTP", "phone_number", sent.PhoneNumber, "channel", s.resendChannel)
+	resent := sent
+	resent.Channel = otp.Channel(sender)
+	// The client follows the first delivery; the re-send isn't tracked.
+	_ = s.deliver(ctx, sender, s.resendChannel, client, uuid.Nil, resent)
+}
+
+// recordDeadLetter keeps an OTP that couldn't be delivered, if dead letters are kept.
+func (s *authService) recordDeadLetter(ctx context.Context, letter model.DeadLetter) {
+	if s.deadLetters == nil {
+		return
+	}
+	if err := s.deadLetters.RecordDeadLetter(ctx, letter); err != nil {
+		logging.FromContext(ctx).Error("Failed to record dead letter", "phone_number", letter.PhoneNumber, "error", err)
+	}
+}
+
+// brandingOf returns the sender ID and message prefix of the tenant's OTPs; what the tenant
+// doesn't set comes from the defaults.
+func (s *authService) brandingOf(tenant string) (senderID, messagePrefix string) {
+	brand := s.tenantBrands[tenant]
+	return cmp.Or(brand.SenderID, s.branding.SenderID), cmp.Or(brand.MessagePrefix, s.branding.MessagePrefix)
+}
+
+// refundQuota takes back the booking of an OTP that couldn't be sent.
+func (s *authService) refundQuota(ctx context.Context, channel, tenant string) {
+	if s.quota == nil {
+		return
 	}
+	if err := s.quota.Refund(ctx, tenant, channel); err != nil {
+		logging.FromContext(ctx).Error("Failed to refund OTP quota", "channel", channel, "tenant", tenant, "error", err)
+	}
+}
+
+// sender returns the sender of the channel.
+func (s *authService) sender(channel string) (otp.Sender, error) {
+	if channel == otp.ChannelSMS {
+		return s.otpSender, nil
+	}
+	sender, ok := s.channels[channel]
+	if !ok {
+		return nil, ErrChannelNotEnabled
+	}
+	return sender, nil
+}
+
+// channelEnabled reports whether the feature flag of the channel, if any, is on for the phone
+// number. SMS, the channel of last resort, is always enabled.
+func (s *authService) channelEnabled(channel, phoneNumber, tenant string) bool {
+	if s.flags == nil || channel == otp.ChannelSMS {
+		return true
+	}
+	return s.flags.Enabled(featureflag.ChannelFlag(channel), phoneNumber, tenant, true)
+}
 
-	// 2. OTP is valid, delete it to prevent reuse
+// awaitDelivery records whether the sender confirms the delivery before the OTP expires.
+func (s *authService) awaitDelivery(ctx context.Context, sender otp.Sender, deliveryID uuid.UUID, sent model.OTP) {
+	ctx, cancel := context.WithDeadline(ctx, sent.ExpiresAt)
+	defer cancel()
+
+	err := otp.AwaitDelivery(ctx, sender, sent)
+	switch {
+	case err == nil:
+		s.deliveries.Update(deliveryID, otp.StatusDelivered)
+	case errors.Is(err, otp.ErrUnconfirmed):
+		s.deliveries.Finish(deliveryID)
+	default:
+		logging.FromContext(ctx).Warn("OTP delivery failed", "phone_number", sent.PhoneNumber, "error", err)
+		s.deliveries.Update(deliveryID, otp.StatusFailed)
+	}
+}
+
+func (s *authService) WatchDelivery(id uuid.UUID) ([]otp.DeliveryStatus, <-chan otp.DeliveryStatus, func(), error) {
+	if s.deliveries == nil {
+		return nil, nil, nil, ErrDeliveryNotFound
+	}
+	history, updates, stop, ok := s.deliveries.Subscribe(id)
+	if !ok {
+		return nil, nil, nil, ErrDeliveryNotFound
+	}
+	return history, updates, stop, nil
+}
+
+func (s *authService) VerifyOTPAndAuthenticate(ctx context.Context, phoneNumber, receivedOTP string, client model.ClientInfo) (token string, rateLimit model.RateLimitResult, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.VerifyOTPAndAuthenticate")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	token, _, rateLimit, err = s.verifyOTPAndSignIn(ctx, phoneNumber, receivedOTP, client, uuid.Nil, nil)
+	return token, rateLimit, err
+}
+
+func (s *authService) VerifyOTPAndRestore(ctx context.Context, phoneNumber, receivedOTP string, restore bool, client model.ClientInfo) (token string, rateLimit model.RateLimitResult, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.VerifyOTPAndRestore")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	token, _, rateLimit, err = s.verifyOTPAndSignIn(ctx, phoneNumber, receivedOTP, client, uuid.Nil, &restore)
+	return token, rateLimit, err
+}
+
+func (s *authService) VerifyOTPAndTrustDevice(ctx context.Context, phoneNumber, receivedOTP string, client model.ClientInfo) (token string, deviceToken model.DeviceToken, rateLimit model.RateLimitResult, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.VerifyOTPAndTrustDevice")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	token, user, rateLimit, err := s.verifyOTPAndSignIn(ctx, phoneNumber, receivedOTP, client, uuid.Nil, nil)
+	if err != nil || s.devices == nil || s.trustTTL <= 0 {
+		return token, model.DeviceToken{}, rateLimit, err
+	}
+
+	// The user is signed in either way; without a device token they need an OTP next time.
+	deviceToken, err = s.devices.Trust(ctx, user.ID, client, s.now().Add(s.trustTTL))
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to trust device", "user_id", user.ID, "error", err)
+		return token, model.DeviceToken{}, rateLimit, nil
+	}
+	return token, deviceToken, rateLimit, nil
+}
+
+// verifyOTPAndSignIn checks the OTP and signs its user in, registering them on their first
+// login, with the ID of the guest if guestID is set. If the number's account was deleted
+// within the grace period, restore decides whether it's restored; without a decision the
+// OTP is kept and a *RestorableError returned.
+func (s *authService) verifyOTPAndSignIn(ctx context.Context, phoneNumber, receivedOTP string, client model.ClientInfo, guestID uuid.UUID, restore *bool) (string, model.User, model.RateLimitResult, error) {
+	// 0. Normalize the number, so every formatting of it shares one user and one rate limit
+	phoneNumber, err := s.phones.Load().Normalize(phoneNumber)
+	if err != nil {
+		return "", model.User{}, model.RateLimitResult{}, ErrInvalidPhoneNumber
+	}
+
+	// 1. Check the OTP, and consume it unless the client has to decide about a deleted account
+	storedOTP, rateLimit, err := s.checkOTP(ctx, phoneNumber, receivedOTP, client)
+	if err != nil {
+		return "", model.User{}, rateLimit, err
+	}
+	deleted, err := s.findRestorableUser(ctx, phoneNumber)
+	if err != nil {
+		return "", model.User{}, rateLimit, err
+	}
+	if deleted.ID != uuid.Nil && restore == nil {
+		return "", model.User{}, rateLimit, restorable(deleted.DeletedAt.Add(s.deletionGrace))
+	}
+	s.useOTP(ctx, phoneNumber, storedOTP)
+	if deleted.ID != uuid.Nil && *restore {
+		if err := s.restoreUser(ctx, deleted); err != nil {
+			return "", model.User{}, rateLimit, err
+		}
+	}
+	// Users with a password must have entered it before the OTP was sent. This is checked
+	// after the OTP, so only the owner of the number learns that the account has one.
+	if !storedOTP.PasswordVerified {
+		if err := s.checkPasswordNotRequired(ctx, phoneNumber, client); err != nil {
+			return "", model.User{}, rateLimit, err
+		}
+	}
+
+	// 2. Sign the user in, registering them on their first login
+	token, user, err := s.signIn(ctx, phoneNumber, methodOTP, client, guestID)
+	if err != nil {
+		return "", model.User{}, rateLimit, err
+	}
+	return token, user, rateLimit, nil
+}
+
+func (s *authService) CreateGuest(ctx context.Context, client model.ClientInfo) (token string, guestID uuid.UUID, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.CreateGuest")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	if s.guestTTL <= 0 {
+		return "", uuid.Nil, ErrGuestsDisabled
+	}
+
+	// Guests aren't stored; the ID only becomes a user once the guest verifies a phone number.
+	guestID = uuid.New()
+	token, err = s.signJWT(middleware.NewGuestClaims(guestID, s.now(), s.guestTTL))
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to generate guest JWT", "error", err)
+		return "", uuid.Nil, ErrJWTGeneration
+	}
+	logging.FromContext(ctx).Info("Guest session started", "guest_id", guestID, "ip", client.IP)
+	return token, guestID, nil
+}
+
+func (s *authService) UpgradeGuest(ctx context.Context, guestID uuid.UUID, phoneNumber, receivedOTP string, client model.ClientInfo) (token string, upgraded bool, rateLimit model.RateLimitResult, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.UpgradeGuest")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	token, user, rateLimit, err := s.verifyOTPAndSignIn(ctx, phoneNumber, receivedOTP, client, guestID, nil)
+	if err != nil {
+		return "", false, rateLimit, err
+	}
+	return token, user.ID == guestID, rateLimit, nil
+}
+
+func (s *authService) SignInTrustedDevice(ctx context.Context, phoneNumber, deviceToken string, client model.ClientInfo) (token string, rateLimit model.RateLimitResult, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.SignInTrustedDevice")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	phoneNumber, err = s.phones.Load().Normalize(phoneNumber)
+	if err != nil {
+		return "", rateLimit, ErrInvalidPhoneNumber
+	}
+
+	// Device tokens can't be guessed, but attempts count against the verification limit anyway.
+	rateLimit = s.authRepo.AllowOTPVerifyRate(phoneNumber + "|" + client.IP)
+	if !rateLimit.Allowed {
+		s.count(ctx, model.CounterOTPVerifyRateLimited)
+		s.loginFailed(ctx, phoneNumber, client, methodTrustedDevice, "rate_limited")
+		return "", rateLimit, rateLimited(ErrVerifyRateLimited, rateLimit)
+	}
+	if s.devices == nil || s.trustTTL <= 0 {
+		return "", rateLimit, ErrInvalidDeviceToken
+	}
+
+	// Only existing users can have trusted devices; signIn would register unknown numbers.
+	user, err := s.authRepo.GetUserByPhoneNumber(ctx, phoneNumber)
+	if errors.Is(err, ErrUserNotFound) {
+		s.loginFailed(ctx, phoneNumber, client, methodTrustedDevice, "invalid_device_token")
+		return "", rateLimit, ErrInvalidDeviceToken
+	}
+	if err != nil {
+		return "", rateLimit, err
+	}
+	trusted, err := s.devices.IsTrusted(ctx, user.ID, client, deviceToken)
+	if err != nil {
+		return "", rateLimit, fmt.Errorf("failed to check device trust: %w", err)
+	}
+	if !trusted {
+		s.loginFailed(ctx, phoneNumber, client, methodTrustedDevice, "invalid_device_token")
+		return "", rateLimit, ErrInvalidDeviceToken
+	}
+
+	token, _, err = s.signIn(ctx, phoneNumber, methodTrustedDevice, client, uuid.Nil)
+	if err != nil {
+		return "", rateLimit, err
+	}
+	return token, rateLimit, nil
+}
+
+// consumeOTP checks the OTP sent to the phone number, which must be normalized, and deletes
+// it once it matched. Every attempt counts against the verification rate limit and the
+// brute-force detection, and failures are recorded as failed logins.
+func (s *authService) consumeOTP(ctx context.Context, phoneNumber, receivedOTP string, client model.ClientInfo) (model.OTP, model.RateLimitResult, error) {
+	storedOTP, rateLimit, err := s.checkOTP(ctx, phoneNumber, receivedOTP, client)
+	if err != nil {
+		return model.OTP{}, rateLimit, err
+	}
+	s.useOTP(ctx, phoneNumber, storedOTP)
+	return storedOTP, rateLimit, nil
+}
+
+// checkOTP is consumeOTP without deleting the OTP.
+func (s *authService) checkOTP(ctx context.Context, phoneNumber, receivedOTP string, client model.ClientInfo) (storedOTP model.OTP, rateLimit model.RateLimitResult, err error) {
+	// Check Rate Limit, keyed by phone number and IP so guessing codes is throttled
+	// without letting one client lock out the owner of the number everywhere.
+	rateLimit = s.authRepo.AllowOTPVerifyRate(phoneNumber + "|" + client.IP)
+	if !rateLimit.Allowed {
+		s.count(ctx, model.CounterOTPVerifyRateLimited)
+		s.loginFailed(ctx, phoneNumber, client, methodOTP, "rate_limited")
+		return model.OTP{}, rateLimit, rateLimited(ErrVerifyRateLimited, rateLimit)
+	}
+	// IPs and numbers caught in a brute-force attack are blocked on top of the regular limit.
+	if blockedUntil := s.authRepo.VerifyBlockedUntil(client.IP, phoneNumber); !blockedUntil.IsZero() {
+		rateLimit.Allowed = false
+		rateLimit.Remaining = 0
+		rateLimit.RetryAfter = blockedUntil.Sub(s.now())
+		if blockedUntil.After(rateLimit.ResetAt) {
+			rateLimit.ResetAt = blockedUntil
+		}
+		s.count(ctx, model.CounterOTPVerifyRateLimited)
+		s.loginFailed(ctx, phoneNumber, client, methodOTP, "blocked")
+		return model.OTP{}, rateLimit, rateLimited(ErrVerifyRateLimited, rateLimit)
+	}
+	if err = s.evaluateRisk(ctx, RiskAssessment{
+		Action: RiskActionVerifyOTP, PhoneNumber: phoneNumber, Client: client, Velocity: rateLimit,
+	}); err != nil {
+		s.loginFailed(ctx, phoneNumber, client, methodOTP, "risk_refused")
+		return model.OTP{}, rateLimit, err
+	}
+
+	// Retrieve and Validate OTP
+	storedOTP, err = s.authRepo.GetOTP(ctx, phoneNumber)
+	if err != nil || storedOTP.OTPCode != receivedOTP || s.now().After(storedOTP.ExpiresAt) {
+		s.count(ctx, model.CounterVerificationFailed)
+		s.loginFailed(ctx, phoneNumber, client, methodOTP, "invalid_otp")
+		for _, anomaly := range s.authRepo.RecordVerifyFailure(client.IP, phoneNumber) {
+			s.publishBruteForceDetected(ctx, anomaly)
+		}
+		return model.OTP{}, rateLimit, ErrInvalidOTP
+	}
+	return storedOTP, rateLimit, nil
+}
+
+// useOTP deletes the OTP that checkOTP accepted and counts the successful verification.
+func (s *authService) useOTP(ctx context.Context, phoneNumber string, storedOTP model.OTP) {
+	// OTP is valid, delete it to prevent reuse
 	// We can ignore the error here for now, as the main flow can continue.
-	_ = s.authRepo.DeleteOTP(phoneNumber)
+	_ = s.authRepo.DeleteOTP(ctx, phoneNumber)
+	metrics.OTPFunnel.Add(storedOTP.Channel, phone.CountryCode(phoneNumber), metrics.StageVerified)
+	s.count(ctx, model.CounterVerificationSucceeded)
+}
 
-	// 3. Find or Create User
-	user, err := s.authRepo.GetUserByPhoneNumber(phoneNumber)
+// findRestorableUser returns the account deleted within the grace period whose phone number
+// has no user now, or a zero user if there is none.
+func (s *authService) findRestorableUser(ctx context.Context, phoneNumber string) (model.User, error) {
+	if s.deletionGrace <= 0 {
+		return model.User{}, nil
+	}
+	_, err := s.authRepo.GetUserByPhoneNumber(ctx, phoneNumber)
+	if err == nil {
+		return model.User{}, nil
+	}
+	if !errors.Is(err, ErrUserNotFound) {
+		return model.User{}, fmt.Errorf("failed to get user by phone number: %w", err)
+	}
+	deleted, err := s.authRepo.GetDeletedUserByPhoneNumber(ctx, phoneNumber, s.now().Add(-s.deletionGrace))
+	if errors.Is(err, ErrUserNotFound) {
+		return model.User{}, nil
+	}
+	if err != nil {
+		return model.User{}, fmt.Errorf("failed to get deleted user: %w", err)
+	}
+	return deleted, nil
+}
+
+// restoreUser undeletes the account and publishes user.restored. An account that was restored
+// or purged in the meantime, or whose number registered again, is left as it is.
+func (s *authService) restoreUser(ctx context.Context, deleted model.User) error {
+	err := s.authRepo.RestoreUser(ctx, deleted.ID)
+	if errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrPhoneNumberTaken) {
+		logging.FromContext(ctx).Warn("Deleted account can't be restored anymore", "user_id", deleted.ID, "error", err)
+		return nil
+	}
 	if err != nil {
+		return fmt.Errorf("failed to restore user: %w", err)
+	}
+	logging.FromContext(ctx).Info("User restored their account", "user_id", deleted.ID)
+	s.events.Publish(model.NewEvent(model.EventUserRestored, map[string]interface{}{
+		"user_id":      deleted.ID,
+		"phone_number": deleted.PhoneNumber,
+	}))
+	return nil
+}
+
+func (s *authService) InvalidateOTP(ctx context.Context, phoneNumber, invalidatedBy, reason string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.InvalidateOTP")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	phoneNumber, err = s.phones.Load().Normalize(phoneNumber)
+	if err != nil {
+		return ErrInvalidPhoneNumber
+	}
+	pending, err := s.authRepo.GetOTP(ctx, phoneNumber)
+	if err != nil {
+		return err
+	}
+	if s.now().After(pending.ExpiresAt) {
+		return ErrOTPNotFound
+	}
+	if err := s.authRepo.DeleteOTP(ctx, phoneNumber); err != nil {
+		return fmt.Errorf("failed to delete OTP: %w", err)
+	}
+
+	logging.FromContext(ctx).Info("Invalidated pending OTP", "phone_number", phoneNumber, "invalidated_by", invalidatedBy)
+	s.events.Publish(model.NewEvent(model.EventOTPInvalidated, map[string]interface{}{
+		"phone_number":   phoneNumber,
+		"channel":        pending.Channel,
+		"invalidated_by": invalidatedBy,
+		"reason":         reason,
+	}))
+	return nil
+}
+
+func (s *authService) ForceLogout(ctx context.Context, userID uuid.UUID, loggedOutBy, reason string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.ForceLogout")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	u, err := s.authRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	// Unlike on deletion the account lives on, so the revocation has to go through.
+	untrusted, err := s.endSessions(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+
+	logging.FromContext(ctx).Info("Logged user out everywhere", "user_id", u.ID, "logged_out_by", loggedOutBy, "untrusted_devices", untrusted)
+	s.events.Publish(model.NewEvent(model.EventUserLoggedOut, map[string]interface{}{
+		"user_id":           u.ID,
+		"logged_out_by":     loggedOutBy,
+		"reason":            reason,
+		"untrusted_devices": untrusted,
+	}))
+	return nil
+}
+
+// bulkBatchSize is how many users BulkUpdateUsers updates in one transaction.
+const bulkBatchSize = 100
+
+func (s *authService) BulkUpdateUsers(ctx context.Context, action string, ids []uuid.UUID, actor, reason string) (results []model.UserBulkResult, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.BulkUpdateUsers")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	var apply func(ctx context.Context, batch []uuid.UUID) ([]uuid.UUID, error)
+	switch action {
+	case model.BulkActionDelete:
+		apply = func(ctx context.Context, batch []uuid.UUID) ([]uuid.UUID, error) {
+			return s.bulkDelete(ctx, batch, actor, reason)
+		}
+	case model.BulkActionBan:
+		now := s.now()
+		apply = func(ctx context.Context, batch []uuid.UUID) ([]uuid.UUID, error) {
+			return s.bulkBan(ctx, batch, &now, actor, reason)
+		}
+	case model.BulkActionUnban:
+		apply = func(ctx context.Context, batch []uuid.UUID) ([]uuid.UUID, error) {
+			return s.bulkBan(ctx, batch, nil, actor, reason)
+		}
+	default:
+		return nil, ErrInvalidBulkAction
+	}
+
+	// Users listed twice are updated once.
+	status := make(map[uuid.UUID]string, len(ids))
+	var unique []uuid.UUID
+	for _, id := range ids {
+		if _, ok := status[id]; !ok {
+			status[id] = model.BulkStatusNotFound
+			unique = append(unique, id)
+		}
+	}
+	logger := logging.FromContext(ctx)
+	for batch := range slices.Chunk(unique, bulkBatchSize) {
+		done, err := apply(ctx, batch)
+		if err != nil {
+			logger.Error("Failed to apply bulk action", "action", action, "users", len(batch), "error", err)
+			for _, id := range batch {
+				status[id] = model.BulkStatusFailed
+			}
+			continue
+		}
+		for _, id := range done {
+			status[id] = model.BulkStatusDone
+		}
+	}
+
+	results = make([]model.UserBulkResult, len(ids))
+	done := 0
+	for i, id := range ids {
+		results[i] = model.UserBulkResult{UserID: id, Status: status[id]}
+		if status[id] == model.BulkStatusDone {
+			done++
+		}
+	}
+	logger.Info("Applied bulk action", "action", action, "users", len(ids), "done", done, "actor", actor)
+	return results, nil
+}
+
+// bulkDelete deletes a batch of users and returns the IDs of those deleted.
+func (s *authService) bulkDelete(ctx context.Context, batch []uuid.UUID, actor, reason string) ([]uuid.UUID, error) {
+	logger := logging.FromContext(ctx)
+	// The phone numbers are gone from the users once deleted.
+	users, err := s.authRepo.GetUsersByIDs(ctx, batch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	phoneNumbers := make(map[uuid.UUID]string, len(users))
+	for _, u := range users {
+		phoneNumbers[u.ID] = u.PhoneNumber
+	}
+
+	deleted, err := s.authRepo.DeleteUsers(ctx, batch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete users: %w", err)
+	}
+	for _, id := range deleted {
+		// Like in DeleteAccount, the users are gone either way, so the cleanup only gets logged.
+		if phoneNumber := phoneNumbers[id]; phoneNumber != "" {
+			if err := s.authRepo.DeleteOTP(ctx, phoneNumber); err != nil {
+				logger.Error("Failed to purge OTPs of deleted user", "user_id", id, "error", err)
+			}
+		}
+		if s.tokens != nil {
+			if err := s.tokens.RevokeTokens(ctx, id, s.now()); err != nil {
+				logger.Error("Failed to revoke tokens of deleted user", "user_id", id, "error", err)
+			}
+		}
+		payload := map[string]interface{}{
+			"user_id":      id,
+			"phone_number": phoneNumbers[id],
+			"deleted_by":   actor,
+			"reason":       reason,
+		}
+		if s.deletionGrace > 0 {
+			payload["purge_at"] = s.now().Add(s.deletionGrace)
+		}
+		s.events.Publish(model.NewEvent(model.EventUserDeleted, payload))
+	}
+	return deleted, nil
+}
+
+// bulkBan bans a batch of users, or lifts their ban if bannedAt is nil, and returns the IDs of
+// those updated. Banned users whose sessions couldn't be ended are left out, so they're
+// reported failed and the ban can be repeated.
+func (s *authService) bulkBan(ctx context.Context, batch []uuid.UUID, bannedAt *time.Time, actor, reason string) ([]uuid.UUID, error) {
+	updated, err := s.authRepo.SetUsersBanned(ctx, batch, bannedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update bans: %w", err)
+	}
+	if bannedAt == nil {
+		for _, id := range updated {
+			s.events.Publish(model.NewEvent(model.EventUserUnbanned, map[string]interface{}{
+				"user_id":     id,
+				"unbanned_by": actor,
+				"reason":      reason,
+			}))
+		}
+		return updated, nil
+	}
+
+	logger := logging.FromContext(ctx)
+	banned := make([]uuid.UUID, 0, len(updated))
+	for _, id := range updated {
+		if _, err := s.endSessions(ctx, id); err != nil {
+			logger.Error("Failed to log banned user out", "user_id", id, "error", err)
+			continue
+		}
+		banned = append(banned, id)
+		s.events.Publish(model.NewEvent(model.EventUserBanned, map[string]interface{}{
+			"user_id":   id,
+			"banned_by": actor,
+			"reason":    reason,
+		}))
+	}
+	return banned, nil
+}
+
+// endSessions revokes the tokens of the user and the trust in their devices, which would sign
+// them in without an OTP. It returns how many devices were untrusted.
+func (s *authService) endSessions(ctx context.Context, userID uuid.UUID) (int, error) {
+	if s.tokens != nil {
+		if err := s.tokens.RevokeTokens(ctx, userID, s.now()); err != nil {
+			return 0, fmt.Errorf("failed to revoke tokens: %w", err)
+		}
+	}
+	if s.devices == nil {
+		return 0, nil
+	}
+	untrusted, err := s.devices.RevokeAllTrust(ctx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke trusted devices: %w", err)
+	}
+	return untrusted, nil
+}
+
+func (s *authService) DeleteAccount(ctx context.Context, userID uuid.UUID, receivedOTP string, client model.ClientInfo) (rateLimit model.RateLimitResult, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.DeleteAccount")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	logger := logging.FromContext(ctx)
+
+	u, err := s.authRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return rateLimit, err
+	}
+
+	// The deletion is confirmed with an OTP, so a stolen token alone can't delete the account.
+	_, rateLimit, err = s.consumeOTP(ctx, u.PhoneNumber, receivedOTP, client)
+	if err != nil {
+		return rateLimit, err
+	}
+
+	if err = s.authRepo.DeleteUser(ctx, u.ID); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return rateLimit, err
+		}
+		return rateLimit, fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	// Purge an OTP requested in the meantime, it would sign a new account up.
+	if err := s.authRepo.DeleteOTP(ctx, u.PhoneNumber); err != nil {
+		logger.Error("Failed to purge OTPs of deleted user", "user_id", u.ID, "error", err)
+	}
+	// The account is gone either way, so failing to revoke its tokens only gets logged.
+	if s.tokens != nil {
+		if err := s.tokens.RevokeTokens(ctx, u.ID, s.now()); err != nil {
+			logger.Error("Failed to revoke tokens of deleted user", "user_id", u.ID, "error", err)
+		}
+	}
+
+	logger.Info("User deleted their account", "user_id", u.ID)
+	payload := map[string]interface{}{
+		"user_id":      u.ID,
+		"phone_number": u.PhoneNumber,
+	}
+	if s.deletionGrace > 0 {
+		payload["purge_at"] = s.now().Add(s.deletionGrace)
+	}
+	s.events.Publish(model.NewEvent(model.EventUserDeleted, payload))
+	return rateLimit, nil
+}
+
+func (s *authService) ReverifyPhone(ctx context.Context, userID uuid.UUID, receivedOTP string, client model.ClientInfo) (token string, rateLimit model.RateLimitResult, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.ReverifyPhone")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	u, err := s.authRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", rateLimit, err
+	}
+
+	_, rateLimit, err = s.consumeOTP(ctx, u.PhoneNumber, receivedOTP, client)
+	if err != nil {
+		return "", rateLimit, err
+	}
+
+	now := s.now()
+	if err = s.authRepo.MarkPhoneVerified(ctx, u.ID, now); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return "", rateLimit, err
+		}
+		return "", rateLimit, fmt.Errorf("failed to mark phone number verified: %w", err)
+	}
+	u.PhoneVerifiedAt = &now
+
+	token, err = s.generateJWT(u)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to generate JWT", "user_id", u.ID, "error", err)
+		return "", rateLimit, ErrJWTGeneration
+	}
+	logging.FromContext(ctx).Info("User re-verified their phone number", "user_id", u.ID)
+	return token, rateLimit, nil
+}
+
+func (s *authService) AddPhoneNumber(ctx context.Context, userID uuid.UUID, phoneNumber string, client model.ClientInfo) (deliveryID uuid.UUID, rateLimit model.RateLimitResult, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.AddPhoneNumber")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	normalized, err := s.phones.Load().Normalize(phoneNumber)
+	if err != nil {
+		return uuid.Nil, rateLimit, ErrInvalidPhoneNumber
+	}
+	// Checked again on verification; this only saves sending a useless OTP.
+	if err = s.checkPhoneNumberFree(ctx, normalized); err != nil {
+		return uuid.Nil, rateLimit, err
+	}
+	return s.SendOTP(ctx, normalized, client)
+}
+
+func (s *authService) VerifyPhoneNumber(ctx context.Context, userID uuid.UUID, phoneNumber, receivedOTP string, client model.ClientInfo) (phone model.UserPhone, rateLimit model.RateLimitResult, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.VerifyPhoneNumber")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	phoneNumber, err = s.phones.Load().Normalize(phoneNumber)
+	if err != nil {
+		return model.UserPhone{}, rateLimit, ErrInvalidPhoneNumber
+	}
+	if err = s.checkPhoneNumberFree(ctx, phoneNumber); err != nil {
+		return model.UserPhone{}, rateLimit, err
+	}
+
+	_, rateLimit, err = s.consumeOTP(ctx, phoneNumber, receivedOTP, client)
+	if err != nil {
+		return model.UserPhone{}, rateLimit, err
+	}
+
+	now := s.now()
+	phone, err = s.authRepo.AddPhoneNumber(ctx, model.UserPhone{UserID: userID, PhoneNumber: phoneNumber, VerifiedAt: &now})
+	if err != nil {
+		if errors.Is(err, ErrPhoneNumberTaken) || errors.Is(err, ErrUserNotFound) {
+			return model.UserPhone{}, rateLimit, err
+		}
+		return model.UserPhone{}, rateLimit, fmt.Errorf("failed to add phone number: %w", err)
+	}
+	logging.FromContext(ctx).Info("User added a phone number", "user_id", userID, "phone_number", phoneNumber)
+	return phone, rateLimit, nil
+}
+
+// checkPhoneNumberFree returns ErrPhoneNumberTaken if the normalized phone number is the
+// primary or a secondary number of any user.
+func (s *authService) checkPhoneNumberFree(ctx context.Context, phoneNumber string) error {
+	_, err := s.authRepo.GetUserByPhoneNumber(ctx, phoneNumber)
+	if err == nil {
+		return ErrPhoneNumberTaken
+	}
+	if !errors.Is(err, ErrUserNotFound) {
+		return fmt.Errorf("failed to look up phone number: %w", err)
+	}
+	return nil
+}
+
+func (s *authService) SetPassword(ctx context.Context, userID uuid.UUID, currentPassword, newPassword string, client model.ClientInfo) (rateLimit model.RateLimitResult, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.SetPassword")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	current, err := s.authRepo.GetPasswordHash(ctx, userID)
+	if err != nil {
+		return rateLimit, err
+	}
+	if current != "" {
+		// A stolen token alone can't replace the password; guessing it is throttled per user.
+		rateLimit = s.authRepo.AllowOTPVerifyRate(userID.String() + "|" + client.IP)
+		if !rateLimit.Allowed {
+			return rateLimit, rateLimited(ErrVerifyRateLimited, rateLimit)
+		}
+		ok, err := password.Verify(currentPassword, current)
+		if err != nil {
+			return rateLimit, fmt.Errorf("failed to check password: %w", err)
+		}
+		if !ok {
+			return rateLimit, ErrInvalidCredentials
+		}
+	}
+
+	hash, err := password.Hash(newPassword, password.DefaultParams)
+	if err != nil {
+		return rateLimit, err
+	}
+	if err = s.authRepo.SetPasswordHash(ctx, userID, hash); err != nil {
 		if errors.Is(err, ErrUserNotFound) {
-			// User does not exist, register them
-			newUser := model.User{PhoneNumber: phoneNumber}
-			createdUser, createErr := s.authRepo.CreateUser(newUser)
+			return rateLimit, err
+		}
+		return rateLimit, fmt.Errorf("failed to set password: %w", err)
+	}
+	logging.FromContext(ctx).Info("User set their password", "user_id", userID, "changed", current != "")
+	return rateLimit, nil
+}
+
+func (s *authService) SendPasswordOTP(ctx context.Context, phoneNumber, pw string, client model.ClientInfo) (deliveryID uuid.UUID, rateLimit model.RateLimitResult, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.SendPasswordOTP")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	phoneNumber, err = s.phones.Load().Normalize(phoneNumber)
+	if err != nil {
+		return uuid.Nil, rateLimit, ErrInvalidPhoneNumber
+	}
+
+	// Password guesses share the limits and brute-force detection of OTP guesses.
+	rateLimit = s.authRepo.AllowOTPVerifyRate(phoneNumber + "|" + client.IP)
+	if !rateLimit.Allowed {
+		s.count(ctx, model.CounterOTPVerifyRateLimited)
+		s.loginFailed(ctx, phoneNumber, client, methodPassword, "rate_limited")
+		return uuid.Nil, rateLimit, rateLimited(ErrVerifyRateLimited, rateLimit)
+	}
+	if blockedUntil := s.authRepo.VerifyBlockedUntil(client.IP, phoneNumber); !blockedUntil.IsZero() {
+		rateLimit.Allowed = false
+		rateLimit.Remaining = 0
+		rateLimit.RetryAfter = blockedUntil.Sub(s.now())
+		s.loginFailed(ctx, phoneNumber, client, methodPassword, "blocked")
+		return uuid.Nil, rateLimit, rateLimited(ErrVerifyRateLimited, rateLimit)
+	}
+
+	hash := ""
+	user, err := s.authRepo.GetUserByPhoneNumber(ctx, phoneNumber)
+	if err == nil {
+		hash, err = s.authRepo.GetPasswordHash(ctx, user.ID)
+	}
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return uuid.Nil, rateLimit, fmt.Errorf("failed to get password: %w", err)
+	}
+	ok := false
+	if hash != "" {
+		ok, err = password.Verify(pw, hash)
+		if err != nil {
+			return uuid.Nil, rateLimit, fmt.Errorf("failed to check password: %w", err)
+		}
+	} else {
+		// Hash anyway, so the response time doesn't tell which numbers have a password.
+		_, _ = password.Verify(pw, dummyPasswordHash())
+	}
+	if !ok {
+		s.loginFailed(ctx, phoneNumber, client, methodPassword, "invalid_password")
+		for _, anomaly := range s.authRepo.RecordVerifyFailure(client.IP, phoneNumber) {
+			s.publishBruteForceDetected(ctx, anomaly)
+		}
+		return uuid.Nil, rateLimit, ErrInvalidCredentials
+	}
+
+	return s.sendOTP(ctx, phoneNumber, otp.ChannelSMS, client, true)
+}
+
+// checkPasswordNotRequired returns ErrPasswordRequired if the user of the normalized phone
+// number has a password.
+func (s *authService) checkPasswordNotRequired(ctx context.Context, phoneNumber string, client model.ClientInfo) error {
+	user, err := s.authRepo.GetUserByPhoneNumber(ctx, phoneNumber)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get user by phone number: %w", err)
+	}
+	hash, err := s.authRepo.GetPasswordHash(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get password: %w", err)
+	}
+	if hash != "" {
+		s.loginFailed(ctx, phoneNumber, client, methodOTP, "password_required")
+		return ErrPasswordRequired
+	}
+	return nil
+}
+
+// dummyPasswordHash is verified against when there is no password to check.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := password.Hash("", password.DefaultParams)
+	return hash
+})
+
+func (s *authService) SignInVerified(ctx context.Context, phoneNumber, method string, client model.ClientInfo) (token string, user model.User, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "auth.SignInVerified")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	phoneNumber, err = s.phones.Load().Normalize(phoneNumber)
+	if err != nil {
+		return "", model.User{}, ErrInvalidPhoneNumber
+	}
+	return s.signIn(ctx, phoneNumber, method, client, uuid.Nil)
+}
+
+// createUser registers the user and publishes user.created, through the outbox if there is one.
+func (s *authService) createUser(ctx context.Context, user model.User) (model.User, error) {
+	if s.outbox != nil {
+		return s.outbox.CreateUserWithEvent(ctx, user, userCreatedEvent)
+	}
+	user, err := s.authRepo.CreateUser(ctx, user)
+	if err != nil {
+		return model.User{}, err
+	}
+	s.events.Publish(userCreatedEvent(user))
+	return user, nil
+}
+
+func userCreatedEvent(user model.User) model.Event {
+	return model.NewEvent(model.EventUserCreated, map[string]interface{}{
+		"user_id":      user.ID,
+		"phone_number": user.PhoneNumber,
+	})
+}
+
+// signIn finds or registers the user of a verified phone number and issues their JWT. A new
+// user gets the ID of the guest signing up, unless guestID is uuid.Nil. OTP sign-ins renew
+// the user's phone verification; other methods don't prove they own the number.
+func (s *authService) signIn(ctx context.Context, phoneNumber, method string, client model.ClientInfo, guestID uuid.UUID) (string, model.User, error) {
+	logger := logging.FromContext(ctx)
+
+	var verifiedAt *time.Time
+	if method == methodOTP {
+		now := s.now()
+		verifiedAt = &now
+	}
+
+	newUser := false
+	user, err := s.authRepo.GetUserByPhoneNumber(ctx, phoneNumber)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			// User does not exist, register them, as the guest signing up if there is one
+			createdUser, createErr := s.createUser(ctx, model.User{ID: guestID, PhoneNumber: phoneNumber, PhoneVerifiedAt: verifiedAt})
+			if createErr != nil && guestID != uuid.Nil {
+				// The guest was upgraded before, with another number.
+				logger.Warn("Could not keep the guest's ID", "guest_id", guestID, "error", createErr)
+				createdUser, createErr = s.createUser(ctx, model.User{PhoneNumber: phoneNumber, PhoneVerifiedAt: verifiedAt})
+			}
 			if createErr != nil {
-				log.Printf("ERROR: Failed to create user for %s: %v", phoneNumber, createErr)
-				return "", ErrUserRegistration
+				logger.Error("Failed to create user", "phone_number", phoneNumber, "error", createErr)
+				return "", model.User{}, ErrUserRegistration
 			}
 			user = createdUser
-			log.Printf("New user registered: %s (ID: %s)", user.PhoneNumber, user.ID)
+			newUser = true
+			logger.Info("New user registered", "phone_number", user.PhoneNumber, "user_id", user.ID)
 		} else {
 			// A different database error occurred
-			log.Printf("ERROR: Failed to get user by phone %s: %v", phoneNumber, err)
-			return "", err
+			logger.Error("Failed to get user by phone number", "phone_number", phoneNumber, "error", err)
+			return "", model.User{}, err
 		}
+	} else if user.BannedAt != nil {
+		logger.Info("Banned user tried to sign in", "user_id", user.ID, "method", method)
+		s.loginFailed(ctx, phoneNumber, client, method, "banned")
+		return "", model.User{}, ErrUserBanned
 	} else {
-		log.Printf("Existing user logged in: %s (ID: %s)", user.PhoneNumber, user.ID)
+		logger.Info("Existing user logged in", "phone_number", user.PhoneNumber, "user_id", user.ID)
+		if verifiedAt != nil {
+			// The OTP was verified either way, so failing to save that only gets logged.
+			if err := s.authRepo.MarkPhoneVerified(ctx, user.ID, *verifiedAt); err != nil {
+				logger.Error("Failed to mark phone number verified", "user_id", user.ID, "error", err)
+			}
+			user.PhoneVerifiedAt = verifiedAt
+		}
 	}
 
-	// 4. Generate JWT Token
-	token, err := s.generateJWT(user.ID, user.PhoneNumber)
+	// Generate JWT Token
+	token, err := s.generateJWT(user)
 	if err != nil {
-		log.Printf("ERROR: Failed to generate JWT for user %s: %v", user.ID, err)
-		return "", ErrJWTGeneration
+		logger.Error("Failed to generate JWT", "user_id", user.ID, "error", err)
+		return "", model.User{}, ErrJWTGeneration
 	}
 
-	return token, nil
+	// Remember the device and the login; failing to do so must not fail the login.
+	login := model.Login{
+		UserID:    user.ID,
+		Succeeded: true,
+		Method:    method,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		CreatedAt: s.now(),
+	}
+	if s.devices != nil {
+		device, unseen, err := s.devices.Track(ctx, user, client)
+		if err != nil {
+			logger.Error("Failed to record device", "user_id", user.ID, "error", err)
+		}
+		// A device registered concurrently by another sign-in comes back without an ID.
+		if device.ID != uuid.Nil {
+			login.DeviceID = &device.ID
+		}
+		if unseen {
+			logger.Info("Login from a new device", "user_id", user.ID, "device_id", device.ID)
+			s.events.Publish(model.NewEvent(model.EventNewDevice, map[string]interface{}{
+				"user_id":      user.ID,
+				"phone_number": user.PhoneNumber,
+				"device_id":    device.ID,
+				"user_agent":   device.UserAgent,
+				"ip":           client.IP,
+			}))
+		}
+	}
+
+	s.recordLogin(ctx, login)
+
+	s.events.Publish(model.NewEvent(model.EventLoginSucceeded, map[string]interface{}{
+		"user_id":      user.ID,
+		"phone_number": user.PhoneNumber,
+		"ip":           client.IP,
+		"new_user":     newUser,
+		"method":       method,
+		"from_guest":   guestID != uuid.Nil && user.ID == guestID,
+	}))
+
+	return token, user, nil
+}
+
+// loginFailed emits a login.failed event with the method and reason of the failure and, if
+// the phone number belongs to a user, adds the attempt to their login history.
+func (s *authService) loginFailed(ctx context.Context, phoneNumber string, client model.ClientInfo, method, reason string) {
+	s.events.Publish(model.NewEvent(model.EventLoginFailed, map[string]interface{}{
+		"phone_number": phoneNumber,
+		"ip":           client.IP,
+		"method":       method,
+		"reason":       reason,
+	}))
+
+	if s.logins == nil {
+		return
+	}
+	user, err := s.authRepo.GetUserByPhoneNumber(ctx, phoneNumber)
+	if err != nil {
+		// Attempts on numbers without a user have no history to go to.
+		if !errors.Is(err, ErrUserNotFound) {
+			logging.FromContext(ctx).Error("Failed to get user by phone number", "phone_number", phoneNumber, "error", err)
+		}
+		return
+	}
+	s.recordLogin(ctx, model.Login{
+		UserID:    user.ID,
+		Method:    method,
+		Reason:    reason,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		CreatedAt: s.now(),
+	})
+}
+
+// count adds one to a statistics counter, if they are kept. Errors are only logged.
+func (s *authService) count(ctx context.Context, counter string) {
+	if s.stats == nil {
+		return
+	}
+	if err := s.stats.Count(ctx, counter); err != nil {
+		logging.FromContext(ctx).Error("Failed to count", "counter", counter, "error", err)
+	}
+}
+
+// recordLogin adds the login to the history, if one is kept. Errors are only logged.
+func (s *authService) recordLogin(ctx context.Context, login model.Login) {
+	if s.logins == nil {
+		return
+	}
+	if err := s.logins.RecordLogin(ctx, login); err != nil {
+		logging.FromContext(ctx).Error("Failed to record login", "user_id", login.UserID, "error", err)
+	}
+}
+
+// publishBruteForceDetected logs a detected attack and emits an event for alerting.
+func (s *authService) publishBruteForceDetected(ctx context.Context, anomaly model.Anomaly) {
+	logging.FromContext(ctx).Warn("Brute-force attack detected",
+		"pattern", anomaly.Pattern, "ip", anomaly.IP, "phone_number", anomaly.PhoneNumber,
+		"count", anomaly.Count, "blocked_until", anomaly.BlockedUntil)
+	s.events.Publish(model.NewEvent(model.EventBruteForceDetected, map[string]interface{}{
+		"pattern":       anomaly.Pattern,
+		"ip":            anomaly.IP,
+		"phone_number":  anomaly.PhoneNumber,
+		"count":         anomaly.Count,
+		"blocked_until": anomaly.BlockedUntil,
+	}))
 }
 
 // generateJWT creates a new JWT token for a given user.
-func (s *authService) generateJWT(userID uuid.UUID, phoneNumber string) (string, error) {
-	// Create the claims
-	claims := jwt.MapClaims{
-		"sub":   userID.String(),                       // Subject (user ID)
-		"phone": phoneNumber,                           // Custom claim
-		"iat":   time.Now().Unix(),                     // Issued At
-		"exp":   time.Now().Add(time.Hour * 24).Unix(), // Expiration Time (24 hours)
+func (s *authService) generateJWT(user model.User) (string, error) {
+	claims := middleware.NewClaims(user.ID, user.PhoneNumber, s.now(), TokenTTL)
+	if user.PhoneVerifiedAt != nil {
+		claims.PhoneVerifiedAt = jwt.NewNumericDate(*user.PhoneVerifiedAt)
 	}
+	return s.signJWT(claims)
+}
 
+// signJWT signs the claims with the current secret.
+func (s *authService) signJWT(claims *middleware.Claims) (string, error) {
 	// Create token
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
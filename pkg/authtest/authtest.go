@@ -0,0 +1,48 @@
+// Package authtest provides in-memory fakes of the auth service's dependencies, for unit
+// tests of code built on the service that shouldn't need a database, Redis or an SMS provider:
+//
+//	f := authtest.New()
+//	svc := f.Service("test-secret")
+//	svc.SendOTP(ctx, "+14155552671", model.ClientInfo{})
+//	code, _ := f.Sender.LastCode("+14155552671") // authtest.DefaultOTP
+//	token, _, err := svc.VerifyOTPAndAuthenticate(ctx, "+14155552671", code, model.ClientInfo{})
+package authtest
+
+import (
+	"github.com/ebipenman/go-otp-auth-service/pkg/auth"
+	"github.com/ebipenman/go-otp-auth-service/pkg/otp"
+	"github.com/ebipenman/go-otp-auth-service/pkg/user"
+)
+
+// Fixture is a set of fakes wired into auth services by Service. Tests adjust the fakes,
+// e.g. SendLimiter or Sender.FailWith, before or between calls.
+type Fixture struct {
+	Users         *UserStore
+	OTPs          *OTPStore
+	Generator     *Generator
+	Sender        *Sender
+	SendLimiter   *RateLimiter
+	VerifyLimiter *RateLimiter
+}
+
+// New returns fakes without rate limits, generating DefaultOTP.
+func New() *Fixture {
+	return &Fixture{
+		Users:         NewUserStore(),
+		OTPs:          NewOTPStore(),
+		Generator:     NewGenerator(),
+		Sender:        NewSender(),
+		SendLimiter:   NewRateLimiter(0),
+		VerifyLimiter: NewRateLimiter(0),
+	}
+}
+
+// Service returns an auth service using the fakes. opts are applied after the fakes,
+// so they can replace them.
+func (f *Fixture) Service(jwtSecret string, opts ...auth.Option) auth.Service {
+	repo := auth.NewRepository(user.NewRepository(f.Users), otp.NewRepository(f.OTPs), f.SendLimiter, nil, f.VerifyLimiter, nil)
+	return auth.NewService(repo, jwtSecret, append([]auth.Option{
+		auth.WithOTPGenerator(f.Generator),
+		auth.WithSender(f.Sender),
+	}, opts...)...)
+}
@@ -0,0 +1,78 @@
+package authtest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+)
+
+// DefaultOTP is the code a Generator created without codes hands out.
+const DefaultOTP = "123456"
+
+// Generator is a deterministic otp.OTPGenerator. It hands out its codes in order and then
+// keeps repeating the last one.
+type Generator struct {
+	mu    sync.Mutex
+	codes []string
+	next  int
+}
+
+// NewGenerator returns a generator of the given codes, or of DefaultOTP when there are none.
+func NewGenerator(codes ...string) *Generator {
+	if len(codes) == 0 {
+		codes = []string{DefaultOTP}
+	}
+	return &Generator{codes: codes}
+}
+
+func (g *Generator) GenerateOTP() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	code := g.codes[g.next]
+	if g.next < len(g.codes)-1 {
+		g.next++
+	}
+	return code
+}
+
+// Sender is an otp.Sender recording every OTP instead of delivering it, so tests can
+// read the code a user would have received.
+type Sender struct {
+	failure
+	mu   sync.Mutex
+	sent []model.OTP
+}
+
+func NewSender() *Sender {
+	return &Sender{}
+}
+
+func (s *Sender) Send(ctx context.Context, otp model.OTP) error {
+	if err := s.failure.failure(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, otp)
+	return nil
+}
+
+// Sent returns the OTPs sent so far, oldest first.
+func (s *Sender) Sent() []model.OTP {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]model.OTP(nil), s.sent...)
+}
+
+// LastCode returns the code last sent to the phone number, given in E.164 format.
+func (s *Sender) LastCode(phoneNumber string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.sent) - 1; i >= 0; i-- {
+		if s.sent[i].PhoneNumber == phoneNumber {
+			return s.sent[i].OTPCode, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,57 @@
+package authtest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+)
+
+// RateLimiter is an auth.RateLimiter allowing a fixed number of requests per key, without
+// windows, so tests control exactly when a limit is hit. It also satisfies the limiter
+// interface of the HTTP middleware.
+type RateLimiter struct {
+	mu    sync.Mutex
+	max   int
+	calls map[string]int
+}
+
+// NewRateLimiter returns a limiter allowing max requests per key; zero or less allows everything.
+func NewRateLimiter(max int) *RateLimiter {
+	return &RateLimiter{max: max, calls: make(map[string]int)}
+}
+
+func (l *RateLimiter) Allow(key string) model.RateLimitResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls[key]++
+	if l.max <= 0 {
+		return model.RateLimitResult{Allowed: true}
+	}
+
+	remaining := l.max - l.calls[key]
+	if remaining < 0 {
+		return model.RateLimitResult{
+			Limit:      l.max,
+			ResetAt:    time.Now().Add(time.Minute),
+			RetryAfter: time.Minute,
+		}
+	}
+	return model.RateLimitResult{Allowed: true, Limit: l.max, Remaining: remaining}
+}
+
+// Calls returns how often Allow was called for the key.
+func (l *RateLimiter) Calls(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.calls[key]
+}
+
+// Reset forgets every request, lifting all limits.
+func (l *RateLimiter) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = make(map[string]int)
+}
+
+func (l *RateLimiter) Stop() {}
@@ -0,0 +1,227 @@
+package authtest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/database"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// failure is an error injected into a fake, returned by every call until it's cleared.
+type failure struct {
+	mu  sync.Mutex
+	err error
+}
+
+// FailWith makes every following call fail with err; nil makes them succeed again.
+func (f *failure) FailWith(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *failure) failure() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// UserStore is an in-memory user.UserStore behaving like the service's own, including
+// the not found errors the auth service relies on.
+type UserStore struct {
+	failure
+	users *database.InMemoryUserStore
+}
+
+func NewUserStore() *UserStore {
+	return &UserStore{users: database.NewInMemoryUserStore()}
+}
+
+func (s *UserStore) CreateUser(ctx context.Context, user model.User) (model.User, error) {
+	if err := s.failure.failure(); err != nil {
+		return model.User{}, err
+	}
+	return s.users.CreateUser(ctx, user)
+}
+
+func (s *UserStore) GetUserByID(ctx context.Context, id uuid.UUID) (model.User, error) {
+	if err := s.failure.failure(); err != nil {
+		return model.User{}, err
+	}
+	return s.users.GetUserByID(ctx, id)
+}
+
+func (s *UserStore) GetUserByPhoneNumber(ctx context.Context, phoneNumber string) (model.User, error) {
+	if err := s.failure.failure(); err != nil {
+		return model.User{}, err
+	}
+	return s.users.GetUserByPhoneNumber(ctx, phoneNumber)
+}
+
+func (s *UserStore) GetUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]model.User, error) {
+	if err := s.failure.failure(); err != nil {
+		return nil, err
+	}
+	return s.users.GetUsersByIDs(ctx, ids)
+}
+
+func (s *UserStore) ListUsers(ctx context.Context, limit, offset int, search string) ([]model.User, int, error) {
+	if err := s.failure.failure(); err != nil {
+		return nil, 0, err
+	}
+	return s.users.ListUsers(ctx, limit, offset, search)
+}
+
+func (s *UserStore) UpdateUser(ctx context.Context, user model.User) (model.User, error) {
+	if err := s.failure.failure(); err != nil {
+		return model.User{}, err
+	}
+	return s.users.UpdateUser(ctx, user)
+}
+
+func (s *UserStore) MarkPhoneVerified(ctx context.Context, id uuid.UUID, at time.Time) error {
+	if err := s.failure.failure(); err != nil {
+		return err
+	}
+	return s.users.MarkPhoneVerified(ctx, id, at)
+}
+
+func (s *UserStore) SetPasswordHash(ctx context.Context, id uuid.UUID, hash string) error {
+	if err := s.failure.failure(); err != nil {
+		return err
+	}
+	return s.users.SetPasswordHash(ctx, id, hash)
+}
+
+func (s *UserStore) GetPasswordHash(ctx context.Context, id uuid.UUID) (string, error) {
+	if err := s.failure.failure(); err != nil {
+		return "", err
+	}
+	return s.users.GetPasswordHash(ctx, id)
+}
+
+func (s *UserStore) ListPhoneNumbers(ctx context.Context, userID uuid.UUID) ([]model.UserPhone, error) {
+	if err := s.failure.failure(); err != nil {
+		return nil, err
+	}
+	return s.users.ListPhoneNumbers(ctx, userID)
+}
+
+func (s *UserStore) GetPhoneNumber(ctx context.Context, phoneNumber string) (model.UserPhone, error) {
+	if err := s.failure.failure(); err != nil {
+		return model.UserPhone{}, err
+	}
+	return s.users.GetPhoneNumber(ctx, phoneNumber)
+}
+
+func (s *UserStore) AddPhoneNumber(ctx context.Context, phone model.UserPhone) (model.UserPhone, error) {
+	if err := s.failure.failure(); err != nil {
+		return model.UserPhone{}, err
+	}
+	return s.users.AddPhoneNumber(ctx, phone)
+}
+
+func (s *UserStore) DeletePhoneNumber(ctx context.Context, userID, id uuid.UUID) error {
+	if err := s.failure.failure(); err != nil {
+		return err
+	}
+	return s.users.DeletePhoneNumber(ctx, userID, id)
+}
+
+func (s *UserStore) SwapPrimaryPhoneNumber(ctx context.Context, userID, id uuid.UUID) error {
+	if err := s.failure.failure(); err != nil {
+		return err
+	}
+	return s.users.SwapPrimaryPhoneNumber(ctx, userID, id)
+}
+
+func (s *UserStore) MovePhoneNumbers(ctx context.Context, fromUserID, toUserID uuid.UUID) error {
+	if err := s.failure.failure(); err != nil {
+		return err
+	}
+	return s.users.MovePhoneNumbers(ctx, fromUserID, toUserID)
+}
+
+func (s *UserStore) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	if err := s.failure.failure(); err != nil {
+		return err
+	}
+	return s.users.DeleteUser(ctx, id)
+}
+
+func (s *UserStore) DeleteUsers(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error) {
+	if err := s.failure.failure(); err != nil {
+		return nil, err
+	}
+	return s.users.DeleteUsers(ctx, ids)
+}
+
+func (s *UserStore) SetUsersBanned(ctx context.Context, ids []uuid.UUID, bannedAt *time.Time) ([]uuid.UUID, error) {
+	if err := s.failure.failure(); err != nil {
+		return nil, err
+	}
+	return s.users.SetUsersBanned(ctx, ids, bannedAt)
+}
+
+func (s *UserStore) GetDeletedUserByPhoneNumber(ctx context.Context, phoneNumber string, deletedAfter time.Time) (model.User, error) {
+	if err := s.failure.failure(); err != nil {
+		return model.User{}, err
+	}
+	return s.users.GetDeletedUserByPhoneNumber(ctx, phoneNumber, deletedAfter)
+}
+
+func (s *UserStore) ListDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) ([]model.User, error) {
+	if err := s.failure.failure(); err != nil {
+		return nil, err
+	}
+	return s.users.ListDeletedUsers(ctx, deletedBefore, limit)
+}
+
+func (s *UserStore) RestoreUser(ctx context.Context, id uuid.UUID) error {
+	if err := s.failure.failure(); err != nil {
+		return err
+	}
+	return s.users.RestoreUser(ctx, id)
+}
+
+func (s *UserStore) AnonymizeUser(ctx context.Context, id uuid.UUID) (model.User, error) {
+	if err := s.failure.failure(); err != nil {
+		return model.User{}, err
+	}
+	return s.users.AnonymizeUser(ctx, id)
+}
+
+// OTPStore is an in-memory otp.OTPStore keeping the latest OTP of every phone number.
+type OTPStore struct {
+	failure
+	otps *database.InMemoryOTPStore
+}
+
+func NewOTPStore() *OTPStore {
+	return &OTPStore{otps: database.NewInMemoryOTPStore(0)}
+}
+
+func (s *OTPStore) StoreOTP(ctx context.Context, otp model.OTP) error {
+	if err := s.failure.failure(); err != nil {
+		return err
+	}
+	return s.otps.StoreOTP(ctx, otp)
+}
+
+func (s *OTPStore) GetOTP(ctx context.Context, phoneNumber string) (model.OTP, error) {
+	if err := s.failure.failure(); err != nil {
+		return model.OTP{}, err
+	}
+	return s.otps.GetOTP(ctx, phoneNumber)
+}
+
+func (s *OTPStore) DeleteOTP(ctx context.Context, phoneNumber string) error {
+	if err := s.failure.failure(); err != nil {
+		return err
+	}
+	return s.otps.DeleteOTP(ctx, phoneNumber)
+}
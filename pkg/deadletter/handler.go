@@ -0,0 +1,104 @@
+package deadletter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+	"github.com/ebipenman/go-otp-auth-service/pkg/auth"
+	"github.com/ebipenman/go-otp-auth-service/pkg/httpx"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type Handler struct {
+	deadLetterService Service
+}
+
+func NewHandler(deadLetterService Service) *Handler {
+	return &Handler{deadLetterService: deadLetterService}
+}
+
+var _ = openapi.Describe((*Handler).ListDeadLetters, openapi.Operation{
+	Summary: "List failed OTP deliveries",
+	Description: "Lists the OTPs whose delivery failed for good, after the sender's retries, with the channel, the " +
+		"tenant that requested them and the error of the provider. Most recent first.",
+	Tags:     []string{"Admin"},
+	Security: []string{openapi.APIKeyAuth},
+	Params: []openapi.Param{
+		{Name: "per_page", In: openapi.InQuery, Description: "Maximum number of dead letters to return (default 50, at most 500); limit is accepted too", Type: "integer"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Type: httpx.Page{Data: []model.DeadLetter{}}},
+		http.StatusBadRequest:          {Description: "Invalid limit"},
+		http.StatusUnauthorized:        {Description: "API key required"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) ListDeadLetters(c *gin.Context) {
+	limit, err := httpx.PerPage(c, DefaultLimit, MaxLimit)
+	if err != nil {
+		middleware.Abort(c, apperr.ErrInvalidPagination.Wrap(err))
+		return
+	}
+
+	letters, err := h.deadLetterService.ListDeadLetters(c.Request.Context(), limit)
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to list dead letters: %w", err))
+		return
+	}
+	c.JSON(http.StatusOK, httpx.CursorPage(c, letters, limit, ""))
+}
+
+var _ = openapi.Describe((*Handler).RetryDeadLetter, openapi.Operation{
+	Summary: "Retry a failed OTP delivery",
+	Description: "Sends a new OTP to the phone number of a failed delivery, through the same channel and on behalf of " +
+		"the same tenant; the failed OTP has expired by now. The send counts against the rate limits and " +
+		"budgets like any other. Once it went through the dead letter is deleted; if it failed again, it's " +
+		"replaced by a new one and 502 is returned.",
+	Tags:     []string{"Admin"},
+	Security: []string{openapi.APIKeyAuth},
+	Params: []openapi.Param{
+		{Name: "id", In: openapi.InPath, Description: "Dead letter ID"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Description: "message, delivery_id and status_url of the new delivery", Type: map[string]string{}},
+		http.StatusBadRequest:          {Description: "Invalid dead letter ID, or the channel is no longer enabled"},
+		http.StatusUnauthorized:        {Description: "API key required"},
+		http.StatusNotFound:            {Description: "Dead letter not found"},
+		http.StatusConflict:            {Description: "The phone number can't be reached on the channel"},
+		http.StatusTooManyRequests:     {Description: "Rate limit or OTP budget exceeded"},
+		http.StatusBadGateway:          {Description: "The delivery failed again"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) RetryDeadLetter(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.Abort(c, apperr.ErrInvalidRequest.Wrap(err))
+		return
+	}
+
+	deliveryID, rateLimit, err := h.deadLetterService.RetryDeadLetter(c.Request.Context(), id)
+	if errors.Is(err, auth.ErrRateLimitExceeded) {
+		middleware.SetRateLimitHeaders(c, rateLimit)
+	}
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to retry dead letter %s: %w", id, err))
+		return
+	}
+
+	body := gin.H{"message": "OTP sent successfully"}
+	if deliveryID != uuid.Nil {
+		body["delivery_id"] = deliveryID
+		body["status_url"] = middleware.Path(c, "/otp/deliveries/"+deliveryID.String()+"/events")
+	}
+	c.JSON(http.StatusOK, body)
+}
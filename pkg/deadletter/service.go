@@ -0,0 +1,88 @@
+// Package deadletter keeps the OTPs whose delivery failed for good, after the retries of the
+// sender, so support staff can look into them and send a new OTP once the cause is fixed.
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/tracing"
+	"github.com/ebipenman/go-otp-auth-service/pkg/auth"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultLimit is how many dead letters ListDeadLetters returns unless asked for another number.
+	DefaultLimit = 50
+	// MaxLimit is the most dead letters ListDeadLetters returns at once.
+	MaxLimit = 500
+)
+
+// OTPSender sends new OTPs, e.g. the auth service.
+type OTPSender interface {
+	SendOTPVia(ctx context.Context, phoneNumber, channel string, client model.ClientInfo) (uuid.UUID, model.RateLimitResult, error)
+}
+
+// Service defines the business logic for dead letters.
+type Service interface {
+	// ListDeadLetters returns the latest dead letters, most recent first. limit is capped at MaxLimit.
+	ListDeadLetters(ctx context.Context, limit int) ([]model.DeadLetter, error)
+	// RetryDeadLetter sends a new OTP to the phone number of the dead letter, through the same
+	// channel and on behalf of the same tenant, and returns the delivery like a send does. The
+	// OTP that failed has expired by now. The dead letter is deleted once the send went through,
+	// and when it failed again, since the failure leaves a new dead letter. Otherwise, e.g. over
+	// the rate limit, it's kept. It returns ErrDeadLetterNotFound for unknown dead letters.
+	RetryDeadLetter(ctx context.Context, id uuid.UUID) (uuid.UUID, model.RateLimitResult, error)
+}
+
+type deadLetterService struct {
+	repo   Repository
+	sender OTPSender
+}
+
+func NewService(repo Repository, sender OTPSender) Service {
+	return &deadLetterService{repo: repo, sender: sender}
+}
+
+func (s *deadLetterService) ListDeadLetters(ctx context.Context, limit int) ([]model.DeadLetter, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "deadletter.ListDeadLetters")
+	defer span.End()
+
+	limit = min(limit, MaxLimit)
+	letters, err := s.repo.ListDeadLetters(ctx, limit)
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	return letters, nil
+}
+
+func (s *deadLetterService) RetryDeadLetter(ctx context.Context, id uuid.UUID) (deliveryID uuid.UUID, rateLimit model.RateLimitResult, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "deadletter.RetryDeadLetter")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	logger := logging.FromContext(ctx)
+
+	letter, err := s.repo.GetDeadLetter(ctx, id)
+	if err != nil {
+		return uuid.Nil, model.RateLimitResult{}, err
+	}
+	deliveryID, rateLimit, err = s.sender.SendOTPVia(ctx, letter.PhoneNumber, letter.Channel, model.ClientInfo{Tenant: letter.Tenant})
+	if err != nil && !errors.Is(err, auth.ErrDeliveryFailed) {
+		return uuid.Nil, rateLimit, err
+	}
+	if deleteErr := s.repo.DeleteDeadLetter(ctx, id); deleteErr != nil && !errors.Is(deleteErr, ErrDeadLetterNotFound) {
+		logger.Error("Failed to delete retried dead letter", "dead_letter_id", id, "error", deleteErr)
+	}
+	if err != nil {
+		return uuid.Nil, rateLimit, err
+	}
+	logger.Info("Retried dead letter", "dead_letter_id", id, "phone_number", letter.PhoneNumber, "channel", letter.Channel)
+	return deliveryID, rateLimit, nil
+}
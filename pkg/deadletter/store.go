@@ -0,0 +1,75 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/database"
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+
+	"github.com/google/uuid"
+)
+
+var ErrDeadLetterNotFound = apperr.New(http.StatusNotFound, i18n.CodeDeadLetterNotFound)
+
+// Repository defines the data operations on dead letters. It records the failed deliveries
+// of the auth service (see auth.WithDeadLetters).
+type Repository interface {
+	RecordDeadLetter(ctx context.Context, letter model.DeadLetter) error
+	GetDeadLetter(ctx context.Context, id uuid.UUID) (model.DeadLetter, error)
+	ListDeadLetters(ctx context.Context, limit int) ([]model.DeadLetter, error)
+	DeleteDeadLetter(ctx context.Context, id uuid.UUID) error
+	DeleteDeadLetters(ctx context.Context, phoneNumber string) error
+}
+
+type deadLetterRepository struct {
+	store DeadLetterStore
+}
+
+func NewRepository(store DeadLetterStore) Repository {
+	return &deadLetterRepository{store: store}
+}
+
+func (r *deadLetterRepository) RecordDeadLetter(ctx context.Context, letter model.DeadLetter) error {
+	_, err := r.store.CreateDeadLetter(ctx, letter)
+	return err
+}
+
+func (r *deadLetterRepository) GetDeadLetter(ctx context.Context, id uuid.UUID) (model.DeadLetter, error) {
+	letter, err := r.store.GetDeadLetter(ctx, id)
+	if errors.Is(err, database.ErrNotFound) {
+		return model.DeadLetter{}, ErrDeadLetterNotFound
+	}
+	return letter, err
+}
+
+func (r *deadLetterRepository) ListDeadLetters(ctx context.Context, limit int) ([]model.DeadLetter, error) {
+	return r.store.ListDeadLetters(ctx, limit)
+}
+
+func (r *deadLetterRepository) DeleteDeadLetter(ctx context.Context, id uuid.UUID) error {
+	err := r.store.DeleteDeadLetter(ctx, id)
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrDeadLetterNotFound
+	}
+	return err
+}
+
+func (r *deadLetterRepository) DeleteDeadLetters(ctx context.Context, phoneNumber string) error {
+	return r.store.DeleteDeadLetters(ctx, phoneNumber)
+}
+
+// DeadLetterStore is the interface that the database implementation must satisfy.
+type DeadLetterStore interface {
+	// CreateDeadLetter records a failed delivery and returns it with its ID and creation time.
+	CreateDeadLetter(ctx context.Context, letter model.DeadLetter) (model.DeadLetter, error)
+	GetDeadLetter(ctx context.Context, id uuid.UUID) (model.DeadLetter, error)
+	// ListDeadLetters returns the latest limit dead letters, most recent first.
+	ListDeadLetters(ctx context.Context, limit int) ([]model.DeadLetter, error)
+	DeleteDeadLetter(ctx context.Context, id uuid.UUID) error
+	// DeleteDeadLetters deletes the dead letters of the phone number, e.g. of an anonymized user.
+	DeleteDeadLetters(ctx context.Context, phoneNumber string) error
+}
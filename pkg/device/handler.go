@@ -0,0 +1,92 @@
+package device
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type Handler struct {
+	deviceService Service
+}
+
+func NewHandler(deviceService Service) *Handler {
+	return &Handler{deviceService: deviceService}
+}
+
+var _ = openapi.Describe((*Handler).ListMyDevices, openapi.Operation{
+	Summary: "List my devices",
+	Description: "Lists the devices the authenticated user has signed in from, most recently seen first. " +
+		"Devices are told apart by the X-Device-ID header sent on /otp/verify, or by their user agent. " +
+		"trusted_until is set for devices that may sign in with their device token instead of an OTP.",
+	Tags:     []string{"Users"},
+	Security: []string{openapi.BearerAuth},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Type: []model.Device{}},
+		http.StatusUnauthorized:        {Description: "Authorization header required"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) ListMyDevices(c *gin.Context) {
+	val, _ := c.Get(middleware.ContextKeyUser)
+	user, ok := val.(model.User)
+	if !ok {
+		middleware.Abort(c, apperr.ErrAuthRequired)
+		return
+	}
+
+	devices, err := h.deviceService.ListDevices(c.Request.Context(), user.ID)
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to list devices of user %s: %w", user.ID, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, devices)
+}
+
+var _ = openapi.Describe((*Handler).RevokeDeviceTrust, openapi.Operation{
+	Summary: "Stop trusting one of my devices",
+	Description: "Revokes the device token of one of the authenticated user's devices, so it needs an OTP " +
+		"to sign in again. The device stays in the list.",
+	Tags:     []string{"Users"},
+	Security: []string{openapi.BearerAuth},
+	Params: []openapi.Param{
+		{Name: "id", In: openapi.InPath, Description: "Device ID"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusNoContent:           {Description: "Device no longer trusted"},
+		http.StatusBadRequest:          {Description: "Invalid device ID"},
+		http.StatusUnauthorized:        {Description: "Authorization header required"},
+		http.StatusNotFound:            {Description: "Device not found"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) RevokeDeviceTrust(c *gin.Context) {
+	val, _ := c.Get(middleware.ContextKeyUser)
+	user, ok := val.(model.User)
+	if !ok {
+		middleware.Abort(c, apperr.ErrAuthRequired)
+		return
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.Abort(c, ErrInvalidDeviceID)
+		return
+	}
+
+	if err := h.deviceService.RevokeTrust(c.Request.Context(), user.ID, id); err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to revoke trust of device %s: %w", id, err))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
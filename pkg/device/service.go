@@ -0,0 +1,235 @@
+package device
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/database"
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/tracing"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrDeviceNotFound is returned for devices that don't exist or belong to another user.
+	ErrDeviceNotFound  = apperr.New(http.StatusNotFound, i18n.CodeDeviceNotFound)
+	ErrInvalidDeviceID = apperr.New(http.StatusBadRequest, i18n.CodeInvalidDeviceID)
+)
+
+// Service defines the business logic for the devices users sign in from.
+type Service interface {
+	// Track records a sign-in of the user from the client. unseen reports a device the user
+	// hasn't signed in from before; a user's very first device doesn't count as unseen.
+	Track(ctx context.Context, user model.User, client model.ClientInfo) (device model.Device, unseen bool, err error)
+	ListDevices(ctx context.Context, userID uuid.UUID) ([]model.Device, error)
+	// Trust lets the client's device, which must have been tracked, sign in with the returned
+	// device token until the given time. A previous token of the device stops working.
+	Trust(ctx context.Context, userID uuid.UUID, client model.ClientInfo, until time.Time) (model.DeviceToken, error)
+	// IsTrusted reports whether the device token belongs to the client's device and is still valid.
+	IsTrusted(ctx context.Context, userID uuid.UUID, client model.ClientInfo, token string) (bool, error)
+	// RevokeTrust ends the trust of one of the user's devices. It returns ErrDeviceNotFound if
+	// the user has no such device.
+	RevokeTrust(ctx context.Context, userID, deviceID uuid.UUID) error
+	// RevokeAllTrust ends the trust of all of the user's devices, e.g. of a compromised account,
+	// and returns how many were trusted.
+	RevokeAllTrust(ctx context.Context, userID uuid.UUID) (int, error)
+}
+
+// Notifier tells users about sign-ins from unseen devices.
+type Notifier interface {
+	NotifyNewDevice(ctx context.Context, user model.User, device model.Device) error
+}
+
+// Notifiers notifies users through all of its notifiers, e.g. in the log and by email.
+type Notifiers []Notifier
+
+func (n Notifiers) NotifyNewDevice(ctx context.Context, user model.User, device model.Device) error {
+	var errs []error
+	for _, notifier := range n {
+		errs = append(errs, notifier.NotifyNewDevice(ctx, user, device))
+	}
+	return errors.Join(errs...)
+}
+
+type deviceService struct {
+	repo     Repository
+	notifier Notifier
+}
+
+// NewService creates the device service. notifier may be nil to only record devices.
+func NewService(repo Repository, notifier Notifier) Service {
+	return &deviceService{repo: repo, notifier: notifier}
+}
+
+func (s *deviceService) Track(ctx context.Context, user model.User, client model.ClientInfo) (device model.Device, unseen bool, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "device.Track")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	known, err := s.repo.ListDevices(ctx, user.ID)
+	if err != nil {
+		return model.Device{}, false, fmt.Errorf("failed to list devices: %w", err)
+	}
+
+	now := time.Now()
+	fingerprint := client.Fingerprint()
+	for _, d := range known {
+		if d.Fingerprint == fingerprint {
+			if err := s.repo.TouchDevice(ctx, d.ID, client.IP, client.UserAgent, now); err != nil {
+				return model.Device{}, false, fmt.Errorf("failed to update device: %w", err)
+			}
+			d.LastIP, d.UserAgent, d.LastSeenAt = client.IP, client.UserAgent, now
+			return d, false, nil
+		}
+	}
+
+	device, err = s.repo.CreateDevice(ctx, model.Device{
+		UserID:      user.ID,
+		Fingerprint: fingerprint,
+		UserAgent:   client.UserAgent,
+		LastIP:      client.IP,
+		FirstSeenAt: now,
+		LastSeenAt:  now,
+	})
+	if errors.Is(err, database.ErrAlreadyExists) {
+		// A concurrent sign-in from the same device registered it first.
+		return model.Device{}, false, nil
+	}
+	if err != nil {
+		return model.Device{}, false, fmt.Errorf("failed to create device: %w", err)
+	}
+
+	unseen = len(known) > 0
+	if unseen && s.notifier != nil {
+		if err := s.notifier.NotifyNewDevice(ctx, user, device); err != nil {
+			// The sign-in itself succeeded; a lost notification shouldn't fail it.
+			logging.FromContext(ctx).Error("Failed to notify user of new device", "user_id", user.ID, "error", err)
+		}
+	}
+	return device, unseen, nil
+}
+
+func (s *deviceService) ListDevices(ctx context.Context, userID uuid.UUID) ([]model.Device, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "device.ListDevices")
+	defer span.End()
+
+	devices, err := s.repo.ListDevices(ctx, userID)
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	return devices, nil
+}
+
+func (s *deviceService) Trust(ctx context.Context, userID uuid.UUID, client model.ClientInfo, until time.Time) (token model.DeviceToken, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "device.Trust")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	device, err := s.findDevice(ctx, userID, client)
+	if err != nil {
+		return model.DeviceToken{}, err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return model.DeviceToken{}, fmt.Errorf("failed to generate device token: %w", err)
+	}
+	token = model.DeviceToken{Token: base64.RawURLEncoding.EncodeToString(b), ExpiresAt: until}
+	if err := s.repo.TrustDevice(ctx, device.ID, hashToken(token.Token), until); err != nil {
+		return model.DeviceToken{}, fmt.Errorf("failed to trust device: %w", err)
+	}
+	return token, nil
+}
+
+func (s *deviceService) IsTrusted(ctx context.Context, userID uuid.UUID, client model.ClientInfo, token string) (trusted bool, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "device.IsTrusted")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	device, err := s.findDevice(ctx, userID, client)
+	if errors.Is(err, ErrDeviceNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !device.Trusted(time.Now()) {
+		return false, nil
+	}
+	return subtle.ConstantTimeCompare([]byte(device.TrustTokenHash), []byte(hashToken(token))) == 1, nil
+}
+
+func (s *deviceService) RevokeTrust(ctx context.Context, userID, deviceID uuid.UUID) error {
+	ctx, span := tracing.Tracer().Start(ctx, "device.RevokeTrust")
+	defer span.End()
+
+	err := s.repo.UntrustDevice(ctx, userID, deviceID)
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrDeviceNotFound
+	}
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (s *deviceService) RevokeAllTrust(ctx context.Context, userID uuid.UUID) (int, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "device.RevokeAllTrust")
+	defer span.End()
+
+	n, err := s.repo.UntrustDevices(ctx, userID)
+	tracing.RecordError(span, err)
+	return n, err
+}
+
+// findDevice returns the user's device the client is recognized as.
+func (s *deviceService) findDevice(ctx context.Context, userID uuid.UUID, client model.ClientInfo) (model.Device, error) {
+	devices, err := s.repo.ListDevices(ctx, userID)
+	if err != nil {
+		return model.Device{}, fmt.Errorf("failed to list devices: %w", err)
+	}
+	fingerprint := client.Fingerprint()
+	for _, d := range devices {
+		if d.Fingerprint == fingerprint {
+			return d, nil
+		}
+	}
+	return model.Device{}, ErrDeviceNotFound
+}
+
+// hashToken hashes a device token for storage. Like API keys, tokens carry 256 bits of
+// randomness, so a fast hash is enough.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ConsoleNotifier "notifies" users by logging, like the console OTP sender.
+type ConsoleNotifier struct{}
+
+func NewConsoleNotifier() *ConsoleNotifier {
+	return &ConsoleNotifier{}
+}
+
+func (n *ConsoleNotifier) NotifyNewDevice(ctx context.Context, user model.User, device model.Device) error {
+	logging.FromContext(ctx).Info("New device sign-in (console notification)",
+		"phone_number", user.PhoneNumber, "user_agent", device.UserAgent, "ip", device.LastIP)
+	return nil
+}
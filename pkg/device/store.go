@@ -0,0 +1,84 @@
+package device
+
+import (
+	"context"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// Repository defines the interface for device data operations.
+type Repository interface {
+	CreateDevice(ctx context.Context, device model.Device) (model.Device, error)
+	ListDevices(ctx context.Context, userID uuid.UUID) ([]model.Device, error)
+	TouchDevice(ctx context.Context, id uuid.UUID, ip, userAgent string, seenAt time.Time) error
+	AnonymizeDevices(ctx context.Context, userID uuid.UUID) error
+	MoveDevices(ctx context.Context, fromUserID, toUserID uuid.UUID) error
+	TrustDevice(ctx context.Context, id uuid.UUID, tokenHash string, until time.Time) error
+	UntrustDevice(ctx context.Context, userID, id uuid.UUID) error
+	UntrustDevices(ctx context.Context, userID uuid.UUID) (int, error)
+}
+
+type deviceRepository struct {
+	store DeviceStore // Using the internal database interface
+}
+
+func NewRepository(store DeviceStore) Repository {
+	return &deviceRepository{store: store}
+}
+
+func (r *deviceRepository) CreateDevice(ctx context.Context, device model.Device) (model.Device, error) {
+	return r.store.CreateDevice(ctx, device)
+}
+
+func (r *deviceRepository) ListDevices(ctx context.Context, userID uuid.UUID) ([]model.Device, error) {
+	return r.store.ListDevices(ctx, userID)
+}
+
+func (r *deviceRepository) TouchDevice(ctx context.Context, id uuid.UUID, ip, userAgent string, seenAt time.Time) error {
+	return r.store.TouchDevice(ctx, id, ip, userAgent, seenAt)
+}
+
+func (r *deviceRepository) AnonymizeDevices(ctx context.Context, userID uuid.UUID) error {
+	return r.store.AnonymizeDevices(ctx, userID)
+}
+
+func (r *deviceRepository) MoveDevices(ctx context.Context, fromUserID, toUserID uuid.UUID) error {
+	return r.store.MoveDevices(ctx, fromUserID, toUserID)
+}
+
+func (r *deviceRepository) TrustDevice(ctx context.Context, id uuid.UUID, tokenHash string, until time.Time) error {
+	return r.store.TrustDevice(ctx, id, tokenHash, until)
+}
+
+func (r *deviceRepository) UntrustDevice(ctx context.Context, userID, id uuid.UUID) error {
+	return r.store.UntrustDevice(ctx, userID, id)
+}
+
+func (r *deviceRepository) UntrustDevices(ctx context.Context, userID uuid.UUID) (int, error) {
+	return r.store.UntrustDevices(ctx, userID)
+}
+
+// DeviceStore is the interface that the database implementation must satisfy.
+type DeviceStore interface {
+	CreateDevice(ctx context.Context, device model.Device) (model.Device, error)
+	// ListDevices returns the user's devices, most recently seen first.
+	ListDevices(ctx context.Context, userID uuid.UUID) ([]model.Device, error)
+	// TouchDevice records another sign-in from a known device.
+	TouchDevice(ctx context.Context, id uuid.UUID, ip, userAgent string, seenAt time.Time) error
+	// AnonymizeDevices scrubs what identifies the user's devices: fingerprint, user agent and IP.
+	// Their trust ends too.
+	AnonymizeDevices(ctx context.Context, userID uuid.UUID) error
+	// MoveDevices gives the devices of one user to another. Devices the other user knows
+	// already are dropped rather than duplicated.
+	MoveDevices(ctx context.Context, fromUserID, toUserID uuid.UUID) error
+	// TrustDevice stores the hash of the device token, valid until the given time.
+	TrustDevice(ctx context.Context, id uuid.UUID, tokenHash string, until time.Time) error
+	// UntrustDevice ends the trust of the user's device. It returns ErrNotFound if the user has
+	// no such device.
+	UntrustDevice(ctx context.Context, userID, id uuid.UUID) error
+	// UntrustDevices ends the trust of all of the user's devices and returns how many were trusted.
+	UntrustDevices(ctx context.Context, userID uuid.UUID) (int, error)
+}
@@ -0,0 +1,170 @@
+package email
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	emailService Service
+}
+
+func NewHandler(emailService Service) *Handler {
+	return &Handler{emailService: emailService}
+}
+
+type verifyEmailRequest struct {
+	Code string `json:"code" binding:"required,numeric,len=6"`
+}
+
+type sendRecoveryRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type recoverRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Code  string `json:"code" binding:"required,numeric,len=6"`
+}
+
+var _ = openapi.Describe((*Handler).SendVerification, openapi.Operation{
+	Summary: "Send an email verification code",
+	Description: "Emails a code to the address in the authenticated user's profile; confirm it with POST /me/email/verify. " +
+		"Another code can be sent after a minute.",
+	Tags:     []string{"Users"},
+	Security: []string{openapi.BearerAuth},
+	Responses: map[int]openapi.Response{
+		http.StatusAccepted:            {Description: "message: Verification code sent", Type: map[string]string{}},
+		http.StatusUnauthorized:        {Description: "Authorization header required"},
+		http.StatusNotFound:            {Description: "User not found"},
+		http.StatusConflict:            {Description: "The profile has no email address"},
+		http.StatusTooManyRequests:     {Description: "A code was just sent, retry_after: seconds until the next code can be sent"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) SendVerification(c *gin.Context) {
+	val, _ := c.Get(middleware.ContextKeyUser)
+	user, ok := val.(model.User)
+	if !ok {
+		middleware.Abort(c, apperr.ErrAuthRequired)
+		return
+	}
+
+	if err := h.emailService.SendVerification(c.Request.Context(), user.ID); err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to send email verification to user %s: %w", user.ID, err))
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification code sent"})
+}
+
+var _ = openapi.Describe((*Handler).Verify, openapi.Operation{
+	Summary: "Verify the email address",
+	Description: "Marks the email address of the authenticated user verified with the code sent to it. A verified " +
+		"address can recover the account and receives new device alerts; changing it in the profile " +
+		"makes it unverified again. Codes stop working after 15 minutes or 5 wrong attempts.",
+	Tags:     []string{"Users"},
+	Security: []string{openapi.BearerAuth},
+	Body:     &openapi.Body{Description: "Code sent to the email address", Required: true, Type: verifyEmailRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Type: model.UserResponse{}},
+		http.StatusBadRequest:          {Description: "Invalid request format"},
+		http.StatusUnauthorized:        {Description: "Authorization header required, or invalid or expired code"},
+		http.StatusConflict:            {Description: "The address is verified by another account"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) Verify(c *gin.Context) {
+	val, _ := c.Get(middleware.ContextKeyUser)
+	user, ok := val.(model.User)
+	if !ok {
+		middleware.Abort(c, apperr.ErrAuthRequired)
+		return
+	}
+
+	var req verifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithDetails(c, apperr.ErrInvalidRequest.Wrap(err), err.Error())
+		return
+	}
+
+	verified, err := h.emailService.Verify(c.Request.Context(), user.ID, req.Code)
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to verify email of user %s: %w", user.ID, err))
+		return
+	}
+	c.JSON(http.StatusOK, verified.ToUserResponse())
+}
+
+var _ = openapi.Describe((*Handler).SendRecoveryCode, openapi.Operation{
+	Summary: "Send an account recovery code",
+	Description: "Emails a sign-in code to a verified email address, for users who lost access to their phone number. " +
+		"The response is the same whether or not the address belongs to a user.",
+	Tags: []string{"Authentication"},
+	Body: &openapi.Body{Description: "Verified email address", Required: true, Type: sendRecoveryRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusAccepted:            {Description: "message: Recovery code sent if the address is verified", Type: map[string]string{}},
+		http.StatusBadRequest:          {Description: "Invalid request format"},
+		http.StatusTooManyRequests:     {Description: "Too many requests from this IP"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) SendRecoveryCode(c *gin.Context) {
+	var req sendRecoveryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithDetails(c, apperr.ErrInvalidRequest.Wrap(err), err.Error())
+		return
+	}
+
+	if err := h.emailService.SendRecoveryCode(c.Request.Context(), req.Email); err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to send recovery code: %w", err))
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Recovery code sent if the address is verified"})
+}
+
+var _ = openapi.Describe((*Handler).Recover, openapi.Operation{
+	Summary: "Sign in with an account recovery code",
+	Description: "Verifies the code sent by /auth/email-recovery/send and returns the same JWT as /otp/verify. " +
+		"The phone verification isn't renewed, so with PHONE_REVERIFY_DAYS set the user may still have to re-verify it.",
+	Tags: []string{"Authentication"},
+	Params: []openapi.Param{
+		{Name: "X-Device-ID", In: openapi.InHeader, Description: "Stable identifier of the app installation, used to recognize the device"},
+	},
+	Body: &openapi.Body{Description: "Verified email address and the code sent to it", Required: true, Type: recoverRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Description: "token: <jwt_token>", Type: map[string]string{}},
+		http.StatusBadRequest:          {Description: "Invalid request format"},
+		http.StatusUnauthorized:        {Description: "Invalid or expired code"},
+		http.StatusTooManyRequests:     {Description: "Too many requests from this IP"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) Recover(c *gin.Context) {
+	var req recoverRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithDetails(c, apperr.ErrInvalidRequest.Wrap(err), err.Error())
+		return
+	}
+	client := model.ClientInfo{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		DeviceID:  c.GetHeader("X-Device-ID"),
+	}
+
+	token, err := h.emailService.Recover(c.Request.Context(), req.Email, req.Code, client)
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("account recovery failed: %w", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
@@ -0,0 +1,71 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/pkg/otp"
+)
+
+// DeviceNotifier emails users about sign-ins from unseen devices. It implements
+// device.Notifier; users without a verified email address aren't notified.
+type DeviceNotifier struct {
+	sender Sender
+}
+
+func NewDeviceNotifier(sender Sender) *DeviceNotifier {
+	return &DeviceNotifier{sender: sender}
+}
+
+func (n *DeviceNotifier) NotifyNewDevice(ctx context.Context, user model.User, device model.Device) error {
+	if user.Email == "" || user.EmailVerifiedAt == nil {
+		return nil
+	}
+	return n.sender.Send(ctx, Message{
+		To:      user.Email,
+		Subject: "New sign-in to your account",
+		Body: fmt.Sprintf("Your account was signed in to from a new device (%s, IP %s) at %s. If this wasn't you, secure your account right away.",
+			device.UserAgent, device.LastIP, device.FirstSeenAt.UTC().Format("2006-01-02 15:04 MST")),
+	})
+}
+
+// OTPSender delivers sign-in OTPs to the verified email address of the phone number's user. It
+// implements otp.Sender for the email channel; numbers without such a user or address return
+// otp.ErrNoRecipient.
+type OTPSender struct {
+	repo   Repository
+	sender Sender
+}
+
+func NewOTPSender(repo Repository, sender Sender) *OTPSender {
+	return &OTPSender{repo: repo, sender: sender}
+}
+
+// Channel is otp.ChannelEmail.
+func (s *OTPSender) Channel() string {
+	return otp.ChannelEmail
+}
+
+func (s *OTPSender) Send(ctx context.Context, code model.OTP) error {
+	user, err := s.repo.GetUserByPhoneNumber(ctx, code.PhoneNumber)
+	if errors.Is(err, errNotFound) {
+		return otp.ErrNoRecipient
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get user by phone number: %w", err)
+	}
+	if user.Email == "" || user.EmailVerifiedAt == nil {
+		return otp.ErrNoRecipient
+	}
+	subject := "Your sign-in code"
+	if code.MessagePrefix != "" {
+		subject = code.MessagePrefix + " " + subject
+	}
+	return s.sender.Send(ctx, Message{
+		To:      user.Email,
+		Subject: subject,
+		Body:    fmt.Sprintf("Use %s to sign in to your account. If you didn't ask for it, ignore this email.", code.OTPCode),
+	})
+}
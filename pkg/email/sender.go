@@ -0,0 +1,38 @@
+package email
+
+import (
+	"context"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+)
+
+// Message is an email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers emails.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// ConsoleSender "delivers" emails by logging them, like the console OTP sender. Unless echo
+// is set, the body, which may hold a code, is left out of the log.
+type ConsoleSender struct {
+	echo bool
+}
+
+func NewConsoleSender(echo bool) *ConsoleSender {
+	return &ConsoleSender{echo: echo}
+}
+
+func (s *ConsoleSender) Send(ctx context.Context, msg Message) error {
+	body := "[not echoed]"
+	if s.echo {
+		body = msg.Body
+	}
+	logging.FromContext(ctx).Info("Email sent (console delivery)", "to", msg.To, "subject", msg.Subject, "body", body)
+	return nil
+}
@@ -0,0 +1,271 @@
+// Package email verifies the email addresses of users with codes sent to them. A verified
+// address is a second recovery channel next to the phone number: users who lost their phone
+// sign in with a code emailed to it. Notifications like new device alerts go to it too.
+package email
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/tracing"
+	"github.com/ebipenman/go-otp-auth-service/pkg/auth"
+	"github.com/ebipenman/go-otp-auth-service/pkg/otp"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// CodeTTL is how long an emailed code is valid. Emails arrive slower than SMS, so it's
+	// longer than the OTP expiration.
+	CodeTTL = 15 * time.Minute
+	// ResendCooldown is how long users wait before another code is emailed to them.
+	ResendCooldown = time.Minute
+	// maxAttempts is how many wrong codes are accepted before the code stops working.
+	maxAttempts = 5
+
+	methodEmail = "email"
+)
+
+var (
+	ErrUserNotFound = apperr.ErrUserNotFound
+	// ErrNoEmail means the user has no email address to verify.
+	ErrNoEmail = apperr.New(http.StatusConflict, i18n.CodeEmailMissing)
+	// ErrInvalidCode covers wrong, expired and used up codes alike.
+	ErrInvalidCode = apperr.New(http.StatusUnauthorized, i18n.CodeInvalidEmailCode)
+	// ErrEmailTaken means another user verified the email address already.
+	ErrEmailTaken = apperr.New(http.StatusConflict, i18n.CodeEmailTaken)
+	// ErrCooldown is answered for a *CooldownError, whose RetryAfter it carries.
+	ErrCooldown = apperr.New(http.StatusTooManyRequests, i18n.CodeEmailCodeCooldown)
+)
+
+// CooldownError is returned, wrapped in ErrCooldown, when a code was emailed to the user less
+// than ResendCooldown ago.
+type CooldownError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CooldownError) Error() string {
+	return fmt.Sprintf("email code was sent recently, retry after %s", e.RetryAfter)
+}
+
+// cooldown returns the ErrCooldown of a code that can be sent again after retryAfter.
+func cooldown(retryAfter time.Duration) error {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	return ErrCooldown.Wrap(&CooldownError{RetryAfter: retryAfter}).
+		WithParams(i18n.Params{"seconds": seconds}).
+		WithFields(map[string]any{"retry_after": seconds})
+}
+
+// Service defines the business logic of email verification and recovery.
+type Service interface {
+	// SendVerification emails a code to the address in the user's profile. It returns
+	// ErrNoEmail if the user has none and ErrCooldown if a code was sent just before.
+	SendVerification(ctx context.Context, userID uuid.UUID) error
+	// Verify marks the user's email address verified with the code sent to it. Changing the
+	// address in the profile makes it unverified again.
+	Verify(ctx context.Context, userID uuid.UUID, code string) (model.User, error)
+	// SendRecoveryCode emails a sign-in code to the verified address. Unknown addresses and
+	// resends within the cooldown are silently ignored, so the endpoint can't tell which
+	// addresses belong to users.
+	SendRecoveryCode(ctx context.Context, email string) error
+	// Recover signs in the user who verified the email address with the recovery code sent
+	// to it, and returns their JWT.
+	Recover(ctx context.Context, email, code string, client model.ClientInfo) (string, error)
+}
+
+type emailService struct {
+	repo        Repository
+	sender      Sender
+	authService auth.Service
+	generator   otp.OTPGenerator
+}
+
+func NewService(repo Repository, sender Sender, authService auth.Service, generator otp.OTPGenerator) Service {
+	return &emailService{repo: repo, sender: sender, authService: authService, generator: generator}
+}
+
+func (s *emailService) SendVerification(ctx context.Context, userID uuid.UUID) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "email.SendVerification")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if errors.Is(err, errNotFound) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.Email == "" {
+		return ErrNoEmail
+	}
+	return s.sendCode(ctx, user, model.EmailPurposeVerify)
+}
+
+func (s *emailService) Verify(ctx context.Context, userID uuid.UUID, code string) (user model.User, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "email.Verify")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	pending, err := s.checkCode(ctx, userID, model.EmailPurposeVerify, code)
+	if err != nil {
+		return model.User{}, err
+	}
+	// The address may have changed since the code was sent; the new one isn't verified by it.
+	if err := s.repo.MarkEmailVerified(ctx, userID, pending.Email, time.Now()); err != nil {
+		if errors.Is(err, errNotFound) {
+			return model.User{}, ErrInvalidCode
+		}
+		if errors.Is(err, ErrEmailTaken) {
+			return model.User{}, err
+		}
+		return model.User{}, fmt.Errorf("failed to mark email verified: %w", err)
+	}
+
+	user, err = s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return model.User{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
+
+func (s *emailService) SendRecoveryCode(ctx context.Context, email string) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "email.SendRecoveryCode")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	user, err := s.repo.GetUserByVerifiedEmail(ctx, normalize(email))
+	if errors.Is(err, errNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get user by email: %w", err)
+	}
+	err = s.sendCode(ctx, user, model.EmailPurposeRecovery)
+	var cooldown *CooldownError
+	if errors.As(err, &cooldown) {
+		return nil
+	}
+	return err
+}
+
+func (s *emailService) Recover(ctx context.Context, email, code string, client model.ClientInfo) (token string, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "email.Recover")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	email = normalize(email)
+	user, err := s.repo.GetUserByVerifiedEmail(ctx, email)
+	if errors.Is(err, errNotFound) {
+		return "", ErrInvalidCode
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get user by email: %w", err)
+	}
+	pending, err := s.checkCode(ctx, user.ID, model.EmailPurposeRecovery, code)
+	if err != nil {
+		return "", err
+	}
+	if pending.Email != email {
+		return "", ErrInvalidCode
+	}
+
+	token, _, err = s.authService.SignInVerified(ctx, user.PhoneNumber, methodEmail, client)
+	return token, err
+}
+
+// sendCode emails a new code for the purpose to the user, replacing any pending one.
+func (s *emailService) sendCode(ctx context.Context, user model.User, purpose string) error {
+	now := time.Now()
+	pending, err := s.repo.GetEmailOTP(ctx, user.ID)
+	if err != nil && !errors.Is(err, errNotFound) {
+		return fmt.Errorf("failed to get pending email code: %w", err)
+	}
+	if err == nil && now.Sub(pending.CreatedAt) < ResendCooldown {
+		return cooldown(pending.CreatedAt.Add(ResendCooldown).Sub(now))
+	}
+
+	code := s.generator.GenerateOTP()
+	err = s.repo.StoreEmailOTP(ctx, model.EmailOTP{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Purpose:   purpose,
+		CodeHash:  hashCode(code),
+		ExpiresAt: now.Add(CodeTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store email code: %w", err)
+	}
+
+	msg := Message{To: user.Email}
+	if purpose == model.EmailPurposeRecovery {
+		msg.Subject = "Your sign-in code"
+		msg.Body = fmt.Sprintf("Use %s to sign in to your account. If you didn't ask for it, ignore this email.", code)
+	} else {
+		msg.Subject = "Verify your email address"
+		msg.Body = fmt.Sprintf("Use %s to verify your email address.", code)
+	}
+	if err := s.sender.Send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// checkCode checks the code against the user's pending code for the purpose, and uses it up
+// if it matches.
+func (s *emailService) checkCode(ctx context.Context, userID uuid.UUID, purpose, code string) (model.EmailOTP, error) {
+	pending, err := s.repo.GetEmailOTP(ctx, userID)
+	if errors.Is(err, errNotFound) {
+		return model.EmailOTP{}, ErrInvalidCode
+	}
+	if err != nil {
+		return model.EmailOTP{}, fmt.Errorf("failed to get pending email code: %w", err)
+	}
+	if pending.Purpose != purpose || pending.Attempts >= maxAttempts || time.Now().After(pending.ExpiresAt) {
+		return model.EmailOTP{}, ErrInvalidCode
+	}
+
+	if subtle.ConstantTimeCompare([]byte(pending.CodeHash), []byte(hashCode(code))) != 1 {
+		if err := s.repo.IncrementEmailOTPAttempts(ctx, userID); err != nil {
+			logging.FromContext(ctx).Error("Failed to count email code attempt", "user_id", userID, "error", err)
+		}
+		return model.EmailOTP{}, ErrInvalidCode
+	}
+	if err := s.repo.DeleteEmailOTP(ctx, userID); err != nil {
+		return model.EmailOTP{}, fmt.Errorf("failed to delete email code: %w", err)
+	}
+	return pending, nil
+}
+
+// hashCode hashes an email code for storage. Codes expire quickly and allow few attempts, so
+// a fast hash is enough.
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalize matches the email addresses stored by the user service.
+func normalize(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
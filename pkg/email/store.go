@@ -0,0 +1,115 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/database"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/pkg/user"
+
+	"github.com/google/uuid"
+)
+
+var errNotFound = errors.New("not found")
+
+// Repository defines the interface for the data operations of email verification.
+type Repository interface {
+	GetUserByID(ctx context.Context, id uuid.UUID) (model.User, error)
+	GetUserByVerifiedEmail(ctx context.Context, email string) (model.User, error)
+	GetUserByPhoneNumber(ctx context.Context, phoneNumber string) (model.User, error)
+	MarkEmailVerified(ctx context.Context, userID uuid.UUID, email string, at time.Time) error
+	StoreEmailOTP(ctx context.Context, otp model.EmailOTP) error
+	GetEmailOTP(ctx context.Context, userID uuid.UUID) (model.EmailOTP, error)
+	IncrementEmailOTPAttempts(ctx context.Context, userID uuid.UUID) error
+	DeleteEmailOTP(ctx context.Context, userID uuid.UUID) error
+}
+
+type emailRepository struct {
+	store    EmailStore
+	userRepo user.Repository
+}
+
+func NewRepository(store EmailStore, userRepo user.Repository) Repository {
+	return &emailRepository{store: store, userRepo: userRepo}
+}
+
+func (r *emailRepository) GetUserByID(ctx context.Context, id uuid.UUID) (model.User, error) {
+	u, err := r.userRepo.GetUserByID(ctx, id)
+	if errors.Is(err, database.ErrNotFound) {
+		return model.User{}, errNotFound
+	}
+	return u, err
+}
+
+func (r *emailRepository) GetUserByVerifiedEmail(ctx context.Context, email string) (model.User, error) {
+	u, err := r.store.GetUserByVerifiedEmail(ctx, email)
+	if errors.Is(err, database.ErrNotFound) {
+		return model.User{}, errNotFound
+	}
+	return u, err
+}
+
+// GetUserByPhoneNumber finds the user by any of their phone numbers, primary or secondary.
+func (r *emailRepository) GetUserByPhoneNumber(ctx context.Context, phoneNumber string) (model.User, error) {
+	u, err := r.userRepo.GetUserByPhoneNumber(ctx, phoneNumber)
+	if errors.Is(err, database.ErrNotFound) {
+		var phone model.UserPhone
+		phone, err = r.userRepo.GetPhoneNumber(ctx, phoneNumber)
+		if err == nil {
+			u, err = r.userRepo.GetUserByID(ctx, phone.UserID)
+		}
+	}
+	if errors.Is(err, database.ErrNotFound) {
+		return model.User{}, errNotFound
+	}
+	return u, err
+}
+
+func (r *emailRepository) MarkEmailVerified(ctx context.Context, userID uuid.UUID, email string, at time.Time) error {
+	err := r.store.MarkEmailVerified(ctx, userID, email, at)
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		return errNotFound
+	case errors.Is(err, database.ErrAlreadyExists):
+		return ErrEmailTaken
+	}
+	return err
+}
+
+func (r *emailRepository) StoreEmailOTP(ctx context.Context, otp model.EmailOTP) error {
+	return r.store.StoreEmailOTP(ctx, otp)
+}
+
+func (r *emailRepository) GetEmailOTP(ctx context.Context, userID uuid.UUID) (model.EmailOTP, error) {
+	otp, err := r.store.GetEmailOTP(ctx, userID)
+	if errors.Is(err, database.ErrNotFound) {
+		return model.EmailOTP{}, errNotFound
+	}
+	return otp, err
+}
+
+func (r *emailRepository) IncrementEmailOTPAttempts(ctx context.Context, userID uuid.UUID) error {
+	return r.store.IncrementEmailOTPAttempts(ctx, userID)
+}
+
+func (r *emailRepository) DeleteEmailOTP(ctx context.Context, userID uuid.UUID) error {
+	return r.store.DeleteEmailOTP(ctx, userID)
+}
+
+// EmailStore is the interface that the database implementation must satisfy.
+type EmailStore interface {
+	// StoreEmailOTP replaces the pending code of the user, resetting its attempts.
+	StoreEmailOTP(ctx context.Context, otp model.EmailOTP) error
+	GetEmailOTP(ctx context.Context, userID uuid.UUID) (model.EmailOTP, error)
+	IncrementEmailOTPAttempts(ctx context.Context, userID uuid.UUID) error
+	// DeleteEmailOTP removes the pending code of the user; none is no error.
+	DeleteEmailOTP(ctx context.Context, userID uuid.UUID) error
+	// MarkEmailVerified marks the email address of the user verified at the given time. It
+	// returns ErrNotFound if the user's address isn't email (anymore), and ErrAlreadyExists if
+	// another user verified it already.
+	MarkEmailVerified(ctx context.Context, userID uuid.UUID, email string, at time.Time) error
+	// GetUserByVerifiedEmail returns the user who verified the email address.
+	GetUserByVerifiedEmail(ctx context.Context, email string) (model.User, error)
+}
@@ -0,0 +1,86 @@
+package eventbus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+)
+
+// KafkaBroker produces every event to one Kafka topic through a Kafka REST Proxy (v2 API).
+// Events about a user are keyed by the user ID, so they land in one partition and keep
+// their order; others have no key.
+type KafkaBroker struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewKafkaBroker creates a broker producing to topic through the REST proxy at proxyURL.
+func NewKafkaBroker(proxyURL, topic string) *KafkaBroker {
+	return &KafkaBroker{
+		endpoint: strings.TrimSuffix(proxyURL, "/") + "/topics/" + url.PathEscape(topic),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type kafkaRecord struct {
+	Key   interface{}     `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (b *KafkaBroker) Send(ctx context.Context, event model.Event, payload []byte) error {
+	record := kafkaRecord{Value: payload}
+	if userID, ok := event.Data["user_id"]; ok {
+		record.Key = fmt.Sprint(userID)
+	}
+	body, err := json.Marshal(map[string][]kafkaRecord{"records": {record}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to produce to Kafka: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("kafka REST proxy answered %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+	}
+
+	// The proxy answers 200 even when a record failed; the offsets tell.
+	var result struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("invalid Kafka REST proxy response: %w", err)
+	}
+	for _, offset := range result.Offsets {
+		if offset.ErrorCode != nil {
+			return fmt.Errorf("kafka rejected the event: %s", offset.Error)
+		}
+	}
+	return nil
+}
+
+func (b *KafkaBroker) Close() error {
+	b.client.CloseIdleConnections()
+	return nil
+}
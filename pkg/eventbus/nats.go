@@ -0,0 +1,154 @@
+package eventbus
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+)
+
+// NATSBroker publishes every event to the subject "<prefix>.<event type>", e.g.
+// auth.login.succeeded, speaking the NATS client protocol directly. It connects lazily and
+// reconnects after errors.
+type NATSBroker struct {
+	url    *url.URL
+	prefix string
+
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewNATSBroker creates a broker for the server at rawURL: nats://[user:password@]host[:4222],
+// nats://token@host, or tls://... for TLS.
+func NewNATSBroker(rawURL, subjectPrefix string) (*NATSBroker, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS URL: %w", err)
+	}
+	if (u.Scheme != "nats" && u.Scheme != "tls") || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid NATS URL %q: must be nats://host[:port] or tls://host[:port]", rawURL)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	return &NATSBroker{url: u, prefix: subjectPrefix}, nil
+}
+
+func (b *NATSBroker) Send(ctx context.Context, event model.Event, payload []byte) error {
+	if b.conn == nil {
+		if err := b.connect(ctx); err != nil {
+			return err
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		b.conn.SetDeadline(deadline)
+	}
+
+	// The PING makes the server answer once it processed the PUB, so errors show up here.
+	subject := b.prefix + "." + event.Type
+	msg := fmt.Sprintf("PUB %s %d\r\n%s\r\nPING\r\n", subject, len(payload), payload)
+	if _, err := b.conn.Write([]byte(msg)); err != nil {
+		b.Close()
+		return fmt.Errorf("failed to publish to NATS: %w", err)
+	}
+	if err := b.awaitPong(); err != nil {
+		b.Close()
+		return err
+	}
+	return nil
+}
+
+func (b *NATSBroker) Close() error {
+	if b.conn == nil {
+		return nil
+	}
+	err := b.conn.Close()
+	b.conn, b.reader = nil, nil
+	return err
+}
+
+// connect opens the connection and introduces the client to the server.
+func (b *NATSBroker) connect(ctx context.Context) error {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{}
+	if b.url.Scheme == "tls" {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: b.url.Hostname()}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", b.url.Host)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", b.url.Host)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	b.conn, b.reader = conn, bufio.NewReader(conn)
+
+	// The server greets with its INFO first.
+	line, err := b.reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO") {
+		b.Close()
+		return fmt.Errorf("unexpected NATS greeting %q: %v", strings.TrimSpace(line), err)
+	}
+
+	options := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "go-otp-auth-service",
+		"lang":     "go",
+		"version":  "1.0.0",
+		"protocol": 0,
+	}
+	if b.url.User != nil {
+		if password, ok := b.url.User.Password(); ok {
+			options["user"], options["pass"] = b.url.User.Username(), password
+		} else {
+			options["auth_token"] = b.url.User.Username()
+		}
+	}
+	connectOptions, err := json.Marshal(options)
+	if err != nil {
+		b.Close()
+		return err
+	}
+	if _, err := fmt.Fprintf(b.conn, "CONNECT %s\r\nPING\r\n", connectOptions); err != nil {
+		b.Close()
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	if err := b.awaitPong(); err != nil {
+		b.Close()
+		return err
+	}
+	return nil
+}
+
+// awaitPong reads until the server's PONG, answering its own PINGs on the way.
+func (b *NATSBroker) awaitPong() error {
+	for {
+		line, err := b.reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read from NATS: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := b.conn.Write([]byte("PONG\r\n")); err != nil {
+				return fmt.Errorf("failed to answer NATS ping: %w", err)
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("NATS error: " + strings.Trim(strings.TrimPrefix(line, "-ERR"), " '"))
+		}
+		// +OK and INFO updates need no answer.
+	}
+}
@@ -0,0 +1,130 @@
+// Package eventbus streams the auth events to a message broker (NATS or Kafka), so other
+// systems can consume them in real time instead of through webhooks.
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+)
+
+const (
+	queueSize   = 1000
+	maxAttempts = 3
+	baseBackoff = 100 * time.Millisecond
+)
+
+// Broker sends one message to the message broker. Implementations need not be safe for
+// concurrent use: the Publisher calls them from a single goroutine.
+type Broker interface {
+	// Send publishes the JSON encoded event. Failed sends are retried.
+	Send(ctx context.Context, event model.Event, payload []byte) error
+	// Close releases the connection to the broker.
+	Close() error
+}
+
+// Publisher hands the events to a Broker in the background, in the order they were published.
+type Publisher struct {
+	broker Broker
+	queue  chan model.Event
+	logger *slog.Logger
+
+	mu     sync.RWMutex // guards closed and sending on queue
+	closed bool
+	done   chan struct{}
+}
+
+// NewPublisher creates a Publisher sending to broker and starts its worker.
+func NewPublisher(broker Broker, logger *slog.Logger) *Publisher {
+	p := &Publisher{
+		broker: broker,
+		queue:  make(chan model.Event, queueSize),
+		logger: logger.With("component", "eventbus"),
+		done:   make(chan struct{}),
+	}
+	go p.worker()
+	return p
+}
+
+// Publish queues the event. It never blocks the caller; events are dropped when the queue is full.
+func (p *Publisher) Publish(event model.Event) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		p.logger.Warn("Event bus publisher is closed, dropping event", "event_type", event.Type, "event_id", event.ID)
+		return
+	}
+	select {
+	case p.queue <- event:
+	default:
+		p.logger.Error("Event bus queue is full, dropping event", "event_type", event.Type, "event_id", event.ID)
+	}
+}
+
+// Close stops accepting events and waits until the queued ones have been sent, or until ctx
+// is done. Then it closes the broker connection.
+func (p *Publisher) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return fmt.Errorf("events still queued for the event bus: %w", ctx.Err())
+	}
+	return p.broker.Close()
+}
+
+func (p *Publisher) worker() {
+	defer close(p.done)
+
+	for event := range p.queue {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			p.logger.Error("Failed to marshal event", "event_type", event.Type, "error", err)
+			continue
+		}
+		// Retrying in place keeps the events in order; a broker that stays down fills the queue.
+		for attempt := 1; ; attempt++ {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err = p.broker.Send(ctx, event, payload)
+			cancel()
+			if err == nil {
+				break
+			}
+			if attempt >= maxAttempts {
+				p.logger.Error("Giving up on event",
+					"event_type", event.Type, "event_id", event.ID, "attempts", attempt, "error", err)
+				break
+			}
+			backoff := baseBackoff << (attempt - 1)
+			p.logger.Warn("Publishing event failed, retrying",
+				"event_type", event.Type, "event_id", event.ID, "attempt", attempt, "backoff", backoff, "error", err)
+			time.Sleep(backoff)
+		}
+	}
+}
+
+// EventPublisher receives events; the webhook dispatcher and Publisher are both one.
+type EventPublisher interface {
+	Publish(event model.Event)
+}
+
+// Fanout publishes every event to all of its publishers.
+type Fanout []EventPublisher
+
+func (f Fanout) Publish(event model.Event) {
+	for _, p := range f {
+		p.Publish(event)
+	}
+}
@@ -0,0 +1,154 @@
+package export
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type Handler struct {
+	exportService Service
+}
+
+func NewHandler(exportService Service) *Handler {
+	return &Handler{exportService: exportService}
+}
+
+// exportResponse describes an export; DownloadURL is set once it's ready.
+type exportResponse struct {
+	model.Export
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+var _ = openapi.Describe((*Handler).RequestExport, openapi.Operation{
+	Summary: "Request an export of my data",
+	Description: "Starts generating a machine-readable (JSON) export of everything stored about the authenticated " +
+		"user: profile, devices, linked social accounts and login history. Poll the URL in the Location " +
+		"header until the status is ready, then fetch the download_url. Exports expire after DATA_EXPORT_TTL.",
+	Tags:     []string{"Users"},
+	Security: []string{openapi.BearerAuth},
+	Responses: map[int]openapi.Response{
+		http.StatusAccepted: {Type: exportResponse{}, Headers: []openapi.Header{
+			{Name: "Location", Description: "URL of the export"},
+		}},
+		http.StatusUnauthorized:        {Description: "Authorization header required"},
+		http.StatusConflict:            {Description: "An export is already being generated"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) RequestExport(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	export, err := h.exportService.RequestExport(c.Request.Context(), user.ID)
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to request export of user %s: %w", user.ID, err))
+		return
+	}
+
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+export.ID.String())
+	c.JSON(http.StatusAccepted, exportResponse{Export: export})
+}
+
+var _ = openapi.Describe((*Handler).GetExport, openapi.Operation{
+	Summary:     "Get an export of my data",
+	Description: "Returns the status of an export of the authenticated user's data, with the download_url once it's ready.",
+	Tags:        []string{"Users"},
+	Security:    []string{openapi.BearerAuth},
+	Params: []openapi.Param{
+		{Name: "id", In: openapi.InPath, Description: "Export ID"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Type: exportResponse{}},
+		http.StatusBadRequest:          {Description: "Invalid export ID"},
+		http.StatusUnauthorized:        {Description: "Authorization header required"},
+		http.StatusNotFound:            {Description: "Export not found or expired"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) GetExport(c *gin.Context) {
+	export, ok := h.getExport(c)
+	if !ok {
+		return
+	}
+
+	resp := exportResponse{Export: export}
+	if export.Status == model.ExportReady {
+		resp.DownloadURL = strings.TrimSuffix(c.Request.URL.Path, "/") + "/download"
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+var _ = openapi.Describe((*Handler).DownloadExport, openapi.Operation{
+	Summary:     "Download an export of my data",
+	Description: "Downloads a ready export of the authenticated user's data as a JSON file.",
+	Tags:        []string{"Users"},
+	Security:    []string{openapi.BearerAuth},
+	Params: []openapi.Param{
+		{Name: "id", In: openapi.InPath, Description: "Export ID"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Type: Document{}},
+		http.StatusBadRequest:          {Description: "Invalid export ID"},
+		http.StatusUnauthorized:        {Description: "Authorization header required"},
+		http.StatusNotFound:            {Description: "Export not found or expired"},
+		http.StatusConflict:            {Description: "The export is still pending or failed"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) DownloadExport(c *gin.Context) {
+	export, ok := h.getExport(c)
+	if !ok {
+		return
+	}
+	if export.Status != model.ExportReady {
+		middleware.Abort(c, ErrNotReady)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%s.json"`, export.ID))
+	c.Data(http.StatusOK, "application/json", export.Data)
+}
+
+// getExport loads the export named in the path for the authenticated user, or aborts with an error.
+func (h *Handler) getExport(c *gin.Context) (model.Export, bool) {
+	user, ok := currentUser(c)
+	if !ok {
+		return model.Export{}, false
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.Abort(c, ErrInvalidID)
+		return model.Export{}, false
+	}
+
+	export, err := h.exportService.GetExport(c.Request.Context(), user.ID, id)
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to get export %s: %w", id, err))
+		return model.Export{}, false
+	}
+	return export, true
+}
+
+// currentUser returns the authenticated user, or aborts with ErrAuthRequired.
+func currentUser(c *gin.Context) (model.User, bool) {
+	val, _ := c.Get(middleware.ContextKeyUser)
+	user, ok := val.(model.User)
+	if !ok {
+		middleware.Abort(c, apperr.ErrAuthRequired)
+	}
+	return user, ok
+}
@@ -0,0 +1,200 @@
+// Package export produces machine-readable copies of everything stored about a user, as
+// required by data protection laws like the GDPR. Exports are generated in the background
+// and can be downloaded until they expire.
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/tracing"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotFound   = apperr.New(http.StatusNotFound, i18n.CodeExportNotFound)
+	ErrInProgress = apperr.New(http.StatusConflict, i18n.CodeExportInProgress)
+	// ErrNotReady means the export is still pending or failed, so it can't be downloaded.
+	ErrNotReady  = apperr.New(http.StatusConflict, i18n.CodeExportNotReady)
+	ErrInvalidID = apperr.New(http.StatusBadRequest, i18n.CodeInvalidExportID)
+)
+
+// generateTimeout bounds the generation of a single export.
+const generateTimeout = time.Minute
+
+// Document is the content of an export.
+type Document struct {
+	ExportedAt time.Time  `json:"exported_at"`
+	Profile    model.User `json:"profile"`
+	// PhoneNumbers are the secondary phone numbers; the primary one is in the profile.
+	PhoneNumbers []model.UserPhone `json:"phone_numbers"`
+	Devices      []model.Device    `json:"devices"`
+	Identities   []model.Identity  `json:"identities"`
+	Logins       []model.Login     `json:"logins"`
+}
+
+// Service defines the business logic for data exports.
+type Service interface {
+	// RequestExport starts generating an export for the user and returns it while still pending.
+	// It returns ErrInProgress while another export of the user is generated.
+	RequestExport(ctx context.Context, userID uuid.UUID) (model.Export, error)
+	// GetExport returns the user's export, or ErrNotFound if it doesn't exist, expired or
+	// belongs to another user.
+	GetExport(ctx context.Context, userID, id uuid.UUID) (model.Export, error)
+	// Close waits for the exports being generated until ctx is done.
+	Close(ctx context.Context) error
+}
+
+type exportService struct {
+	repo    Repository
+	ttl     time.Duration
+	running map[uuid.UUID]bool // users whose export is being generated
+	mu      sync.Mutex
+	workers sync.WaitGroup
+}
+
+// NewService creates the export service. Exports are kept for ttl after they were requested.
+func NewService(repo Repository, ttl time.Duration) Service {
+	return &exportService{repo: repo, ttl: ttl, running: make(map[uuid.UUID]bool)}
+}
+
+func (s *exportService) RequestExport(ctx context.Context, userID uuid.UUID) (export model.Export, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "export.RequestExport")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	// Generating reads all of the user's data, so one at a time is plenty.
+	s.mu.Lock()
+	if s.running[userID] {
+		s.mu.Unlock()
+		return model.Export{}, ErrInProgress
+	}
+	s.running[userID] = true
+	s.mu.Unlock()
+
+	now := time.Now()
+	export, err = s.repo.CreateExport(ctx, model.Export{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Status:    model.ExportPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+	})
+	if err != nil {
+		s.done(userID)
+		return model.Export{}, fmt.Errorf("failed to create export: %w", err)
+	}
+
+	// The export outlives the request, but keeps its logger and trace.
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		defer s.done(userID)
+		genCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), generateTimeout)
+		defer cancel()
+		s.generate(genCtx, export)
+	}()
+	return export, nil
+}
+
+func (s *exportService) GetExport(ctx context.Context, userID, id uuid.UUID) (model.Export, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "export.GetExport")
+	defer span.End()
+
+	export, err := s.repo.GetExport(ctx, id)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			tracing.RecordError(span, err)
+			return model.Export{}, fmt.Errorf("failed to get export: %w", err)
+		}
+		return model.Export{}, err
+	}
+	// Other users' exports are reported as missing, so IDs can't be probed.
+	if export.UserID != userID {
+		return model.Export{}, ErrNotFound
+	}
+	return export, nil
+}
+
+func (s *exportService) Close(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("exports still being generated: %w", ctx.Err())
+	}
+}
+
+func (s *exportService) done(userID uuid.UUID) {
+	s.mu.Lock()
+	delete(s.running, userID)
+	s.mu.Unlock()
+}
+
+// generate collects the user's data into the export and saves it as ready, or failed.
+func (s *exportService) generate(ctx context.Context, export model.Export) {
+	ctx, span := tracing.Tracer().Start(ctx, "export.generate")
+	defer span.End()
+	logger := logging.FromContext(ctx)
+
+	data, err := s.collect(ctx, export.UserID)
+	tracing.RecordError(span, err)
+	if err != nil {
+		logger.Error("Failed to generate export", "export_id", export.ID, "user_id", export.UserID, "error", err)
+		export.Status = model.ExportFailed
+	} else {
+		export.Status = model.ExportReady
+		export.Data = data
+	}
+	completedAt := time.Now()
+	export.CompletedAt = &completedAt
+
+	if err := s.repo.UpdateExport(ctx, export); err != nil {
+		tracing.RecordError(span, err)
+		logger.Error("Failed to save export", "export_id", export.ID, "user_id", export.UserID, "error", err)
+		return
+	}
+	logger.Info("Generated export", "export_id", export.ID, "user_id", export.UserID, "status", export.Status)
+}
+
+// collect reads everything stored about the user into a JSON Document.
+func (s *exportService) collect(ctx context.Context, userID uuid.UUID) ([]byte, error) {
+	doc := Document{ExportedAt: time.Now()}
+	var err error
+	if doc.Profile, err = s.repo.GetUserByID(ctx, userID); err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if doc.PhoneNumbers, err = s.repo.ListPhoneNumbers(ctx, userID); err != nil {
+		return nil, fmt.Errorf("failed to list phone numbers: %w", err)
+	}
+	if doc.Devices, err = s.repo.ListDevices(ctx, userID); err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	if doc.Identities, err = s.repo.ListIdentities(ctx, userID); err != nil {
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+	// The export has the whole login history, not just the latest page.
+	if doc.Logins, err = s.repo.ListLogins(ctx, userID, math.MaxInt32); err != nil {
+		return nil, fmt.Errorf("failed to list logins: %w", err)
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
@@ -0,0 +1,99 @@
+package export
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/database"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/pkg/device"
+	"github.com/ebipenman/go-otp-auth-service/pkg/loginhistory"
+	"github.com/ebipenman/go-otp-auth-service/pkg/social"
+	"github.com/ebipenman/go-otp-auth-service/pkg/user"
+
+	"github.com/google/uuid"
+)
+
+// Repository defines the interface for the data operations of exports, including reading
+// the data that goes into them.
+type Repository interface {
+	CreateExport(ctx context.Context, export model.Export) (model.Export, error)
+	GetExport(ctx context.Context, id uuid.UUID) (model.Export, error)
+	UpdateExport(ctx context.Context, export model.Export) error
+	DeleteExports(ctx context.Context, userID uuid.UUID) error
+	GetUserByID(ctx context.Context, id uuid.UUID) (model.User, error)
+	ListPhoneNumbers(ctx context.Context, userID uuid.UUID) ([]model.UserPhone, error)
+	ListDevices(ctx context.Context, userID uuid.UUID) ([]model.Device, error)
+	ListIdentities(ctx context.Context, userID uuid.UUID) ([]model.Identity, error)
+	ListLogins(ctx context.Context, userID uuid.UUID, limit int) ([]model.Login, error)
+}
+
+type exportRepository struct {
+	store      ExportStore
+	userRepo   user.Repository
+	deviceRepo device.Repository
+	identities social.IdentityStore
+	loginRepo  loginhistory.Repository
+}
+
+func NewRepository(store ExportStore, userRepo user.Repository, deviceRepo device.Repository, identities social.IdentityStore, loginRepo loginhistory.Repository) Repository {
+	return &exportRepository{
+		store:      store,
+		userRepo:   userRepo,
+		deviceRepo: deviceRepo,
+		identities: identities,
+		loginRepo:  loginRepo,
+	}
+}
+
+func (r *exportRepository) CreateExport(ctx context.Context, export model.Export) (model.Export, error) {
+	return r.store.CreateExport(ctx, export)
+}
+
+func (r *exportRepository) GetExport(ctx context.Context, id uuid.UUID) (model.Export, error) {
+	export, err := r.store.GetExport(ctx, id)
+	if errors.Is(err, database.ErrNotFound) {
+		return model.Export{}, ErrNotFound
+	}
+	return export, err
+}
+
+func (r *exportRepository) UpdateExport(ctx context.Context, export model.Export) error {
+	return r.store.UpdateExport(ctx, export)
+}
+
+func (r *exportRepository) DeleteExports(ctx context.Context, userID uuid.UUID) error {
+	return r.store.DeleteExports(ctx, userID)
+}
+
+func (r *exportRepository) GetUserByID(ctx context.Context, id uuid.UUID) (model.User, error) {
+	return r.userRepo.GetUserByID(ctx, id)
+}
+
+func (r *exportRepository) ListPhoneNumbers(ctx context.Context, userID uuid.UUID) ([]model.UserPhone, error) {
+	return r.userRepo.ListPhoneNumbers(ctx, userID)
+}
+
+func (r *exportRepository) ListDevices(ctx context.Context, userID uuid.UUID) ([]model.Device, error) {
+	return r.deviceRepo.ListDevices(ctx, userID)
+}
+
+func (r *exportRepository) ListIdentities(ctx context.Context, userID uuid.UUID) ([]model.Identity, error) {
+	return r.identities.ListIdentities(ctx, userID)
+}
+
+func (r *exportRepository) ListLogins(ctx context.Context, userID uuid.UUID, limit int) ([]model.Login, error) {
+	return r.loginRepo.ListLogins(ctx, userID, limit, nil)
+}
+
+// ExportStore is the interface that the database implementation must satisfy.
+type ExportStore interface {
+	// CreateExport stores a new export; expired exports may be dropped along the way.
+	CreateExport(ctx context.Context, export model.Export) (model.Export, error)
+	// GetExport returns ErrNotFound for unknown and expired exports.
+	GetExport(ctx context.Context, id uuid.UUID) (model.Export, error)
+	// UpdateExport saves the status, completion time and data of the export.
+	UpdateExport(ctx context.Context, export model.Export) error
+	// DeleteExports deletes all exports of the user.
+	DeleteExports(ctx context.Context, userID uuid.UUID) error
+}
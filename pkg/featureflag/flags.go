@@ -0,0 +1,135 @@
+// Package featureflag turns features on for some of the traffic, e.g. the WhatsApp channel for
+// 10% of the phone numbers or a new flow for one tenant. Flags come from the configuration and,
+// optionally, from a remote JSON document polled by a Poller, which overrides them.
+package featureflag
+
+import (
+	"hash/fnv"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// Sources of the flags.
+const (
+	SourceConfig = "config"
+	SourceRemote = "remote"
+)
+
+// ChannelFlag is the name of the flag that gates an OTP channel, e.g. "otp_channel_whatsapp".
+func ChannelFlag(channel string) string {
+	return "otp_channel_" + channel
+}
+
+// Flag decides for whom a feature is on: for everyone if Enabled, otherwise for the listed
+// tenants and the given percentage of the subjects.
+type Flag struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	// Percentage of the subjects, 0 to 100, the flag is on for. A subject always lands in the
+	// same bucket of a flag, so raising the percentage only adds subjects.
+	Percentage int      `json:"percentage"`
+	Tenants    []string `json:"tenants,omitempty"`
+	Source     string   `json:"source"`
+}
+
+// EnabledFor reports whether the flag is on for the subject, e.g. a phone number, of the tenant.
+func (f Flag) EnabledFor(subject, tenant string) bool {
+	if f.Enabled || f.Percentage >= 100 {
+		return true
+	}
+	if tenant != "" && slices.Contains(f.Tenants, tenant) {
+		return true
+	}
+	if f.Percentage <= 0 || subject == "" {
+		return false
+	}
+	return bucket(f.Name, subject) < f.Percentage
+}
+
+// bucket places the subject in one of 100 buckets, independently for every flag.
+func bucket(name, subject string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(subject))
+	return int(h.Sum32() % 100)
+}
+
+// Flags holds the flags of the configuration and of the remote source. A remote flag
+// overrides the configured one of the same name. It's safe for concurrent use.
+type Flags struct {
+	mu     sync.RWMutex
+	config map[string]Flag
+	remote map[string]Flag
+}
+
+// NewFlags creates Flags with the configured flags.
+func NewFlags(config []Flag) *Flags {
+	f := &Flags{}
+	f.SetConfig(config)
+	return f
+}
+
+// SetConfig replaces the configured flags, e.g. when the configuration is reloaded.
+func (f *Flags) SetConfig(flags []Flag) {
+	byName := index(flags, SourceConfig)
+	f.mu.Lock()
+	f.config = byName
+	f.mu.Unlock()
+}
+
+// SetRemote replaces the flags of the remote source.
+func (f *Flags) SetRemote(flags []Flag) {
+	byName := index(flags, SourceRemote)
+	f.mu.Lock()
+	f.remote = byName
+	f.mu.Unlock()
+}
+
+// Lookup returns the flag with the name, if it's defined.
+func (f *Flags) Lookup(name string) (Flag, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if flag, ok := f.remote[name]; ok {
+		return flag, true
+	}
+	flag, ok := f.config[name]
+	return flag, ok
+}
+
+// Enabled reports whether the flag is on for the subject of the tenant. Flags that aren't
+// defined are on if def is set, so features can be gated before their flag exists.
+func (f *Flags) Enabled(name, subject, tenant string, def bool) bool {
+	flag, ok := f.Lookup(name)
+	if !ok {
+		return def
+	}
+	return flag.EnabledFor(subject, tenant)
+}
+
+// List returns the flags in effect, sorted by name.
+func (f *Flags) List() []Flag {
+	f.mu.RLock()
+	merged := maps.Clone(f.config)
+	if merged == nil {
+		merged = make(map[string]Flag, len(f.remote))
+	}
+	maps.Copy(merged, f.remote)
+	f.mu.RUnlock()
+
+	flags := make([]Flag, 0, len(merged))
+	for _, name := range slices.Sorted(maps.Keys(merged)) {
+		flags = append(flags, merged[name])
+	}
+	return flags
+}
+
+func index(flags []Flag, source string) map[string]Flag {
+	byName := make(map[string]Flag, len(flags))
+	for _, flag := range flags {
+		flag.Source = source
+		byName[flag.Name] = flag
+	}
+	return byName
+}
@@ -0,0 +1,57 @@
+package featureflag
+
+import (
+	"net/http"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	flags *Flags
+}
+
+func NewHandler(flags *Flags) *Handler {
+	return &Handler{flags: flags}
+}
+
+// flagResponse is a flag, and whether it's on for the subject and tenant asked about.
+type flagResponse struct {
+	Flag
+	On *bool `json:"on,omitempty"`
+}
+
+var _ = openapi.Describe((*Handler).ListFlags, openapi.Operation{
+	Summary: "List the feature flags",
+	Description: "Lists the feature flags in effect on this instance, from FEATURE_FLAGS (source \"config\") and the remote " +
+		"FEATURE_FLAGS_URL (source \"remote\"), which overrides them. With subject or tenant, \"on\" tells whether each " +
+		"flag is on for them. otp_channel_<channel> flags gate the OTP channels besides SMS, by phone number.",
+	Tags:     []string{"Admin"},
+	Security: []string{openapi.APIKeyAuth},
+	Params: []openapi.Param{
+		{Name: "subject", In: openapi.InQuery, Description: "Subject to evaluate the flags for, e.g. a phone number"},
+		{Name: "tenant", In: openapi.InQuery, Description: "Tenant to evaluate the flags for, e.g. client:billing"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:           {Type: []flagResponse{}},
+		http.StatusUnauthorized: {Description: "API key required"},
+	},
+})
+
+func (h *Handler) ListFlags(c *gin.Context) {
+	subject, tenant := c.Query("subject"), c.Query("tenant")
+	evaluate := subject != "" || tenant != ""
+
+	flags := h.flags.List()
+	resp := make([]flagResponse, 0, len(flags))
+	for _, flag := range flags {
+		r := flagResponse{Flag: flag}
+		if evaluate {
+			on := flag.EnabledFor(subject, tenant)
+			r.On = &on
+		}
+		resp = append(resp, r)
+	}
+	c.JSON(http.StatusOK, resp)
+}
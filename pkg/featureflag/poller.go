@@ -0,0 +1,101 @@
+package featureflag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Poller fetches the remote flags every interval until Stop is called. The document is a JSON
+// object of flags by name, e.g. {"otp_channel_whatsapp": {"percentage": 10}}. While it can't
+// be fetched, the flags fetched last stay in effect.
+type Poller struct {
+	flags  *Flags
+	url    string
+	client *http.Client
+	logger *slog.Logger
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewPoller creates a Poller and starts fetching, right away and then every interval.
+func NewPoller(flags *Flags, url string, interval time.Duration, logger *slog.Logger) *Poller {
+	p := &Poller{
+		flags:  flags,
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: logger.With("component", "featureflag"),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(p.done)
+		p.poll()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.poll()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// Stop ends the polling.
+func (p *Poller) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+		<-p.done
+	})
+}
+
+func (p *Poller) poll() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	flags, err := p.fetch(ctx)
+	if err != nil {
+		p.logger.Error("Failed to fetch feature flags, keeping the previous ones", "url", p.url, "error", err)
+		return
+	}
+	p.flags.SetRemote(flags)
+}
+
+func (p *Poller) fetch(ctx context.Context) ([]Flag, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var byName map[string]Flag
+	if err := json.NewDecoder(resp.Body).Decode(&byName); err != nil {
+		return nil, err
+	}
+	flags := make([]Flag, 0, len(byName))
+	for name, flag := range byName {
+		if flag.Percentage < 0 || flag.Percentage > 100 {
+			return nil, fmt.Errorf("flag %q: percentage must be between 0 and 100, got %d", name, flag.Percentage)
+		}
+		flag.Name = name
+		flags = append(flags, flag)
+	}
+	return flags, nil
+}
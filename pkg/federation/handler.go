@@ -0,0 +1,164 @@
+package federation
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+	"github.com/ebipenman/go-otp-auth-service/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stateCookie holds the state of the login started in the browser, so the callback only
+// finishes logins the same browser started.
+const stateCookie = "sso_state"
+
+type Handler struct {
+	relyingParty *RelyingParty
+	authService  auth.Service
+	returnURLs   []string
+}
+
+// NewHandler creates the SSO handler. Users are only sent back to one of returnURLs.
+func NewHandler(relyingParty *RelyingParty, authService auth.Service, returnURLs []string) *Handler {
+	return &Handler{relyingParty: relyingParty, authService: authService, returnURLs: returnURLs}
+}
+
+var _ = openapi.Describe((*Handler).Login, openapi.Operation{
+	Summary: "Start single sign-on",
+	Description: "Redirects the browser to the organization's identity provider (FEDERATION_ISSUER). " +
+		"After signing in there, the user is sent to return_to with the JWT in the URL fragment " +
+		"(#token=<jwt>, or #error=<code>), or without return_to gets the JSON of /otp/verify.",
+	Tags: []string{"Authentication"},
+	Params: []openapi.Param{
+		{Name: "return_to", In: openapi.InQuery, Description: "One of FEDERATION_RETURN_URLS"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusFound:      {},
+		http.StatusBadRequest: {Description: "return_to is not an allowed URL"},
+		http.StatusBadGateway: {Description: "The identity provider is unreachable"},
+	},
+})
+
+func (h *Handler) Login(c *gin.Context) {
+	returnTo := c.Query("return_to")
+	if returnTo != "" && !slices.Contains(h.returnURLs, returnTo) {
+		middleware.Abort(c, apperr.ErrInvalidRequest)
+		return
+	}
+
+	target, state, err := h.relyingParty.AuthCodeURL(c.Request.Context(), returnTo)
+	if err != nil {
+		middleware.Abort(c, ErrIdPUnavailable.Wrap(fmt.Errorf("failed to start SSO login: %w", err)))
+		return
+	}
+	h.setStateCookie(c, state, int(loginTTL.Seconds()))
+	c.Redirect(http.StatusFound, target)
+}
+
+var _ = openapi.Describe((*Handler).Callback, openapi.Operation{
+	Summary: "Single sign-on callback",
+	Description: "The identity provider redirects here (FEDERATION_REDIRECT_URL). The user is linked by the " +
+		"phone_number claim, registering them if needed, and gets the same JWT as /otp/verify.",
+	Tags: []string{"Authentication"},
+	Params: []openapi.Param{
+		{Name: "state", In: openapi.InQuery, Description: "State of the login, matching the sso_state cookie set by /sso/login", Required: true},
+		{Name: "code", In: openapi.InQuery, Description: "Authorization code"},
+		{Name: "error", In: openapi.InQuery, Description: "Error reported by the identity provider"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:           {Description: "token: <jwt_token>", Type: map[string]string{}},
+		http.StatusFound:        {},
+		http.StatusUnauthorized: {Description: "Single sign-on failed"},
+		http.StatusForbidden:    {Description: "The IdP account has no phone number"},
+	},
+})
+
+func (h *Handler) Callback(c *gin.Context) {
+	ctx := c.Request.Context()
+	logger := logging.FromContext(ctx)
+
+	// Without the cookie an attacker could send a victim the callback of the attacker's own
+	// login and sign them in to the attacker's account.
+	state := c.Query("state")
+	cookie, _ := c.Cookie(stateCookie)
+	h.setStateCookie(c, "", -1)
+	if cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(state)) != 1 {
+		logger.Info("SSO login failed", "error", "state does not match the login of this browser")
+		h.fail(c, "", ErrLoginFailed)
+		return
+	}
+
+	claims, returnTo, err := h.relyingParty.Exchange(ctx, state, c.Query("code"))
+	if idpErr := c.Query("error"); idpErr != "" && err == nil {
+		err = errors.New("IdP reported " + idpErr)
+	}
+	if err != nil {
+		logger.Info("SSO login failed", "error", err)
+		if !errors.Is(err, ErrNoPhoneNumber) {
+			err = ErrLoginFailed.Wrap(err)
+		}
+		h.fail(c, returnTo, err)
+		return
+	}
+
+	client := model.ClientInfo{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+	token, user, err := h.authService.SignInVerified(ctx, claims.PhoneNumber, "sso", client)
+	if errors.Is(err, auth.ErrInvalidPhoneNumber) {
+		logger.Info("SSO login with an invalid phone number", "subject", claims.Subject)
+		h.fail(c, returnTo, ErrNoPhoneNumber.Wrap(err))
+		return
+	}
+	if errors.Is(err, auth.ErrUserBanned) {
+		logger.Info("SSO login of a banned user", "subject", claims.Subject)
+	}
+	if err != nil {
+		h.fail(c, returnTo, fmt.Errorf("failed to sign in SSO user %s: %w", claims.Subject, err))
+		return
+	}
+	logger.Info("SSO login", "subject", claims.Subject, "user_id", user.ID)
+	if returnTo == "" {
+		c.JSON(http.StatusOK, gin.H{"token": token})
+		return
+	}
+	h.redirect(c, returnTo, url.Values{"token": {token}})
+}
+
+// setStateCookie sets the state cookie for Login and Callback, which share the path, or
+// deletes it with a negative maxAge. Lax lets the browser send it with the IdP's redirect.
+func (h *Handler) setStateCookie(c *gin.Context, state string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(stateCookie, state, maxAge, path.Dir(c.Request.URL.Path), "", secure, true)
+}
+
+// fail sends the user back to returnTo with the error code in the fragment, or leaves err to
+// middleware.ErrorHandler without returnTo.
+func (h *Handler) fail(c *gin.Context, returnTo string, err error) {
+	if returnTo == "" {
+		middleware.Abort(c, err)
+		return
+	}
+	e, ok := apperr.As(err)
+	if !ok {
+		logging.FromContext(c.Request.Context()).Error("SSO login failed", "error", err)
+		e = apperr.ErrInternal
+	}
+	h.redirect(c, returnTo, url.Values{"error": {e.Code}})
+}
+
+// redirect sends the user back to returnTo with the fragment, which stays out of server logs.
+func (h *Handler) redirect(c *gin.Context, returnTo string, fragment url.Values) {
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, returnTo+"#"+fragment.Encode())
+}
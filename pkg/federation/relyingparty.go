@@ -0,0 +1,232 @@
+// Package federation lets users sign in through an upstream OpenID Connect identity provider,
+// typically a company's, instead of with an OTP. The IdP's users are linked to the service's
+// users by the phone_number claim, so an employee keeps one account whichever way they sign in.
+package federation
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/pkg/social"
+)
+
+// loginTTL is how long users have to sign in at the IdP.
+const loginTTL = 10 * time.Minute
+
+var (
+	// ErrLoginFailed is answered for logins that failed at the IdP or came back unverifiable.
+	ErrLoginFailed   = apperr.New(http.StatusUnauthorized, i18n.CodeSSOFailed)
+	ErrUnknownState  = ErrLoginFailed.Wrap(errors.New("unknown or expired login state"))
+	ErrNoPhoneNumber = apperr.New(http.StatusForbidden, i18n.CodeSSONoPhoneNumber)
+	// ErrIdPUnavailable means a login couldn't be started because the IdP didn't answer.
+	ErrIdPUnavailable = apperr.New(http.StatusBadGateway, i18n.CodeSSOFailed)
+)
+
+// login is a sign-in in progress at the IdP, keyed by the state parameter.
+type login struct {
+	nonce        string
+	codeVerifier string
+	returnTo     string
+	expiresAt    time.Time
+}
+
+// metadata is the part of the IdP's OpenID Provider Metadata the relying party uses.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// RelyingParty runs the authorization code flow with PKCE against the IdP. Logins in progress
+// are kept in memory, so with several replicas the callback must reach the replica that
+// started the login.
+type RelyingParty struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	client       *http.Client
+
+	mu       sync.Mutex
+	metadata *metadata
+	verifier *social.Provider
+	logins   map[string]login
+}
+
+func NewRelyingParty(issuer, clientID, clientSecret, redirectURL string) *RelyingParty {
+	return &RelyingParty{
+		issuer:       issuer,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		logins:       make(map[string]login),
+	}
+}
+
+// AuthCodeURL starts a login and returns the IdP URL to send the user to and the state of the
+// login. returnTo is kept for the callback.
+func (rp *RelyingParty) AuthCodeURL(ctx context.Context, returnTo string) (target, state string, err error) {
+	md, _, err := rp.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	state, err1 := randomString()
+	nonce, err2 := randomString()
+	codeVerifier, err3 := randomString()
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return "", "", err
+	}
+
+	rp.mu.Lock()
+	now := time.Now()
+	// Forget the logins nobody finished.
+	for s, l := range rp.logins {
+		if now.After(l.expiresAt) {
+			delete(rp.logins, s)
+		}
+	}
+	rp.logins[state] = login{nonce: nonce, codeVerifier: codeVerifier, returnTo: returnTo, expiresAt: now.Add(loginTTL)}
+	rp.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {rp.clientID},
+		"redirect_uri":          {rp.redirectURL},
+		"scope":                 {"openid phone"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(md.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return md.AuthorizationEndpoint + sep + params.Encode(), state, nil
+}
+
+// Exchange finishes the login of the state: it redeems the code at the IdP, verifies the ID
+// token and returns its claims and the returnTo URL of AuthCodeURL. The state can't be used
+// again afterwards.
+func (rp *RelyingParty) Exchange(ctx context.Context, state, code string) (social.Claims, string, error) {
+	rp.mu.Lock()
+	l, ok := rp.logins[state]
+	delete(rp.logins, state)
+	rp.mu.Unlock()
+	if !ok || time.Now().After(l.expiresAt) {
+		return social.Claims{}, "", ErrUnknownState
+	}
+
+	md, verifier, err := rp.discover(ctx)
+	if err != nil {
+		return social.Claims{}, l.returnTo, err
+	}
+	idToken, err := rp.redeem(ctx, md.TokenEndpoint, code, l.codeVerifier)
+	if err != nil {
+		return social.Claims{}, l.returnTo, fmt.Errorf("failed to redeem code: %w", err)
+	}
+	claims, err := verifier.Verify(ctx, idToken)
+	if err != nil {
+		return social.Claims{}, l.returnTo, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if claims.Nonce != l.nonce {
+		return social.Claims{}, l.returnTo, errors.New("invalid ID token: nonce mismatch")
+	}
+	// Users are linked by phone number, so one the IdP hasn't verified could take over any account.
+	if claims.PhoneNumber == "" || !claims.PhoneVerified {
+		return social.Claims{}, l.returnTo, ErrNoPhoneNumber
+	}
+	return claims, l.returnTo, nil
+}
+
+// redeem exchanges the code for the ID token at the token endpoint.
+func (rp *RelyingParty) redeem(ctx context.Context, tokenEndpoint, code, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {rp.redirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(rp.clientID), url.QueryEscape(rp.clientSecret))
+
+	resp, err := rp.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("unexpected response (%s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return "", fmt.Errorf("token endpoint answered %s: %s", resp.Status, body.Error)
+	}
+	return body.IDToken, nil
+}
+
+// discover fetches the IdP's metadata on first use, so the service starts even when the IdP
+// is unreachable; failures are retried with the next login.
+func (rp *RelyingParty) discover(ctx context.Context) (*metadata, *social.Provider, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if rp.metadata != nil {
+		return rp.metadata, rp.verifier, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rp.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := rp.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch IdP metadata: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("failed to fetch IdP metadata: unexpected status %s", resp.Status)
+	}
+	var md metadata
+	if err := json.NewDecoder(resp.Body).Decode(&md); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode IdP metadata: %w", err)
+	}
+	// The configured issuer has its trailing slash trimmed; some IdPs publish theirs with one.
+	if strings.TrimSuffix(md.Issuer, "/") != rp.issuer || md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return nil, nil, fmt.Errorf("IdP metadata is incomplete or names issuer %q", md.Issuer)
+	}
+
+	rp.metadata = &md
+	rp.verifier = social.NewProvider("sso", []string{md.Issuer}, md.JWKSURI, []string{rp.clientID})
+	return rp.metadata, rp.verifier, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,33 @@
+package httpx
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ETag derives the entity tag of a resource from the time it last changed. It's weak: the
+// representation may differ in bytes, e.g. in another language, without the resource changing.
+func ETag(updatedAt time.Time) string {
+	return `W/"` + strconv.FormatInt(updatedAt.UnixNano(), 36) + `"`
+}
+
+// NotModified sets the ETag of the response and reports whether the client's copy, named by
+// If-None-Match, is still current; it has answered 304 then. The response may only be cached
+// by the client, and must be revalidated before reuse.
+func NotModified(c *gin.Context, etag string) bool {
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, no-cache")
+
+	for _, tag := range strings.Split(c.GetHeader("If-None-Match"), ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
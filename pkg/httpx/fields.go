@@ -0,0 +1,92 @@
+package httpx
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UnknownFieldError is returned for a fields query parameter naming a field the response
+// doesn't have.
+type UnknownFieldError struct {
+	Field string
+}
+
+func (e *UnknownFieldError) Error() string {
+	return fmt.Sprintf("unknown field %q", e.Field)
+}
+
+// Fields is a sparse fieldset: the JSON fields of a response a client asked for, e.g. with
+// ?fields=id,phone_number. A nil Fields selects them all.
+type Fields map[string]bool
+
+// ParseFields reads the fields query parameter, checking the names against the JSON fields of
+// T, a response struct.
+func ParseFields[T any](c *gin.Context) (Fields, error) {
+	value := c.Query("fields")
+	if value == "" {
+		return nil, nil
+	}
+	known := jsonFields(reflect.TypeFor[T]())
+	fields := make(Fields)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := known[name]; !ok {
+			return nil, &UnknownFieldError{Field: name}
+		}
+		fields[name] = true
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return fields, nil
+}
+
+// Select returns v with only the selected fields, or v itself if all are.
+func Select[T any](v T, fields Fields) any {
+	if fields == nil {
+		return v
+	}
+	rv := reflect.ValueOf(v)
+	sparse := make(map[string]any, len(fields))
+	for name, index := range jsonFields(rv.Type()) {
+		if fields[name] {
+			sparse[name] = rv.Field(index).Interface()
+		}
+	}
+	return sparse
+}
+
+// SelectAll applies Select to every item of a list.
+func SelectAll[T any](vs []T, fields Fields) []any {
+	sparse := make([]any, len(vs))
+	for i, v := range vs {
+		sparse[i] = Select(v, fields)
+	}
+	return sparse
+}
+
+// jsonFields maps the JSON names of the exported fields of a struct type to their index.
+func jsonFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int, t.NumField())
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = i
+	}
+	return fields
+}
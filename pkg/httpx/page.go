@@ -0,0 +1,115 @@
+// Package httpx holds the response shapes shared by the HTTP handlers, e.g. the envelope of
+// every list endpoint.
+package httpx
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrInvalidPagination is returned for page or per_page query parameters that aren't positive
+// numbers.
+var ErrInvalidPagination = errors.New("invalid pagination parameters")
+
+// Page is the envelope of the list endpoints. Lists paged by number carry the page and the
+// total; lists paged by cursor carry next_cursor instead, to pass as cursor for the next page.
+// Data is the slice of items; it's untyped so the API docs can describe every list with it.
+type Page struct {
+	Data       any    `json:"data"`
+	Total      *int   `json:"total,omitempty"`
+	Page       int    `json:"page,omitempty"`
+	PerPage    int    `json:"per_page"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Links      Links  `json:"links"`
+}
+
+// Links are the relative URLs of the page and of its neighbours, if there are any.
+type Links struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// PerPage parses the per_page query parameter, or its older name limit, capped at maxPerPage
+// unless that is zero.
+func PerPage(c *gin.Context, defPerPage, maxPerPage int) (int, error) {
+	value := c.Query("per_page")
+	if value == "" {
+		value = c.Query("limit")
+	}
+	if value == "" {
+		return defPerPage, nil
+	}
+	perPage, err := strconv.Atoi(value)
+	if err != nil || perPage <= 0 {
+		return 0, ErrInvalidPagination
+	}
+	if maxPerPage > 0 {
+		perPage = min(perPage, maxPerPage)
+	}
+	return perPage, nil
+}
+
+// PageNumber parses the page query parameter, 1 by default.
+func PageNumber(c *gin.Context) (int, error) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page <= 0 {
+		return 0, ErrInvalidPagination
+	}
+	return page, nil
+}
+
+// OffsetPage wraps the page-th page of a list of total items.
+func OffsetPage[T any](c *gin.Context, data []T, total, page, perPage int) Page {
+	p := Page{
+		Data:    nonNil(data),
+		Total:   &total,
+		Page:    page,
+		PerPage: perPage,
+		Links:   Links{Self: link(c, nil)},
+	}
+	if page*perPage < total {
+		p.Links.Next = link(c, map[string]string{"page": strconv.Itoa(page + 1)})
+	}
+	if page > 1 {
+		p.Links.Prev = link(c, map[string]string{"page": strconv.Itoa(page - 1)})
+	}
+	return p
+}
+
+// CursorPage wraps a page of a list paged by cursor; next is the cursor of the following page,
+// empty on the last one.
+func CursorPage[T any](c *gin.Context, data []T, perPage int, next string) Page {
+	p := Page{
+		Data:       nonNil(data),
+		PerPage:    perPage,
+		NextCursor: next,
+		Links:      Links{Self: link(c, nil)},
+	}
+	if next != "" {
+		p.Links.Next = link(c, map[string]string{"cursor": next})
+	}
+	return p
+}
+
+// link returns the path and query of the request, with the given query parameters replaced.
+func link(c *gin.Context, set map[string]string) string {
+	u := *c.Request.URL
+	query := u.Query()
+	for key, value := range set {
+		query.Set(key, value)
+	}
+	u.RawQuery = query.Encode()
+	u.Scheme, u.Host, u.User = "", "", nil
+	return u.RequestURI()
+}
+
+// nonNil makes empty lists encode as [] rather than null.
+func nonNil[T any](data []T) []T {
+	if data == nil {
+		return []T{}
+	}
+	return data
+}
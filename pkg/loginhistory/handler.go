@@ -0,0 +1,93 @@
+package loginhistory
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+	"github.com/ebipenman/go-otp-auth-service/pkg/httpx"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type Handler struct {
+	loginService Service
+}
+
+func NewHandler(loginService Service) *Handler {
+	return &Handler{loginService: loginService}
+}
+
+var _ = openapi.Describe((*Handler).ListMyLogins, openapi.Operation{
+	Summary: "List my logins",
+	Description: "Lists the latest sign-ins of the authenticated user, successful and failed, most recent first. " +
+		"Failed attempts carry the reason, e.g. invalid_otp, rate_limited or blocked.",
+	Tags:     []string{"Users"},
+	Security: []string{openapi.BearerAuth},
+	Params: []openapi.Param{
+		{Name: "per_page", In: openapi.InQuery, Description: "Number of logins (default 20, at most 100); limit is accepted too", Type: "integer", Default: 20},
+		{Name: "cursor", In: openapi.InQuery, Description: "next_cursor of the previous page"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Type: httpx.Page{Data: []model.Login{}}},
+		http.StatusBadRequest:          {Description: "Invalid pagination parameters or cursor"},
+		http.StatusUnauthorized:        {Description: "Authorization header required"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) ListMyLogins(c *gin.Context) {
+	val, _ := c.Get(middleware.ContextKeyUser)
+	user, ok := val.(model.User)
+	if !ok {
+		middleware.Abort(c, apperr.ErrAuthRequired)
+		return
+	}
+	h.listLogins(c, user.ID)
+}
+
+var _ = openapi.Describe((*Handler).ListUserLogins, openapi.Operation{
+	Summary:     "List the logins of a user",
+	Description: "Lists the latest sign-ins of any user, successful and failed, most recent first, for support staff.",
+	Tags:        []string{"Admin"},
+	Security:    []string{openapi.APIKeyAuth},
+	Params: []openapi.Param{
+		{Name: "id", In: openapi.InPath, Description: "User ID"},
+		{Name: "per_page", In: openapi.InQuery, Description: "Number of logins (default 20, at most 100); limit is accepted too", Type: "integer", Default: 20},
+		{Name: "cursor", In: openapi.InQuery, Description: "next_cursor of the previous page"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Type: httpx.Page{Data: []model.Login{}}},
+		http.StatusBadRequest:          {Description: "Invalid user ID, pagination parameters or cursor"},
+		http.StatusUnauthorized:        {Description: "API key required"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) ListUserLogins(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.Abort(c, apperr.ErrInvalidUserID)
+		return
+	}
+	h.listLogins(c, id)
+}
+
+func (h *Handler) listLogins(c *gin.Context, userID uuid.UUID) {
+	perPage, err := httpx.PerPage(c, DefaultLimit, MaxLimit)
+	if err != nil {
+		middleware.Abort(c, apperr.ErrInvalidPagination.Wrap(err))
+		return
+	}
+
+	logins, next, err := h.loginService.ListLogins(c.Request.Context(), userID, perPage, c.Query("cursor"))
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to list logins of user %s: %w", userID, err))
+		return
+	}
+	c.JSON(http.StatusOK, httpx.CursorPage(c, logins, perPage, next))
+}
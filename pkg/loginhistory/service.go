@@ -0,0 +1,113 @@
+// Package loginhistory keeps the successful and failed sign-ins of every user, so users and
+// support staff can review recent access to an account.
+package loginhistory
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/tracing"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultLimit is how many logins ListLogins returns unless asked for another number.
+	DefaultLimit = 20
+	// MaxLimit is the most logins ListLogins returns at once.
+	MaxLimit = 100
+)
+
+// ErrInvalidCursor is returned for a cursor that ListLogins didn't hand out.
+var ErrInvalidCursor = apperr.ErrInvalidPagination.Wrap(errors.New("invalid cursor"))
+
+// Service defines the business logic for the login history.
+type Service interface {
+	RecordLogin(ctx context.Context, login model.Login) error
+	// ListLogins returns the user's latest logins, most recent first, starting after the cursor
+	// if one is given. limit is capped at MaxLimit. The returned cursor leads to the next page;
+	// it's empty on the last one.
+	ListLogins(ctx context.Context, userID uuid.UUID, limit int, cursor string) ([]model.Login, string, error)
+}
+
+type loginService struct {
+	repo Repository
+}
+
+func NewService(repo Repository) Service {
+	return &loginService{repo: repo}
+}
+
+func (s *loginService) RecordLogin(ctx context.Context, login model.Login) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "loginhistory.RecordLogin")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	if login.CreatedAt.IsZero() {
+		login.CreatedAt = time.Now()
+	}
+	if _, err := s.repo.CreateLogin(ctx, login); err != nil {
+		return fmt.Errorf("failed to record login: %w", err)
+	}
+	return nil
+}
+
+func (s *loginService) ListLogins(ctx context.Context, userID uuid.UUID, limit int, cursor string) ([]model.Login, string, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "loginhistory.ListLogins")
+	defer span.End()
+
+	var before *model.LoginCursor
+	if cursor != "" {
+		c, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		before = &c
+	}
+
+	// One more than asked for tells whether there is a next page.
+	limit = min(limit, MaxLimit)
+	logins, err := s.repo.ListLogins(ctx, userID, limit+1, before)
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list logins: %w", err)
+	}
+	if len(logins) <= limit {
+		return logins, "", nil
+	}
+	logins = logins[:limit]
+	last := logins[limit-1]
+	return logins, encodeCursor(model.LoginCursor{CreatedAt: last.CreatedAt, ID: last.ID}), nil
+}
+
+// encodeCursor makes the cursor opaque to clients, so its format can change.
+func encodeCursor(c model.LoginCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()))
+}
+
+func decodeCursor(cursor string) (model.LoginCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return model.LoginCursor{}, ErrInvalidCursor
+	}
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return model.LoginCursor{}, ErrInvalidCursor
+	}
+	var c model.LoginCursor
+	if c.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return model.LoginCursor{}, ErrInvalidCursor
+	}
+	if c.ID, err = uuid.Parse(id); err != nil {
+		return model.LoginCursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
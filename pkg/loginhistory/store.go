@@ -0,0 +1,53 @@
+package loginhistory
+
+import (
+	"context"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// Repository defines the interface for login history data operations.
+type Repository interface {
+	CreateLogin(ctx context.Context, login model.Login) (model.Login, error)
+	ListLogins(ctx context.Context, userID uuid.UUID, limit int, before *model.LoginCursor) ([]model.Login, error)
+	AnonymizeLogins(ctx context.Context, userID uuid.UUID) error
+	MoveLogins(ctx context.Context, fromUserID, toUserID uuid.UUID) error
+}
+
+type loginRepository struct {
+	store LoginStore // Using the internal database interface
+}
+
+func NewRepository(store LoginStore) Repository {
+	return &loginRepository{store: store}
+}
+
+func (r *loginRepository) CreateLogin(ctx context.Context, login model.Login) (model.Login, error) {
+	return r.store.CreateLogin(ctx, login)
+}
+
+func (r *loginRepository) ListLogins(ctx context.Context, userID uuid.UUID, limit int, before *model.LoginCursor) ([]model.Login, error) {
+	return r.store.ListLogins(ctx, userID, limit, before)
+}
+
+func (r *loginRepository) AnonymizeLogins(ctx context.Context, userID uuid.UUID) error {
+	return r.store.AnonymizeLogins(ctx, userID)
+}
+
+func (r *loginRepository) MoveLogins(ctx context.Context, fromUserID, toUserID uuid.UUID) error {
+	return r.store.MoveLogins(ctx, fromUserID, toUserID)
+}
+
+// LoginStore is the interface that the database implementation must satisfy.
+type LoginStore interface {
+	CreateLogin(ctx context.Context, login model.Login) (model.Login, error)
+	// ListLogins returns the user's latest limit logins, most recent first, ordered by time and
+	// then ID. With a cursor, only the logins before it count.
+	ListLogins(ctx context.Context, userID uuid.UUID, limit int, before *model.LoginCursor) ([]model.Login, error)
+	// AnonymizeLogins scrubs the IP and user agent of the user's logins.
+	AnonymizeLogins(ctx context.Context, userID uuid.UUID) error
+	// MoveLogins gives the logins of one user to another.
+	MoveLogins(ctx context.Context, fromUserID, toUserID uuid.UUID) error
+}
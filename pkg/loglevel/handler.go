@@ -0,0 +1,112 @@
+// Package loglevel lets admins change the log level at runtime, e.g. to debug for a while
+// when investigating an incident, without a restart.
+package loglevel
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct{}
+
+func NewHandler() *Handler {
+	return &Handler{}
+}
+
+type setLevelRequest struct {
+	Level string `json:"level" binding:"required,oneof=debug info warn error"`
+	// Duration, e.g. "15m", after which the configured level comes back; empty keeps the level
+	// until it's reset or the configuration is reloaded.
+	Duration string `json:"duration"`
+}
+
+var _ = openapi.Describe((*Handler).GetLevel, openapi.Operation{
+	Summary:     "Get the log level",
+	Description: "Reports the log level of this instance, the configured one, and whether it's overridden at runtime and until when.",
+	Tags:        []string{"Admin"},
+	Security:    []string{openapi.APIKeyAuth},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:           {Type: logging.LevelStatus{}},
+		http.StatusUnauthorized: {Description: "API key required"},
+	},
+})
+
+func (h *Handler) GetLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, logging.Level())
+}
+
+var _ = openapi.Describe((*Handler).SetLevel, openapi.Operation{
+	Summary: "Change the log level",
+	Description: "Overrides the log level of this instance (debug, info, warn or error) without a restart, for the duration " +
+		"given or until it's reset. Reloading the configuration (SIGHUP) also ends the override. Behind a load " +
+		"balancer, change every instance.",
+	Tags:     []string{"Admin"},
+	Security: []string{openapi.APIKeyAuth},
+	Body:     &openapi.Body{Description: "The level, and optionally how long it lasts, e.g. 15m", Required: true, Type: setLevelRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:           {Type: logging.LevelStatus{}},
+		http.StatusBadRequest:   {Description: "Invalid request format"},
+		http.StatusUnauthorized: {Description: "API key required"},
+	},
+})
+
+func (h *Handler) SetLevel(c *gin.Context) {
+	var req setLevelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithDetails(c, apperr.ErrInvalidRequest.Wrap(err), err.Error())
+		return
+	}
+	var duration time.Duration
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			middleware.AbortWithDetails(c, apperr.ErrInvalidRequest, "duration must be positive, e.g. 15m")
+			return
+		}
+		duration = d
+	}
+
+	status, err := logging.OverrideLevel(req.Level, duration)
+	if err != nil {
+		middleware.AbortWithDetails(c, apperr.ErrInvalidRequest.Wrap(err), err.Error())
+		return
+	}
+	// Logged at warn, so the change shows up whatever the level.
+	logging.FromContext(c.Request.Context()).Warn("Log level changed", "level", status.Level, "until", status.Until, "changed_by", actor(c))
+	c.JSON(http.StatusOK, status)
+}
+
+var _ = openapi.Describe((*Handler).ResetLevel, openapi.Operation{
+	Summary:     "Reset the log level",
+	Description: "Ends an override of the log level of this instance, going back to the configured level.",
+	Tags:        []string{"Admin"},
+	Security:    []string{openapi.APIKeyAuth},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:           {Type: logging.LevelStatus{}},
+		http.StatusUnauthorized: {Description: "API key required"},
+	},
+})
+
+func (h *Handler) ResetLevel(c *gin.Context) {
+	status := logging.ResetLevel()
+	logging.FromContext(c.Request.Context()).Warn("Log level reset", "level", status.Level, "changed_by", actor(c))
+	c.JSON(http.StatusOK, status)
+}
+
+// actor names the API key of the admin, which the admin routes always authenticate.
+func actor(c *gin.Context) string {
+	if val, ok := c.Get(middleware.ContextKeyAPIKey); ok {
+		if key, ok := val.(model.APIKey); ok {
+			return "api-key:" + key.ID.String()
+		}
+	}
+	return ""
+}
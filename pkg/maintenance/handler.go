@@ -0,0 +1,78 @@
+package maintenance
+
+import (
+	"net/http"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	maintenance *Switch
+}
+
+func NewHandler(maintenance *Switch) *Handler {
+	return &Handler{maintenance: maintenance}
+}
+
+type setStatusRequest struct {
+	Enabled *bool  `json:"enabled" binding:"required"`
+	Message string `json:"message" binding:"max=500"`
+}
+
+var _ = openapi.Describe((*Handler).GetStatus, openapi.Operation{
+	Summary:     "Get the maintenance mode",
+	Description: "Reports whether this instance is in maintenance mode, with the message shown to clients.",
+	Tags:        []string{"Admin"},
+	Security:    []string{openapi.APIKeyAuth},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:           {Type: Status{}},
+		http.StatusUnauthorized: {Description: "API key required"},
+	},
+})
+
+func (h *Handler) GetStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, h.maintenance.Status())
+}
+
+var _ = openapi.Describe((*Handler).SetStatus, openapi.Operation{
+	Summary: "Switch the maintenance mode",
+	Description: "Turns maintenance mode on or off on this instance. While it's on, the OTP endpoints and the GraphQL API " +
+		"answer 503 with the code \"under_maintenance\" and the message, if any; health checks and the admin API " +
+		"keep working. Behind a load balancer, switch every instance, or set MAINTENANCE_MODE in the config " +
+		"file and reload it. A reload only overrides this switch when MAINTENANCE_MODE or MAINTENANCE_MESSAGE " +
+		"changed.",
+	Tags:     []string{"Admin"},
+	Security: []string{openapi.APIKeyAuth},
+	Body:     &openapi.Body{Description: "Whether maintenance mode is on, and the message for clients", Required: true, Type: setStatusRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:           {Type: Status{}},
+		http.StatusBadRequest:   {Description: "Invalid request format"},
+		http.StatusUnauthorized: {Description: "API key required"},
+	},
+})
+
+func (h *Handler) SetStatus(c *gin.Context) {
+	var req setStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithDetails(c, apperr.ErrInvalidRequest.Wrap(err), err.Error())
+		return
+	}
+
+	// The admin routes always authenticate an API key; it's named in the log.
+	var switchedBy string
+	if val, ok := c.Get(middleware.ContextKeyAPIKey); ok {
+		if key, ok := val.(model.APIKey); ok {
+			switchedBy = "api-key:" + key.ID.String()
+		}
+	}
+
+	status := h.maintenance.Set(*req.Enabled, req.Message)
+	logging.FromContext(c.Request.Context()).Warn("Maintenance mode switched", "enabled", status.Enabled, "switched_by", switchedBy)
+	c.JSON(http.StatusOK, status)
+}
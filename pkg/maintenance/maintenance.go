@@ -0,0 +1,69 @@
+// Package maintenance pauses sign-ins during planned maintenance, e.g. of the database: the
+// OTP endpoints answer 503 while health checks and the admin API keep working.
+package maintenance
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Status is whether maintenance mode is on, with the message shown to clients.
+type Status struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+	// Since is when maintenance mode was last switched on or off.
+	Since time.Time `json:"since"`
+}
+
+// Switch turns maintenance mode on and off at runtime. The state is kept by the instance;
+// behind a load balancer every instance has to be switched.
+type Switch struct {
+	mu     sync.RWMutex
+	status Status
+}
+
+// NewSwitch creates a Switch, in maintenance mode if enabled is set.
+func NewSwitch(enabled bool, message string) *Switch {
+	return &Switch{status: Status{Enabled: enabled, Message: message, Since: time.Now()}}
+}
+
+// Set turns maintenance mode on or off and returns the new status.
+func (s *Switch) Set(enabled bool, message string) Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if enabled != s.status.Enabled {
+		s.status.Since = time.Now()
+	}
+	s.status.Enabled, s.status.Message = enabled, message
+	return s.status
+}
+
+// Status returns whether maintenance mode is on.
+func (s *Switch) Status() Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.status
+}
+
+// Gate creates a Gin middleware that rejects requests with 503 while maintenance mode is on.
+// The message of the switch, if any, is returned next to the localized error.
+func Gate(s *Switch) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := s.Status()
+		if !status.Enabled {
+			c.Next()
+			return
+		}
+		body := middleware.ErrorBody(c, http.StatusServiceUnavailable, i18n.CodeUnderMaintenance, nil)
+		if status.Message != "" {
+			body["message"] = status.Message
+		}
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, body)
+	}
+}
@@ -0,0 +1,106 @@
+package maintenance_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/pkg/server/servertest"
+)
+
+const adminKey = "oak_maintenance-test-admin-key"
+
+// request sends body as JSON, with the token if any and the admin key to the admin API, and
+// returns the status and error code.
+func request(t *testing.T, srv *servertest.Server, method, path, token string, body any) (int, string) {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if strings.HasPrefix(path, "/admin/") {
+		req.Header.Set("X-API-Key", adminKey)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := *srv.Client.HTTPClient
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	var problem struct {
+		Code string `json:"code"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&problem)
+	return resp.StatusCode, problem.Code
+}
+
+// TestGateSignIns checks that every route signing in or sending a code answers 503 in
+// maintenance mode, the optional ones included.
+func TestGateSignIns(t *testing.T) {
+	cfg := servertest.Config()
+	cfg.AdminAPIKey = adminKey
+	cfg.GuestTokenTTL = time.Hour
+	cfg.GoogleClientIDs = []string{"google-client"}
+	cfg.FederationIssuer = "https://idp.example.com"
+	cfg.FederationClientID = "otp-service"
+	cfg.FederationRedirectURL = "http://localhost/sso/callback"
+	cfg.OIDCIssuer = "http://auth.example.com"
+	cfg.OIDCClients = map[string]string{"app": "app-secret"}
+	cfg.OIDCRedirectURIs = map[string][]string{"app": {"https://app.example.com/callback"}}
+	srv := servertest.NewServerWithConfig(t, cfg)
+
+	// Signed in before the maintenance starts.
+	token, err := srv.Login(t.Context(), "+14155552671")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if status, code := request(t, srv, http.MethodPut, "/admin/maintenance", "", map[string]any{"enabled": true}); status != http.StatusOK {
+		t.Fatalf("enabling maintenance mode: %d %s", status, code)
+	}
+
+	routes := []struct {
+		method, path string
+		token        string
+	}{
+		{http.MethodPost, "/otp/send", ""},
+		{http.MethodPost, "/otp/send-with-password", ""},
+		{http.MethodPost, "/otp/verify", ""},
+		{http.MethodPost, "/otp/device-login", ""},
+		{http.MethodPost, "/graphql", ""},
+		{http.MethodPost, "/me/reverify", token},
+		{http.MethodPost, "/me/phones", token},
+		{http.MethodPost, "/me/email/verification", token},
+		{http.MethodPost, "/auth/email-recovery/send", ""},
+		{http.MethodPost, "/auth/email-recovery/verify", ""},
+		{http.MethodPost, "/auth/guest", ""},
+		{http.MethodPost, "/auth/social/google", ""},
+		{http.MethodGet, "/sso/login", ""},
+		{http.MethodGet, "/sso/callback", ""},
+		{http.MethodPost, "/authorize", ""},
+		{http.MethodPost, "/token", ""},
+	}
+	for _, route := range routes {
+		status, code := request(t, srv, route.method, route.path, route.token, map[string]string{})
+		if status != http.StatusServiceUnavailable || code != i18n.CodeUnderMaintenance {
+			t.Errorf("%s %s: %d %q, want %d %q", route.method, route.path, status, code, http.StatusServiceUnavailable, i18n.CodeUnderMaintenance)
+		}
+	}
+
+	// Signed-in users keep using the service.
+	if status, code := request(t, srv, http.MethodGet, "/me", token, nil); status != http.StatusOK {
+		t.Errorf("GET /me: %d %q, want 200", status, code)
+	}
+}
@@ -0,0 +1,111 @@
+package merge
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type Handler struct {
+	mergeService Service
+}
+
+func NewHandler(mergeService Service) *Handler {
+	return &Handler{mergeService: mergeService}
+}
+
+type mergeUsersRequest struct {
+	SourceUserID string `json:"source_user_id" binding:"required,uuid"`
+	Reason       string `json:"reason" binding:"max=500"`
+}
+
+var _ = openapi.Describe((*Handler).MergeUsers, openapi.Operation{
+	Summary: "Merge a user into another",
+	Description: "Merges a duplicate user, e.g. someone who registered again with a new phone number, into the " +
+		"user in the path. That user keeps their ID and takes over the devices, logins, linked social " +
+		"accounts and secondary phone numbers of the source user; the source's primary number becomes " +
+		"one of their secondary numbers. An empty name or email is filled in from the source user, whose " +
+		"email address has to be verified again. The source user is deleted, their tokens revoked and " +
+		"a user.merged event is emitted. The merge is recorded with the API key and reason, and returned.",
+	Tags:     []string{"Admin"},
+	Security: []string{openapi.APIKeyAuth},
+	Params: []openapi.Param{
+		{Name: "id", In: openapi.InPath, Description: "ID of the user to keep"},
+	},
+	Body: &openapi.Body{Description: "ID of the user to merge and why", Required: true, Type: mergeUsersRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Type: model.UserMerge{}},
+		http.StatusBadRequest:          {Description: "Invalid user ID or request format, or a user merged into themselves"},
+		http.StatusUnauthorized:        {Description: "API key required"},
+		http.StatusNotFound:            {Description: "User not found"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) MergeUsers(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.Abort(c, apperr.ErrInvalidUserID)
+		return
+	}
+	var req mergeUsersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithDetails(c, apperr.ErrInvalidRequest.Wrap(err), err.Error())
+		return
+	}
+	sourceID := uuid.MustParse(req.SourceUserID)
+
+	// The admin routes always authenticate an API key; it's named in the audit record.
+	var mergedBy string
+	if val, ok := c.Get(middleware.ContextKeyAPIKey); ok {
+		if key, ok := val.(model.APIKey); ok {
+			mergedBy = "api-key:" + key.ID.String()
+		}
+	}
+
+	merge, err := h.mergeService.MergeUsers(c.Request.Context(), id, sourceID, mergedBy, req.Reason)
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to merge user %s into %s: %w", sourceID, id, err))
+		return
+	}
+	c.JSON(http.StatusOK, merge)
+}
+
+var _ = openapi.Describe((*Handler).ListMerges, openapi.Operation{
+	Summary: "List the merges of a user",
+	Description: "Lists the merges a user took part in, kept or merged, newest first. Merged users are deleted but " +
+		"their merges can still be listed.",
+	Tags:     []string{"Admin"},
+	Security: []string{openapi.APIKeyAuth},
+	Params: []openapi.Param{
+		{Name: "id", In: openapi.InPath, Description: "User ID"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Type: []model.UserMerge{}},
+		http.StatusBadRequest:          {Description: "Invalid user ID"},
+		http.StatusUnauthorized:        {Description: "API key required"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) ListMerges(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.Abort(c, apperr.ErrInvalidUserID)
+		return
+	}
+
+	merges, err := h.mergeService.ListMerges(c.Request.Context(), id)
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to list merges of user %s: %w", id, err))
+		return
+	}
+	c.JSON(http.StatusOK, merges)
+}
@@ -0,0 +1,186 @@
+// Package merge merges duplicate users, e.g. someone who registered again with their new
+// phone number instead of changing it. The target user keeps their ID and takes over the
+// devices, logins, linked accounts and phone numbers of the source user, who is deleted.
+// Every merge leaves an audit record behind.
+package merge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/database"
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/tracing"
+	"github.com/ebipenman/go-otp-auth-service/pkg/auth"
+
+	"github.com/google/uuid"
+)
+
+// ErrSameUser means a user was to be merged into themselves.
+var ErrSameUser = apperr.New(http.StatusBadRequest, i18n.CodeMergeSameUser)
+
+// Service defines the business logic for merging users.
+type Service interface {
+	// MergeUsers moves the devices, logins, linked social accounts and secondary phone numbers
+	// of the source user to the target user, and adds the source's primary phone number as a
+	// secondary number of the target. The target's empty name and email are filled in from the
+	// source; a taken over email address has to be verified again. The source user is deleted
+	// and their tokens revoked. Until then a failed run can be repeated. mergedBy and reason
+	// end up in the audit record, which is returned. It returns ErrUserNotFound if either user
+	// is unknown or deleted.
+	MergeUsers(ctx context.Context, targetID, sourceID uuid.UUID, mergedBy, reason string) (model.UserMerge, error)
+	// ListMerges returns the merges the user took part in, as target or source, newest first.
+	// Merged users are deleted, so unknown users aren't an error.
+	ListMerges(ctx context.Context, userID uuid.UUID) ([]model.UserMerge, error)
+}
+
+type mergeService struct {
+	repo   Repository
+	tokens auth.TokenRevoker
+	events auth.EventPublisher
+}
+
+func NewService(repo Repository, tokens auth.TokenRevoker, events auth.EventPublisher) Service {
+	return &mergeService{repo: repo, tokens: tokens, events: events}
+}
+
+func (s *mergeService) MergeUsers(ctx context.Context, targetID, sourceID uuid.UUID, mergedBy, reason string) (merge model.UserMerge, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "merge.MergeUsers")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	logger := logging.FromContext(ctx)
+
+	if targetID == sourceID {
+		return model.UserMerge{}, ErrSameUser
+	}
+	target, err := s.repo.GetUserByID(ctx, targetID)
+	if err != nil {
+		return model.UserMerge{}, userError("target", err)
+	}
+	source, err := s.repo.GetUserByID(ctx, sourceID)
+	if err != nil {
+		return model.UserMerge{}, userError("source", err)
+	}
+
+	// The source user goes last: until they're deleted, a failed run can be repeated.
+	if err := s.repo.MoveDevices(ctx, sourceID, targetID); err != nil {
+		return model.UserMerge{}, fmt.Errorf("failed to move devices: %w", err)
+	}
+	if err := s.repo.MoveLogins(ctx, sourceID, targetID); err != nil {
+		return model.UserMerge{}, fmt.Errorf("failed to move logins: %w", err)
+	}
+	if err := s.repo.MoveIdentities(ctx, sourceID, targetID); err != nil {
+		return model.UserMerge{}, fmt.Errorf("failed to move identities: %w", err)
+	}
+	if err := s.repo.MovePhoneNumbers(ctx, sourceID, targetID); err != nil {
+		return model.UserMerge{}, fmt.Errorf("failed to move phone numbers: %w", err)
+	}
+	// Exports hold a copy of the source user's data only, and would be orphaned.
+	if err := s.repo.DeleteExports(ctx, sourceID); err != nil {
+		return model.UserMerge{}, fmt.Errorf("failed to delete exports: %w", err)
+	}
+	if err := s.fillProfile(ctx, target, source); err != nil {
+		return model.UserMerge{}, err
+	}
+	if err := s.addPhoneNumber(ctx, targetID, source); err != nil {
+		return model.UserMerge{}, err
+	}
+
+	merge, err = s.repo.CreateUserMerge(ctx, model.UserMerge{
+		TargetUserID:      targetID,
+		SourceUserID:      sourceID,
+		SourcePhoneNumber: source.PhoneNumber,
+		MergedBy:          mergedBy,
+		Reason:            reason,
+	})
+	if err != nil {
+		return model.UserMerge{}, fmt.Errorf("failed to record merge: %w", err)
+	}
+	if err := s.repo.DeleteUser(ctx, sourceID); err != nil {
+		return model.UserMerge{}, fmt.Errorf("failed to delete source user: %w", err)
+	}
+	if err := s.tokens.RevokeTokens(ctx, sourceID, time.Now()); err != nil {
+		logger.Error("Failed to revoke tokens of merged user", "user_id", sourceID, "error", err)
+	}
+
+	logger.Info("Merged users", "target_user_id", targetID, "source_user_id", sourceID, "merged_by", mergedBy)
+	s.events.Publish(model.NewEvent(model.EventUserMerged, map[string]interface{}{
+		"user_id":        targetID,
+		"source_user_id": sourceID,
+		"phone_number":   source.PhoneNumber,
+	}))
+	return merge, nil
+}
+
+func (s *mergeService) ListMerges(ctx context.Context, userID uuid.UUID) (merges []model.UserMerge, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "merge.ListMerges")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	merges, err = s.repo.ListUserMerges(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list merges: %w", err)
+	}
+	return merges, nil
+}
+
+// fillProfile copies the name and email of the source user where the target has none.
+func (s *mergeService) fillProfile(ctx context.Context, target, source model.User) error {
+	changed := false
+	if target.Name == "" && source.Name != "" {
+		target.Name, changed = source.Name, true
+	}
+	if target.Email == "" && source.Email != "" {
+		target.Email, changed = source.Email, true
+	}
+	if !changed {
+		return nil
+	}
+	if _, err := s.repo.UpdateUser(ctx, target); err != nil {
+		return fmt.Errorf("failed to update target user: %w", err)
+	}
+	return nil
+}
+
+// addPhoneNumber adds the primary phone number of the source user as a secondary number of
+// the target, unless a previous run did so already.
+func (s *mergeService) addPhoneNumber(ctx context.Context, targetID uuid.UUID, source model.User) error {
+	// The number was last verified when the source user was.
+	verifiedAt := source.PhoneVerifiedAt
+	if verifiedAt == nil {
+		verifiedAt = &source.CreatedAt
+	}
+	_, err := s.repo.AddPhoneNumber(ctx, model.UserPhone{
+		UserID:      targetID,
+		PhoneNumber: source.PhoneNumber,
+		VerifiedAt:  verifiedAt,
+	})
+	if errors.Is(err, database.ErrAlreadyExists) {
+		existing, getErr := s.repo.GetPhoneNumber(ctx, source.PhoneNumber)
+		if getErr == nil && existing.UserID == targetID {
+			return nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add phone number to target user: %w", err)
+	}
+	return nil
+}
+
+// userError names which user of the merge wasn't found.
+func userError(role string, err error) error {
+	if errors.Is(err, ErrUserNotFound) {
+		return fmt.Errorf("%s %w", role, ErrUserNotFound)
+	}
+	return fmt.Errorf("failed to get %s user: %w", role, err)
+}
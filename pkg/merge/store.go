@@ -0,0 +1,115 @@
+package merge
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/database"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/pkg/device"
+	"github.com/ebipenman/go-otp-auth-service/pkg/export"
+	"github.com/ebipenman/go-otp-auth-service/pkg/loginhistory"
+	"github.com/ebipenman/go-otp-auth-service/pkg/social"
+	"github.com/ebipenman/go-otp-auth-service/pkg/user"
+
+	"github.com/google/uuid"
+)
+
+var ErrUserNotFound = apperr.ErrUserNotFound
+
+// Repository defines the data operations that merge one user into another.
+type Repository interface {
+	GetUserByID(ctx context.Context, id uuid.UUID) (model.User, error)
+	UpdateUser(ctx context.Context, user model.User) (model.User, error)
+	DeleteUser(ctx context.Context, id uuid.UUID) error
+	AddPhoneNumber(ctx context.Context, phone model.UserPhone) (model.UserPhone, error)
+	GetPhoneNumber(ctx context.Context, phoneNumber string) (model.UserPhone, error)
+	MovePhoneNumbers(ctx context.Context, fromUserID, toUserID uuid.UUID) error
+	MoveDevices(ctx context.Context, fromUserID, toUserID uuid.UUID) error
+	MoveLogins(ctx context.Context, fromUserID, toUserID uuid.UUID) error
+	MoveIdentities(ctx context.Context, fromUserID, toUserID uuid.UUID) error
+	DeleteExports(ctx context.Context, userID uuid.UUID) error
+	CreateUserMerge(ctx context.Context, merge model.UserMerge) (model.UserMerge, error)
+	ListUserMerges(ctx context.Context, userID uuid.UUID) ([]model.UserMerge, error)
+}
+
+type mergeRepository struct {
+	userRepo   user.Repository
+	deviceRepo device.Repository
+	loginRepo  loginhistory.Repository
+	identities social.IdentityStore
+	exports    export.ExportStore
+	merges     MergeStore
+}
+
+func NewRepository(userRepo user.Repository, deviceRepo device.Repository, loginRepo loginhistory.Repository, identities social.IdentityStore, exports export.ExportStore, merges MergeStore) Repository {
+	return &mergeRepository{
+		userRepo:   userRepo,
+		deviceRepo: deviceRepo,
+		loginRepo:  loginRepo,
+		identities: identities,
+		exports:    exports,
+		merges:     merges,
+	}
+}
+
+func (r *mergeRepository) GetUserByID(ctx context.Context, id uuid.UUID) (model.User, error) {
+	u, err := r.userRepo.GetUserByID(ctx, id)
+	if errors.Is(err, database.ErrNotFound) {
+		return model.User{}, ErrUserNotFound
+	}
+	return u, err
+}
+
+func (r *mergeRepository) UpdateUser(ctx context.Context, u model.User) (model.User, error) {
+	return r.userRepo.UpdateUser(ctx, u)
+}
+
+func (r *mergeRepository) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	return r.userRepo.DeleteUser(ctx, id)
+}
+
+func (r *mergeRepository) AddPhoneNumber(ctx context.Context, phone model.UserPhone) (model.UserPhone, error) {
+	return r.userRepo.AddPhoneNumber(ctx, phone)
+}
+
+func (r *mergeRepository) GetPhoneNumber(ctx context.Context, phoneNumber string) (model.UserPhone, error) {
+	return r.userRepo.GetPhoneNumber(ctx, phoneNumber)
+}
+
+func (r *mergeRepository) MovePhoneNumbers(ctx context.Context, fromUserID, toUserID uuid.UUID) error {
+	return r.userRepo.MovePhoneNumbers(ctx, fromUserID, toUserID)
+}
+
+func (r *mergeRepository) MoveDevices(ctx context.Context, fromUserID, toUserID uuid.UUID) error {
+	return r.deviceRepo.MoveDevices(ctx, fromUserID, toUserID)
+}
+
+func (r *mergeRepository) MoveLogins(ctx context.Context, fromUserID, toUserID uuid.UUID) error {
+	return r.loginRepo.MoveLogins(ctx, fromUserID, toUserID)
+}
+
+func (r *mergeRepository) MoveIdentities(ctx context.Context, fromUserID, toUserID uuid.UUID) error {
+	return r.identities.MoveIdentities(ctx, fromUserID, toUserID)
+}
+
+func (r *mergeRepository) DeleteExports(ctx context.Context, userID uuid.UUID) error {
+	return r.exports.DeleteExports(ctx, userID)
+}
+
+func (r *mergeRepository) CreateUserMerge(ctx context.Context, merge model.UserMerge) (model.UserMerge, error) {
+	return r.merges.CreateUserMerge(ctx, merge)
+}
+
+func (r *mergeRepository) ListUserMerges(ctx context.Context, userID uuid.UUID) ([]model.UserMerge, error) {
+	return r.merges.ListUserMerges(ctx, userID)
+}
+
+// MergeStore is the interface that the database implementation must satisfy.
+type MergeStore interface {
+	// CreateUserMerge records a merge and returns it with its ID and creation time.
+	CreateUserMerge(ctx context.Context, merge model.UserMerge) (model.UserMerge, error)
+	// ListUserMerges returns the merges the user took part in, as target or source, newest first.
+	ListUserMerges(ctx context.Context, userID uuid.UUID) ([]model.UserMerge, error)
+}
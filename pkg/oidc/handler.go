@@ -0,0 +1,293 @@
+package oidc
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/pkg/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type Handler struct {
+	provider    *Provider
+	authService auth.Service
+}
+
+func NewHandler(provider *Provider, authService auth.Service) *Handler {
+	return &Handler{provider: provider, authService: authService}
+}
+
+// authorizeRequest holds the authorization request parameters. The sign-in page posts them
+// back in hidden fields, along with the phone number and, in the second step, the OTP.
+type authorizeRequest struct {
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	ResponseType        string `form:"response_type"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	Nonce               string `form:"nonce"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+	PhoneNumber         string `form:"phone_number"`
+	OTP                 string `form:"otp"`
+}
+
+// pageData fills the sign-in page.
+type pageData struct {
+	Request authorizeRequest
+	// CodeSent switches the page from asking for the phone number to asking for the OTP.
+	CodeSent bool
+	Error    string
+}
+
+var page = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Sign in</title></head>
+<body>
+<h1>Sign in</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+{{with .Request}}{{if .ClientID}}<form method="post" action="authorize">
+<input type="hidden" name="client_id" value="{{.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+<input type="hidden" name="response_type" value="{{.ResponseType}}">
+<input type="hidden" name="scope" value="{{.Scope}}">
+<input type="hidden" name="state" value="{{.State}}">
+<input type="hidden" name="nonce" value="{{.Nonce}}">
+<input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}">
+{{if $.CodeSent}}<input type="hidden" name="phone_number" value="{{.PhoneNumber}}">
+<p>Enter the code sent to {{.PhoneNumber}}.</p>
+<label>Code <input name="otp" inputmode="numeric" autocomplete="one-time-code" maxlength="6" required autofocus></label>
+{{else}}<label>Phone number <input name="phone_number" type="tel" autocomplete="tel" placeholder="+14155550123" value="{{.PhoneNumber}}" required autofocus></label>
+{{end}}<button type="submit">Continue</button>
+</form>{{end}}{{end}}
+</body>
+</html>
+`))
+
+// Discovery serves the OpenID Provider Metadata.
+func (h *Handler) Discovery(c *gin.Context) {
+	c.JSON(http.StatusOK, h.provider.Discovery())
+}
+
+// JWKS serves the keys ID tokens are signed with.
+func (h *Handler) JWKS(c *gin.Context) {
+	c.JSON(http.StatusOK, h.provider.JWKS())
+}
+
+// Authorize serves the sign-in page of the authorization code flow. The GET request from the
+// relying party shows the phone number form; posting it sends the OTP, and posting the OTP
+// signs the user in and redirects back to the relying party with an authorization code.
+func (h *Handler) Authorize(c *gin.Context) {
+	var req authorizeRequest
+	if err := c.ShouldBind(&req); err != nil {
+		h.renderError(c, http.StatusBadRequest, i18n.CodeInvalidRequest, nil)
+		return
+	}
+	// Errors can't be sent back to a redirect URI that isn't registered.
+	if err := h.provider.CheckClient(req.ClientID, req.RedirectURI); err != nil {
+		h.renderError(c, http.StatusBadRequest, i18n.CodeUnknownClient, nil)
+		return
+	}
+	switch {
+	case req.ResponseType != "code":
+		redirectError(c, req, "unsupported_response_type")
+		return
+	case !slices.Contains(strings.Fields(req.Scope), "openid"):
+		redirectError(c, req, "invalid_scope")
+		return
+	case req.CodeChallengeMethod != "" && req.CodeChallengeMethod != "S256",
+		req.CodeChallengeMethod == "S256" && req.CodeChallenge == "":
+		redirectError(c, req, "invalid_request")
+		return
+	}
+
+	ctx := c.Request.Context()
+	client := model.ClientInfo{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+	switch {
+	case c.Request.Method == http.MethodGet || req.PhoneNumber == "":
+		h.render(c, http.StatusOK, pageData{Request: req})
+
+	case req.OTP == "":
+		_, rateLimit, err := h.authService.SendOTP(ctx, req.PhoneNumber, client)
+		if err != nil {
+			code, params := errorCode(err, rateLimit, i18n.CodeOTPRateLimited)
+			h.render(c, http.StatusOK, pageData{Request: req, Error: i18n.FromContext(ctx).Message(code, params)})
+			return
+		}
+		h.render(c, http.StatusOK, pageData{Request: req, CodeSent: true})
+
+	default:
+		token, rateLimit, err := h.authService.VerifyOTPAndAuthenticate(ctx, req.PhoneNumber, req.OTP, client)
+		if err != nil {
+			code, params := errorCode(err, rateLimit, i18n.CodeVerifyRateLimited)
+			h.render(c, http.StatusOK, pageData{Request: req, CodeSent: true, Error: i18n.FromContext(ctx).Message(code, params)})
+			return
+		}
+		grant, err := grantFromToken(token)
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed to read the issued token", "error", err)
+			h.renderError(c, http.StatusInternalServerError, i18n.CodeInternal, nil)
+			return
+		}
+		grant.ClientID = req.ClientID
+		grant.RedirectURI = req.RedirectURI
+		grant.Nonce = req.Nonce
+		grant.CodeChallenge = req.CodeChallenge
+		code, err := h.provider.IssueCode(grant)
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed to issue authorization code", "error", err)
+			h.renderError(c, http.StatusInternalServerError, i18n.CodeInternal, nil)
+			return
+		}
+		redirect(c, req, url.Values{"code": {code}})
+	}
+}
+
+// Token exchanges an authorization code for the ID token and an access token. Errors
+// follow RFC 6749 rather than the service's error format, as OAuth clients expect.
+func (h *Handler) Token(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	clientID, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		clientID, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+	if !h.provider.AuthenticateClient(clientID, secret) {
+		c.Header("WWW-Authenticate", `Basic realm="token"`)
+		tokenError(c, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if c.PostForm("grant_type") != "authorization_code" {
+		tokenError(c, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	grant, err := h.provider.RedeemCode(c.PostForm("code"), clientID, c.PostForm("redirect_uri"), c.PostForm("code_verifier"))
+	if err != nil {
+		tokenError(c, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	idToken, err := h.provider.IDToken(grant)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to sign ID token", "error", err)
+		tokenError(c, http.StatusInternalServerError, "server_error")
+		return
+	}
+	accessToken, expiresAt, err := h.provider.AccessToken(grant)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to sign access token", "error", err)
+		tokenError(c, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(time.Until(expiresAt).Seconds()),
+		"id_token":     idToken,
+		"scope":        scope,
+	})
+}
+
+// UserInfo returns the claims of the user the access token was issued to. It runs behind
+// the JWT middleware validating the access tokens of the provider, which puts the user into
+// the context.
+func (h *Handler) UserInfo(c *gin.Context) {
+	value, _ := c.Get(middleware.ContextKeyUser)
+	user, ok := value.(model.User)
+	if !ok {
+		middleware.Abort(c, apperr.ErrAuthRequired)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"sub":                   user.ID.String(),
+		"phone_number":          user.PhoneNumber,
+		"phone_number_verified": true,
+	})
+}
+
+func (h *Handler) render(c *gin.Context, status int, data pageData) {
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	if err := page.Execute(c.Writer, data); err != nil {
+		logging.FromContext(c.Request.Context()).Error("Failed to render sign-in page", "error", err)
+	}
+}
+
+func (h *Handler) renderError(c *gin.Context, status int, code string, params i18n.Params) {
+	h.render(c, status, pageData{Error: i18n.FromContext(c.Request.Context()).Message(code, params)})
+}
+
+// redirect sends the user back to the relying party with the parameters and the state.
+func redirect(c *gin.Context, req authorizeRequest, params url.Values) {
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	target, _ := url.Parse(req.RedirectURI) // checked by CheckClient
+	query := target.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	target.RawQuery = query.Encode()
+	c.Redirect(http.StatusFound, target.String())
+}
+
+// redirectError reports an invalid authorization request to the relying party.
+func redirectError(c *gin.Context, req authorizeRequest, code string) {
+	redirect(c, req, url.Values{"error": {code}})
+}
+
+func tokenError(c *gin.Context, status int, code string) {
+	c.JSON(status, gin.H{"error": code})
+}
+
+// errorCode maps an error of the auth service to the message shown on the sign-in page.
+func errorCode(err error, rateLimit model.RateLimitResult, rateLimited string) (string, i18n.Params) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidPhoneNumber):
+		return i18n.CodeInvalidPhoneNumber, nil
+	case errors.Is(err, auth.ErrCountryNotAllowed):
+		return i18n.CodeCountryNotAllowed, nil
+	case errors.Is(err, auth.ErrQuotaExceeded):
+		return i18n.CodeOTPQuotaExceeded, nil
+	case errors.Is(err, auth.ErrRateLimitExceeded):
+		return rateLimited, i18n.Params{"seconds": middleware.RetryAfterSeconds(rateLimit)}
+	case errors.Is(err, auth.ErrInvalidOTP):
+		return i18n.CodeInvalidOTP, nil
+	case errors.Is(err, auth.ErrPasswordRequired):
+		return i18n.CodePasswordRequired, nil
+	case errors.Is(err, auth.ErrUserBanned):
+		return i18n.CodeAccountBanned, nil
+	case errors.As(err, new(*auth.RestorableError)):
+		return i18n.CodeAccountRestorable, nil
+	case errors.Is(err, auth.ErrRiskChallenge):
+		return i18n.CodeChallengeRequired, nil
+	case errors.Is(err, auth.ErrRiskDenied):
+		return i18n.CodeRequestDenied, nil
+	}
+	return i18n.CodeInternal, nil
+}
+
+// grantFromToken reads the user from the JWT the auth service just issued.
+func grantFromToken(token string) (Grant, error) {
+	claims := &middleware.Claims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return Grant{}, err
+	}
+	return Grant{UserID: claims.Subject, PhoneNumber: claims.Phone, AuthTime: time.Now()}, nil
+}
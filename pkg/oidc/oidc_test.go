@@ -0,0 +1,188 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/config"
+	"github.com/ebipenman/go-otp-auth-service/pkg/server/servertest"
+)
+
+const (
+	clientID     = "app"
+	clientSecret = "app-secret"
+	redirectURI  = "https://app.example.com/callback"
+	phoneNumber  = "+14155552671"
+)
+
+func newConfig() *config.Config {
+	cfg := servertest.Config()
+	cfg.OIDCIssuer = "http://auth.example.com"
+	cfg.OIDCClients = map[string]string{clientID: clientSecret, "other": "other-secret"}
+	cfg.OIDCRedirectURIs = map[string][]string{clientID: {redirectURI}, "other": {"https://other.example.com/callback"}}
+	return cfg
+}
+
+func newServer(t *testing.T) *servertest.Server {
+	return servertest.NewServerWithConfig(t, newConfig())
+}
+
+// noRedirects returns a client of the server that reports redirects instead of following them.
+func noRedirects(srv *servertest.Server) *http.Client {
+	client := *srv.Client.HTTPClient
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	return &client
+}
+
+func authorizeParams(clientID, redirectURI string) url.Values {
+	return url.Values{
+		"client_id":     {clientID},
+		"redirect_uri":  {redirectURI},
+		"response_type": {"code"},
+		"scope":         {"openid phone"},
+		"state":         {"xyz"},
+	}
+}
+
+// signIn goes through the sign-in page like a user and returns the authorization code.
+func signIn(t *testing.T, srv *servertest.Server) string {
+	t.Helper()
+	client := noRedirects(srv)
+	form := authorizeParams(clientID, redirectURI)
+	form.Set("phone_number", phoneNumber)
+	resp, err := client.PostForm(srv.URL+"/authorize", form)
+	if err != nil {
+		t.Fatalf("posting the phone number: %v", err)
+	}
+	resp.Body.Close()
+
+	code, ok := srv.Sender.LastCode(phoneNumber)
+	if !ok {
+		t.Fatalf("no OTP was sent to %s", phoneNumber)
+	}
+	form.Set("otp", code)
+	resp, err = client.PostForm(srv.URL+"/authorize", form)
+	if err != nil {
+		t.Fatalf("posting the OTP: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("posting the OTP: status %d, want a redirect", resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), redirectURI) || location.Query().Get("state") != "xyz" {
+		t.Fatalf("redirected to %q, want %s with the state", resp.Header.Get("Location"), redirectURI)
+	}
+	return location.Query().Get("code")
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+}
+
+func redeem(t *testing.T, srv *servertest.Server, code string) (int, tokenResponse) {
+	t.Helper()
+	resp, err := srv.Client.HTTPClient.PostForm(srv.URL+"/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+	})
+	if err != nil {
+		t.Fatalf("token request: %v", err)
+	}
+	defer resp.Body.Close()
+	var body tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding token response: %v", err)
+	}
+	return resp.StatusCode, body
+}
+
+func get(t *testing.T, srv *servertest.Server, path, token string) int {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := srv.Client.HTTPClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	srv := newServer(t)
+	code := signIn(t, srv)
+
+	status, tokens := redeem(t, srv, code)
+	if status != http.StatusOK || tokens.AccessToken == "" || tokens.IDToken == "" {
+		t.Fatalf("token request = %d %+v, want tokens", status, tokens)
+	}
+	if status := get(t, srv, "/userinfo", tokens.AccessToken); status != http.StatusOK {
+		t.Errorf("GET /userinfo with the access token = %d, want 200", status)
+	}
+	// The access token is only good for /userinfo.
+	if status := get(t, srv, "/me", tokens.AccessToken); status != http.StatusUnauthorized {
+		t.Errorf("GET /me with the access token = %d, want 401", status)
+	}
+	if status := get(t, srv, "/userinfo", tokens.IDToken); status != http.StatusUnauthorized {
+		t.Errorf("GET /userinfo with the ID token = %d, want 401", status)
+	}
+
+	// Codes are single-use.
+	if status, body := redeem(t, srv, code); status != http.StatusBadRequest || body.Error != "invalid_grant" {
+		t.Errorf("replayed token request = %d %+v, want 400 invalid_grant", status, body)
+	}
+}
+
+func TestUserInfoRejectsServiceJWT(t *testing.T) {
+	srv := newServer(t)
+	token, err := srv.Login(context.Background(), phoneNumber)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if status := get(t, srv, "/userinfo", token); status != http.StatusUnauthorized {
+		t.Errorf("GET /userinfo with the service JWT = %d, want 401", status)
+	}
+}
+
+func TestAuthorizeRejectsRedirectURIOfOtherClient(t *testing.T) {
+	srv := newServer(t)
+	// The redirect URI is registered, but by another client.
+	resp, err := noRedirects(srv).Get(srv.URL + "/authorize?" + authorizeParams("other", redirectURI).Encode())
+	if err != nil {
+		t.Fatalf("GET /authorize: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("GET /authorize = %d, want 400", resp.StatusCode)
+	}
+	if location := resp.Header.Get("Location"); location != "" {
+		t.Errorf("redirected to %q, want no redirect", location)
+	}
+}
+
+func TestTokenRateLimited(t *testing.T) {
+	cfg := newConfig()
+	cfg.IPRateLimit = config.RateLimit{Max: 3, Window: time.Minute}
+	srv := servertest.NewServerWithConfig(t, cfg)
+
+	// Guessing at codes (or client secrets) runs into the per-IP limit.
+	for i := range cfg.IPRateLimit.Max {
+		if status, body := redeem(t, srv, "guessed-code"); status != http.StatusBadRequest {
+			t.Fatalf("token request %d = %d %+v, want 400", i+1, status, body)
+		}
+	}
+	if status, _ := redeem(t, srv, "guessed-code"); status != http.StatusTooManyRequests {
+		t.Errorf("token request over the limit = %d, want 429", status)
+	}
+}
@@ -0,0 +1,301 @@
+// Package oidc lets OpenID Connect relying parties (apps, API gateways) sign users in with the
+// OTP flow, using the authorization code flow with optional PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// codeTTL is how long an authorization code can be redeemed.
+	codeTTL = time.Minute
+	// idTokenTTL is how long ID tokens are valid. Relying parties only check them at sign-in.
+	idTokenTTL = time.Hour
+	// accessTokenTTL is how long access tokens are accepted by /userinfo.
+	accessTokenTTL = time.Hour
+	// accessTokenType is the typ header of access tokens (RFC 9068). It tells them apart from
+	// the ID tokens, which are signed with the same key.
+	accessTokenType = "at+jwt"
+	// scope is the scope of every access token; it only grants /userinfo.
+	scope = "openid phone"
+)
+
+var (
+	ErrUnknownClient = errors.New("unknown client or redirect URI")
+	ErrInvalidGrant  = errors.New("invalid, expired or already used authorization code")
+)
+
+// Grant is what an authorization code stands for: a user who signed in for a client.
+type Grant struct {
+	ClientID    string
+	RedirectURI string
+	Nonce       string
+	// CodeChallenge is the PKCE S256 challenge, empty when the client didn't send one.
+	CodeChallenge string
+	UserID        string
+	PhoneNumber   string
+	AuthTime      time.Time
+
+	expiresAt time.Time
+}
+
+// accessTokenClaims are the claims of an access token, issued for the client (aud).
+type accessTokenClaims struct {
+	jwt.RegisteredClaims
+	ClientID    string `json:"client_id"`
+	Scope       string `json:"scope"`
+	PhoneNumber string `json:"phone_number"`
+}
+
+// Client is a registered relying party.
+type Client struct {
+	Secret string
+	// RedirectURIs are the only URIs the client's users are sent back to.
+	RedirectURIs []string
+}
+
+// Provider issues authorization codes and ID tokens. Codes are kept in memory, so with
+// several replicas the token request must reach the replica that issued the code.
+type Provider struct {
+	issuer  string
+	clients map[string]Client // by client ID
+	key     *rsa.PrivateKey
+	keyID   string
+
+	mu    sync.Mutex
+	codes map[string]Grant
+}
+
+func NewProvider(issuer string, clients map[string]Client, key *rsa.PrivateKey) *Provider {
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	sum := sha256.Sum256(der)
+	return &Provider{
+		issuer:  issuer,
+		clients: clients,
+		key:     key,
+		keyID:   base64.RawURLEncoding.EncodeToString(sum[:12]),
+		codes:   make(map[string]Grant),
+	}
+}
+
+// LoadSigningKey reads a PEM encoded RSA private key (PKCS#1 or PKCS#8). Without a file a
+// new key is generated, which invalidates the ID tokens issued before each restart.
+func LoadSigningKey(path string) (*rsa.PrivateKey, error) {
+	if path == "" {
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s contains no PEM data", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s contains no RSA private key: %w", path, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s contains no RSA private key", path)
+	}
+	return key, nil
+}
+
+// Issuer is the issuer identifier, the base URL of the provider's endpoints.
+func (p *Provider) Issuer() string {
+	return p.issuer
+}
+
+// CheckClient reports whether the client exists and registered the redirect URI.
+func (p *Provider) CheckClient(clientID, redirectURI string) error {
+	if client, ok := p.clients[clientID]; !ok || !slices.Contains(client.RedirectURIs, redirectURI) {
+		return ErrUnknownClient
+	}
+	return nil
+}
+
+// AuthenticateClient checks the client's secret.
+func (p *Provider) AuthenticateClient(clientID, secret string) bool {
+	client, ok := p.clients[clientID]
+	return ok && subtle.ConstantTimeCompare([]byte(client.Secret), []byte(secret)) == 1
+}
+
+// IssueCode stores the grant and returns the single-use code standing for it.
+func (p *Provider) IssueCode(grant Grant) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	// Forget the codes nobody redeemed.
+	for c, g := range p.codes {
+		if now.After(g.expiresAt) {
+			delete(p.codes, c)
+		}
+	}
+	grant.expiresAt = now.Add(codeTTL)
+	p.codes[code] = grant
+	return code, nil
+}
+
+// RedeemCode returns the grant of the code, which can't be used again afterwards. The code
+// must have been issued to the client for the redirect URI, and the verifier must match
+// the PKCE challenge if there was one.
+func (p *Provider) RedeemCode(code, clientID, redirectURI, codeVerifier string) (Grant, error) {
+	p.mu.Lock()
+	grant, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	if !ok || time.Now().After(grant.expiresAt) || grant.ClientID != clientID || grant.RedirectURI != redirectURI {
+		return Grant{}, ErrInvalidGrant
+	}
+	if grant.CodeChallenge != "" {
+		sum := sha256.Sum256([]byte(codeVerifier))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != grant.CodeChallenge {
+			return Grant{}, ErrInvalidGrant
+		}
+	}
+	return grant, nil
+}
+
+// IDToken signs the ID token of the grant.
+func (p *Provider) IDToken(grant Grant) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                   p.issuer,
+		"sub":                   grant.UserID,
+		"aud":                   grant.ClientID,
+		"iat":                   now.Unix(),
+		"exp":                   now.Add(idTokenTTL).Unix(),
+		"auth_time":             grant.AuthTime.Unix(),
+		"amr":                   []string{"otp"},
+		"phone_number":          grant.PhoneNumber,
+		"phone_number_verified": true,
+	}
+	if grant.Nonce != "" {
+		claims["nonce"] = grant.Nonce
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.keyID
+	return token.SignedString(p.key)
+}
+
+// AccessToken signs the access token of the grant and returns it with its expiry. Unlike the
+// service's own JWTs, it's issued for the client and only accepted by /userinfo, so a relying
+// party can't act as the user elsewhere in the API.
+func (p *Provider) AccessToken(grant Grant) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(accessTokenTTL)
+	claims := accessTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    p.issuer,
+			Subject:   grant.UserID,
+			Audience:  jwt.ClaimStrings{grant.ClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		ClientID:    grant.ClientID,
+		Scope:       scope,
+		PhoneNumber: grant.PhoneNumber,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.keyID
+	token.Header["typ"] = accessTokenType
+	signed, err := token.SignedString(p.key)
+	return signed, expiresAt, err
+}
+
+// ValidateToken implements middleware.TokenValidator for /userinfo: it accepts the access
+// tokens of AccessToken only, neither ID tokens nor the service's own JWTs.
+func (p *Provider) ValidateToken(ctx context.Context, token string) (*middleware.Claims, error) {
+	claims := &accessTokenClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if typ, _ := t.Header["typ"].(string); typ != accessTokenType {
+			return nil, fmt.Errorf("unexpected token type %q", typ)
+		}
+		return &p.key.PublicKey, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(p.issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.clients[claims.ClientID]; !ok || !slices.Contains(claims.Audience, claims.ClientID) {
+		return nil, errors.New("token not issued for a registered client")
+	}
+	if claims.Scope != scope {
+		return nil, fmt.Errorf("unexpected scope %q", claims.Scope)
+	}
+	return &middleware.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   claims.Subject,
+			IssuedAt:  claims.IssuedAt,
+			ExpiresAt: claims.ExpiresAt,
+		},
+		Phone: claims.PhoneNumber,
+	}, nil
+}
+
+// JWKS returns the JSON Web Key Set relying parties verify ID tokens with.
+func (p *Provider) JWKS() map[string]interface{} {
+	return map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": p.keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	}
+}
+
+// Discovery returns the OpenID Provider Metadata.
+func (p *Provider) Discovery() map[string]interface{} {
+	return map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"userinfo_endpoint":                     p.issuer + "/userinfo",
+		"jwks_uri":                              p.issuer + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "phone"},
+		"claims_supported":                      []string{"sub", "phone_number", "phone_number_verified"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	}
+}
@@ -0,0 +1,239 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer      = "https://auth.example.com"
+	testRedirectURI = "https://app.example.com/callback"
+	testVerifier    = "dBjftJeZ4CVP-mJ92K9qc7xOqcB6vIlIUvgDl4TydZQ"
+)
+
+var testKey = func() *rsa.PrivateKey {
+	key, err := LoadSigningKey("")
+	if err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+func newTestProvider() *Provider {
+	return NewProvider(testIssuer, map[string]Client{
+		"app":   {Secret: "app-secret", RedirectURIs: []string{testRedirectURI}},
+		"other": {Secret: "other-secret", RedirectURIs: []string{"https://other.example.com/callback"}},
+	}, testKey)
+}
+
+func testGrant() Grant {
+	sum := sha256.Sum256([]byte(testVerifier))
+	return Grant{
+		ClientID:      "app",
+		RedirectURI:   testRedirectURI,
+		Nonce:         "n-0S6_WzA2Mj",
+		CodeChallenge: base64.RawURLEncoding.EncodeToString(sum[:]),
+		UserID:        "6f1c2b3a-9d4e-4f5a-8b6c-7d8e9f0a1b2c",
+		PhoneNumber:   "+14155552671",
+		AuthTime:      time.Now(),
+	}
+}
+
+func TestCheckClient(t *testing.T) {
+	p := newTestProvider()
+	tests := []struct {
+		name        string
+		clientID    string
+		redirectURI string
+		wantErr     bool
+	}{
+		{"registered pair", "app", testRedirectURI, false},
+		{"redirect URI of another client", "other", testRedirectURI, true},
+		{"unknown client", "unknown", testRedirectURI, true},
+		{"unregistered redirect URI", "app", "https://evil.example.com/callback", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.CheckClient(tt.clientID, tt.redirectURI)
+			if gotErr := errors.Is(err, ErrUnknownClient); gotErr != tt.wantErr {
+				t.Errorf("CheckClient(%q, %q) = %v, want error %v", tt.clientID, tt.redirectURI, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRedeemCode(t *testing.T) {
+	tests := []struct {
+		name         string
+		clientID     string
+		redirectURI  string
+		codeVerifier string
+		expired      bool
+		wantErr      bool
+	}{
+		{name: "valid", clientID: "app", redirectURI: testRedirectURI, codeVerifier: testVerifier},
+		{name: "other client", clientID: "other", redirectURI: testRedirectURI, codeVerifier: testVerifier, wantErr: true},
+		{name: "other redirect URI", clientID: "app", redirectURI: "https://app.example.com/other", codeVerifier: testVerifier, wantErr: true},
+		{name: "wrong PKCE verifier", clientID: "app", redirectURI: testRedirectURI, codeVerifier: "wrong-verifier", wantErr: true},
+		{name: "missing PKCE verifier", clientID: "app", redirectURI: testRedirectURI, wantErr: true},
+		{name: "expired code", clientID: "app", redirectURI: testRedirectURI, codeVerifier: testVerifier, expired: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider()
+			code, err := p.IssueCode(testGrant())
+			if err != nil {
+				t.Fatalf("IssueCode: %v", err)
+			}
+			if tt.expired {
+				grant := p.codes[code]
+				grant.expiresAt = time.Now().Add(-time.Second)
+				p.codes[code] = grant
+			}
+
+			grant, err := p.RedeemCode(code, tt.clientID, tt.redirectURI, tt.codeVerifier)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidGrant) {
+					t.Fatalf("RedeemCode = %v, want ErrInvalidGrant", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RedeemCode: %v", err)
+			}
+			if grant.UserID != testGrant().UserID || grant.Nonce != testGrant().Nonce {
+				t.Errorf("RedeemCode returned grant %+v, want the issued one", grant)
+			}
+		})
+	}
+}
+
+func TestRedeemCodeOnce(t *testing.T) {
+	p := newTestProvider()
+	code, err := p.IssueCode(testGrant())
+	if err != nil {
+		t.Fatalf("IssueCode: %v", err)
+	}
+	if _, err := p.RedeemCode(code, "app", testRedirectURI, testVerifier); err != nil {
+		t.Fatalf("first RedeemCode: %v", err)
+	}
+	if _, err := p.RedeemCode(code, "app", testRedirectURI, testVerifier); !errors.Is(err, ErrInvalidGrant) {
+		t.Errorf("replayed RedeemCode = %v, want ErrInvalidGrant", err)
+	}
+}
+
+func TestRedeemCodeFailureUsesCode(t *testing.T) {
+	p := newTestProvider()
+	code, err := p.IssueCode(testGrant())
+	if err != nil {
+		t.Fatalf("IssueCode: %v", err)
+	}
+	// A wrong verifier mustn't leave the code for more guesses.
+	if _, err := p.RedeemCode(code, "app", testRedirectURI, "wrong-verifier"); !errors.Is(err, ErrInvalidGrant) {
+		t.Fatalf("RedeemCode with wrong verifier = %v, want ErrInvalidGrant", err)
+	}
+	if _, err := p.RedeemCode(code, "app", testRedirectURI, testVerifier); !errors.Is(err, ErrInvalidGrant) {
+		t.Errorf("RedeemCode after a failed attempt = %v, want ErrInvalidGrant", err)
+	}
+}
+
+// keyFromJWKS returns the key of the ID from the provider's JWKS, as a relying party would.
+func keyFromJWKS(t *testing.T, p *Provider, kid string) *rsa.PublicKey {
+	t.Helper()
+	for _, jwk := range p.JWKS()["keys"].([]map[string]string) {
+		if jwk["kid"] != kid {
+			continue
+		}
+		if jwk["kty"] != "RSA" || jwk["alg"] != "RS256" || jwk["use"] != "sig" {
+			t.Fatalf("unexpected JWK %v", jwk)
+		}
+		n, err1 := base64.RawURLEncoding.DecodeString(jwk["n"])
+		e, err2 := base64.RawURLEncoding.DecodeString(jwk["e"])
+		if err := errors.Join(err1, err2); err != nil {
+			t.Fatalf("invalid JWK %v: %v", jwk, err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	t.Fatalf("no JWK with kid %q", kid)
+	return nil
+}
+
+func TestIDTokenVerifiesAgainstJWKS(t *testing.T) {
+	p := newTestProvider()
+	grant := testGrant()
+	idToken, err := p.IDToken(grant)
+	if err != nil {
+		t.Fatalf("IDToken: %v", err)
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return keyFromJWKS(t, p, kid), nil
+	},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(testIssuer),
+		jwt.WithAudience("app"),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		t.Fatalf("ID token doesn't verify against the JWKS: %v", err)
+	}
+	if claims["sub"] != grant.UserID || claims["nonce"] != grant.Nonce || claims["phone_number"] != grant.PhoneNumber {
+		t.Errorf("ID token claims = %v, want those of the grant", claims)
+	}
+}
+
+func TestValidateToken(t *testing.T) {
+	p := newTestProvider()
+	grant := testGrant()
+
+	accessToken, _, err := p.AccessToken(grant)
+	if err != nil {
+		t.Fatalf("AccessToken: %v", err)
+	}
+	claims, err := p.ValidateToken(context.Background(), accessToken)
+	if err != nil {
+		t.Fatalf("ValidateToken(access token): %v", err)
+	}
+	if claims.Subject != grant.UserID || claims.Phone != grant.PhoneNumber || claims.IssuedAt == nil {
+		t.Errorf("ValidateToken claims = %+v, want those of the grant", claims)
+	}
+
+	idToken, err := p.IDToken(grant)
+	if err != nil {
+		t.Fatalf("IDToken: %v", err)
+	}
+	if _, err := p.ValidateToken(context.Background(), idToken); err == nil {
+		t.Error("ValidateToken accepted an ID token")
+	}
+
+	serviceToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": grant.UserID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("service-secret"))
+	if err != nil {
+		t.Fatalf("signing service token: %v", err)
+	}
+	if _, err := p.ValidateToken(context.Background(), serviceToken); err == nil {
+		t.Error("ValidateToken accepted a service JWT")
+	}
+
+	otherIssuer := NewProvider("https://other.example.com", p.clients, testKey)
+	foreign, _, err := otherIssuer.AccessToken(grant)
+	if err != nil {
+		t.Fatalf("AccessToken: %v", err)
+	}
+	if _, err := p.ValidateToken(context.Background(), foreign); err == nil {
+		t.Error("ValidateToken accepted an access token of another issuer")
+	}
+}
@@ -0,0 +1,82 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	// ErrQueueFull is returned by Dispatch when the workers are busy and the queue is full.
+	ErrQueueFull = errors.New("OTP dispatch queue is full")
+	// ErrDispatcherClosed is returned by Dispatch once the dispatcher is closed.
+	ErrDispatcherClosed = errors.New("OTP dispatcher is closed")
+)
+
+// Dispatcher runs OTP sends on a pool of workers, so requests don't wait for slow providers.
+type Dispatcher struct {
+	queue chan func()
+
+	mu      sync.RWMutex // guards closed and sending on queue
+	closed  bool
+	workers sync.WaitGroup
+}
+
+// NewDispatcher creates a Dispatcher and starts its workers. Up to queueSize sends wait for a
+// free worker.
+func NewDispatcher(workers, queueSize int) *Dispatcher {
+	d := &Dispatcher{queue: make(chan func(), queueSize)}
+	d.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go d.worker()
+	}
+	return d
+}
+
+// Dispatch queues the send. It never blocks; it returns ErrQueueFull when the send can't be
+// queued and ErrDispatcherClosed once the dispatcher is closed.
+func (d *Dispatcher) Dispatch(send func()) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return ErrDispatcherClosed
+	}
+	select {
+	case d.queue <- send:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting sends and waits for the queued ones until ctx is done.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("OTP sends still pending: %w", ctx.Err())
+	}
+}
+
+func (d *Dispatcher) worker() {
+	defer d.workers.Done()
+	for send := range d.queue {
+		send()
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"fmt"
 	"io"
+	"log/slog"
 )
 
 // OTPGenerator defines the interface for generating OTPs.
@@ -25,7 +26,7 @@ func (g *SimpleOTPGenerator) GenerateOTP() string {
 		// In a real application, you'd log this and potentially handle it
 		// For simplicity, falling back to a non-crypto random or panicking is an option here
 		// but for production, this should be robust.
-		fmt.Println("WARNING: Failed to read from crypto/rand, using fallback for OTP. Error:", err)
+		slog.Warn("Failed to read from crypto/rand, using fallback for OTP", "error", err)
 		return "000000" // Fallback to a fixed OTP for demonstration, NOT for production
 	}
 	return fmt.Sprintf("%06d", (int(b[0])<<16|int(b[1])<<8|int(b[2]))%1000000)
@@ -0,0 +1,122 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+)
+
+// ErrInvalidNumber is returned by senders whose provider rejects the phone number, e.g. as
+// unallocated or not a mobile number. Sending again won't help.
+var ErrInvalidNumber = errors.New("phone number rejected by the provider")
+
+// ProviderError is a failed request to a provider, with the HTTP status it answered.
+type ProviderError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("provider responded with status %d: %v", e.StatusCode, e.Err)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether the provider may accept the request later: on 5xx and 429.
+func (e *ProviderError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == 429
+}
+
+// Retryable reports whether a failed send may succeed when repeated: on timeouts and on
+// provider errors that are temporary. Anything else, including unknown errors, isn't
+// retried, since the message might have gone out already.
+func Retryable(err error) bool {
+	if errors.Is(err, ErrInvalidNumber) || errors.Is(err, ErrNoRecipient) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var temporary interface{ Temporary() bool }
+	return errors.As(err, &temporary) && temporary.Temporary()
+}
+
+// RetryPolicy is how often and how far apart failed sends are repeated. The delay before
+// attempt n+1 is random between 0 and BaseDelay*2^(n-1), capped at MaxDelay.
+type RetryPolicy struct {
+	MaxAttempts int // including the first; 1 or less doesn't retry
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// RetryingSender repeats the sends of a Sender that fail with a Retryable error, backing off
+// exponentially with jitter, until the policy's attempts are used up or ctx is done.
+type RetryingSender struct {
+	sender Sender
+	policy RetryPolicy
+}
+
+func NewRetryingSender(sender Sender, policy RetryPolicy) *RetryingSender {
+	return &RetryingSender{sender: sender, policy: policy}
+}
+
+func (s *RetryingSender) Send(ctx context.Context, otp model.OTP) error {
+	for attempt := 1; ; attempt++ {
+		err := s.sender.Send(ctx, otp)
+		if err == nil || attempt >= s.policy.MaxAttempts || !Retryable(err) {
+			return err
+		}
+		delay := s.backoff(attempt)
+		logging.FromContext(ctx).Warn("OTP send failed, retrying",
+			"phone_number", otp.PhoneNumber, "attempt", attempt, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// backoff returns the jittered delay after the given attempt failed.
+func (s *RetryingSender) backoff(attempt int) time.Duration {
+	limit := s.policy.MaxDelay
+	if shift := attempt - 1; shift < 32 {
+		if d := s.policy.BaseDelay << shift; d > 0 && (limit <= 0 || d < limit) {
+			limit = d
+		}
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return rand.N(limit + 1)
+}
+
+// CheckHealth checks the wrapped sender, if it can be checked.
+func (s *RetryingSender) CheckHealth(ctx context.Context) error {
+	if checker, ok := s.sender.(HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
+	return nil
+}
+
+// AwaitDelivery waits for the wrapped sender to confirm the delivery, if it can.
+func (s *RetryingSender) AwaitDelivery(ctx context.Context, otp model.OTP) error {
+	return AwaitDelivery(ctx, s.sender, otp)
+}
+
+// Channel names the channel of the wrapped sender.
+func (s *RetryingSender) Channel() string {
+	return Channel(s.sender)
+}
@@ -0,0 +1,112 @@
+package otp
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Scheduler runs jobs at a later time, one after the other on a single goroutine, e.g. the
+// checks whether an OTP has to be sent again. Jobs must not block for long.
+type Scheduler struct {
+	mu   sync.Mutex
+	jobs jobHeap
+	wake chan struct{}
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewScheduler creates a Scheduler and starts its goroutine.
+func NewScheduler() *Scheduler {
+	s := &Scheduler{
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Schedule runs job at the given time, or right away if it has passed.
+func (s *Scheduler) Schedule(at time.Time, job func()) {
+	s.mu.Lock()
+	heap.Push(&s.jobs, scheduledJob{at: at, run: job})
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop ends the scheduler; the jobs that aren't due yet are dropped.
+func (s *Scheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+	})
+}
+
+func (s *Scheduler) run() {
+	defer close(s.done)
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		next, ok := s.runDue()
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if ok {
+			timer.Reset(time.Until(next))
+		}
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// runDue runs the jobs that are due and returns when the next one is, if any.
+func (s *Scheduler) runDue() (time.Time, bool) {
+	for {
+		s.mu.Lock()
+		if len(s.jobs) == 0 {
+			s.mu.Unlock()
+			return time.Time{}, false
+		}
+		next := s.jobs[0]
+		if next.at.After(time.Now()) {
+			s.mu.Unlock()
+			return next.at, true
+		}
+		heap.Pop(&s.jobs)
+		s.mu.Unlock()
+		next.run()
+	}
+}
+
+type scheduledJob struct {
+	at  time.Time
+	run func()
+}
+
+// jobHeap orders the jobs by when they're due, earliest first.
+type jobHeap []scheduledJob
+
+func (h jobHeap) Len() int           { return len(h) }
+func (h jobHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h jobHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *jobHeap) Push(x any)        { *h = append(*h, x.(scheduledJob)) }
+func (h *jobHeap) Pop() any {
+	old := *h
+	job := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return job
+}
@@ -0,0 +1,148 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+)
+
+// Sender delivers a freshly generated OTP to its phone number.
+type Sender interface {
+	Send(ctx context.Context, otp model.OTP) error
+}
+
+// The channels clients can request OTPs through. ChannelSMS is the default, and the channel of
+// senders that don't name theirs.
+const (
+	ChannelSMS      = "sms"
+	ChannelWhatsApp = "whatsapp"
+	ChannelEmail    = "email"
+	ChannelVoice    = "voice"
+)
+
+// ErrNoRecipient is returned by senders that can't reach the phone number on their channel,
+// e.g. email senders when the number's user has no verified email address.
+var ErrNoRecipient = errors.New("phone number can't be reached on this channel")
+
+// ChannelNamer is implemented by senders that can name their delivery channel (e.g. "sms" or
+// "voice"). The channel is stored with the OTP and keys the funnel metrics.
+type ChannelNamer interface {
+	Channel() string
+}
+
+// Channel returns the delivery channel of sender, ChannelSMS unless it names another.
+func Channel(sender Sender) string {
+	if namer, ok := sender.(ChannelNamer); ok {
+		return namer.Channel()
+	}
+	return ChannelSMS
+}
+
+// Branding is how the OTPs requested by an integrator present themselves, see model.OTP.
+type Branding struct {
+	SenderID      string
+	MessagePrefix string
+}
+
+// Text returns the message text of the OTP, with its prefix, for senders delivering text.
+func Text(otp model.OTP) string {
+	text := fmt.Sprintf("Your verification code is %s", otp.OTPCode)
+	if otp.MessagePrefix != "" {
+		text = otp.MessagePrefix + " " + text
+	}
+	return text
+}
+
+// HealthChecker is implemented by senders that can verify their provider is configured
+// and reachable. The readiness probe calls it; senders without it are assumed ready.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// ReloadableSender forwards to a Sender that can be replaced at runtime, so the SMS provider
+// can be switched by reloading the configuration.
+type ReloadableSender struct {
+	mu     sync.RWMutex
+	sender Sender
+}
+
+func NewReloadableSender(sender Sender) *ReloadableSender {
+	return &ReloadableSender{sender: sender}
+}
+
+func (s *ReloadableSender) Send(ctx context.Context, otp model.OTP) error {
+	return s.current().Send(ctx, otp)
+}
+
+// CheckHealth checks the current sender, if it can be checked.
+func (s *ReloadableSender) CheckHealth(ctx context.Context) error {
+	if checker, ok := s.current().(HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
+	return nil
+}
+
+// AwaitDelivery waits for the current sender to confirm the delivery, if it can.
+func (s *ReloadableSender) AwaitDelivery(ctx context.Context, otp model.OTP) error {
+	return AwaitDelivery(ctx, s.current(), otp)
+}
+
+// Channel names the channel of the current sender.
+func (s *ReloadableSender) Channel() string {
+	return Channel(s.current())
+}
+
+// Swap makes sender deliver all further OTPs.
+func (s *ReloadableSender) Swap(sender Sender) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sender = sender
+}
+
+func (s *ReloadableSender) current() Sender {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sender
+}
+
+// ConsoleSender "delivers" OTPs by logging them, for local development and demos.
+// Unless echo is set, the code itself is left out of the log.
+type ConsoleSender struct {
+	echo    bool
+	channel string
+}
+
+func NewConsoleSender(echo bool) *ConsoleSender {
+	return &ConsoleSender{echo: echo, channel: ChannelSMS}
+}
+
+// NewConsoleChannelSender creates a ConsoleSender standing in for the provider of another
+// channel, e.g. ChannelWhatsApp; the channel is named in the log.
+func NewConsoleChannelSender(channel string, echo bool) *ConsoleSender {
+	return &ConsoleSender{echo: echo, channel: channel}
+}
+
+// Channel is "console", which keeps demo traffic apart from real SMS in the funnel metrics.
+func (s *ConsoleSender) Channel() string {
+	return "console"
+}
+
+func (s *ConsoleSender) Send(ctx context.Context, otp model.OTP) error {
+	code, text := "[not echoed]", "[not echoed]"
+	if s.echo {
+		code, text = otp.OTPCode, Text(otp)
+	}
+	logging.FromContext(ctx).Info("OTP issued (console delivery)",
+		"phone_number", otp.PhoneNumber, "channel", s.channel, "otp", code, "expires_at", otp.ExpiresAt,
+		"sender_id", otp.SenderID, "text", text)
+	return nil
+}
+
+// AwaitDelivery returns right away: the log line is the delivery.
+func (s *ConsoleSender) AwaitDelivery(ctx context.Context, otp model.OTP) error {
+	return nil
+}
@@ -0,0 +1,172 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// Delivery statuses of an OTP, in the order they are reached. Delivered and failed are final.
+const (
+	StatusQueued    = "queued"
+	StatusSent      = "sent"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// ErrUnconfirmed is returned by AwaitDelivery for senders that can't tell whether an OTP
+// reached the phone.
+var ErrUnconfirmed = errors.New("delivery is not confirmed by the sender")
+
+// DeliveryConfirmer is implemented by senders that learn when an OTP reached the phone, e.g.
+// from the delivery receipts of their provider.
+type DeliveryConfirmer interface {
+	// AwaitDelivery blocks until the OTP, which Send accepted, was delivered, or returns an
+	// error once it is known to have failed or ctx is done.
+	AwaitDelivery(ctx context.Context, otp model.OTP) error
+}
+
+// AwaitDelivery waits for the delivery of an OTP sent through sender, or returns ErrUnconfirmed
+// if the sender can't confirm it.
+func AwaitDelivery(ctx context.Context, sender Sender, otp model.OTP) error {
+	if confirmer, ok := sender.(DeliveryConfirmer); ok {
+		return confirmer.AwaitDelivery(ctx, otp)
+	}
+	return ErrUnconfirmed
+}
+
+// DeliveryStatus is one step of the delivery of an OTP.
+type DeliveryStatus struct {
+	Status  string    `json:"status"`
+	Channel string    `json:"channel"`
+	At      time.Time `json:"at"`
+}
+
+// StatusTracker keeps the delivery statuses of the OTPs until they expire and streams them to
+// subscribers, so clients can follow a delivery instead of polling. It lives in memory: clients
+// must be routed to the replica that sent their OTP.
+type StatusTracker struct {
+	mu         sync.Mutex
+	deliveries map[uuid.UUID]*delivery
+	closed     bool
+}
+
+type delivery struct {
+	channel     string
+	expiresAt   time.Time
+	history     []DeliveryStatus
+	finished    bool
+	subscribers map[chan DeliveryStatus]struct{}
+}
+
+func NewStatusTracker() *StatusTracker {
+	return &StatusTracker{deliveries: make(map[uuid.UUID]*delivery)}
+}
+
+// Track starts following the delivery with the given ID, as queued. It is forgotten once
+// expiresAt has passed.
+func (t *StatusTracker) Track(id uuid.UUID, channel string, expiresAt time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Sweeping here keeps the map bounded by the OTPs sent within one TTL.
+	now := time.Now()
+	for otherID, d := range t.deliveries {
+		if now.After(d.expiresAt) {
+			d.finish()
+			delete(t.deliveries, otherID)
+		}
+	}
+
+	d := &delivery{channel: channel, expiresAt: expiresAt, subscribers: make(map[chan DeliveryStatus]struct{})}
+	d.add(DeliveryStatus{Status: StatusQueued, Channel: channel, At: now.UTC()})
+	t.deliveries[id] = d
+}
+
+// Update records the next status of the delivery. Delivered and failed end its streams.
+func (t *StatusTracker) Update(id uuid.UUID, status string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d, ok := t.deliveries[id]
+	if !ok || d.finished {
+		return
+	}
+	d.add(DeliveryStatus{Status: status, Channel: d.channel, At: time.Now().UTC()})
+	if status == StatusDelivered || status == StatusFailed {
+		d.finish()
+	}
+}
+
+// Finish ends the streams of the delivery when no further status will come, e.g. because the
+// sender doesn't confirm deliveries.
+func (t *StatusTracker) Finish(id uuid.UUID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if d, ok := t.deliveries[id]; ok {
+		d.finish()
+	}
+}
+
+// Subscribe returns the statuses the delivery went through so far and a channel receiving
+// the later ones, which is closed after the last. ok is false for unknown or expired
+// deliveries. cancel must be called once the caller stops reading.
+func (t *StatusTracker) Subscribe(id uuid.UUID) (history []DeliveryStatus, updates <-chan DeliveryStatus, cancel func(), ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d, found := t.deliveries[id]
+	if !found || time.Now().After(d.expiresAt) {
+		return nil, nil, nil, false
+	}
+	history = append([]DeliveryStatus(nil), d.history...)
+
+	// Buffered for every status that can still follow, so updating never blocks.
+	ch := make(chan DeliveryStatus, 3)
+	if d.finished || t.closed {
+		close(ch)
+		return history, ch, func() {}, true
+	}
+	d.subscribers[ch] = struct{}{}
+	cancel = func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if _, subscribed := d.subscribers[ch]; subscribed {
+			delete(d.subscribers, ch)
+			close(ch)
+		}
+	}
+	return history, ch, cancel, true
+}
+
+// Close ends all open streams, so the requests serving them can finish on shutdown.
+func (t *StatusTracker) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+	for _, d := range t.deliveries {
+		d.finish()
+	}
+}
+
+func (d *delivery) add(status DeliveryStatus) {
+	d.history = append(d.history, status)
+	for ch := range d.subscribers {
+		ch <- status
+	}
+}
+
+func (d *delivery) finish() {
+	d.finished = true
+	for ch := range d.subscribers {
+		close(ch)
+		delete(d.subscribers, ch)
+	}
+}
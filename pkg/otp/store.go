@@ -1,12 +1,16 @@
 package otp
 
-import "github.com/ebipenman/go-otp-auth-service/internal/model"
+import (
+	"context"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+)
 
 // Repository defines the interface for OTP data operations.
 type Repository interface {
-	StoreOTP(otp model.OTP) error
-	GetOTP(phoneNumber string) (model.OTP, error)
-	DeleteOTP(phoneNumber string) error
+	StoreOTP(ctx context.Context, otp model.OTP) error
+	GetOTP(ctx context.Context, phoneNumber string) (model.OTP, error)
+	DeleteOTP(ctx context.Context, phoneNumber string) error
 }
 
 type otpRepository struct {
@@ -17,22 +21,22 @@ func NewRepository(store OTPStore) Repository {
 	return &otpRepository{store: store}
 }
 
-func (r *otpRepository) StoreOTP(otp model.OTP) error {
-	return r.store.StoreOTP(otp)
+func (r *otpRepository) StoreOTP(ctx context.Context, otp model.OTP) error {
+	return r.store.StoreOTP(ctx, otp)
 }
 
-func (r *otpRepository) GetOTP(phoneNumber string) (model.OTP, error) {
-	return r.store.GetOTP(phoneNumber)
+func (r *otpRepository) GetOTP(ctx context.Context, phoneNumber string) (model.OTP, error) {
+	return r.store.GetOTP(ctx, phoneNumber)
 }
 
-func (r *otpRepository) DeleteOTP(phoneNumber string) error {
-	return r.store.DeleteOTP(phoneNumber)
+func (r *otpRepository) DeleteOTP(ctx context.Context, phoneNumber string) error {
+	return r.store.DeleteOTP(ctx, phoneNumber)
 }
 
 // OTPStore is the interface that the database implementation must satisfy.
 // It's defined here for the service layer to depend on an interface from its own package.
 type OTPStore interface {
-	StoreOTP(otp model.OTP) error
-	GetOTP(phoneNumber string) (model.OTP, error)
-	DeleteOTP(phoneNumber string) error
+	StoreOTP(ctx context.Context, otp model.OTP) error
+	GetOTP(ctx context.Context, phoneNumber string) (model.OTP, error)
+	DeleteOTP(ctx context.Context, phoneNumber string) error
 }
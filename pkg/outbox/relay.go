@@ -0,0 +1,91 @@
+// Package outbox relays the events that the store wrote to its outbox, in the same transaction
+// as the state change they describe, to the event publishers. An event is thus published if and
+// only if its change was committed, even when the process crashes in between.
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+)
+
+// batchSize is how many events are relayed per transaction.
+const batchSize = 100
+
+// Store is the interface that the database implementation must satisfy.
+type Store interface {
+	// RelayEvents passes up to limit of the oldest stored events to publish, in order, and
+	// removes them. It returns how many it removed.
+	RelayEvents(ctx context.Context, limit int, publish func(model.Event)) (int, error)
+}
+
+// EventPublisher receives the relayed events. It must not block.
+type EventPublisher interface {
+	Publish(event model.Event)
+}
+
+// Relay moves the stored events to the publisher every interval until Stop is called.
+type Relay struct {
+	store  Store
+	events EventPublisher
+	logger *slog.Logger
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewRelay creates a Relay and starts polling the store.
+func NewRelay(store Store, events EventPublisher, interval time.Duration, logger *slog.Logger) *Relay {
+	r := &Relay{
+		store:  store,
+		events: events,
+		logger: logger.With("component", "outbox"),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.relay()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// Stop ends the polling and relays the events stored until now, so they reach the publisher
+// before it is closed.
+func (r *Relay) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+		<-r.done
+		r.relay()
+	})
+}
+
+// relay drains the outbox batch by batch. Failures are retried on the next tick.
+func (r *Relay) relay() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		n, err := r.store.RelayEvents(ctx, batchSize, r.events.Publish)
+		cancel()
+		if err != nil {
+			r.logger.Error("Failed to relay outbox events", "error", err)
+			return
+		}
+		if n < batchSize {
+			return
+		}
+	}
+}
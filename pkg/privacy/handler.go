@@ -0,0 +1,57 @@
+package privacy
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type Handler struct {
+	privacyService Service
+}
+
+func NewHandler(privacyService Service) *Handler {
+	return &Handler{privacyService: privacyService}
+}
+
+var _ = openapi.Describe((*Handler).AnonymizeUser, openapi.Operation{
+	Summary: "Anonymize a user",
+	Description: "Irreversibly scrubs the personal data of a user, e.g. on a right to be forgotten request: the " +
+		"phone number, name and email, the IPs and user agents of their devices and logins, their linked " +
+		"social accounts, data exports, pending OTPs and the phone number in undelivered webhook events. " +
+		"Deleted users can be anonymized, too. The user ID is kept, so logins and devices still count in " +
+		"analytics. The user is deleted, their tokens revoked and a user.anonymized event is emitted. " +
+		"Repeating the request is harmless, e.g. after a failure.",
+	Tags:     []string{"Admin"},
+	Security: []string{openapi.APIKeyAuth},
+	Params: []openapi.Param{
+		{Name: "id", In: openapi.InPath, Description: "User ID"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusNoContent:           {Description: "User anonymized"},
+		http.StatusBadRequest:          {Description: "Invalid user ID"},
+		http.StatusUnauthorized:        {Description: "API key required"},
+		http.StatusNotFound:            {Description: "User not found"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) AnonymizeUser(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.Abort(c, apperr.ErrInvalidUserID)
+		return
+	}
+
+	if err := h.privacyService.AnonymizeUser(c.Request.Context(), id); err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to anonymize user %s: %w", id, err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,90 @@
+package privacy
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// purgeBatchSize is how many deleted users are listed at a time.
+const purgeBatchSize = 100
+
+// Purger anonymizes the users deleted longer than the grace period ago, who can't be restored
+// anymore, until Stop is called.
+type Purger struct {
+	repo    Repository
+	service Service
+	grace   time.Duration
+	logger  *slog.Logger
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewPurger creates a Purger and starts purging right away, then every interval.
+func NewPurger(repo Repository, service Service, grace, interval time.Duration, logger *slog.Logger) *Purger {
+	p := &Purger{
+		repo:    repo,
+		service: service,
+		grace:   grace,
+		logger:  logger.With("component", "purger"),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(p.done)
+		p.purge()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.purge()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// Stop ends the purging, waiting for a running purge to finish.
+func (p *Purger) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+		<-p.done
+	})
+}
+
+// purge anonymizes the expired users batch by batch. Failures are retried on the next tick.
+func (p *Purger) purge() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		users, err := p.repo.ListDeletedUsers(ctx, time.Now().Add(-p.grace), purgeBatchSize)
+		if err != nil {
+			cancel()
+			p.logger.Error("Failed to list deleted users", "error", err)
+			return
+		}
+		for _, u := range users {
+			if err := p.service.AnonymizeUser(ctx, u.ID); err != nil {
+				cancel()
+				p.logger.Error("Failed to purge deleted user", "user_id", u.ID, "error", err)
+				return
+			}
+			select {
+			case <-p.stop:
+				cancel()
+				return
+			default:
+			}
+		}
+		cancel()
+		if len(users) < purgeBatchSize {
+			return
+		}
+	}
+}
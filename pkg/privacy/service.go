@@ -0,0 +1,103 @@
+// Package privacy erases users on request, as the GDPR's right to be forgotten demands.
+// Anonymized users keep their ID, so logins and devices still add up in analytics, but
+// nothing left identifies the person behind them.
+package privacy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/tracing"
+	"github.com/ebipenman/go-otp-auth-service/pkg/auth"
+
+	"github.com/google/uuid"
+)
+
+// Service defines the business logic for anonymizing users.
+type Service interface {
+	// AnonymizeUser irreversibly scrubs the personal data of the user, deleted or not: the phone
+	// numbers, name and email, the IPs and user agents of their devices and logins, their linked
+	// social accounts, data exports and pending OTPs, and the phone number in webhook events not
+	// delivered yet. The user is deleted and their tokens revoked. Anonymizing a user again is
+	// harmless, so a failed run can be repeated. It returns ErrUserNotFound for unknown users.
+	AnonymizeUser(ctx context.Context, id uuid.UUID) error
+}
+
+// EventRedactor removes a phone number from the events waiting for delivery.
+type EventRedactor interface {
+	Forget(phoneNumber string)
+}
+
+type privacyService struct {
+	repo     Repository
+	tokens   auth.TokenRevoker
+	events   auth.EventPublisher
+	redactor EventRedactor
+}
+
+func NewService(repo Repository, tokens auth.TokenRevoker, events auth.EventPublisher, redactor EventRedactor) Service {
+	return &privacyService{repo: repo, tokens: tokens, events: events, redactor: redactor}
+}
+
+func (s *privacyService) AnonymizeUser(ctx context.Context, id uuid.UUID) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "privacy.AnonymizeUser")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	logger := logging.FromContext(ctx)
+
+	// The user goes last: until their phone number is gone, a failed run can be repeated.
+	if err := s.repo.AnonymizeDevices(ctx, id); err != nil {
+		return fmt.Errorf("failed to anonymize devices: %w", err)
+	}
+	if err := s.repo.AnonymizeLogins(ctx, id); err != nil {
+		return fmt.Errorf("failed to anonymize logins: %w", err)
+	}
+	if err := s.repo.DeleteIdentities(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete identities: %w", err)
+	}
+	if err := s.repo.DeleteExports(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete exports: %w", err)
+	}
+	phoneNumbers, err := s.repo.DeletePhoneNumbers(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete phone numbers: %w", err)
+	}
+	u, err := s.repo.AnonymizeUser(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to anonymize user: %w", err)
+	}
+
+	// Users anonymized before have no phone number left.
+	if u.PhoneNumber != "" {
+		phoneNumbers = append(phoneNumbers, u.PhoneNumber)
+	}
+	for _, phoneNumber := range phoneNumbers {
+		s.redactor.Forget(phoneNumber)
+		// OTPs expire within minutes anyway, so this doesn't fail the anonymization.
+		if err := s.repo.DeleteOTP(ctx, phoneNumber); err != nil {
+			logger.Error("Failed to purge OTPs of anonymized user", "user_id", id, "error", err)
+		}
+		if err := s.repo.DeleteDeadLetters(ctx, phoneNumber); err != nil {
+			logger.Error("Failed to purge dead letters of anonymized user", "user_id", id, "error", err)
+		}
+	}
+	if err := s.tokens.RevokeTokens(ctx, id, time.Now()); err != nil {
+		logger.Error("Failed to revoke tokens of anonymized user", "user_id", id, "error", err)
+	}
+
+	logger.Info("Anonymized user", "user_id", id)
+	// Receivers that stored earlier events are expected to scrub the user from them, too.
+	s.events.Publish(model.NewEvent(model.EventUserAnonymized, map[string]interface{}{
+		"user_id": id,
+	}))
+	return nil
+}
@@ -0,0 +1,120 @@
+package privacy
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/database"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/pkg/deadletter"
+	"github.com/ebipenman/go-otp-auth-service/pkg/device"
+	"github.com/ebipenman/go-otp-auth-service/pkg/export"
+	"github.com/ebipenman/go-otp-auth-service/pkg/loginhistory"
+	"github.com/ebipenman/go-otp-auth-service/pkg/otp"
+	"github.com/ebipenman/go-otp-auth-service/pkg/social"
+	"github.com/ebipenman/go-otp-auth-service/pkg/user"
+
+	"github.com/google/uuid"
+)
+
+var ErrUserNotFound = apperr.ErrUserNotFound
+
+// Repository defines the data operations that scrub a user's personal data.
+type Repository interface {
+	AnonymizeUser(ctx context.Context, id uuid.UUID) (model.User, error)
+	ListDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) ([]model.User, error)
+	AnonymizeDevices(ctx context.Context, userID uuid.UUID) error
+	AnonymizeLogins(ctx context.Context, userID uuid.UUID) error
+	DeleteIdentities(ctx context.Context, userID uuid.UUID) error
+	DeleteExports(ctx context.Context, userID uuid.UUID) error
+	DeleteOTP(ctx context.Context, phoneNumber string) error
+	DeleteDeadLetters(ctx context.Context, phoneNumber string) error
+	DeletePhoneNumbers(ctx context.Context, userID uuid.UUID) ([]string, error)
+}
+
+type privacyRepository struct {
+	userRepo   user.Repository
+	deviceRepo device.Repository
+	loginRepo  loginhistory.Repository
+	identities social.IdentityStore
+	exports    export.ExportStore
+	otpRepo    otp.Repository
+	letters    deadletter.Repository
+}
+
+func NewRepository(userRepo user.Repository, deviceRepo device.Repository, loginRepo loginhistory.Repository, identities social.IdentityStore, exports export.ExportStore, otpRepo otp.Repository, letters deadletter.Repository) Repository {
+	return &privacyRepository{
+		userRepo:   userRepo,
+		deviceRepo: deviceRepo,
+		loginRepo:  loginRepo,
+		identities: identities,
+		exports:    exports,
+		otpRepo:    otpRepo,
+		letters:    letters,
+	}
+}
+
+func (r *privacyRepository) AnonymizeUser(ctx context.Context, id uuid.UUID) (model.User, error) {
+	u, err := r.userRepo.AnonymizeUser(ctx, id)
+	if errors.Is(err, database.ErrNotFound) {
+		return model.User{}, ErrUserNotFound
+	}
+	return u, err
+}
+
+func (r *privacyRepository) ListDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) ([]model.User, error) {
+	return r.userRepo.ListDeletedUsers(ctx, deletedBefore, limit)
+}
+
+func (r *privacyRepository) AnonymizeDevices(ctx context.Context, userID uuid.UUID) error {
+	return r.deviceRepo.AnonymizeDevices(ctx, userID)
+}
+
+func (r *privacyRepository) AnonymizeLogins(ctx context.Context, userID uuid.UUID) error {
+	return r.loginRepo.AnonymizeLogins(ctx, userID)
+}
+
+// DeletePhoneNumbers detaches the user's secondary phone numbers and returns them.
+func (r *privacyRepository) DeletePhoneNumbers(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	phones, err := r.userRepo.ListPhoneNumbers(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	numbers := make([]string, 0, len(phones))
+	for _, phone := range phones {
+		if err := r.userRepo.DeletePhoneNumber(ctx, userID, phone.ID); err != nil && !errors.Is(err, database.ErrNotFound) {
+			return nil, err
+		}
+		numbers = append(numbers, phone.PhoneNumber)
+	}
+	return numbers, nil
+}
+
+// DeleteIdentities unlinks the user's social login accounts, whose subjects and email
+// addresses identify the user.
+func (r *privacyRepository) DeleteIdentities(ctx context.Context, userID uuid.UUID) error {
+	identities, err := r.identities.ListIdentities(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, identity := range identities {
+		if err := r.identities.DeleteIdentity(ctx, identity.Provider, identity.Subject); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *privacyRepository) DeleteExports(ctx context.Context, userID uuid.UUID) error {
+	return r.exports.DeleteExports(ctx, userID)
+}
+
+func (r *privacyRepository) DeleteOTP(ctx context.Context, phoneNumber string) error {
+	return r.otpRepo.DeleteOTP(ctx, phoneNumber)
+}
+
+func (r *privacyRepository) DeleteDeadLetters(ctx context.Context, phoneNumber string) error {
+	return r.letters.DeleteDeadLetters(ctx, phoneNumber)
+}
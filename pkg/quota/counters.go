@@ -0,0 +1,127 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// InMemoryCounters implements Counters for a single instance. The spend starts over when the
+// process restarts.
+type InMemoryCounters struct {
+	mu       sync.Mutex
+	counters map[string]inMemoryCounter
+}
+
+type inMemoryCounter struct {
+	value     int64
+	expiresAt time.Time
+}
+
+func NewInMemoryCounters() *InMemoryCounters {
+	return &InMemoryCounters{counters: make(map[string]inMemoryCounter)}
+}
+
+func (m *InMemoryCounters) Add(ctx context.Context, counters []Counter, amount int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Counters change at most once per send, so pruning the expired ones here is cheap enough.
+	now := time.Now()
+	for key, c := range m.counters {
+		if now.After(c.expiresAt) {
+			delete(m.counters, key)
+		}
+	}
+	if amount > 0 {
+		for _, c := range counters {
+			if c.Limit > 0 && m.counters[c.Key].value+amount > c.Limit {
+				return false, nil
+			}
+		}
+	}
+	for _, c := range counters {
+		m.counters[c.Key] = inMemoryCounter{value: m.counters[c.Key].value + amount, expiresAt: c.ExpiresAt}
+	}
+	return true, nil
+}
+
+func (m *InMemoryCounters) Get(ctx context.Context, keys []string) ([]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	values := make([]int64, len(keys))
+	for i, key := range keys {
+		values[i] = m.counters[key].value
+	}
+	return values, nil
+}
+
+// RedisCounters implements Counters on top of Redis, so all replicas share the budgets.
+type RedisCounters struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisCounters creates counters stored under prefix.
+func NewRedisCounters(client *redis.Client, prefix string) *RedisCounters {
+	return &RedisCounters{client: client, prefix: prefix}
+}
+
+// addScript checks every limit before adding to any counter, so a send is booked everywhere or
+// nowhere. KEYS are the counters; ARGV the amount, then a limit and an expiry (Unix ms) per key.
+var addScript = redis.NewScript(`
+local amount = tonumber(ARGV[1])
+if amount > 0 then
+	for i, key in ipairs(KEYS) do
+		local limit = tonumber(ARGV[2 * i])
+		if limit > 0 and tonumber(redis.call('GET', key) or '0') + amount > limit then
+			return 0
+		end
+	end
+end
+for i, key in ipairs(KEYS) do
+	redis.call('INCRBY', key, amount)
+	redis.call('PEXPIREAT', key, ARGV[2 * i + 1])
+end
+return 1
+`)
+
+func (r *RedisCounters) Add(ctx context.Context, counters []Counter, amount int64) (bool, error) {
+	keys := make([]string, len(counters))
+	args := []interface{}{amount}
+	for i, c := range counters {
+		keys[i] = r.prefix + c.Key
+		args = append(args, c.Limit, c.ExpiresAt.UnixMilli())
+	}
+	added, err := addScript.Run(ctx, r.client, keys, args...).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to add to quota counters: %w", err)
+	}
+	return added == 1, nil
+}
+
+func (r *RedisCounters) Get(ctx context.Context, keys []string) ([]int64, error) {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = r.prefix + key
+	}
+	results, err := r.client.MGet(ctx, prefixed...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quota counters: %w", err)
+	}
+	values := make([]int64, len(keys))
+	for i, result := range results {
+		s, ok := result.(string)
+		if !ok {
+			continue
+		}
+		if values[i], err = strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid quota counter %q: %w", s, err)
+		}
+	}
+	return values, nil
+}
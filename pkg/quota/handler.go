@@ -0,0 +1,47 @@
+package quota
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	keeper *Keeper
+}
+
+func NewHandler(keeper *Keeper) *Handler {
+	return &Handler{keeper: keeper}
+}
+
+var _ = openapi.Describe((*Handler).GetUsage, openapi.Operation{
+	Summary: "Get the OTP spend",
+	Description: "Reports the cost of the OTPs sent today and this month (UTC) against the budgets of OTP_QUOTA_DAILY and " +
+		"OTP_QUOTA_MONTHLY, split by channel. With tenant, the spend of that tenant against OTP_TENANT_QUOTA_DAILY " +
+		"and OTP_TENANT_QUOTA_MONTHLY instead: \"client:<id>\" for signed requests, \"api-key:<id>\" for API keys. " +
+		"A limit of 0 means unlimited. Without Redis, the spend starts over when the service restarts.",
+	Tags:     []string{"Admin"},
+	Security: []string{openapi.APIKeyAuth},
+	Params: []openapi.Param{
+		{Name: "tenant", In: openapi.InQuery, Description: "Tenant, e.g. client:billing"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Type: Usage{}},
+		http.StatusUnauthorized:        {Description: "API key required"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) GetUsage(c *gin.Context) {
+	tenant := c.Query("tenant")
+	usage, err := h.keeper.Usage(c.Request.Context(), tenant)
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to get OTP spend of tenant %q: %w", tenant, err))
+		return
+	}
+	c.JSON(http.StatusOK, usage)
+}
@@ -0,0 +1,188 @@
+// Package quota keeps the OTP sends within daily and monthly budgets, of the whole service and
+// of every tenant, the backend sending OTPs on behalf of its users. Each message costs what the
+// provider of its channel charges, in whatever unit the costs and budgets are given in; with
+// the default cost of 1 the budgets count messages. The spend is accounted per channel too.
+package quota
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// DefaultCost is the cost of a message on channels without a configured one.
+const DefaultCost = 1
+
+// Limits are the budgets of a UTC day and month. Zero doesn't limit.
+type Limits struct {
+	Daily   int64
+	Monthly int64
+}
+
+// Counter is a spend counter with its budget.
+type Counter struct {
+	Key string
+	// Limit is the most the counter may reach; zero or less doesn't limit it.
+	Limit int64
+	// ExpiresAt is when the counter can be forgotten.
+	ExpiresAt time.Time
+}
+
+// Counters is the interface that the counter storage must satisfy.
+type Counters interface {
+	// Add adds amount to every counter, unless that takes one of them past its limit. It
+	// reports whether it added. Negative amounts are always added.
+	Add(ctx context.Context, counters []Counter, amount int64) (bool, error)
+	// Get returns the values of the counters, zero for unknown ones.
+	Get(ctx context.Context, keys []string) ([]int64, error)
+}
+
+// Usage is the spend of a UTC day and month, of the service or a tenant.
+type Usage struct {
+	Tenant string `json:"tenant,omitempty"`
+	Day    Period `json:"day"`
+	Month  Period `json:"month"`
+}
+
+// Period is the spend within one budget period.
+type Period struct {
+	Start time.Time `json:"start"`
+	Spent int64     `json:"spent"`
+	// Limit is the budget of the period; zero doesn't limit.
+	Limit int64 `json:"limit"`
+	// ByChannel splits the spend of the service by channel.
+	ByChannel map[string]int64 `json:"by_channel,omitempty"`
+}
+
+// Keeper books the cost of OTP sends against the budgets.
+type Keeper struct {
+	counters Counters
+	global   Limits
+	tenant   Limits
+	costs    map[string]int64
+	now      func() time.Time
+}
+
+// NewKeeper creates a Keeper with the budgets of the service and of every tenant, and the cost
+// of a message by channel. The channels of costs are the ones accounted separately.
+func NewKeeper(counters Counters, global, tenant Limits, costs map[string]int64) *Keeper {
+	return &Keeper{counters: counters, global: global, tenant: tenant, costs: costs, now: time.Now}
+}
+
+// Cost returns the cost of a message on the channel.
+func (k *Keeper) Cost(channel string) int64 {
+	if cost, ok := k.costs[channel]; ok {
+		return cost
+	}
+	return DefaultCost
+}
+
+// Spend books a message on the channel against the budgets of the service and of the tenant,
+// if any. It reports false, booking nothing, if that would exceed one of them.
+func (k *Keeper) Spend(ctx context.Context, tenant, channel string) (bool, error) {
+	ok, err := k.counters.Add(ctx, k.bookedCounters(tenant, channel, true), k.Cost(channel))
+	if err != nil {
+		return false, fmt.Errorf("failed to book OTP send: %w", err)
+	}
+	return ok, nil
+}
+
+// Refund takes back a message booked by Spend that wasn't sent after all.
+func (k *Keeper) Refund(ctx context.Context, tenant, channel string) error {
+	if _, err := k.counters.Add(ctx, k.bookedCounters(tenant, channel, false), -k.Cost(channel)); err != nil {
+		return fmt.Errorf("failed to refund OTP send: %w", err)
+	}
+	return nil
+}
+
+// Usage returns the spend of the current day and month, of the tenant or, if it's empty, of
+// the service.
+func (k *Keeper) Usage(ctx context.Context, tenant string) (Usage, error) {
+	day, month := k.periods()
+	usage := Usage{
+		Tenant: tenant,
+		Day:    Period{Start: day.start, Limit: k.global.Daily},
+		Month:  Period{Start: month.start, Limit: k.global.Monthly},
+	}
+	scope := "global"
+	if tenant != "" {
+		scope = "tenant:" + tenant
+		usage.Day.Limit, usage.Month.Limit = k.tenant.Daily, k.tenant.Monthly
+	}
+
+	keys := []string{day.key(scope), month.key(scope)}
+	var channels []string
+	if tenant == "" {
+		for channel := range k.costs {
+			channels = append(channels, channel)
+		}
+		slices.Sort(channels)
+		for _, channel := range channels {
+			keys = append(keys, day.key("channel:"+channel), month.key("channel:"+channel))
+		}
+	}
+	values, err := k.counters.Get(ctx, keys)
+	if err != nil {
+		return Usage{}, fmt.Errorf("failed to get OTP spend: %w", err)
+	}
+	usage.Day.Spent, usage.Month.Spent = values[0], values[1]
+	if len(channels) > 0 {
+		usage.Day.ByChannel = make(map[string]int64, len(channels))
+		usage.Month.ByChannel = make(map[string]int64, len(channels))
+		for i, channel := range channels {
+			usage.Day.ByChannel[channel] = values[2+2*i]
+			usage.Month.ByChannel[channel] = values[3+2*i]
+		}
+	}
+	return usage, nil
+}
+
+// bookedCounters returns the counters a message on the channel is booked to, limited unless
+// it's a refund.
+func (k *Keeper) bookedCounters(tenant, channel string, limited bool) []Counter {
+	day, month := k.periods()
+	counters := []Counter{
+		day.counter("global", k.global.Daily),
+		month.counter("global", k.global.Monthly),
+		day.counter("channel:"+channel, 0),
+		month.counter("channel:"+channel, 0),
+	}
+	if tenant != "" {
+		counters = append(counters,
+			day.counter("tenant:"+tenant, k.tenant.Daily),
+			month.counter("tenant:"+tenant, k.tenant.Monthly))
+	}
+	if !limited {
+		for i := range counters {
+			counters[i].Limit = 0
+		}
+	}
+	return counters
+}
+
+// period is a budget period, named by its start like "2006-01-02" or "2006-01".
+type period struct {
+	name  string
+	start time.Time
+	end   time.Time
+}
+
+func (k *Keeper) periods() (day, month period) {
+	now := k.now().UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	day = period{name: dayStart.Format(time.DateOnly), start: dayStart, end: dayStart.AddDate(0, 0, 1)}
+	month = period{name: monthStart.Format("2006-01"), start: monthStart, end: monthStart.AddDate(0, 1, 0)}
+	return day, month
+}
+
+func (p period) key(scope string) string {
+	return scope + ":" + p.name
+}
+
+// counter returns the counter of the scope in the period. It's kept for another day, so the
+// spend of the period just ended can still be looked at.
+func (p period) counter(scope string, limit int64) Counter {
+	return Counter{Key: p.key(scope), Limit: limit, ExpiresAt: p.end.Add(24 * time.Hour)}
+}
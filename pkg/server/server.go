@@ -0,0 +1,1016 @@
+// Package server wires the OTP auth service together: stores, rate limiters, services,
+// handlers and the HTTP listeners. cmd/app runs it as a standalone service; other Go
+// programs can embed it with New, either on its own listeners or mounted on their own
+// *gin.Engine.
+package server
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/config"
+	"github.com/ebipenman/go-otp-auth-service/internal/api"
+	"github.com/ebipenman/go-otp-auth-service/internal/database"
+	"github.com/ebipenman/go-otp-auth-service/internal/fieldcrypt"
+	"github.com/ebipenman/go-otp-auth-service/internal/graph"
+	"github.com/ebipenman/go-otp-auth-service/internal/health"
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/internal/mtls"
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+	"github.com/ebipenman/go-otp-auth-service/internal/phone"
+	"github.com/ebipenman/go-otp-auth-service/pkg/apikey"
+	"github.com/ebipenman/go-otp-auth-service/pkg/auth"
+	"github.com/ebipenman/go-otp-auth-service/pkg/deadletter"
+	"github.com/ebipenman/go-otp-auth-service/pkg/device"
+	"github.com/ebipenman/go-otp-auth-service/pkg/email"
+	"github.com/ebipenman/go-otp-auth-service/pkg/eventbus"
+	"github.com/ebipenman/go-otp-auth-service/pkg/export"
+	"github.com/ebipenman/go-otp-auth-service/pkg/featureflag"
+	"github.com/ebipenman/go-otp-auth-service/pkg/federation"
+	"github.com/ebipenman/go-otp-auth-service/pkg/loginhistory"
+	"github.com/ebipenman/go-otp-auth-service/pkg/loglevel"
+	"github.com/ebipenman/go-otp-auth-service/pkg/maintenance"
+	"github.com/ebipenman/go-otp-auth-service/pkg/merge"
+	"github.com/ebipenman/go-otp-auth-service/pkg/oidc"
+	"github.com/ebipenman/go-otp-auth-service/pkg/otp"
+	"github.com/ebipenman/go-otp-auth-service/pkg/outbox"
+	"github.com/ebipenman/go-otp-auth-service/pkg/privacy"
+	"github.com/ebipenman/go-otp-auth-service/pkg/quota"
+	"github.com/ebipenman/go-otp-auth-service/pkg/simswap"
+	"github.com/ebipenman/go-otp-auth-service/pkg/social"
+	"github.com/ebipenman/go-otp-auth-service/pkg/stats"
+	"github.com/ebipenman/go-otp-auth-service/pkg/user"
+	"github.com/ebipenman/go-otp-auth-service/pkg/webhook"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// apiInfo heads the OpenAPI document served at /openapi.json.
+var apiInfo = openapi.Info{
+	Title:       "OTP Auth GoLang API",
+	Description: "OTP based authentication and user management.",
+	Version:     "1.0",
+}
+
+// Option customizes a Server built by New.
+type Option func(*options)
+
+type options struct {
+	logger *slog.Logger
+	engine *gin.Engine
+	risk   auth.RiskEvaluator
+	sender otp.Sender
+}
+
+// WithLogger sets the logger of the server and its request logs (slog.Default() otherwise).
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithEngine mounts the routes onto an existing engine instead of a new one. The caller
+// serves the engine itself, so Start only starts the admin listener, if configured. The
+// engine's trusted proxies and CORS handling are left to the caller; the service's other
+// middleware only applies to its own routes.
+func WithEngine(engine *gin.Engine) Option {
+	return func(o *options) {
+		o.engine = engine
+	}
+}
+
+// WithRiskEvaluator sets the evaluator asked before OTPs are sent and verified, replacing
+// the SIM swap check configured by SIM_SWAP_API_URL.
+func WithRiskEvaluator(risk auth.RiskEvaluator) Option {
+	return func(o *options) {
+		o.risk = risk
+	}
+}
+
+// WithOTPSender sets how OTPs are delivered, replacing SMS_PROVIDER; reloading the
+// configuration then keeps it.
+func WithOTPSender(sender otp.Sender) Option {
+	return func(o *options) {
+		o.sender = sender
+	}
+}
+
+// Server is the wired up service. Build it with New, then call Start and, eventually, Shutdown.
+type Server struct {
+	cfg     *config.Config
+	logger  *slog.Logger
+	router  *gin.Engine
+	mounted bool
+
+	// srv and httpSrv are nil when the routes are mounted on the caller's engine,
+	// adminSrv when the admin routes are served by the main router.
+	srv      *http.Server
+	httpSrv  *http.Server
+	adminSrv *http.Server
+
+	postgresStore        *database.PostgresStore
+	snapshotter          *database.Snapshotter
+	redisClient          *redis.Client
+	otpRateLimiter       *middleware.ReloadableRateLimiter
+	otpChannelLimiters   map[string]*middleware.ReloadableRateLimiter // by enabled OTP channel
+	otpVerifyRateLimiter *middleware.ReloadableRateLimiter
+	ipRateLimiter        *middleware.ReloadableRateLimiter
+	anomalyDetector      *middleware.AnomalyDetector
+	otpSender            *otp.ReloadableSender
+	otpDeliveries        *otp.StatusTracker
+	fixedSender          bool // the sender came from WithOTPSender
+	webhookDispatcher    *webhook.Dispatcher
+	otpDispatcher        *otp.Dispatcher     // nil sends OTPs within the requests
+	otpScheduler         *otp.Scheduler      // nil without OTP_AUTO_RESEND_AFTER
+	eventBus             *eventbus.Publisher // nil without EVENT_BUS
+	outboxRelay          *outbox.Relay       // nil unless the store is Postgres
+	purger               *privacy.Purger     // nil without DELETION_GRACE_PERIOD
+	exportService        export.Service
+	authService          auth.Service
+	maintenance          *maintenance.Switch
+	featureFlags         *featureflag.Flags
+	flagPoller           *featureflag.Poller // nil without FEATURE_FLAGS_URL
+
+	// mu guards runtime, the settings last applied by ApplyRuntime.
+	mu      sync.Mutex
+	runtime config.Runtime
+}
+
+// New connects to the configured stores and builds the service. Nothing listens until
+// Start is called; on error, whatever was already opened is closed again.
+func New(cfg *config.Config, opts ...Option) (_ *Server, err error) {
+	o := options{logger: slog.Default()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	logger := o.logger
+
+	// A Config built in code instead of by config.Load may set only one of the JWT secrets.
+	if len(cfg.JWTSecrets) == 0 {
+		if cfg.JWTSecret == "" {
+			return nil, errors.New("no JWT secret configured")
+		}
+		withSecrets := *cfg
+		withSecrets.JWTSecrets = []string{cfg.JWTSecret}
+		cfg = &withSecrets
+	} else if cfg.JWTSecret == "" {
+		withSecret := *cfg
+		withSecret.JWTSecret = cfg.JWTSecrets[0]
+		cfg = &withSecret
+	}
+
+	s := &Server{cfg: cfg, logger: logger, runtime: cfg.Runtime()}
+	defer func() {
+		if err != nil {
+			s.close()
+		}
+	}()
+
+	// Declare variables for our stores using their INTERFACE types.
+	var userStore user.UserStore
+	var otpStore otp.OTPStore
+	var apiKeyStore apikey.APIKeyStore
+	var deviceStore device.DeviceStore
+	var identityStore social.IdentityStore
+	var loginStore loginhistory.LoginStore
+	var exportStore export.ExportStore
+	var statsStore stats.StatsStore
+	var emailStore email.EmailStore
+	var mergeStore merge.MergeStore
+	var deadLetterStore deadletter.DeadLetterStore
+
+	// Decide which concrete implementation to create based on the config.
+	if cfg.StorageType == "postgres" {
+		logger.Info("Initializing PostgreSQL database store...")
+		// Phone numbers are encrypted at rest when a key is configured.
+		var phoneCipher *fieldcrypt.Cipher
+		if cfg.PhoneEncryptionKey != "" {
+			phoneCipher, err = fieldcrypt.New(cfg.PhoneEncryptionKey)
+			if err != nil {
+				return nil, fmt.Errorf("invalid PHONE_ENCRYPTION_KEY: %w", err)
+			}
+		}
+		// The database may still be starting next to the service, e.g. in docker compose.
+		retry := database.ConnectRetry{MaxWait: cfg.DBConnectMaxWait, BaseDelay: cfg.DBConnectRetryBaseDelay, MaxDelay: cfg.DBConnectRetryMaxDelay}
+		if err := database.WaitForPostgres(context.Background(), cfg.DatabaseURL, retry); err != nil {
+			return nil, fmt.Errorf("could not connect to postgres database: %w", err)
+		}
+		// Fresh clusters get the database and extensions the migrations need.
+		if cfg.DBAutoProvision {
+			if err := database.ProvisionPostgres(cfg.DatabaseURL); err != nil {
+				return nil, fmt.Errorf("could not provision postgres database: %w", err)
+			}
+		}
+		s.postgresStore, err = database.NewPostgresStore(cfg.DatabaseURL, phoneCipher)
+		if err != nil {
+			return nil, fmt.Errorf("could not connect to postgres database: %w", err)
+		}
+		// The single PostgresStore object implements BOTH interfaces.
+		userStore = s.postgresStore
+		otpStore = s.postgresStore
+		apiKeyStore = s.postgresStore
+		deviceStore = s.postgresStore
+		identityStore = s.postgresStore
+		loginStore = s.postgresStore
+		exportStore = s.postgresStore
+		statsStore = s.postgresStore
+		emailStore = s.postgresStore
+		mergeStore = s.postgresStore
+		deadLetterStore = s.postgresStore
+	} else {
+		logger.Info("Initializing in-memory database store...")
+		// For in-memory, we have separate store objects.
+		users := database.NewInMemoryUserStore()
+		otps := database.NewInMemoryOTPStore(cfg.OTPStoreMaxEntries)
+		if cfg.MemorySnapshotFile != "" {
+			s.snapshotter, err = database.NewSnapshotter(cfg.MemorySnapshotFile, cfg.MemorySnapshotInterval, users, otps)
+			if err != nil {
+				return nil, fmt.Errorf("could not restore MEMORY_SNAPSHOT_FILE: %w", err)
+			}
+		}
+		userStore = users
+		otpStore = otps
+		apiKeyStore = database.NewInMemoryAPIKeyStore()
+		deviceStore = database.NewInMemoryDeviceStore()
+		identityStore = database.NewInMemoryIdentityStore()
+		loginStore = database.NewInMemoryLoginStore()
+		exportStore = database.NewInMemoryExportStore()
+		statsStore = database.NewInMemoryStatsStore(users, otps)
+		emailStore = users
+		mergeStore = users
+		deadLetterStore = database.NewInMemoryDeadLetterStore()
+	}
+
+	// Rate limiter state lives in Redis when configured, so limits are shared by all replicas.
+	if cfg.RateLimitBackend == "redis" {
+		logger.Info("Initializing Redis rate limiters...")
+		s.redisClient, err = database.NewRedisClient(cfg.RedisURL)
+		if err != nil {
+			return nil, fmt.Errorf("could not connect to redis: %w", err)
+		}
+	} else {
+		logger.Info("Initializing in-memory rate limiters...")
+	}
+
+	// The limiters can be replaced when their settings are reloaded.
+	s.otpRateLimiter = middleware.NewReloadableRateLimiter(newRateLimiter(s.redisClient, "ratelimit:otp:", cfg.OTPSendRateLimit, cfg.RateLimitCleanupInterval))
+	// Every channel has its own send limit below the one of s.otpRateLimiter, which caps them all.
+	s.otpChannelLimiters = make(map[string]*middleware.ReloadableRateLimiter, len(cfg.OTPChannels))
+	channelLimiters := make(map[string]auth.RateLimiter, len(cfg.OTPChannels))
+	for _, channel := range cfg.OTPChannels {
+		limiter := middleware.NewReloadableRateLimiter(newRateLimiter(s.redisClient, "ratelimit:otp:"+channel+":", cfg.OTPChannelSendRateLimits[channel], cfg.RateLimitCleanupInterval))
+		s.otpChannelLimiters[channel] = limiter
+		channelLimiters[channel] = limiter
+	}
+	s.otpVerifyRateLimiter = middleware.NewReloadableRateLimiter(newRateLimiter(s.redisClient, "ratelimit:verify:", cfg.OTPVerifyRateLimit, cfg.RateLimitCleanupInterval))
+	// A separate limiter keyed by client IP stops one host from cycling through phone numbers.
+	s.ipRateLimiter = middleware.NewReloadableRateLimiter(newRateLimiter(s.redisClient, "ratelimit:ip:", cfg.IPRateLimit, cfg.RateLimitCleanupInterval))
+
+	// Brute-force attacks spread over many numbers or IPs get their own, longer blocks.
+	var attackDetector auth.AttackDetector
+	if cfg.AnomalyDetectionEnabled {
+		var failures middleware.FailureStore
+		var box middleware.PenaltyBox
+		if s.redisClient != nil {
+			failures = middleware.NewRedisFailureStore(s.redisClient, "anomaly:failures:")
+			box = middleware.NewRedisPenaltyBox(s.redisClient, "anomaly:block:")
+		} else {
+			failures = middleware.NewInMemoryFailureStore(cfg.RateLimitCleanupInterval)
+			box = middleware.NewInMemoryPenaltyBox(cfg.RateLimitCleanupInterval)
+		}
+		s.anomalyDetector = middleware.NewAnomalyDetector(failures, box, cfg.AnomalyWindow,
+			cfg.AnomalyMaxPhonesPerIP, cfg.AnomalyMaxIPsPerPhone, cfg.AnomalyBlock, cfg.AnomalyMaxBlock)
+		attackDetector = s.anomalyDetector
+	}
+
+	// Tokens of deleted accounts are revoked on every replica when they share Redis.
+	var tokenRevocations middleware.TokenRevocations
+	if s.redisClient != nil {
+		tokenRevocations = middleware.NewRedisTokenRevocations(s.redisClient, "revoked:", auth.TokenTTL)
+	} else {
+		tokenRevocations = middleware.NewInMemoryTokenRevocations(auth.TokenTTL)
+	}
+	tokenValidator := middleware.NewJWTValidator(cfg.JWTSecrets, middleware.TokenPolicy{
+		ClockSkew:      cfg.JWTClockSkew,
+		RequiredClaims: cfg.JWTRequiredClaims,
+		MaxAge:         cfg.JWTMaxTokenAge,
+	})
+
+	// Initialize OTP components
+	otpGenerator := otp.NewSimpleOTPGenerator()
+	// The provider can be switched when the configuration is reloaded.
+	if o.sender != nil {
+		s.otpSender = otp.NewReloadableSender(o.sender)
+		s.fixedSender = true
+	} else {
+		s.otpSender = otp.NewReloadableSender(newOTPSender(cfg.SMSProvider, cfg.Env))
+	}
+	// Clients can follow the delivery of their OTP as server-sent events.
+	s.otpDeliveries = otp.NewStatusTracker()
+
+	// Initialize Repositories
+	userRepo := user.NewRepository(userStore)
+	otpRepo := otp.NewRepository(otpStore)
+	apiKeyRepo := apikey.NewRepository(apiKeyStore)
+	deviceRepo := device.NewRepository(deviceStore)
+	authRepo := auth.NewRepository(userRepo, otpRepo, s.otpRateLimiter, channelLimiters, s.otpVerifyRateLimiter, attackDetector)
+
+	// Auth events are delivered to the configured webhook URLs, and streamed to the event bus.
+	s.webhookDispatcher = webhook.NewDispatcher(cfg.WebhookURLs, cfg.WebhookSecret, cfg.WebhookMaxAttempts, logger)
+	var events eventbus.EventPublisher = s.webhookDispatcher
+	if cfg.EventBus != "" {
+		var broker eventbus.Broker
+		if cfg.EventBus == "nats" {
+			broker, err = eventbus.NewNATSBroker(cfg.NATSURL, cfg.NATSSubjectPrefix)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			broker = eventbus.NewKafkaBroker(cfg.KafkaRESTURL, cfg.KafkaTopic)
+		}
+		s.eventBus = eventbus.NewPublisher(broker, logger)
+		events = eventbus.Fanout{s.webhookDispatcher, s.eventBus}
+	}
+
+	// Sign-ins from unseen devices always emit an event; users are only told when enabled,
+	// by email too if they verified their address.
+	emailSender := email.NewConsoleSender(cfg.Env == config.EnvDev)
+	var deviceNotifier device.Notifier
+	if cfg.NewDeviceNotifications {
+		deviceNotifier = device.Notifiers{device.NewConsoleNotifier(), email.NewDeviceNotifier(emailSender)}
+	}
+	deviceService := device.NewService(deviceRepo, deviceNotifier)
+	loginRepo := loginhistory.NewRepository(loginStore)
+	loginService := loginhistory.NewService(loginRepo)
+	deadLetterRepo := deadletter.NewRepository(deadLetterStore)
+	s.exportService = export.NewService(export.NewRepository(exportStore, userRepo, deviceRepo, identityStore, loginRepo), cfg.DataExportTTL)
+
+	// The SIM swap check is the only built-in risk evaluator; without one every request is allowed.
+	riskEvaluator := o.risk
+	if riskEvaluator == nil && cfg.SIMSwapAPIURL != "" {
+		onSwap := auth.RiskChallenge
+		if cfg.SIMSwapAction == "deny" {
+			onSwap = auth.RiskDeny
+		}
+		riskEvaluator = simswap.NewChecker(cfg.SIMSwapAPIURL, cfg.SIMSwapAPIToken, cfg.SIMSwapMaxAge, onSwap, events)
+	}
+
+	statsService := stats.NewService(stats.NewRepository(statsStore))
+
+	// Every OTP is booked against the send budgets, shared through Redis when configured.
+	otpCosts := make(map[string]int64, len(cfg.OTPChannels))
+	for _, channel := range cfg.OTPChannels {
+		otpCosts[channel] = quota.DefaultCost
+		if cost, ok := cfg.OTPCosts[channel]; ok {
+			otpCosts[channel] = cost
+		}
+	}
+	var quotaCounters quota.Counters = quota.NewInMemoryCounters()
+	if s.redisClient != nil {
+		quotaCounters = quota.NewRedisCounters(s.redisClient, "quota:otp:")
+	}
+	quotaKeeper := quota.NewKeeper(quotaCounters,
+		quota.Limits{Daily: cfg.OTPQuotaDaily, Monthly: cfg.OTPQuotaMonthly},
+		quota.Limits{Daily: cfg.OTPTenantQuotaDaily, Monthly: cfg.OTPTenantQuotaMonthly},
+		otpCosts)
+
+	// Features can be rolled out to part of the traffic, configured or fetched remotely.
+	s.featureFlags = featureflag.NewFlags(featureFlags(cfg.FeatureFlags))
+	if cfg.FeatureFlagsURL != "" {
+		s.flagPoller = featureflag.NewPoller(s.featureFlags, cfg.FeatureFlagsURL, cfg.FeatureFlagsRefreshInterval, logger)
+	}
+
+	// The auth service now correctly receives all its dependencies via the authRepo.
+	authOptions := []auth.Option{
+		auth.WithOTPGenerator(otpGenerator),
+		auth.WithSender(otp.NewRetryingSender(s.otpSender, otp.RetryPolicy{
+			MaxAttempts: cfg.SMSRetryMaxAttempts,
+			BaseDelay:   cfg.SMSRetryBaseDelay,
+			MaxDelay:    cfg.SMSRetryMaxDelay,
+		})),
+		auth.WithOTPTTL(time.Duration(cfg.OTPExpirationMinutes) * time.Minute),
+		auth.WithDeliveryTracker(s.otpDeliveries),
+		auth.WithEventPublisher(events),
+		auth.WithDeviceTracker(deviceService),
+		auth.WithTrustedDevices(cfg.TrustedDeviceTTL),
+		auth.WithGuestSessions(cfg.GuestTokenTTL),
+		auth.WithDeletionGracePeriod(cfg.DeletionGracePeriod),
+		auth.WithLoginRecorder(loginService),
+		auth.WithDeadLetters(deadLetterRepo),
+		auth.WithTokenRevoker(tokenRevocations),
+		auth.WithStatsRecorder(statsService),
+		auth.WithPhonePolicy(phone.Policy{
+			DefaultRegion:       cfg.PhoneDefaultRegion,
+			AllowedCountryCodes: cfg.OTPAllowedCountryCodes,
+			BlockedCountryCodes: cfg.OTPBlockedCountryCodes,
+		}),
+		auth.WithRiskEvaluator(riskEvaluator),
+		auth.WithQuota(quotaKeeper, cfg.OTPQuotaFailover),
+		auth.WithBranding(otp.Branding{SenderID: cfg.SMSSenderID, MessagePrefix: cfg.SMSMessagePrefix}, tenantBranding(cfg)),
+		auth.WithFeatureFlags(s.featureFlags),
+	}
+	if cfg.OTPDispatchWorkers > 0 {
+		s.otpDispatcher = otp.NewDispatcher(cfg.OTPDispatchWorkers, cfg.OTPDispatchQueueSize)
+		authOptions = append(authOptions, auth.WithDispatcher(s.otpDispatcher))
+	}
+	if cfg.OTPAutoResendAfter > 0 {
+		s.otpScheduler = otp.NewScheduler()
+		authOptions = append(authOptions, auth.WithAutoResend(cfg.OTPAutoResendAfter, cfg.OTPAutoResendChannel, s.otpScheduler))
+	}
+	// SMS goes through s.otpSender; the other channels have no real provider yet, but email.
+	emailRepo := email.NewRepository(emailStore, userRepo)
+	for _, channel := range cfg.OTPChannels {
+		switch channel {
+		case otp.ChannelSMS:
+		case otp.ChannelEmail:
+			authOptions = append(authOptions, auth.WithChannelSender(channel, email.NewOTPSender(emailRepo, emailSender)))
+		default:
+			authOptions = append(authOptions, auth.WithChannelSender(channel, otp.NewConsoleChannelSender(channel, cfg.Env == config.EnvDev)))
+		}
+	}
+	// With Postgres, user.created is written in the transaction creating the user and relayed from there.
+	if s.postgresStore != nil {
+		s.outboxRelay = outbox.NewRelay(s.postgresStore, events, cfg.OutboxPollInterval, logger)
+		authOptions = append(authOptions, auth.WithUserOutbox(s.postgresStore))
+	}
+	s.authService = auth.NewService(authRepo, cfg.JWTSecret, authOptions...)
+	userService := user.NewService(userRepo)
+	apiKeyService := apikey.NewService(apiKeyRepo, cfg.JWTSecrets)
+
+	// The bootstrap admin key lets operators create the first real API keys.
+	if cfg.AdminAPIKey != "" {
+		if err := apiKeyService.ImportAPIKey(context.Background(), "bootstrap-admin", cfg.AdminAPIKey, []string{apikey.ScopeAdmin}); err != nil {
+			return nil, fmt.Errorf("could not import ADMIN_API_KEY: %w", err)
+		}
+	}
+
+	// Initialize Handlers
+	authHandler := auth.NewHandler(s.authService)
+	userHandler := user.NewHandler(userService)
+	apiKeyHandler := apikey.NewHandler(apiKeyService)
+	deviceHandler := device.NewHandler(deviceService)
+	loginHandler := loginhistory.NewHandler(loginService)
+	exportHandler := export.NewHandler(s.exportService)
+	privacyRepo := privacy.NewRepository(userRepo, deviceRepo, loginRepo, identityStore, exportStore, otpRepo, deadLetterRepo)
+	privacyService := privacy.NewService(privacyRepo, tokenRevocations, events, s.webhookDispatcher)
+	// Deleted accounts are purged once they can't be restored anymore, checked at least hourly.
+	if cfg.DeletionGracePeriod > 0 {
+		s.purger = privacy.NewPurger(privacyRepo, privacyService, cfg.DeletionGracePeriod,
+			min(cfg.DeletionGracePeriod, time.Hour), logger)
+	}
+	privacyHandler := privacy.NewHandler(privacyService)
+	mergeService := merge.NewService(
+		merge.NewRepository(userRepo, deviceRepo, loginRepo, identityStore, exportStore, mergeStore),
+		tokenRevocations, events)
+	mergeHandler := merge.NewHandler(mergeService)
+	statsHandler := stats.NewHandler(statsService)
+	quotaHandler := quota.NewHandler(quotaKeeper)
+	deadLetterHandler := deadletter.NewHandler(deadletter.NewService(deadLetterRepo, s.authService))
+	// Sign-ins can be paused for planned maintenance, from the config or the admin API.
+	s.maintenance = maintenance.NewSwitch(cfg.MaintenanceMode, cfg.MaintenanceMessage)
+	maintenanceHandler := maintenance.NewHandler(s.maintenance)
+	featureFlagHandler := featureflag.NewHandler(s.featureFlags)
+	logLevelHandler := loglevel.NewHandler()
+	if cfg.MaintenanceMode {
+		logger.Warn("Starting in maintenance mode, sign-ins answer 503 until MAINTENANCE_MODE is turned off")
+	}
+	graphHandler := graph.NewHandler(graph.NewResolver(s.authService, userService, s.ipRateLimiter))
+
+	// Client-facing error messages follow the Accept-Language header.
+	catalog := i18n.NewCatalog()
+	if cfg.I18nDir != "" {
+		if err := catalog.LoadDir(cfg.I18nDir); err != nil {
+			return nil, fmt.Errorf("could not load message catalogs: %w", err)
+		}
+	}
+
+	// Readiness depends on everything a login needs.
+	healthHandler := health.NewHandler()
+	if s.postgresStore != nil {
+		healthHandler.Register("database", s.postgresStore.Ping)
+		healthHandler.Register("migrations", s.postgresStore.CheckMigrations)
+	}
+	if s.redisClient != nil {
+		redisClient := s.redisClient
+		healthHandler.Register("redis", func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		})
+	}
+	healthHandler.Register("sms_provider", s.otpSender.CheckHealth)
+
+	// Setup Gin router, or mount the routes onto the caller's one.
+	base := cfg.BasePath
+	var router gin.IRouter
+	if o.engine != nil {
+		s.router = o.engine
+		s.mounted = true
+		// A group keeps the middleware below off the caller's own routes.
+		router = o.engine.Group("/")
+	} else {
+		s.router = gin.New()
+		router = s.router
+
+		// Only trust X-Forwarded-For from the configured proxies; with none configured,
+		// the client IP comes straight from the TCP connection.
+		if err := s.router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+		}
+	}
+
+	router.Use(middleware.SecurityHeaders(cfg.ContentSecurityPolicy, cfg.HSTSMaxAge))
+
+	// Cross-origin requests are only allowed from the configured origins (the front-end dev
+	// server by default).
+	if len(cfg.CORSAllowedOrigins) > 0 && !s.mounted {
+		corsConfig := cors.Config{
+			AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+			AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Request-ID", "X-API-Key", "X-Device-ID"},
+			ExposeHeaders:    []string{"Content-Length", "X-Request-ID", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"},
+			AllowCredentials: cfg.CORSAllowCredentials,
+			MaxAge:           12 * time.Hour,
+		}
+		// cors rejects "*" among the origins; it must be AllowAllOrigins alone.
+		if slices.Contains(cfg.CORSAllowedOrigins, "*") {
+			corsConfig.AllowAllOrigins = true
+		} else {
+			corsConfig.AllowOrigins = cfg.CORSAllowedOrigins
+		}
+		router.Use(cors.New(corsConfig))
+	}
+
+	// Global Middleware
+	router.Use(otelgin.Middleware(cfg.ServiceName))
+	router.Use(middleware.RequestIDMiddleware())
+	router.Use(middleware.RequestLogger(logger))
+	if cfg.LogBodies {
+		// Debug aid for integrations; bodies are logged with phone numbers and OTPs masked.
+		router.Use(middleware.BodyLogger(4096))
+	}
+	router.Use(middleware.Localization(catalog))
+	router.Use(gin.Recovery())
+	// Shed load before it reaches the handlers (and the database) when the instance is saturated.
+	router.Use(middleware.LoadShedder(cfg.MaxConcurrentRequests, cfg.MaxRequestsPerSecond, base+"/health", base+"/livez", base+"/readyz"))
+	// Oversized bodies are turned away before they reach the JSON binder, the sign-in ones
+	// sooner than the others.
+	router.Use(middleware.BodyLimit(cfg.MaxRequestBodySize, authBodyLimits(base, cfg.AuthMaxRequestBodySize)))
+	// A hung store or provider answers 504 instead of holding the request; the status
+	// streams last until the OTP expires, and CPU profiles and traces as long as asked for.
+	requestTimeout := middleware.Timeout(cfg.RequestTimeout, prefixed(base, cfg.RequestTimeouts), base+"/otp/deliveries/:id/events",
+		base+"/admin/debug/pprof/profile", base+"/admin/debug/pprof/trace")
+	router.Use(requestTimeout)
+	router.Use(middleware.BasePath(base))
+	// Handlers pass the errors of the services on; their status and code come from apperr.
+	router.Use(middleware.ErrorHandler())
+
+	// Behind a gateway routing by path, every route lives below the base path.
+	routes := router.Group(cmp.Or(base, "/"))
+
+	// Every route that signs in or sends a code pauses in maintenance mode.
+	maintenanceGate := maintenance.Gate(s.maintenance)
+
+	// The router setup function needs this to apply the rate limiting middleware
+	api.SetupRoutes(routes, authHandler, userHandler, deviceHandler, loginHandler, exportHandler, graphHandler, healthHandler, apiKeyService, tokenValidator, tokenRevocations, s.ipRateLimiter,
+		middleware.SignedRequestAuth(cfg.ServiceClientSecrets, cfg.ServiceClientMaxSkew), maintenanceGate,
+		time.Duration(cfg.PhoneReverifyDays)*24*time.Hour)
+	// A verified email address is a second way into the account.
+	emailService := email.NewService(emailRepo, emailSender, s.authService, otpGenerator)
+	api.SetupEmailRoutes(routes, email.NewHandler(emailService), tokenValidator, tokenRevocations, s.ipRateLimiter,
+		maintenanceGate, time.Duration(cfg.PhoneReverifyDays)*24*time.Hour)
+	// Machine clients can trade their API key for a short-lived access token.
+	api.SetupOAuthRoutes(routes, apiKeyHandler, s.ipRateLimiter)
+
+	// Apps that allow browsing before sign-in can start with a guest session.
+	if cfg.GuestTokenTTL > 0 {
+		api.SetupGuestRoutes(routes, authHandler, s.ipRateLimiter, maintenanceGate)
+	}
+
+	// Google and Apple sign-in as a fallback for unreliable SMS delivery.
+	var socialProviders []*social.Provider
+	if len(cfg.GoogleClientIDs) > 0 {
+		socialProviders = append(socialProviders, social.Google(cfg.GoogleClientIDs))
+	}
+	if len(cfg.AppleClientIDs) > 0 {
+		socialProviders = append(socialProviders, social.Apple(cfg.AppleClientIDs))
+	}
+	if len(socialProviders) > 0 {
+		var nonces social.NonceStore = social.NewInMemoryNonceStore()
+		if s.redisClient != nil {
+			nonces = social.NewRedisNonceStore(s.redisClient, "social:nonce:")
+		}
+		socialService := social.NewService(social.NewRepository(identityStore, userRepo), s.authService, nonces, socialProviders...)
+		api.SetupSocialRoutes(routes, social.NewHandler(socialService), tokenValidator, tokenRevocations, s.ipRateLimiter, maintenanceGate)
+	}
+
+	// Employees can sign in through their organization's identity provider instead.
+	if cfg.FederationIssuer != "" {
+		relyingParty := federation.NewRelyingParty(cfg.FederationIssuer, cfg.FederationClientID, cfg.FederationClientSecret, cfg.FederationRedirectURL)
+		api.SetupFederationRoutes(routes, federation.NewHandler(relyingParty, s.authService, cfg.FederationReturnURLs), s.ipRateLimiter, maintenanceGate)
+	}
+
+	// Relying parties can use the OTP flow through OpenID Connect.
+	if cfg.OIDCIssuer != "" {
+		signingKey, err := oidc.LoadSigningKey(cfg.OIDCSigningKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load OIDC_SIGNING_KEY_FILE: %w", err)
+		}
+		if cfg.OIDCSigningKeyFile == "" {
+			logger.Warn("OIDC_SIGNING_KEY_FILE is not set, ID tokens are signed with a key generated on startup")
+		}
+		clients := make(map[string]oidc.Client, len(cfg.OIDCClients))
+		for clientID, secret := range cfg.OIDCClients {
+			clients[clientID] = oidc.Client{Secret: secret, RedirectURIs: cfg.OIDCRedirectURIs[clientID]}
+		}
+		provider := oidc.NewProvider(cfg.OIDCIssuer, clients, signingKey)
+		api.SetupOIDCRoutes(routes, oidc.NewHandler(provider, s.authService), provider, tokenRevocations, s.ipRateLimiter, maintenanceGate)
+	}
+
+	// Admin endpoints move to their own mutual TLS listener when one is configured.
+	if cfg.AdminPort != "" {
+		adminTLS, err := mtls.ServerConfig(cfg.AdminTLSCertFile, cfg.AdminTLSKeyFile, cfg.AdminClientCAFile, cfg.AdminClientAllowedSANs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid admin listener TLS configuration: %w", err)
+		}
+
+		adminRouter := gin.New()
+		adminRouter.Use(otelgin.Middleware(cfg.ServiceName))
+		adminRouter.Use(middleware.RequestIDMiddleware())
+		adminRouter.Use(middleware.RequestLogger(logger.With("listener", "admin")))
+		adminRouter.Use(middleware.Localization(catalog))
+		adminRouter.Use(gin.Recovery())
+		adminRouter.Use(middleware.BodyLimit(cfg.MaxRequestBodySize, nil))
+		adminRouter.Use(requestTimeout)
+		adminRouter.Use(middleware.BasePath(base))
+		adminRouter.Use(middleware.ErrorHandler())
+		api.SetupAdminRoutes(adminRouter.Group(cmp.Or(base, "/")), apiKeyHandler, loginHandler, privacyHandler, mergeHandler, statsHandler, quotaHandler, deadLetterHandler, authHandler, maintenanceHandler, featureFlagHandler, logLevelHandler, apiKeyService)
+		if cfg.ProfilingEnabled {
+			api.SetupProfilingRoutes(adminRouter.Group(cmp.Or(base, "/")), apiKeyService)
+		}
+		adminRouter.GET(base+"/openapi.json", openapi.Handler(adminRouter.Routes, base, apiInfo))
+
+		s.adminSrv = &http.Server{
+			Addr:              ":" + cfg.AdminPort,
+			Handler:           adminRouter,
+			TLSConfig:         adminTLS,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	} else {
+		api.SetupAdminRoutes(routes, apiKeyHandler, loginHandler, privacyHandler, mergeHandler, statsHandler, quotaHandler, deadLetterHandler, authHandler, maintenanceHandler, featureFlagHandler, logLevelHandler, apiKeyService)
+		if cfg.ProfilingEnabled {
+			api.SetupProfilingRoutes(routes, apiKeyService)
+		}
+	}
+
+	// The OpenAPI 3 document of the routes above, and the Swagger UI showing it.
+	routes.GET("/openapi.json", openapi.Handler(s.router.Routes, base, apiInfo))
+	routes.GET("/swagger/*any", middleware.ContentSecurityPolicy(middleware.SwaggerUIContentSecurityPolicy),
+		openapi.SwaggerUI(base+"/openapi.json"))
+
+	if !s.mounted {
+		s.setupListeners()
+	}
+	return s, nil
+}
+
+// setupListeners prepares the main server and, next to native TLS, the plain HTTP one.
+func (s *Server) setupListeners() {
+	cfg := s.cfg
+	s.srv = &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           s.router,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	var autocertManager *autocert.Manager
+	if len(cfg.TLSAutocertDomains) > 0 {
+		autocertManager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.TLSAutocertDomains...),
+			Cache:      autocert.DirCache(cfg.TLSAutocertCacheDir),
+			Email:      cfg.TLSAutocertEmail,
+		}
+		// Also answers TLS-ALPN-01 challenges, so autocert works without port 80.
+		s.srv.TLSConfig = autocertManager.TLSConfig()
+	} else if cfg.TLSCertFile != "" {
+		s.srv.TLSConfig = &tls.Config{}
+	}
+	if s.srv.TLSConfig != nil {
+		s.srv.TLSConfig.MinVersion = tls.VersionTLS12
+	}
+
+	if cfg.TLSHTTPPort != "" {
+		var handler http.Handler = http.HandlerFunc(redirectToHTTPS)
+		if autocertManager != nil {
+			// Serves HTTP-01 challenges and redirects everything else.
+			handler = autocertManager.HTTPHandler(nil)
+		}
+		s.httpSrv = &http.Server{
+			Addr:              ":" + cfg.TLSHTTPPort,
+			Handler:           handler,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+}
+
+// Handler returns the engine serving the service's routes, the caller's one with WithEngine.
+func (s *Server) Handler() http.Handler {
+	return s.router
+}
+
+// Start binds the listeners and serves them in the background. It returns once they accept
+// connections, or the error of the first one that couldn't bind.
+func (s *Server) Start() error {
+	cfg := s.cfg
+	if s.adminSrv != nil {
+		ln, err := net.Listen("tcp", s.adminSrv.Addr)
+		if err != nil {
+			return fmt.Errorf("admin server failed to start: %w", err)
+		}
+		s.logger.Info("Admin server starting (mutual TLS)", "port", cfg.AdminPort)
+		go s.serve("Admin server", func() error { return s.adminSrv.ServeTLS(ln, "", "") })
+	}
+	if s.mounted {
+		return nil
+	}
+
+	if s.httpSrv != nil {
+		ln, err := net.Listen("tcp", s.httpSrv.Addr)
+		if err != nil {
+			return fmt.Errorf("HTTP redirect server failed to start: %w", err)
+		}
+		s.logger.Info("HTTP to HTTPS redirect starting", "port", cfg.TLSHTTPPort)
+		go s.serve("HTTP redirect server", func() error { return s.httpSrv.Serve(ln) })
+	}
+
+	ln, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return fmt.Errorf("server failed to start: %w", err)
+	}
+	s.logger.Info("Server starting", "port", cfg.Port, "tls", cfg.TLSEnabled())
+	go s.serve("Server", func() error {
+		if cfg.TLSEnabled() {
+			// With autocert both file names are empty and the certificates come from the TLS config.
+			return s.srv.ServeTLS(ln, cfg.TLSCertFile, cfg.TLSKeyFile)
+		}
+		return s.srv.Serve(ln)
+	})
+	return nil
+}
+
+// serve runs a listener until it's shut down, logging why it stopped otherwise.
+func (s *Server) serve(name string, serve func() error) {
+	if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.logger.Error(name+" stopped", "error", err)
+	}
+}
+
+// ApplyRuntime applies the settings that are safe to change while running, as returned by
+// config.Reload; everything else, including the in-memory OTPs, stays as it is.
+func (s *Server) ApplyRuntime(rt config.Runtime) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := logging.SetLevel(rt.LogLevel); err != nil {
+		s.logger.Error("Config reload failed to set the log level", "error", err)
+	}
+	if rt.OTPSendRateLimit != s.runtime.OTPSendRateLimit {
+		s.otpRateLimiter.Swap(newRateLimiter(s.redisClient, "ratelimit:otp:", rt.OTPSendRateLimit, s.cfg.RateLimitCleanupInterval))
+	}
+	for channel, limiter := range s.otpChannelLimiters {
+		if rl := rt.OTPChannelSendRateLimits[channel]; rl != s.runtime.OTPChannelSendRateLimits[channel] {
+			limiter.Swap(newRateLimiter(s.redisClient, "ratelimit:otp:"+channel+":", rl, s.cfg.RateLimitCleanupInterval))
+		}
+	}
+	if rt.OTPVerifyRateLimit != s.runtime.OTPVerifyRateLimit {
+		s.otpVerifyRateLimiter.Swap(newRateLimiter(s.redisClient, "ratelimit:verify:", rt.OTPVerifyRateLimit, s.cfg.RateLimitCleanupInterval))
+	}
+	if rt.IPRateLimit != s.runtime.IPRateLimit {
+		s.ipRateLimiter.Swap(newRateLimiter(s.redisClient, "ratelimit:ip:", rt.IPRateLimit, s.cfg.RateLimitCleanupInterval))
+	}
+	s.authService.SetPhonePolicy(phone.Policy{
+		DefaultRegion:       s.cfg.PhoneDefaultRegion,
+		AllowedCountryCodes: rt.OTPAllowedCountryCodes,
+		BlockedCountryCodes: rt.OTPBlockedCountryCodes,
+	})
+	// The admin API may have switched maintenance mode since, so only a changed setting counts.
+	if rt.MaintenanceMode != s.runtime.MaintenanceMode || rt.MaintenanceMessage != s.runtime.MaintenanceMessage {
+		s.maintenance.Set(rt.MaintenanceMode, rt.MaintenanceMessage)
+	}
+	s.featureFlags.SetConfig(featureFlags(rt.FeatureFlags))
+	if rt.SMSProvider != s.runtime.SMSProvider && !s.fixedSender {
+		s.otpSender.Swap(newOTPSender(rt.SMSProvider, s.cfg.Env))
+	}
+	s.runtime = rt
+}
+
+// Shutdown drains the in-flight requests, webhook deliveries, events and exports until ctx is done, then
+// releases the stores. It returns the errors of whatever didn't shut down cleanly.
+func (s *Server) Shutdown(ctx context.Context) error {
+	// Open OTP status streams would otherwise keep their requests running until the OTPs expire.
+	s.otpDeliveries.Close()
+
+	var errs []error
+	for _, l := range []struct {
+		name string
+		srv  *http.Server
+	}{{"server", s.srv}, {"admin server", s.adminSrv}, {"HTTP redirect server", s.httpSrv}} {
+		if l.srv == nil {
+			continue
+		}
+		if err := l.srv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s did not shut down cleanly: %w", l.name, err))
+		}
+	}
+
+	// Queued OTPs are sent before the events of their sends are delivered. Pending re-sends
+	// are dropped.
+	if s.otpScheduler != nil {
+		s.otpScheduler.Stop()
+	}
+	if s.otpDispatcher != nil {
+		if err := s.otpDispatcher.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("OTP dispatcher did not shut down cleanly: %w", err))
+		}
+	}
+
+	// Requests are done, so no new events, rate limit checks or exports can arrive from here on,
+	// but for the purger's, which stops first. The outbox goes next, so its last events are
+	// delivered with the others.
+	if s.purger != nil {
+		s.purger.Stop()
+	}
+	if s.outboxRelay != nil {
+		s.outboxRelay.Stop()
+	}
+	if err := s.webhookDispatcher.Close(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("webhook dispatcher did not shut down cleanly: %w", err))
+	}
+	if s.eventBus != nil {
+		if err := s.eventBus.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("event bus publisher did not shut down cleanly: %w", err))
+		}
+	}
+	if err := s.exportService.Close(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("export service did not shut down cleanly: %w", err))
+	}
+	if err := s.close(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// close stops the background work and closes the store connections opened so far.
+func (s *Server) close() error {
+	var errs []error
+	for _, limiter := range []*middleware.ReloadableRateLimiter{s.otpRateLimiter, s.otpVerifyRateLimiter, s.ipRateLimiter} {
+		if limiter != nil {
+			limiter.Stop()
+		}
+	}
+	for _, limiter := range s.otpChannelLimiters {
+		limiter.Stop()
+	}
+	if s.anomalyDetector != nil {
+		s.anomalyDetector.Stop()
+	}
+	if s.flagPoller != nil {
+		s.flagPoller.Stop()
+	}
+	if s.otpScheduler != nil {
+		s.otpScheduler.Stop()
+	}
+	if s.purger != nil {
+		s.purger.Stop()
+	}
+	if s.outboxRelay != nil {
+		s.outboxRelay.Stop()
+	}
+
+	if s.snapshotter != nil {
+		if err := s.snapshotter.Stop(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to save in-memory snapshot: %w", err))
+		}
+	}
+	if s.redisClient != nil {
+		if err := s.redisClient.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close redis client: %w", err))
+		}
+	}
+	if s.postgresStore != nil {
+		if err := s.postgresStore.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close postgres database: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL over HTTPS, so OTPs are never
+// submitted in cleartext. The HTTPS port is assumed to be the default one (443).
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
+
+// newOTPSender builds the sender of the SMS provider. Only the console sender exists so far;
+// config validation rejects other providers. It only echoes the codes in development.
+func newOTPSender(provider, env string) otp.Sender {
+	return otp.NewConsoleSender(env == config.EnvDev)
+}
+
+// newRateLimiter builds the limiter described by the policy. When a Redis client is given the
+// limiter state lives in Redis and is shared by all replicas; otherwise it is kept in memory.
+// NOTE: We use the middleware's in-memory rate limiters, not the one from the database package,
+// as they contain the cleanup logic.
+func newRateLimiter(redisClient *redis.Client, prefix string, rl config.RateLimit, cleanupInterval time.Duration) middleware.RateLimiterStore {
+	limit := rl.Max
+	var limiter middleware.RateLimiterStore
+	switch {
+	case redisClient != nil && rl.Algorithm == middleware.AlgorithmTokenBucket:
+		limit = rl.Burst
+		limiter = middleware.NewRedisTokenBucketRateLimiter(redisClient, prefix, rl.Burst, rl.Max, rl.Window)
+	case redisClient != nil:
+		limiter = middleware.NewRedisRateLimiter(redisClient, prefix, rl.Max, rl.Window)
+	case rl.Algorithm == middleware.AlgorithmTokenBucket:
+		limit = rl.Burst
+		limiter = middleware.NewTokenBucketRateLimiter(rl.Burst, rl.Max, rl.Window, cleanupInterval)
+	default:
+		limiter = middleware.NewInMemoryRateLimiter(rl.Max, rl.Window, cleanupInterval)
+	}
+
+	if rl.MaxPenalty <= 0 {
+		return limiter
+	}
+
+	// Escalate the block each time the limit is exhausted.
+	var box middleware.PenaltyBox
+	if redisClient != nil {
+		box = middleware.NewRedisPenaltyBox(redisClient, prefix+"penalty:")
+	} else {
+		box = middleware.NewInMemoryPenaltyBox(cleanupInterval)
+	}
+	return middleware.NewPenaltyRateLimiter(limiter, box, limit, rl.Window, rl.MaxPenalty)
+}
+
+// authBodyLimits applies the body size limit of the sign-in endpoints to their routes.
+// /graphql is one of them: anyone can send and verify OTPs through its mutations.
+func authBodyLimits(base string, limit int64) map[string]int64 {
+	limits := make(map[string]int64)
+	for _, prefix := range []string{"/otp", "/oauth", "/auth", "/authorize", "/token", "/graphql"} {
+		limits[base+prefix] = limit
+	}
+	return limits
+}
+
+// prefixed puts the base path before the path prefixes of a per-route setting.
+func prefixed[V any](base string, byPrefix map[string]V) map[string]V {
+	if base == "" {
+		return byPrefix
+	}
+	out := make(map[string]V, len(byPrefix))
+	for prefix, v := range byPrefix {
+		out[base+prefix] = v
+	}
+	return out
+}
+
+// featureFlags converts the configured feature flags.
+func featureFlags(configured map[string]config.FeatureFlag) []featureflag.Flag {
+	flags := make([]featureflag.Flag, 0, len(configured))
+	for name, flag := range configured {
+		flags = append(flags, featureflag.Flag{Name: name, Enabled: flag.Enabled, Percentage: flag.Percentage, Tenants: flag.Tenants})
+	}
+	return flags
+}
+
+// tenantBranding collects the sender IDs and message prefixes configured for tenants.
+func tenantBranding(cfg *config.Config) map[string]otp.Branding {
+	brands := make(map[string]otp.Branding)
+	for tenant, senderID := range cfg.SMSTenantSenderIDs {
+		brand := brands[tenant]
+		brand.SenderID = senderID
+		brands[tenant] = brand
+	}
+	for tenant, prefix := range cfg.SMSTenantMessagePrefixes {
+		brand := brands[tenant]
+		brand.MessagePrefix = prefix
+		brands[tenant] = brand
+	}
+	return brands
+}
@@ -0,0 +1,196 @@
+// Package servertest runs the whole service in-process for end-to-end tests, like
+// net/http/httptest does for single handlers:
+//
+//	srv := servertest.NewServer(t)
+//	token, err := srv.Login(ctx, "+14155552671")
+//	user, err := srv.Client.Me(ctx, token)
+package servertest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/config"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/pkg/authtest"
+	"github.com/ebipenman/go-otp-auth-service/pkg/server"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWTSecret signs the tokens of servers started with the default Config.
+const JWTSecret = "servertest-jwt-secret-0123456789abcdef"
+
+// Server is the service listening on a random local port, with in-memory storage and the
+// OTPs captured by Sender instead of being delivered.
+type Server struct {
+	// URL is the base URL of the server, e.g. http://127.0.0.1:54321.
+	URL    string
+	Client *Client
+	Sender *authtest.Sender
+	Config *config.Config
+}
+
+// Config returns the configuration NewServer uses: in-memory storage and rate limiting,
+// limits high enough not to get in the way, and no optional integrations.
+func Config() *config.Config {
+	limit := config.RateLimit{Max: 1000, Window: time.Minute}
+	return &config.Config{
+		Env:                  config.EnvDev,
+		JWTSecret:            JWTSecret,
+		JWTSecrets:           []string{JWTSecret},
+		OTPExpirationMinutes: 2,
+		StorageType:          "inmemory",
+		RateLimitBackend:     "inmemory",
+		OTPSendRateLimit:     limit,
+		OTPVerifyRateLimit:   limit,
+		IPRateLimit:          limit,
+		ServiceName:          "go-otp-auth-service-test",
+		ShutdownTimeout:      5 * time.Second,
+	}
+}
+
+// NewServer starts the service with Config. opts are applied after the defaults of the
+// test server, so they can replace them. It's shut down when the test finishes.
+func NewServer(tb testing.TB, opts ...server.Option) *Server {
+	tb.Helper()
+	return NewServerWithConfig(tb, Config(), opts...)
+}
+
+// NewServerWithConfig is NewServer with a custom configuration, usually Config adjusted.
+// Storage and rate limiting should stay in memory unless the test provides them.
+func NewServerWithConfig(tb testing.TB, cfg *config.Config, opts ...server.Option) *Server {
+	tb.Helper()
+	gin.SetMode(gin.TestMode)
+
+	sender := authtest.NewSender()
+	opts = append([]server.Option{
+		server.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		server.WithOTPSender(sender),
+	}, opts...)
+	srv, err := server.New(cfg, opts...)
+	if err != nil {
+		tb.Fatalf("servertest: could not build the service: %v", err)
+	}
+
+	httpSrv := httptest.NewServer(srv.Handler())
+	tb.Cleanup(func() {
+		httpSrv.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			tb.Errorf("servertest: service did not shut down cleanly: %v", err)
+		}
+	})
+
+	return &Server{
+		URL:    httpSrv.URL,
+		Client: &Client{BaseURL: httpSrv.URL, HTTPClient: httpSrv.Client()},
+		Sender: sender,
+		Config: cfg,
+	}
+}
+
+// Login signs the phone number in the way a user would, sending an OTP and verifying the
+// code it received, and returns the JWT. The phone number must be in E.164 format.
+func (s *Server) Login(ctx context.Context, phoneNumber string) (string, error) {
+	if err := s.Client.SendOTP(ctx, phoneNumber); err != nil {
+		return "", err
+	}
+	code, ok := s.Sender.LastCode(phoneNumber)
+	if !ok {
+		return "", fmt.Errorf("servertest: no OTP was sent to %s", phoneNumber)
+	}
+	return s.Client.VerifyOTP(ctx, phoneNumber, code)
+}
+
+// Client calls the service's REST API.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// APIError is an error response of the service.
+type APIError struct {
+	StatusCode int
+	Code       string `json:"code"`
+	Message    string `json:"error"`
+	RequestID  string `json:"request_id"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// SendOTP requests an OTP for the phone number.
+func (c *Client) SendOTP(ctx context.Context, phoneNumber string) error {
+	return c.do(ctx, http.MethodPost, "/otp/send", "", map[string]string{"phone_number": phoneNumber}, nil)
+}
+
+// VerifyOTP verifies the code sent to the phone number and returns the JWT.
+func (c *Client) VerifyOTP(ctx context.Context, phoneNumber, code string) (string, error) {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	err := c.do(ctx, http.MethodPost, "/otp/verify", "", map[string]string{"phone_number": phoneNumber, "otp": code}, &resp)
+	return resp.Token, err
+}
+
+// Me returns the user the token was issued to.
+func (c *Client) Me(ctx context.Context, token string) (model.User, error) {
+	var user model.User
+	err := c.do(ctx, http.MethodGet, "/me", token, nil, &user)
+	return user, err
+}
+
+// do sends the request and decodes the response into out, or returns an *APIError.
+func (c *Client) do(ctx context.Context, method, path, token string, body, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(apiErr); err != nil {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,114 @@
+// Package simswap asks the carrier whether a phone number's SIM card was recently swapped,
+// a common step in account takeovers, before an OTP is sent to it.
+package simswap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/pkg/auth"
+)
+
+// Checker is an auth.RiskEvaluator querying a CAMARA SIM Swap API
+// (POST {baseURL}/check with the phone number and maxAge in hours).
+// OTP sends to numbers whose SIM changed within maxAge get onSwap, either auth.RiskChallenge
+// (require a secondary verification channel) or auth.RiskDeny. Every check is published
+// as a security.sim_swap_checked event for the audit trail.
+type Checker struct {
+	baseURL string
+	token   string
+	maxAge  time.Duration
+	onSwap  auth.RiskDecision
+	events  auth.EventPublisher
+	client  *http.Client
+}
+
+// NewChecker creates a Checker. token is sent as bearer token; leave it empty if the API
+// doesn't need one.
+func NewChecker(baseURL, token string, maxAge time.Duration, onSwap auth.RiskDecision, events auth.EventPublisher) *Checker {
+	return &Checker{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		maxAge:  maxAge,
+		onSwap:  onSwap,
+		events:  events,
+		client:  &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Evaluate checks the SIM before an OTP is sent; verifications are allowed, since the
+// OTP they check already passed the check.
+func (c *Checker) Evaluate(ctx context.Context, assessment auth.RiskAssessment) (auth.RiskDecision, error) {
+	if assessment.Action != auth.RiskActionSendOTP {
+		return auth.RiskAllow, nil
+	}
+
+	swapped, err := c.swapped(ctx, assessment.PhoneNumber)
+	data := map[string]interface{}{
+		"phone_number": assessment.PhoneNumber,
+		"ip":           assessment.Client.IP,
+		"max_age":      c.maxAge.String(),
+	}
+	if err != nil {
+		data["error"] = err.Error()
+		c.events.Publish(model.NewEvent(model.EventSIMSwapChecked, data))
+		return auth.RiskAllow, fmt.Errorf("sim swap check failed: %w", err)
+	}
+
+	decision := auth.RiskAllow
+	if swapped {
+		decision = c.onSwap
+		logging.FromContext(ctx).Warn("Recent SIM swap detected", "phone_number", assessment.PhoneNumber, "ip", assessment.Client.IP)
+	}
+	data["swapped"] = swapped
+	data["decision"] = decision.String()
+	c.events.Publish(model.NewEvent(model.EventSIMSwapChecked, data))
+	return decision, nil
+}
+
+// swapped asks the carrier whether the SIM of phoneNumber changed within maxAge.
+func (c *Checker) swapped(ctx context.Context, phoneNumber string) (bool, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"phoneNumber": phoneNumber,
+		"maxAge":      max(int(c.maxAge.Hours()), 1),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/check", bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return false, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		Swapped bool `json:"swapped"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return result.Swapped, nil
+}
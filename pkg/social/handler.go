@@ -0,0 +1,106 @@
+package social
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	socialService Service
+}
+
+func NewHandler(socialService Service) *Handler {
+	return &Handler{socialService: socialService}
+}
+
+type signInRequest struct {
+	IDToken string `json:"id_token" binding:"required"`
+	// Nonce is the nonce from /auth/social/{provider}/nonce the ID token was requested with.
+	Nonce string `json:"nonce" binding:"required"`
+}
+
+var _ = openapi.Describe((*Handler).Nonce, openapi.Operation{
+	Summary: "Start a sign-in with Google or Apple",
+	Description: "Issues a single-use nonce, valid for ten minutes, to pass to the provider's sign-in. " +
+		"The ID token must carry it, or its hex SHA-256 hash as with Apple's native sign-in, " +
+		"and it must be sent along with the ID token to /auth/social/{provider}.",
+	Tags: []string{"Authentication"},
+	Params: []openapi.Param{
+		{Name: "provider", In: openapi.InPath, Description: "google or apple"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Description: "nonce: <nonce>, expires_at: <RFC 3339 time>", Type: map[string]string{}},
+		http.StatusNotFound:            {Description: "Provider not configured"},
+		http.StatusTooManyRequests:     {Description: "Too many requests from this IP"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) Nonce(c *gin.Context) {
+	nonce, expiresAt, err := h.socialService.Nonce(c.Request.Context(), c.Param("provider"))
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to issue %s nonce: %w", c.Param("provider"), err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"nonce": nonce, "expires_at": expiresAt})
+}
+
+var _ = openapi.Describe((*Handler).SignIn, openapi.Operation{
+	Summary: "Sign in with Google or Apple",
+	Description: "Verifies the provider's ID token and returns the same JWT as /otp/verify. The token must " +
+		"have been requested with a nonce from /auth/social/{provider}/nonce, sent along as nonce. " +
+		"An account that isn't linked yet is linked to the user of the Bearer token if one is sent, " +
+		"else to the owner of a verified phone number in the ID token (registering them if needed), " +
+		"else to the user whose account at the other provider has the same verified email. " +
+		"Otherwise the request fails with account_not_linked: sign in with the OTP once and repeat " +
+		"the request with the JWT to link the account.",
+	Tags:     []string{"Authentication"},
+	Security: []string{openapi.BearerAuth},
+	Params: []openapi.Param{
+		{Name: "provider", In: openapi.InPath, Description: "google or apple"},
+		{Name: "X-Device-ID", In: openapi.InHeader, Description: "Stable identifier of the app installation, used to recognize the device"},
+	},
+	Body: &openapi.Body{Description: "ID token and nonce", Required: true, Type: signInRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Description: "token: <jwt_token>", Type: map[string]string{}},
+		http.StatusBadRequest:          {Description: "Invalid request format"},
+		http.StatusUnauthorized:        {Description: "Invalid ID token, or unknown, used or expired nonce"},
+		http.StatusNotFound:            {Description: "Provider not configured"},
+		http.StatusConflict:            {Description: "Account not linked to a user"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) SignIn(c *gin.Context) {
+	var req signInRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithDetails(c, apperr.ErrInvalidRequest.Wrap(err), err.Error())
+		return
+	}
+
+	var linkTo *model.User
+	if value, exists := c.Get(middleware.ContextKeyUser); exists {
+		if user, ok := value.(model.User); ok {
+			linkTo = &user
+		}
+	}
+	client := model.ClientInfo{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		DeviceID:  c.GetHeader("X-Device-ID"),
+	}
+
+	token, err := h.socialService.SignIn(c.Request.Context(), c.Param("provider"), req.IDToken, req.Nonce, linkTo, client)
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("%s login failed: %w", c.Param("provider"), err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
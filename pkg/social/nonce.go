@@ -0,0 +1,84 @@
+package social
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NonceTTL is how long a nonce can be used to sign in after it was issued.
+const NonceTTL = 10 * time.Minute
+
+// NonceStore keeps the nonces issued for sign-ins until they are used once or expire, so a
+// captured ID token can't be replayed.
+type NonceStore interface {
+	// AddNonce stores a new nonce of the provider, usable until it expires.
+	AddNonce(ctx context.Context, provider, nonce string, expiresAt time.Time) error
+	// ConsumeNonce removes the nonce of the provider and reports whether it was still usable.
+	ConsumeNonce(ctx context.Context, provider, nonce string) (bool, error)
+}
+
+// InMemoryNonceStore implements NonceStore for a single instance.
+type InMemoryNonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+func NewInMemoryNonceStore() *InMemoryNonceStore {
+	return &InMemoryNonceStore{nonces: make(map[string]time.Time)}
+}
+
+func (m *InMemoryNonceStore) AddNonce(ctx context.Context, provider, nonce string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Nonces are added once per sign-in, so pruning the expired ones here is cheap enough.
+	now := time.Now()
+	for key, exp := range m.nonces {
+		if now.After(exp) {
+			delete(m.nonces, key)
+		}
+	}
+	m.nonces[provider+":"+nonce] = expiresAt
+	return nil
+}
+
+func (m *InMemoryNonceStore) ConsumeNonce(ctx context.Context, provider, nonce string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := provider + ":" + nonce
+	expiresAt, ok := m.nonces[key]
+	delete(m.nonces, key)
+	return ok && time.Now().Before(expiresAt), nil
+}
+
+// RedisNonceStore implements NonceStore on top of Redis, so a nonce issued by one replica can
+// be used at another.
+type RedisNonceStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisNonceStore creates a nonce store under prefix.
+func NewRedisNonceStore(client *redis.Client, prefix string) *RedisNonceStore {
+	return &RedisNonceStore{client: client, prefix: prefix}
+}
+
+func (r *RedisNonceStore) AddNonce(ctx context.Context, provider, nonce string, expiresAt time.Time) error {
+	if err := r.client.Set(ctx, r.prefix+provider+":"+nonce, 1, time.Until(expiresAt)).Err(); err != nil {
+		return fmt.Errorf("failed to store nonce: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisNonceStore) ConsumeNonce(ctx context.Context, provider, nonce string) (bool, error) {
+	// DEL is atomic, so of two sign-ins with the same nonce only one deletes it.
+	deleted, err := r.client.Del(ctx, r.prefix+provider+":"+nonce).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to consume nonce: %w", err)
+	}
+	return deleted == 1, nil
+}
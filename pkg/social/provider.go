@@ -0,0 +1,192 @@
+package social
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// keyRefreshInterval limits how often unknown key IDs make a provider fetch its keys again,
+// so tokens with made-up key IDs can't be used to hammer the provider.
+const keyRefreshInterval = time.Minute
+
+// Claims is what a verified ID token says about the account.
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	PhoneNumber   string
+	PhoneVerified bool
+	// Nonce is the value of the authentication request the token answers, if it had one.
+	Nonce string
+}
+
+// Provider verifies the ID tokens of a social login provider against its published keys.
+type Provider struct {
+	name      string
+	issuers   []string
+	jwksURL   string
+	clientIDs []string
+	client    *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// NewProvider creates a provider accepting RS256 ID tokens of one of the issuers, signed with a
+// key from jwksURL and issued for one of the client IDs.
+func NewProvider(name string, issuers []string, jwksURL string, clientIDs []string) *Provider {
+	return &Provider{
+		name:      name,
+		issuers:   issuers,
+		jwksURL:   jwksURL,
+		clientIDs: clientIDs,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		keys:      make(map[string]*rsa.PublicKey),
+	}
+}
+
+// Google accepts Google Sign-In ID tokens issued for the client IDs.
+func Google(clientIDs []string) *Provider {
+	return NewProvider("google", []string{"https://accounts.google.com", "accounts.google.com"},
+		"https://www.googleapis.com/oauth2/v3/certs", clientIDs)
+}
+
+// Apple accepts Sign in with Apple ID tokens issued for the bundle or Services IDs.
+func Apple(clientIDs []string) *Provider {
+	return NewProvider("apple", []string{"https://appleid.apple.com"},
+		"https://appleid.apple.com/auth/keys", clientIDs)
+}
+
+// Name identifies the provider in URLs, identities and events.
+func (p *Provider) Name() string {
+	return p.name
+}
+
+// Verify checks the ID token's signature, issuer, audience and expiry and returns its claims.
+func (p *Provider) Verify(ctx context.Context, idToken string) (Claims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	iss, _ := claims.GetIssuer()
+	if !slices.Contains(p.issuers, iss) {
+		return Claims{}, fmt.Errorf("unexpected issuer %q", iss)
+	}
+	aud, _ := claims.GetAudience()
+	if !slices.ContainsFunc(aud, func(a string) bool { return slices.Contains(p.clientIDs, a) }) {
+		return Claims{}, errors.New("token not issued for a configured client ID")
+	}
+	sub, _ := claims.GetSubject()
+	if sub == "" {
+		return Claims{}, errors.New("token has no subject")
+	}
+
+	email, _ := claims["email"].(string)
+	phoneNumber, _ := claims["phone_number"].(string)
+	nonce, _ := claims["nonce"].(string)
+	return Claims{
+		Subject:       sub,
+		Email:         email,
+		EmailVerified: email != "" && isTrue(claims["email_verified"]),
+		PhoneNumber:   phoneNumber,
+		PhoneVerified: phoneNumber != "" && isTrue(claims["phone_number_verified"]),
+		Nonce:         nonce,
+	}, nil
+}
+
+// key returns the public key with the ID, fetching the provider's keys when it's unknown,
+// as providers rotate them regularly.
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.fetchedAt) < keyRefreshInterval {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	p.fetchedAt = time.Now()
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s keys: %w", p.name, err)
+	}
+	p.keys = keys
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key ID %q", kid)
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+// isTrue reads a boolean claim, which Apple sends as a string.
+func isTrue(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
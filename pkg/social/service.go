@@ -0,0 +1,192 @@
+// Package social signs users in with a Google or Apple ID token, as a fallback when SMS
+// delivery is unreliable. Users stay identified by their phone number: a provider account is
+// linked to one once, and later sign-ins with it issue the same JWTs as the OTP flow.
+package social
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/database"
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/logging"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/tracing"
+	"github.com/ebipenman/go-otp-auth-service/pkg/auth"
+)
+
+var (
+	ErrUnknownProvider = apperr.New(http.StatusNotFound, i18n.CodeUnknownProvider)
+	ErrInvalidIDToken  = apperr.New(http.StatusUnauthorized, i18n.CodeInvalidIDToken)
+	// ErrNotLinked means the account can't be matched to a user. The user has to sign in with
+	// their phone number and send the ID token along once to link it.
+	ErrNotLinked = apperr.New(http.StatusConflict, i18n.CodeAccountNotLinked)
+)
+
+// Service defines the business logic for social login.
+type Service interface {
+	// Nonce issues a single-use nonce for a sign-in with the provider, which the app passes to
+	// the provider's sign-in and then to SignIn along with the ID token.
+	Nonce(ctx context.Context, provider string) (nonce string, expiresAt time.Time, err error)
+	// SignIn verifies the provider's ID token, which must carry the nonce or, as Apple's native
+	// sign-in sends it, its hex SHA-256 hash, and returns the JWT of the user the account
+	// belongs to. An account that isn't linked yet is linked to, in this order, the signed-in
+	// user (linkTo, nil if the request carried no JWT), the owner of the verified phone number
+	// in the token, registering them if needed, or the user whose account at another provider
+	// has the same verified email address.
+	SignIn(ctx context.Context, provider, idToken, nonce string, linkTo *model.User, client model.ClientInfo) (string, error)
+}
+
+type socialService struct {
+	repo        Repository
+	authService auth.Service
+	nonces      NonceStore
+	providers   map[string]*Provider
+}
+
+func NewService(repo Repository, authService auth.Service, nonces NonceStore, providers ...*Provider) Service {
+	s := &socialService{repo: repo, authService: authService, nonces: nonces, providers: make(map[string]*Provider)}
+	for _, p := range providers {
+		s.providers[p.Name()] = p
+	}
+	return s
+}
+
+func (s *socialService) Nonce(ctx context.Context, providerName string) (string, time.Time, error) {
+	if _, ok := s.providers[providerName]; !ok {
+		return "", time.Time{}, ErrUnknownProvider
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(b)
+	expiresAt := time.Now().Add(NonceTTL)
+	if err := s.nonces.AddNonce(ctx, providerName, nonce, expiresAt); err != nil {
+		return "", time.Time{}, err
+	}
+	return nonce, expiresAt, nil
+}
+
+func (s *socialService) SignIn(ctx context.Context, providerName, idToken, nonce string, linkTo *model.User, client model.ClientInfo) (token string, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "social.SignIn")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	logger := logging.FromContext(ctx)
+
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return "", ErrUnknownProvider
+	}
+	claims, err := provider.Verify(ctx, idToken)
+	if err != nil {
+		logger.Info("Rejected ID token", "provider", providerName, "error", err)
+		return "", ErrInvalidIDToken
+	}
+	// Without the nonce a captured ID token could be replayed until it expires.
+	if !nonceMatches(claims.Nonce, nonce) {
+		logger.Info("Rejected ID token", "provider", providerName, "error", "nonce mismatch")
+		return "", ErrInvalidIDToken
+	}
+	if ok, err := s.nonces.ConsumeNonce(ctx, providerName, nonce); err != nil {
+		return "", err
+	} else if !ok {
+		logger.Info("Rejected ID token", "provider", providerName, "error", "unknown, used or expired nonce")
+		return "", ErrInvalidIDToken
+	}
+
+	identity, err := s.repo.GetIdentity(ctx, providerName, claims.Subject)
+	if err == nil {
+		user, err := s.repo.GetUserByID(ctx, identity.UserID)
+		if err == nil {
+			token, _, err := s.authService.SignInVerified(ctx, user.PhoneNumber, providerName, client)
+			return token, err
+		}
+		if !errors.Is(err, errNotFound) {
+			return "", fmt.Errorf("failed to get linked user: %w", err)
+		}
+		// The linked user deleted their account, so the account signs in as if it was never linked.
+		if err := s.unlink(ctx, identity); err != nil {
+			return "", err
+		}
+	} else if !errors.Is(err, errNotFound) {
+		return "", err
+	}
+
+	var user model.User
+	switch {
+	case linkTo != nil:
+		user = *linkTo
+		token, _, err = s.authService.SignInVerified(ctx, user.PhoneNumber, providerName, client)
+	case claims.PhoneVerified:
+		token, user, err = s.authService.SignInVerified(ctx, claims.PhoneNumber, providerName, client)
+	case claims.EmailVerified:
+		var byEmail model.Identity
+		byEmail, err = s.repo.GetIdentityByEmail(ctx, claims.Email)
+		if errors.Is(err, errNotFound) {
+			return "", ErrNotLinked
+		}
+		if err != nil {
+			return "", err
+		}
+		if user, err = s.repo.GetUserByID(ctx, byEmail.UserID); errors.Is(err, errNotFound) {
+			if err := s.unlink(ctx, byEmail); err != nil {
+				return "", err
+			}
+			return "", ErrNotLinked
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to get linked user: %w", err)
+		}
+		token, _, err = s.authService.SignInVerified(ctx, user.PhoneNumber, providerName, client)
+	default:
+		return "", ErrNotLinked
+	}
+	if err != nil {
+		return "", err
+	}
+
+	link := model.Identity{Provider: providerName, Subject: claims.Subject, UserID: user.ID}
+	if claims.EmailVerified {
+		link.Email = claims.Email
+	}
+	// A concurrent sign-in with the same account may have linked it first.
+	if _, err := s.repo.CreateIdentity(ctx, link); err != nil && !errors.Is(err, database.ErrAlreadyExists) {
+		return "", fmt.Errorf("failed to link account: %w", err)
+	}
+	logger.Info("Linked social login account", "provider", providerName, "user_id", user.ID)
+	return token, nil
+}
+
+// unlink removes an identity left behind by a deleted user.
+func (s *socialService) unlink(ctx context.Context, identity model.Identity) error {
+	if err := s.repo.DeleteIdentity(ctx, identity.Provider, identity.Subject); err != nil {
+		return fmt.Errorf("failed to unlink account of deleted user: %w", err)
+	}
+	logging.FromContext(ctx).Info("Unlinked social login account of deleted user",
+		"provider", identity.Provider, "user_id", identity.UserID)
+	return nil
+}
+
+// nonceMatches reports whether the nonce of an ID token is the issued nonce or its hex SHA-256
+// hash.
+func nonceMatches(tokenNonce, nonce string) bool {
+	if tokenNonce == "" || nonce == "" {
+		return false
+	}
+	hash := sha256.Sum256([]byte(nonce))
+	return subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) == 1 ||
+		subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(hex.EncodeToString(hash[:]))) == 1
+}
@@ -0,0 +1,78 @@
+package social
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/database"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/pkg/user"
+
+	"github.com/google/uuid"
+)
+
+var errNotFound = errors.New("not found")
+
+// Repository defines the interface for the data operations of social login.
+type Repository interface {
+	CreateIdentity(ctx context.Context, identity model.Identity) (model.Identity, error)
+	GetIdentity(ctx context.Context, provider, subject string) (model.Identity, error)
+	GetIdentityByEmail(ctx context.Context, email string) (model.Identity, error)
+	DeleteIdentity(ctx context.Context, provider, subject string) error
+	GetUserByID(ctx context.Context, id uuid.UUID) (model.User, error)
+}
+
+type socialRepository struct {
+	store    IdentityStore
+	userRepo user.Repository
+}
+
+func NewRepository(store IdentityStore, userRepo user.Repository) Repository {
+	return &socialRepository{store: store, userRepo: userRepo}
+}
+
+func (r *socialRepository) CreateIdentity(ctx context.Context, identity model.Identity) (model.Identity, error) {
+	return r.store.CreateIdentity(ctx, identity)
+}
+
+func (r *socialRepository) GetIdentity(ctx context.Context, provider, subject string) (model.Identity, error) {
+	identity, err := r.store.GetIdentity(ctx, provider, subject)
+	if errors.Is(err, database.ErrNotFound) {
+		return model.Identity{}, errNotFound
+	}
+	return identity, err
+}
+
+func (r *socialRepository) GetIdentityByEmail(ctx context.Context, email string) (model.Identity, error) {
+	identity, err := r.store.GetIdentityByEmail(ctx, email)
+	if errors.Is(err, database.ErrNotFound) {
+		return model.Identity{}, errNotFound
+	}
+	return identity, err
+}
+
+func (r *socialRepository) DeleteIdentity(ctx context.Context, provider, subject string) error {
+	return r.store.DeleteIdentity(ctx, provider, subject)
+}
+
+func (r *socialRepository) GetUserByID(ctx context.Context, id uuid.UUID) (model.User, error) {
+	u, err := r.userRepo.GetUserByID(ctx, id)
+	if errors.Is(err, database.ErrNotFound) {
+		return model.User{}, errNotFound
+	}
+	return u, err
+}
+
+// IdentityStore is the interface that the database implementation must satisfy.
+type IdentityStore interface {
+	CreateIdentity(ctx context.Context, identity model.Identity) (model.Identity, error)
+	GetIdentity(ctx context.Context, provider, subject string) (model.Identity, error)
+	// GetIdentityByEmail returns the oldest identity with the verified email address.
+	GetIdentityByEmail(ctx context.Context, email string) (model.Identity, error)
+	// ListIdentities returns the accounts linked to the user, oldest first.
+	ListIdentities(ctx context.Context, userID uuid.UUID) ([]model.Identity, error)
+	// DeleteIdentity unlinks the account; unknown accounts are no error.
+	DeleteIdentity(ctx context.Context, provider, subject string) error
+	// MoveIdentities links the accounts of one user to another.
+	MoveIdentities(ctx context.Context, fromUserID, toUserID uuid.UUID) error
+}
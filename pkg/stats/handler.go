@@ -0,0 +1,98 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	statsService Service
+}
+
+func NewHandler(statsService Service) *Handler {
+	return &Handler{statsService: statsService}
+}
+
+var _ = openapi.Describe((*Handler).GetStats, openapi.Operation{
+	Summary: "Get usage statistics",
+	Description: "Reports the total number of users, the users registered per day, the active OTPs, the verification " +
+		"success rate and the rate limit rejections of OTP sends and verifications over the last days (UTC), " +
+		"today included. Users deleted since count as new users of their day.",
+	Tags:     []string{"Admin"},
+	Security: []string{openapi.APIKeyAuth},
+	Params: []openapi.Param{
+		{Name: "days", In: openapi.InQuery, Description: "Window in days (default 7, at most 90)", Type: "integer", Default: 7},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Type: Report{}},
+		http.StatusBadRequest:          {Description: "Invalid window"},
+		http.StatusUnauthorized:        {Description: "API key required"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) GetStats(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", strconv.Itoa(DefaultDays)))
+	if err != nil || days < 1 || days > MaxDays {
+		middleware.Abort(c, ErrInvalidWindow)
+		return
+	}
+
+	report, err := h.statsService.Report(c.Request.Context(), days)
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to report statistics of %d days: %w", days, err))
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+var _ = openapi.Describe((*Handler).GetUserGrowth, openapi.Operation{
+	Summary: "Get user growth",
+	Description: "Reports the users registered on every day (UTC) of a range and the number of users at its end, " +
+		"counted by the database. Users deleted since count as signups of their day. The range defaults to the " +
+		"last 30 days, today included.",
+	Tags:     []string{"Admin"},
+	Security: []string{openapi.APIKeyAuth},
+	Params: []openapi.Param{
+		{Name: "from", In: openapi.InQuery, Description: "First day, YYYY-MM-DD (default 29 days before to)"},
+		{Name: "to", In: openapi.InQuery, Description: "Last day, YYYY-MM-DD (default today)"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Type: UserGrowth{}},
+		http.StatusBadRequest:          {Description: "Invalid date range"},
+		http.StatusUnauthorized:        {Description: "API key required"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) GetUserGrowth(c *gin.Context) {
+	from, fromErr := parseDate(c.Query("from"))
+	to, toErr := parseDate(c.Query("to"))
+	if err := errors.Join(fromErr, toErr); err != nil {
+		middleware.Abort(c, ErrInvalidDateRange.Wrap(err))
+		return
+	}
+
+	growth, err := h.statsService.UserGrowth(c.Request.Context(), from, to)
+	if err != nil {
+		middleware.Abort(c, fmt.Errorf("failed to report user growth from %q to %q: %w", c.Query("from"), c.Query("to"), err))
+		return
+	}
+	c.JSON(http.StatusOK, growth)
+}
+
+// parseDate parses a YYYY-MM-DD query parameter, the zero time if it's empty.
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
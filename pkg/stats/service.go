@@ -0,0 +1,215 @@
+// Package stats reports usage statistics to administrators: users, OTPs, verification
+// outcomes and rate limit rejections over a window of days.
+package stats
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/tracing"
+)
+
+const (
+	// DefaultDays is the window of the report unless another is asked for.
+	DefaultDays = 7
+	// MaxDays is the longest window of a report.
+	MaxDays = 90
+	// DefaultGrowthDays is the range of the user growth unless another is asked for.
+	DefaultGrowthDays = 30
+	// MaxGrowthDays is the longest range of the user growth.
+	MaxGrowthDays = 366
+)
+
+var (
+	ErrInvalidWindow    = apperr.New(http.StatusBadRequest, i18n.CodeInvalidStatsWindow).WithParams(i18n.Params{"max": MaxDays})
+	ErrInvalidDateRange = apperr.New(http.StatusBadRequest, i18n.CodeInvalidDateRange).WithParams(i18n.Params{"max": MaxGrowthDays})
+)
+
+// Service defines the business logic for the statistics.
+type Service interface {
+	// Count adds one to the named counter of today, one of the model.Counter* names.
+	Count(ctx context.Context, name string) error
+	// Report returns the statistics of the last days days, today included. It returns
+	// ErrInvalidWindow unless days is between 1 and MaxDays.
+	Report(ctx context.Context, days int) (Report, error)
+	// UserGrowth returns the signups and the number of users of every day from the day of from
+	// to that of to (UTC). A zero from or to stands for DefaultGrowthDays ago or today. It
+	// returns ErrInvalidDateRange for a range ending in the future, backwards or longer than
+	// MaxGrowthDays.
+	UserGrowth(ctx context.Context, from, to time.Time) (UserGrowth, error)
+}
+
+// Report holds the statistics of a window of days. Days are UTC days.
+type Report struct {
+	Days                int               `json:"days"`
+	Since               time.Time         `json:"since"`
+	Users               UserStats         `json:"users"`
+	ActiveOTPs          int64             `json:"active_otps"`
+	Verifications       VerificationStats `json:"verifications"`
+	RateLimitRejections RejectionStats    `json:"rate_limit_rejections"`
+}
+
+// UserStats counts the users. Total is the current number of users, New the ones registered
+// within the window, including the ones deleted since.
+type UserStats struct {
+	Total     int64            `json:"total"`
+	New       int64            `json:"new"`
+	NewPerDay []model.DayCount `json:"new_per_day"`
+}
+
+// UserGrowth holds the signups and the number of users per day of a range of days, both
+// included. Users deleted since count as signups of their day.
+type UserGrowth struct {
+	From    string                `json:"from"`
+	To      string                `json:"to"`
+	Signups int64                 `json:"signups"`
+	Days    []model.UserGrowthDay `json:"days"`
+}
+
+// VerificationStats counts the OTP verifications that got to check the code. SuccessRate is
+// nil without any.
+type VerificationStats struct {
+	Succeeded   int64    `json:"succeeded"`
+	Failed      int64    `json:"failed"`
+	SuccessRate *float64 `json:"success_rate"`
+}
+
+// RejectionStats counts the OTP sends and verifications refused by the rate limits, including
+// the blocks of the brute-force detection.
+type RejectionStats struct {
+	OTPSend   int64 `json:"otp_send"`
+	OTPVerify int64 `json:"otp_verify"`
+	Total     int64 `json:"total"`
+}
+
+type statsService struct {
+	repo Repository
+	now  func() time.Time
+}
+
+func NewService(repo Repository) Service {
+	return &statsService{repo: repo, now: time.Now}
+}
+
+func (s *statsService) Count(ctx context.Context, name string) error {
+	return s.repo.IncrementCounter(ctx, name, s.now())
+}
+
+func (s *statsService) Report(ctx context.Context, days int) (report Report, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "stats.Report")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	if days < 1 || days > MaxDays {
+		return Report{}, ErrInvalidWindow
+	}
+	today := s.now().UTC().Truncate(24 * time.Hour)
+	report = Report{Days: days, Since: today.AddDate(0, 0, 1-days)}
+
+	if report.Users.Total, err = s.repo.CountUsers(ctx); err != nil {
+		return Report{}, err
+	}
+	newPerDay, err := s.repo.CountNewUsersPerDay(ctx, report.Since)
+	if err != nil {
+		return Report{}, err
+	}
+	report.Users.NewPerDay, report.Users.New = fillDays(report.Since, days, newPerDay)
+
+	if report.ActiveOTPs, err = s.repo.CountActiveOTPs(ctx); err != nil {
+		return Report{}, err
+	}
+
+	counters, err := s.repo.SumCounters(ctx, report.Since)
+	if err != nil {
+		return Report{}, err
+	}
+	report.Verifications.Succeeded = counters[model.CounterVerificationSucceeded]
+	report.Verifications.Failed = counters[model.CounterVerificationFailed]
+	if attempts := report.Verifications.Succeeded + report.Verifications.Failed; attempts > 0 {
+		rate := float64(report.Verifications.Succeeded) / float64(attempts)
+		report.Verifications.SuccessRate = &rate
+	}
+	report.RateLimitRejections.OTPSend = counters[model.CounterOTPSendRateLimited]
+	report.RateLimitRejections.OTPVerify = counters[model.CounterOTPVerifyRateLimited]
+	report.RateLimitRejections.Total = report.RateLimitRejections.OTPSend + report.RateLimitRejections.OTPVerify
+	return report, nil
+}
+
+func (s *statsService) UserGrowth(ctx context.Context, from, to time.Time) (growth UserGrowth, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "stats.UserGrowth")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	today := s.now().UTC().Truncate(24 * time.Hour)
+	if to.IsZero() {
+		to = today
+	}
+	to = to.UTC().Truncate(24 * time.Hour)
+	if from.IsZero() {
+		from = to.AddDate(0, 0, 1-DefaultGrowthDays)
+	}
+	from = from.UTC().Truncate(24 * time.Hour)
+	days := int(to.Sub(from)/(24*time.Hour)) + 1
+	if to.After(today) || days < 1 || days > MaxGrowthDays {
+		return UserGrowth{}, ErrInvalidDateRange
+	}
+
+	counts, err := s.repo.CountUserGrowth(ctx, from, to)
+	if err != nil {
+		return UserGrowth{}, err
+	}
+	growth = UserGrowth{From: from.Format(time.DateOnly), To: to.Format(time.DateOnly)}
+	growth.Days, growth.Signups = fillGrowth(from, days, counts)
+	return growth, nil
+}
+
+// fillGrowth returns the growth of every day of the range, carrying the number of users over
+// the days counts leaves out, and the signups within the range.
+func fillGrowth(from time.Time, days int, counts []model.UserGrowthDay) ([]model.UserGrowthDay, int64) {
+	byDay := make(map[string]model.UserGrowthDay, len(counts))
+	var total int64
+	for _, c := range counts {
+		byDay[c.Date] = c
+	}
+	if before, ok := byDay[from.AddDate(0, 0, -1).Format(time.DateOnly)]; ok {
+		total = before.Total
+	}
+	filled := make([]model.UserGrowthDay, days)
+	var signups int64
+	for i := range filled {
+		date := from.AddDate(0, 0, i).Format(time.DateOnly)
+		if c, ok := byDay[date]; ok {
+			total = c.Total
+			signups += c.Signups
+			filled[i] = c
+			continue
+		}
+		filled[i] = model.UserGrowthDay{Date: date, Total: total}
+	}
+	return filled, signups
+}
+
+// fillDays returns one count for every day of the window, zero for the days counts leaves
+// out, and their total.
+func fillDays(since time.Time, days int, counts []model.DayCount) ([]model.DayCount, int64) {
+	byDay := make(map[string]int64, len(counts))
+	for _, c := range counts {
+		byDay[c.Date] = c.Count
+	}
+	filled := make([]model.DayCount, days)
+	var total int64
+	for i := range filled {
+		date := since.AddDate(0, 0, i).Format(time.DateOnly)
+		filled[i] = model.DayCount{Date: date, Count: byDay[date]}
+		total += byDay[date]
+	}
+	return filled, total
+}
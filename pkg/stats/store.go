@@ -0,0 +1,70 @@
+package stats
+
+import (
+	"context"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+)
+
+// Repository defines the interface for the data operations of the statistics.
+type Repository interface {
+	IncrementCounter(ctx context.Context, name string, at time.Time) error
+	SumCounters(ctx context.Context, since time.Time) (map[string]int64, error)
+	CountUsers(ctx context.Context) (int64, error)
+	CountNewUsersPerDay(ctx context.Context, since time.Time) ([]model.DayCount, error)
+	CountUserGrowth(ctx context.Context, from, to time.Time) ([]model.UserGrowthDay, error)
+	CountActiveOTPs(ctx context.Context) (int64, error)
+}
+
+type statsRepository struct {
+	store StatsStore
+}
+
+func NewRepository(store StatsStore) Repository {
+	return &statsRepository{store: store}
+}
+
+func (r *statsRepository) IncrementCounter(ctx context.Context, name string, at time.Time) error {
+	return r.store.IncrementCounter(ctx, name, at)
+}
+
+func (r *statsRepository) SumCounters(ctx context.Context, since time.Time) (map[string]int64, error) {
+	return r.store.SumCounters(ctx, since)
+}
+
+func (r *statsRepository) CountUsers(ctx context.Context) (int64, error) {
+	return r.store.CountUsers(ctx)
+}
+
+func (r *statsRepository) CountNewUsersPerDay(ctx context.Context, since time.Time) ([]model.DayCount, error) {
+	return r.store.CountNewUsersPerDay(ctx, since)
+}
+
+func (r *statsRepository) CountUserGrowth(ctx context.Context, from, to time.Time) ([]model.UserGrowthDay, error) {
+	return r.store.CountUserGrowth(ctx, from, to)
+}
+
+func (r *statsRepository) CountActiveOTPs(ctx context.Context) (int64, error) {
+	return r.store.CountActiveOTPs(ctx)
+}
+
+// StatsStore is the interface that the database implementation must satisfy.
+type StatsStore interface {
+	// IncrementCounter adds one to the named counter of the day (UTC) of at.
+	IncrementCounter(ctx context.Context, name string, at time.Time) error
+	// SumCounters returns the totals of the counters from the day (UTC) of since on.
+	SumCounters(ctx context.Context, since time.Time) (map[string]int64, error)
+	// CountUsers counts the users that aren't deleted.
+	CountUsers(ctx context.Context) (int64, error)
+	// CountNewUsersPerDay counts the users created since since per day (UTC), oldest first,
+	// including the ones deleted later. Days without new users may be left out.
+	CountNewUsersPerDay(ctx context.Context, since time.Time) ([]model.DayCount, error)
+	// CountUserGrowth counts, per day (UTC) from the day of from to that of to, oldest first,
+	// the users registered that day, including the ones deleted later, and the users there
+	// were at its end. Everything before from is summed up as the day before from, so the
+	// total of the first day is known. Days without registrations or deletions may be left out.
+	CountUserGrowth(ctx context.Context, from, to time.Time) ([]model.UserGrowthDay, error)
+	// CountActiveOTPs counts the OTPs that haven't expired.
+	CountActiveOTPs(ctx context.Context) (int64, error)
+}
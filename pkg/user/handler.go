@@ -1,8 +1,15 @@
 package user
 
 import (
+	"errors"
 	"net/http"
-	"strconv"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
+	"github.com/ebipenman/go-otp-auth-service/internal/i18n"
+	"github.com/ebipenman/go-otp-auth-service/internal/middleware"
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/openapi"
+	"github.com/ebipenman/go-otp-auth-service/pkg/httpx"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -16,82 +23,334 @@ func NewHandler(userService Service) *Handler {
 	return &Handler{userService: userService}
 }
 
-// @Summary Get User by ID
-// @Description Retrieve details of a single user by their ID
-// @Tags User Management
-// @Security BearerAuth
-// @Accept json
-// @Produce json
-// @Param id path string true "User ID"
-// @Success 200 {object} model.UserResponse
-// @Failure 400 {object} map[string]string "error: Invalid user ID"
-// @Failure 404 {object} map[string]string "error: User not found"
-// @Failure 500 {object} map[string]string "error: Internal server error"
-// @Router /users/{id} [get]
+var _ = openapi.Describe((*Handler).GetUserByID, openapi.Operation{
+	Summary:     "Get User by ID",
+	Description: "Retrieve details of a single user by their ID",
+	Tags:        []string{"User Management"},
+	Security:    []string{openapi.BearerAuth},
+	Params: []openapi.Param{
+		{Name: "id", In: openapi.InPath, Description: "User ID"},
+		{Name: "fields", In: openapi.InQuery, Description: "Fields to return, separated by commas, e.g. id,phone_number (default all)"},
+		{Name: "If-None-Match", In: openapi.InHeader, Description: "ETag of the copy the client has"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK: {Type: model.UserResponse{}, Headers: []openapi.Header{
+			{Name: "ETag", Description: "Changes whenever the user does"},
+		}},
+		http.StatusNotModified:         {Description: "The client's copy is current"},
+		http.StatusBadRequest:          {Description: "Invalid user ID or unknown field"},
+		http.StatusNotFound:            {Description: "User not found"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
 func (h *Handler) GetUserByID(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		middleware.Abort(c, apperr.ErrInvalidUserID)
+		return
+	}
+	fields, err := httpx.ParseFields[model.UserResponse](c)
+	if err != nil {
+		middleware.Abort(c, apperr.ErrInvalidFields.WithParams(fieldsParams(err)))
 		return
 	}
 
-	user, err := h.userService.GetUserByID(id)
+	user, err := h.userService.GetUserByID(c.Request.Context(), id)
 	if err != nil {
-		// Check for specific error types for more precise HTTP status codes
-		// For now, a generic 500 or 404 if error message indicates not found
-		if err.Error() == "user not found: not found: user with ID "+id.String() { // Simplified check for demonstration
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		middleware.Abort(c, err)
+		return
+	}
+
+	if httpx.NotModified(c, httpx.ETag(user.UpdatedAt)) {
+		return
+	}
+	c.JSON(http.StatusOK, httpx.Select(user, fields))
+}
+
+var _ = openapi.Describe((*Handler).GetMe, openapi.Operation{
+	Summary: "Get my profile",
+	Description: "Returns the current record of the authenticated user. A user deleted since the token was " +
+		"issued is not found. Clients polling the profile can send the ETag of their copy in If-None-Match " +
+		"and get 304 while it's current.",
+	Tags:     []string{"Users"},
+	Security: []string{openapi.BearerAuth},
+	Params: []openapi.Param{
+		{Name: "fields", In: openapi.InQuery, Description: "Fields to return, separated by commas, e.g. id,phone_number (default all)"},
+		{Name: "If-None-Match", In: openapi.InHeader, Description: "ETag of the copy the client has"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK: {Type: model.UserResponse{}, Headers: []openapi.Header{
+			{Name: "ETag", Description: "Changes whenever the user does"},
+		}},
+		http.StatusNotModified:         {Description: "The client's copy is current"},
+		http.StatusBadRequest:          {Description: "Unknown field"},
+		http.StatusUnauthorized:        {Description: "Authorization header required"},
+		http.StatusNotFound:            {Description: "User not found"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) GetMe(c *gin.Context) {
+	val, _ := c.Get(middleware.ContextKeyUser)
+	claimed, ok := val.(model.User)
+	if !ok {
+		middleware.Abort(c, apperr.ErrAuthRequired)
+		return
+	}
+	fields, err := httpx.ParseFields[model.UserResponse](c)
+	if err != nil {
+		middleware.Abort(c, apperr.ErrInvalidFields.WithParams(fieldsParams(err)))
+		return
+	}
+
+	// The token only tells who the user was when it was issued; the database has the truth.
+	user, err := h.userService.GetUserByID(c.Request.Context(), claimed.ID)
+	if err != nil {
+		middleware.Abort(c, err)
+		return
+	}
+
+	if httpx.NotModified(c, httpx.ETag(user.UpdatedAt)) {
+		return
+	}
+	c.JSON(http.StatusOK, httpx.Select(user, fields))
+}
+
+var _ = openapi.Describe((*Handler).UpdateMe, openapi.Operation{
+	Summary: "Update my profile",
+	Description: "Changes the name and email address of the authenticated user. Fields left out keep their " +
+		"value, an empty string clears them. The phone number can only change by signing in with another one.",
+	Tags:     []string{"Users"},
+	Security: []string{openapi.BearerAuth},
+	Body:     &openapi.Body{Description: "Profile fields to change", Required: true, Type: model.UserProfileUpdateRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Type: model.UserResponse{}},
+		http.StatusBadRequest:          {Description: "Invalid request format"},
+		http.StatusUnauthorized:        {Description: "Authorization header required"},
+		http.StatusNotFound:            {Description: "User not found"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) UpdateMe(c *gin.Context) {
+	val, _ := c.Get(middleware.ContextKeyUser)
+	claimed, ok := val.(model.User)
+	if !ok {
+		middleware.Abort(c, apperr.ErrAuthRequired)
+		return
+	}
+
+	var req model.UserProfileUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithDetails(c, apperr.ErrInvalidRequest.Wrap(err), err.Error())
+		return
+	}
+
+	user, err := h.userService.UpdateProfile(c.Request.Context(), claimed.ID, req)
+	if err != nil {
+		middleware.Abort(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, user)
 }
 
-// @Summary List Users
-// @Description Retrieve a paginated list of users, with optional search
-// @Tags User Management
-// @Security BearerAuth
-// @Accept json
-// @Produce json
-// @Param page query int false "Page number (default 1)" default(1)
-// @Param limit query int false "Number of items per page (default 10)" default(10)
-// @Param search query string false "Search by phone number"
-// @Success 200 {object} map[string]interface{} "data: [], total: int"
-// @Failure 400 {object} map[string]string "error: Invalid query parameters"
-// @Failure 500 {object} map[string]string "error: Internal server error"
-// @Router /users [get]
+var _ = openapi.Describe((*Handler).ListMyPhones, openapi.Operation{
+	Summary: "List my phone numbers",
+	Description: "Lists the phone numbers of the authenticated user, the primary one first. Users can sign in " +
+		"with any of them. Secondary numbers are added through POST /me/phones.",
+	Tags:     []string{"Users"},
+	Security: []string{openapi.BearerAuth},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Type: []model.UserPhone{}},
+		http.StatusUnauthorized:        {Description: "Authorization header required"},
+		http.StatusNotFound:            {Description: "User not found"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) ListMyPhones(c *gin.Context) {
+	val, _ := c.Get(middleware.ContextKeyUser)
+	claimed, ok := val.(model.User)
+	if !ok {
+		middleware.Abort(c, apperr.ErrAuthRequired)
+		return
+	}
+
+	phones, err := h.userService.ListPhoneNumbers(c.Request.Context(), claimed.ID)
+	if err != nil {
+		middleware.Abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, phones)
+}
+
+var _ = openapi.Describe((*Handler).SetPrimaryPhone, openapi.Operation{
+	Summary: "Make one of my phone numbers primary",
+	Description: "Makes a secondary phone number of the authenticated user their primary one, e.g. after losing " +
+		"the SIM of the old one. The old primary number becomes a secondary number under the same ID, " +
+		"which can then be removed.",
+	Tags:     []string{"Users"},
+	Security: []string{openapi.BearerAuth},
+	Params: []openapi.Param{
+		{Name: "id", In: openapi.InPath, Description: "Phone number ID"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Type: []model.UserPhone{}},
+		http.StatusBadRequest:          {Description: "Invalid phone number ID"},
+		http.StatusUnauthorized:        {Description: "Authorization header required"},
+		http.StatusNotFound:            {Description: "Phone number not found"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) SetPrimaryPhone(c *gin.Context) {
+	val, _ := c.Get(middleware.ContextKeyUser)
+	claimed, ok := val.(model.User)
+	if !ok {
+		middleware.Abort(c, apperr.ErrAuthRequired)
+		return
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.Abort(c, apperr.ErrInvalidPhoneID)
+		return
+	}
+
+	phones, err := h.userService.SetPrimaryPhoneNumber(c.Request.Context(), claimed.ID, id)
+	if err != nil {
+		middleware.Abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, phones)
+}
+
+var _ = openapi.Describe((*Handler).RemoveMyPhone, openapi.Operation{
+	Summary: "Remove one of my phone numbers",
+	Description: "Removes a secondary phone number of the authenticated user, who can no longer sign in with it. " +
+		"The primary number can't be removed; make another number primary first.",
+	Tags:     []string{"Users"},
+	Security: []string{openapi.BearerAuth},
+	Params: []openapi.Param{
+		{Name: "id", In: openapi.InPath, Description: "Phone number ID"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusNoContent:           {Description: "Phone number removed"},
+		http.StatusBadRequest:          {Description: "Invalid phone number ID"},
+		http.StatusUnauthorized:        {Description: "Authorization header required"},
+		http.StatusNotFound:            {Description: "Phone number not found"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) RemoveMyPhone(c *gin.Context) {
+	val, _ := c.Get(middleware.ContextKeyUser)
+	claimed, ok := val.(model.User)
+	if !ok {
+		middleware.Abort(c, apperr.ErrAuthRequired)
+		return
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		middleware.Abort(c, apperr.ErrInvalidPhoneID)
+		return
+	}
+
+	if err := h.userService.RemovePhoneNumber(c.Request.Context(), claimed.ID, id); err != nil {
+		middleware.Abort(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+var _ = openapi.Describe((*Handler).GetUsersByIDs, openapi.Operation{
+	Summary: "Get Users by IDs",
+	Description: "Retrieve up to 100 users in one request, e.g. to resolve the subjects of many JWTs. " +
+		"Users are returned in the order of the request; IDs without a user are listed in not_found.",
+	Tags:     []string{"User Management"},
+	Security: []string{openapi.BearerAuth},
+	Body:     &openapi.Body{Description: "User IDs", Required: true, Type: model.UserBatchGetRequest{}},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Type: model.UserBatchGetResponse{}},
+		http.StatusBadRequest:          {Description: "Invalid request"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
+func (h *Handler) GetUsersByIDs(c *gin.Context) {
+	var req model.UserBatchGetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.AbortWithDetails(c, apperr.ErrInvalidRequest.Wrap(err), err.Error())
+		return
+	}
+
+	users, notFound, err := h.userService.GetUsersByIDs(c.Request.Context(), req.IDs)
+	if err != nil {
+		middleware.Abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, model.UserBatchGetResponse{Data: users, NotFound: notFound})
+}
+
+var _ = openapi.Describe((*Handler).ListUsers, openapi.Operation{
+	Summary:     "List Users",
+	Description: "Retrieve a paginated list of users, with optional search",
+	Tags:        []string{"User Management"},
+	Security:    []string{openapi.BearerAuth},
+	Params: []openapi.Param{
+		{Name: "page", In: openapi.InQuery, Description: "Page number (default 1)", Type: "integer", Default: 1},
+		{Name: "per_page", In: openapi.InQuery, Description: "Number of items per page (default 10); limit is accepted too", Type: "integer", Default: 10},
+		{Name: "search", In: openapi.InQuery, Description: "Search by phone number (exact match only when phone numbers are encrypted at rest)"},
+		{Name: "fields", In: openapi.InQuery, Description: "Fields to return, separated by commas, e.g. id,phone_number (default all)"},
+	},
+	Responses: map[int]openapi.Response{
+		http.StatusOK:                  {Type: httpx.Page{Data: []model.UserResponse{}}},
+		http.StatusBadRequest:          {Description: "Invalid query parameters or unknown field"},
+		http.StatusInternalServerError: {Description: "Internal server error"},
+	},
+})
+
 func (h *Handler) ListUsers(c *gin.Context) {
-	pageStr := c.DefaultQuery("page", "1")
-	limitStr := c.DefaultQuery("limit", "10")
 	search := c.Query("search")
 
-	page, err := strconv.Atoi(pageStr)
-	if err != nil || page <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page number"})
+	page, err := httpx.PageNumber(c)
+	if err != nil {
+		middleware.Abort(c, apperr.ErrInvalidPagination)
 		return
 	}
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit per page"})
+	perPage, err := httpx.PerPage(c, 10, 0)
+	if err != nil {
+		middleware.Abort(c, apperr.ErrInvalidPagination)
+		return
+	}
+	fields, err := httpx.ParseFields[model.UserResponse](c)
+	if err != nil {
+		middleware.Abort(c, apperr.ErrInvalidFields.WithParams(fieldsParams(err)))
 		return
 	}
 
-	offset := (page - 1) * limit
+	offset := (page - 1) * perPage
 
-	users, total, err := h.userService.ListUsers(limit, offset, search)
+	users, total, err := h.userService.ListUsers(c.Request.Context(), perPage, offset, search)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		middleware.Abort(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"data":  users,
-		"total": total,
-		"page":  page,
-		"limit": limit,
-	})
+	c.JSON(http.StatusOK, httpx.OffsetPage(c, httpx.SelectAll(users, fields), total, page, perPage))
+}
+
+// fieldsParams names the unknown field of a fields query parameter in the error.
+func fieldsParams(err error) i18n.Params {
+	var unknown *httpx.UnknownFieldError
+	if errors.As(err, &unknown) {
+		return i18n.Params{"field": unknown.Field}
+	}
+	return nil
 }
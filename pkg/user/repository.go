@@ -1,6 +1,9 @@
 package user
 
 import (
+	"context"
+	"time"
+
 	"github.com/ebipenman/go-otp-auth-service/internal/model"
 
 	"github.com/google/uuid"
@@ -8,11 +11,28 @@ import (
 
 // Repository defines the interface for user data operations.
 type Repository interface {
-	CreateUser(user model.User) (model.User, error)
-	GetUserByID(id uuid.UUID) (model.User, error)
-	GetUserByPhoneNumber(phoneNumber string) (model.User, error)
-	ListUsers(limit, offset int, search string) ([]model.User, int, error)
-	// Add UpdateUser, DeleteUser if needed
+	CreateUser(ctx context.Context, user model.User) (model.User, error)
+	GetUserByID(ctx context.Context, id uuid.UUID) (model.User, error)
+	GetUserByPhoneNumber(ctx context.Context, phoneNumber string) (model.User, error)
+	GetUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]model.User, error)
+	ListUsers(ctx context.Context, limit, offset int, search string) ([]model.User, int, error)
+	UpdateUser(ctx context.Context, user model.User) (model.User, error)
+	MarkPhoneVerified(ctx context.Context, id uuid.UUID, at time.Time) error
+	SetPasswordHash(ctx context.Context, id uuid.UUID, hash string) error
+	GetPasswordHash(ctx context.Context, id uuid.UUID) (string, error)
+	DeleteUser(ctx context.Context, id uuid.UUID) error
+	DeleteUsers(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error)
+	SetUsersBanned(ctx context.Context, ids []uuid.UUID, bannedAt *time.Time) ([]uuid.UUID, error)
+	GetDeletedUserByPhoneNumber(ctx context.Context, phoneNumber string, deletedAfter time.Time) (model.User, error)
+	ListDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) ([]model.User, error)
+	RestoreUser(ctx context.Context, id uuid.UUID) error
+	AnonymizeUser(ctx context.Context, id uuid.UUID) (model.User, error)
+	ListPhoneNumbers(ctx context.Context, userID uuid.UUID) ([]model.UserPhone, error)
+	GetPhoneNumber(ctx context.Context, phoneNumber string) (model.UserPhone, error)
+	AddPhoneNumber(ctx context.Context, phone model.UserPhone) (model.UserPhone, error)
+	DeletePhoneNumber(ctx context.Context, userID, id uuid.UUID) error
+	SwapPrimaryPhoneNumber(ctx context.Context, userID, id uuid.UUID) error
+	MovePhoneNumbers(ctx context.Context, fromUserID, toUserID uuid.UUID) error
 }
 
 type userRepository struct {
@@ -23,27 +43,156 @@ func NewRepository(store UserStore) Repository {
 	return &userRepository{store: store}
 }
 
-func (r *userRepository) CreateUser(user model.User) (model.User, error) {
-	return r.store.CreateUser(user)
+func (r *userRepository) CreateUser(ctx context.Context, user model.User) (model.User, error) {
+	return r.store.CreateUser(ctx, user)
+}
+
+func (r *userRepository) GetUserByID(ctx context.Context, id uuid.UUID) (model.User, error) {
+	return r.store.GetUserByID(ctx, id)
+}
+
+func (r *userRepository) GetUserByPhoneNumber(ctx context.Context, phoneNumber string) (model.User, error) {
+	return r.store.GetUserByPhoneNumber(ctx, phoneNumber)
+}
+
+func (r *userRepository) GetUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]model.User, error) {
+	return r.store.GetUsersByIDs(ctx, ids)
+}
+
+func (r *userRepository) ListUsers(ctx context.Context, limit, offset int, search string) ([]model.User, int, error) {
+	return r.store.ListUsers(ctx, limit, offset, search)
+}
+
+func (r *userRepository) UpdateUser(ctx context.Context, user model.User) (model.User, error) {
+	return r.store.UpdateUser(ctx, user)
+}
+
+func (r *userRepository) MarkPhoneVerified(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return r.store.MarkPhoneVerified(ctx, id, at)
+}
+
+func (r *userRepository) SetPasswordHash(ctx context.Context, id uuid.UUID, hash string) error {
+	return r.store.SetPasswordHash(ctx, id, hash)
+}
+
+func (r *userRepository) GetPasswordHash(ctx context.Context, id uuid.UUID) (string, error) {
+	return r.store.GetPasswordHash(ctx, id)
+}
+
+func (r *userRepository) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	return r.store.DeleteUser(ctx, id)
+}
+
+func (r *userRepository) DeleteUsers(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error) {
+	return r.store.DeleteUsers(ctx, ids)
+}
+
+func (r *userRepository) SetUsersBanned(ctx context.Context, ids []uuid.UUID, bannedAt *time.Time) ([]uuid.UUID, error) {
+	return r.store.SetUsersBanned(ctx, ids, bannedAt)
+}
+
+func (r *userRepository) GetDeletedUserByPhoneNumber(ctx context.Context, phoneNumber string, deletedAfter time.Time) (model.User, error) {
+	return r.store.GetDeletedUserByPhoneNumber(ctx, phoneNumber, deletedAfter)
+}
+
+func (r *userRepository) ListDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) ([]model.User, error) {
+	return r.store.ListDeletedUsers(ctx, deletedBefore, limit)
+}
+
+func (r *userRepository) RestoreUser(ctx context.Context, id uuid.UUID) error {
+	return r.store.RestoreUser(ctx, id)
+}
+
+func (r *userRepository) AnonymizeUser(ctx context.Context, id uuid.UUID) (model.User, error) {
+	return r.store.AnonymizeUser(ctx, id)
+}
+
+func (r *userRepository) ListPhoneNumbers(ctx context.Context, userID uuid.UUID) ([]model.UserPhone, error) {
+	return r.store.ListPhoneNumbers(ctx, userID)
+}
+
+func (r *userRepository) GetPhoneNumber(ctx context.Context, phoneNumber string) (model.UserPhone, error) {
+	return r.store.GetPhoneNumber(ctx, phoneNumber)
+}
+
+func (r *userRepository) AddPhoneNumber(ctx context.Context, phone model.UserPhone) (model.UserPhone, error) {
+	return r.store.AddPhoneNumber(ctx, phone)
 }
 
-func (r *userRepository) GetUserByID(id uuid.UUID) (model.User, error) {
-	return r.store.GetUserByID(id)
+func (r *userRepository) DeletePhoneNumber(ctx context.Context, userID, id uuid.UUID) error {
+	return r.store.DeletePhoneNumber(ctx, userID, id)
 }
 
-func (r *userRepository) GetUserByPhoneNumber(phoneNumber string) (model.User, error) {
-	return r.store.GetUserByPhoneNumber(phoneNumber)
+func (r *userRepository) SwapPrimaryPhoneNumber(ctx context.Context, userID, id uuid.UUID) error {
+	return r.store.SwapPrimaryPhoneNumber(ctx, userID, id)
 }
 
-func (r *userRepository) ListUsers(limit, offset int, search string) ([]model.User, int, error) {
-	return r.store.ListUsers(limit, offset, search)
+func (r *userRepository) MovePhoneNumbers(ctx context.Context, fromUserID, toUserID uuid.UUID) error {
+	return r.store.MovePhoneNumbers(ctx, fromUserID, toUserID)
 }
 
 // UserStore is the interface that the database implementation must satisfy.
 // It's defined here for the service layer to depend on an interface from its own package.
 type UserStore interface {
-	CreateUser(user model.User) (model.User, error)
-	GetUserByID(id uuid.UUID) (model.User, error)
-	GetUserByPhoneNumber(phoneNumber string) (model.User, error)
-	ListUsers(limit, offset int, search string) ([]model.User, int, error)
+	CreateUser(ctx context.Context, user model.User) (model.User, error)
+	GetUserByID(ctx context.Context, id uuid.UUID) (model.User, error)
+	GetUserByPhoneNumber(ctx context.Context, phoneNumber string) (model.User, error)
+	// GetUsersByIDs returns the users with the IDs in one query, in no particular order.
+	// Unknown IDs are skipped rather than reported as ErrNotFound.
+	GetUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]model.User, error)
+	ListUsers(ctx context.Context, limit, offset int, search string) ([]model.User, int, error)
+	// UpdateUser saves the profile fields (name and email) of the user and bumps UpdatedAt.
+	// The phone number can't be changed this way.
+	UpdateUser(ctx context.Context, user model.User) (model.User, error)
+	// MarkPhoneVerified records that the user proved they own their phone number at the time.
+	// It returns ErrNotFound for unknown or deleted users.
+	MarkPhoneVerified(ctx context.Context, id uuid.UUID, at time.Time) error
+	// SetPasswordHash sets the encoded password hash of the user. It returns ErrNotFound for
+	// unknown or deleted users.
+	SetPasswordHash(ctx context.Context, id uuid.UUID, hash string) error
+	// GetPasswordHash returns the encoded password hash of the user, empty if they have no
+	// password. It returns ErrNotFound for unknown or deleted users.
+	GetPasswordHash(ctx context.Context, id uuid.UUID) (string, error)
+	// DeleteUser deletes the user, who is no longer returned by the other methods, so their
+	// phone number can register again. It returns ErrNotFound for unknown or deleted users.
+	DeleteUser(ctx context.Context, id uuid.UUID) error
+	// DeleteUsers deletes the users like DeleteUser, all of them or, on error, none, and
+	// returns the IDs of those deleted; unknown or deleted users are skipped.
+	DeleteUsers(ctx context.Context, ids []uuid.UUID) ([]uuid.UUID, error)
+	// SetUsersBanned bans the users as of bannedAt, or lifts their ban if it's nil, all of them
+	// or, on error, none, and returns the IDs of those updated; unknown or deleted users are
+	// skipped. Users banned already keep the time of their ban.
+	SetUsersBanned(ctx context.Context, ids []uuid.UUID, bannedAt *time.Time) ([]uuid.UUID, error)
+	// GetDeletedUserByPhoneNumber returns the user most recently deleted after the given time
+	// whose primary phone number it was, with DeletedAt set, if they can still be restored:
+	// users anonymized or merged into another one can't. It returns ErrNotFound otherwise.
+	GetDeletedUserByPhoneNumber(ctx context.Context, phoneNumber string, deletedAfter time.Time) (model.User, error)
+	// ListDeletedUsers returns up to limit users deleted before the given time that could
+	// still be restored, longest deleted first, with DeletedAt set.
+	ListDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) ([]model.User, error)
+	// RestoreUser undoes DeleteUser; the secondary phone numbers it dropped stay dropped. It
+	// returns ErrNotFound unless the user can be restored, and ErrAlreadyExists if their
+	// phone number belongs to another user now.
+	RestoreUser(ctx context.Context, id uuid.UUID) error
+	// AnonymizeUser irreversibly scrubs the phone number, name and email of the user, deleted
+	// or not, and deletes them if they weren't. The ID is kept, so records referring to the
+	// user stay intact. It returns the user as they were before, or ErrNotFound.
+	AnonymizeUser(ctx context.Context, id uuid.UUID) (model.User, error)
+	// ListPhoneNumbers returns the secondary phone numbers of the user, oldest first.
+	ListPhoneNumbers(ctx context.Context, userID uuid.UUID) ([]model.UserPhone, error)
+	// GetPhoneNumber returns the secondary phone number, or ErrNotFound if no user added it.
+	// Primary numbers are found with GetUserByPhoneNumber.
+	GetPhoneNumber(ctx context.Context, phoneNumber string) (model.UserPhone, error)
+	// AddPhoneNumber attaches a verified secondary phone number to the user and returns it with
+	// its new ID. It returns ErrAlreadyExists if the number is another user's secondary number
+	// already and ErrNotFound for unknown or deleted users. Deleting the user drops the numbers.
+	AddPhoneNumber(ctx context.Context, phone model.UserPhone) (model.UserPhone, error)
+	// DeletePhoneNumber detaches a secondary phone number of the user, or returns ErrNotFound.
+	DeletePhoneNumber(ctx context.Context, userID, id uuid.UUID) error
+	// SwapPrimaryPhoneNumber makes the secondary phone number the user's primary one, and the
+	// primary one a secondary number under the same ID. It returns ErrNotFound if the user has
+	// no such number.
+	SwapPrimaryPhoneNumber(ctx context.Context, userID, id uuid.UUID) error
+	// MovePhoneNumbers gives the secondary phone numbers of one user to another.
+	MovePhoneNumbers(ctx context.Context, fromUserID, toUserID uuid.UUID) error
 }
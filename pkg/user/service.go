@@ -1,19 +1,36 @@
 package user
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/ebipenman/go-otp-auth-service/internal/apperr"
 	"github.com/ebipenman/go-otp-auth-service/internal/database"
 	"github.com/ebipenman/go-otp-auth-service/internal/model"
+	"github.com/ebipenman/go-otp-auth-service/internal/tracing"
 
 	"github.com/google/uuid"
 )
 
 // Service defines the business logic for user management.
 type Service interface {
-	GetUserByID(id uuid.UUID) (model.UserResponse, error)
-	ListUsers(limit, offset int, search string) ([]model.UserResponse, int, error)
+	GetUserByID(ctx context.Context, id uuid.UUID) (model.UserResponse, error)
+	// GetUsersByIDs returns the users in the order of ids, without duplicates, and the IDs no
+	// user was found for.
+	GetUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]model.UserResponse, []uuid.UUID, error)
+	ListUsers(ctx context.Context, limit, offset int, search string) ([]model.UserResponse, int, error)
+	// UpdateProfile applies the fields set in req to the user's profile. Names are trimmed,
+	// email addresses also lowercased.
+	UpdateProfile(ctx context.Context, id uuid.UUID, req model.UserProfileUpdateRequest) (model.UserResponse, error)
+	// ListPhoneNumbers returns the phone numbers of the user, the primary one first.
+	ListPhoneNumbers(ctx context.Context, id uuid.UUID) ([]model.UserPhone, error)
+	// SetPrimaryPhoneNumber makes a secondary phone number of the user their primary one; the
+	// old primary number takes its place. It returns the phone numbers afterwards.
+	SetPrimaryPhoneNumber(ctx context.Context, id, phoneID uuid.UUID) ([]model.UserPhone, error)
+	// RemovePhoneNumber detaches a secondary phone number. The primary number can't be removed.
+	RemovePhoneNumber(ctx context.Context, id, phoneID uuid.UUID) error
 }
 
 type userService struct {
@@ -24,20 +41,59 @@ func NewService(userRepo Repository) Service {
 	return &userService{userRepo: userRepo}
 }
 
-func (s *userService) GetUserByID(id uuid.UUID) (model.UserResponse, error) {
-	user, err := s.userRepo.GetUserByID(id)
+func (s *userService) GetUserByID(ctx context.Context, id uuid.UUID) (model.UserResponse, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.GetUserByID")
+	defer span.End()
+
+	user, err := s.userRepo.GetUserByID(ctx, id)
 	if err != nil {
+		tracing.RecordError(span, err)
 		if errors.Is(err, database.ErrNotFound) {
-			return model.UserResponse{}, fmt.Errorf("user not found: %w", err)
+			return model.UserResponse{}, apperr.ErrUserNotFound.Wrap(err)
 		}
 		return model.UserResponse{}, fmt.Errorf("failed to retrieve user: %w", err)
 	}
 	return user.ToUserResponse(), nil
 }
 
-func (s *userService) ListUsers(limit, offset int, search string) ([]model.UserResponse, int, error) {
-	users, total, err := s.userRepo.ListUsers(limit, offset, search)
+func (s *userService) GetUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]model.UserResponse, []uuid.UUID, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.GetUsersByIDs")
+	defer span.End()
+
+	users, err := s.userRepo.GetUsersByIDs(ctx, ids)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, nil, fmt.Errorf("failed to retrieve users: %w", err)
+	}
+
+	byID := make(map[uuid.UUID]model.User, len(users))
+	for _, u := range users {
+		byID[u.ID] = u
+	}
+	found := []model.UserResponse{}
+	notFound := []uuid.UUID{}
+	seen := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if u, ok := byID[id]; ok {
+			found = append(found, u.ToUserResponse())
+		} else {
+			notFound = append(notFound, id)
+		}
+	}
+	return found, notFound, nil
+}
+
+func (s *userService) ListUsers(ctx context.Context, limit, offset int, search string) ([]model.UserResponse, int, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.ListUsers")
+	defer span.End()
+
+	users, total, err := s.userRepo.ListUsers(ctx, limit, offset, search)
 	if err != nil {
+		tracing.RecordError(span, err)
 		return nil, 0, fmt.Errorf("failed to list users: %w", err)
 	}
 
@@ -47,3 +103,91 @@ func (s *userService) ListUsers(limit, offset int, search string) ([]model.UserR
 	}
 	return userResponses, total, nil
 }
+
+func (s *userService) UpdateProfile(ctx context.Context, id uuid.UUID, req model.UserProfileUpdateRequest) (resp model.UserResponse, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.UpdateProfile")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	user, err := s.userRepo.GetUserByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return model.UserResponse{}, apperr.ErrUserNotFound.Wrap(err)
+		}
+		return model.UserResponse{}, fmt.Errorf("failed to retrieve user: %w", err)
+	}
+	if req.Name != nil {
+		user.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Email != nil {
+		user.Email = strings.ToLower(strings.TrimSpace(*req.Email))
+	}
+
+	user, err = s.userRepo.UpdateUser(ctx, user)
+	if err != nil {
+		return model.UserResponse{}, fmt.Errorf("failed to update user: %w", err)
+	}
+	return user.ToUserResponse(), nil
+}
+
+func (s *userService) ListPhoneNumbers(ctx context.Context, id uuid.UUID) (phones []model.UserPhone, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.ListPhoneNumbers")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	user, err := s.userRepo.GetUserByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, apperr.ErrUserNotFound.Wrap(err)
+		}
+		return nil, fmt.Errorf("failed to retrieve user: %w", err)
+	}
+	secondary, err := s.userRepo.ListPhoneNumbers(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list phone numbers: %w", err)
+	}
+
+	phones = append(phones, model.UserPhone{
+		UserID:      user.ID,
+		PhoneNumber: user.PhoneNumber,
+		Primary:     true,
+		VerifiedAt:  user.PhoneVerifiedAt,
+	})
+	return append(phones, secondary...), nil
+}
+
+func (s *userService) SetPrimaryPhoneNumber(ctx context.Context, id, phoneID uuid.UUID) (phones []model.UserPhone, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.SetPrimaryPhoneNumber")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	if err := s.userRepo.SwapPrimaryPhoneNumber(ctx, id, phoneID); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, apperr.ErrPhoneNotFound.Wrap(err)
+		}
+		return nil, fmt.Errorf("failed to set primary phone number: %w", err)
+	}
+	return s.ListPhoneNumbers(ctx, id)
+}
+
+func (s *userService) RemovePhoneNumber(ctx context.Context, id, phoneID uuid.UUID) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "user.RemovePhoneNumber")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	if err := s.userRepo.DeletePhoneNumber(ctx, id, phoneID); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return apperr.ErrPhoneNotFound.Wrap(err)
+		}
+		return fmt.Errorf("failed to remove phone number: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ebipenman/go-otp-auth-service/internal/model"
+)
+
+const (
+	queueSize   = 1000
+	workerCount = 4
+	baseBackoff = time.Second
+)
+
+// delivery is a single attempt to POST one event to one URL.
+type delivery struct {
+	url     string
+	event   model.Event
+	payload []byte
+	attempt int
+}
+
+// Dispatcher POSTs signed JSON events to the configured webhook URLs.
+// Deliveries happen asynchronously on a small worker pool; failed deliveries are retried
+// with exponential backoff (1s, 2s, 4s, ...) up to maxAttempts.
+type Dispatcher struct {
+	urls        []string
+	secret      []byte
+	maxAttempts int
+	client      *http.Client
+	queue       chan delivery
+	logger      *slog.Logger
+
+	mu      sync.RWMutex // guards closed and sending on queue
+	closed  bool
+	workers sync.WaitGroup
+
+	forgottenMu sync.Mutex
+	forgotten   map[string]time.Time // phone numbers to redact from events up to the given time
+}
+
+// NewDispatcher creates a Dispatcher and starts its workers.
+// Without URLs the dispatcher simply drops every event.
+func NewDispatcher(urls []string, secret string, maxAttempts int, logger *slog.Logger) *Dispatcher {
+	d := &Dispatcher{
+		urls:        urls,
+		secret:      []byte(secret),
+		maxAttempts: maxAttempts,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan delivery, queueSize),
+		logger:      logger.With("component", "webhook"),
+		forgotten:   make(map[string]time.Time),
+	}
+
+	d.workers.Add(workerCount)
+	for i := 0; i < workerCount; i++ {
+		go d.worker()
+	}
+
+	return d
+}
+
+// Publish queues the event for delivery to every configured URL. It never blocks the caller.
+func (d *Dispatcher) Publish(event model.Event) {
+	if len(d.urls) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		d.logger.Error("Failed to marshal webhook event", "event_type", event.Type, "error", err)
+		return
+	}
+
+	for _, url := range d.urls {
+		d.enqueue(delivery{url: url, event: event, payload: payload, attempt: 1})
+	}
+}
+
+// Forget redacts the phone number from the events published so far that are still waiting
+// for delivery or a retry, e.g. because its owner was anonymized.
+func (d *Dispatcher) Forget(phoneNumber string) {
+	d.forgottenMu.Lock()
+	defer d.forgottenMu.Unlock()
+
+	// Once the last retry of the events published so far is due, there is nothing left to redact.
+	now := time.Now()
+	retention := baseBackoff << d.maxAttempts
+	for number, forgottenAt := range d.forgotten {
+		if now.Sub(forgottenAt) > retention {
+			delete(d.forgotten, number)
+		}
+	}
+	d.forgotten[phoneNumber] = now
+}
+
+// redact removes forgotten phone numbers from the event of the delivery.
+func (d *Dispatcher) redact(del delivery) delivery {
+	phoneNumber, ok := del.event.Data["phone_number"].(string)
+	if !ok {
+		return del
+	}
+	d.forgottenMu.Lock()
+	forgottenAt, forgotten := d.forgotten[phoneNumber]
+	d.forgottenMu.Unlock()
+	// Events published after the number was forgotten may be about a new owner of the number.
+	if !forgotten || del.event.CreatedAt.After(forgottenAt) {
+		return del
+	}
+
+	data := make(map[string]interface{}, len(del.event.Data))
+	for key, value := range del.event.Data {
+		data[key] = value
+	}
+	delete(data, "phone_number")
+	del.event.Data = data
+	payload, err := json.Marshal(del.event)
+	if err != nil {
+		d.logger.Error("Failed to marshal webhook event", "event_type", del.event.Type, "error", err)
+		return del
+	}
+	del.payload = payload
+	return del
+}
+
+// Close stops accepting events and waits until the queued deliveries have been attempted,
+// or until ctx is done. Retries that are still waiting for their backoff are dropped.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("webhook deliveries still pending: %w", ctx.Err())
+	}
+}
+
+func (d *Dispatcher) enqueue(del delivery) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		d.logger.Warn("Webhook dispatcher is closed, dropping event", "event_type", del.event.Type, "event_id", del.event.ID, "url", del.url, "attempt", del.attempt)
+		return
+	}
+
+	select {
+	case d.queue <- del:
+	default:
+		d.logger.Error("Webhook queue is full, dropping event", "event_type", del.event.Type, "event_id", del.event.ID, "url", del.url)
+	}
+}
+
+func (d *Dispatcher) worker() {
+	defer d.workers.Done()
+
+	for del := range d.queue {
+		del = d.redact(del)
+		err := d.deliver(del)
+		if err == nil {
+			continue
+		}
+
+		if del.attempt >= d.maxAttempts {
+			d.logger.Error("Giving up on webhook event",
+				"event_type", del.event.Type, "event_id", del.event.ID, "url", del.url, "attempts", del.attempt, "error", err)
+			continue
+		}
+
+		backoff := baseBackoff << (del.attempt - 1)
+		d.logger.Warn("Webhook delivery failed, retrying",
+			"event_type", del.event.Type, "event_id", del.event.ID, "url", del.url, "attempt", del.attempt, "backoff", backoff, "error", err)
+		del.attempt++
+		time.AfterFunc(backoff, func() { d.enqueue(del) })
+	}
+}
+
+// deliver POSTs the event once. Any non-2xx response counts as a failure.
+func (d *Dispatcher) deliver(del delivery) error {
+	req, err := http.NewRequest(http.MethodPost, del.url, bytes.NewReader(del.payload))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", del.event.ID.String())
+	req.Header.Set("X-Webhook-Event", del.event.Type)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+Sign(d.secret, timestamp, del.payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign computes the hex encoded HMAC-SHA256 of "<timestamp>.<payload>".
+// Receivers recompute it with the shared secret to verify the event came from this service
+// and check the timestamp to reject replays.
+func Sign(secret []byte, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}